@@ -0,0 +1,183 @@
+// Command bench benchmarks the orchestration pipeline in-process, with the mock LLM and the
+// in-memory database, so the numbers reflect our code rather than a provider or MongoDB.
+//
+// Each case runs ProcessMessage or ProcessMessageStream on a flight or a general question
+// through testing.Benchmark and reports wall time, allocations and events per request, then
+// the average duration of each pipeline stage (Telemetry.StagesMs):
+//
+//	go run ./cmd/bench -latency 0 -benchtime 2s
+//	go run ./cmd/bench -latency 50ms -run stream -cpuprofile cpu.out
+//
+// With -latency 0 the LLM answers instantly and the figures are the pipeline's own overhead;
+// a realistic latency shows how the pipeline's concurrency hides it: the workers stage takes
+// one call's latency, not two, the search hides the work done while it runs, and a request's
+// critical path is intent + search + workers + aggregation.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/orchestrator"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// benchCase is one benchmarked request.
+type benchCase struct {
+	name    string
+	message string
+	stream  bool
+}
+
+var cases = []benchCase{
+	{"flight/buffered", "Show me flights from Madrid to Paris", false},
+	{"flight/stream", "Show me flights from Madrid to Paris", true},
+	{"general/buffered", "What is the capital of France?", false},
+	{"general/stream", "What is the capital of France?", true},
+}
+
+// stageOrder is the order stages are printed in, which is the order they run in (llm1 and
+// llm2 run concurrently, within workers).
+var stageOrder = []string{"intent", "search", "llm1", "llm2", "workers", "aggregation"}
+
+// stageTotals sums the stage durations reported by the requests of one case.
+type stageTotals struct {
+	mu       sync.Mutex
+	requests int
+	ms       map[string]int64
+}
+
+func (t *stageTotals) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests, t.ms = 0, make(map[string]int64)
+}
+
+// add is the orchestrator's telemetry hook.
+func (t *stageTotals) add(telemetry orchestrator.Telemetry, _ string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests++
+	for stage, ms := range telemetry.StagesMs {
+		t.ms[stage] += ms
+	}
+}
+
+// String formats the average of each stage, and of the critical path, in milliseconds.
+func (t *stageTotals) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.requests == 0 {
+		return ""
+	}
+	var b strings.Builder
+	var path float64
+	for _, stage := range stageOrder {
+		ms, ok := t.ms[stage]
+		if !ok {
+			continue
+		}
+		avg := float64(ms) / float64(t.requests)
+		fmt.Fprintf(&b, " %s=%.1f", stage, avg)
+		if stage != "llm1" && stage != "llm2" {
+			path += avg
+		}
+	}
+	return fmt.Sprintf("  stages ms:%s  critical path=%.1f", b.String(), path)
+}
+
+func main() {
+	testing.Init() // Registers the test.* flags testing.Benchmark reads, such as test.benchtime.
+	latency := flag.Duration("latency", 0, "artificial latency of each mock LLM call (and of each streamed answer)")
+	benchtime := flag.String("benchtime", "1s", "run each case for this long, or Nx for N iterations")
+	run := flag.String("run", "", "only run cases whose name contains this")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile of the runs to this file")
+	flag.Parse()
+	if err := flag.Set("test.benchtime", *benchtime); err != nil {
+		log.Fatalf("Invalid -benchtime: %v", err)
+	}
+	// The pipeline logs every request; at benchmark rates that would measure the logger.
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var totals stageTotals
+	orch, err := newOrchestrator(*latency, totals.add)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatal(err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	fmt.Printf("mock LLM latency %v, benchtime %s\n", *latency, *benchtime)
+	for _, c := range cases {
+		if !strings.Contains(c.name, *run) {
+			continue
+		}
+		var events int
+		result := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			events = 0
+			totals.reset() // testing.Benchmark calls this with growing b.N; keep the last run.
+			for range b.N {
+				events += processOnce(orch, c)
+			}
+		})
+		fmt.Printf("%-18s %s %s %6.1f events/op\n", c.name, result, result.MemString(), float64(events)/float64(result.N))
+		fmt.Println(totals.String())
+	}
+}
+
+// newOrchestrator builds the pipeline on mock LLMs and a seeded in-memory database. hook
+// receives every request's telemetry.
+func newOrchestrator(latency time.Duration, hook orchestrator.TelemetryHook) (*orchestrator.Orchestrator, error) {
+	store := db.NewMemoryClient()
+	if err := store.SeedFlights(context.Background()); err != nil {
+		return nil, fmt.Errorf("seed flights: %w", err)
+	}
+	llm := func() llmclient.LLMClient { return llmclient.NewMockClient(latency) }
+	orch := orchestrator.NewOrchestrator(llm(), llm(), llm(), store)
+	orch.HideTelemetry() // Hooks still get it.
+	orch.AddTelemetryHook(hook)
+	return orch, nil
+}
+
+// processOnce runs one request to completion, draining its events like a client would,
+// and returns how many events it produced.
+func processOnce(orch *orchestrator.Orchestrator, c benchCase) int {
+	eventChan := make(chan sse.Event)
+	count := make(chan int)
+	go func() {
+		n := 0
+		for range eventChan {
+			n++
+		}
+		count <- n
+	}()
+	if c.stream {
+		orch.ProcessMessageStream(context.Background(), c.message, orchestrator.Options{}, eventChan)
+	} else {
+		orch.ProcessMessage(context.Background(), c.message, orchestrator.Options{}, eventChan)
+	}
+	close(eventChan)
+	return <-count
+}
@@ -0,0 +1,172 @@
+// Command chat is an interactive terminal client for the chat server.
+//
+// It sends each line typed at the prompt to the server (see package chatclient), follows the
+// answer's events, and prints Status lines dimmed, the answer as it streams in, and flight
+// results as a table. Every turn uses the same session ID. With --once it asks a single question
+// and exits, which suits scripts:
+//
+//	go run ./cmd/chat --once "flights from Madrid to Paris under 150"
+//
+// Exit codes: 0 on success, 1 if the server answered with an Error event or an error outcome,
+// 2 for usage or connection problems.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/Cris245/go-llm-chat/pkg/chatclient"
+)
+
+// maxReconnects bounds how often one turn reconnects after the connection drops mid-stream.
+const maxReconnects = 3
+
+// errAnswerFailed marks a turn the server reported as failed (Error event or error outcome).
+var errAnswerFailed = errors.New("the server reported an error")
+
+// client holds the settings shared by every turn.
+type client struct {
+	server  string
+	session string
+	lang    string
+	out     *printer
+	api     *chatclient.Client
+}
+
+func main() {
+	server := flag.String("server", "http://localhost:8080", "server base URL")
+	apiKey := flag.String("api-key", "", "API key sent as a bearer token (default $CHAT_API_KEY)")
+	session := flag.String("session", "", "session ID to continue (default: a new random one)")
+	lang := flag.String("lang", "", `answer language, "en" or "es" (default: detect)`)
+	once := flag.String("once", "", "ask this single question and exit")
+	flag.Parse()
+
+	if *apiKey == "" {
+		*apiKey = os.Getenv("CHAT_API_KEY")
+	}
+	c := &client{
+		server:  strings.TrimRight(*server, "/"),
+		session: *session,
+		lang:    *lang,
+		out:     newPrinter(os.Stdout, os.Stderr),
+		api:     chatclient.New(*server, chatclient.WithAPIKey(*apiKey), chatclient.WithMaxReconnects(maxReconnects)),
+	}
+	if c.session == "" {
+		c.session = newSessionID()
+	}
+
+	ctx := context.Background()
+	if *once != "" {
+		os.Exit(exitCode(c.ask(ctx, *once)))
+	}
+	os.Exit(c.repl(ctx))
+}
+
+// repl reads questions from stdin until EOF or "exit" and returns the exit code.
+// Ctrl-C during an answer cancels it and returns to the prompt; at the prompt it quits.
+func (c *client) repl(ctx context.Context) int {
+	fmt.Fprintf(os.Stderr, "Connected to %s (session %s). Type a question, or \"exit\" to quit.\n", c.server, c.session)
+	lines := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprint(os.Stderr, "> ")
+		if !lines.Scan() {
+			fmt.Fprintln(os.Stderr)
+			return 0
+		}
+		question := strings.TrimSpace(lines.Text())
+		switch question {
+		case "":
+			continue
+		case "exit", "quit":
+			return 0
+		}
+		err := c.ask(ctx, question)
+		switch {
+		case errors.Is(err, context.Canceled):
+			c.out.status("Cancelled.")
+		case err != nil && !errors.Is(err, errAnswerFailed):
+			c.out.errorf("%v", err)
+		}
+	}
+}
+
+// ask sends one question and prints the answer as its events arrive. If the connection drops
+// before Done, the client resumes the stream with Last-Event-ID so no events are lost or
+// repeated.
+func (c *client) ask(ctx context.Context, question string) error {
+	// While the answer streams, Ctrl-C cancels the request instead of killing the process.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	events, err := c.api.Chat(ctx, chatclient.Request{Message: question, SessionID: c.session, Language: c.lang})
+	if err != nil {
+		return err
+	}
+	failed := false
+	for event := range events {
+		switch e := event.(type) {
+		case chatclient.Status:
+			c.out.status(e.Message)
+		case chatclient.MessageChunk:
+			c.out.chunk(e.Text, e.Final)
+		case chatclient.FlightResults:
+			c.out.flights(e.Flights)
+		case chatclient.Routes:
+			c.out.routes(e.Routes)
+		case chatclient.Enrichment:
+			c.out.enrichment(e.Kind, e.Summary)
+		case chatclient.Reconnect:
+			c.out.status("Server: " + e.Reason)
+		case chatclient.Reconnecting:
+			c.out.status(fmt.Sprintf("Connection lost; reconnecting in %s...", e.Wait))
+		case chatclient.Error:
+			if e.Code == chatclient.CodeConnectionLost {
+				c.out.endAnswer()
+				return errors.New(e.Message)
+			}
+			c.out.errorf("%s (%s)", e.Message, e.Code)
+			failed = true
+		case chatclient.Done:
+			c.out.endAnswer()
+			if e.Outcome != chatclient.OutcomeOK {
+				if e.Error != "" {
+					c.out.errorf("Request failed: %s", e.Error)
+				}
+				failed = true
+			}
+			if failed {
+				return errAnswerFailed
+			}
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// exitCode maps the result of a --once question onto the process exit code.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errAnswerFailed):
+		return 1
+	default:
+		fmt.Fprintln(os.Stderr, "chat:", err)
+		return 2
+	}
+}
+
+// newSessionID returns a random session ID in the format the server accepts.
+func newSessionID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return "cli-" + hex.EncodeToString(buf)
+}
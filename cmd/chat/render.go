@@ -0,0 +1,165 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/pkg/chatclient"
+)
+
+// ANSI styles, used only when writing to a terminal.
+const (
+	styleDim   = "\x1b[2m"
+	styleRed   = "\x1b[31m"
+	styleReset = "\x1b[0m"
+)
+
+// printer renders events: the answer and tables go to out, progress and errors to errOut,
+// so piping the output of --once captures just the answer.
+type printer struct {
+	out, errOut io.Writer
+	color       bool
+	midLine     bool // The answer has been written without a trailing newline yet
+}
+
+func newPrinter(out, errOut *os.File) *printer {
+	return &printer{out: out, errOut: errOut, color: isTerminal(out) && os.Getenv("NO_COLOR") == ""}
+}
+
+// isTerminal reports whether f is a character device rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *printer) style(style, text string) string {
+	if !p.color {
+		return text
+	}
+	return style + text + styleReset
+}
+
+// status prints a progress line, dimmed.
+func (p *printer) status(msg string) {
+	p.breakLine()
+	fmt.Fprintln(p.errOut, p.style(styleDim, "· "+msg))
+}
+
+// errorf prints an error line in red.
+func (p *printer) errorf(format string, args ...any) {
+	p.breakLine()
+	fmt.Fprintln(p.errOut, p.style(styleRed, "error: "+fmt.Sprintf(format, args...)))
+}
+
+// chunk prints answer text inline as it streams in.
+func (p *printer) chunk(text string, final bool) {
+	fmt.Fprint(p.out, text)
+	p.midLine = !strings.HasSuffix(text, "\n")
+	if final {
+		p.endAnswer()
+	}
+}
+
+// endAnswer finishes the answer's last line.
+func (p *printer) endAnswer() {
+	p.breakLine()
+}
+
+// breakLine ends a partially written answer line so other output starts on its own line.
+func (p *printer) breakLine() {
+	if p.midLine {
+		fmt.Fprintln(p.out)
+		p.midLine = false
+	}
+}
+
+// enrichment prints facts that go with the answer, such as the weather at the destination.
+func (p *printer) enrichment(kind, summary string) {
+	p.breakLine()
+	fmt.Fprintln(p.out, kind+": "+summary)
+}
+
+// flights prints the flights as an ASCII table.
+func (p *printer) flights(flights []chatclient.Flight) {
+	p.breakLine()
+	if len(flights) == 0 {
+		return
+	}
+	header := []string{"Flight", "From", "To", "Departure", "Arrival", "Price", "Seats"}
+	rows := make([][]string, len(flights))
+	for i, f := range flights {
+		rows[i] = []string{
+			f.FlightNumber, place(f.Origin, f.OriginAirport), place(f.Destination, f.DestinationAirport),
+			formatTime(cmp.Or(f.DepartureLocal, f.DepartureTime)), formatTime(cmp.Or(f.ArrivalLocal, f.ArrivalTime)),
+			strconv.FormatFloat(f.Price, 'f', 2, 64), strconv.Itoa(f.AvailableSeats),
+		}
+	}
+	writeTable(p.out, header, rows)
+}
+
+// routes prints the routes as an ASCII table.
+func (p *printer) routes(routes []chatclient.Route) {
+	p.breakLine()
+	if len(routes) == 0 {
+		return
+	}
+	rows := make([][]string, len(routes))
+	for i, r := range routes {
+		rows[i] = []string{r.Origin, r.Destination}
+	}
+	writeTable(p.out, []string{"From", "To"}, rows)
+}
+
+// place names a city with its airport, if the flight says which: "New York (JFK)".
+func place(city, airport string) string {
+	if airport == "" {
+		return city
+	}
+	return city + " (" + airport + ")"
+}
+
+// formatTime shortens RFC 3339 times to "2006-01-02 15:04 -07:00", keeping their offset so
+// local times read as such; anything else is shown as is.
+func formatTime(raw string) string {
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return raw
+	}
+	return t.Format("2006-01-02 15:04 -07:00")
+}
+
+// writeTable draws rows under header with +---+ borders, sizing each column to its widest cell.
+func writeTable(w io.Writer, header []string, rows [][]string) {
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len([]rune(cell)))
+		}
+	}
+	var b strings.Builder
+	rule := func() {
+		for _, width := range widths {
+			b.WriteString("+" + strings.Repeat("-", width+2))
+		}
+		b.WriteString("+\n")
+	}
+	line := func(row []string) {
+		for i, cell := range row {
+			b.WriteString("| " + cell + strings.Repeat(" ", widths[i]-len([]rune(cell))) + " ")
+		}
+		b.WriteString("|\n")
+	}
+	rule()
+	line(header)
+	rule()
+	for _, row := range rows {
+		line(row)
+	}
+	rule()
+	io.WriteString(w, b.String())
+}
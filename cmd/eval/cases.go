@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/orchestrator"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// telemetryWait bounds the wait for a case's telemetry, which the grounding check delays until
+// after the Done event.
+const telemetryWait = 10 * time.Second
+
+// evalCase is one question of the dataset, a line of the -cases file.
+type evalCase struct {
+	ID       string   `json:"id"`
+	Message  string   `json:"message"`
+	Language string   `json:"language"` // "en" or "es"; empty detects it from the message
+	Intent   string   `json:"intent"`   // The intent it must be detected as; empty skips the check
+	Flights  []string `json:"flights"`  // Flight numbers the search must return and the answer cite; absent skips the checks, [] expects none
+	Rubric   string   `json:"rubric"`   // What a good answer does, for the people reading the report
+}
+
+// caseLanguages maps the language codes cases may give to the orchestrator's languages.
+var caseLanguages = map[string]string{
+	"en": orchestrator.LanguageEnglish,
+	"es": orchestrator.LanguageSpanish,
+}
+
+// loadCases reads the cases of path whose ID contains filter.
+func loadCases(path, filter string) ([]evalCase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open cases: %w", err)
+	}
+	defer f.Close()
+	var cases []evalCase
+	ids := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "//") {
+			continue
+		}
+		var c evalCase
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.DisallowUnknownFields() // A misspelt expectation would silently check nothing.
+		if err := dec.Decode(&c); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		switch {
+		case c.ID == "" || c.Message == "":
+			return nil, fmt.Errorf("%s:%d: id and message are required", path, line)
+		case ids[c.ID]:
+			return nil, fmt.Errorf("%s:%d: duplicate id %q", path, line, c.ID)
+		case c.Language != "" && caseLanguages[c.Language] == "":
+			return nil, fmt.Errorf("%s:%d: language must be \"en\" or \"es\"", path, line)
+		}
+		ids[c.ID] = true
+		if strings.Contains(c.ID, filter) {
+			cases = append(cases, c)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read cases: %w", err)
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("%s: no cases to run", path)
+	}
+	return cases, nil
+}
+
+// caseResult is how one case was answered, and which checks it failed.
+type caseResult struct {
+	ID       string   `json:"id"`
+	Message  string   `json:"message"`
+	Rubric   string   `json:"rubric,omitempty"`
+	Pass     bool     `json:"pass"`
+	Failures []string `json:"failures,omitempty"`
+	Skipped  []string `json:"skipped,omitempty"` // Checks the mode can't make
+
+	Outcome   string        `json:"outcome"`
+	Intent    string        `json:"intent"`
+	Answer    string        `json:"answer"`
+	Flights   []string      `json:"flights"` // Returned by the search
+	Cited     []string      `json:"cited"`   // Flight numbers the answer states
+	Grounding *db.Grounding `json:"grounding,omitempty"`
+
+	LatencyMs        int64   `json:"latency_ms"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// runner answers cases one at a time, so their latencies don't disturb each other.
+type runner struct {
+	orch    *orchestrator.Orchestrator
+	mock    bool
+	timeout time.Duration
+
+	mu        sync.Mutex
+	telemetry map[string]chan orchestrator.Telemetry // By request ID, for the cases being run
+}
+
+func newRunner(env *environment, timeout time.Duration) (*runner, error) {
+	orch, err := env.newOrchestrator()
+	if err != nil {
+		return nil, err
+	}
+	r := &runner{orch: orch, mock: env.mock, timeout: timeout, telemetry: make(map[string]chan orchestrator.Telemetry)}
+	orch.HideTelemetry() // The hook gets it with the grounding report.
+	orch.AddTelemetryHook(func(t orchestrator.Telemetry, _ string) {
+		r.mu.Lock()
+		ch := r.telemetry[t.RequestID]
+		r.mu.Unlock()
+		if ch != nil {
+			ch <- t
+		}
+	})
+	return r, nil
+}
+
+// run answers c and checks the answer.
+func (r *runner) run(ctx context.Context, c evalCase) caseResult {
+	requestID := logging.NewRequestID()
+	ctx = logging.WithRequestID(ctx, requestID)
+	ctx, usage := withUsage(ctx)
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	telemetry := make(chan orchestrator.Telemetry, 1)
+	r.mu.Lock()
+	r.telemetry[requestID] = telemetry
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.telemetry, requestID)
+		r.mu.Unlock()
+	}()
+
+	result := caseResult{ID: c.ID, Message: c.Message, Rubric: c.Rubric, Flights: []string{}}
+	var answer strings.Builder
+	var errs []string
+	events := make(chan sse.Event)
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for event := range events {
+			switch event.Type {
+			case sse.TypeMessage:
+				answer.WriteString(event.Data)
+			case sse.TypeFlightResults:
+				flights, _ := event.Payload.([]db.Flight)
+				for _, f := range flights {
+					result.Flights = append(result.Flights, f.FlightNumber)
+				}
+			case sse.TypeError:
+				errs = append(errs, event.Data)
+			case sse.TypeDone:
+				done, _ := event.Payload.(sse.DonePayload)
+				result.Outcome = done.Outcome
+				if done.Error != "" {
+					errs = append(errs, done.Error)
+				}
+			}
+		}
+	}()
+	start := time.Now()
+	r.orch.ProcessMessage(ctx, c.Message, orchestrator.Options{Language: caseLanguages[c.Language]}, events)
+	close(events)
+	<-collected
+	result.LatencyMs = time.Since(start).Milliseconds()
+
+	select {
+	case t := <-telemetry:
+		result.Intent, result.Grounding = t.Intent, t.Grounding
+	case <-time.After(telemetryWait):
+	}
+	result.Answer = answer.String()
+	result.Cited = orchestrator.CitedFlightNumbers(result.Answer)
+	if result.Cited == nil {
+		result.Cited = []string{}
+	}
+	for model, u := range usage.byModel() {
+		result.PromptTokens += u.PromptTokens
+		result.CompletionTokens += u.CompletionTokens
+		cost, _ := llmclient.EstimateCost(llmclient.DefaultPrices, model, u)
+		result.CostUSD += cost
+	}
+
+	r.check(&result, c, errs)
+	return result
+}
+
+// check records the checks result fails against the expectations of c.
+func (r *runner) check(result *caseResult, c evalCase, errs []string) {
+	fail := func(format string, args ...any) {
+		result.Failures = append(result.Failures, fmt.Sprintf(format, args...))
+	}
+	if result.Outcome != sse.OutcomeOK {
+		fail("outcome %q: %s", result.Outcome, strings.Join(errs, "; "))
+	}
+	if c.Intent != "" && result.Intent != c.Intent {
+		fail("intent %q, want %q", result.Intent, c.Intent)
+	}
+	if c.Flights != nil {
+		for _, number := range c.Flights {
+			if !slices.Contains(result.Flights, number) {
+				fail("search did not return %s", number)
+			}
+		}
+		for _, number := range result.Flights {
+			if !slices.Contains(c.Flights, number) {
+				fail("search returned unexpected %s", number)
+			}
+		}
+		if r.mock {
+			result.Skipped = append(result.Skipped, "cited flights")
+		} else {
+			for _, number := range c.Flights {
+				if !slices.Contains(result.Cited, number) {
+					fail("answer does not cite %s", number)
+				}
+			}
+		}
+	}
+	if result.Grounding != nil {
+		for _, m := range result.Grounding.Mismatches {
+			fail("answer states %s %q, which no flight record has", strings.ReplaceAll(m.Kind, "_", " "), m.Claim)
+		}
+	}
+	result.Pass = len(result.Failures) == 0
+}
+
+// usageSum adds up the LLM usage of the calls made with its context, by model.
+type usageSum struct {
+	mu    sync.Mutex
+	usage map[string]llmclient.Usage
+}
+
+func (s *usageSum) add(model string, u llmclient.Usage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := s.usage[model]
+	sum.PromptTokens += u.PromptTokens
+	sum.CompletionTokens += u.CompletionTokens
+	sum.TotalTokens += u.TotalTokens
+	s.usage[model] = sum
+}
+
+func (s *usageSum) byModel() map[string]llmclient.Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.usage)
+}
+
+// total is the usage of every model together.
+func (s *usageSum) total() llmclient.Usage {
+	var total llmclient.Usage
+	for _, u := range s.byModel() {
+		total.PromptTokens += u.PromptTokens
+		total.CompletionTokens += u.CompletionTokens
+		total.TotalTokens += u.TotalTokens
+	}
+	return total
+}
+
+type usageKey struct{}
+
+// withUsage returns a context whose LLM calls are counted in the returned sum, as well as in
+// the sums of the contexts it derives from.
+func withUsage(ctx context.Context) (context.Context, *usageSum) {
+	s := &usageSum{usage: make(map[string]llmclient.Usage)}
+	outer, _ := ctx.Value(usageKey{}).([]*usageSum)
+	return context.WithValue(ctx, usageKey{}, append(slices.Clip(outer), s)), s
+}
+
+// countUsage is the LLM clients' usage hook: it adds usage to the sums of the call's context.
+func countUsage(ctx context.Context, model string, usage llmclient.Usage) {
+	sums, _ := ctx.Value(usageKey{}).([]*usageSum)
+	for _, s := range sums {
+		s.add(model, usage)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+const (
+	datasetPath = "testdata/cases.jsonl"
+	caseTimeout = 10 * time.Second
+)
+
+// newMockRunner returns a runner answering with the mock LLMs.
+func newMockRunner(t *testing.T) *runner {
+	t.Helper()
+	env, err := newEnvironment(modeMock, "", "", "", countUsage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRunner(env, caseTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// runAll answers cases with r and returns the report.
+func runAll(r *runner, mode string, cases []evalCase) report {
+	rep := report{Mode: mode}
+	for _, c := range cases {
+		rep.Cases = append(rep.Cases, r.run(context.Background(), c))
+	}
+	rep.summarize()
+	return rep
+}
+
+func TestLoadCases(t *testing.T) {
+	cases, err := loadCases(datasetPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) < 10 {
+		t.Errorf("the dataset has %d cases", len(cases))
+	}
+	cases, err = loadCases(datasetPath, "compare/")
+	if err != nil || len(cases) != 2 || cases[0].Flights == nil {
+		t.Errorf("filtered: %+v, %v", cases, err)
+	}
+	if cases, err := loadCases(datasetPath, "flight/no-results"); err != nil || cases[0].Flights == nil || len(cases[0].Flights) != 0 {
+		t.Errorf("flights: [] must expect none, not skip the check: %+v, %v", cases, err)
+	}
+
+	for name, content := range map[string]string{
+		"unknown field":  `{"id":"a","message":"Hi","flight":["FL101"]}`,
+		"missing id":     `{"message":"Hi"}`,
+		"duplicate id":   `{"id":"a","message":"Hi"}` + "\n" + `{"id":"a","message":"Hello"}`,
+		"bad language":   `{"id":"a","message":"Hi","language":"fr"}`,
+		"malformed":      `{"id":"a",`,
+		"only comments":  "// No cases yet\n\n",
+		"nothing to run": `{"id":"a","message":"Hi"}`,
+	} {
+		path := filepath.Join(t.TempDir(), "cases.jsonl")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		filter := ""
+		if name == "nothing to run" {
+			filter = "b"
+		}
+		if _, err := loadCases(path, filter); err == nil {
+			t.Errorf("%s: loaded", name)
+		} else if !strings.Contains(err.Error(), path) {
+			t.Errorf("%s: %v doesn't name the file", name, err)
+		}
+	}
+}
+
+func TestRunDataset(t *testing.T) {
+	cases, err := loadCases(datasetPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := runAll(newMockRunner(t), modeMock, cases)
+	for _, c := range rep.Cases {
+		if !c.Pass {
+			t.Errorf("%s failed: %v", c.ID, c.Failures)
+		}
+		if c.Outcome != sse.OutcomeOK || c.Intent == "" || c.Answer == "" {
+			t.Errorf("%s: outcome %q, intent %q, answer %q", c.ID, c.Outcome, c.Intent, c.Answer)
+		}
+	}
+	if rep.Summary.Passed != len(cases) || rep.Summary.PromptTokens == 0 || rep.Summary.CostUSD <= 0 {
+		t.Errorf("summary %+v", rep.Summary)
+	}
+	// The mock's canned answers cite nothing, so that check is skipped rather than failed.
+	if c := rep.Cases[0]; !slices.Equal(c.Skipped, []string{"cited flights"}) {
+		t.Errorf("%s skipped %v", c.ID, c.Skipped)
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	r := newMockRunner(t)
+	result := r.run(context.Background(), evalCase{
+		ID:      "wrong",
+		Message: "Show me flights from Madrid to Paris",
+		Intent:  "general",
+		Flights: []string{"FL101", "FL999"},
+	})
+	want := []string{
+		`intent "flight", want "general"`,
+		"search did not return FL999",
+		"search returned unexpected FL102",
+		"search returned unexpected FL103",
+		"search returned unexpected FL104",
+	}
+	if result.Pass || !slices.Equal(result.Failures, want) {
+		t.Errorf("failures %q, want %q", result.Failures, want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	live := &runner{}
+	result := caseResult{
+		Outcome: sse.OutcomeOK,
+		Intent:  "flight",
+		Flights: []string{"FL101", "FL103"},
+		Cited:   []string{"FL101"},
+		Grounding: &db.Grounding{Mismatches: []db.GroundingMismatch{
+			{Kind: "flight_number", Claim: "FL555"},
+		}},
+	}
+	live.check(&result, evalCase{Intent: "flight", Flights: []string{"FL101", "FL103"}}, nil)
+	want := []string{
+		"answer does not cite FL103",
+		`answer states flight number "FL555", which no flight record has`,
+	}
+	if result.Pass || !slices.Equal(result.Failures, want) {
+		t.Errorf("failures %q, want %q", result.Failures, want)
+	}
+
+	// A failed request fails the case whatever else it got right.
+	result = caseResult{Outcome: sse.OutcomeError}
+	live.check(&result, evalCase{}, []string{"llm1: timeout"})
+	if result.Pass || !slices.Equal(result.Failures, []string{`outcome "error": llm1: timeout`}) {
+		t.Errorf("failures %q", result.Failures)
+	}
+}
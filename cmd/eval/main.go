@@ -0,0 +1,258 @@
+// Command eval runs a fixed set of questions through the orchestration pipeline and reports how
+// each was answered, so prompt and model changes can be compared before they ship.
+//
+// Each case in the -cases file (JSON lines; see evalCase) is answered in-process against the
+// seeded in-memory database, then checked without judgment calls: the detected intent, the
+// flights the search returned, the flight numbers the answer cites, and the grounding check of
+// its prices, times and flight numbers. The report lists every case with its failures, answer,
+// latency and token cost, and is written as JSON with -out:
+//
+//	go run ./cmd/eval -mode mock
+//	OPENAI_API_KEY=... go run ./cmd/eval -mode live -record run.replay -out before.json
+//	go run ./cmd/eval -mode replay -replay run.replay -out after.json -baseline before.json
+//
+// The modes choose the LLMs. live calls the providers of the server's configuration (-config,
+// CONFIG_FILE and the environment, as for the server), optionally recording every answer with
+// -record. replay answers from such a recording, so pipeline changes can be checked again
+// without new calls; a prompt that changed has no recorded answer and its case fails. mock
+// answers with canned text at once; the answer checks are skipped, but intents and searches are
+// still checked, which makes it the mode for checking the harness and the dataset.
+//
+// With -baseline, the report of an earlier run, the cases whose result, latency or cost changed
+// are listed too. The exit code is 1 when a case failed, 2 for usage errors, and 0 otherwise.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/currency"
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/orchestrator"
+	"github.com/Cris245/go-llm-chat/internal/persona"
+)
+
+// Modes choose the LLMs the cases are answered by.
+const (
+	modeLive   = "live"
+	modeReplay = "replay"
+	modeMock   = "mock"
+)
+
+func main() {
+	casesPath := flag.String("cases", "cmd/eval/testdata/cases.jsonl", "JSON lines file of cases")
+	mode := flag.String("mode", modeMock, "LLMs to answer with: live, replay or mock")
+	configPath := flag.String("config", "", "server config file for live mode (default $CONFIG_FILE)")
+	recordPath := flag.String("record", "", "live mode: record the answers to this file for replay")
+	replayPath := flag.String("replay", "", "replay mode: the recorded answers")
+	outPath := flag.String("out", "", "write the JSON report to this file")
+	baselinePath := flag.String("baseline", "", "JSON report of an earlier run to compare with")
+	run := flag.String("run", "", "only run cases whose id contains this")
+	timeout := flag.Duration("timeout", 2*time.Minute, "bounds each case")
+	verbose := flag.Bool("v", false, "log the pipeline, and print every answer")
+	flag.Parse()
+	if !*verbose {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+
+	cases, err := loadCases(*casesPath, *run)
+	if err != nil {
+		usageError(err)
+	}
+	var baseline *report
+	if *baselinePath != "" {
+		if baseline, err = readReport(*baselinePath); err != nil {
+			usageError(err)
+		}
+	}
+
+	env, err := newEnvironment(*mode, *configPath, *recordPath, *replayPath, countUsage)
+	if err != nil {
+		usageError(err)
+	}
+	runner, err := newRunner(env, *timeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rep := report{Mode: *mode, Models: env.models, StartedAt: time.Now().UTC()}
+	for _, c := range cases {
+		result := runner.run(context.Background(), c)
+		rep.Cases = append(rep.Cases, result)
+		printResult(os.Stdout, result, *verbose)
+	}
+	rep.summarize()
+	if env.recording != nil {
+		if err := env.recording.save(); err != nil {
+			log.Fatalf("Save the recording: %v", err)
+		}
+	}
+	printSummary(os.Stdout, rep)
+	if baseline != nil {
+		printComparison(os.Stdout, compare(*baseline, rep))
+	}
+	if *outPath != "" {
+		if err := rep.write(*outPath); err != nil {
+			log.Fatalf("Write the report: %v", err)
+		}
+	}
+	if rep.Summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// usageError reports a problem with the flags or input files and exits with 2.
+func usageError(err error) {
+	fmt.Fprintln(os.Stderr, "eval:", err)
+	os.Exit(2)
+}
+
+// environment is what the cases are answered with.
+type environment struct {
+	llm1, llm2, llm3 llmclient.LLMClient
+	router           *llmclient.Router // The models routed in place of the slots' (see config.ModelRouting)
+	models           map[string]string // Model by slot and route, for the report
+	cfg              *config.Config
+	mock             bool       // The answers are canned, so checking them is pointless
+	recording        *recording // Live mode with -record
+}
+
+// newEnvironment builds the LLM clients of mode, reporting their usage to onUsage.
+func newEnvironment(mode, configPath, recordPath, replayPath string, onUsage llmclient.UsageFunc) (*environment, error) {
+	// The cases always run against the sample flights, so answers can be compared from run to run.
+	args := []string{"-db-backend", config.BackendMemory}
+	if configPath != "" {
+		args = append(args, "-config", configPath)
+	}
+	getenv := os.Getenv
+	if mode != modeLive {
+		// Only live mode calls the providers, so the others mustn't need their key.
+		getenv = func(name string) string {
+			if value := os.Getenv(name); value != "" || name != "OPENAI_API_KEY" {
+				return value
+			}
+			return "unused"
+		}
+	}
+	cfg, err := config.Load(args, getenv)
+	if err != nil {
+		return nil, fmt.Errorf("configuration: %w", err)
+	}
+
+	env := &environment{cfg: cfg, models: make(map[string]string), mock: mode == modeMock}
+	var rec *recording
+	switch mode {
+	case modeLive:
+		if recordPath != "" {
+			rec = newRecording(recordPath)
+			env.recording = rec
+		}
+	case modeReplay:
+		if replayPath == "" {
+			return nil, fmt.Errorf("replay mode needs -replay")
+		}
+		if rec, err = loadRecording(replayPath); err != nil {
+			return nil, err
+		}
+	case modeMock:
+	default:
+		return nil, fmt.Errorf("unknown mode %q (want %s, %s or %s)", mode, modeLive, modeReplay, modeMock)
+	}
+
+	newClient := func(slot config.NamedSlot) (llmclient.LLMClient, error) {
+		env.models[slot.Name] = slot.Model
+		var client llmclient.LLMClient
+		switch mode {
+		case modeLive:
+			var err error
+			client, err = llmclient.New(llmclient.ProviderConfig{
+				Provider: slot.Provider,
+				Model:    slot.Model,
+				APIKey:   cfg.LLM.APIKey,
+				OnUsage:  onUsage,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", slot.Name, err)
+			}
+			client = llmclient.WithRetry(client, cfg.LLM.MaxRetries, 500*time.Millisecond)
+			if rec != nil {
+				client = rec.recorder(slot.Name, slot.Model, client)
+			}
+		case modeReplay:
+			client = rec.replayer(slot.Name, onUsage)
+		case modeMock:
+			mock := llmclient.NewMockClient(0)
+			mock.Model, mock.OnUsage = slot.Model, onUsage
+			client = mock
+		}
+		return llmclient.WithTokenBudget(client), nil
+	}
+	clients := make([]llmclient.LLMClient, 0, 3)
+	for _, slot := range cfg.LLM.Slots() {
+		client, err := newClient(slot)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	env.llm1, env.llm2, env.llm3 = clients[0], clients[1], clients[2]
+	env.router = llmclient.NewRouter()
+	for _, slot := range cfg.LLM.RouteSlots() {
+		client, err := newClient(slot)
+		if err != nil {
+			return nil, err
+		}
+		env.router.Route(slot.Name, slot.Model, client)
+	}
+	return env, nil
+}
+
+// newOrchestrator builds the pipeline as the server configures it for answering, on the
+// seeded in-memory database, with the grounding check on.
+func (env *environment) newOrchestrator() (*orchestrator.Orchestrator, error) {
+	store := db.NewMemoryClient()
+	if err := store.SeedFlights(context.Background()); err != nil {
+		return nil, fmt.Errorf("seed flights: %w", err)
+	}
+	cfg := env.cfg
+	orch := orchestrator.NewOrchestrator(env.llm1, env.llm2, env.llm3, store)
+	orch.SetModels(cfg.LLM.LLM1.Model, cfg.LLM.LLM2.Model, cfg.LLM.LLM3.Model)
+	orch.SetRouter(env.router)
+	if cfg.LLM.Budget.MaxTokens > 0 {
+		orch.SetTokenBudget(orchestrator.TokenBudget(cfg.LLM.Budget))
+	}
+	orch.SetOutputLimit(orchestrator.OutputLimit(cfg.LLM.Output))
+	orch.SetCondensation(orchestrator.Condensation(cfg.LLM.Condense))
+	if err := orch.SetFlightFormat(orchestrator.FlightFormat(cfg.LLM.FlightLines)); err != nil {
+		return nil, err
+	}
+
+	rates, err := currency.NewRateProvider(currency.Config{Provider: cfg.Currency.Provider, RatesURL: cfg.Currency.RatesURL, Refresh: cfg.Currency.Refresh})
+	if err != nil {
+		return nil, fmt.Errorf("currency: %w", err)
+	}
+	orch.SetCurrency(currency.NewConverter(cfg.Currency.Base, rates))
+	if cfg.Persona.Enabled() {
+		p, err := persona.New(cfg.Persona.Settings())
+		if err != nil {
+			return nil, fmt.Errorf("persona: %w", err)
+		}
+		orch.SetPersona(p)
+	}
+	if cfg.Features.RoutePhrasing {
+		orch.EnableRoutePhrasing()
+	}
+	if cfg.Features.LanguageCheck {
+		orch.EnableLanguageCheck()
+	}
+	orch.EnableGroundingCheck()
+	return orch, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+)
+
+// recordedAnswer is one line of a recording: what a slot answered to a prompt.
+type recordedAnswer struct {
+	Slot   string          `json:"slot"`
+	Prompt string          `json:"prompt_sha256"`
+	Model  string          `json:"model"`
+	Answer string          `json:"answer"`
+	Usage  llmclient.Usage `json:"usage"`
+}
+
+// recording holds the answers of a live run, by slot and prompt hash. Replaying a prompt that
+// was asked more than once gives the last answer.
+type recording struct {
+	path string
+
+	mu      sync.Mutex
+	answers map[string]recordedAnswer
+}
+
+func newRecording(path string) *recording {
+	return &recording{path: path, answers: make(map[string]recordedAnswer)}
+}
+
+// loadRecording reads a recording written by save.
+func loadRecording(path string) (*recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+	defer f.Close()
+	rec := newRecording(path)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var a recordedAnswer
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		rec.answers[a.Slot+"/"+a.Prompt] = a
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read recording: %w", err)
+	}
+	return rec, nil
+}
+
+// save writes the recording as JSON lines.
+func (r *recording) save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, a := range r.answers {
+		if err := enc.Encode(a); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// promptHash identifies a prompt without storing its text, which holds the flight records
+// and the user's question.
+func promptHash(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// recorder returns client, recording the answers it gives as slot.
+func (r *recording) recorder(slot, model string, client llmclient.LLMClient) llmclient.LLMClient {
+	return &recordingClient{LLMClient: client, rec: r, slot: slot, model: model}
+}
+
+// replayer returns a client answering as slot did in the recording.
+func (r *recording) replayer(slot string, onUsage llmclient.UsageFunc) llmclient.LLMClient {
+	return &replayClient{rec: r, slot: slot, onUsage: onUsage}
+}
+
+type recordingClient struct {
+	llmclient.LLMClient
+	rec         *recording
+	slot, model string
+}
+
+func (c *recordingClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	ctx, usage := withUsage(ctx)
+	answer, err := c.LLMClient.ChatCompletion(ctx, prompt)
+	if err == nil {
+		c.store(prompt, answer, usage.total())
+	}
+	return answer, err
+}
+
+func (c *recordingClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	ctx, usage := withUsage(ctx)
+	chunks, err := c.LLMClient.StreamChatCompletion(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		var answer strings.Builder
+		for chunk := range chunks {
+			answer.WriteString(chunk)
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if ctx.Err() == nil {
+			c.store(prompt, answer.String(), usage.total())
+		}
+	}()
+	return out, nil
+}
+
+func (c *recordingClient) store(prompt, answer string, usage llmclient.Usage) {
+	c.rec.mu.Lock()
+	defer c.rec.mu.Unlock()
+	hash := promptHash(prompt)
+	c.rec.answers[c.slot+"/"+hash] = recordedAnswer{Slot: c.slot, Prompt: hash, Model: c.model, Answer: answer, Usage: usage}
+}
+
+// errNotRecorded is the answer to a prompt the recording doesn't have.
+var errNotRecorded = errors.New("no recorded answer for this prompt; it changed since the recording")
+
+type replayClient struct {
+	rec     *recording
+	slot    string
+	onUsage llmclient.UsageFunc
+}
+
+func (c *replayClient) lookup(ctx context.Context, prompt string) (string, error) {
+	c.rec.mu.Lock()
+	a, ok := c.rec.answers[c.slot+"/"+promptHash(prompt)]
+	c.rec.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("%s: %w", c.slot, errNotRecorded)
+	}
+	if c.onUsage != nil {
+		c.onUsage(ctx, a.Model, a.Usage)
+	}
+	return a.Answer, nil
+}
+
+func (c *replayClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	return c.lookup(ctx, prompt)
+}
+
+// StreamChatCompletion sends the recorded answer a word at a time, as a streamed answer comes.
+func (c *replayClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	answer, err := c.lookup(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make(chan string)
+	go func() {
+		defer close(chunks)
+		for _, word := range strings.SplitAfter(answer, " ") {
+			select {
+			case chunks <- word:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return chunks, nil
+}
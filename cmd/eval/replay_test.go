@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+)
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.replay")
+	rec := newRecording(path)
+	mock := llmclient.NewMockClient(0)
+	mock.Model, mock.OnUsage = "mock-model", countUsage
+	client := rec.recorder("llm1", "mock-model", mock)
+
+	answer, err := client.ChatCompletion(context.Background(), "Which flights go to Paris?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := client.StreamChatCompletion(context.Background(), "And to Rome?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed strings.Builder
+	for chunk := range chunks {
+		streamed.WriteString(chunk)
+	}
+	if err := rec.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, usage := withUsage(context.Background())
+	replay := loaded.replayer("llm1", countUsage)
+	if got, err := replay.ChatCompletion(ctx, "Which flights go to Paris?"); err != nil || got != answer {
+		t.Errorf("replayed %q, %v, want %q", got, err, answer)
+	}
+	if u := usage.byModel()["mock-model"]; u.PromptTokens == 0 || u.CompletionTokens == 0 {
+		t.Errorf("replayed usage %+v", usage.byModel())
+	}
+	chunks, err = replay.StreamChatCompletion(ctx, "And to Rome?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replayed strings.Builder
+	for chunk := range chunks {
+		replayed.WriteString(chunk)
+	}
+	if replayed.String() != streamed.String() {
+		t.Errorf("replayed stream %q, want %q", replayed.String(), streamed.String())
+	}
+
+	// A changed prompt, or another slot's, has no answer.
+	if _, err := replay.ChatCompletion(ctx, "Which flights go to Paris, please?"); !errors.Is(err, errNotRecorded) {
+		t.Errorf("changed prompt: %v", err)
+	}
+	if _, err := loaded.replayer("llm2", nil).ChatCompletion(ctx, "Which flights go to Paris?"); !errors.Is(err, errNotRecorded) {
+		t.Errorf("another slot: %v", err)
+	}
+}
+
+func TestReplayRun(t *testing.T) {
+	cases, err := loadCases(datasetPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A mock run whose answers are recorded, as a live run records the providers'.
+	path := filepath.Join(t.TempDir(), "run.replay")
+	rec := newRecording(path)
+	env, err := newEnvironment(modeMock, "", "", "", countUsage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.llm1 = rec.recorder("llm1", env.models["llm1"], env.llm1)
+	env.llm2 = rec.recorder("llm2", env.models["llm2"], env.llm2)
+	env.llm3 = rec.recorder("llm3", env.models["llm3"], env.llm3)
+	r, err := newRunner(env, caseTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := runAll(r, modeMock, cases)
+	if err := rec.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replayed, every case gets the answer it was recorded with, and its cost.
+	env, err = newEnvironment(modeReplay, "", "", path, countUsage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err = newRunner(env, caseTimeout); err != nil {
+		t.Fatal(err)
+	}
+	replayed := runAll(r, modeReplay, cases)
+	for i, c := range replayed.Cases {
+		if old := recorded.Cases[i]; c.Answer != old.Answer || c.PromptTokens != old.PromptTokens || c.Outcome != old.Outcome {
+			t.Errorf("%s: replayed %q (%d tokens), recorded %q (%d tokens)", c.ID, c.Answer, c.PromptTokens, old.Answer, old.PromptTokens)
+		}
+	}
+	for _, change := range compare(recorded, replayed).Changes {
+		if change.AnswerChanged {
+			t.Errorf("%s: answer changed", change.ID)
+		}
+	}
+
+	if _, err := newEnvironment(modeReplay, "", "", "", countUsage); err == nil {
+		t.Error("replay mode without -replay")
+	}
+	if _, err := newEnvironment("nonexistent", "", "", "", countUsage); err == nil {
+		t.Error("unknown mode")
+	}
+}
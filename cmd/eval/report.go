@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// report is the outcome of a run, as written with -out and read back with -baseline.
+type report struct {
+	Mode      string            `json:"mode"`
+	Models    map[string]string `json:"models"` // By slot and route
+	StartedAt time.Time         `json:"started_at"`
+	Summary   summary           `json:"summary"`
+	Cases     []caseResult      `json:"cases"`
+}
+
+// summary adds up the cases of a report.
+type summary struct {
+	Passed           int     `json:"passed"`
+	Failed           int     `json:"failed"`
+	LatencyP50Ms     int64   `json:"latency_p50_ms"`
+	LatencyMaxMs     int64   `json:"latency_max_ms"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+func (r *report) summarize() {
+	var s summary
+	latencies := make([]int64, 0, len(r.Cases))
+	for _, c := range r.Cases {
+		if c.Pass {
+			s.Passed++
+		} else {
+			s.Failed++
+		}
+		latencies = append(latencies, c.LatencyMs)
+		s.PromptTokens += c.PromptTokens
+		s.CompletionTokens += c.CompletionTokens
+		s.CostUSD += c.CostUSD
+	}
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		s.LatencyP50Ms, s.LatencyMaxMs = latencies[len(latencies)/2], latencies[len(latencies)-1]
+	}
+	r.Summary = s
+}
+
+func (r *report) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func readReport(path string) (*report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read baseline: %w", err)
+	}
+	var r report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parse baseline %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// printResult prints one case's line, its failures and, with verbose, its answer.
+func printResult(w io.Writer, c caseResult, verbose bool) {
+	status := "PASS"
+	if !c.Pass {
+		status = "FAIL"
+	}
+	fmt.Fprintf(w, "%s %-28s intent=%-11s flights=%-3d %6dms %6d tokens $%.5f\n",
+		status, c.ID, c.Intent, len(c.Flights), c.LatencyMs, c.PromptTokens+c.CompletionTokens, c.CostUSD)
+	for _, failure := range c.Failures {
+		fmt.Fprintf(w, "     - %s\n", failure)
+	}
+	if verbose {
+		fmt.Fprintf(w, "     > %s\n", strings.ReplaceAll(strings.TrimSpace(c.Answer), "\n", "\n     > "))
+	}
+}
+
+func printSummary(w io.Writer, r report) {
+	s := r.Summary
+	fmt.Fprintf(w, "\n%s mode, %d passed, %d failed; latency p50 %dms, max %dms; %d prompt + %d completion tokens, $%.4f\n",
+		r.Mode, s.Passed, s.Failed, s.LatencyP50Ms, s.LatencyMaxMs, s.PromptTokens, s.CompletionTokens, s.CostUSD)
+}
+
+// caseChange is how a case's result differs from the baseline's.
+type caseChange struct {
+	ID            string
+	Status        string // "regressed", "fixed", "new", "not run" or empty
+	AnswerChanged bool
+	LatencyMs     int64   // This run's minus the baseline's
+	CostUSD       float64 // This run's minus the baseline's
+}
+
+// comparison is a run compared with its baseline.
+type comparison struct {
+	Baseline, Current summary
+	Changes           []caseChange // Only the cases that changed, in this run's order
+}
+
+func compare(baseline, current report) comparison {
+	before := make(map[string]caseResult, len(baseline.Cases))
+	for _, c := range baseline.Cases {
+		before[c.ID] = c
+	}
+	cmp := comparison{Baseline: baseline.Summary, Current: current.Summary}
+	seen := make(map[string]bool)
+	for _, c := range current.Cases {
+		seen[c.ID] = true
+		old, ok := before[c.ID]
+		if !ok {
+			cmp.Changes = append(cmp.Changes, caseChange{ID: c.ID, Status: "new"})
+			continue
+		}
+		change := caseChange{
+			ID:            c.ID,
+			AnswerChanged: c.Answer != old.Answer,
+			LatencyMs:     c.LatencyMs - old.LatencyMs,
+			CostUSD:       c.CostUSD - old.CostUSD,
+		}
+		switch {
+		case old.Pass && !c.Pass:
+			change.Status = "regressed"
+		case !old.Pass && c.Pass:
+			change.Status = "fixed"
+		}
+		if change.Status != "" || change.AnswerChanged {
+			cmp.Changes = append(cmp.Changes, change)
+		}
+	}
+	for _, c := range baseline.Cases {
+		if !seen[c.ID] {
+			cmp.Changes = append(cmp.Changes, caseChange{ID: c.ID, Status: "not run"})
+		}
+	}
+	return cmp
+}
+
+func printComparison(w io.Writer, cmp comparison) {
+	b, c := cmp.Baseline, cmp.Current
+	fmt.Fprintf(w, "\nAgainst the baseline: passed %d -> %d, latency p50 %dms -> %dms, cost $%.4f -> $%.4f\n",
+		b.Passed, c.Passed, b.LatencyP50Ms, c.LatencyP50Ms, b.CostUSD, c.CostUSD)
+	if len(cmp.Changes) == 0 {
+		fmt.Fprintln(w, "No case changed.")
+		return
+	}
+	for _, change := range cmp.Changes {
+		var notes []string
+		if change.Status != "" {
+			notes = append(notes, change.Status)
+		}
+		if change.AnswerChanged {
+			notes = append(notes, fmt.Sprintf("answer changed (%+dms, %+.5f$)", change.LatencyMs, change.CostUSD))
+		}
+		fmt.Fprintf(w, "  %-28s %s\n", change.ID, strings.Join(notes, ", "))
+	}
+}
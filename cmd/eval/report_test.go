@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	r := report{Cases: []caseResult{
+		{Pass: true, LatencyMs: 30, PromptTokens: 100, CompletionTokens: 20, CostUSD: 0.01},
+		{Pass: false, LatencyMs: 10, PromptTokens: 50, CompletionTokens: 10, CostUSD: 0.005},
+		{Pass: true, LatencyMs: 20},
+	}}
+	r.summarize()
+	want := summary{Passed: 2, Failed: 1, LatencyP50Ms: 20, LatencyMaxMs: 30, PromptTokens: 150, CompletionTokens: 30, CostUSD: 0.015}
+	if r.Summary != want {
+		t.Errorf("summary %+v, want %+v", r.Summary, want)
+	}
+	empty := report{}
+	empty.summarize()
+	if empty.Summary != (summary{}) {
+		t.Errorf("empty summary %+v", empty.Summary)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	baseline := report{Cases: []caseResult{
+		{ID: "same", Pass: true, Answer: "FL101.", LatencyMs: 100},
+		{ID: "regressed", Pass: true, Answer: "FL101."},
+		{ID: "fixed", Pass: false, Answer: "FL999."},
+		{ID: "reworded", Pass: true, Answer: "FL101 is cheapest.", LatencyMs: 100, CostUSD: 0.002},
+		{ID: "dropped", Pass: true},
+	}}
+	current := report{Cases: []caseResult{
+		{ID: "same", Pass: true, Answer: "FL101.", LatencyMs: 300},
+		{ID: "regressed", Pass: false, Answer: "FL101."},
+		{ID: "fixed", Pass: true, Answer: "FL103."},
+		{ID: "reworded", Pass: true, Answer: "The cheapest is FL101.", LatencyMs: 150, CostUSD: 0.001},
+		{ID: "added", Pass: true},
+	}}
+	got := compare(baseline, current).Changes
+	want := []caseChange{
+		{ID: "regressed", Status: "regressed"},
+		{ID: "fixed", Status: "fixed", AnswerChanged: true},
+		{ID: "reworded", AnswerChanged: true, LatencyMs: 50, CostUSD: -0.001},
+		{ID: "added", Status: "new"},
+		{ID: "dropped", Status: "not run"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes\n%+v\nwant\n%+v", got, want)
+	}
+
+	var out bytes.Buffer
+	printComparison(&out, compare(baseline, baseline))
+	if !strings.Contains(out.String(), "No case changed.") {
+		t.Errorf("no changes:\n%s", out.String())
+	}
+}
+
+func TestReportRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	r := report{Mode: modeMock, Models: map[string]string{"llm1": "mock-model"}, Cases: []caseResult{
+		{ID: "flight/madrid-paris", Pass: false, Failures: []string{"search did not return FL101"}, Flights: []string{}, Cited: []string{}},
+	}}
+	r.summarize()
+	if err := r.write(path); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*read, r) {
+		t.Errorf("read back %+v, want %+v", *read, r)
+	}
+	if _, err := readReport(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing baseline read")
+	}
+}
@@ -0,0 +1,271 @@
+// Command loadtest drives concurrent chat requests against a running server and reports
+// latency, time to first event and error rates, plus the server's goroutine count before,
+// during and after the run to catch leaks.
+//
+//	go run ./cmd/loadtest -c 20 -n 500
+//	go run ./cmd/loadtest -c 50 -duration 5m -stream
+//
+// To measure the server rather than the LLM provider, run it with the mock provider:
+//
+//	LLM_PROVIDER=mock LLM_MOCK_LATENCY=200ms DB_BACKEND=memory go run ./cmd/server
+//
+// Rate limits apply to the load test like to any client; turn them off (the default) or give
+// the test an API key with enough headroom. The exit code is 1 when the goroutine count did not
+// return to its starting level, 2 for usage or connection problems, and 0 otherwise.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// messages are sent round-robin: flight searches and general questions, in English and Spanish.
+var messages = []string{
+	"Show me flights from Madrid to Paris",
+	"What is the capital of France?",
+	"Vuelos de Madrid a Barcelona",
+	"Explain how airline overbooking works",
+	"Show me flights from Barcelona to Seville under 200",
+	"¿Qué documentos necesito para volar a Londres?",
+}
+
+// result is the outcome of one request.
+type result struct {
+	latency    time.Duration // Until the stream ended
+	firstEvent time.Duration // Until the first event arrived; 0 if none did
+	err        string        // Error class, empty on success
+}
+
+func main() {
+	server := flag.String("server", "http://localhost:8080", "server base URL")
+	concurrency := flag.Int("c", 10, "concurrent requests")
+	total := flag.Int("n", 100, "total requests (ignored with -duration)")
+	duration := flag.Duration("duration", 0, "keep sending requests for this long instead of -n")
+	stream := flag.Bool("stream", false, "ask for the answer streamed in chunks")
+	apiKey := flag.String("api-key", "", "API key sent as a bearer token")
+	timeout := flag.Duration("timeout", 3*time.Minute, "per-request timeout")
+	settle := flag.Duration("settle", 10*time.Second, "how long to wait for the server's goroutines to return to their starting level")
+	leakThreshold := flag.Int("leak-threshold", 5, "goroutines above the starting level still counted as settled")
+	flag.Parse()
+	if *concurrency < 1 || (*duration <= 0 && *total < 1) {
+		fmt.Fprintln(os.Stderr, "-c and -n (or -duration) must be positive")
+		os.Exit(2)
+	}
+	base := strings.TrimRight(*server, "/")
+	client := &http.Client{Timeout: *timeout}
+
+	before, err := goroutines(client, base)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Reading the server's goroutine count: %v\n", err)
+		os.Exit(2)
+	}
+
+	// Sample the goroutine count through the run for its peak.
+	var peak atomic.Int64
+	peak.Store(int64(before))
+	stopSampling := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if n, err := goroutines(client, base); err == nil && int64(n) > peak.Load() {
+					peak.Store(int64(n))
+				}
+			case <-stopSampling:
+				return
+			}
+		}
+	}()
+
+	// Workers take request numbers until the count or the duration runs out.
+	var next atomic.Int64
+	deadline := time.Now().Add(*duration)
+	more := func() (int, bool) {
+		i := int(next.Add(1)) - 1
+		if *duration > 0 {
+			return i, time.Now().Before(deadline)
+		}
+		return i, i < *total
+	}
+	var mu sync.Mutex
+	var results []result
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, ok := more(); ok; i, ok = more() {
+				r := send(client, base, *apiKey, messages[i%len(messages)], *stream)
+				mu.Lock()
+				results = append(results, r)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(stopSampling)
+	<-sampled
+
+	// Give finished requests' goroutines time to exit before judging the count.
+	after := before
+	for settleBy := time.Now().Add(*settle); ; {
+		if n, err := goroutines(client, base); err == nil {
+			after = n
+		}
+		if after <= before+*leakThreshold || time.Now().After(settleBy) {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	leaked := report(os.Stdout, results, elapsed, *concurrency, before, int(peak.Load()), after, *leakThreshold)
+	if leaked {
+		os.Exit(1)
+	}
+}
+
+// send makes one chat request and follows its event stream to the end.
+func send(client *http.Client, base, apiKey, message string, stream bool) result {
+	body, _ := json.Marshal(map[string]any{"message": message, "stream": stream})
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, base+"/api", bytes.NewReader(body))
+	if err != nil {
+		return result{err: "request"}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream, application/json") // JSON envelopes carry the Done outcome.
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return result{latency: time.Since(start), err: "connection"}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return result{latency: time.Since(start), err: "http_" + strconv.Itoa(resp.StatusCode)}
+	}
+
+	r := result{err: "no_done"} // Until the Done event says otherwise.
+	reader := sse.NewReader(resp.Body)
+	for {
+		frame, err := reader.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				r.err = "stream_read"
+			}
+			break
+		}
+		if r.firstEvent == 0 {
+			r.firstEvent = time.Since(start)
+		}
+		env, err := sse.ParseEnvelope(frame.Data)
+		if err != nil || env.Type != sse.TypeDone {
+			continue
+		}
+		var done sse.DonePayload
+		json.Unmarshal(env.Data, &done)
+		r.err = ""
+		if done.Outcome != sse.OutcomeOK {
+			r.err = "outcome_" + done.Outcome
+		}
+	}
+	r.latency = time.Since(start)
+	return r
+}
+
+// goroutines reads the server's go_goroutines gauge from /metrics.
+func goroutines(client *http.Client, base string) (int, error) {
+	resp, err := client.Get(base + "/metrics")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("GET /metrics: %s", resp.Status)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		if value, ok := strings.CutPrefix(lines.Text(), "go_goroutines "); ok {
+			f, err := strconv.ParseFloat(value, 64)
+			return int(f), err
+		}
+	}
+	return 0, errors.New("go_goroutines not found in /metrics")
+}
+
+// report prints the run's summary and returns whether the goroutine count suggests a leak.
+func report(w io.Writer, results []result, elapsed time.Duration, concurrency, before, peak, after, threshold int) bool {
+	var latencies, firstEvents []time.Duration
+	errorsByClass := map[string]int{}
+	for _, r := range results {
+		latencies = append(latencies, r.latency)
+		if r.firstEvent > 0 {
+			firstEvents = append(firstEvents, r.firstEvent)
+		}
+		if r.err != "" {
+			errorsByClass[r.err]++
+		}
+	}
+	failed := 0
+	var classes []string
+	for class, n := range errorsByClass {
+		failed += n
+		classes = append(classes, fmt.Sprintf("%s: %d", class, n))
+	}
+	slices.Sort(classes)
+
+	fmt.Fprintf(w, "Requests:     %d (concurrency %d) in %v, %.1f req/s\n", len(results), concurrency, elapsed.Round(time.Millisecond), float64(len(results))/elapsed.Seconds())
+	fmt.Fprintf(w, "Errors:       %d (%.1f%%)", failed, 100*float64(failed)/float64(max(1, len(results))))
+	if len(classes) > 0 {
+		fmt.Fprintf(w, " [%s]", strings.Join(classes, ", "))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Latency:      %s\n", percentiles(latencies))
+	fmt.Fprintf(w, "First event:  %s\n", percentiles(firstEvents))
+
+	leaked := after > before+threshold
+	verdict := "ok"
+	if leaked {
+		verdict = fmt.Sprintf("POSSIBLE LEAK (+%d)", after-before)
+	}
+	fmt.Fprintf(w, "Goroutines:   before %d, peak %d, after %d: %s\n", before, peak, after, verdict)
+	return leaked
+}
+
+// percentiles formats the p50, p95, p99 and maximum of durations (nearest rank).
+func percentiles(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "n/a"
+	}
+	slices.Sort(durations)
+	at := func(p float64) time.Duration {
+		i := int(p*float64(len(durations))+0.5) - 1
+		return durations[min(max(i, 0), len(durations)-1)].Round(time.Millisecond)
+	}
+	return fmt.Sprintf("p50 %v  p95 %v  p99 %v  max %v", at(0.50), at(0.95), at(0.99), durations[len(durations)-1].Round(time.Millisecond))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestPercentiles(t *testing.T) {
+	if got := percentiles(nil); got != "n/a" {
+		t.Errorf("percentiles(nil) = %q", got)
+	}
+	// 100ms down to 1ms: sorted, nearest rank picks the 50th, 95th and 99th.
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+	if got, want := percentiles(durations), "p50 50ms  p95 95ms  p99 99ms  max 100ms"; got != want {
+		t.Errorf("percentiles = %q, want %q", got, want)
+	}
+	if got, want := percentiles([]time.Duration{7 * time.Millisecond}), "p50 7ms  p95 7ms  p99 7ms  max 7ms"; got != want {
+		t.Errorf("percentiles of one = %q, want %q", got, want)
+	}
+}
+
+func TestReport(t *testing.T) {
+	results := []result{
+		{latency: 100 * time.Millisecond, firstEvent: 10 * time.Millisecond},
+		{latency: 200 * time.Millisecond, firstEvent: 20 * time.Millisecond},
+		{latency: 5 * time.Millisecond, err: "http_429"},
+		{latency: 300 * time.Millisecond, firstEvent: 30 * time.Millisecond, err: "outcome_error"},
+	}
+	var out strings.Builder
+	if report(&out, results, 2*time.Second, 2, 10, 40, 14, 5) {
+		t.Errorf("reported a leak within the threshold:\n%s", out.String())
+	}
+	for _, want := range []string{
+		"Requests:     4 (concurrency 2) in 2s, 2.0 req/s",
+		"Errors:       2 (50.0%) [http_429: 1, outcome_error: 1]",
+		"Latency:      p50 100ms",
+		"First event:  p50 20ms", // The request that never got an event isn't counted.
+		"Goroutines:   before 10, peak 40, after 14: ok",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if !report(&out, results, time.Second, 2, 10, 40, 16, 5) || !strings.Contains(out.String(), "POSSIBLE LEAK (+6)") {
+		t.Errorf("no leak reported 6 goroutines up:\n%s", out.String())
+	}
+}
+
+// fakeServer answers /metrics with a goroutine count and /api with the given status and
+// events, as JSON envelopes.
+func fakeServer(t *testing.T, status int, events ...sse.Event) string {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "# TYPE go_goroutines gauge\ngo_goroutines 42\ngo_threads 8\n")
+	})
+	mux.HandleFunc("POST /api", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream, application/json" {
+			t.Errorf("Accept %q, want JSON envelopes", r.Header.Get("Accept"))
+		}
+		if status != http.StatusOK {
+			http.Error(w, "busy", status)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i, ev := range events {
+			ev.Seq = int64(i + 1)
+			sse.SSEEncoder{Format: sse.FormatJSON}.Encode(w, "s1", ev)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestGoroutines(t *testing.T) {
+	if n, err := goroutines(http.DefaultClient, fakeServer(t, http.StatusOK)); n != 42 || err != nil {
+		t.Errorf("goroutines = %d, %v", n, err)
+	}
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	if _, err := goroutines(http.DefaultClient, srv.URL); err == nil {
+		t.Error("no error without /metrics")
+	}
+}
+
+func TestSend(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		status  int
+		events  []sse.Event
+		wantErr string
+	}{
+		{"ok", http.StatusOK, []sse.Event{sse.Started("s1"), sse.MessageChunk("Hi", true), sse.Done(sse.DonePayload{Outcome: sse.OutcomeOK})}, ""},
+		{"failed", http.StatusOK, []sse.Event{sse.Started("s1"), sse.Done(sse.DonePayload{Outcome: sse.OutcomeError})}, "outcome_error"},
+		{"no done", http.StatusOK, []sse.Event{sse.Started("s1"), sse.MessageChunk("Hi", false)}, "no_done"},
+		{"rate limited", http.StatusTooManyRequests, nil, "http_429"},
+	} {
+		r := send(http.DefaultClient, fakeServer(t, tt.status, tt.events...), "", "Hi", false)
+		if r.err != tt.wantErr || r.latency <= 0 {
+			t.Errorf("%s: %+v, want error %q", tt.name, r, tt.wantErr)
+		}
+		if gotFirst := r.firstEvent > 0; gotFirst != (len(tt.events) > 0) || r.firstEvent > r.latency {
+			t.Errorf("%s: first event after %v of %v", tt.name, r.firstEvent, r.latency)
+		}
+	}
+}
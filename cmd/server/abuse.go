@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/metrics"
+)
+
+// abuseCheckTimeout bounds each ban lookup and rejection record.
+const abuseCheckTimeout = 2 * time.Second
+
+// abuseDetector bans clients for a while once too many of their requests were rejected
+// within a window: invalid requests (400, 413, 414 and 415) and rate limit hits (429). Counts and bans
+// live in the database, so every replica sees them. A nil detector bans no one.
+type abuseDetector struct {
+	store     db.Client
+	threshold int
+	window    time.Duration
+	banFor    time.Duration
+	now       func() time.Time
+}
+
+// newAbuseDetector returns the detector cfg describes, or nil if it is disabled.
+func newAbuseDetector(store db.Client, cfg config.Abuse) *abuseDetector {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return &abuseDetector{store: store, threshold: cfg.Threshold, window: cfg.Window, banFor: cfg.BanDuration, now: time.Now}
+}
+
+// rejectionKind classifies a response status as a rejection counted towards a ban; ok is
+// false for the others.
+func rejectionKind(status int) (kind string, ok bool) {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusRequestURITooLong, http.StatusUnsupportedMediaType:
+		return "invalid_request", true
+	case http.StatusTooManyRequests:
+		return "rate_limited", true
+	}
+	return "", false
+}
+
+// statusRecorder captures the status of a response. Flush and Unwrap keep streaming handlers
+// working behind it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// middleware refuses the requests of banned clients with 403 and counts the rejections of the
+// others, banning a client once it reaches the threshold. The database failing doesn't
+// refuse requests: the check is skipped and the failure logged.
+func (d *abuseDetector) middleware(next http.HandlerFunc) http.HandlerFunc {
+	if d == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := clientKey(r)
+		account := usageAccount(key)
+		now := d.now()
+		ctx, cancel := context.WithTimeout(r.Context(), abuseCheckTimeout)
+		ban, err := d.store.GetBan(ctx, account, now)
+		cancel()
+		switch {
+		case err == nil:
+			metrics.RateLimited.WithLabelValues("banned").Inc()
+			slog.InfoContext(r.Context(), "Request refused: client banned", "client", maskClient(key), "ban", ban.ID, "until", ban.ExpiresAt)
+			httpapi.Write(w, r, &httpapi.Error{
+				Status:     http.StatusForbidden,
+				Code:       httpapi.CodeClientBanned,
+				Message:    "Too many rejected requests; this client is banned until " + ban.ExpiresAt.UTC().Format(time.RFC3339),
+				RetryAfter: ban.ExpiresAt.Sub(now),
+			})
+			return
+		case !errors.Is(err, db.ErrNotFound):
+			slog.WarnContext(r.Context(), "Ban check failed; allowing the request", "client", maskClient(key), "error", err)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r)
+		if kind, ok := rejectionKind(rec.status); ok {
+			d.reject(context.WithoutCancel(r.Context()), key, kind)
+		}
+	}
+}
+
+// reject records a rejection of key's request and bans the client if it was one too many.
+func (d *abuseDetector) reject(ctx context.Context, key, kind string) {
+	ctx, cancel := context.WithTimeout(ctx, abuseCheckTimeout)
+	defer cancel()
+	account := usageAccount(key)
+	now := d.now()
+	n, err := d.store.RecordRejection(ctx, db.Rejection{Client: account, Kind: kind, At: now, ExpiresAt: now.Add(d.window)}, now.Add(-d.window))
+	if err != nil {
+		slog.WarnContext(ctx, "Recording a rejected request failed", "client", maskClient(key), "error", err)
+		return
+	}
+	if n < d.threshold {
+		return
+	}
+	ban := db.Ban{
+		ID:         newBanID(),
+		Client:     account,
+		Reason:     strconv.Itoa(n) + " rejected requests within " + d.window.String() + ", the last " + kind,
+		Rejections: n,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(d.banFor),
+	}
+	if err := d.store.SaveBan(ctx, ban); err != nil {
+		slog.ErrorContext(ctx, "Saving a ban failed", "client", maskClient(key), "error", err)
+		return
+	}
+	slog.WarnContext(ctx, "Client banned", "client", maskClient(key), "ban", ban.ID, "rejections", n, "until", ban.ExpiresAt)
+}
+
+// newBanID returns a random ban ID.
+func newBanID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return "ban-" + hex.EncodeToString(buf)
+}
+
+// listBansHandler serves GET /api/admin/bans: the bans in force, newest first, or with
+// ?all=true every ban, lifted and expired ones included.
+func listBansHandler(store db.Client, now func() time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		activeAt := now()
+		if raw := r.URL.Query().Get("all"); raw != "" {
+			all, err := strconv.ParseBool(raw)
+			if err != nil {
+				httpapi.Write(w, r, httpapi.BadRequest("invalid_all", "all must be true or false"))
+				return
+			}
+			if all {
+				activeAt = time.Time{}
+			}
+		}
+		bans, err := store.ListBans(r.Context(), activeAt)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Listing bans failed", "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodeInternal, Message: "Bans could not be loaded"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"bans": bans, "count": len(bans)})
+	}
+}
+
+// liftBanHandler serves DELETE /api/admin/bans/{client}: it ends the bans of a client (an
+// account as listed, e.g. "ip:203.0.113.7" or "key:3f2a…") now, and returns the lifted ban.
+func liftBanHandler(store db.Client, now func() time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := r.PathValue("client")
+		ban, err := store.LiftBan(r.Context(), client, maskClient(clientKey(r)), now())
+		if errors.Is(err, db.ErrNotFound) {
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeBanNotFound, Message: "No ban of this client is in force"})
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Lifting a ban failed", "client", client, "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodeInternal, Message: "The ban could not be lifted"})
+			return
+		}
+		slog.InfoContext(r.Context(), "Ban lifted", "client", client, "ban", ban.ID, "by", ban.LiftedBy)
+		writeJSON(w, http.StatusOK, ban)
+	}
+}
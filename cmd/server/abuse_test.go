@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+func TestRejectionKind(t *testing.T) {
+	for status, want := range map[int]string{
+		http.StatusBadRequest:            "invalid_request",
+		http.StatusRequestEntityTooLarge: "invalid_request",
+		http.StatusUnsupportedMediaType:  "invalid_request",
+		http.StatusTooManyRequests:       "rate_limited",
+		http.StatusOK:                    "",
+		http.StatusNotFound:              "",
+		http.StatusPaymentRequired:       "",
+		http.StatusServiceUnavailable:    "",
+	} {
+		if kind, ok := rejectionKind(status); kind != want || ok != (want != "") {
+			t.Errorf("rejectionKind(%d) = %q, %v", status, kind, ok)
+		}
+	}
+}
+
+func TestAbuseBan(t *testing.T) {
+	store := db.NewMemoryClient()
+	d := newAbuseDetector(store, config.Abuse{Threshold: 3, Window: time.Minute, BanDuration: 15 * time.Minute})
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	h := d.middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("bad") != "" {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeMalformedJSON, "Bad request"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	send := func(query, remote string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api?"+query, nil)
+		req.RemoteAddr = remote
+		h(rec, req)
+		return rec
+	}
+
+	// Two rejections, then a good request, stay under the threshold.
+	for _, query := range []string{"bad=1", "bad=1", ""} {
+		send(query, "192.0.2.1:1234")
+	}
+	if rec := send("", "192.0.2.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("under the threshold: %d", rec.Code)
+	}
+	// The third within the window bans the client, and only it.
+	now = now.Add(30 * time.Second)
+	send("bad=1", "192.0.2.1:1234")
+	rec := send("", "192.0.2.1:5678")
+	if rec.Code != http.StatusForbidden || errorCodeOf(rec) != httpapi.CodeClientBanned || rec.Header().Get("Retry-After") != "900" {
+		t.Errorf("banned: %d %s, Retry-After %q", rec.Code, rec.Body, rec.Header().Get("Retry-After"))
+	}
+	if rec := send("", "192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("another client: %d", rec.Code)
+	}
+	ban, err := store.GetBan(context.Background(), "ip:192.0.2.1", now)
+	if err != nil || ban.Rejections != 3 || !ban.ExpiresAt.Equal(now.Add(15*time.Minute)) || !strings.HasSuffix(ban.Reason, "the last invalid_request") {
+		t.Errorf("ban %+v, %v", ban, err)
+	}
+
+	// The ban ends on time.
+	now = now.Add(15 * time.Minute)
+	if rec := send("", "192.0.2.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("after the ban: %d", rec.Code)
+	}
+
+	// Rejections spread out over more than the window don't ban.
+	for range 5 {
+		now = now.Add(40 * time.Second)
+		send("bad=1", "192.0.2.3:1234")
+	}
+	if rec := send("", "192.0.2.3:1234"); rec.Code != http.StatusOK {
+		t.Errorf("rejections spread out: %d", rec.Code)
+	}
+
+	// A disabled detector passes requests through.
+	var off *abuseDetector
+	rec = httptest.NewRecorder()
+	off.middleware(http.NotFound)(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("without a detector: %d", rec.Code)
+	}
+}
+
+func TestBansHandlers(t *testing.T) {
+	store := db.NewMemoryClient()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	for _, ban := range []db.Ban{
+		{ID: "ban-old", Client: "ip:192.0.2.1", Reason: "old", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-30 * time.Minute)},
+		{ID: "ban-new", Client: "ip:192.0.2.1", Reason: "new", CreatedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)},
+	} {
+		if err := store.SaveBan(context.Background(), ban); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/admin/bans", listBansHandler(store, clock))
+	mux.HandleFunc("DELETE /api/admin/bans/{client}", liftBanHandler(store, clock))
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-API-Key", "admin-key-1234")
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	list := func(path string) []string {
+		t.Helper()
+		rec := do(http.MethodGet, path)
+		var resp struct {
+			Bans  []db.Ban `json:"bans"`
+			Count int      `json:"count"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK || resp.Count != len(resp.Bans) {
+			t.Fatalf("%s: %d, %v", path, rec.Code, err)
+		}
+		ids := []string{}
+		for _, b := range resp.Bans {
+			ids = append(ids, b.ID)
+		}
+		return ids
+	}
+
+	if got := strings.Join(list("/api/admin/bans"), " "); got != "ban-new" {
+		t.Errorf("bans in force %q", got)
+	}
+	if got := strings.Join(list("/api/admin/bans?all=true"), " "); got != "ban-new ban-old" {
+		t.Errorf("every ban %q", got)
+	}
+	if rec := do(http.MethodGet, "/api/admin/bans?all=maybe"); rec.Code != http.StatusBadRequest || errorCodeOf(rec) != "invalid_all" {
+		t.Errorf("?all=maybe: %d %s", rec.Code, rec.Body)
+	}
+
+	// Lifting answers with the lifted ban, and names the admin by a masked key.
+	rec := do(http.MethodDelete, "/api/admin/bans/ip:192.0.2.1")
+	var lifted db.Ban
+	if err := json.NewDecoder(rec.Body).Decode(&lifted); err != nil || rec.Code != http.StatusOK ||
+		lifted.ID != "ban-new" || lifted.LiftedAt == nil || lifted.LiftedBy != "key:…1234" {
+		t.Errorf("lift: %d %+v, %v", rec.Code, lifted, err)
+	}
+	if got := list("/api/admin/bans"); len(got) != 0 {
+		t.Errorf("bans in force after the lift %v", got)
+	}
+	if rec := do(http.MethodDelete, "/api/admin/bans/ip:192.0.2.1"); rec.Code != http.StatusNotFound || errorCodeOf(rec) != httpapi.CodeBanNotFound {
+		t.Errorf("lifting again: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAbuseBanServer(t *testing.T) {
+	s := startServer(t, "ABUSE_THRESHOLD=3", "ADMIN_API_KEYS=admin-key")
+	for range 3 {
+		resp := postChat(t, s, "", false)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("empty message answered %d", resp.StatusCode)
+		}
+	}
+	resp := postChat(t, s, "What is the capital of France?", false)
+	if resp.StatusCode != http.StatusForbidden || errorCode(t, resp) != httpapi.CodeClientBanned || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("banned client answered %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	resp.Body.Close()
+
+	// The ban is listed for admins, who can lift it.
+	var bans struct {
+		Bans []db.Ban `json:"bans"`
+	}
+	if status := adminGet(t, s, "/api/admin/bans", &bans); status != http.StatusOK || len(bans.Bans) != 1 || bans.Bans[0].Client != "ip:127.0.0.1" {
+		t.Fatalf("bans: %d %+v", status, bans.Bans)
+	}
+	req, _ := http.NewRequest(http.MethodDelete, s.url+"/api/admin/bans/"+bans.Bans[0].Client, nil)
+	req.Header.Set("X-API-Key", "admin-key")
+	lift, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lift.Body.Close()
+	if lift.StatusCode != http.StatusOK {
+		t.Errorf("lift answered %d", lift.StatusCode)
+	}
+	resp = postChat(t, s, "What is the capital of France?", false)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("after the lift, answered %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/orchestrator"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// activeRequest is a running orchestration.
+type activeRequest struct {
+	stream    *sse.Stream
+	cancel    context.CancelCauseFunc
+	startedAt time.Time
+	client    string // Masked clientKey, safe to show to admins
+	sessionID string // The client's session, if it gave one
+	intent    string // Set once intent detection finishes
+}
+
+// activeRequests tracks running orchestrations by stream ID, so POST /api/cancel/{id} can stop
+// one and admins can see what is running. Entries exist only while the orchestration runs.
+type activeRequests struct {
+	mu       sync.Mutex
+	requests map[string]*activeRequest
+}
+
+func newActiveRequests() *activeRequests {
+	return &activeRequests{requests: make(map[string]*activeRequest)}
+}
+
+// add registers the orchestration feeding stream, cancelled by cancel, on behalf of client in
+// sessionID (which may be empty). With exclusive set it registers nothing and returns false if
+// another request of the same session is running.
+func (a *activeRequests) add(stream *sse.Stream, cancel context.CancelCauseFunc, client, sessionID string, exclusive bool) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if exclusive && sessionID != "" {
+		for _, req := range a.requests {
+			if req.sessionID == sessionID {
+				return false
+			}
+		}
+	}
+	a.requests[stream.ID()] = &activeRequest{stream: stream, cancel: cancel, startedAt: time.Now(), client: client, sessionID: sessionID}
+	return true
+}
+
+// remove drops streamID once its orchestration has finished.
+func (a *activeRequests) remove(streamID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.requests, streamID)
+}
+
+// setIntent records the detected intent of a running orchestration.
+func (a *activeRequests) setIntent(streamID, intent string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if req, ok := a.requests[streamID]; ok {
+		req.intent = intent
+	}
+}
+
+// stop cancels the orchestration behind streamID with orchestrator.ErrCancelled. It reports
+// false if no such orchestration is running (unknown ID, or already finished).
+func (a *activeRequests) stop(streamID string) bool {
+	a.mu.Lock()
+	req, ok := a.requests[streamID]
+	a.mu.Unlock()
+	if ok {
+		req.cancel(orchestrator.ErrCancelled)
+	}
+	return ok
+}
+
+// activeStreamInfo describes a running request in the admin endpoints.
+type activeStreamInfo struct {
+	StreamID  string    `json:"stream_id"`
+	StartedAt time.Time `json:"started_at"`
+	AgeMs     int64     `json:"age_ms"`
+	Client    string    `json:"client"`
+	SessionID string    `json:"session_id,omitempty"`
+	Intent    string    `json:"intent,omitempty"` // Empty until intent detection finishes
+	Phase     string    `json:"phase,omitempty"`  // The latest Status event
+	Events    int       `json:"events"`
+}
+
+// activeStreamDetail is the body of GET /api/admin/streams/{id}: the summary plus every event
+// buffered so far, as JSON envelopes.
+type activeStreamDetail struct {
+	activeStreamInfo
+	EventLog []json.RawMessage `json:"event_log"`
+}
+
+func (req *activeRequest) info(id string, now time.Time) activeStreamInfo {
+	events, phase := req.stream.Progress()
+	return activeStreamInfo{
+		StreamID:  id,
+		StartedAt: req.startedAt,
+		AgeMs:     now.Sub(req.startedAt).Milliseconds(),
+		Client:    req.client,
+		SessionID: req.sessionID,
+		Intent:    req.intent,
+		Phase:     phase,
+		Events:    events,
+	}
+}
+
+// list returns the running requests, oldest first.
+func (a *activeRequests) list() []activeStreamInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	infos := make([]activeStreamInfo, 0, len(a.requests))
+	for id, req := range a.requests {
+		infos = append(infos, req.info(id, now))
+	}
+	slices.SortFunc(infos, func(x, y activeStreamInfo) int { return x.StartedAt.Compare(y.StartedAt) })
+	return infos
+}
+
+// get returns one running request with its events.
+func (a *activeRequests) get(streamID string) (activeStreamDetail, bool) {
+	a.mu.Lock()
+	req, ok := a.requests[streamID]
+	var info activeStreamInfo
+	if ok {
+		info = req.info(streamID, time.Now())
+	}
+	a.mu.Unlock()
+	if !ok {
+		return activeStreamDetail{}, false
+	}
+	events := req.stream.Events()
+	detail := activeStreamDetail{activeStreamInfo: info, EventLog: make([]json.RawMessage, len(events))}
+	for i, event := range events {
+		detail.EventLog[i] = sse.MarshalEvent(event)
+	}
+	return detail, true
+}
+
+// maskClient shortens an API key clientKey to its last four characters, so admin listings
+// identify callers without exposing their keys. IP-based keys are shown as they are.
+func maskClient(key string) string {
+	apiKey, ok := strings.CutPrefix(key, "key:")
+	if !ok {
+		return key
+	}
+	return "key:…" + apiKey[max(0, len(apiKey)-4):]
+}
+
+// listStreamsHandler serves GET /api/admin/streams: the running requests, oldest first.
+func listStreamsHandler(active *activeRequests) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		streams := active.list()
+		writeJSON(w, http.StatusOK, map[string]any{"streams": streams, "count": len(streams)})
+	}
+}
+
+// getStreamHandler serves GET /api/admin/streams/{id}: one running request and its events.
+func getStreamHandler(active *activeRequests) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		detail, ok := active.get(r.PathValue("id"))
+		if !ok {
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeNotRunning, Message: "No running request has this stream ID"})
+			return
+		}
+		writeJSON(w, http.StatusOK, detail)
+	}
+}
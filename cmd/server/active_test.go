@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestActiveRequestsConcurrentUpdates(t *testing.T) {
+	// Orchestrations start, progress and finish while admins list and inspect them.
+	active := newActiveRequests()
+	registry := sse.NewRegistry(time.Minute)
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			stream := registry.Create()
+			active.add(stream, func(error) {}, fmt.Sprintf("key:client-%d", i), "", false)
+			for j := range 20 {
+				stream.Publish(sse.Status(fmt.Sprintf("step %d", j)))
+				active.setIntent(stream.ID(), "general")
+			}
+			stream.Close()
+			active.remove(stream.ID())
+		}()
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				for _, info := range active.list() {
+					active.get(info.StreamID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if infos := active.list(); len(infos) != 0 {
+		t.Errorf("%d requests left after all finished", len(infos))
+	}
+}
+
+func TestMaskClient(t *testing.T) {
+	for key, want := range map[string]string{
+		"key:sk-live-abcd1234": "key:…1234",
+		"key:abc":              "key:…abc",
+		"ip:192.0.2.1":         "ip:192.0.2.1",
+	} {
+		if got := maskClient(key); got != want {
+			t.Errorf("maskClient(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+// adminGet sends an admin GET to s and decodes the JSON answer into v.
+func adminGet(t *testing.T, s *testServer, path string, v any) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, s.url+path, nil)
+	req.Header.Set("X-API-Key", "admin-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdminStreams(t *testing.T) {
+	s := startServer(t, "ADMIN_API_KEYS=admin-key", "LLM_MOCK_LATENCY=1s")
+
+	// Two clients start requests that keep running for a few seconds.
+	readers := map[string]*sse.Reader{}
+	for _, key := range []string{"client-one-1111", "client-two-2222"} {
+		req, _ := http.NewRequest(http.MethodPost, s.url+"/api", strings.NewReader(`{"message":"What is the capital of France?","language":"en"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		reader := sse.NewReader(resp.Body)
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+		readers[resp.Header.Get("X-Stream-ID")] = reader
+	}
+
+	var list struct {
+		Streams []activeStreamInfo `json:"streams"`
+		Count   int                `json:"count"`
+	}
+	if status := adminGet(t, s, "/api/admin/streams", &list); status != http.StatusOK {
+		t.Fatalf("listing answered %d", status)
+	}
+	if list.Count != 2 || len(list.Streams) != 2 {
+		t.Fatalf("listing %+v, want both requests", list)
+	}
+	clients := map[string]bool{}
+	for _, info := range list.Streams {
+		if readers[info.StreamID] == nil || info.Events == 0 || info.StartedAt.IsZero() {
+			t.Errorf("listed %+v", info)
+		}
+		clients[info.Client] = true
+	}
+	if !clients["key:…1111"] || !clients["key:…2222"] {
+		t.Errorf("clients %v, want both masked keys", clients)
+	}
+
+	var detail activeStreamDetail
+	if status := adminGet(t, s, "/api/admin/streams/"+list.Streams[0].StreamID, &detail); status != http.StatusOK {
+		t.Fatalf("detail answered %d", status)
+	}
+	if len(detail.EventLog) == 0 || detail.Events < len(detail.EventLog)-1 {
+		t.Fatalf("detail %+v", detail)
+	}
+	if first, err := sse.ParseEnvelope(string(detail.EventLog[0])); err != nil || first.Type != sse.TypeStarted {
+		t.Errorf("first buffered event %s: %v", detail.EventLog[0], err)
+	}
+
+	// Without the admin key the running requests stay hidden.
+	resp, err := http.Get(s.url + "/api/admin/streams")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("listing without the key answered %d", resp.StatusCode)
+	}
+
+	// Once both finish they drop out of the listing.
+	for id, reader := range readers {
+		if frames := readAll(t, reader); frames[len(frames)-1].Data != sse.OutcomeOK {
+			t.Errorf("%s ended with %+v", id, frames[len(frames)-1])
+		}
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		adminGet(t, s, "/api/admin/streams", &list)
+		if list.Count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still listed after finishing: %+v", list.Streams)
+		}
+	}
+	if status := adminGet(t, s, "/api/admin/streams/"+detail.StreamID, &detail); status != http.StatusNotFound {
+		t.Errorf("detail of a finished request answered %d", status)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+const (
+	importBatchSize     = 500      // Rows upserted per BulkWrite while streaming a CSV import.
+	maxImportBytes      = 32 << 20 // Upper bound on an import upload (32 MiB).
+	maxReportedRejects  = 100      // Rejected rows listed individually in the import summary.
+	importFileFieldName = "file"   // Multipart form field carrying the CSV.
+)
+
+// csvColumns are the header names an import file must contain (in any order). It may also have
+// origin_airport and destination_airport.
+var csvColumns = []string{"flight_number", "origin", "destination", "departure_time", "arrival_time", "price", "available_seats"}
+
+// requestAPIKey extracts the caller's key from "Authorization: Bearer <key>" or "X-API-Key: <key>".
+func requestAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// hasKey reports whether the request carries one of keys.
+func hasKey(r *http.Request, keys []string) bool {
+	given := requestAPIKey(r)
+	for _, k := range keys {
+		if given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(k)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAdmin wraps an admin handler so it only runs for requests carrying one of the admin keys.
+// With no keys configured every request is rejected, so admin endpoints are closed by default.
+func requireAdmin(keys []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hasKey(r, keys) {
+			next(w, r)
+			return
+		}
+		httpapi.Write(w, r, &httpapi.Error{Status: http.StatusUnauthorized, Code: httpapi.CodeUnauthorized, Message: "A valid admin API key is required"})
+	}
+}
+
+// rejectedRow reports why a CSV row was not imported. Row numbers are 1-based and count the header.
+type rejectedRow struct {
+	Row    int    `json:"row"`
+	Reason string `json:"reason"`
+}
+
+// importSummary is the JSON body returned by the import endpoint.
+type importSummary struct {
+	Inserted     int           `json:"inserted"`
+	Updated      int           `json:"updated"`
+	Rejected     int           `json:"rejected"`
+	RejectedRows []rejectedRow `json:"rejected_rows"`
+}
+
+func (s *importSummary) reject(row int, reason string) {
+	s.Rejected++
+	if len(s.RejectedRows) < maxReportedRejects {
+		s.RejectedRows = append(s.RejectedRows, rejectedRow{Row: row, Reason: reason})
+	}
+}
+
+// importFlightsHandler handles POST /api/admin/flights/import.
+// It accepts a multipart upload with the CSV in the "file" field and streams through it row by row,
+// upserting valid flights in batches so large files are never held in memory as a whole.
+func importFlightsHandler(dbClient db.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// MultipartReader (unlike ParseMultipartForm) lets us read the file part as a stream.
+		mr, err := r.MultipartReader()
+		if err != nil {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeNotMultipart, "Expected a multipart/form-data upload"))
+			return
+		}
+
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeMissingFile, fmt.Sprintf("Missing %q field with the CSV file", importFileFieldName)))
+				return
+			}
+			if err != nil {
+				httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeUnreadableBody, "Error reading multipart upload"))
+				return
+			}
+			if part.FormName() != importFileFieldName {
+				part.Close()
+				continue
+			}
+
+			summary, err := importFlightsCSV(r.Context(), dbClient, part)
+			part.Close()
+			if err != nil {
+				var badInput *csvFormatError
+				if errors.As(err, &badInput) {
+					httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidCSV, badInput.Error()))
+					return
+				}
+				slog.ErrorContext(r.Context(), "Flight import failed", "error", err)
+				httpapi.Write(w, r, &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodeImportFailed, Message: "Error importing flights"})
+				return
+			}
+
+			slog.InfoContext(r.Context(), "Flight import", "inserted", summary.Inserted, "updated", summary.Updated, "rejected", summary.Rejected)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(summary)
+			return
+		}
+	}
+}
+
+// statusForDBError maps the db package's error kinds onto HTTP status codes.
+func statusForDBError(err error) int {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// csvFormatError marks problems with the file itself (as opposed to individual rows or the database).
+type csvFormatError struct{ msg string }
+
+func (e *csvFormatError) Error() string { return e.msg }
+
+// importFlightsCSV reads flights from src and upserts them in batches of importBatchSize.
+// Rows that fail to parse or validate are skipped and recorded in the summary.
+func importFlightsCSV(ctx context.Context, dbClient db.Client, src io.Reader) (*importSummary, error) {
+	reader := csv.NewReader(src)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1 // Column count is checked per row so one short row doesn't abort the import.
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, &csvFormatError{"CSV file is empty"}
+	}
+	if err != nil {
+		return nil, &csvFormatError{fmt.Sprintf("Invalid CSV header: %v", err)}
+	}
+
+	// Map column names to their positions so the file may order columns freely.
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, col := range csvColumns {
+		if _, ok := index[col]; !ok {
+			return nil, &csvFormatError{fmt.Sprintf("CSV header is missing column %q", col)}
+		}
+	}
+
+	summary := &importSummary{RejectedRows: []rejectedRow{}}
+	batch := make([]db.Flight, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		res, err := dbClient.UpsertFlights(ctx, batch)
+		if err != nil {
+			return err
+		}
+		summary.Inserted += res.Inserted
+		summary.Updated += res.Updated
+		batch = batch[:0]
+		return nil
+	}
+
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				summary.reject(row, parseErr.Err.Error())
+				continue
+			}
+			return nil, err // The upload itself failed (e.g. too large or connection dropped).
+		}
+
+		flight, err := flightFromRecord(record, index)
+		if err == nil {
+			err = flight.Validate()
+		}
+		if err != nil {
+			summary.reject(row, err.Error())
+			continue
+		}
+
+		batch = append(batch, flight)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// flightFromRecord converts one CSV record into a Flight using the header index.
+func flightFromRecord(record []string, index map[string]int) (db.Flight, error) {
+	field := func(col string) string {
+		if i, ok := index[col]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	price, err := strconv.ParseFloat(field("price"), 64)
+	if err != nil {
+		return db.Flight{}, fmt.Errorf("price %q is not a number", field("price"))
+	}
+	seats, err := strconv.Atoi(field("available_seats"))
+	if err != nil {
+		return db.Flight{}, fmt.Errorf("available_seats %q is not an integer", field("available_seats"))
+	}
+
+	return db.Flight{
+		FlightNumber:   field("flight_number"),
+		Origin:         field("origin"),
+		Destination:    field("destination"),
+		DepartureTime:  field("departure_time"),
+		ArrivalTime:    field("arrival_time"),
+		Price:          price,
+		AvailableSeats: seats,
+
+		OriginAirport:      strings.ToUpper(field("origin_airport")),
+		DestinationAirport: strings.ToUpper(field("destination_airport")),
+	}, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// createFlightHandler serves POST /api/admin/flights: it creates one flight from the JSON body and
+// answers 201, or 409 if the flight number is taken. With ?upsert=true an existing flight is
+// overwritten instead (200); a new one is still created (201).
+func createFlightHandler(dbClient db.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		upsert := false
+		if raw := r.URL.Query().Get("upsert"); raw != "" {
+			var err error
+			if upsert, err = strconv.ParseBool(raw); err != nil {
+				httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidUpsert, "upsert must be true or false"))
+				return
+			}
+		}
+		flight, apiErr := decodeFlight(r, "")
+		if apiErr != nil {
+			httpapi.Write(w, r, apiErr)
+			return
+		}
+
+		status := http.StatusCreated
+		if upsert {
+			res, err := dbClient.UpsertFlights(r.Context(), []db.Flight{flight})
+			if err != nil {
+				writeFlightDBError(w, r, "upsert", flight.FlightNumber, err)
+				return
+			}
+			if res.Inserted == 0 {
+				status = http.StatusOK
+			}
+		} else if err := dbClient.CreateFlight(r.Context(), flight); err != nil {
+			writeFlightDBError(w, r, "create", flight.FlightNumber, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "Flight saved", "flight_number", flight.FlightNumber, "created", status == http.StatusCreated)
+		writeJSON(w, status, flight)
+	}
+}
+
+// updateFlightHandler serves PUT /api/admin/flights/{number}: it replaces an existing flight
+// with the JSON body (whose flight_number may be omitted but must match the path if given).
+func updateFlightHandler(dbClient db.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		number := r.PathValue("number")
+		flight, apiErr := decodeFlight(r, number)
+		if apiErr != nil {
+			httpapi.Write(w, r, apiErr)
+			return
+		}
+		if err := dbClient.UpdateFlight(r.Context(), flight); err != nil {
+			writeFlightDBError(w, r, "update", number, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "Flight updated", "flight_number", number)
+		writeJSON(w, http.StatusOK, flight)
+	}
+}
+
+// deleteFlightHandler serves DELETE /api/admin/flights/{number}: 204 when deleted, 404 if unknown.
+func deleteFlightHandler(dbClient db.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		number := r.PathValue("number")
+		if err := dbClient.DeleteFlight(r.Context(), number); err != nil {
+			writeFlightDBError(w, r, "delete", number, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "Flight deleted", "flight_number", number)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// seedHandler serves POST /api/admin/seed: it re-runs the sample data seeding (an upsert, so
+// edited sample flights are restored and nothing is duplicated) and answers 204.
+func seedHandler(dbClient db.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := dbClient.SeedFlights(r.Context()); err != nil {
+			slog.ErrorContext(r.Context(), "Seeding failed", "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodeSeedFailed, Message: "Error seeding flights"})
+			return
+		}
+		slog.InfoContext(r.Context(), "Sample flights re-seeded")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// decodeFlight reads and validates a flight from a JSON body. When pathNumber is set (PUT),
+// the body's flight_number defaults to it and must not contradict it.
+func decodeFlight(r *http.Request, pathNumber string) (db.Flight, *httpapi.Error) {
+	var flight db.Flight
+	dec := json.NewDecoder(r.Body) // Bounded by the route's httpmw.MaxBytes.
+	dec.DisallowUnknownFields()    // Catch misspelled fields instead of silently zeroing them.
+	if err := dec.Decode(&flight); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return flight, &httpapi.Error{Status: http.StatusRequestEntityTooLarge, Code: httpapi.CodeBodyTooLarge, Message: "Request body is too large"}
+		}
+		return flight, httpapi.BadRequest(httpapi.CodeMalformedJSON, "Request body is not a valid flight: "+err.Error())
+	}
+	if pathNumber != "" {
+		if flight.FlightNumber == "" {
+			flight.FlightNumber = pathNumber
+		}
+		if flight.FlightNumber != pathNumber {
+			return flight, httpapi.BadRequest(httpapi.CodeFlightNumberMismatch, "flight_number in the body does not match the URL")
+		}
+	}
+	if err := flight.Validate(); err != nil {
+		return flight, httpapi.BadRequest(httpapi.CodeInvalidFlight, err.Error())
+	}
+	return flight, nil
+}
+
+// writeFlightDBError maps a failed flight write onto its status: 404 (ErrNotFound), 409 (ErrConflict),
+// 503 (ErrUnavailable) or 500.
+func writeFlightDBError(w http.ResponseWriter, r *http.Request, action, number string, err error) {
+	status := statusForDBError(err)
+	apiErr := &httpapi.Error{Status: status, Code: action + "_failed", Message: "Error saving flight " + number}
+	switch status {
+	case http.StatusNotFound:
+		apiErr.Code, apiErr.Message = httpapi.CodeFlightNotFound, "Flight "+number+" does not exist"
+	case http.StatusConflict:
+		apiErr.Code, apiErr.Message = httpapi.CodeFlightExists, "Flight "+number+" already exists"
+	default:
+		slog.ErrorContext(r.Context(), "Flight write failed", "action", action, "flight_number", number, "error", err)
+	}
+	httpapi.Write(w, r, apiErr)
+}
+
+// writeJSON sends v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// adminFlights is the flight admin API with the listing, over a cached memory backend so the
+// tests see whether writes invalidate the search cache.
+type adminFlights struct {
+	mux   *http.ServeMux
+	store *db.MemoryClient
+}
+
+func newAdminFlights(t *testing.T) *adminFlights {
+	t.Helper()
+	store := db.NewMemoryClient()
+	if err := store.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cached := db.NewCachedClient(store, time.Hour)
+	keys := []string{"admin-key"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/admin/flights", requireAdmin(keys, createFlightHandler(cached)))
+	mux.HandleFunc("PUT /api/admin/flights/{number}", requireAdmin(keys, updateFlightHandler(cached)))
+	mux.HandleFunc("DELETE /api/admin/flights/{number}", requireAdmin(keys, deleteFlightHandler(cached)))
+	mux.HandleFunc("POST /api/admin/seed", requireAdmin(keys, seedHandler(cached)))
+	mux.HandleFunc("/api/flights", listFlightsHandler(cached))
+	return &adminFlights{mux: mux, store: store}
+}
+
+// do sends an admin request with the admin key and returns the response.
+func (a *adminFlights) do(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "admin-key")
+	rec := httptest.NewRecorder()
+	a.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// prices returns the price of each flight the listing finds from origin, by flight number.
+func (a *adminFlights) prices(t *testing.T, origin string) map[string]float64 {
+	t.Helper()
+	rec := a.do(http.MethodGet, "/api/flights?origin="+origin, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listing: status %d: %s", rec.Code, rec.Body)
+	}
+	var resp flightsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	prices := map[string]float64{}
+	for _, f := range resp.Flights {
+		prices[f.FlightNumber] = f.Price
+	}
+	return prices
+}
+
+// checkStatus fails the test unless rec has status and, for errors, code.
+func checkStatus(t *testing.T, what string, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("%s: status %d, want %d: %s", what, rec.Code, status, rec.Body)
+	}
+	if code != "" {
+		if got := errorCodeOf(rec); got != code {
+			t.Fatalf("%s: code %q, want %q", what, got, code)
+		}
+	}
+}
+
+// lisbon returns the JSON of flight LX900 at price.
+func lisbon(price string) string {
+	return fmt.Sprintf(`{"flight_number":"LX900","origin":"Lisbon","destination":"Rome",`+
+		`"departure_time":"2026-05-01T08:00:00Z","arrival_time":"2026-05-01T11:00:00Z","price":%s,"available_seats":20}`, price)
+}
+
+func TestAdminFlightLifecycle(t *testing.T) {
+	a := newAdminFlights(t)
+	// The listing caches an empty result for Lisbon; each write below must invalidate it.
+	if prices := a.prices(t, "Lisbon"); len(prices) != 0 {
+		t.Fatalf("Lisbon flights before any were created: %v", prices)
+	}
+
+	checkStatus(t, "create", a.do(http.MethodPost, "/api/admin/flights", lisbon("90")), http.StatusCreated, "")
+	if prices := a.prices(t, "Lisbon"); prices["LX900"] != 90 {
+		t.Errorf("after create: %v", prices)
+	}
+	checkStatus(t, "create again", a.do(http.MethodPost, "/api/admin/flights", lisbon("95")), http.StatusConflict, httpapi.CodeFlightExists)
+	checkStatus(t, "upsert existing", a.do(http.MethodPost, "/api/admin/flights?upsert=true", lisbon("95")), http.StatusOK, "")
+	if prices := a.prices(t, "Lisbon"); prices["LX900"] != 95 {
+		t.Errorf("after upsert: %v", prices)
+	}
+
+	update := a.do(http.MethodPut, "/api/admin/flights/LX900", strings.Replace(lisbon("110"), `"flight_number":"LX900",`, "", 1))
+	checkStatus(t, "update", update, http.StatusOK, "")
+	if !strings.Contains(update.Body.String(), `"flight_number":"LX900"`) {
+		t.Errorf("update answered %s, want the flight with the path's number", update.Body)
+	}
+	if prices := a.prices(t, "Lisbon"); prices["LX900"] != 110 {
+		t.Errorf("after update: %v", prices)
+	}
+
+	checkStatus(t, "delete", a.do(http.MethodDelete, "/api/admin/flights/LX900", ""), http.StatusNoContent, "")
+	if prices := a.prices(t, "Lisbon"); len(prices) != 0 {
+		t.Errorf("after delete: %v", prices)
+	}
+	checkStatus(t, "delete again", a.do(http.MethodDelete, "/api/admin/flights/LX900", ""), http.StatusNotFound, httpapi.CodeFlightNotFound)
+	checkStatus(t, "update deleted", a.do(http.MethodPut, "/api/admin/flights/LX900", lisbon("110")), http.StatusNotFound, httpapi.CodeFlightNotFound)
+	checkStatus(t, "upsert new", a.do(http.MethodPost, "/api/admin/flights?upsert=1", lisbon("80")), http.StatusCreated, "")
+}
+
+func TestAdminFlightSeedRestoresSamples(t *testing.T) {
+	a := newAdminFlights(t)
+	edited := `{"origin":"Madrid","destination":"Paris","departure_time":"2025-08-10T09:00:00Z",` +
+		`"arrival_time":"2025-08-10T11:00:00Z","price":999,"available_seats":50}`
+	checkStatus(t, "update", a.do(http.MethodPut, "/api/admin/flights/FL101", edited), http.StatusOK, "")
+	if prices := a.prices(t, "Madrid"); prices["FL101"] != 999 {
+		t.Fatalf("after update: %v", prices)
+	}
+	before := len(a.prices(t, "Madrid"))
+
+	checkStatus(t, "seed", a.do(http.MethodPost, "/api/admin/seed", ""), http.StatusNoContent, "")
+	prices := a.prices(t, "Madrid")
+	if prices["FL101"] != 120 || len(prices) != before {
+		t.Errorf("after seeding: %v, want FL101 restored and nothing duplicated", prices)
+	}
+}
+
+func TestAdminFlightErrors(t *testing.T) {
+	a := newAdminFlights(t)
+	for _, tt := range []struct {
+		name, method, path, body string
+		status                   int
+		code                     string
+	}{
+		{"bad upsert", http.MethodPost, "/api/admin/flights?upsert=maybe", lisbon("90"), http.StatusBadRequest, httpapi.CodeInvalidUpsert},
+		{"malformed JSON", http.MethodPost, "/api/admin/flights", `{"flight_number":`, http.StatusBadRequest, httpapi.CodeMalformedJSON},
+		{"unknown field", http.MethodPost, "/api/admin/flights", `{"flight_numbr":"LX900"}`, http.StatusBadRequest, httpapi.CodeMalformedJSON},
+		{"invalid flight", http.MethodPost, "/api/admin/flights", lisbon("-5"), http.StatusBadRequest, httpapi.CodeInvalidFlight},
+		{"number mismatch", http.MethodPut, "/api/admin/flights/FL101", lisbon("90"), http.StatusBadRequest, httpapi.CodeFlightNumberMismatch},
+	} {
+		rec := a.do(tt.method, tt.path, tt.body)
+		if rec.Code != tt.status || errorCodeOf(rec) != tt.code {
+			t.Errorf("%s: status %d, body %s; want %d %s", tt.name, rec.Code, rec.Body, tt.status, tt.code)
+		}
+	}
+
+	// Without the admin key nothing changes.
+	for _, key := range []string{"", "wrong-key"} {
+		req := httptest.NewRequest(http.MethodDelete, "/api/admin/flights/FL101", nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		a.mux.ServeHTTP(rec, req)
+		checkStatus(t, "key "+key, rec, http.StatusUnauthorized, httpapi.CodeUnauthorized)
+	}
+	if _, err := a.store.GetFlight(context.Background(), "FL101"); err != nil {
+		t.Errorf("FL101 after unauthorized deletes: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+)
+
+const csvHeader = "flight_number,origin,destination,departure_time,arrival_time,price,available_seats\n"
+
+// postCSV uploads csv to the import endpoint with the admin key key and returns the response.
+func postCSV(t *testing.T, h http.Handler, key, csv string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("note", "nightly feed") // Fields before the file are skipped
+	part, _ := mw.CreateFormFile(importFileFieldName, "flights.csv")
+	part.Write([]byte(csv))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/flights/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+key)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// importSummaryOf decodes a successful import's summary.
+func importSummaryOf(t *testing.T, rec *httptest.ResponseRecorder) importSummary {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var s importSummary
+	if err := json.NewDecoder(rec.Body).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// errorCodeOf returns the code of an error response.
+func errorCodeOf(rec *httptest.ResponseRecorder) string {
+	var body struct {
+		Error struct{ Code string }
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	return body.Error.Code
+}
+
+func newImportHandler() (http.Handler, *db.MemoryClient) {
+	store := db.NewMemoryClient()
+	return requireAdmin([]string{"admin-key"}, importFlightsHandler(store)), store
+}
+
+func TestImportValidFile(t *testing.T) {
+	h, store := newImportHandler()
+	csv := csvHeader +
+		"IB101,Madrid,Paris,2026-03-01T08:00:00Z,2026-03-01T10:00:00Z,120.50,30\n" +
+		"AF202,Paris,Rome,2026-03-02T09:00:00Z,2026-03-02T11:00:00Z,99,12\n"
+	s := importSummaryOf(t, postCSV(t, h, "admin-key", csv))
+	if s.Inserted != 2 || s.Updated != 0 || s.Rejected != 0 {
+		t.Errorf("summary = %+v, want 2 inserted", s)
+	}
+	f, err := store.GetFlight(context.Background(), "IB101")
+	if err != nil || f.Origin != "Madrid" || f.Price != 120.5 || f.AvailableSeats != 30 {
+		t.Errorf("IB101 = %+v, %v", f, err)
+	}
+}
+
+func TestImportColumnsInAnyOrder(t *testing.T) {
+	h, store := newImportHandler()
+	csv := "price,flight_number,destination,origin,available_seats,arrival_time,departure_time,origin_airport,destination_airport\n" +
+		"80,VY303,Barcelona,Madrid,5,2026-03-01T10:15:00Z,2026-03-01T09:00:00Z,mad,bcn\n"
+	if s := importSummaryOf(t, postCSV(t, h, "admin-key", csv)); s.Inserted != 1 {
+		t.Fatalf("summary = %+v, want 1 inserted", s)
+	}
+	f, _ := store.GetFlight(context.Background(), "VY303")
+	if f.Destination != "Barcelona" || f.OriginAirport != "MAD" || f.DestinationAirport != "BCN" {
+		t.Errorf("VY303 = %+v", f)
+	}
+}
+
+func TestImportBadRows(t *testing.T) {
+	h, _ := newImportHandler()
+	csv := csvHeader +
+		"IB101,Madrid,Paris,2026-03-01T08:00:00Z,2026-03-01T10:00:00Z,120,30\n" +
+		"IB102,Madrid,Paris,2026-03-01T08:00:00Z,2026-03-01T10:00:00Z,cheap,30\n" + // Row 3
+		"IB103,Madrid,Madrid,2026-03-01T08:00:00Z,2026-03-01T10:00:00Z,120,30\n" + // Row 4
+		"IB104,Madrid,Paris,tomorrow,2026-03-01T10:00:00Z,120,30\n" + // Row 5
+		"IB105,Madrid,Paris,2026-03-01T08:00:00Z,2026-03-01T10:00:00Z,120,-1\n" + // Row 6
+		"IB106,Madrid\n" + // Row 7
+		"IB107,Madrid,Paris,2026-03-01T08:00:00Z,2026-03-01T10:00:00Z,120,2.5\n" + // Row 8
+		"AF202,Paris,Rome,2026-03-02T09:00:00Z,2026-03-02T11:00:00Z,99,12\n"
+	s := importSummaryOf(t, postCSV(t, h, "admin-key", csv))
+	if s.Inserted != 2 || s.Rejected != 6 || len(s.RejectedRows) != 6 {
+		t.Fatalf("summary = %+v, want 2 inserted and 6 rejected", s)
+	}
+	for i, want := range []struct {
+		row    int
+		reason string
+	}{
+		{3, "price"},
+		{4, "origin and destination must differ"},
+		{5, "departure_time"},
+		{6, "available_seats must not be negative"},
+		{7, "price"}, // Short rows lack the columns
+		{8, "available_seats"},
+	} {
+		got := s.RejectedRows[i]
+		if got.Row != want.row || !strings.Contains(got.Reason, want.reason) {
+			t.Errorf("rejected row %d = %+v, want row %d for %q", i, got, want.row, want.reason)
+		}
+	}
+}
+
+func TestImportIsIdempotent(t *testing.T) {
+	h, store := newImportHandler()
+	csv := csvHeader +
+		"IB101,Madrid,Paris,2026-03-01T08:00:00Z,2026-03-01T10:00:00Z,120,30\n" +
+		"AF202,Paris,Rome,2026-03-02T09:00:00Z,2026-03-02T11:00:00Z,99,12\n"
+	importSummaryOf(t, postCSV(t, h, "admin-key", csv))
+	s := importSummaryOf(t, postCSV(t, h, "admin-key", strings.Replace(csv, ",120,30", ",95,28", 1)))
+	if s.Inserted != 0 || s.Updated != 2 {
+		t.Errorf("re-import summary = %+v, want 2 updated", s)
+	}
+	flights, _ := store.QueryFlights(context.Background(), db.FlightQuery{})
+	if len(flights) != 2 {
+		t.Errorf("%d flights after re-import, want 2", len(flights))
+	}
+	if f, _ := store.GetFlight(context.Background(), "IB101"); f.Price != 95 || f.AvailableSeats != 28 {
+		t.Errorf("IB101 = %+v, want the re-imported price and seats", f)
+	}
+}
+
+func TestImportLargeFileInBatches(t *testing.T) {
+	h, store := newImportHandler()
+	var csv strings.Builder
+	csv.WriteString(csvHeader)
+	const rows = 2*importBatchSize + 7
+	for i := range rows {
+		fmt.Fprintf(&csv, "XX%05d,Madrid,Paris,2026-03-01T08:00:00Z,2026-03-01T10:00:00Z,50,9\n", i)
+	}
+	if s := importSummaryOf(t, postCSV(t, h, "admin-key", csv.String())); s.Inserted != rows {
+		t.Errorf("summary = %+v, want %d inserted", s, rows)
+	}
+	if flights, _ := store.QueryFlights(context.Background(), db.FlightQuery{}); len(flights) != rows {
+		t.Errorf("%d flights stored, want %d", len(flights), rows)
+	}
+}
+
+func TestImportRejectsRequest(t *testing.T) {
+	h, _ := newImportHandler()
+	for _, tt := range []struct {
+		name   string
+		key    string
+		csv    string
+		status int
+		code   string
+	}{
+		{"no admin key", "", csvHeader, http.StatusUnauthorized, "unauthorized"},
+		{"wrong admin key", "guess", csvHeader, http.StatusUnauthorized, "unauthorized"},
+		{"empty file", "admin-key", "", http.StatusBadRequest, "invalid_csv"},
+		{"missing column", "admin-key", "flight_number,origin,destination\n", http.StatusBadRequest, "invalid_csv"},
+	} {
+		rec := postCSV(t, h, tt.key, tt.csv)
+		if code := errorCodeOf(rec); rec.Code != tt.status || code != tt.code {
+			t.Errorf("%s: status %d, code %q; want %d, %q", tt.name, rec.Code, code, tt.status, tt.code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/flights/import", strings.NewReader(csvHeader))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Set("X-API-Key", "admin-key")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if code := errorCodeOf(rec); rec.Code != http.StatusBadRequest || code != "not_multipart" {
+		t.Errorf("plain CSV body: status %d, code %q; want 400, not_multipart", rec.Code, code)
+	}
+}
+
+func TestStatusForDBError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{&db.OpError{Op: "get flight IB101", Kind: db.ErrNotFound, Err: db.ErrNotFound}, http.StatusNotFound},
+		{&db.OpError{Op: "create flight IB101", Kind: db.ErrConflict, Err: db.ErrConflict}, http.StatusConflict},
+		{&db.OpError{Op: "search flights", Kind: db.ErrUnavailable, Err: context.DeadlineExceeded}, http.StatusServiceUnavailable},
+		{fmt.Errorf("import: %w", &db.OpError{Op: "upsert flights", Kind: db.ErrUnavailable, Err: context.DeadlineExceeded}), http.StatusServiceUnavailable},
+		{&db.OpError{Op: "search flights", Err: errors.New("bad query")}, http.StatusInternalServerError},
+	} {
+		if got := statusForDBError(tt.err); got != tt.want {
+			t.Errorf("statusForDBError(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+// unavailableStore is a database that doesn't answer in time.
+type unavailableStore struct{ db.Client }
+
+func (unavailableStore) UpsertFlights(ctx context.Context, flights []db.Flight) (db.UpsertResult, error) {
+	return db.UpsertResult{}, &db.OpError{Op: "upsert flights", Kind: db.ErrUnavailable, Err: context.DeadlineExceeded}
+}
+
+func TestImportDatabaseUnavailable(t *testing.T) {
+	h := requireAdmin([]string{"admin-key"}, importFlightsHandler(unavailableStore{db.NewMemoryClient()}))
+	rec := postCSV(t, h, "admin-key", csvHeader+"IB101,Madrid,Paris,2026-03-01T08:00:00Z,2026-03-01T10:00:00Z,120,30\n")
+	if code := errorCodeOf(rec); rec.Code != http.StatusServiceUnavailable || code != "import_failed" {
+		t.Errorf("status %d, code %q; want 503, import_failed", rec.Code, code)
+	}
+}
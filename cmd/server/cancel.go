@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// cancelResponse is the body of a successful POST /api/cancel/{id}.
+type cancelResponse struct {
+	StreamID  string `json:"stream_id"`
+	Cancelled bool   `json:"cancelled"`
+}
+
+// cancelHandler serves POST /api/cancel/{id}: it stops the orchestration of a running request.
+// The request's stream then ends with a Done event whose outcome is "cancelled". The stream ID
+// is unguessable, so knowing it is what authorizes the call, as for GET /api/stream/{id}.
+func cancelHandler(running *activeRequests) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST, OPTIONS")
+			httpapi.Write(w, r, httpapi.MethodNotAllowed())
+			return
+		}
+		id := r.PathValue("id")
+		if !running.stop(id) {
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeNotRunning, Message: "No running request has this stream ID"})
+			return
+		}
+		slog.InfoContext(r.Context(), "Cancel requested", "stream", id)
+		writeJSON(w, http.StatusAccepted, cancelResponse{StreamID: id, Cancelled: true})
+	}
+}
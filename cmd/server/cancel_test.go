@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// cancelStream asks s to cancel the request streaming to id.
+func cancelStream(t *testing.T, s *testServer, id string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(s.url+"/api/cancel/"+id, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestCancelRunningRequest(t *testing.T) {
+	// Each mock call takes 2s, so the request would run for several seconds uncancelled.
+	s := startServer(t, "LLM_MOCK_LATENCY=2s")
+	resp := postChat(t, s, "What is the capital of France?", true)
+	defer resp.Body.Close()
+	reader := sse.NewReader(resp.Body)
+	started, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := started.Data
+	if started.Event != sse.TypeStarted || id == "" || resp.Header.Get("X-Stream-ID") != id {
+		t.Fatalf("first event %+v, X-Stream-ID %q; want Started with the stream ID", started, resp.Header.Get("X-Stream-ID"))
+	}
+
+	cancelled := time.Now()
+	cancel := cancelStream(t, s, id)
+	cancel.Body.Close()
+	if cancel.StatusCode != http.StatusAccepted {
+		t.Fatalf("cancel answered %d", cancel.StatusCode)
+	}
+	frames := readAll(t, reader)
+	if elapsed := time.Since(cancelled); elapsed > time.Second {
+		t.Errorf("stream ended %v after the cancel, want promptly", elapsed)
+	}
+	if len(frames) == 0 || frames[len(frames)-1].Event != sse.TypeDone || frames[len(frames)-1].Data != sse.OutcomeCancelled {
+		t.Errorf("stream ended with %+v, want a cancelled Done", frames)
+	}
+	for _, frame := range frames {
+		if frame.Event == sse.TypeMessage || frame.Event == sse.TypeError {
+			t.Errorf("%s event after the cancel: %q", frame.Event, frame.Data)
+		}
+	}
+
+	// The finished request is no longer registered.
+	again := cancelStream(t, s, id)
+	defer again.Body.Close()
+	if again.StatusCode != http.StatusNotFound || errorCode(t, again) != httpapi.CodeNotRunning {
+		t.Errorf("second cancel answered %d", again.StatusCode)
+	}
+}
+
+func TestCancelFinishedOrUnknown(t *testing.T) {
+	s := startServer(t)
+	resp := postChat(t, s, "What is the capital of France?", true)
+	defer resp.Body.Close()
+	if frames := readAll(t, sse.NewReader(resp.Body)); frames[len(frames)-1].Data != sse.OutcomeOK {
+		t.Fatalf("stream ended with %+v", frames[len(frames)-1])
+	}
+	// The registry entry goes once the orchestration ends, so a late cancel finds nothing.
+	for _, id := range []string{resp.Header.Get("X-Stream-ID"), "0123456789abcdef0123456789abcdef"} {
+		cancel := cancelStream(t, s, id)
+		if cancel.StatusCode != http.StatusNotFound || errorCode(t, cancel) != httpapi.CodeNotRunning {
+			t.Errorf("cancel of %s answered %d", id, cancel.StatusCode)
+		}
+		cancel.Body.Close()
+	}
+
+	get, err := http.Get(s.url + "/api/cancel/" + resp.Header.Get("X-Stream-ID"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get.Body.Close()
+	if get.StatusCode != http.StatusMethodNotAllowed || get.Header.Get("Allow") != "POST, OPTIONS" {
+		t.Errorf("GET answered %d, Allow %q", get.StatusCode, get.Header.Get("Allow"))
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/orchestrator"
+	"github.com/Cris245/go-llm-chat/internal/ratelimit"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// serverSlots is the key under which chatCapacity's limiter counts every orchestration: the
+// server's concurrency limit is a per-client stream cap with a single client.
+const serverSlots = "server"
+
+// chatCapacity bounds the orchestrations running at once across all clients
+// (MAX_CONCURRENT_CHATS), queueing the requests over the limit or rejecting them.
+type chatCapacity struct {
+	limiter  *ratelimit.Limiter
+	max      int
+	maxQueue int // 0 without queueing
+}
+
+// newChatCapacity returns the concurrency limit of cfg; a zero limit lets every request through.
+func newChatCapacity(cfg config.Server) *chatCapacity {
+	c := &chatCapacity{max: cfg.MaxConcurrentChats}
+	if cfg.ChatQueue {
+		c.maxQueue = cfg.ChatMaxQueue
+	}
+	c.limiter = ratelimit.New(ratelimit.Config{MaxConcurrent: c.max, Queue: cfg.ChatQueue, MaxQueue: cfg.ChatMaxQueue})
+	return c
+}
+
+// tryAcquire takes a slot without waiting. It reports whether one was taken; false means the
+// request must wait for one (see wait). A request that can't queue gets a 503 rejection.
+func (c *chatCapacity) tryAcquire() (bool, *httpapi.Error) {
+	acquired, err := c.limiter.TryAcquire(serverSlots)
+	if err != nil {
+		return false, &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeServerBusy, Message: "The server is at capacity; try again shortly", RetryAfter: time.Second}
+	}
+	return acquired, nil
+}
+
+// release frees a slot taken by tryAcquire or wait.
+func (c *chatCapacity) release() {
+	c.limiter.Release(serverSlots)
+}
+
+// load returns the orchestrations holding a slot and the requests waiting for one.
+func (c *chatCapacity) load() (running, queued int) {
+	return c.limiter.Load(serverSlots)
+}
+
+// chatLoad is the concurrency limit's state, as reported by GET /readyz.
+type chatLoad struct {
+	Running       int `json:"running"`
+	Queued        int `json:"queued"`
+	MaxConcurrent int `json:"max_concurrent"`      // 0 is unlimited
+	MaxQueue      int `json:"max_queue,omitempty"` // Left out without queueing
+}
+
+// status returns the limit's state for /readyz, or nil if there is no limit.
+func (c *chatCapacity) status() *chatLoad {
+	if c == nil || c.max == 0 {
+		return nil
+	}
+	running, queued := c.load()
+	return &chatLoad{Running: running, Queued: queued, MaxConcurrent: c.max, MaxQueue: c.maxQueue}
+}
+
+// waitQueued waits for a slot of limiter for key, telling the client its position in the queue
+// with Status events. If the request is cancelled or the wait fails, it ends the stream with
+// the outcome and returns false; full is the error code of a queue that is full.
+func waitQueued(ctx context.Context, limiter *ratelimit.Limiter, key, full, lang string, eventChan chan<- sse.Event) bool {
+	err := limiter.Acquire(ctx, key, func(position int) {
+		eventChan <- sse.Status(i18n.T(lang, "status.queued", position))
+	})
+	if errors.Is(context.Cause(ctx), orchestrator.ErrCancelled) {
+		if err == nil {
+			limiter.Release(key)
+		}
+		eventChan <- sse.Done(sse.DonePayload{Outcome: sse.OutcomeCancelled})
+		return false
+	}
+	if err != nil {
+		code := "queue_timeout"
+		if errors.Is(err, ratelimit.ErrTooManyStreams) {
+			code = full
+		}
+		eventChan <- sse.Error(code, i18n.T(lang, "error.not_started", err))
+		eventChan <- sse.Done(sse.DonePayload{Outcome: sse.OutcomeError, Error: err.Error()})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestChatCapacity(t *testing.T) {
+	c := newChatCapacity(config.Server{MaxConcurrentChats: 1, ChatMaxQueue: 5})
+	if ok, err := c.tryAcquire(); !ok || err != nil {
+		t.Fatalf("first slot = %v, %v", ok, err)
+	}
+	// Without queueing, a request over the limit is turned away with 503.
+	ok, err := c.tryAcquire()
+	if ok || err == nil || err.Status != http.StatusServiceUnavailable || err.Code != httpapi.CodeServerBusy || err.RetryAfter != time.Second {
+		t.Errorf("over the limit = %v, %+v", ok, err)
+	}
+	if load := c.status(); *load != (chatLoad{Running: 1, MaxConcurrent: 1}) {
+		t.Errorf("status %+v", load)
+	}
+	c.release()
+	if ok, _ := c.tryAcquire(); !ok {
+		t.Error("no slot after a release")
+	}
+
+	// With queueing, it waits instead.
+	c = newChatCapacity(config.Server{MaxConcurrentChats: 1, ChatQueue: true, ChatMaxQueue: 5})
+	c.tryAcquire()
+	if ok, err := c.tryAcquire(); ok || err != nil {
+		t.Errorf("over the limit with a queue = %v, %+v", ok, err)
+	}
+	if load := c.status(); *load != (chatLoad{Running: 1, MaxConcurrent: 1, MaxQueue: 5}) {
+		t.Errorf("status with a queue %+v", load)
+	}
+
+	// No limit, no load to report.
+	c = newChatCapacity(config.Server{})
+	for range 10 {
+		if ok, err := c.tryAcquire(); !ok || err != nil {
+			t.Fatalf("unlimited = %v, %+v", ok, err)
+		}
+	}
+	if load := c.status(); load != nil {
+		t.Errorf("unlimited status %+v", load)
+	}
+}
+
+// loadOf returns the load /readyz reports.
+func loadOf(t *testing.T, s *testServer) *chatLoad {
+	t.Helper()
+	resp, err := http.Get(s.url + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var ready readyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ready); err != nil {
+		t.Fatal(err)
+	}
+	return ready.Load
+}
+
+// waitForStatus reads reader's frames up to the Status event status, and returns them.
+func waitForStatus(t *testing.T, reader *sse.Reader, status string) []sse.Frame {
+	t.Helper()
+	var frames []sse.Frame
+	for {
+		frame, err := reader.Next()
+		if err != nil {
+			t.Fatalf("no %q status in %+v: %v", status, frames, err)
+		}
+		frames = append(frames, frame)
+		if frame.Event == sse.TypeStatus && frame.Data == status {
+			return frames
+		}
+	}
+}
+
+// queuePositions returns the queue positions frames tell.
+func queuePositions(frames []sse.Frame) []string {
+	var positions []string
+	for _, f := range frames {
+		if f.Event == sse.TypeStatus && strings.HasPrefix(f.Data, "Queued (") {
+			positions = append(positions, f.Data)
+		}
+	}
+	return positions
+}
+
+func TestCapacityRejects(t *testing.T) {
+	s := startServer(t, "MAX_CONCURRENT_CHATS=1", "LLM_MOCK_LATENCY=500ms")
+	first := postChat(t, s, "What is the capital of France?", true)
+	defer first.Body.Close()
+	reader := sse.NewReader(first.Body)
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if load := loadOf(t, s); load == nil || *load != (chatLoad{Running: 1, MaxConcurrent: 1}) {
+		t.Errorf("readyz load %+v", load)
+	}
+
+	second := postChat(t, s, "What is the capital of Spain?", false)
+	if second.StatusCode != http.StatusServiceUnavailable || second.Header.Get("Retry-After") != "1" {
+		t.Errorf("over the limit: %d, Retry-After %q", second.StatusCode, second.Header.Get("Retry-After"))
+	}
+	if code := errorCode(t, second); code != httpapi.CodeServerBusy {
+		t.Errorf("over the limit: code %q", code)
+	}
+	second.Body.Close()
+
+	// The slot frees up when the first ends.
+	if frames := readAll(t, reader); len(frames) == 0 || frames[len(frames)-1].Data != sse.OutcomeOK {
+		t.Errorf("first stream ended with %+v", frames)
+	}
+	third := postChat(t, s, "What is the capital of Spain?", false)
+	third.Body.Close()
+	if third.StatusCode != http.StatusOK {
+		t.Errorf("request after the first ended answered %d", third.StatusCode)
+	}
+}
+
+func TestCapacityQueues(t *testing.T) {
+	s := startServer(t, "MAX_CONCURRENT_CHATS=1", "CHAT_QUEUE=true", "CHAT_MAX_QUEUE=2", "LLM_MOCK_LATENCY=500ms")
+	first := postChat(t, s, "What is the capital of France?", true)
+	defer first.Body.Close()
+	firstReader := sse.NewReader(first.Body)
+	if _, err := firstReader.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next two wait their turn, in the order they came.
+	second := postChat(t, s, "What is the capital of Spain?", true)
+	defer second.Body.Close()
+	secondReader := sse.NewReader(second.Body)
+	secondFrames := waitForStatus(t, secondReader, "Queued (position 1)")
+	third := postChat(t, s, "What is the capital of Italy?", true)
+	defer third.Body.Close()
+	thirdReader := sse.NewReader(third.Body)
+	thirdFrames := waitForStatus(t, thirdReader, "Queued (position 2)")
+	if load := loadOf(t, s); load == nil || *load != (chatLoad{Running: 1, Queued: 2, MaxConcurrent: 1, MaxQueue: 2}) {
+		t.Errorf("readyz load %+v", load)
+	}
+
+	// With the queue full too, the next is turned away.
+	fourth := postChat(t, s, "What is the capital of Portugal?", true)
+	if fourth.StatusCode != http.StatusServiceUnavailable || errorCode(t, fourth) != httpapi.CodeServerBusy {
+		t.Errorf("with the queue full: %d", fourth.StatusCode)
+	}
+	fourth.Body.Close()
+
+	// The first ending lets the second in, which moves the third up; each is answered.
+	readAll(t, firstReader)
+	secondFrames = append(secondFrames, readAll(t, secondReader)...)
+	thirdFrames = append(thirdFrames, readAll(t, thirdReader)...)
+	if got := queuePositions(secondFrames); !slices.Equal(got, []string{"Queued (position 1)"}) {
+		t.Errorf("second told %v", got)
+	}
+	if got := queuePositions(thirdFrames); !slices.Equal(got, []string{"Queued (position 2)", "Queued (position 1)"}) {
+		t.Errorf("third told %v", got)
+	}
+	for i, frames := range [][]sse.Frame{secondFrames, thirdFrames} {
+		if last := frames[len(frames)-1]; last.Event != sse.TypeDone || last.Data != sse.OutcomeOK {
+			t.Errorf("queued request %d ended with %+v", i+2, frames)
+		}
+	}
+	// The slots are released just after the streams end.
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		load := loadOf(t, s)
+		if load != nil && *load == (chatLoad{MaxConcurrent: 1, MaxQueue: 2}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("readyz load after the requests %+v", load)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/pkg/chatclient"
+)
+
+func TestChatClient(t *testing.T) {
+	s := startServer(t)
+	ctx := context.Background()
+	client := chatclient.New(s.url, chatclient.WithAPIKey("client-key"))
+
+	// The typed events of a flight question, as the server streams them.
+	events, err := client.Chat(ctx, chatclient.Request{Message: "Show me flights from Madrid to Paris", SessionID: "trip-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flights []chatclient.Flight
+	var last chatclient.Event
+	for e := range events {
+		if results, ok := e.(chatclient.FlightResults); ok {
+			flights = append(flights, results.Flights...)
+		}
+		last = e
+	}
+	if done, ok := last.(chatclient.Done); !ok || done.Outcome != chatclient.OutcomeOK || done.Telemetry == nil || done.Telemetry.ResultCount != len(flights) || len(flights) != 4 {
+		t.Errorf("last event %#v after %d flights", last, len(flights))
+	}
+
+	answer, telemetry, err := client.CollectAnswer(ctx, chatclient.Request{Message: "What is the capital of France?", SessionID: "trip-1"})
+	if err != nil || answer == "" || telemetry.Intent != "general" || telemetry.RequestID == "" {
+		t.Errorf("CollectAnswer = %q, %+v, %v", answer, telemetry, err)
+	}
+
+	// The REST calls, on the conversation the questions made.
+	sessions, err := client.ListSessions(ctx, 0)
+	if err != nil || len(sessions) != 1 || sessions[0].SessionID != "trip-1" || sessions[0].TurnCount != 4 {
+		t.Fatalf("ListSessions = %+v, %v", sessions, err)
+	}
+	if err := client.RenameSession(ctx, "trip-1", "Paris in May"); err != nil {
+		t.Error(err)
+	}
+	if md, err := client.ExportSession(ctx, "trip-1", "md"); err != nil || !strings.Contains(string(md), "Paris in May") {
+		t.Errorf("ExportSession = %q, %v", md, err)
+	}
+	page, err := client.ListFlights(ctx, chatclient.FlightQuery{Origin: "Madrid", Destination: "Paris", Sort: "price", Limit: 1})
+	if err != nil || page.Total != 4 || len(page.Flights) != 1 || page.Flights[0].FlightNumber != "FL103" {
+		t.Errorf("ListFlights = %+v, %v", page, err)
+	}
+
+	// Another key doesn't see the conversation.
+	var apiErr *chatclient.APIError
+	_, err = chatclient.New(s.url, chatclient.WithAPIKey("other-key")).ExportSession(ctx, "trip-1", "md")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Code != httpapi.CodeSessionNotFound || apiErr.RequestID == "" {
+		t.Errorf("another key's export: %#v", err)
+	}
+	if _, _, err := client.CollectAnswer(ctx, chatclient.Request{Message: ""}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("empty message: %#v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/persona"
+	"github.com/Cris245/go-llm-chat/internal/version"
+)
+
+// Outcomes of a check. Only checkFail fails the preflight and readiness.
+const (
+	checkPass = "pass"
+	checkWarn = "warn" // The server runs, but something needs attention, e.g. an incomplete catalog
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// llmCheckTimeout bounds each LLM slot's check call.
+const llmCheckTimeout = 30 * time.Second
+
+// checkResult is the outcome of one check, as printed by -check and returned by /readyz.
+type checkResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func passed(name, format string, args ...any) checkResult {
+	return checkResult{Name: name, Status: checkPass, Detail: fmt.Sprintf(format, args...)}
+}
+
+func failed(name string, err error) checkResult {
+	return checkResult{Name: name, Status: checkFail, Detail: err.Error()}
+}
+
+// anyFailed reports whether a check failed.
+func anyFailed(results []checkResult) bool {
+	for _, r := range results {
+		if r.Status == checkFail {
+			return true
+		}
+	}
+	return false
+}
+
+// runPreflight runs every check against the configuration (the -check flag) and writes the
+// report to out. It reports whether all checks passed. It connects to the database and calls
+// the LLMs as the server would, but doesn't serve or seed anything.
+func runPreflight(cfg *config.Config, out io.Writer) bool {
+	results := []checkResult{passed("config", "valid")}
+	results = append(results, checkPrompts(cfg)...)
+	results = append(results, checkTLS(cfg.Server.TLS, time.Now()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DB.ConnectTimeout)
+	store, err := connectStore(ctx, cfg.DB)
+	cancel()
+	if err != nil {
+		results = append(results, failed("database", err),
+			checkResult{Name: "seed data", Status: checkSkip, Detail: "no database connection"},
+			checkResult{Name: "indexes", Status: checkSkip, Detail: "no database connection"},
+			checkResult{Name: "migrations", Status: checkSkip, Detail: "no database connection"})
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.DB.ConnectTimeout)
+		results = append(results, databaseChecks(ctx, store, cfg.DB.Backend)...)
+		cancel()
+		store.Disconnect(context.Background())
+	}
+
+	for _, slot := range append(cfg.LLM.Slots(), cfg.LLM.RouteSlots()...) {
+		if cfg.Orchestrator.DBOnly() {
+			results = append(results, checkResult{Name: slot.Name, Status: checkSkip, Detail: "db-only mode"})
+			continue
+		}
+		results = append(results, checkLLM(cfg.LLM, slot, cfg.SkipLLM))
+	}
+
+	writeReport(out, results)
+	return !anyFailed(results)
+}
+
+// checkTLS loads the certificate files of cfg, as the server would at startup, and warns about
+// a certificate that has expired by now. Automatic certificates are obtained on the first
+// connections, so they can't be checked beforehand.
+func checkTLS(cfg config.TLS, now time.Time) checkResult {
+	switch {
+	case !cfg.Enabled():
+		return checkResult{Name: "tls", Status: checkSkip, Detail: "plain HTTP"}
+	case cfg.Autocert():
+		return passed("tls", "automatic certificates for %s", strings.Join(cfg.AutocertHosts, ", "))
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return failed("tls", err)
+	}
+	if now.After(cert.Leaf.NotAfter) {
+		return checkResult{Name: "tls", Status: checkWarn, Detail: fmt.Sprintf("the certificate expired on %s", cert.Leaf.NotAfter.Format(time.DateOnly))}
+	}
+	return passed("tls", "certificate for %s, valid until %s", strings.Join(cert.Leaf.DNSNames, ", "), cert.Leaf.NotAfter.Format(time.DateOnly))
+}
+
+// reportInvalidConfig writes the -check report of a configuration that doesn't load.
+func reportInvalidConfig(out io.Writer, err error) {
+	writeReport(out, []checkResult{failed("config", err)})
+}
+
+// writeReport prints one line per check, then the verdict.
+func writeReport(out io.Writer, results []checkResult) {
+	fmt.Fprintf(out, "go-llm-chat %s preflight check\n", version.Version)
+	width := 0
+	for _, r := range results {
+		width = max(width, len(r.Name))
+	}
+	failures := 0
+	for _, r := range results {
+		if r.Status == checkFail {
+			failures++
+		}
+		// Multi-line details (e.g. every invalid setting) are indented under their check.
+		detail := strings.ReplaceAll(r.Detail, "\n", "\n"+strings.Repeat(" ", width+10))
+		fmt.Fprintf(out, "  %-4s  %-*s  %s\n", strings.ToUpper(r.Status), width, r.Name, detail)
+	}
+	if failures > 0 {
+		fmt.Fprintf(out, "FAIL: %d of %d checks failed\n", failures, len(results))
+		return
+	}
+	fmt.Fprintln(out, "PASS")
+}
+
+// connectStore connects to the configured database backend, as main does.
+func connectStore(ctx context.Context, cfg config.DB) (db.Client, error) {
+	if cfg.Backend == config.BackendMemory {
+		return db.NewMemoryClient(), nil
+	}
+	return db.NewClient(ctx, cfg.MongoURI, cfg.Mongo())
+}
+
+// databaseChecks runs a trivial query against store, looks for the flight data and, for
+// backends with indexes, confirms them, and looks for pending migrations. /readyz runs them
+// on every probe.
+func databaseChecks(ctx context.Context, store db.Client, backend string) []checkResult {
+	flights, err := store.QueryFlights(ctx, db.FlightQuery{})
+	if err != nil {
+		return []checkResult{failed("database", err),
+			{Name: "seed data", Status: checkSkip, Detail: "the database query failed"},
+			{Name: "indexes", Status: checkSkip, Detail: "the database query failed"},
+			{Name: "migrations", Status: checkSkip, Detail: "the database query failed"}}
+	}
+	results := []checkResult{passed("database", "%s backend answers queries", backend)}
+
+	schedules, err := store.ListSchedules(ctx)
+	switch {
+	case err != nil:
+		results = append(results, failed("seed data", err))
+	case len(flights) == 0 && len(schedules) == 0:
+		// Not a failure: the server seeds sample flights when it starts with an empty database.
+		results = append(results, checkResult{Name: "seed data", Status: checkWarn,
+			Detail: "no flights or schedules; sample flights are seeded when the server starts"})
+	default:
+		results = append(results, passed("seed data", "%d flights, %d schedules", len(flights), len(schedules)))
+	}
+
+	if checker, ok := store.(db.IndexChecker); ok {
+		if err := checker.CheckIndexes(ctx); err != nil {
+			results = append(results, failed("indexes", err))
+		} else {
+			results = append(results, passed("indexes", "present"))
+		}
+	} else {
+		results = append(results, checkResult{Name: "indexes", Status: checkSkip, Detail: "the " + backend + " backend has none"})
+	}
+
+	pending, err := db.Migrate(ctx, store, db.Migrations(), db.MigrateOptions{DryRun: true})
+	switch {
+	case err != nil:
+		results = append(results, failed("migrations", err))
+	case len(pending) > 0:
+		// Not a failure either: the server applies them when it starts.
+		results = append(results, checkResult{Name: "migrations", Status: checkWarn,
+			Detail: fmt.Sprintf("%d pending; they are applied when the server starts", len(pending))})
+	default:
+		results = append(results, passed("migrations", "up to date"))
+	}
+	return results
+}
+
+// checkPrompts renders the persona's prompt templates and loads the message catalogs.
+// Catalog problems are warnings, as at startup: missing texts fall back to English.
+func checkPrompts(cfg *config.Config) []checkResult {
+	var results []checkResult
+	if !cfg.Persona.Enabled() {
+		results = append(results, checkResult{Name: "persona", Status: checkSkip, Detail: "not configured"})
+	} else if _, err := persona.New(cfg.Persona.Settings()); err != nil {
+		results = append(results, failed("persona", err))
+	} else {
+		results = append(results, passed("persona", "prompt templates render"))
+	}
+
+	if cfg.I18nDir != "" {
+		if err := i18n.LoadDir(cfg.I18nDir); err != nil {
+			return append(results, failed("catalogs", err))
+		}
+	}
+	if problems := i18n.Problems(); len(problems) > 0 {
+		return append(results, checkResult{Name: "catalogs", Status: checkWarn, Detail: strings.Join(problems, "\n")})
+	}
+	return append(results, passed("catalogs", "languages %s", strings.Join(i18n.Languages(), ", ")))
+}
+
+// checkLLM asks slot's model for a one-token answer, without the server's retries, so a bad
+// key or model name shows up as the provider's error.
+func checkLLM(cfg config.LLM, slot config.NamedSlot, skip bool) checkResult {
+	if skip {
+		return checkResult{Name: slot.Name, Status: checkSkip, Detail: "-skip-llm"}
+	}
+	client, err := llmclient.New(llmclient.ProviderConfig{
+		Provider:    slot.Provider,
+		Model:       slot.Model,
+		APIKey:      cfg.APIKey,
+		Signer:      requestSigner(cfg.Signing),
+		MockLatency: cfg.MockLatency,
+	})
+	if err != nil {
+		return failed(slot.Name, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), llmCheckTimeout)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.ChatCompletion(llmclient.WithMaxTokens(ctx, 1), "Reply with OK."); err != nil {
+		return failed(slot.Name, fmt.Errorf("%s %s: %w", slot.Provider, slot.Model, err))
+	}
+	return passed(slot.Name, "%s %s answered in %s", slot.Provider, slot.Model, time.Since(start).Round(time.Millisecond))
+}
+
+// readyResponse is the body of GET /readyz.
+type readyResponse struct {
+	Status string        `json:"status"` // "ready" or "not_ready"
+	Checks []checkResult `json:"checks"`
+	Load   *chatLoad     `json:"load,omitempty"` // With MAX_CONCURRENT_CHATS
+}
+
+// readyHandler serves GET /readyz: the database checks of -check against the server's own
+// connection, with 503 Service Unavailable if one fails. The LLM checks are left out, since
+// a probe every few seconds would be billed. The load under the server's concurrency limit is
+// reported too, but doesn't make the server unready: requests over it are queued or rejected.
+func readyHandler(store db.Client, backend string, timeout time.Duration, capacity *chatCapacity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		resp := readyResponse{Status: "ready", Checks: databaseChecks(ctx, store, backend), Load: capacity.status()}
+		status := http.StatusOK
+		if anyFailed(resp.Checks) {
+			resp.Status, status = "not_ready", http.StatusServiceUnavailable
+			slog.WarnContext(r.Context(), "Readiness check failed", "checks", resp.Checks)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
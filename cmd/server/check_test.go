@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/db"
+)
+
+// checkConfig loads the configuration of -check with env over the in-memory backends.
+func checkConfig(t *testing.T, env ...string) *config.Config {
+	t.Helper()
+	vars := map[string]string{"DB_BACKEND": "memory", "LLM_PROVIDER": "mock"}
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		vars[k] = v
+	}
+	cfg, err := config.Load([]string{"-check"}, func(k string) string { return vars[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// reportStatus returns the status a report gave the named check, or "" if it wasn't run.
+func reportStatus(report, name string) string {
+	m := regexp.MustCompile(`(?m)^  (PASS|WARN|FAIL|SKIP)  ` + regexp.QuoteMeta(name) + ` `).FindStringSubmatch(report)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[1])
+}
+
+func TestPreflight(t *testing.T) {
+	var out bytes.Buffer
+	if !runPreflight(checkConfig(t), &out) {
+		t.Fatalf("preflight failed:\n%s", out.String())
+	}
+	report := out.String()
+	for name, want := range map[string]string{
+		"config":     checkPass,
+		"persona":    checkSkip,
+		"catalogs":   checkPass,
+		"tls":        checkSkip,
+		"database":   checkPass,
+		"seed data":  checkWarn, // The memory backend starts empty
+		"indexes":    checkSkip,
+		"migrations": checkWarn,
+		"llm1":       checkPass,
+		"llm2":       checkPass,
+		"llm3":       checkPass,
+	} {
+		if got := reportStatus(report, name); got != want {
+			t.Errorf("%s: %q, want %q\n%s", name, got, want, report)
+		}
+	}
+	if !strings.HasSuffix(report, "\nPASS\n") {
+		t.Errorf("report doesn't end with the verdict:\n%s", report)
+	}
+}
+
+func TestPreflightSkipLLM(t *testing.T) {
+	cfg := checkConfig(t)
+	cfg.SkipLLM = true
+	var out bytes.Buffer
+	if !runPreflight(cfg, &out) {
+		t.Fatalf("preflight failed:\n%s", out.String())
+	}
+	for _, slot := range []string{"llm1", "llm2", "llm3"} {
+		if got := reportStatus(out.String(), slot); got != checkSkip {
+			t.Errorf("%s: %q, want skipped", slot, got)
+		}
+	}
+}
+
+func TestPreflightDBOnly(t *testing.T) {
+	var out bytes.Buffer
+	if !runPreflight(checkConfig(t, "ORCH_MODE=db-only", "LLM_PROVIDER=openai"), &out) {
+		t.Fatalf("preflight failed without an API key in db-only mode:\n%s", out.String())
+	}
+	for _, slot := range []string{"llm1", "llm2", "llm3"} {
+		if got := reportStatus(out.String(), slot); got != checkSkip {
+			t.Errorf("%s: %q, want skipped", slot, got)
+		}
+	}
+}
+
+func TestPreflightFailures(t *testing.T) {
+	catalogs := t.TempDir()
+	if err := os.WriteFile(filepath.Join(catalogs, "es.json"), []byte(`{"message.greeting": `), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := checkConfig(t,
+		"DB_BACKEND=mongo", "MONGO_URI=mongodb://127.0.0.1:1", "DB_CONNECT_TIMEOUT=300ms", "I18N_DIR="+catalogs)
+	cfg.SkipLLM = true
+	var out bytes.Buffer
+	if runPreflight(cfg, &out) {
+		t.Fatalf("preflight passed:\n%s", out.String())
+	}
+	report := out.String()
+	for name, want := range map[string]string{
+		"catalogs":   checkFail,
+		"database":   checkFail,
+		"seed data":  checkSkip,
+		"indexes":    checkSkip,
+		"migrations": checkSkip,
+	} {
+		if got := reportStatus(report, name); got != want {
+			t.Errorf("%s: %q, want %q\n%s", name, got, want, report)
+		}
+	}
+	if !strings.HasSuffix(report, "\nFAIL: 2 of 11 checks failed\n") {
+		t.Errorf("verdict:\n%s", report)
+	}
+
+	// A configuration that doesn't load is reported as the one failed check.
+	cfg, err := config.Load([]string{"-check"}, func(k string) string {
+		return map[string]string{"DB_BACKEND": "memory", "PERSONA_PROMPT": "You are {{.Name}}."}[k]
+	})
+	if err == nil || cfg == nil || !cfg.Check {
+		t.Fatalf("broken persona loaded: %v", err)
+	}
+	out.Reset()
+	reportInvalidConfig(&out, err)
+	if report := out.String(); reportStatus(report, "config") != checkFail ||
+		!strings.Contains(report, ".Name") || !strings.HasSuffix(report, "FAIL: 1 of 1 checks failed\n") {
+		t.Errorf("invalid configuration report:\n%s", report)
+	}
+}
+
+func TestCheckTLS(t *testing.T) {
+	if r := checkTLS(config.TLS{}, time.Now()); r.Status != checkSkip {
+		t.Errorf("plain HTTP: %+v", r)
+	}
+	if r := checkTLS(config.TLS{AutocertHosts: []string{"chat.example.com"}}, time.Now()); r.Status != checkPass {
+		t.Errorf("automatic certificates: %+v", r)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+	if r := checkTLS(config.TLS{CertFile: missing, KeyFile: missing}, time.Now()); r.Status != checkFail {
+		t.Errorf("missing certificate: %+v", r)
+	}
+}
+
+// brokenStore is a database whose queries fail.
+type brokenStore struct{ db.Client }
+
+func (brokenStore) QueryFlights(context.Context, db.FlightQuery) ([]db.Flight, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestReadyz(t *testing.T) {
+	var store db.Client = db.NewMemoryClient()
+	ready := func() (int, readyResponse) {
+		rec := httptest.NewRecorder()
+		readyHandler(store, config.BackendMemory, time.Second, nil)(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp readyResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, resp
+	}
+	code, resp := ready()
+	if code != http.StatusOK || resp.Status != "ready" || len(resp.Checks) != 4 {
+		t.Errorf("empty database: %d %+v", code, resp)
+	}
+	if err := store.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, resp := ready(); resp.Checks[1].Status != checkPass {
+		t.Errorf("seeded database: %+v", resp.Checks)
+	}
+
+	store = brokenStore{store}
+	if code, resp := ready(); code != http.StatusServiceUnavailable || resp.Status != "not_ready" || resp.Checks[0].Status != checkFail {
+		t.Errorf("broken database: %d %+v", code, resp)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+func TestErrorResponses(t *testing.T) {
+	s := startServer(t, "ADMIN_API_KEYS=admin-key", "RATE_LIMIT_RPS=0.01", "RATE_LIMIT_BURST=8")
+	do := func(method, path, contentType, body string, header ...string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, s.url+path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Every failure class gets the same body, whose request ID is the header's.
+	for _, tt := range []struct {
+		name                    string
+		method, path, ctype, in string
+		status                  int
+		code                    string
+	}{
+		{"method", http.MethodPut, "/api", "application/json", `{}`, http.StatusMethodNotAllowed, httpapi.CodeMethodNotAllowed},
+		{"malformed", http.MethodPost, "/api", "application/json", `{"message":`, http.StatusBadRequest, httpapi.CodeMalformedJSON},
+		{"too large", http.MethodPost, "/api", "application/json", `{"message":"` + strings.Repeat("a", maxRequestBytes) + `"}`, http.StatusRequestEntityTooLarge, httpapi.CodeBodyTooLarge},
+		{"media type", http.MethodPost, "/api", "application/xml", `<message/>`, http.StatusUnsupportedMediaType, httpapi.CodeUnsupportedMediaType},
+		{"no admin key", http.MethodGet, "/api/admin/flags", "", "", http.StatusUnauthorized, httpapi.CodeUnauthorized},
+		{"unknown session", http.MethodGet, "/api/sessions/trip-9/export", "", "", http.StatusNotFound, httpapi.CodeSessionNotFound},
+	} {
+		resp := do(tt.method, tt.path, tt.ctype, tt.in)
+		var body struct {
+			Error map[string]string `json:"error"`
+		}
+		err := json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if resp.StatusCode != tt.status || body.Error["code"] != tt.code {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, resp.StatusCode, body.Error["code"], tt.status, tt.code)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Content-Type %q", tt.name, ct)
+		}
+		if id := resp.Header.Get("X-Request-ID"); id == "" || body.Error["request_id"] != id || body.Error["message"] == "" {
+			t.Errorf("%s: body %v, X-Request-ID %q", tt.name, body.Error, id)
+		}
+	}
+
+	// Clients that ask for text get the message alone.
+	resp := do(http.MethodPut, "/api", "", "", "Accept", "text/plain")
+	text, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") || string(text) != "Method Not Allowed\n" {
+		t.Errorf("text: %d %q %q", resp.StatusCode, resp.Header.Get("Content-Type"), text)
+	}
+
+	// Limits say when to come back.
+	for range 10 {
+		resp := postChat(t, s, "Flights from Madrid to Paris", false)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if code := errorCode(t, resp); code != httpapi.CodeRateLimited || resp.Header.Get("Retry-After") == "" {
+				t.Errorf("rate limited: %q, Retry-After %q", code, resp.Header.Get("Retry-After"))
+			}
+			resp.Body.Close()
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	t.Error("never rate limited")
+}
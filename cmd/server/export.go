@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// exportFormatVersion is the version of the JSON export schema. It changes only when a field is
+// removed or changes meaning; new fields may be added without a new version.
+const exportFormatVersion = 1
+
+// exportedConversation is the JSON export of a conversation. Its fields are the documented
+// export schema (see the README), kept separate from db.Conversation so storage changes don't
+// alter downloads.
+type exportedConversation struct {
+	FormatVersion int            `json:"format_version"`
+	SessionID     string         `json:"session_id"`
+	Title         string         `json:"title"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	ExportedAt    time.Time      `json:"exported_at"`
+	Turns         []exportedTurn `json:"turns"`
+}
+
+// exportedTurn is one message of an exported conversation.
+type exportedTurn struct {
+	Role        string      `json:"role"` // "user" or "assistant"
+	Content     string      `json:"content"`
+	Timestamp   time.Time   `json:"timestamp"`
+	Regenerated bool        `json:"regenerated"`       // An answer produced by "try again"
+	Flights     []db.Flight `json:"flights,omitempty"` // Search results shown with an answer
+}
+
+// newExport converts a stored conversation to the export schema.
+func newExport(conv db.Conversation, now time.Time) exportedConversation {
+	export := exportedConversation{
+		FormatVersion: exportFormatVersion,
+		SessionID:     conv.SessionID,
+		Title:         conv.Title,
+		CreatedAt:     conv.CreatedAt,
+		UpdatedAt:     conv.UpdatedAt,
+		ExportedAt:    now.UTC(),
+		Turns:         make([]exportedTurn, 0, len(conv.Turns)),
+	}
+	for _, turn := range conv.Turns {
+		export.Turns = append(export.Turns, exportedTurn{
+			Role:        turn.Role,
+			Content:     turn.Content,
+			Timestamp:   turn.Timestamp,
+			Regenerated: turn.Regenerated,
+			Flights:     turn.Flights,
+		})
+	}
+	return export
+}
+
+// renderMarkdown writes the conversation as a Markdown document: a heading with the title and
+// dates, then each turn under its own heading, with flight results as a table.
+func renderMarkdown(export exportedConversation) string {
+	var b strings.Builder
+	title := export.Title
+	if title == "" {
+		title = "Conversation " + export.SessionID
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	fmt.Fprintf(&b, "- Session: `%s`\n", export.SessionID)
+	fmt.Fprintf(&b, "- Started: %s\n", export.CreatedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Last message: %s\n", export.UpdatedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Exported: %s\n", export.ExportedAt.UTC().Format(time.RFC3339))
+
+	for _, turn := range export.Turns {
+		speaker := "User"
+		if turn.Role == db.RoleAssistant {
+			speaker = "Assistant"
+			if turn.Regenerated {
+				speaker = "Assistant (regenerated)"
+			}
+		}
+		fmt.Fprintf(&b, "\n## %s · %s\n\n", speaker, turn.Timestamp.UTC().Format(time.RFC3339))
+		b.WriteString(strings.TrimSpace(turn.Content))
+		b.WriteString("\n")
+		if len(turn.Flights) > 0 {
+			b.WriteString("\n| Flight | From | To | Departure | Arrival | Price | Seats |\n")
+			b.WriteString("|---|---|---|---|---|---:|---:|\n")
+			for _, f := range turn.Flights {
+				fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %.2f | %d |\n",
+					markdownCell(f.FlightNumber), markdownCell(db.PlaceName(f.Origin, f.OriginAirport)), markdownCell(db.PlaceName(f.Destination, f.DestinationAirport)),
+					markdownCell(f.DepartureTime), markdownCell(f.ArrivalTime), f.Price, f.AvailableSeats)
+			}
+		}
+	}
+	return b.String()
+}
+
+// markdownCell makes s safe inside a table cell: pipes are escaped and line breaks, which would
+// end the row, become spaces.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// exportSessionHandler serves GET /api/sessions/{id}/export?format=json|md: one of the caller's
+// conversations as a file to download, in the JSON export schema (the default) or as Markdown.
+// Conversations of other clients, and unknown ones, get 404.
+func exportSessionHandler(store db.Client, now func() time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET, OPTIONS")
+			httpapi.Write(w, r, httpapi.MethodNotAllowed())
+			return
+		}
+		sessionID := r.PathValue("id")
+		if len(sessionID) > maxSessionIDLen || !sessionIDPattern.MatchString(sessionID) {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidSessionID, "session_id must be at most 128 letters, digits or _.:-"))
+			return
+		}
+		format := r.URL.Query().Get("format")
+		switch format {
+		case "":
+			format = "json"
+		case "json", "md":
+		default:
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidFormat, "format must be json or md"))
+			return
+		}
+
+		conv, err := store.GetConversation(r.Context(), sessionID)
+		if errors.Is(err, db.ErrNotFound) || (err == nil && conv.Client != usageAccount(clientKey(r))) {
+			// Someone else's conversation is reported like a missing one, so IDs can't be probed.
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeSessionNotFound, Message: "No conversation " + sessionID + " of yours exists"})
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to load conversation to export", "session_id", sessionID, "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeConversationUnavailable, Message: "The conversation could not be loaded; please retry"})
+			return
+		}
+
+		export := newExport(conv, now())
+		// Session IDs are limited to letters, digits and _.:-, so they need no quoting in the file name.
+		filename := "conversation-" + strings.ReplaceAll(sessionID, ":", "_") + "." + format
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		w.Header().Set("Cache-Control", "no-store")
+		if format == "md" {
+			body := renderMarkdown(export)
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			_, _ = w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(export); err != nil {
+			slog.WarnContext(r.Context(), "Failed to write conversation export", "session_id", sessionID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// exportedAt is when the test exports are made.
+var exportedAt = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+// twoTurnConversation is a flight question and its answer with the flights found.
+func twoTurnConversation() db.Conversation {
+	asked := time.Date(2026, 3, 1, 18, 30, 0, 0, time.UTC)
+	return db.Conversation{
+		SessionID: "trip:1",
+		Client:    usageAccount("key:team-one-key"),
+		Title:     "Madrid to Paris",
+		CreatedAt: asked,
+		UpdatedAt: asked.Add(3 * time.Second),
+		Turns: []db.Turn{
+			{Role: db.RoleUser, Content: "Flights from Madrid to Paris?", Timestamp: asked},
+			{Role: db.RoleAssistant, Content: "Two flights leave tomorrow:\n\n- FL101 at 08:00\n- FL102 at 19:15\n", Timestamp: asked.Add(3 * time.Second), Flights: []db.Flight{
+				{FlightNumber: "FL101", Origin: "Madrid", Destination: "Paris", OriginAirport: "MAD", DepartureTime: "2026-03-02T08:00:00Z", ArrivalTime: "2026-03-02T10:00:00Z", Price: 120, AvailableSeats: 5},
+				{FlightNumber: "FL102", Origin: "Madrid", Destination: "Paris | Orly", DepartureTime: "2026-03-02T19:15:00Z", ArrivalTime: "2026-03-02T21:20:00Z", Price: 89.5, AvailableSeats: 12},
+			}},
+		},
+	}
+}
+
+func TestRenderMarkdownGolden(t *testing.T) {
+	got := renderMarkdown(newExport(twoTurnConversation(), exportedAt))
+	want, err := os.ReadFile("testdata/export.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("Markdown export differs from testdata/export.md:\n%s", got)
+	}
+}
+
+// exportRequest serves GET /api/sessions/{id}/export?format= for key over store.
+func exportRequest(store db.Client, key, sessionID, format string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/sessions/{id}/export", exportSessionHandler(store, func() time.Time { return exportedAt }))
+	r := httptest.NewRequest(http.MethodGet, "/api/sessions/"+sessionID+"/export?format="+format, nil)
+	r.Header.Set("X-API-Key", key)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestExportSession(t *testing.T) {
+	store := db.NewMemoryClient()
+	conv := twoTurnConversation()
+	store.AppendTurns(context.Background(), conv.SessionID, conv.Client, conv.Turns...)
+	store.SetConversationTitle(context.Background(), conv.SessionID, conv.Client, conv.Title, true)
+	stored, _ := store.GetConversation(context.Background(), conv.SessionID)
+
+	// JSON is the default format.
+	for _, format := range []string{"", "json"} {
+		w := exportRequest(store, "team-one-key", "trip:1", format)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json; charset=utf-8" ||
+			w.Header().Get("Content-Disposition") != `attachment; filename="conversation-trip_1.json"` {
+			t.Fatalf("format %q: %d %v", format, w.Code, w.Header())
+		}
+		var export exportedConversation
+		if err := json.Unmarshal(w.Body.Bytes(), &export); err != nil {
+			t.Fatal(err)
+		}
+		if export.FormatVersion != exportFormatVersion || export.SessionID != "trip:1" || export.Title != "Madrid to Paris" ||
+			!export.ExportedAt.Equal(exportedAt) || len(export.Turns) != 2 {
+			t.Errorf("export %+v", export)
+		}
+		if answer := export.Turns[1]; answer.Role != db.RoleAssistant || answer.Content != conv.Turns[1].Content ||
+			!answer.Timestamp.Equal(conv.Turns[1].Timestamp) || len(answer.Flights) != 2 || answer.Flights[1].Price != 89.5 {
+			t.Errorf("answer %+v", answer)
+		}
+	}
+	// The schema's field names are part of the documented format.
+	w := exportRequest(store, "team-one-key", "trip:1", "json")
+	for _, field := range []string{`"format_version": 1`, `"exported_at"`, `"turns"`, `"role": "user"`, `"regenerated": false`, `"flight_number": "FL101"`} {
+		if !strings.Contains(w.Body.String(), field) {
+			t.Errorf("JSON export lacks %s:\n%s", field, w.Body)
+		}
+	}
+
+	w = exportRequest(store, "team-one-key", "trip:1", "md")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/markdown; charset=utf-8" ||
+		w.Header().Get("Content-Disposition") != `attachment; filename="conversation-trip_1.md"` {
+		t.Fatalf("Markdown: %d %v", w.Code, w.Header())
+	}
+	if want := renderMarkdown(newExport(stored, exportedAt)); w.Body.String() != want {
+		t.Errorf("Markdown export:\n%s\nwant:\n%s", w.Body, want)
+	}
+
+	for _, tt := range []struct {
+		name, key, sessionID, format string
+		status                       int
+		code                         string
+	}{
+		{"another key", "team-two-key", "trip:1", "md", http.StatusNotFound, httpapi.CodeSessionNotFound},
+		{"unknown session", "team-one-key", "trip:2", "json", http.StatusNotFound, httpapi.CodeSessionNotFound},
+		{"bad format", "team-one-key", "trip:1", "pdf", http.StatusBadRequest, httpapi.CodeInvalidFormat},
+		{"bad session ID", "team-one-key", "trip%201", "json", http.StatusBadRequest, httpapi.CodeInvalidSessionID},
+	} {
+		w := exportRequest(store, tt.key, tt.sessionID, tt.format)
+		if code := errorCodeOf(w); w.Code != tt.status || code != tt.code {
+			t.Errorf("%s: %d %s, want %d %s", tt.name, w.Code, code, tt.status, tt.code)
+		}
+	}
+}
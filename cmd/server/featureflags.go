@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/flags"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// flagLoader reads the feature flag overrides from the "flags" collection.
+func flagLoader(store db.Client) flags.Loader {
+	return func(ctx context.Context) (map[string]flags.Rule, error) {
+		stored, err := store.ListFlags(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rules := make(map[string]flags.Rule, len(stored))
+		for _, f := range stored {
+			rules[f.Name] = flags.Rule{Enabled: f.Enabled, Percent: f.Percent, Clients: f.Clients, Sessions: f.Sessions}
+		}
+		return rules, nil
+	}
+}
+
+// listFlagsHandler serves GET /api/admin/flags: every flag with the rule in effect and where
+// it comes from.
+func listFlagsHandler(featureFlags *flags.Flags) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"flags": featureFlags.States()})
+	}
+}
+
+// saveFlagHandler serves PUT /api/admin/flags/{name}: it stores the JSON body as the flag's
+// override and answers with the flag's new state. This replica applies it at once; the
+// others when they next poll.
+func saveFlagHandler(store db.Client, featureFlags *flags.Flags) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !flags.Known(name) {
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeFlagNotFound, Message: "Flag " + name + " does not exist"})
+			return
+		}
+		var rule flags.Rule
+		dec := json.NewDecoder(r.Body) // Bounded by the route's httpmw.MaxBytes.
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rule); err != nil {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeMalformedJSON, "Request body is not a valid flag rule: "+err.Error()))
+			return
+		}
+		if err := rule.Validate(); err != nil {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidFlagRule, err.Error()))
+			return
+		}
+		flag := db.Flag{Name: name, Enabled: rule.Enabled, Percent: rule.Percent, Clients: rule.Clients, Sessions: rule.Sessions, UpdatedAt: time.Now().UTC()}
+		if err := store.SaveFlag(r.Context(), flag); err != nil {
+			slog.ErrorContext(r.Context(), "Flag write failed", "flag", name, "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodeSaveFailed, Message: "Error saving flag " + name})
+			return
+		}
+		refreshFlags(r.Context(), store, featureFlags)
+		slog.InfoContext(r.Context(), "Feature flag override saved", "flag", name, "rule", rule)
+		writeJSON(w, http.StatusOK, flagState(featureFlags, name))
+	}
+}
+
+// deleteFlagHandler serves DELETE /api/admin/flags/{name}: it removes the flag's override, so
+// the configured rule or the default applies again. 204 when deleted, 404 without an override.
+func deleteFlagHandler(store db.Client, featureFlags *flags.Flags) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if err := store.DeleteFlag(r.Context(), name); err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeFlagNotFound, Message: "Flag " + name + " has no override"})
+				return
+			}
+			slog.ErrorContext(r.Context(), "Flag write failed", "flag", name, "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodeDeleteFailed, Message: "Error deleting flag " + name})
+			return
+		}
+		refreshFlags(r.Context(), store, featureFlags)
+		slog.InfoContext(r.Context(), "Feature flag override deleted", "flag", name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// refreshFlags applies a change to the overrides right away. The write succeeded, so a failed
+// read only delays it until the next poll.
+func refreshFlags(ctx context.Context, store db.Client, featureFlags *flags.Flags) {
+	if err := featureFlags.Refresh(ctx, flagLoader(store)); err != nil {
+		slog.WarnContext(ctx, "Failed to refresh feature flags; the change applies at the next poll", "error", err)
+	}
+}
+
+// flagState returns the state of the named flag.
+func flagState(featureFlags *flags.Flags, name string) flags.State {
+	for _, state := range featureFlags.States() {
+		if state.Name == name {
+			return state
+		}
+	}
+	return flags.State{}
+}
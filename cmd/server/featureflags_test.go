@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/flags"
+)
+
+func TestFlagOverrides(t *testing.T) {
+	store := db.NewMemoryClient()
+	featureFlags, err := flags.New(map[string]flags.Rule{flags.GroundingCheck: {Percent: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/admin/flags", listFlagsHandler(featureFlags))
+	mux.HandleFunc("PUT /api/admin/flags/{name}", saveFlagHandler(store, featureFlags))
+	mux.HandleFunc("DELETE /api/admin/flags/{name}", deleteFlagHandler(store, featureFlags))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	client := flags.Subject{Client: "key:1a2b3c4d5e6f7a8b"}
+
+	// A stored override applies to the next request, without a restart.
+	rec := do(http.MethodPut, "/api/admin/flags/grounding_check", `{"clients":["key:1a2b3c4d5e6f7a8b"]}`)
+	var state flags.State
+	if err := json.NewDecoder(rec.Body).Decode(&state); err != nil || rec.Code != http.StatusOK ||
+		state.Source != flags.SourceDatabase || state.Rule.Clients[0] != client.Client {
+		t.Fatalf("PUT: %d %+v, %v", rec.Code, state, err)
+	}
+	if !featureFlags.Evaluate(client).On(flags.GroundingCheck) {
+		t.Error("the override isn't applied")
+	}
+	if stored, err := store.ListFlags(context.Background()); err != nil || len(stored) != 1 || stored[0].Name != flags.GroundingCheck {
+		t.Errorf("stored flags %+v, %v", stored, err)
+	}
+
+	var list struct{ Flags []flags.State }
+	if rec := do(http.MethodGet, "/api/admin/flags", ""); rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&list) != nil || len(list.Flags) != len(flags.Definitions) {
+		t.Errorf("GET: %d %+v", rec.Code, list)
+	}
+
+	// Deleting it restores the configured rule.
+	if rec := do(http.MethodDelete, "/api/admin/flags/grounding_check", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE: %d %s", rec.Code, rec.Body)
+	}
+	if got := flagState(featureFlags, flags.GroundingCheck); got.Source != flags.SourceConfig || got.Rule.Percent != 5 {
+		t.Errorf("after DELETE: %+v", got)
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodDelete, "/api/admin/flags/grounding_check", "", http.StatusNotFound},
+		{http.MethodPut, "/api/admin/flags/nonexistent", `{"enabled":true}`, http.StatusNotFound},
+		{http.MethodPut, "/api/admin/flags/db_only", `{"percent":150}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/flags/db_only", `{"enable":true}`, http.StatusBadRequest},
+	} {
+		if rec := do(tt.method, tt.path, tt.body); rec.Code != tt.status {
+			t.Errorf("%s %s %s: %d, want %d", tt.method, tt.path, tt.body, rec.Code, tt.status)
+		}
+	}
+}
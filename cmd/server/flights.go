@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+const (
+	defaultFlightsLimit = 50
+	maxFlightsLimit     = 500
+)
+
+// cityPattern restricts city filters to names (letters, spaces, dots, apostrophes and hyphens),
+// which also keeps regex metacharacters out of the database query.
+var cityPattern = regexp.MustCompile(`^[\p{L} .'-]{1,64}$`)
+
+// airportPattern matches an IATA airport code, in either case.
+var airportPattern = regexp.MustCompile(`^[A-Za-z]{3}$`)
+
+// flightSorts maps the fields flights can be sorted by onto comparisons.
+// A leading "-" on the sort parameter reverses the order.
+var flightSorts = map[string]func(a, b db.Flight) int{
+	"price":          func(a, b db.Flight) int { return cmp.Compare(a.Price, b.Price) },
+	"departure_time": func(a, b db.Flight) int { return strings.Compare(a.DepartureTime, b.DepartureTime) },
+	"flight_number":  func(a, b db.Flight) int { return strings.Compare(a.FlightNumber, b.FlightNumber) },
+}
+
+// flightsResponse is the body of GET /api/flights. Total counts every match, before limit and offset.
+type flightsResponse struct {
+	Flights []db.Flight `json:"flights"`
+	Total   int         `json:"total"`
+}
+
+// flightsRequest is a parsed GET /api/flights query.
+type flightsRequest struct {
+	query         db.FlightQuery
+	sort          string
+	limit, offset int
+}
+
+// listFlightsHandler serves raw flight data straight from the database, without the LLM pipeline:
+//
+//	GET /api/flights?origin=Madrid&destination=Paris&min_price=50&max_price=200
+//	    &depart_after=2025-08-01T00:00:00Z&depart_before=2025-09-01T00:00:00Z
+//	    &sort=-price&limit=20&offset=40
+//
+// Every parameter is optional. Dates are RFC 3339 timestamps or YYYY-MM-DD days (midnight UTC).
+func listFlightsHandler(dbClient db.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET, OPTIONS")
+			httpapi.Write(w, r, httpapi.MethodNotAllowed())
+			return
+		}
+		req, apiErr := parseFlightsRequest(r.URL.Query())
+		if apiErr != nil {
+			httpapi.Write(w, r, apiErr)
+			return
+		}
+
+		flights, err := dbClient.QueryFlights(r.Context(), req.query)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Flight listing failed", "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodeSearchFailed, Message: "Error searching flights"})
+			return
+		}
+
+		// Ties keep a stable order so pages don't shift between requests.
+		field, descending := strings.CutPrefix(req.sort, "-")
+		compare := flightSorts[field]
+		slices.SortStableFunc(flights, func(a, b db.Flight) int {
+			if descending {
+				return compare(b, a)
+			}
+			return compare(a, b)
+		})
+
+		resp := flightsResponse{Flights: []db.Flight{}, Total: len(flights)}
+		if req.offset < len(flights) {
+			end := min(req.offset+req.limit, len(flights))
+			resp.Flights = flights[req.offset:end]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// parseFlightsRequest validates the query parameters of GET /api/flights.
+func parseFlightsRequest(query url.Values) (flightsRequest, *httpapi.Error) {
+	req := flightsRequest{sort: "departure_time", limit: defaultFlightsLimit}
+
+	cities := []struct {
+		name string
+		dst  *string
+	}{{"origin", &req.query.Origin}, {"destination", &req.query.Destination}}
+	for _, p := range cities {
+		if raw := query.Get(p.name); raw != "" {
+			if !cityPattern.MatchString(raw) {
+				return req, httpapi.BadRequest("invalid_"+p.name, p.name+" must be a city name")
+			}
+			*p.dst = raw
+		}
+	}
+
+	airports := []struct {
+		name string
+		dst  *string
+	}{{"origin_airport", &req.query.OriginAirport}, {"destination_airport", &req.query.DestinationAirport}}
+	for _, p := range airports {
+		if raw := query.Get(p.name); raw != "" {
+			if !airportPattern.MatchString(raw) {
+				return req, httpapi.BadRequest("invalid_"+p.name, p.name+" must be a three-letter IATA code")
+			}
+			*p.dst = strings.ToUpper(raw)
+		}
+	}
+
+	prices := []struct {
+		name string
+		dst  *float64
+	}{{"min_price", &req.query.MinPrice}, {"max_price", &req.query.MaxPrice}}
+	for _, p := range prices {
+		if raw := query.Get(p.name); raw != "" {
+			price, err := strconv.ParseFloat(raw, 64)
+			if err != nil || price < 0 || math.IsInf(price, 0) {
+				return req, httpapi.BadRequest("invalid_"+p.name, p.name+" must be a non-negative number")
+			}
+			*p.dst = price
+		}
+	}
+	if req.query.MaxPrice > 0 && req.query.MinPrice > req.query.MaxPrice {
+		return req, httpapi.BadRequest(httpapi.CodeInvalidPriceRange, "min_price must not be greater than max_price")
+	}
+
+	dates := []struct {
+		name string
+		dst  *time.Time
+	}{{"depart_after", &req.query.DepartAfter}, {"depart_before", &req.query.DepartBefore}}
+	for _, p := range dates {
+		if raw := query.Get(p.name); raw != "" {
+			t, err := parseFlightTime(raw)
+			if err != nil {
+				return req, httpapi.BadRequest("invalid_"+p.name, p.name+" must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+			}
+			*p.dst = t
+		}
+	}
+	if !req.query.DepartAfter.IsZero() && !req.query.DepartBefore.IsZero() && !req.query.DepartBefore.After(req.query.DepartAfter) {
+		return req, httpapi.BadRequest(httpapi.CodeInvalidDateRange, "depart_before must be after depart_after")
+	}
+
+	if raw := query.Get("sort"); raw != "" {
+		if _, ok := flightSorts[strings.TrimPrefix(raw, "-")]; !ok {
+			return req, httpapi.BadRequest(httpapi.CodeInvalidSort, "sort must be price, departure_time or flight_number, prefixed with - for descending order")
+		}
+		req.sort = raw
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxFlightsLimit {
+			return req, httpapi.BadRequest(httpapi.CodeInvalidLimit, "limit must be between 1 and "+strconv.Itoa(maxFlightsLimit))
+		}
+		req.limit = limit
+	}
+	if raw := query.Get("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return req, httpapi.BadRequest(httpapi.CodeInvalidOffset, "offset must be a non-negative integer")
+		}
+		req.offset = offset
+	}
+	return req, nil
+}
+
+// parseFlightTime accepts an RFC 3339 timestamp or a bare date, taken as midnight UTC.
+func parseFlightTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, raw)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// listingFlights are the flights the listing tests search, in departure order.
+var listingFlights = []db.Flight{
+	{FlightNumber: "F1", Origin: "Madrid", Destination: "Paris", DepartureTime: "2026-03-01T08:00:00Z", ArrivalTime: "2026-03-01T10:00:00Z", Price: 100, AvailableSeats: 10},
+	{FlightNumber: "F2", Origin: "Madrid", Destination: "Rome", DepartureTime: "2026-03-02T08:00:00Z", ArrivalTime: "2026-03-02T10:30:00Z", Price: 200, AvailableSeats: 10},
+	{FlightNumber: "F3", Origin: "Paris", Destination: "Rome", DepartureTime: "2026-03-03T08:00:00Z", ArrivalTime: "2026-03-03T10:00:00Z", Price: 150, AvailableSeats: 10},
+	{FlightNumber: "F4", Origin: "Barcelona", Destination: "Paris", DepartureTime: "2026-03-04T08:00:00Z", ArrivalTime: "2026-03-04T10:00:00Z", Price: 50, AvailableSeats: 10},
+	{FlightNumber: "F5", Origin: "Madrid", Destination: "Paris", DepartureTime: "2026-03-05T08:00:00Z", ArrivalTime: "2026-03-05T10:00:00Z", Price: 300, AvailableSeats: 10},
+}
+
+// newListingHandler returns GET /api/flights over a memory backend holding listingFlights.
+func newListingHandler(t *testing.T) http.HandlerFunc {
+	t.Helper()
+	store := db.NewMemoryClient()
+	if err := store.InsertFlights(context.Background(), listingFlights); err != nil {
+		t.Fatal(err)
+	}
+	return listFlightsHandler(store)
+}
+
+// listFlights requests /api/flights?query from h.
+func listFlights(h http.HandlerFunc, query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/api/flights?"+query, nil))
+	return rec
+}
+
+// listedNumbers decodes a successful listing into its flight numbers and total.
+func listedNumbers(t *testing.T, rec *httptest.ResponseRecorder) ([]string, int) {
+	t.Helper()
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d %s: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	var resp flightsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	numbers := []string{}
+	for _, f := range resp.Flights {
+		numbers = append(numbers, f.FlightNumber)
+	}
+	return numbers, resp.Total
+}
+
+func TestListFlightsFilters(t *testing.T) {
+	h := newListingHandler(t)
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"", "F1 F2 F3 F4 F5"},
+		{"origin=Madrid", "F1 F2 F5"},
+		{"origin=madrid", "F1 F2 F5"},
+		{"destination=Rome", "F2 F3"},
+		{"origin=Madrid&destination=Paris", "F1 F5"},
+		{"min_price=150", "F2 F3 F5"},
+		{"max_price=100", "F1 F4"},
+		{"min_price=100&max_price=200", "F1 F2 F3"},
+		{"depart_after=2026-03-03", "F3 F4 F5"},
+		{"depart_before=2026-03-03T00:00:00Z", "F1 F2"},
+		{"depart_after=2026-03-02&depart_before=2026-03-04", "F2 F3"},
+		{"origin=Madrid&max_price=250&depart_after=2026-03-02", "F2"},
+		{"origin=Lisbon", ""},
+	} {
+		numbers, total := listedNumbers(t, listFlights(h, tt.query))
+		if got := strings.Join(numbers, " "); got != tt.want || total != len(numbers) {
+			t.Errorf("?%s: %q, total %d; want %q", tt.query, got, total, tt.want)
+		}
+	}
+}
+
+func TestListFlightsSortAndPages(t *testing.T) {
+	h := newListingHandler(t)
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"sort=price", "F4 F1 F3 F2 F5"},
+		{"sort=-price", "F5 F2 F3 F1 F4"},
+		{"sort=-departure_time", "F5 F4 F3 F2 F1"},
+		{"sort=flight_number&limit=2", "F1 F2"},
+		{"sort=price&limit=2&offset=1", "F1 F3"},
+		{"sort=price&limit=2&offset=4", "F5"},
+		{"offset=5", ""},
+	} {
+		numbers, total := listedNumbers(t, listFlights(h, tt.query))
+		if got := strings.Join(numbers, " "); got != tt.want || total != len(listingFlights) {
+			t.Errorf("?%s: %q, total %d; want %q of %d", tt.query, got, total, tt.want, len(listingFlights))
+		}
+	}
+
+	// A page past the end is an empty list, not null.
+	if body := listFlights(h, "offset=10").Body.String(); !strings.Contains(body, `"flights":[]`) {
+		t.Errorf("page past the end: %s", body)
+	}
+	// Pages of equal prices don't shift between requests.
+	first, _ := listedNumbers(t, listFlights(h, "sort=price&limit=3"))
+	again, _ := listedNumbers(t, listFlights(h, "sort=price&limit=3"))
+	if !slices.Equal(first, again) {
+		t.Errorf("pages %v and %v differ", first, again)
+	}
+}
+
+func TestListFlightsInvalid(t *testing.T) {
+	h := newListingHandler(t)
+	for _, tt := range []struct {
+		query, code string
+	}{
+		{"origin=Madrid%3B+drop", "invalid_origin"},
+		{"destination=.*", "invalid_destination"},
+		{"origin_airport=MADX", "invalid_origin_airport"},
+		{"min_price=-1", "invalid_min_price"},
+		{"max_price=cheap", "invalid_max_price"},
+		{"max_price=Inf", "invalid_max_price"},
+		{"min_price=200&max_price=100", httpapi.CodeInvalidPriceRange},
+		{"depart_after=March", "invalid_depart_after"},
+		{"depart_before=2026-13-01", "invalid_depart_before"},
+		{"depart_after=2026-03-04&depart_before=2026-03-02", httpapi.CodeInvalidDateRange},
+		{"sort=seats", httpapi.CodeInvalidSort},
+		{"limit=0", httpapi.CodeInvalidLimit},
+		{"limit=501", httpapi.CodeInvalidLimit},
+		{"offset=-1", httpapi.CodeInvalidOffset},
+	} {
+		rec := listFlights(h, tt.query)
+		if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("?%s: status %d %s", tt.query, rec.Code, rec.Header().Get("Content-Type"))
+			continue
+		}
+		if code := errorCodeOf(rec); code != tt.code {
+			t.Errorf("?%s: code %q, want %q", tt.query, code, tt.code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/api/flights", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, OPTIONS" {
+		t.Errorf("POST: %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
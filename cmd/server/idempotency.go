@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotentReplayHeader  = "Idempotent-Replayed" // "true" on answers given from an earlier request
+	maxIdempotencyKeyLen    = 255
+	idempotencyWriteTimeout = 5 * time.Second // Bounds storing a key's state
+)
+
+// requestIdempotencyKey returns the idempotency key of a chat request: the Idempotency-Key
+// header, or the idempotency_key field or parameter. Both may be sent if they agree.
+func requestIdempotencyKey(r *http.Request, req chatRequest) (string, *httpapi.Error) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key != "" && req.IdempotencyKey != "" && key != req.IdempotencyKey {
+		return "", httpapi.BadRequest(httpapi.CodeInvalidIdempotency, "The Idempotency-Key header and idempotency_key differ")
+	}
+	if key == "" {
+		key = req.IdempotencyKey
+	}
+	if !validIdempotencyKey(key) {
+		return "", httpapi.BadRequest(httpapi.CodeInvalidIdempotency, "Idempotency keys must be at most 255 printable ASCII characters")
+	}
+	return key, nil
+}
+
+// validIdempotencyKey reports whether key is empty or at most maxIdempotencyKeyLen printable
+// ASCII characters without spaces, such as a UUID.
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// fingerprint hashes what a request asks for, so a key sent again with a different request
+// is told apart from a retry. The idempotency key itself is left out.
+func (req chatRequest) fingerprint(regenerate bool) string {
+	req.IdempotencyKey = ""
+	b, _ := json.Marshal(struct {
+		Request    chatRequest `json:"request"`
+		Regenerate bool        `json:"regenerate"`
+	}{req, regenerate})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyKeys deduplicates chat requests sent with an idempotency key. The first request
+// with a key runs; a retry with the same key, from the same client and for the same request,
+// gets the first one's stream instead: attached to it while it runs, or replayed from its
+// stored events within the retention after it finished. Keys are stored through db.Client,
+// so a replay works after a restart and on other replicas. A request still running on another
+// replica is attached to if streams are shared (see sharedStreams); otherwise its retries get
+// 409 until it finishes.
+type idempotencyKeys struct {
+	store     db.Client
+	streams   *sse.Registry
+	retention time.Duration // How long a finished request can be replayed
+	hold      time.Duration // How long a running request's key lasts if its result is never stored (e.g. a crash)
+	now       func() time.Time
+
+	// find looks up a running request's stream, in this replica or shared by another.
+	find func(ctx context.Context, id string) (*sse.Stream, bool)
+
+	mu    sync.Mutex
+	calls map[string]*idempotentCall // Keys this process is claiming or starting, by record ID
+
+	wg sync.WaitGroup // Results being stored
+}
+
+// idempotentCall is a request claiming a key. Requests with the same key that arrive before
+// it has started (or failed to) wait for it on ready.
+type idempotentCall struct {
+	id        string
+	hash      string
+	sessionID string // Recorded, so the session's data can be deleted with its events
+	createdAt time.Time
+	ready     chan struct{}
+
+	// Set before ready is closed: the request's stream, or why it did not start.
+	stream *sse.Stream
+	err    *httpapi.Error
+}
+
+func newIdempotencyKeys(store db.Client, streams *sse.Registry, find func(context.Context, string) (*sse.Stream, bool), retention, hold time.Duration) *idempotencyKeys {
+	return &idempotencyKeys{
+		store:     store,
+		streams:   streams,
+		find:      find,
+		retention: retention,
+		hold:      hold,
+		now:       time.Now,
+		calls:     make(map[string]*idempotentCall),
+	}
+}
+
+// idempotencyRecordID derives a record's ID from the client's account and its key, so clients
+// can't see (or block) each other's keys.
+func idempotencyRecordID(account, key string) string {
+	sum := sha256.Sum256([]byte(account + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// begin looks up key for the client account, for a request of sessionID (if any). It returns the stream to answer with if the key
+// was used before for the same request; otherwise the returned call has claimed the key, and
+// the caller must start the request and report it with started, or abandon the call. A key
+// used for a different request is refused with 422.
+func (k *idempotencyKeys) begin(ctx context.Context, account, key, sessionID, hash string) (*idempotentCall, *sse.Stream, *httpapi.Error) {
+	id := idempotencyRecordID(account, key)
+	k.mu.Lock()
+	if call, ok := k.calls[id]; ok {
+		k.mu.Unlock()
+		stream, apiErr := k.join(ctx, call, hash)
+		return nil, stream, apiErr
+	}
+	call := &idempotentCall{id: id, hash: hash, sessionID: sessionID, createdAt: k.now().UTC(), ready: make(chan struct{})}
+	k.calls[id] = call
+	k.mu.Unlock()
+
+	err := k.store.CreateIdempotencyKey(ctx, db.IdempotencyRecord{
+		ID:          id,
+		RequestHash: hash,
+		SessionID:   sessionID,
+		Status:      db.IdempotencyRunning,
+		CreatedAt:   call.createdAt,
+		ExpiresAt:   call.createdAt.Add(k.hold),
+	})
+	if errors.Is(err, db.ErrConflict) {
+		stream, apiErr := k.existing(ctx, id, hash)
+		k.resolve(call, stream, apiErr)
+		return nil, stream, apiErr
+	}
+	if err != nil {
+		// Running the request twice is better than not at all; retries in this process are
+		// still deduplicated.
+		slog.WarnContext(ctx, "Failed to store idempotency key; running the request anyway", "error", err)
+	}
+	return call, nil, nil
+}
+
+// join waits for a call of this process with the same key to start, and answers with its stream.
+func (k *idempotencyKeys) join(ctx context.Context, call *idempotentCall, hash string) (*sse.Stream, *httpapi.Error) {
+	if call.hash != hash {
+		return nil, keyReused()
+	}
+	select {
+	case <-call.ready:
+		return call.stream, call.err
+	case <-ctx.Done():
+		return nil, keyInProgress()
+	}
+}
+
+// existing answers a request whose key is already stored: with the stored request's stream if
+// it is known here, or with its stored events once it has finished.
+func (k *idempotencyKeys) existing(ctx context.Context, id, hash string) (*sse.Stream, *httpapi.Error) {
+	record, err := k.store.GetIdempotencyKey(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, keyInProgress() // Removed since the conflict, so a retry will claim it.
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load idempotency key", "error", err)
+		return nil, &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeIdempotencyUnavailable, Message: "The idempotency key could not be checked; please retry", RetryAfter: time.Second}
+	}
+	if record.RequestHash != hash {
+		return nil, keyReused()
+	}
+	if record.Status == db.IdempotencyDone {
+		return k.streams.Restore(record.StreamID, replayEvents(record.Events)), nil
+	}
+	if stream, ok := k.find(ctx, record.StreamID); ok {
+		return stream, nil
+	}
+	return nil, keyInProgress()
+}
+
+// started records that call's request is running as stream, hands the stream to requests
+// waiting for it, and stores the request's events once it finishes.
+func (k *idempotencyKeys) started(ctx context.Context, call *idempotentCall, stream *sse.Stream) {
+	if call == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	record := db.IdempotencyRecord{
+		ID:          call.id,
+		RequestHash: call.hash,
+		SessionID:   call.sessionID,
+		StreamID:    stream.ID(),
+		Status:      db.IdempotencyRunning,
+		CreatedAt:   call.createdAt,
+		ExpiresAt:   k.now().UTC().Add(k.hold),
+	}
+	// Saved before the call is resolved, so a retry that finds neither the call nor a stream ID
+	// can't happen.
+	k.save(ctx, record)
+	k.resolve(call, stream, nil)
+
+	k.wg.Add(1)
+	go func() {
+		defer k.wg.Done()
+		_ = stream.Follow(ctx, func(sse.Event) {}) // ctx is never cancelled; Follow ends with the stream.
+		record.Status = db.IdempotencyDone
+		record.Events = storedEvents(stream.Events())
+		record.ExpiresAt = k.now().UTC().Add(k.retention)
+		k.save(ctx, record)
+	}()
+}
+
+// abandon releases the key of a request that could not be started, so a retry runs it. The
+// requests waiting for it get its error.
+func (k *idempotencyKeys) abandon(ctx context.Context, call *idempotentCall, apiErr *httpapi.Error) {
+	if call == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), idempotencyWriteTimeout)
+	defer cancel()
+	if err := k.store.DeleteIdempotencyKey(ctx, call.id); err != nil {
+		slog.WarnContext(ctx, "Failed to release idempotency key", "error", err)
+	}
+	k.resolve(call, nil, apiErr)
+}
+
+// resolve hands call's outcome to the requests waiting for it and forgets the call; later
+// requests with its key find the stored record instead.
+func (k *idempotencyKeys) resolve(call *idempotentCall, stream *sse.Stream, apiErr *httpapi.Error) {
+	call.stream, call.err = stream, apiErr
+	close(call.ready)
+	k.mu.Lock()
+	delete(k.calls, call.id)
+	k.mu.Unlock()
+}
+
+func (k *idempotencyKeys) save(ctx context.Context, record db.IdempotencyRecord) {
+	ctx, cancel := context.WithTimeout(ctx, idempotencyWriteTimeout)
+	defer cancel()
+	if err := k.store.SaveIdempotencyKey(ctx, record); err != nil {
+		slog.WarnContext(ctx, "Failed to store idempotency key", "stream", record.StreamID, "status", record.Status, "error", err)
+	}
+}
+
+// Wait blocks until the results of finished requests have been stored or ctx is done, and
+// reports whether they all were. Shutdown calls it after the orchestrations have drained.
+func (k *idempotencyKeys) Wait(ctx context.Context) bool {
+	finished := make(chan struct{})
+	go func() {
+		k.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func keyReused() *httpapi.Error {
+	return &httpapi.Error{Status: http.StatusUnprocessableEntity, Code: httpapi.CodeIdempotencyKeyReused, Message: "This idempotency key was used for a different request"}
+}
+
+func keyInProgress() *httpapi.Error {
+	return &httpapi.Error{Status: http.StatusConflict, Code: httpapi.CodeIdempotencyInProgress, Message: "A request with this idempotency key is still running; retry shortly", RetryAfter: time.Second}
+}
+
+// storedEvents converts a stream's events for storage, encoding their payloads as JSON.
+func storedEvents(events []sse.Event) []db.StoredEvent {
+	stored := make([]db.StoredEvent, len(events))
+	for i, event := range events {
+		stored[i] = db.StoredEvent{Type: event.Type, Data: event.Data, Seq: event.Seq, Timestamp: event.Timestamp}
+		if event.Payload != nil {
+			stored[i].Payload, _ = json.Marshal(event.Payload)
+		}
+	}
+	return stored
+}
+
+// replayEvents converts stored events back into stream events. Payloads are decoded into
+// their usual types, so replays are handled like live streams; unknown ones stay raw JSON.
+func replayEvents(stored []db.StoredEvent) []sse.Event {
+	events := make([]sse.Event, len(stored))
+	for i, s := range stored {
+		events[i] = sse.Event{Type: s.Type, Data: s.Data, Seq: s.Seq, Timestamp: s.Timestamp}
+		if len(s.Payload) > 0 {
+			events[i].Payload = decodePayload(s.Type, s.Payload)
+		}
+	}
+	return events
+}
+
+func decodePayload(eventType string, raw []byte) any {
+	switch eventType {
+	case sse.TypeStarted:
+		return decodeAs[sse.StartedPayload](raw)
+	case sse.TypeQueryUnderstanding:
+		return decodeAs[sse.QueryUnderstandingPayload](raw)
+	case sse.TypeMessage:
+		return decodeAs[sse.MessagePayload](raw)
+	case sse.TypeError:
+		return decodeAs[sse.ErrorPayload](raw)
+	case sse.TypeDone:
+		return decodeAs[sse.DonePayload](raw)
+	case sse.TypeFlightResults:
+		return decodeAs[[]db.Flight](raw)
+	case sse.TypeRoutes:
+		return decodeAs[[]db.Route](raw)
+	case sse.TypeEnrichment:
+		return decodeAs[sse.EnrichmentPayload](raw)
+	}
+	return json.RawMessage(raw)
+}
+
+func decodeAs[T any](raw []byte) any {
+	var payload T
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return json.RawMessage(raw)
+	}
+	return payload
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestRequestIdempotencyKey(t *testing.T) {
+	for _, tt := range []struct {
+		name, header, field, want string
+		ok                        bool
+	}{
+		{"header", "6f1c2a9e-1", "", "6f1c2a9e-1", true},
+		{"field", "", "6f1c2a9e-1", "6f1c2a9e-1", true},
+		{"both agree", "6f1c2a9e-1", "6f1c2a9e-1", "6f1c2a9e-1", true},
+		{"none", "", "", "", true},
+		{"both differ", "6f1c2a9e-1", "6f1c2a9e-2", "", false},
+		{"space", "", "a key", "", false},
+		{"not ASCII", "", "clé", "", false},
+		{"too long", strings.Repeat("k", maxIdempotencyKeyLen+1), "", "", false},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api", nil)
+		if tt.header != "" {
+			r.Header.Set(idempotencyKeyHeader, tt.header)
+		}
+		key, apiErr := requestIdempotencyKey(r, chatRequest{IdempotencyKey: tt.field})
+		if key != tt.want || (apiErr == nil) != tt.ok || (apiErr != nil && apiErr.Code != httpapi.CodeInvalidIdempotency) {
+			t.Errorf("%s: %q, %v", tt.name, key, apiErr)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	req := chatRequest{Message: "Flights to Paris", SessionID: "s-1", Stream: true, IdempotencyKey: "k1"}
+	retry := req
+	retry.IdempotencyKey = "k2"
+	if req.fingerprint(false) != retry.fingerprint(false) {
+		t.Error("the key changed the fingerprint")
+	}
+	other := req
+	other.Stream = false
+	if req.fingerprint(false) == other.fingerprint(false) || req.fingerprint(false) == req.fingerprint(true) {
+		t.Error("different requests share a fingerprint")
+	}
+}
+
+// llmCalls returns how many LLM calls the server has made, from its /metrics.
+func llmCalls(t *testing.T, s *testServer) int {
+	t.Helper()
+	resp, err := http.Get(s.url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	calls := 0
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		if strings.HasPrefix(lines.Text(), "chat_llm_request_duration_seconds_count{") {
+			fields := strings.Fields(lines.Text())
+			n, _ := strconv.Atoi(fields[len(fields)-1])
+			calls += n
+		}
+	}
+	return calls
+}
+
+// idempotentChat sends a chat request with an idempotency key and reads its whole stream.
+func idempotentChat(s *testServer, key, body string) (*http.Response, []sse.Frame, error) {
+	req, _ := http.NewRequest(http.MethodPost, s.url+"/api", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyKeyHeader, key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	var frames []sse.Frame
+	reader := sse.NewReader(resp.Body)
+	for {
+		frame, err := reader.Next()
+		if err != nil {
+			return resp, frames, nil
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func TestIdempotentConcurrentRequests(t *testing.T) {
+	s := startServer(t, "LLM_MOCK_LATENCY=300ms")
+	const body = `{"message":"What is the capital of France?","language":"en"}`
+
+	// Two identical requests at once, as a client retrying a request it thinks was lost.
+	var wg sync.WaitGroup
+	responses := make([]*http.Response, 2)
+	streams := make([][]sse.Frame, 2)
+	errs := make([]error, 2)
+	for i := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], streams[i], errs[i] = idempotentChat(s, "retry-key-1", body)
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+
+	// Both got the whole stream of one orchestration.
+	if responses[0].Header.Get("X-Stream-ID") != responses[1].Header.Get("X-Stream-ID") {
+		t.Errorf("stream IDs %q and %q, want one stream", responses[0].Header.Get("X-Stream-ID"), responses[1].Header.Get("X-Stream-ID"))
+	}
+	replayed := 0
+	for i, frames := range streams {
+		if len(frames) != len(streams[0]) || frames[0].Event != sse.TypeStarted || frames[len(frames)-1].Data != sse.OutcomeOK {
+			t.Errorf("request %d got %+v, want the full stream", i, frames)
+		}
+		if responses[i].Header.Get("Idempotent-Replayed") == "true" {
+			replayed++
+		}
+	}
+	if replayed != 1 {
+		t.Errorf("%d responses marked as replayed, want the retry's", replayed)
+	}
+	once := llmCalls(t, s)
+	if once == 0 {
+		t.Fatal("no LLM calls counted")
+	}
+
+	// After it finished, a retry is replayed from the stored events without calling the LLMs.
+	resp, frames, err := idempotentChat(s, "retry-key-1", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Idempotent-Replayed") != "true" || len(frames) != len(streams[0]) || frames[len(frames)-1].ID != streams[0][len(frames)-1].ID {
+		t.Errorf("late retry got %v %+v", resp.Header, frames)
+	}
+	if calls := llmCalls(t, s); calls != once {
+		t.Errorf("%d LLM calls after the replay, want %d", calls, once)
+	}
+
+	// The same key with another request is refused; another key runs again.
+	resp, _, err = idempotentChat(s, "retry-key-1", `{"message":"What is the capital of Spain?","language":"en"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("reused key answered %d", resp.StatusCode)
+	}
+	if _, _, err := idempotentChat(s, "retry-key-2", body); err != nil {
+		t.Fatal(err)
+	}
+	if calls := llmCalls(t, s); calls != 2*once {
+		t.Errorf("%d LLM calls after a new key, want %d", calls, 2*once)
+	}
+}
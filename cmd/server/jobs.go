@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/metrics"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+	"github.com/Cris245/go-llm-chat/internal/webhook"
+)
+
+const (
+	jobWriteTimeout     = 5 * time.Second  // Bounds storing a job's state
+	progressSendTimeout = 10 * time.Second // Bounds one progress callback, which is not retried
+)
+
+// Callback events, sent in the payload's event field and the X-Webhook-Event header.
+const (
+	callbackProgress  = "job.progress"
+	callbackCompleted = "job.completed"
+)
+
+// callbackPayload is the JSON body of a callback. Progress callbacks carry the phase; the
+// completed callback carries the answer, or the error of a failed job.
+type callbackPayload struct {
+	Event     string      `json:"event"`
+	JobID     string      `json:"job_id"`
+	SessionID string      `json:"session_id,omitempty"`
+	Status    string      `json:"status"`
+	Phase     string      `json:"phase,omitempty"`
+	Answer    string      `json:"answer,omitempty"`
+	Flights   []db.Flight `json:"flights,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// jobRunner runs asynchronous requests (POST /api with a callback_url). The orchestration runs
+// like any other request; the runner follows its stream, keeps the job's state in the jobs
+// collection for polling, and reports progress and the result to the callback URL.
+type jobRunner struct {
+	store       db.Client
+	sender      *webhook.Sender
+	ttl         time.Duration // How long a job can be polled after it was last updated
+	maxAttempts int           // Deliveries of the completed callback
+	now         func() time.Time
+
+	wg sync.WaitGroup // Jobs being followed or delivered
+}
+
+// start records a job for the request streaming into stream, on behalf of the client
+// identified by key, and follows it in the background. The job takes the stream's ID.
+func (j *jobRunner) start(ctx context.Context, stream *sse.Stream, key string, req chatRequest) (db.Job, error) {
+	now := j.now().UTC()
+	job := db.Job{
+		ID:          stream.ID(),
+		Client:      usageAccount(key),
+		SessionID:   req.SessionID,
+		CallbackURL: req.CallbackURL,
+		Status:      db.JobRunning,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		ExpiresAt:   now.Add(j.ttl),
+	}
+	if err := j.save(ctx, &job); err != nil {
+		return db.Job{}, err
+	}
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		j.follow(context.WithoutCancel(ctx), job, stream)
+	}()
+	return job, nil
+}
+
+// follow collects the stream's events into the job until the stream ends, then stores the
+// result and delivers the completed callback. Progress callbacks are sent as the phases
+// change; they are best effort, since the next one or the result supersedes them.
+func (j *jobRunner) follow(ctx context.Context, job db.Job, stream *sse.Stream) {
+	var answer strings.Builder
+	outcome := ""
+	_ = stream.Follow(ctx, func(event sse.Event) { // ctx is never cancelled; Follow ends with the stream.
+		switch event.Type {
+		case sse.TypeStatus:
+			job.Phase = event.Data
+			if err := j.save(ctx, &job); err != nil {
+				slog.WarnContext(ctx, "Failed to store job progress", "job_id", job.ID, "error", err)
+			}
+			j.deliver(ctx, job, callbackProgress, 1)
+		case sse.TypeMessage:
+			answer.WriteString(event.Data)
+		case sse.TypeFlightResults:
+			job.Flights, _ = event.Payload.([]db.Flight)
+		case sse.TypeError:
+			job.Error = event.Data
+		case sse.TypeDone:
+			if done, ok := event.Payload.(sse.DonePayload); ok {
+				outcome = done.Outcome
+				if job.Error == "" {
+					job.Error = done.Error
+				}
+			}
+		}
+	})
+
+	switch outcome {
+	case sse.OutcomeOK:
+		job.Status, job.Answer, job.Error = db.JobDone, answer.String(), ""
+	case sse.OutcomeCancelled:
+		job.Status = db.JobCancelled
+	default:
+		job.Status = db.JobFailed
+	}
+	job.Phase = ""
+	if err := j.save(ctx, &job); err != nil {
+		slog.ErrorContext(ctx, "Failed to store job result", "job_id", job.ID, "error", err)
+	}
+
+	job.Callback.Attempts, job.Callback.LastError = 0, ""
+	attempts, err := j.deliver(ctx, job, callbackCompleted, j.maxAttempts)
+	job.Callback.Attempts, job.Callback.Delivered = attempts, err == nil
+	if err != nil {
+		job.Callback.LastError = err.Error()
+		slog.ErrorContext(ctx, "Job callback not delivered; the result can still be polled", "job_id", job.ID, "attempts", attempts, "error", err)
+	}
+	if err := j.save(ctx, &job); err != nil {
+		slog.ErrorContext(ctx, "Failed to store job callback state", "job_id", job.ID, "error", err)
+	}
+}
+
+// deliver sends job's state as an event callback, trying at most attempts times. A single
+// attempt is bounded by progressSendTimeout; retried deliveries by the sender's timeouts.
+func (j *jobRunner) deliver(ctx context.Context, job db.Job, event string, attempts int) (int, error) {
+	payload := callbackPayload{
+		Event:     event,
+		JobID:     job.ID,
+		SessionID: job.SessionID,
+		Status:    job.Status,
+		Phase:     job.Phase,
+		Timestamp: j.now().UTC(),
+	}
+	if event == callbackCompleted {
+		payload.Answer, payload.Flights, payload.Error = job.Answer, job.Flights, job.Error
+	}
+	if attempts == 1 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, progressSendTimeout)
+		defer cancel()
+	}
+	n, err := j.sender.Send(ctx, job.CallbackURL, event, payload, attempts)
+	result := "delivered"
+	if err != nil {
+		result = "failed"
+		if attempts == 1 {
+			slog.WarnContext(ctx, "Progress callback not delivered", "job_id", job.ID, "error", err)
+		}
+	}
+	metrics.CallbackDeliveries.WithLabelValues(event, result).Inc()
+	return n, err
+}
+
+// save stores job, refreshing its update time and expiry.
+func (j *jobRunner) save(ctx context.Context, job *db.Job) error {
+	job.UpdatedAt = j.now().UTC()
+	job.ExpiresAt = job.UpdatedAt.Add(j.ttl)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobWriteTimeout)
+	defer cancel()
+	return j.store.SaveJob(ctx, *job)
+}
+
+// Wait blocks until the jobs being followed have delivered their results or ctx is done, and
+// reports whether they all did. Shutdown calls it after the orchestrations have drained.
+func (j *jobRunner) Wait(ctx context.Context) bool {
+	finished := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeJobAccepted answers a request that runs as job with 202, pointing at the job's status.
+// The status is left out if it is unknown.
+func writeJobAccepted(w http.ResponseWriter, job db.Job) {
+	statusURL := "/api/jobs/" + job.ID
+	body := map[string]string{"job_id": job.ID, "status_url": statusURL}
+	if job.Status != "" {
+		body["status"] = job.Status
+	}
+	w.Header().Set("Location", statusURL)
+	writeJSON(w, http.StatusAccepted, body)
+}
+
+// getJobHandler serves GET /api/jobs/{id}: the state of one of the caller's jobs, for clients
+// polling instead of (or as well as) receiving callbacks. Jobs of other clients, and unknown
+// or expired ones, get 404.
+func getJobHandler(store db.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET, OPTIONS")
+			httpapi.Write(w, r, httpapi.MethodNotAllowed())
+			return
+		}
+		id := r.PathValue("id")
+		job, err := store.GetJob(r.Context(), id)
+		if errors.Is(err, db.ErrNotFound) || (err == nil && job.Client != usageAccount(clientKey(r))) {
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeJobNotFound, Message: "No job " + id + " of yours exists"})
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to load job", "job_id", id, "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeJobUnavailable, Message: "The job could not be loaded; please retry"})
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, job)
+	}
+}
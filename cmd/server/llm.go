@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/metrics"
+	"github.com/Cris245/go-llm-chat/internal/ratelimit"
+	"github.com/Cris245/go-llm-chat/internal/tracing"
+)
+
+// llmRetryBaseDelay is the first retry's backoff; each further retry doubles it.
+const llmRetryBaseDelay = 500 * time.Millisecond
+
+// newLLMClients builds the three pipeline clients from the configuration, and the router of
+// the models configured for some calls in their place (see config.ModelRouting). Each client
+// gets its provider's implementation wrapped in the same decorators, innermost first: metrics
+// (one observation per attempt), the provider's shared rate limit and per-minute quota,
+// retries, the per-request token budget (charged once per logical call), and tracing (one
+// span per logical call, with the retry count). Requests to the provider carry the request ID and trace context of the
+// request they are made for (see tracing.OutboundHeaders).
+func newLLMClients(cfg config.LLM) (llm1, llm2, llm3 llmclient.LLMClient, router *llmclient.Router, err error) {
+	// Slots on the same provider share its quota, so they share one limiter key.
+	var limiter *ratelimit.Limiter
+	if cfg.RPS > 0 {
+		limiter = ratelimit.New(ratelimit.Config{RPS: cfg.RPS, Burst: max(1, int(cfg.RPS))})
+	}
+	// Slots on the same provider share its account's per-minute quota, so they queue for one.
+	quotas := make(map[string]*llmclient.Quota, len(cfg.Quotas))
+	for provider, quota := range cfg.Quotas {
+		if quotas[provider] = llmclient.NewQuota(quota.TPM, quota.RPM); quotas[provider] != nil {
+			slog.Info("LLM provider quota enabled", "provider", provider, "tpm", quota.TPM, "rpm", quota.RPM)
+		}
+	}
+	signer := requestSigner(cfg.Signing)
+	if signer != nil {
+		slog.Info("LLM requests signed", "signature_header", cfg.Signing.SignatureHeader, "timestamp_header", cfg.Signing.TimestampHeader)
+	}
+	newClient := func(slot config.NamedSlot) (llmclient.LLMClient, error) {
+		client, err := llmclient.New(llmclient.ProviderConfig{
+			Provider:    slot.Provider,
+			Model:       slot.Model,
+			APIKey:      cfg.APIKey,
+			Signer:      signer,
+			Headers:     tracing.OutboundHeaders,
+			MockLatency: cfg.MockLatency,
+			OnUsage: func(ctx context.Context, model string, usage llmclient.Usage) {
+				metrics.RecordTokens(model, usage.PromptTokens, usage.CompletionTokens)
+				countUsage(ctx, model, usage) // Attributes it to the request's client
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", slot.Name, err)
+		}
+		client = metrics.InstrumentLLM(client, slot.Name, slot.Model)
+		client = llmclient.WithRateLimit(client, limiter, slot.Provider)
+		client = llmclient.WithQuota(client, quotas[slot.Provider])
+		client = llmclient.WithRetry(client, cfg.MaxRetries, llmRetryBaseDelay)
+		client = llmclient.WithTokenBudget(client)
+		return tracing.TraceLLM(client, slot.Name, slot.Model), nil
+	}
+
+	clients := make([]llmclient.LLMClient, 0, 3)
+	for _, slot := range cfg.Slots() {
+		client, err := newClient(slot)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		slog.Info("LLM slot configured", "slot", slot.Name, "provider", slot.Provider, "model", slot.Model)
+		clients = append(clients, client)
+	}
+	router = llmclient.NewRouter()
+	for _, slot := range cfg.RouteSlots() {
+		client, err := newClient(slot)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		slog.Info("LLM route configured", "route", slot.Name, "provider", slot.Provider, "model", slot.Model)
+		router.Route(slot.Name, slot.Model, client)
+	}
+	return clients[0], clients[1], clients[2], router, nil
+}
+
+// requestSigner returns the signer of the LLM requests configured by cfg, or nil if they
+// aren't signed.
+func requestSigner(cfg config.RequestSigning) llmclient.RequestSigner {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return llmclient.HMACSigner([]byte(cfg.Key), cfg.SignatureHeader, cfg.TimestampHeader)
+}
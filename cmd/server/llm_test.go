@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+)
+
+func TestNewLLMClients(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	// Two mock slots of different models and an OpenAI aggregator without a key, which fails
+	// before reaching the network.
+	cfg := config.LLM{
+		MaxRetries: 2,
+		LLM1:       config.Slot{Provider: llmclient.ProviderMock, Model: "mock-small"},
+		LLM2:       config.Slot{Provider: llmclient.ProviderMock, Model: "mock-medium"},
+		LLM3:       config.Slot{Provider: llmclient.ProviderOpenAI, Model: "gpt-4o"},
+	}
+	llm1, llm2, llm3, router, err := newLLMClients(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if router == nil {
+		t.Fatal("no router")
+	}
+	ctx := context.Background()
+	for i, client := range []llmclient.LLMClient{llm1, llm2} {
+		if answer, err := client.ChatCompletion(ctx, "hello"); err != nil || answer == "" {
+			t.Errorf("llm%d: %q, %v", i+1, answer, err)
+		}
+	}
+	if _, err := llm3.ChatCompletion(ctx, "hello"); !errors.Is(err, llmclient.ErrNoAPIKey) {
+		t.Errorf("llm3: %v, want the OpenAI client's missing key error", err)
+	}
+
+	// Every slot is traced under its own name and model; the final error wasn't retried.
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("%d spans, want one per call", len(spans))
+	}
+	for i, want := range []string{"llm1 mock-small", "llm2 mock-medium", "llm3 gpt-4o"} {
+		got := map[string]string{}
+		for _, kv := range spans[i].Attributes() {
+			got[string(kv.Key)] = kv.Value.Emit()
+		}
+		if got["llm.slot"]+" "+got["gen_ai.request.model"] != want || got["llm.retry_count"] != "0" {
+			t.Errorf("span %d attributes %v, want %s", i, got, want)
+		}
+	}
+}
+
+func TestNewLLMClientsUnknownProvider(t *testing.T) {
+	cfg := config.LLM{
+		LLM1: config.Slot{Provider: llmclient.ProviderMock, Model: "m"},
+		LLM2: config.Slot{Provider: "carrier-pigeon", Model: "m"},
+		LLM3: config.Slot{Provider: llmclient.ProviderMock, Model: "m"},
+	}
+	_, _, _, _, err := newLLMClients(cfg)
+	if err == nil || !strings.HasPrefix(err.Error(), `llm2: unknown LLM provider "carrier-pigeon"`) {
+		t.Errorf("error %v, want one naming the slot and the provider", err)
+	}
+}
@@ -2,93 +2,1015 @@ package main
 
 import (
 	"context"
-	"io"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"maps"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 	"time"
 
-	"github.com/Cris245/go-llm-chat/internal/db"           // Database package
-	"github.com/Cris245/go-llm-chat/internal/llmclient"    // LLM client package
+	"github.com/Cris245/go-llm-chat/internal/config"   // Server configuration
+	"github.com/Cris245/go-llm-chat/internal/currency" // Price conversion
+	"github.com/Cris245/go-llm-chat/internal/db"       // Database package
+	"github.com/Cris245/go-llm-chat/internal/flags"    // Feature flags
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/httpmw"       // Shared HTTP middleware
+	"github.com/Cris245/go-llm-chat/internal/i18n"         // Translated status and error texts
+	"github.com/Cris245/go-llm-chat/internal/llmclient"    // LLM providers
+	"github.com/Cris245/go-llm-chat/internal/logging"      // Structured logging and request IDs
+	"github.com/Cris245/go-llm-chat/internal/metrics"      // Prometheus metrics
 	"github.com/Cris245/go-llm-chat/internal/orchestrator" // Orchestrator package
+	"github.com/Cris245/go-llm-chat/internal/persona"      // Deployment system prompt
+	"github.com/Cris245/go-llm-chat/internal/pii"          // Personal data masking
+	"github.com/Cris245/go-llm-chat/internal/ratelimit"    // Per-client rate limiting
+	"github.com/Cris245/go-llm-chat/internal/slack"        // Slack integration
 	"github.com/Cris245/go-llm-chat/internal/sse"          // SSE package
+	"github.com/Cris245/go-llm-chat/internal/telegram"     // Telegram bot
+	"github.com/Cris245/go-llm-chat/internal/timezone"     // Time zones of cities and airports
+	"github.com/Cris245/go-llm-chat/internal/tracing"      // OpenTelemetry tracing
+	"github.com/Cris245/go-llm-chat/internal/version"      // Build information
+	"github.com/Cris245/go-llm-chat/internal/weather"      // Forecasts for flight answers
+	"github.com/Cris245/go-llm-chat/internal/webhook"      // Signed callbacks of asynchronous requests
 )
 
+// shutdownDrainTimeout is how long cancelled requests get to send their final events
+// once the shutdown grace period is over, before remaining connections are closed.
+const shutdownDrainTimeout = 5 * time.Second
+
 func main() {
-	// Check if the OPENAI_API_KEY environment variable is set.
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		log.Fatal("Error: OPENAI_API_KEY environment variable is not set. Please set it before running.")
+	// Settings come from defaults, an optional -config file, the environment and flags (see internal/config).
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return // -h printed the usage.
+	}
+	if err != nil {
+		if cfg != nil && cfg.Check {
+			reportInvalidConfig(os.Stdout, err)
+			os.Exit(1)
+		}
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
+	if err := logging.Setup(os.Stderr, cfg.Log.Level, cfg.Log.Format); err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("Configuration loaded", "config", cfg)
 
-	// Get MongoDB URI from environment variable. Docker Compose will set this.
-	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		log.Fatal("Error: MONGO_URI environment variable is not set. Please set it or ensure docker-compose.yml provides it.")
+	// -check validates the setup, for CI and container health checks, instead of serving.
+	if cfg.Check {
+		if !runPreflight(cfg, os.Stdout) {
+			os.Exit(1)
+		}
+		return
 	}
 
+	// Tracing is configured by the standard OTEL_* variables and stays off unless they enable it.
+	shutdownTracing, tracingEnabled, err := tracing.Setup(context.Background(), os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid tracing configuration: %v", err)
+	}
+	if tracingEnabled {
+		slog.Info("OpenTelemetry tracing enabled")
+	}
+
+	// Name the replica in every log line from here on, so the logs of replicas behind a load
+	// balancer can be told apart.
+	replica := replicaID(cfg.Server.ReplicaID)
+	slog.SetDefault(slog.Default().With("replica", replica))
+
+	// Identify the build first thing, so every log and bug report can be tied to it.
+	build := version.Get()
+	features := enabledFeatures(cfg, tracingEnabled)
+	slog.Info("Starting go-llm-chat", "version", build.Version, "commit", build.Commit,
+		"build_date", build.BuildDate, "go_version", build.GoVersion, "features", features)
+	metrics.RegisterBuildInfo(build.Version, build.Commit, build.GoVersion)
+
+	// Overlay custom translations before any request looks a text up. Incomplete catalogs still
+	// work (missing texts fall back to English), so problems are warnings.
+	if cfg.I18nDir != "" {
+		if err := i18n.LoadDir(cfg.I18nDir); err != nil {
+			log.Fatalf("Failed to load message catalogs: %v", err)
+		}
+	}
+	for _, problem := range i18n.Problems() {
+		slog.Warn("Message catalog problem", "problem", problem)
+	}
+	slog.Info("Message catalogs loaded", "languages", i18n.Languages())
+
 	// Create a context for database connection with a timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DB.ConnectTimeout)
 	defer cancel() // Ensure the context is cancelled when main exits.
 
-	// Initialize MongoDB client and connect to the database.
-	dbClient, err := db.NewClient(ctx, mongoURI)
-	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	// Choose the database backend. The memory backend runs without MongoDB (data is lost on exit).
+	var dbClient db.Client
+	if cfg.DB.Backend == config.BackendMemory {
+		slog.Info("Using the in-memory database backend; data will not be persisted")
+		dbClient = db.NewMemoryClient()
+	} else {
+		// Initialize MongoDB client and connect to the database.
+		mongoClient, err := db.NewClient(ctx, cfg.DB.MongoURI, cfg.DB.Mongo())
+		if err != nil {
+			log.Fatalf("Failed to connect to MongoDB: %v", err)
+		}
+		dbClient = mongoClient
+	}
+	defer dbClient.Disconnect(context.Background()) // Ensure the database connection is closed when main exits.
+
+	// -migrate-dry-run and -migrate-down manage the schema migrations instead of serving.
+	if cfg.MigrateDryRun || cfg.MigrateDown >= 0 {
+		if err := runMigrateCommand(cfg, dbClient, replica, os.Stdout); err != nil {
+			log.Fatalf("Migrations failed: %v", err)
+		}
+		return
+	}
+	// Bring the schema and data up to date before anything reads or seeds them. Replicas
+	// starting together take turns, so each migration is applied once.
+	if err := migrate(dbClient, replica); err != nil {
+		log.Fatalf("Database migrations failed: %v", err)
+	}
+
+	// Cache flight searches. The cache sits above the metrics and tracing decorators so only real database round trips are timed.
+	cachedDB := db.NewCachedClient(tracing.TraceDB(metrics.InstrumentDB(dbClient)), cfg.DB.SearchCacheTTL)
+	metrics.RegisterCache(cachedDB.Stats)
+	// When the database is slow, answer with recently expired results while it catches up.
+	if cfg.DB.SearchCacheTTL > 0 && cfg.DB.StaleAfter > 0 {
+		cachedDB.ServeStale(cfg.DB.StaleAfter, cfg.DB.MaxStale)
 	}
-	defer dbClient.Disconnect(context.Background()) // Ensure MongoDB connection is closed when main exits.
+
+	// The cities questions can name, from the flights' routes and the configured aliases. They
+	// are reloaded periodically and whenever the watcher below sees flights change.
+	var aliases map[string]string
+	if cfg.Cities.AliasesFile != "" {
+		if aliases, err = orchestrator.LoadCityAliases(cfg.Cities.AliasesFile); err != nil {
+			log.Fatalf("Invalid city aliases: %v", err)
+		}
+	}
+	cities := orchestrator.NewCityIndex(cachedDB, aliases)
+	citiesCtx, stopCities := context.WithCancel(context.Background())
+	citiesDone := make(chan struct{})
+	go func() {
+		defer close(citiesDone)
+		cities.Run(citiesCtx, cfg.Cities.RefreshInterval)
+	}()
+	defer func() {
+		stopCities()
+		<-citiesDone
+	}()
+
+	// Watch for flight changes (e.g. admin imports from another replica) and drop stale cached searches.
+	// Backends without change streams poll instead; either way the watcher stops when main returns.
+	if watcher, ok := dbClient.(db.Watcher); ok {
+		watchCtx, stopWatch := context.WithCancel(context.Background())
+		watchDone := make(chan struct{})
+		go func() {
+			defer close(watchDone)
+			err := watcher.Watch(watchCtx, func(ev db.FlightEvent) {
+				cachedDB.Invalidate()
+				cities.Invalidate()
+				slog.Info("Flight data changed; search cache invalidated", "operation", ev.Operation, "flight_number", ev.FlightNumber)
+			})
+			if err != nil {
+				slog.Warn("Flight watcher stopped", "error", err)
+			}
+		}()
+		defer func() {
+			stopWatch()
+			<-watchDone
+		}()
+	}
+	store := dbClient // Uncached, for the readiness checks
+	dbClient = cachedDB
 
 	// Populate the database with sample flights if empty
 	if err := dbClient.SeedFlights(ctx); err != nil {
 		log.Fatalf("Error seeding flights: %v", err)
 	}
 
-	log.Printf("Is OPENAI_API_KEY present?: %v", os.Getenv("OPENAI_API_KEY") != "")
+	// Feature flags: the configured rules, overridden by the "flags" collection. The collection
+	// is polled so changes reach every replica without a restart.
+	featureFlags, err := flags.New(cfg.Flags.Settings())
+	if err != nil {
+		log.Fatalf("Invalid feature flags: %v", err)
+	}
+	if err := featureFlags.Refresh(ctx, flagLoader(dbClient)); err != nil {
+		slog.Warn("Failed to load feature flag overrides; using the configured rules", "error", err)
+	}
+	sources := map[string]string{}
+	for _, state := range featureFlags.States() {
+		sources[state.Name] = state.Source
+	}
+	slog.Info("Feature flags loaded", "sources", sources)
+	if cfg.Flags.PollInterval > 0 {
+		pollCtx, stopPoll := context.WithCancel(context.Background())
+		pollDone := make(chan struct{})
+		go func() {
+			defer close(pollDone)
+			featureFlags.Poll(pollCtx, cfg.Flags.PollInterval, flagLoader(dbClient))
+		}()
+		defer func() {
+			stopPoll()
+			<-pollDone
+		}()
+	}
+
+	// Delete the records holding users' text once they are older than the retention allows.
+	if cfg.Retention.Enabled() {
+		purgeCtx, stopPurge := context.WithCancel(context.Background())
+		purgeDone := make(chan struct{})
+		go func() {
+			defer close(purgeDone)
+			purgeExpired(purgeCtx, dbClient, cfg.Retention.Settings(), cfg.Retention.SweepInterval)
+		}()
+		defer func() {
+			stopPurge()
+			<-purgeDone
+		}()
+	}
 
-	// Initialize LLM clients
-	llm1Client := llmclient.NewOpenAIClient("gpt-4o-mini")
-	llm2Client := llmclient.NewOpenAIClient("gpt-4o-mini")
-	llm3Client := llmclient.NewOpenAIClient("gpt-4o-mini")
+	// Initialize the LLM clients from the per-slot provider and model settings. In db-only mode
+	// there are none: every answer is written from the database.
+	var llm1Client, llm2Client, llm3Client llmclient.LLMClient
+	var router *llmclient.Router
+	if cfg.Orchestrator.DBOnly() {
+		slog.Info("DB-only mode: answering from the database, without LLM calls")
+	} else if llm1Client, llm2Client, llm3Client, router, err = newLLMClients(cfg.LLM); err != nil {
+		log.Fatalf("Invalid LLM configuration: %v", err)
+	}
 
-	// Initialize orchestrator with all three LLM clients
+	// Initialize orchestrator with all three LLM clients, and the models routed in their place
 	orch := orchestrator.NewOrchestrator(llm1Client, llm2Client, llm3Client, dbClient)
+	orch.SetModels(cfg.LLM.LLM1.Model, cfg.LLM.LLM2.Model, cfg.LLM.LLM3.Model)
+	orch.SetRouter(router)
+	orch.SetCities(cities)
+	timeZones, err := timezone.New(cfg.Cities.TimeZones)
+	if err != nil {
+		log.Fatalf("Invalid time zones: %v", err)
+	}
+	orch.SetTimeZones(timeZones)
+	if cfg.Orchestrator.DBOnly() {
+		orch.EnableDBOnly()
+	}
+	orch.AddTelemetryHook(func(t orchestrator.Telemetry, outcome string) {
+		metrics.RecordRequest(t.Intent, outcome, t.DurationMs)
+		if t.LanguageRetry != "" {
+			metrics.LanguageRetries.WithLabelValues(t.LanguageRetry).Inc()
+		}
+		for _, method := range t.Condensed {
+			metrics.CondensedAnswers.WithLabelValues(method).Inc()
+		}
+		if t.Grounding != nil {
+			kinds := make([]string, len(t.Grounding.Mismatches))
+			for i, m := range t.Grounding.Mismatches {
+				kinds[i] = m.Kind
+			}
+			metrics.RecordGrounding(t.Grounding.Score, kinds)
+		}
+	})
+	if !cfg.Features.Telemetry {
+		orch.HideTelemetry()
+	}
+	orch.SetReplica(replica)
 
-	// Handle HTTP POST requests to the "/api" endpoint.
-	http.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-			return
+	// Cap the tokens each request may use across its three LLM calls.
+	if cfg.LLM.Budget.MaxTokens > 0 {
+		slog.Info("Per-request token budget enabled", "max_tokens", cfg.LLM.Budget.MaxTokens)
+		orch.SetTokenBudget(orchestrator.TokenBudget(cfg.LLM.Budget))
+	}
+
+	// Cap the length of answers, and merge streamed chunks into fewer Message events as the
+	// SSE handler merges their flushes.
+	orch.SetOutputLimit(orchestrator.OutputLimit(cfg.LLM.Output))
+	orch.SetChunkCoalescing(cfg.SSE.CoalesceWindow, sse.DefaultCoalesceBytes)
+	orch.SetWorkerProgress(cfg.LLM.WorkerProgress)
+
+	// Condense worker answers too long to aggregate whole.
+	if cfg.LLM.Condense.MaxTokens > 0 {
+		slog.Info("Worker answer condensation enabled", "max_tokens", cfg.LLM.Condense.MaxTokens, "summarize", cfg.LLM.Condense.Summarize)
+		orch.SetCondensation(orchestrator.Condensation(cfg.LLM.Condense))
+	}
+
+	// Write the flights of flight answers in one canonical line each.
+	if err := orch.SetFlightFormat(orchestrator.FlightFormat(cfg.LLM.FlightLines)); err != nil {
+		log.Fatalf("Failed to set up flight lines: %v", err)
+	}
+
+	// Add the forecast at the destination to flight answers.
+	if cfg.Weather.Enabled() {
+		provider, err := weather.New(weather.Config{
+			Provider:     cfg.Weather.Provider,
+			GeocodingURL: cfg.Weather.GeocodingURL,
+			ForecastURL:  cfg.Weather.ForecastURL,
+		})
+		if err != nil {
+			log.Fatalf("Invalid weather configuration: %v", err)
+		}
+		slog.Info("Weather enrichment enabled", "provider", cfg.Weather.Provider, "always", cfg.Weather.Always)
+		orch.SetWeather(orchestrator.WeatherEnrichment{Provider: provider, Always: cfg.Weather.Always, Timeout: cfg.Weather.Timeout})
+	}
+
+	// Convert price limits and shown prices for questions asked in another currency.
+	rates, err := currency.NewRateProvider(currency.Config{
+		Provider: cfg.Currency.Provider,
+		RatesURL: cfg.Currency.RatesURL,
+		Refresh:  cfg.Currency.Refresh,
+	})
+	if err != nil {
+		log.Fatalf("Invalid currency configuration: %v", err)
+	}
+	orch.SetCurrency(currency.NewConverter(cfg.Currency.Base, rates))
+
+	// Brand the assistant with the deployment's system prompt.
+	var assistant *persona.Persona
+	if cfg.Persona.Enabled() {
+		if assistant, err = persona.New(cfg.Persona.Settings()); err != nil {
+			log.Fatalf("Invalid persona: %v", err)
+		}
+		slog.Info("Persona enabled", "bot_name", cfg.Persona.BotName, "company", cfg.Persona.Company, "prompt_file", cfg.Persona.PromptFile)
+		orch.SetPersona(assistant)
+	}
+
+	// Check flight answers against their flight records once they are sent.
+	if cfg.Features.Grounding {
+		slog.Info("Grounding check of flight answers enabled")
+		orch.EnableGroundingCheck()
+	}
+
+	// Let LLM 3 reword the answers to route questions, which are otherwise sent as written.
+	if cfg.Features.RoutePhrasing {
+		slog.Info("Phrasing of route answers by LLM 3 enabled")
+		orch.EnableRoutePhrasing()
+	}
+	if cfg.Features.LanguageCheck {
+		orch.EnableLanguageCheck()
+	}
+
+	// Record every query in the audit log.
+	if cfg.DB.QueryLog {
+		slog.Info("Query audit log enabled", "prompts", cfg.DB.QueryLogPrompts)
+		var redact orchestrator.RedactFunc
+		if cfg.Features.PIIMasking {
+			redact = func(text string) string {
+				masked, _ := pii.Mask(text)
+				return masked
+			}
 		}
+		orch.EnableQueryLog(redact)
+		if cfg.DB.QueryLogPrompts {
+			orch.StorePrompts()
+		}
+	}
+
+	// Recent request streams, kept for a while after they finish so clients can resume or watch them.
+	streams := sse.NewRegistry(cfg.SSE.StreamRetention)
+	streams.OnPublish(func(event sse.Event) {
+		metrics.SSEEvents.WithLabelValues(event.Type).Inc()
+	})
+	// With shared streams, the events are also stored in the database, so a reconnection that the
+	// load balancer sends to another replica can resume there. findStream looks a stream up in
+	// this replica, then in the database.
+	var shared *sharedStreams
+	if cfg.SSE.Shared {
+		shared = newSharedStreams(dbClient, streams, cfg.SSE.StreamRetention, cfg.Server.OrchestrationTimeout+time.Minute)
+	}
+	findStream := func(ctx context.Context, id string) (*sse.Stream, bool) {
+		if stream, ok := streams.Get(id); ok {
+			return stream, true
+		}
+		return shared.find(ctx, id)
+	}
+	sseHandler := sse.NewHandler()
+	sseHandler.BufferSize = cfg.SSE.BufferSize
+	sseHandler.WriteTimeout = cfg.SSE.WriteTimeout
+	sseHandler.RetryInterval = cfg.SSE.RetryInterval
+	sseHandler.CoalesceWindow = cfg.SSE.CoalesceWindow
+	sseHandler.OnClose = func(stats sse.StreamStats) {
+		metrics.RecordStreamClosed(stats.EndReason, stats.Duration, stats.Events)
+	}
+	// serveStream serves an SSE connection, counting it as in flight while it is open.
+	serveStream := func(w http.ResponseWriter, r *http.Request, stream *sse.Stream, after int64) {
+		metrics.SSEStreamsInFlight.Inc()
+		defer metrics.SSEStreamsInFlight.Dec()
+		sseHandler.ServeStream(w, r, stream, after)
+	}
 
-		// Read the user's message from the request body.
-		buf, err := io.ReadAll(r.Body)
+	// Requests that don't set stream/aggregate get the configured defaults.
+	requestDefaults := chatRequest{Stream: cfg.Features.Streaming, Aggregate: cfg.Features.Aggregation}
+
+	// Per-client rate limits.
+	limiter := ratelimit.New(ratelimit.Config{
+		RPS:           cfg.RateLimit.RPS,
+		Burst:         cfg.RateLimit.Burst,
+		MaxConcurrent: cfg.RateLimit.MaxStreams,
+		Queue:         cfg.RateLimit.Queue,
+		MaxQueue:      cfg.RateLimit.MaxQueue,
+	})
+	// The server-wide limit on running orchestrations, behind the per-client ones.
+	capacity := newChatCapacity(cfg.Server)
+	metrics.RegisterChatLoad(capacity.load)
+
+	// Titles for new conversations, generated after their first answer. With the feature
+	// off, conversations are titled with their first question instead of an LLM call.
+	titler := &conversationTitler{store: dbClient}
+	if cfg.Features.Titles && !cfg.Orchestrator.DBOnly() {
+		titler.llm = llm1Client
+	}
+
+	// LLM usage per client and day, and the monthly token quota.
+	usage := newUsageTracker(dbClient, cfg.Usage.MonthlyTokenQuota, cfg.Usage.Prices)
+
+	// The persona's prompts, the city aliases and the prices are reloaded on SIGHUP and by
+	// POST /api/admin/reload, without dropping the streams in progress.
+	reloads := &reloader{
+		loadConfig:  func() (*config.Config, error) { return config.Load(os.Args[1:], os.Getenv) },
+		persona:     assistant,
+		cities:      cities,
+		aliasesFile: cfg.Cities.AliasesFile,
+		usage:       usage,
+		aliases:     aliases,
+	}
+	go reloads.reloadOnHangup()
+
+	// Asynchronous requests, which report to a callback URL; off without a signing secret.
+	var jobs *jobRunner
+	if cfg.Callbacks.Enabled() {
+		jobs = &jobRunner{
+			store:       dbClient,
+			sender:      webhook.NewSender(cfg.Callbacks.SigningSecret, cfg.Callbacks.Timeout),
+			ttl:         cfg.Callbacks.JobTTL,
+			maxAttempts: cfg.Callbacks.MaxAttempts,
+			now:         time.Now,
+		}
+		jobs.sender.UserAgent = "go-llm-chat-webhook/" + build.Version
+	}
+
+	// Idempotency keys, so a client's retry gets the original request's stream instead of running
+	// it again. A key whose request never stores its result is held for as long as a request
+	// can run, plus a margin.
+	var idempotency *idempotencyKeys
+	if cfg.Idempotency.Retention > 0 {
+		idempotency = newIdempotencyKeys(dbClient, streams, findStream, cfg.Idempotency.Retention, cfg.Server.OrchestrationTimeout+time.Minute)
+	}
+
+	// Running orchestrations, and a context whose cancellation aborts them all at shutdown.
+	var running inflight
+	orchestrations, cancelOrchestrations := context.WithCancel(context.Background())
+	defer cancelOrchestrations()
+	// Running orchestrations by stream ID, for POST /api/cancel/{id} and the admin listing.
+	active := newActiveRequests()
+
+	// handle registers h under pattern behind the middleware every route shares (outermost first):
+	// metrics under the route label, the request ID, the tracing span, the access log and panic
+	// recovery, followed by the route's own middleware.
+	handle := func(pattern, route string, h http.HandlerFunc, mws ...httpmw.Middleware) {
+		common := []httpmw.Middleware{
+			func(next http.HandlerFunc) http.HandlerFunc { return metrics.InstrumentHandler(route, next) },
+			withRequestID,
+			func(next http.HandlerFunc) http.HandlerFunc { return tracing.Middleware(route, next) },
+			httpmw.AccessLog(route),
+			httpmw.Recover,
+		}
+		http.HandleFunc(pattern, httpmw.Chain(h, append(common, mws...)...))
+	}
+	// Cross-origin policy for browser clients. It answers OPTIONS preflights itself, so it runs
+	// before authentication (browsers send preflights without credentials).
+	cors := httpmw.CORS(httpmw.CORSConfig{
+		AllowedOrigins: cfg.CORS.AllowedOrigins,
+		AllowedMethods: cfg.CORS.AllowedMethods,
+		AllowedHeaders: cfg.CORS.AllowedHeaders,
+		ExposedHeaders: []string{"X-Stream-ID", logging.RequestIDHeader, idempotentReplayHeader},
+		MaxAge:         cfg.CORS.MaxAge,
+	})
+	// Clients whose requests keep being rejected are banned for a while.
+	abuse := newAbuseDetector(dbClient, cfg.Abuse)
+	if abuse != nil {
+		slog.Info("Abuse bans enabled", "threshold", cfg.Abuse.Threshold, "window", cfg.Abuse.Window, "ban_duration", cfg.Abuse.BanDuration)
+	}
+	// Chat routes bound the request before the response starts, apply the CORS policy and
+	// refuse banned clients.
+	chatMiddleware := []httpmw.Middleware{httpmw.Timeout(cfg.Server.RequestTimeout), httpmw.MaxBytes(maxRequestBytes), cors, abuse.middleware}
+
+	// startChat starts a validated chat request on behalf of the client identified by key: it
+	// applies the per-client limits and starts the orchestration in the background, returning the
+	// stream its events go to, or the error rejecting the request. base carries the caller's
+	// values (request ID, span) but not its cancellation. When the request has a session ID the
+	// exchange is stored in the session's conversation once it finishes. regenerate marks a
+	// "try again" of the session's last message, which is refused with 409 while another request
+	// of the session is running.
+	startChat := func(base context.Context, req chatRequest, key string, regenerate bool) (*sse.Stream, *httpapi.Error) {
+		// Personal data is masked before anything else sees the message, so the prompts, the query
+		// log and the stored conversation only get the placeholders.
+		var masked []string
+		if cfg.Features.PIIMasking {
+			req.Message, masked = pii.Mask(req.Message)
+		}
+		// Per-client limits. The request rate is checked before anything starts; a client at its
+		// stream cap is rejected, or with queueing on, waits for a slot inside its stream.
+		if ok, wait := limiter.Allow(key); !ok {
+			metrics.RateLimited.WithLabelValues("rate").Inc()
+			slog.InfoContext(base, "Request rate limited", "client", maskClient(key), "retry_after", wait)
+			return nil, rateLimited(wait, httpapi.CodeRateLimited, "Too many requests; slow down")
+		}
+		if apiErr := usage.checkQuota(base, key); apiErr != nil {
+			return nil, apiErr
+		}
+		acquired, err := limiter.TryAcquire(key)
 		if err != nil {
-			http.Error(w, "Error reading request body", http.StatusInternalServerError)
-			return
+			metrics.RateLimited.WithLabelValues("streams").Inc()
+			slog.InfoContext(base, "Request rejected: too many concurrent streams", "client", maskClient(key))
+			return nil, rateLimited(time.Second, httpapi.CodeTooManyStreams, "Too many concurrent requests; wait for one to finish")
 		}
-		userMessage := string(buf)
-		if userMessage == "" {
-			http.Error(w, "User message cannot be empty", http.StatusBadRequest)
-			return
+		// Then the server's limit: a request over it is rejected, or with queueing on, waits for
+		// a slot. A request waiting for its client's slot takes one of the server's only after,
+		// so a client's queue doesn't hold server slots.
+		var reserved bool
+		var apiErr *httpapi.Error
+		if acquired {
+			reserved, apiErr = capacity.tryAcquire()
+		}
+		if apiErr != nil {
+			limiter.Release(key)
+			metrics.RateLimited.WithLabelValues("capacity").Inc()
+			busy, waiting := capacity.load()
+			slog.WarnContext(base, "Request rejected: server at capacity", "client", maskClient(key), "running", busy, "queued", waiting)
+			return nil, apiErr
 		}
+		release := func() {
+			if acquired {
+				limiter.Release(key)
+			}
+			if reserved {
+				capacity.release()
+			}
+		}
+
+		// Refuse new work while shutting down; in-flight requests are being drained.
+		if !running.start() {
+			release()
+			return nil, &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeShuttingDown, Message: "Server is shutting down", RetryAfter: 5 * time.Second}
+		}
+
+		// Events go through a registered stream so they are buffered for replay.
+		stream := streams.Create()
+		shared.share(stream, req.SessionID)
+
+		// The orchestration is detached from the caller so it survives a client reconnecting
+		// (WithoutCancel keeps the context's values, so its logs still carry the request ID);
+		// the orchestration timeout bounds it instead, and shutdown cancels it once the grace period is over.
+		// POST /api/cancel/{id} cancels it with orchestrator.ErrCancelled until it finishes.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(base), cfg.Server.OrchestrationTimeout)
+		ctx, cancelRequest := context.WithCancelCause(ctx)
+		if !active.add(stream, cancelRequest, maskClient(key), req.SessionID, regenerate) {
+			cancel()
+			stream.Close()
+			running.done()
+			release()
+			return nil, &httpapi.Error{Status: http.StatusConflict, Code: httpapi.CodeGenerationInProgress, Message: "This session already has a request running; wait for it to finish"}
+		}
+		stopOnShutdown := context.AfterFunc(orchestrations, cancel)
 
-		// Create a new SSE handler for this specific request.
-		sseHandler := sse.NewHandler()
-		// Create a channel for the orchestrator to send events to the SSE handler.
+		// The first event names the stream, so clients can cancel or watch it.
+		// Flags are evaluated once, so a rule changing mid-request doesn't change its pipeline.
+		requestFlags := featureFlags.Evaluate(flags.Subject{Client: usageAccount(key), SessionID: req.SessionID})
+		slog.InfoContext(base, "Chat request", "stream", stream.ID(), "client", maskClient(key), "streaming", req.Stream, "queued", !acquired || !reserved, "regenerate", regenerate, "flags", requestFlags)
+		stream.Publish(sse.Started(stream.ID()))
 		eventChan := make(chan sse.Event)
+		piped := make(chan struct{})
+		go func() {
+			stream.Pipe(eventChan)
+			close(piped)
+		}()
 
 		// Start a goroutine to process the message with the orchestrator.
-		// This allows the HTTP handler to immediately set up the SSE connection
-		// while the LLM processing happens concurrently.
 		go func() {
-			defer close(eventChan)                                   // Ensure the event channel is closed when processing is done.
-			orch.ProcessMessage(r.Context(), userMessage, eventChan) // Pass the context for cancellation.
+			defer running.done()
+			defer stopOnShutdown()
+			defer cancel()
+			defer active.remove(stream.ID())
+			// Once every event is in the stream, store the exchange. The request stays active
+			// until then, so a regeneration can't read the conversation before it is written.
+			defer func() {
+				<-piped
+				recordExchange(ctx, dbClient, titler, key, req, stream.Events(), regenerate)
+			}()
+			defer close(eventChan) // Ensure the event channel is closed when processing is done.
+			opts := req.options()
+			opts.Preferences = sessionPreferences(ctx, dbClient, key, req.SessionID)
+			if !regenerate && !req.Force {
+				opts.Repeat = repeatedAnswer(ctx, dbClient, key, req, time.Now())
+			}
+			lang := orchestrator.LanguageCode(opts, req.Message) // For the events written here
+			// The orchestrator recovers its own panics; this catches the rest (e.g. queueing), so
+			// the stream still ends with an Error and Done instead of crashing the process.
+			defer func() {
+				if p := recover(); p != nil {
+					httpmw.LogPanic(ctx, p)
+					eventChan <- sse.Error(httpapi.CodeInternal, i18n.T(lang, "error.internal"))
+					eventChan <- sse.Done(sse.DonePayload{Outcome: sse.OutcomeError, Error: "internal error"})
+				}
+			}()
+			if len(masked) > 0 {
+				slog.InfoContext(ctx, "Personal data masked", "kinds", masked)
+				eventChan <- sse.Status(maskedStatus(lang, masked))
+			}
+			if reserved {
+				defer capacity.release()
+			}
+			if !acquired {
+				metrics.RateLimitQueued.Inc()
+				if !waitQueued(ctx, limiter, key, httpapi.CodeTooManyStreams, lang, eventChan) {
+					return
+				}
+				// The client's other requests may have used up its quota while this one waited.
+				if apiErr := usage.checkQuota(ctx, key); apiErr != nil {
+					limiter.Release(key)
+					eventChan <- sse.Error(apiErr.Code, apiErr.Message)
+					eventChan <- sse.Done(sse.DonePayload{Outcome: sse.OutcomeError, Error: apiErr.Message})
+					return
+				}
+			}
+			defer limiter.Release(key)
+			if !reserved {
+				if !waitQueued(ctx, capacity.limiter, serverSlots, httpapi.CodeServerBusy, lang, eventChan) {
+					return
+				}
+				defer capacity.release()
+			}
+			// Count the tokens of this request's LLM calls and bill them to the client.
+			ctx, meter := withUsageMeter(ctx)
+			defer usage.record(ctx, key, meter)
+			opts.Regenerate = regenerate
+			opts.Flags = requestFlags
+			opts.OnIntent = func(intent string) { active.setIntent(stream.ID(), intent) }
+			if req.Stream {
+				orch.ProcessMessageStream(ctx, req.Message, opts, eventChan)
+			} else {
+				orch.ProcessMessage(ctx, req.Message, opts, eventChan)
+			}
 		}()
 
-		// Serve the SSE events to the client using the sseHandler and the eventChan.
-		sseHandler.ServeHTTP(w, r, eventChan)
-	})
+		return stream, nil
+	}
+
+	// runChat runs a validated chat request for an HTTP client and streams its events to it.
+	// A request with a callback URL runs as a job instead: the client gets 202 with the job's
+	// ID at once, and the progress and answer go to the callback URL. A request with an
+	// idempotency key that was seen before gets the earlier request's stream (or job) instead
+	// of running again.
+	runChat := func(w http.ResponseWriter, r *http.Request, req chatRequest, regenerate bool) {
+		if req.CallbackURL != "" && jobs == nil {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeCallbacksDisabled, "This server does not accept callback_url; stream the answer instead"))
+			return
+		}
+		key := clientKey(r)
+		var claim *idempotentCall
+		if idempotency != nil {
+			idemKey, apiErr := requestIdempotencyKey(r, req)
+			if apiErr != nil {
+				httpapi.Write(w, r, apiErr)
+				return
+			}
+			if idemKey != "" {
+				var stream *sse.Stream
+				claim, stream, apiErr = idempotency.begin(r.Context(), usageAccount(key), idemKey, req.SessionID, req.fingerprint(regenerate))
+				if apiErr != nil {
+					httpapi.Write(w, r, apiErr)
+					return
+				}
+				if stream != nil {
+					slog.InfoContext(r.Context(), "Idempotent retry answered from an earlier request", "stream", stream.ID(), "client", maskClient(key))
+					w.Header().Set(idempotentReplayHeader, "true")
+					if req.CallbackURL != "" {
+						// The earlier request is a job under the stream's ID; report its current state.
+						job, err := dbClient.GetJob(r.Context(), stream.ID())
+						if err != nil {
+							slog.WarnContext(r.Context(), "Failed to load job of an idempotent retry", "job_id", stream.ID(), "error", err)
+							job = db.Job{ID: stream.ID()}
+						}
+						writeJobAccepted(w, job)
+						return
+					}
+					serveStream(w, r, stream, 0)
+					return
+				}
+			}
+		}
+		stream, apiErr := startChat(r.Context(), req, key, regenerate)
+		if apiErr != nil {
+			idempotency.abandon(r.Context(), claim, apiErr)
+			httpapi.Write(w, r, apiErr)
+			return
+		}
+		if req.CallbackURL != "" {
+			job, err := jobs.start(r.Context(), stream, key, req)
+			if err != nil {
+				// Without a stored job the client could neither poll nor trust the callbacks.
+				active.stop(stream.ID())
+				slog.ErrorContext(r.Context(), "Failed to store job; request cancelled", "stream", stream.ID(), "error", err)
+				apiErr := &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeJobUnavailable, Message: "The request could not be queued; please retry", RetryAfter: time.Second}
+				idempotency.abandon(r.Context(), claim, apiErr)
+				httpapi.Write(w, r, apiErr)
+				return
+			}
+			idempotency.started(r.Context(), claim, stream)
+			writeJobAccepted(w, job)
+			return
+		}
+		idempotency.started(r.Context(), claim, stream)
+		// Serve the stream's events to the client as SSE.
+		serveStream(w, r, stream, 0)
+	}
+
+	// Handle requests to the "/api" endpoint: POST with the message in the body, or GET with it in
+	// the query string so browsers can use EventSource directly.
+	handle("/api", "/api", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET, POST, OPTIONS")
+			httpapi.Write(w, r, httpapi.MethodNotAllowed())
+			return
+		}
+
+		// A reconnecting client sends the ID of the last event it received; resume that stream
+		// (replaying anything missed) instead of starting a new orchestration.
+		if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+			streamID, seq, ok := sse.ParseEventID(lastID)
+			var stream *sse.Stream
+			if ok {
+				stream, ok = findStream(r.Context(), streamID)
+			}
+			if !ok {
+				// 204 tells EventSource clients the stream is over and they should not reconnect.
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			serveStream(w, r, stream, seq)
+			return
+		}
 
-	// Start the HTTP server on port 8080.
-	log.Println("Server listening on :8080. Send POST requests to /api with your message in the body.")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+		// Read the user's message and options from the request body (JSON or plain text).
+		req, apiErr := parseChatRequest(r, requestDefaults)
+		if apiErr != nil {
+			httpapi.Write(w, r, apiErr)
+			return
+		}
+		if len(req.PersonaOverrides) > 0 {
+			if !hasKey(r, cfg.Admin.DeveloperKeys) {
+				httpapi.Write(w, r, &httpapi.Error{Status: http.StatusForbidden, Code: httpapi.CodeDeveloperKeyRequired, Message: "persona_overrides need a developer API key"})
+				return
+			}
+			slog.InfoContext(r.Context(), "Persona overridden", "client", maskClient(clientKey(r)), "stages", slices.Sorted(maps.Keys(req.PersonaOverrides)))
+		}
+		runChat(w, r, req, false)
+	}, chatMiddleware...)
+
+	// The caller's conversations with their titles, renaming one, and downloading it.
+	handle("/api/sessions", "/api/sessions", listSessionsHandler(dbClient), chatMiddleware...)
+	handle("/api/sessions/{id}", "/api/sessions/{id}", renameSessionHandler(dbClient), chatMiddleware...)
+	handle("/api/sessions/{id}/preferences", "/api/sessions/{id}/preferences", preferencesHandler(dbClient), chatMiddleware...)
+	handle("/api/sessions/{id}/export", "/api/sessions/{id}/export", exportSessionHandler(dbClient, time.Now), chatMiddleware...)
+
+	// Read-only links to a conversation. Viewing one needs no API key, only the link's token.
+	if cfg.Sharing.Enabled() {
+		handle("/api/sessions/{id}/share", "/api/sessions/{id}/share", shareSessionHandler(dbClient, cfg.Sharing.LinkTTL, time.Now), chatMiddleware...)
+		handle("/share/{token}", "/share/{token}", viewShareHandler(dbClient, time.Now), chatMiddleware...)
+	}
+
+	// "Try again": answer the session's last message once more, as an extra assistant turn.
+	handle("/api/sessions/{id}/regenerate", "/api/sessions/{id}/regenerate", regenerateHandler(dbClient, requestDefaults, runChat), chatMiddleware...)
+
+	// Attach to an existing stream (e.g. a second browser watching an in-progress request).
+	// Subscribers get the buffered events followed by live ones; Last-Event-ID skips what they already have.
+	handle("/api/stream/{id}", "/api/stream/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET, OPTIONS")
+			httpapi.Write(w, r, httpapi.MethodNotAllowed())
+			return
+		}
+		stream, found := findStream(r.Context(), r.PathValue("id"))
+		if !found {
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeStreamNotFound, Message: "Stream not found"})
+			return
+		}
+		var after int64
+		if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+			streamID, seq, ok := sse.ParseEventID(lastID)
+			if !ok || streamID != stream.ID() {
+				httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidLastEventID, "Invalid Last-Event-ID"))
+				return
+			}
+			after = seq
+		}
+		serveStream(w, r, stream, after)
+	}, chatMiddleware...)
+
+	// Poll an asynchronous request's state and result.
+	handle("/api/jobs/{id}", "/api/jobs/{id}", getJobHandler(dbClient), chatMiddleware...)
+
+	// Stop a running request; its stream ends with a "cancelled" Done event.
+	handle("/api/cancel/{id}", "/api/cancel/{id}", cancelHandler(active), chatMiddleware...)
+
+	// Raw flight data for frontends and integrators, bypassing the LLM pipeline.
+	handle("/api/flights", "/api/flights", listFlightsHandler(dbClient), chatMiddleware...)
+
+	// integrationChat starts a question from a chat integration. The answer is streamed so the
+	// integration can edit its reply as the text arrives.
+	integrationChat := func(ctx context.Context, user, sessionID, text string) (*sse.Stream, error) {
+		req := requestDefaults
+		req.Message, req.SessionID, req.Stream = text, sessionID, true
+		if apiErr := req.validate(); apiErr != nil {
+			return nil, errors.New(apiErr.Message)
+		}
+		stream, apiErr := startChat(ctx, req, user, false)
+		if apiErr != nil {
+			return nil, errors.New(apiErr.Message)
+		}
+		return stream, nil
+	}
+
+	// Integrations answering on chat platforms. Their replies outlive the orchestrations, so
+	// shutdown waits for them separately.
+	var botReplies []interface{ Wait(context.Context) bool }
+
+	// Slack Events API callbacks, when a Slack app is configured. Slack calls the endpoint
+	// itself, so it takes no CORS policy; requests are authenticated by their signature.
+	if cfg.Slack.Enabled() {
+		slackHandler := slack.NewHandler(cfg.Slack.SigningSecret, slack.NewClient(cfg.Slack.BotToken, cfg.Slack.APIURL), integrationChat)
+		handle("POST /integrations/slack", "/integrations/slack", slackHandler.ServeHTTP,
+			httpmw.Timeout(cfg.Server.RequestTimeout), httpmw.MaxBytes(maxRequestBytes))
+		botReplies = append(botReplies, slackHandler)
+		slog.Info("Slack integration enabled", "endpoint", "/integrations/slack")
+	}
+
+	// The Telegram bot, when a token is configured. It long-polls for messages, so it works
+	// with or without the HTTP server; shutdown stops the polling first.
+	stopPolling := func() {}
+	if cfg.Telegram.Enabled() {
+		bot := telegram.NewBot(telegram.NewClient(cfg.Telegram.BotToken, cfg.Telegram.APIURL), integrationChat)
+		bot.PollTimeout = cfg.Telegram.PollTimeout
+		pollCtx, cancelPoll := context.WithCancel(context.Background())
+		polling := make(chan struct{})
+		go func() {
+			defer close(polling)
+			bot.Run(pollCtx)
+		}()
+		stopPolling = func() {
+			cancelPoll()
+			<-polling
+		}
+		botReplies = append(botReplies, bot)
+	}
+
+	// Admin endpoints require one of the configured admin keys (ADMIN_API_KEYS).
+	adminKeys := cfg.Admin.APIKeys
+	if len(adminKeys) == 0 {
+		slog.Warn("ADMIN_API_KEYS is not set; admin endpoints will reject all requests")
+	}
+
+	requireAdminMW := func(next http.HandlerFunc) http.HandlerFunc { return requireAdmin(adminKeys, next) }
+
+	// adminRoute registers an admin handler behind mws, CORS and the key check. Method patterns
+	// don't match OPTIONS, so each admin path also gets a route that answers CORS preflights.
+	preflightRoutes := map[string]bool{}
+	adminRoute := func(pattern, route string, handler http.HandlerFunc, mws ...httpmw.Middleware) {
+		handle(pattern, route, handler, slices.Concat(mws, []httpmw.Middleware{cors, requireAdminMW})...)
+		if !preflightRoutes[route] {
+			preflightRoutes[route] = true
+			handle("OPTIONS "+route, route, http.NotFound, cors) // CORS answers every OPTIONS request itself.
+		}
+	}
+	adminDefaults := []httpmw.Middleware{httpmw.Timeout(cfg.Server.RequestTimeout), httpmw.MaxBytes(maxRequestBytes)}
+
+	// Bulk import of flights from a CSV upload. Large files may take longer than the request
+	// timeout to upload, so only the body limit applies.
+	adminRoute("POST /api/admin/flights/import", "/api/admin/flights/import", importFlightsHandler(dbClient), httpmw.MaxBytes(maxImportBytes))
+
+	// Single-flight management and re-seeding. Writes go through the cached client, which clears the search cache.
+	adminRoute("POST /api/admin/flights", "/api/admin/flights", createFlightHandler(dbClient), adminDefaults...)
+	adminRoute("PUT /api/admin/flights/{number}", "/api/admin/flights/{number}", updateFlightHandler(dbClient), adminDefaults...)
+	adminRoute("DELETE /api/admin/flights/{number}", "/api/admin/flights/{number}", deleteFlightHandler(dbClient), adminDefaults...)
+	adminRoute("POST /api/admin/seed", "/api/admin/seed", seedHandler(dbClient), adminDefaults...)
+
+	// Running requests, for debugging stuck ones.
+	adminRoute("GET /api/admin/streams", "/api/admin/streams", listStreamsHandler(active), adminDefaults...)
+	adminRoute("GET /api/admin/streams/{id}", "/api/admin/streams/{id}", getStreamHandler(active), adminDefaults...)
+	// Feature flags and their runtime overrides.
+	adminRoute("GET /api/admin/flags", "/api/admin/flags", listFlagsHandler(featureFlags), adminDefaults...)
+	adminRoute("PUT /api/admin/flags/{name}", "/api/admin/flags/{name}", saveFlagHandler(dbClient, featureFlags), adminDefaults...)
+	adminRoute("DELETE /api/admin/flags/{name}", "/api/admin/flags/{name}", deleteFlagHandler(dbClient, featureFlags), adminDefaults...)
+	// Reloading of the persona's prompts, the city aliases and the prices.
+	adminRoute("POST /api/admin/reload", "/api/admin/reload", reloadHandler(reloads), adminDefaults...)
+	// Per-client LLM usage by day.
+	adminRoute("GET /api/admin/usage", "/api/admin/usage", usageHandler(dbClient, time.Now), adminDefaults...)
+	// Bans of clients with too many rejected requests; lifted and expired ones stay listed with ?all=true.
+	adminRoute("GET /api/admin/bans", "/api/admin/bans", listBansHandler(dbClient, time.Now), adminDefaults...)
+	adminRoute("DELETE /api/admin/bans/{client}", "/api/admin/bans/{client}", liftBanHandler(dbClient, time.Now), adminDefaults...)
+	// What each stage of a logged request was asked and answered, for debugging bad answers.
+	adminRoute("GET /api/admin/requests/{request_id}", "/api/admin/requests/{request_id}", requestSnapshotHandler(dbClient), adminDefaults...)
+	// Deletion of everything stored about a session, e.g. on a user's request.
+	adminRoute("DELETE /api/admin/data", "/api/admin/data", deleteSessionDataHandler(dbClient), adminDefaults...)
+
+	// Build and feature information, for bug reports and deployment checks.
+	handle("GET /version", "/version", versionHandler(build, replica, features))
+
+	// Readiness, for load balancers and orchestrators: the database checks of -check.
+	handle("GET /readyz", "/readyz", readyHandler(store, cfg.DB.Backend, cfg.DB.ConnectTimeout, capacity))
+
+	// Prometheus metrics.
+	http.Handle("GET /metrics", metrics.Handler())
+
+	grace := cfg.Server.ShutdownGracePeriod
+
+	// Start the HTTP server on port 8080, unless only the bots should run.
+	var srv, redirect *http.Server
+	serveErr := make(chan error, 2)
+	if cfg.Server.HTTPEnabled {
+		srv, redirect = listen(cfg.Server, serveErr)
+		slog.Info("Server listening; send POST requests to /api with your message in the body", "addr", srv.Addr, "tls", cfg.Server.TLS.Enabled())
+		if redirect != nil {
+			slog.Info("Redirecting plain HTTP to HTTPS", "addr", redirect.Addr)
+		}
+	} else {
+		slog.Info("HTTP server disabled; only the bots are running")
+	}
+
+	// Run until SIGINT/SIGTERM. A second signal kills the process immediately.
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-signals.Done():
+	}
+	stopSignals()
+	slog.Info("Shutting down; waiting for in-flight requests", "grace_period", grace)
+	stopPolling()
+
+	// Stop accepting connections. Shutdown waits for open streams, which end once their
+	// orchestration sends Done, so it gets the grace period plus time for the final events.
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), grace+shutdownDrainTimeout)
+	defer cancelShutdown()
+	shutdownErr := make(chan error, 1)
+	go func() {
+		if srv == nil {
+			shutdownErr <- nil
+			return
+		}
+		if redirect != nil {
+			// Redirects are answered at once, so this doesn't hold up the streams' shutdown.
+			if err := redirect.Shutdown(shutdownCtx); err != nil {
+				redirect.Close()
+			}
+		}
+		shutdownErr <- srv.Shutdown(shutdownCtx)
+	}()
+
+	// Let running orchestrations finish; past the grace period, cancel them so they end with an error Done.
+	graceCtx, cancelGrace := context.WithTimeout(context.Background(), grace)
+	defer cancelGrace()
+	if !running.drain(graceCtx) {
+		slog.Warn("Grace period over; cancelling in-flight requests")
+		cancelOrchestrations()
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), shutdownDrainTimeout)
+		running.drain(drainCtx)
+		cancelDrain()
+	}
+	// Orchestrations are over; give the bots' replies a moment to post their final text, the
+	// jobs' callbacks to be delivered, and the new conversations' titles to be stored.
+	replyCtx, cancelReplies := context.WithTimeout(context.Background(), shutdownDrainTimeout)
+	for _, bot := range botReplies {
+		if !bot.Wait(replyCtx) {
+			slog.Warn("Bot replies still being posted at exit")
+		}
+	}
+	if jobs != nil && !jobs.Wait(replyCtx) {
+		slog.Warn("Job callbacks still being delivered at exit; their results can be polled")
+	}
+	if idempotency != nil && !idempotency.Wait(replyCtx) {
+		slog.Warn("Idempotent request results still being stored at exit; their retries will run again")
+	}
+	if shared != nil && !shared.Wait(replyCtx) {
+		slog.Warn("Stream events still being shared at exit; other replicas can't resume those streams")
+	}
+	if !titler.Wait(replyCtx) {
+		slog.Warn("Conversation titles still being generated at exit")
+	}
+	cancelReplies()
+
+	// Connections still open now (slow clients, watchers of finished streams) are told to
+	// reconnect later rather than seeing the connection drop.
+	sseHandler.Shutdown("server shutting down", sseHandler.RetryInterval)
+	if err := <-shutdownErr; err != nil {
+		slog.Warn("Shutdown did not complete cleanly; closing remaining connections", "error", err)
+		srv.Close()
+	}
+	// Send the spans of the last requests before exiting.
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), shutdownDrainTimeout)
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("Flushing traces failed", "error", err)
+	}
+	cancelFlush()
+	// Deferred calls stop the flight watcher and disconnect from the database after the drain.
+	slog.Info("Server stopped")
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestMetricsAfterRequest(t *testing.T) {
+	s := startServer(t, "LLM_MODEL=mock-model")
+	resp, err := http.Post(s.url+"/api", "application/json", strings.NewReader(`{"message":"Flights from Madrid to Paris"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body) // Until Done
+	resp.Body.Close()
+
+	resp, err = http.Get(s.url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	scraped := string(body)
+
+	for _, want := range []string{
+		`chat_http_requests_total{code="200",method="POST",route="/api"} 1`,
+		`chat_requests_total{intent="flight",outcome="ok"} 1`,
+		`chat_sse_events_total{event_type="Done"} 1`,
+		`chat_sse_events_total{event_type="FlightResults"} 1`,
+		`chat_sse_stream_duration_seconds_count{end_reason="done"} 1`,
+		`chat_sse_streams_in_flight 0`,
+		`chat_db_operation_duration_seconds_count{operation="query_flights"}`,
+		`chat_llm_request_duration_seconds_count{method="chat",model="mock-model",slot="llm1"}`,
+		`chat_llm_tokens_total{kind="prompt",model="mock-model"}`,
+		`chat_build_info{`,
+	} {
+		if !strings.Contains(scraped, want) {
+			t.Errorf("no %s in the scrape", want)
+		}
+	}
+}
+
+func TestDBOnlyServer(t *testing.T) {
+	// The OpenAI provider without a key: the server must start, and never call it.
+	s := startServer(t, "ORCH_MODE=db-only", "LLM_PROVIDER=openai", "OPENAI_API_KEY=")
+	for _, tt := range []struct {
+		message, want string
+		flights       bool
+	}{
+		{"Flights from Madrid to Paris", "I found 4 flights:\n- FL101: Madrid → Paris,", true},
+		{"What is the capital of France?", i18n.T("en", "message.db_only.general"), false},
+	} {
+		resp := postChat(t, s, tt.message, true)
+		var answer string
+		flights := false
+		for _, frame := range readAll(t, sse.NewReader(resp.Body)) {
+			switch frame.Event {
+			case sse.TypeMessage:
+				answer += frame.Data
+			case sse.TypeFlightResults:
+				flights = true
+			}
+		}
+		resp.Body.Close()
+		if !strings.HasPrefix(answer, tt.want) || flights != tt.flights {
+			t.Errorf("%q answered %q, flight results %v", tt.message, answer, flights)
+		}
+	}
+	if calls := llmCalls(t, s); calls != 0 {
+		t.Errorf("%d LLM calls in db-only mode", calls)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/db"
+)
+
+// migrateTimeout bounds the migrations at startup, including the wait for another replica's.
+const migrateTimeout = 10 * time.Minute
+
+// migrate applies the pending database migrations as replica, before the server seeds the
+// database and serves; see db.Migrate.
+func migrate(client db.Client, replica string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+	applied, err := db.Migrate(ctx, client, db.Migrations(), db.MigrateOptions{Owner: replica})
+	if err != nil {
+		return err
+	}
+	slog.Info("Database migrations up to date", "applied", len(applied))
+	return nil
+}
+
+// runMigrateCommand runs -migrate-dry-run or -migrate-down instead of serving, and writes the
+// migrations it ran, or would run, to w.
+func runMigrateCommand(cfg *config.Config, client db.Client, replica string, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+	opts := db.MigrateOptions{Owner: replica, DryRun: cfg.MigrateDryRun}
+	var (
+		migrations []db.Migration
+		err        error
+		verb       string
+	)
+	if cfg.MigrateDown >= 0 {
+		migrations, err = db.Rollback(ctx, client, db.Migrations(), cfg.MigrateDown, opts)
+		verb = "reverted"
+	} else {
+		migrations, err = db.Migrate(ctx, client, db.Migrations(), opts)
+		verb = "applied"
+	}
+	if cfg.MigrateDryRun {
+		verb = "to be " + verb
+	}
+	for _, m := range migrations {
+		fmt.Fprintf(w, "%s  %4d  %s\n", verb, m.Version, m.Name)
+	}
+	if err == nil && len(migrations) == 0 {
+		fmt.Fprintf(w, "no migrations %s\n", verb)
+	}
+	return err
+}
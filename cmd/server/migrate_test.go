@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/db"
+)
+
+func TestMigrateCommand(t *testing.T) {
+	store := db.NewMemoryClient()
+	run := func(dryRun bool, down int) string {
+		t.Helper()
+		cfg := config.Default()
+		cfg.MigrateDryRun, cfg.MigrateDown = dryRun, down
+		var out bytes.Buffer
+		if err := runMigrateCommand(&cfg, store, "replica-a", &out); err != nil {
+			t.Fatalf("dry run %v, down %d: %v", dryRun, down, err)
+		}
+		return out.String()
+	}
+
+	// A dry run lists the pending migrations; they are applied at startup, once.
+	if got := run(true, -1); got != "to be applied     1  query_logs_timestamp_index\n" {
+		t.Errorf("dry run:\n%s", got)
+	}
+	for range 2 {
+		if err := migrate(store, "replica-a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := run(true, -1); got != "no migrations to be applied\n" {
+		t.Errorf("dry run once applied:\n%s", got)
+	}
+	results := databaseChecks(context.Background(), store, config.BackendMemory)
+	if last := results[len(results)-1]; last.Name != "migrations" || last.Status != checkPass {
+		t.Errorf("preflight once applied: %+v", last)
+	}
+
+	// -migrate-down reverts, or with -migrate-dry-run lists, those above the version.
+	if got := run(true, 0); got != "to be reverted     1  query_logs_timestamp_index\n" {
+		t.Errorf("rollback dry run:\n%s", got)
+	}
+	if got := run(false, 1); got != "no migrations reverted\n" {
+		t.Errorf("rollback to the latest version:\n%s", got)
+	}
+	if got := run(false, 0); got != "reverted     1  query_logs_timestamp_index\n" {
+		t.Errorf("rollback:\n%s", got)
+	}
+	if got := run(false, -1); !strings.HasPrefix(got, "applied") {
+		t.Errorf("migrate after the rollback:\n%s", got)
+	}
+}
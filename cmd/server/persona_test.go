@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/orchestrator"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// personaCalls sends a question as request id and returns its snapshot, with its LLM calls,
+// from the admin API.
+func personaCalls(t *testing.T, s *testServer, id, message string) requestSnapshot {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, s.url+"/api", strings.NewReader(`{"message":"`+message+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(logging.RequestIDHeader, id)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, sse.NewReader(resp.Body))
+	resp.Body.Close()
+
+	var snapshot requestSnapshot
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		if adminGet(t, s, "/api/admin/requests/"+id, &snapshot) == http.StatusOK && len(snapshot.Calls) >= 3 {
+			return snapshot
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("request %s has calls %+v", id, snapshot.Calls)
+		}
+	}
+}
+
+// checkPersona checks that every LLM call of snapshot was given system ahead of its prompt.
+// The mock answers with the length of the prompt it got, which the recorded prompt doesn't
+// include the system prompt in.
+func checkPersona(t *testing.T, snapshot requestSnapshot, system string) {
+	t.Helper()
+	for _, call := range snapshot.Calls {
+		if want := fmt.Sprintf("(Prompt: %d characters.)", len(system)+len("\n\n")+len(call.Prompt)); !strings.Contains(call.Response, want) {
+			t.Errorf("%s call answered %q, want %s", call.Stage, call.Response, want)
+		}
+	}
+}
+
+func TestPersonaReloadsOnHangup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "persona.yaml")
+	if err := os.WriteFile(file, []byte("default: You are {{.BotName}}.\nes: Eres {{.BotName}}, tu asistente de viajes.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := startServer(t, "ADMIN_API_KEYS=admin-key", "QUERY_LOG_ENABLED=true", "QUERY_LOG_PROMPTS=true",
+		"PERSONA_BOT_NAME=FlightBuddy", "PERSONA_PROMPT_FILE="+file)
+
+	checkPersona(t, personaCalls(t, s, "persona-en-1", "What is the capital of France?"), "You are FlightBuddy.")
+	checkPersona(t, personaCalls(t, s, "persona-es-1", "Muéstrame vuelos desde Madrid a París"), "Eres FlightBuddy, tu asistente de viajes.")
+
+	// The file is read again on SIGHUP.
+	if err := os.WriteFile(file, []byte("default: You are {{.BotName}} for Acme Travel. Never discuss competitors.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.cmd.Process.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); !strings.Contains(s.logs.String(), "Reloaded prompts"); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("no reload logged:\n%s", s.logs.String())
+		}
+	}
+	checkPersona(t, personaCalls(t, s, "persona-en-2", "What is the capital of France?"), "You are FlightBuddy for Acme Travel. Never discuss competitors.")
+	checkPersona(t, personaCalls(t, s, "persona-es-2", "Muéstrame vuelos desde Madrid a París"), "You are FlightBuddy for Acme Travel. Never discuss competitors.")
+}
+
+// postOverrides sends a question with persona overrides as request id, with key as its API key
+// unless it is empty.
+func postOverrides(t *testing.T, s *testServer, id, key, overrides string) *http.Response {
+	t.Helper()
+	body := `{"message":"What is the capital of France?","persona_overrides":` + overrides + `}`
+	req, _ := http.NewRequest(http.MethodPost, s.url+"/api", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(logging.RequestIDHeader, id)
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestPersonaOverrides(t *testing.T) {
+	s := startServer(t, "ADMIN_API_KEYS=admin-key", "DEVELOPER_API_KEYS=dev-key", "QUERY_LOG_ENABLED=true", "QUERY_LOG_PROMPTS=true",
+		"PERSONA_PROMPT=You are FlightBuddy.")
+
+	// Only developer keys may override; not even admin keys.
+	for _, key := range []string{"", "other-key", "admin-key"} {
+		resp := postOverrides(t, s, "overrides-denied", key, `{"aggregator":"Answer in one word."}`)
+		if resp.StatusCode != http.StatusForbidden || errorCode(t, resp) != httpapi.CodeDeveloperKeyRequired {
+			t.Errorf("key %q: %d", key, resp.StatusCode)
+		}
+		resp.Body.Close()
+	}
+	for _, overrides := range []string{`{"llm4":"Be brief."}`, `{"aggregator":""}`, `{"worker1":"` + strings.Repeat("x", orchestrator.MaxPersonaOverrideLen+1) + `"}`} {
+		resp := postOverrides(t, s, "overrides-invalid", "dev-key", overrides)
+		if resp.StatusCode != http.StatusBadRequest || errorCode(t, resp) != httpapi.CodeInvalidOverrides {
+			t.Errorf("overrides %.40s: %d", overrides, resp.StatusCode)
+		}
+		resp.Body.Close()
+	}
+
+	// A developer's override replaces the persona for its stage only, and is recorded.
+	resp := postOverrides(t, s, "overrides-1", "dev-key", `{"aggregator":"Answer in one word."}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("developer key: %d", resp.StatusCode)
+	}
+	readAll(t, sse.NewReader(resp.Body))
+	resp.Body.Close()
+	snapshot := snapshotOf(t, s, "overrides-1")
+	if len(snapshot.PersonaOverrides) != 1 || snapshot.PersonaOverrides["aggregator"] != "Answer in one word." {
+		t.Errorf("recorded overrides %v", snapshot.PersonaOverrides)
+	}
+	var workers, aggregation []db.LLMCall
+	for _, call := range snapshot.Calls {
+		if call.Stage == "aggregation" {
+			aggregation = append(aggregation, call)
+		} else {
+			workers = append(workers, call)
+		}
+	}
+	checkPersona(t, requestSnapshot{Calls: workers}, "You are FlightBuddy.")
+	checkPersona(t, requestSnapshot{Calls: aggregation}, "Answer in one word.")
+	if len(workers) != 2 || len(aggregation) != 1 {
+		t.Errorf("calls %+v", snapshot.Calls)
+	}
+	if !strings.Contains(s.logs.String(), "Persona overridden") {
+		t.Error("override not logged")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/pii"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestMaskedStatus(t *testing.T) {
+	if got, want := maskedStatus("en", []string{pii.KindCard, pii.KindEmail}), "Masked personal data in your message before processing it: card number, email address"; got != want {
+		t.Errorf("en: %q, want %q", got, want)
+	}
+	if got, want := maskedStatus("es", []string{pii.KindPhone}), "Se ocultaron datos personales de tu mensaje antes de procesarlo: número de teléfono"; got != want {
+		t.Errorf("es: %q, want %q", got, want)
+	}
+}
+
+func TestPIIMasking(t *testing.T) {
+	s := startServer(t, "ADMIN_API_KEYS=admin-key", "QUERY_LOG_ENABLED=true", "QUERY_LOG_PROMPTS=true")
+	const card, email = "4111 1111 1111 1111", "ana@example.com"
+	message := "Book FL101 for $120.00, my card is " + card + " and my email " + email
+
+	// The stream says what was masked, in the order of the detectors.
+	resp := postChat(t, s, message, true)
+	frames := readAll(t, sse.NewReader(resp.Body))
+	resp.Body.Close()
+	var noted bool
+	for _, f := range frames {
+		if f.Event == sse.TypeStatus && f.Data == "Masked personal data in your message before processing it: email address, card number" {
+			noted = true
+		}
+	}
+	if !noted {
+		t.Errorf("no masking note in %+v", frames)
+	}
+
+	// Neither the stored message nor the prompts have the data; flight numbers and prices stay.
+	snapshot := personaCalls(t, s, "pii-1", message)
+	if want := "Book FL101 for $120.00, my card is [CARD] and my email [EMAIL]"; snapshot.Message != want {
+		t.Errorf("stored message %q, want %q", snapshot.Message, want)
+	}
+	for _, call := range snapshot.Calls {
+		if strings.Contains(call.Prompt, card) || strings.Contains(call.Prompt, email) {
+			t.Errorf("%s prompt has the personal data: %q", call.Stage, call.Prompt)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// sessionPreferences returns the preferences of sessionID for a request of the client
+// identified by key, for orchestrator.Options.Preferences: the stored ones, or empty ones
+// owned by the client if there are none. It returns nil, so the request neither uses nor
+// saves any, without a session ID, for another client's session, or if they can't be loaded.
+func sessionPreferences(ctx context.Context, store db.Client, key, sessionID string) *db.Preferences {
+	if sessionID == "" {
+		return nil
+	}
+	account := usageAccount(key)
+	prefs, err := store.GetPreferences(ctx, sessionID)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return &db.Preferences{SessionID: sessionID, Client: account}
+	case err != nil:
+		slog.WarnContext(ctx, "Failed to load session preferences; answering without them", "session_id", sessionID, "error", err)
+		return nil
+	case prefs.Client != account:
+		return nil
+	}
+	return &prefs
+}
+
+// currencyCodePattern matches ISO 4217 currency codes.
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// preferencesPatch is the body of PATCH /api/sessions/{id}/preferences. Fields left out keep
+// their value; an empty string or 0 clears one.
+type preferencesPatch struct {
+	HomeCity  *string  `json:"home_city"`
+	Currency  *string  `json:"currency"`
+	Language  *string  `json:"language"`
+	MaxBudget *float64 `json:"max_budget"`
+}
+
+// preferencesHandler serves GET and PATCH /api/sessions/{id}/preferences: the defaults a
+// session's flight questions use when they leave them out, which chat messages such as
+// "remember that I always fly from Madrid" set too. GET returns them (empty for a session
+// without any); PATCH changes the fields in its body and returns the result. The home city
+// must be one the flights serve. Sessions of other clients get 404.
+func preferencesHandler(store db.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+			w.Header().Set("Allow", "GET, PATCH, OPTIONS")
+			httpapi.Write(w, r, httpapi.MethodNotAllowed())
+			return
+		}
+		sessionID := r.PathValue("id")
+		if len(sessionID) > maxSessionIDLen || !sessionIDPattern.MatchString(sessionID) {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidSessionID, "session_id must be at most 128 letters, digits or _.:-"))
+			return
+		}
+		account := usageAccount(clientKey(r))
+		prefs, err := store.GetPreferences(r.Context(), sessionID)
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			prefs = db.Preferences{SessionID: sessionID, Client: account}
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to load session preferences", "session_id", sessionID, "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodePreferencesUnavailable, Message: "The preferences could not be loaded; please retry"})
+			return
+		case prefs.Client != account:
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeSessionNotFound, Message: "No session " + sessionID + " of yours exists"})
+			return
+		}
+		if r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, prefs)
+			return
+		}
+
+		var patch preferencesPatch
+		dec := json.NewDecoder(r.Body) // Bounded by the route's httpmw.MaxBytes.
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&patch); err != nil {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeMalformedJSON, "Request body is not valid preferences: "+err.Error()))
+			return
+		}
+		if apiErr := applyPreferencesPatch(r.Context(), store, &prefs, patch); apiErr != nil {
+			httpapi.Write(w, r, apiErr)
+			return
+		}
+		prefs.UpdatedAt = time.Now().UTC()
+		if err := store.SavePreferences(r.Context(), prefs); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to save session preferences", "session_id", sessionID, "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodeSaveFailed, Message: "The preferences could not be saved; please retry"})
+			return
+		}
+		writeJSON(w, http.StatusOK, prefs)
+	}
+}
+
+// applyPreferencesPatch validates patch and applies it to prefs, which are only saved if it
+// returns nil.
+func applyPreferencesPatch(ctx context.Context, store db.Client, prefs *db.Preferences, patch preferencesPatch) *httpapi.Error {
+	if patch.HomeCity != nil {
+		name := strings.Join(strings.Fields(*patch.HomeCity), " ")
+		prefs.HomeCity = ""
+		if name != "" {
+			routes, err := store.ListRoutes(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to list routes", "error", err)
+				return &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodeRoutesUnavailable, Message: "The home city could not be checked; please retry"}
+			}
+			cities := db.Cities(routes)
+			i := slices.IndexFunc(cities, func(city string) bool { return strings.EqualFold(city, name) })
+			if i < 0 {
+				return httpapi.BadRequest(httpapi.CodeUnknownCity, "home_city must be one of the cities we fly between: "+strings.Join(cities, ", "))
+			}
+			prefs.HomeCity = cities[i]
+		}
+	}
+	if patch.Currency != nil {
+		code := strings.ToUpper(strings.TrimSpace(*patch.Currency))
+		if code != "" && !currencyCodePattern.MatchString(code) {
+			return httpapi.BadRequest(httpapi.CodeInvalidCurrency, "currency must be a three-letter ISO 4217 code, such as EUR")
+		}
+		prefs.Currency = code
+	}
+	if patch.Language != nil {
+		code := strings.ToLower(strings.TrimSpace(*patch.Language))
+		if _, ok := requestLanguages[code]; !ok {
+			return httpapi.BadRequest(httpapi.CodeInvalidLanguage, `language must be "en" or "es"`)
+		}
+		prefs.Language = code
+	}
+	if patch.MaxBudget != nil {
+		if *patch.MaxBudget < 0 {
+			return httpapi.BadRequest(httpapi.CodeInvalidMaxBudget, "max_budget must not be negative")
+		}
+		prefs.MaxBudget = *patch.MaxBudget
+	}
+	return nil
+}
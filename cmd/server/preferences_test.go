@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+)
+
+func TestPreferencesAPI(t *testing.T) {
+	store := db.NewMemoryClient()
+	if err := store.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/sessions/{id}/preferences", preferencesHandler(store))
+	do := func(method, key, path, body string) (*httptest.ResponseRecorder, db.Preferences) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		var prefs db.Preferences
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&prefs); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, prefs
+	}
+
+	// A session without preferences has empty ones.
+	if rec, prefs := do(http.MethodGet, "key-one", "/api/sessions/s-1/preferences", ""); rec.Code != http.StatusOK || prefs.SessionID != "s-1" || prefs.HomeCity != "" || !prefs.UpdatedAt.IsZero() {
+		t.Errorf("GET: %d %+v", rec.Code, prefs)
+	}
+	rec, prefs := do(http.MethodPatch, "key-one", "/api/sessions/s-1/preferences", `{"home_city":" madrid ","currency":"usd","language":"es","max_budget":300}`)
+	if rec.Code != http.StatusOK || prefs.HomeCity != "Madrid" || prefs.Currency != "USD" || prefs.Language != "es" || prefs.MaxBudget != 300 {
+		t.Fatalf("PATCH: %d %+v", rec.Code, prefs)
+	}
+	// Fields left out keep their value; empty ones are cleared.
+	rec, prefs = do(http.MethodPatch, "key-one", "/api/sessions/s-1/preferences", `{"currency":""}`)
+	if rec.Code != http.StatusOK || prefs.HomeCity != "Madrid" || prefs.Currency != "" || prefs.MaxBudget != 300 {
+		t.Errorf("second PATCH: %d %+v", rec.Code, prefs)
+	}
+	// The chat requests of the session get them, but only the client's own.
+	if got := sessionPreferences(context.Background(), store, "key:key-one", "s-1"); got == nil || got.HomeCity != "Madrid" {
+		t.Errorf("sessionPreferences = %+v", got)
+	}
+	if got := sessionPreferences(context.Background(), store, "key:key-two", "s-1"); got != nil {
+		t.Errorf("another client's sessionPreferences = %+v", got)
+	}
+	if got := sessionPreferences(context.Background(), store, "key:key-two", "s-2"); got == nil || got.Client != usageAccount("key:key-two") {
+		t.Errorf("new session's preferences = %+v", got)
+	}
+
+	for _, tt := range []struct {
+		method, key, path, body string
+		status                  int
+	}{
+		{http.MethodGet, "key-two", "/api/sessions/s-1/preferences", "", http.StatusNotFound},
+		{http.MethodPatch, "key-two", "/api/sessions/s-1/preferences", `{"home_city":"Rome"}`, http.StatusNotFound},
+		{http.MethodPatch, "key-one", "/api/sessions/s-1/preferences", `{"home_city":"Atlantis"}`, http.StatusBadRequest},
+		{http.MethodPatch, "key-one", "/api/sessions/s-1/preferences", `{"currency":"dollars"}`, http.StatusBadRequest},
+		{http.MethodPatch, "key-one", "/api/sessions/s-1/preferences", `{"language":"fr"}`, http.StatusBadRequest},
+		{http.MethodPatch, "key-one", "/api/sessions/s-1/preferences", `{"max_budget":-1}`, http.StatusBadRequest},
+		{http.MethodPatch, "key-one", "/api/sessions/s-1/preferences", `{"home":"Rome"}`, http.StatusBadRequest},
+		{http.MethodPatch, "key-one", "/api/sessions/bad%20id/preferences", `{}`, http.StatusBadRequest},
+		{http.MethodDelete, "key-one", "/api/sessions/s-1/preferences", "", http.StatusMethodNotAllowed},
+	} {
+		if rec, _ := do(tt.method, tt.key, tt.path, tt.body); rec.Code != tt.status {
+			t.Errorf("%s %s %s: %d, want %d", tt.method, tt.path, tt.body, rec.Code, tt.status)
+		}
+	}
+	// The rejected changes weren't saved.
+	if _, prefs := do(http.MethodGet, "key-one", "/api/sessions/s-1/preferences", ""); prefs.HomeCity != "Madrid" || prefs.Language != "es" || prefs.MaxBudget != 300 {
+		t.Errorf("after rejected changes: %+v", prefs)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// clientKey identifies the client a request is rate limited as: its API key when it sends one,
+// otherwise its IP address. X-Forwarded-For is deliberately ignored since clients can forge it.
+func clientKey(r *http.Request) string {
+	if key := requestAPIKey(r); key != "" {
+		return "key:" + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimited is the 429 rejection of a rate-limited request, with a Retry-After hint.
+func rateLimited(retryAfter time.Duration, code, message string) *httpapi.Error {
+	return &httpapi.Error{Status: http.StatusTooManyRequests, Code: code, Message: message, RetryAfter: retryAfter}
+}
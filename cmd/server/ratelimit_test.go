@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestClientKey(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"IP address", nil, "ip:192.0.2.1"},
+		{"forwarded IP ignored", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "ip:192.0.2.1"},
+		{"API key", map[string]string{"X-API-Key": "k1"}, "key:k1"},
+		{"bearer token", map[string]string{"Authorization": "Bearer k2"}, "key:k2"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api", nil)
+		for name, value := range tt.headers {
+			r.Header.Set(name, value)
+		}
+		if got := clientKey(r); got != tt.want {
+			t.Errorf("%s: clientKey = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// postChat sends message to s's chat endpoint, as JSON with the given stream setting.
+func postChat(t *testing.T, s *testServer, message string, stream bool) *http.Response {
+	t.Helper()
+	body, _ := json.Marshal(map[string]any{"message": message, "language": "en", "stream": stream})
+	resp, err := http.Post(s.url+"/api", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// errorCode returns the code of a JSON error response.
+func errorCode(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("error body: %v", err)
+	}
+	return body.Error.Code
+}
+
+func TestRequestRateLimit(t *testing.T) {
+	s := startServer(t, "RATE_LIMIT_RPS=0.1", "RATE_LIMIT_BURST=3")
+
+	// A client hammering the endpoint gets its burst through and 429s for the rest. The
+	// responses are checked here rather than in the goroutines, which can't stop the test.
+	responses := make(chan *http.Response, 10)
+	for range 10 {
+		go func() {
+			body := `{"message":"What is the capital of France?","stream":false}`
+			resp, err := http.Post(s.url+"/api", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Error(err)
+			}
+			responses <- resp
+		}()
+	}
+	codes := map[int]int{}
+	for range 10 {
+		resp := <-responses
+		if resp == nil {
+			continue
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if code := errorCode(t, resp); code != httpapi.CodeRateLimited || resp.Header.Get("Retry-After") == "" {
+				t.Errorf("429 with code %q, Retry-After %q", code, resp.Header.Get("Retry-After"))
+			}
+		}
+		resp.Body.Close()
+		codes[resp.StatusCode]++
+	}
+	if codes[http.StatusOK] != 3 || codes[http.StatusTooManyRequests] != 7 {
+		t.Errorf("responses %v, want 3 answered and 7 rate limited", codes)
+	}
+}
+
+func TestStreamCapRejects(t *testing.T) {
+	s := startServer(t, "RATE_LIMIT_MAX_STREAMS=1", "LLM_MOCK_LATENCY=500ms")
+	first := postChat(t, s, "What is the capital of France?", true)
+	defer first.Body.Close()
+	reader := sse.NewReader(first.Body)
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := postChat(t, s, "What is the capital of Spain?", true)
+	second.Body.Close()
+	if second.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second stream answered %d, want 429", second.StatusCode)
+	}
+	// The first one isn't disturbed, and its slot frees up when it ends.
+	if frames := readAll(t, reader); len(frames) == 0 || frames[len(frames)-1].Data != sse.OutcomeOK {
+		t.Errorf("first stream ended with %+v", frames)
+	}
+	third := postChat(t, s, "What is the capital of Spain?", false)
+	third.Body.Close()
+	if third.StatusCode != http.StatusOK {
+		t.Errorf("request after the stream ended answered %d", third.StatusCode)
+	}
+}
+
+func TestStreamCapQueues(t *testing.T) {
+	s := startServer(t, "RATE_LIMIT_MAX_STREAMS=1", "RATE_LIMIT_QUEUE=true", "LLM_MOCK_LATENCY=500ms")
+	first := postChat(t, s, "What is the capital of France?", true)
+	defer first.Body.Close()
+	reader := sse.NewReader(first.Body)
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second waits in its stream, told its place, and is answered once the first ends.
+	second := postChat(t, s, "What is the capital of Spain?", true)
+	defer second.Body.Close()
+	if second.StatusCode != http.StatusOK {
+		t.Fatalf("queued stream answered %d", second.StatusCode)
+	}
+	frames := readAll(t, sse.NewReader(second.Body))
+	queued := -1
+	for i, frame := range frames {
+		if frame.Event == sse.TypeStatus && frame.Data == "Queued (position 1)" {
+			queued = i
+			break
+		}
+	}
+	if queued < 0 {
+		t.Fatalf("events %+v, want a queue position", frames)
+	}
+	var answered bool
+	for _, frame := range frames[queued:] {
+		answered = answered || frame.Event == sse.TypeMessage
+	}
+	if last := frames[len(frames)-1]; !answered || last.Event != sse.TypeDone || last.Data != sse.OutcomeOK {
+		t.Errorf("events %+v, want the answer after the queue position, then Done ok", frames)
+	}
+	readAll(t, reader)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/orchestrator"
+	"github.com/Cris245/go-llm-chat/internal/persona"
+)
+
+// reloadTimeout bounds a reload's refresh of the cities.
+const reloadTimeout = 10 * time.Second
+
+// reloader reads again, without a restart, what the server otherwise reads at startup only:
+// the persona's prompt file, the city aliases file and the model prices of the configuration.
+// Everything is read and checked before anything is swapped in, so a reload that fails
+// changes nothing. Requests already running keep the prompts they started with.
+type reloader struct {
+	loadConfig  func() (*config.Config, error)
+	persona     *persona.Persona // Nil without a persona
+	cities      *orchestrator.CityIndex
+	aliasesFile string // Empty without an aliases file
+	usage       *usageTracker
+
+	mu      sync.Mutex        // One reload at a time
+	aliases map[string]string // The aliases file's, as last loaded
+}
+
+// reloadReport lists what a reload changed: the persona's prompts by language (persona.DefaultKey
+// for the default), the city aliases, and the models whose price was changed, added or removed.
+type reloadReport struct {
+	Persona     []string `json:"persona"`
+	CityAliases []string `json:"city_aliases"`
+	Prices      []string `json:"prices"`
+}
+
+// empty reports whether the reload changed nothing.
+func (r reloadReport) empty() bool {
+	return len(r.Persona) == 0 && len(r.CityAliases) == 0 && len(r.Prices) == 0
+}
+
+// reload reads and checks the persona, the city aliases and the configuration's prices, then
+// puts them all in use. If any fails to load, none is changed and the error says which.
+func (rl *reloader) reload(ctx context.Context) (reloadReport, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	report := reloadReport{Persona: []string{}, CityAliases: []string{}, Prices: []string{}}
+
+	var staged *persona.Staged
+	if rl.persona != nil {
+		var err error
+		if staged, err = rl.persona.Stage(); err != nil {
+			return report, err
+		}
+		report.Persona = append(report.Persona, staged.Changed()...)
+	}
+	var aliases map[string]string
+	if rl.aliasesFile != "" {
+		var err error
+		if aliases, err = orchestrator.LoadCityAliases(rl.aliasesFile); err != nil {
+			return report, fmt.Errorf("city aliases: %w", err)
+		}
+		report.CityAliases = append(report.CityAliases, changedKeys(rl.aliases, aliases)...)
+	}
+	cfg, err := rl.loadConfig()
+	if err != nil {
+		return report, fmt.Errorf("configuration: %w", err)
+	}
+	report.Prices = append(report.Prices, changedKeys(*rl.usage.prices.Load(), pricesWith(cfg.Usage.Prices))...)
+
+	if staged != nil {
+		staged.Commit()
+	}
+	if rl.aliasesFile != "" {
+		rl.aliases = aliases
+		rl.cities.SetAliases(aliases)
+		ctx, cancel := context.WithTimeout(ctx, reloadTimeout)
+		defer cancel()
+		if err := rl.cities.Refresh(ctx); err != nil {
+			slog.WarnContext(ctx, "Failed to refresh the cities; the new aliases apply from the next refresh", "error", err)
+		}
+	}
+	rl.usage.setPrices(cfg.Usage.Prices)
+	return report, nil
+}
+
+// changedKeys returns the keys whose values differ between old and next, including those only
+// one of them has, sorted.
+func changedKeys[V comparable](old, next map[string]V) []string {
+	var changed []string
+	for key, value := range next {
+		if was, ok := old[key]; !ok || was != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range old {
+		if _, ok := next[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// logReload logs the outcome of a reload.
+func logReload(ctx context.Context, trigger string, report reloadReport, err error) {
+	if err != nil {
+		slog.ErrorContext(ctx, "Reload failed; keeping the previous settings", "trigger", trigger, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Reloaded prompts, city aliases and prices", "trigger", trigger,
+		"persona", report.Persona, "city_aliases", report.CityAliases, "prices", report.Prices, "changed", !report.empty())
+}
+
+// reloadOnHangup reloads whenever the process gets a SIGHUP.
+func (rl *reloader) reloadOnHangup() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		report, err := rl.reload(context.Background())
+		logReload(context.Background(), "SIGHUP", report, err)
+	}
+}
+
+// reloadHandler serves POST /api/admin/reload: it reloads and answers with what changed, or
+// with 422 and the reason if something failed to load, in which case nothing changed.
+func reloadHandler(rl *reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := rl.reload(r.Context())
+		logReload(r.Context(), "admin", report, err)
+		if err != nil {
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusUnprocessableEntity, Code: httpapi.CodeReloadRejected, Message: "Nothing was reloaded: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"changed": report})
+	}
+}
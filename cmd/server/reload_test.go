@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/orchestrator"
+	"github.com/Cris245/go-llm-chat/internal/persona"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestChangedKeys(t *testing.T) {
+	got := changedKeys(map[string]int{"a": 1, "b": 2, "c": 3}, map[string]int{"a": 1, "b": 20, "d": 4})
+	if want := []string{"b", "c", "d"}; !slices.Equal(got, want) {
+		t.Errorf("changedKeys = %v, want %v", got, want)
+	}
+	if got := changedKeys(map[string]int{"a": 1}, map[string]int{"a": 1}); len(got) != 0 {
+		t.Errorf("changedKeys of equal maps = %v", got)
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	personaFile := write("persona.yaml", "default: You are {{.BotName}}.\n")
+	aliasesFile := write("aliases.yaml", "Lisboa: Lisbon\n")
+	p, err := persona.New(persona.Config{BotName: "FlightBuddy", File: personaFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := db.NewMemoryClient()
+	if err := store.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	prices := map[string]llmclient.Price{"house-model": {Prompt: 1, Completion: 2}}
+	var configErr error
+	rl := &reloader{
+		loadConfig: func() (*config.Config, error) {
+			cfg := config.Default()
+			cfg.Usage.Prices = prices
+			return &cfg, configErr
+		},
+		persona:     p,
+		cities:      orchestrator.NewCityIndex(store, map[string]string{"Lisboa": "Lisbon"}),
+		aliasesFile: aliasesFile,
+		usage:       newUsageTracker(store, 0, prices),
+		aliases:     map[string]string{"Lisboa": "Lisbon"},
+	}
+
+	// Nothing edited, nothing changed.
+	report, err := rl.reload(context.Background())
+	if err != nil || !report.empty() {
+		t.Errorf("reload without edits = %+v, %v", report, err)
+	}
+
+	// Each edit is reported and applied.
+	write("persona.yaml", "default: You are {{.BotName}}, at your service.\nes: Eres {{.BotName}}.\n")
+	write("aliases.yaml", "Lisboa: Lisbon\nMúnich: Munich\n")
+	prices = map[string]llmclient.Price{"house-model": {Prompt: 3, Completion: 4}, "new-model": {Prompt: 1, Completion: 1}}
+	report, err = rl.reload(context.Background())
+	if err != nil || !slices.Equal(report.Persona, []string{persona.DefaultKey, "es"}) ||
+		!slices.Equal(report.CityAliases, []string{"Múnich"}) || !slices.Equal(report.Prices, []string{"house-model", "new-model"}) {
+		t.Errorf("reload = %+v, %v", report, err)
+	}
+	if p.Prompt("es") != "Eres FlightBuddy." || (*rl.usage.prices.Load())["new-model"] != prices["new-model"] || rl.aliases["Múnich"] != "Munich" {
+		t.Errorf("after the reload: prompt %q, prices %v, aliases %v", p.Prompt("es"), *rl.usage.prices.Load(), rl.aliases)
+	}
+
+	// If anything fails to load, nothing changes, and the error says what failed.
+	write("aliases.yaml", "Lisboa: Lisbon\nRoma: Rome\n")
+	prices = map[string]llmclient.Price{"house-model": {Prompt: 5, Completion: 5}}
+	for _, tt := range []struct {
+		name, file, content, wantErr string
+		configErr                    error
+	}{
+		{"template", "persona.yaml", "default: You are {{.BotNam}}.\n", "BotNam", nil},
+		{"YAML", "persona.yaml", "default: [\n", "persona", nil},
+		{"aliases", "aliases.yaml", "- Lisboa\n", "city aliases", nil},
+		{"configuration", "", "", "configuration: bad port", errors.New("bad port")},
+	} {
+		// The others are edited validly, so a reload that went through would show.
+		write("persona.yaml", "default: You are {{.BotName}}, new.\n")
+		if tt.file != "" {
+			write(tt.file, tt.content)
+		}
+		configErr = tt.configErr
+		if _, err := rl.reload(context.Background()); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want one about %q", tt.name, err, tt.wantErr)
+		}
+		if p.Prompt("en") != "You are FlightBuddy, at your service." || rl.aliases["Roma"] != "" || (*rl.usage.prices.Load())["house-model"].Prompt != 3 {
+			t.Errorf("%s: a failed reload changed prompt %q, aliases %v, prices %v", tt.name, p.Prompt("en"), rl.aliases, *rl.usage.prices.Load())
+		}
+		write("aliases.yaml", "Lisboa: Lisbon\nRoma: Rome\n")
+	}
+}
+
+// adminReload posts /api/admin/reload and returns the response.
+func adminReload(t *testing.T, s *testServer) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, s.url+"/api/admin/reload", nil)
+	req.Header.Set("X-API-Key", "admin-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAdminReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "persona.yaml")
+	if err := os.WriteFile(file, []byte("default: You are {{.BotName}}.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := startServer(t, "ADMIN_API_KEYS=admin-key", "QUERY_LOG_ENABLED=true", "QUERY_LOG_PROMPTS=true",
+		"PERSONA_BOT_NAME=FlightBuddy", "PERSONA_PROMPT_FILE="+file, "LLM_MOCK_LATENCY=300ms")
+
+	// A request is running when the template is edited and reloaded.
+	req, _ := http.NewRequest(http.MethodPost, s.url+"/api", strings.NewReader(`{"message":"What is the capital of France?"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(logging.RequestIDHeader, "reload-running")
+	running, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer running.Body.Close()
+	reader := sse.NewReader(running.Body)
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("default: You are {{.BotName}} for Acme Travel.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	resp := adminReload(t, s)
+	var report struct {
+		Changed reloadReport `json:"changed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil || resp.StatusCode != http.StatusOK || !slices.Equal(report.Changed.Persona, []string{persona.DefaultKey}) {
+		t.Errorf("reload: %d %+v, %v", resp.StatusCode, report, err)
+	}
+	resp.Body.Close()
+
+	// New requests get the new prompt; the running one finishes on the old.
+	checkPersona(t, personaCalls(t, s, "reload-new", "What is the capital of France?"), "You are FlightBuddy for Acme Travel.")
+	readAll(t, reader)
+	var snapshot requestSnapshot
+	for deadline := time.Now().Add(2 * time.Second); adminGet(t, s, "/api/admin/requests/reload-running", &snapshot) != http.StatusOK || len(snapshot.Calls) < 3; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("running request has calls %+v", snapshot.Calls)
+		}
+	}
+	checkPersona(t, snapshot, "You are FlightBuddy.")
+
+	// A template that doesn't parse is rejected, with the reason, and the prompt stays.
+	if err := os.WriteFile(file, []byte("default: You are {{.BotName}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	resp = adminReload(t, s)
+	var body struct {
+		Error struct {
+			Code, Message string
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusUnprocessableEntity ||
+		body.Error.Code != httpapi.CodeReloadRejected || !strings.Contains(body.Error.Message, "default") {
+		t.Errorf("bad template: %d %+v, %v", resp.StatusCode, body, err)
+	}
+	resp.Body.Close()
+	checkPersona(t, personaCalls(t, s, "reload-after-rejected", "What is the capital of France?"), "You are FlightBuddy for Acme Travel.")
+}
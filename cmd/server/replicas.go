@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// replicaID returns the name of this replica: the configured one, or else the host name, which
+// is unique per container or pod.
+func replicaID(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "unknown"
+}
+
+const (
+	sharedStreamPoll    = 250 * time.Millisecond // How often a stream running on another replica is read again
+	sharedStreamTimeout = 5 * time.Second        // Bounds each read or write of a shared stream
+)
+
+// sharedStreams shares the streams of the requests this replica runs with the other replicas
+// through db.Client, so a client whose reconnection (Last-Event-ID, GET /api/stream/{id}) or
+// idempotent retry lands on another replica behind the load balancer is served there too. The
+// events are written as they are published; a replica that doesn't know a stream reads it from
+// the database and, while it runs, reads it again every sharedStreamPoll for new events.
+type sharedStreams struct {
+	store     db.Client
+	streams   *sse.Registry
+	retention time.Duration // How long a finished stream can be resumed, as in the registry
+	hold      time.Duration // How long a running stream is kept if it never finishes (e.g. a crash)
+	now       func() time.Time
+
+	wg sync.WaitGroup // Streams being written
+}
+
+func newSharedStreams(store db.Client, streams *sse.Registry, retention, hold time.Duration) *sharedStreams {
+	return &sharedStreams{store: store, streams: streams, retention: retention, hold: hold, now: time.Now}
+}
+
+// share writes the events of stream, a request of this replica in session sessionID (if any),
+// to the database as they are published, until it finishes. Events that fail to be written are
+// written with the next ones, so the stored stream has no gaps.
+func (s *sharedStreams) share(stream *sse.Stream, sessionID string) {
+	if s == nil {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		var pending []db.StoredEvent
+		// The context is never cancelled; FollowBatches ends with the stream.
+		_ = stream.FollowBatches(context.Background(), func(events []sse.Event, done bool) {
+			pending = append(pending, storedEvents(events)...)
+			expiresAt := s.now().UTC().Add(s.hold)
+			if done {
+				expiresAt = s.now().UTC().Add(s.retention)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), sharedStreamTimeout)
+			defer cancel()
+			if err := s.store.AppendStreamEvents(ctx, stream.ID(), sessionID, pending, done, expiresAt); err != nil {
+				slog.WarnContext(ctx, "Failed to share stream events", "stream", stream.ID(), "events", len(pending), "done", done, "error", err)
+				return
+			}
+			pending = nil
+		})
+	}()
+}
+
+// find returns the stream with the given ID from the database, for a stream this replica
+// doesn't know, and registers it so later reconnections find it here. A finished stream comes
+// with all its events; a running one is fed its events as its replica shares them, until it
+// finishes. ok is false when the database has no such stream or can't be read.
+func (s *sharedStreams) find(ctx context.Context, id string) (stream *sse.Stream, ok bool) {
+	if s == nil {
+		return nil, false
+	}
+	readCtx, cancel := context.WithTimeout(ctx, sharedStreamTimeout)
+	shared, err := s.store.GetStream(readCtx, id)
+	cancel()
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			slog.WarnContext(ctx, "Failed to read a shared stream", "stream", id, "error", err)
+		}
+		return nil, false
+	}
+	if shared.Done {
+		return s.streams.Restore(id, replayEvents(shared.Events)), true
+	}
+	stream, isNew := s.streams.Remote(id)
+	if isNew {
+		after := publishAfter(stream, shared.Events, 0)
+		go s.follow(context.WithoutCancel(ctx), stream, after)
+	}
+	return stream, true
+}
+
+// follow publishes to stream the events its replica shares after sequence number after, until
+// the stream is done, disappears from the database (it expired, e.g. after its replica
+// crashed) or has run for longer than a request can, and then closes it.
+func (s *sharedStreams) follow(ctx context.Context, stream *sse.Stream, after int64) {
+	defer stream.Close()
+	deadline := s.now().Add(s.hold)
+	ticker := time.NewTicker(sharedStreamPoll)
+	defer ticker.Stop()
+	for range ticker.C {
+		readCtx, cancel := context.WithTimeout(ctx, sharedStreamTimeout)
+		shared, err := s.store.GetStream(readCtx, stream.ID())
+		cancel()
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			slog.WarnContext(ctx, "Shared stream expired before it finished", "stream", stream.ID())
+			return
+		case err != nil:
+			slog.WarnContext(ctx, "Failed to read a shared stream; retrying", "stream", stream.ID(), "error", err)
+		default:
+			after = publishAfter(stream, shared.Events, after)
+			if shared.Done {
+				return
+			}
+		}
+		if s.now().After(deadline) {
+			slog.WarnContext(ctx, "Shared stream did not finish in time", "stream", stream.ID())
+			return
+		}
+	}
+}
+
+// publishAfter publishes the stored events that follow sequence number after, in order, and
+// returns the last sequence number published. Events stored twice, after a write that failed
+// but had gone through, are skipped.
+func publishAfter(stream *sse.Stream, stored []db.StoredEvent, after int64) int64 {
+	for _, event := range replayEvents(stored) {
+		if event.Seq == after+1 {
+			stream.Publish(event)
+			after = event.Seq
+		}
+	}
+	return after
+}
+
+// Wait blocks until the streams being shared have been written or ctx is done, and reports
+// whether they all were. Shutdown calls it after the orchestrations have drained.
+func (s *sharedStreams) Wait(ctx context.Context) bool {
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
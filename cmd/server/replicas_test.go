@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestReplicaID(t *testing.T) {
+	if got := replicaID("replica-a"); got != "replica-a" {
+		t.Errorf("configured replica %q", got)
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		if got := replicaID(""); got != host {
+			t.Errorf("replica %q, want the host name %q", got, host)
+		}
+	}
+}
+
+// testReplica is one server replica's streams and its GET /api/stream/{id}, as main wires
+// them, over a database shared with other replicas.
+type testReplica struct {
+	streams *sse.Registry
+	shared  *sharedStreams // Nil if streams aren't shared
+	keys    *idempotencyKeys
+	server  *httptest.Server
+}
+
+func newTestReplica(t *testing.T, store db.Client, share bool) *testReplica {
+	r := &testReplica{streams: sse.NewRegistry(time.Minute)}
+	if share {
+		r.shared = newSharedStreams(store, r.streams, time.Minute, time.Minute)
+	}
+	r.keys = newIdempotencyKeys(store, r.streams, r.find, time.Minute, time.Minute)
+	handler := sse.NewHandler()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/stream/{id}", func(w http.ResponseWriter, req *http.Request) {
+		stream, ok := r.find(req.Context(), req.PathValue("id"))
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		var after int64
+		if _, seq, ok := sse.ParseEventID(req.Header.Get("Last-Event-ID")); ok {
+			after = seq
+		}
+		handler.ServeStream(w, req, stream, after)
+	})
+	r.server = httptest.NewServer(mux)
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// find is main's findStream: this replica's streams, then the shared ones.
+func (r *testReplica) find(ctx context.Context, id string) (*sse.Stream, bool) {
+	if stream, ok := r.streams.Get(id); ok {
+		return stream, true
+	}
+	return r.shared.find(ctx, id)
+}
+
+// resume asks the replica for a stream's events after lastEventID ("" for all of them), and
+// returns the response for the caller to read.
+func (r *testReplica) resume(t *testing.T, id, lastEventID string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, r.server.URL+"/api/stream/"+id, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// waitShared waits until the database has n events of the stream.
+func waitShared(t *testing.T, store db.Client, id string, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if shared, err := store.GetStream(context.Background(), id); err == nil && len(shared.Events) >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stream %s doesn't have %d events shared", id, n)
+		}
+	}
+}
+
+// frameIDs returns the event IDs of frames.
+func frameIDs(frames []sse.Frame) string {
+	ids := make([]string, len(frames))
+	for i, f := range frames {
+		ids[i] = f.ID
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestResumeOnOtherReplica(t *testing.T) {
+	store := db.NewMemoryClient()
+	a, b := newTestReplica(t, store, true), newTestReplica(t, store, true)
+
+	// A request runs on replica A, which has sent its first two events when the client drops.
+	stream := a.streams.Create()
+	a.shared.share(stream, "session-1")
+	id := stream.ID()
+	stream.Publish(sse.Started(id))
+	stream.Publish(sse.Status("Searching flights"))
+	stream.Publish(sse.MessageChunk("FL101 ", false))
+	waitShared(t, store, id, 3)
+
+	// The reconnection lands on replica B, which resumes after the last event the client had,
+	// and follows the stream as A goes on.
+	resp := b.resume(t, id, id+"-2")
+	defer resp.Body.Close()
+	reader := sse.NewReader(resp.Body)
+	first, err := reader.Next()
+	if err != nil || first.ID != id+"-3" || first.Event != sse.TypeMessage || first.Data != "FL101 " {
+		t.Fatalf("first event on B %+v, %v", first, err)
+	}
+	stream.Publish(sse.MessageChunk("leaves at 09:00.", true))
+	stream.Publish(sse.Done(sse.DonePayload{Outcome: sse.OutcomeOK}))
+	stream.Close()
+	rest := readAll(t, reader)
+	if got := frameIDs(rest); got != id+"-4 "+id+"-5" || rest[0].Data != "leaves at 09:00." || rest[1].Event != sse.TypeDone {
+		t.Errorf("rest on B %+v", rest)
+	}
+
+	// Once it has finished, any replica replays it whole.
+	c := newTestReplica(t, store, true)
+	waitShared(t, store, id, 5)
+	resp = c.resume(t, id, "")
+	frames := readAll(t, sse.NewReader(resp.Body))
+	resp.Body.Close()
+	if got := frameIDs(frames); got != strings.Join([]string{id + "-1", id + "-2", id + "-3", id + "-4", id + "-5"}, " ") {
+		t.Errorf("replay on C %s", got)
+	}
+
+	// Streams are found on other replicas only when they are shared.
+	if resp := b.resume(t, "no-such-stream", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown stream: %d", resp.StatusCode)
+	}
+	if resp := newTestReplica(t, store, false).resume(t, id, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("stream found without sharing: %d", resp.StatusCode)
+	}
+}
+
+func TestIdempotentRetryOnOtherReplica(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryClient()
+	a, b := newTestReplica(t, store, true), newTestReplica(t, store, true)
+
+	// The request starts on replica A.
+	call, stream, apiErr := a.keys.begin(ctx, "ip:192.0.2.1", "retry-1", "", "hash-1")
+	if call == nil || stream != nil || apiErr != nil {
+		t.Fatalf("begin on A = %v, %v, %v", call, stream, apiErr)
+	}
+	running := a.streams.Create()
+	a.shared.share(running, "")
+	a.keys.started(ctx, call, running)
+	running.Publish(sse.Started(running.ID()))
+	waitShared(t, store, running.ID(), 1)
+
+	// Its retry on replica B gets the same stream, while it runs, instead of running it again.
+	call, stream, apiErr = b.keys.begin(ctx, "ip:192.0.2.1", "retry-1", "", "hash-1")
+	if call != nil || stream == nil || stream.ID() != running.ID() || apiErr != nil {
+		t.Fatalf("retry on B = %v, %v, %v", call, stream, apiErr)
+	}
+	running.Publish(sse.MessageChunk("FL101.", true))
+	running.Publish(sse.Done(sse.DonePayload{Outcome: sse.OutcomeOK}))
+	running.Close()
+	done := make(chan struct{})
+	go func() {
+		stream.Follow(ctx, func(sse.Event) {})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream on B didn't finish")
+	}
+	if events := stream.Events(); len(events) != 3 || events[2].Type != sse.TypeDone {
+		t.Errorf("events on B %+v", events)
+	}
+}
+
+func TestReplicaNamed(t *testing.T) {
+	s := startServer(t, "REPLICA_ID=replica-a", "SSE_SHARED_STREAMS=true")
+	var version struct {
+		Replica  string          `json:"replica"`
+		Features map[string]bool `json:"features"`
+	}
+	resp, err := http.Get(s.url + "/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&version)
+	resp.Body.Close()
+	if version.Replica != "replica-a" || !version.Features["shared_streams"] {
+		t.Errorf("version %+v", version)
+	}
+
+	// The telemetry and the logs name the replica.
+	resp, err = http.Post(s.url+"/api?format=json", "application/json", strings.NewReader(`{"message":"Flights from Madrid to Paris"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := doneOf(t, readAll(t, sse.NewReader(resp.Body)))
+	resp.Body.Close()
+	if telemetry, _ := done.Telemetry.(map[string]any); telemetry["replica"] != "replica-a" {
+		t.Errorf("telemetry %+v", done.Telemetry)
+	}
+	if !strings.Contains(s.logs.String(), "replica=replica-a") {
+		t.Errorf("logs don't name the replica:\n%s", s.logs.String())
+	}
+}
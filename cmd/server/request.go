@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/orchestrator"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+const (
+	maxRequestBytes   = 64 << 10 // Upper bound on a JSON or text request body (64 KiB).
+	maxQueryBytes     = 8 << 10  // Upper bound on a GET /api query string (8 KiB); browsers and proxies cap URLs anyway.
+	maxSessionIDLen   = 128
+	maxMessageLength  = 8000 // Characters; longer messages are rejected rather than truncated.
+	maxCallbackURLLen = 2048
+)
+
+// sessionIDPattern restricts session IDs to characters that are safe in URLs and logs.
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// chatRequest is the JSON body accepted by POST /api:
+//
+//	{"message":"...","session_id":"...","language":"es","stream":true,"aggregate":false}
+//
+// Only message is required; stream and aggregate default to the server's feature settings.
+// With callback_url the request runs as a job and is answered with 202 (see jobs.go).
+// With idempotency_key (or the Idempotency-Key header) a retry doesn't run it again.
+// Unknown fields are ignored so clients can send newer fields to older servers.
+type chatRequest struct {
+	Message   string `json:"message"`
+	SessionID string `json:"session_id"`
+	Language  string `json:"language"`  // "en" or "es"; empty means detect from the message
+	Stream    bool   `json:"stream"`    // Stream the final answer in chunks
+	Aggregate bool   `json:"aggregate"` // False returns the worker answers as they are
+	Force     bool   `json:"force"`     // Answer anew a message the session just had answered (see repeatedAnswer)
+
+	CallbackURL    string `json:"callback_url"`    // Report progress and the answer here instead of streaming them
+	IdempotencyKey string `json:"idempotency_key"` // Like the Idempotency-Key header; see idempotency.go
+
+	// PersonaOverrides replace the persona's system prompt for some stages; developer keys only
+	// (see orchestrator.Options.PersonaOverrides).
+	PersonaOverrides map[string]string `json:"persona_overrides,omitempty"`
+}
+
+// parseChatRequest reads a /api request. GET requests carry the message and options in the
+// query string. For POST, the Content-Type decides how the body is read:
+//
+//   - application/json: the message and options as JSON.
+//   - application/x-www-form-urlencoded and multipart/form-data: the same fields as form
+//     fields, for webhook sources and HTML forms. A URL-encoded body without a message field
+//     is the plain-text message, as curl -d sends it.
+//   - text/plain, or none: the plain-text message, as older clients send it.
+//
+// Other types are rejected with 415. Text and form fields are transcoded to UTF-8 from the
+// declared charset (see decodeCharset). Options the client leaves out keep their values from
+// defaults; an Accept hint (see acceptStream) overrides the default streaming mode, and an
+// explicit stream field or parameter overrides both.
+func parseChatRequest(r *http.Request, defaults chatRequest) (chatRequest, *httpapi.Error) {
+	if stream, ok := acceptStream(r); ok {
+		defaults.Stream = stream
+	}
+	if r.Method == http.MethodGet {
+		return parseQueryRequest(r, defaults)
+	}
+	body, err := io.ReadAll(r.Body) // Bounded by the route's httpmw.MaxBytes.
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return chatRequest{}, &httpapi.Error{Status: http.StatusRequestEntityTooLarge, Code: httpapi.CodeBodyTooLarge, Message: "Request body is too large"}
+		}
+		return chatRequest{}, httpapi.BadRequest(httpapi.CodeUnreadableBody, "Error reading request body")
+	}
+
+	req := defaults
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		if err := json.Unmarshal(body, &req); err != nil {
+			return chatRequest{}, httpapi.BadRequest(httpapi.CodeMalformedJSON, "Request body is not valid JSON: "+err.Error())
+		}
+		return req, req.validate()
+	case "application/x-www-form-urlencoded":
+		if form, err := url.ParseQuery(string(body)); err == nil && form.Has("message") {
+			if apiErr := decodeForm(form, params["charset"]); apiErr != nil {
+				return chatRequest{}, apiErr
+			}
+			return parseFormRequest(form, defaults)
+		}
+	case "multipart/form-data":
+		form, apiErr := readMultipartForm(body, params["boundary"])
+		if apiErr != nil {
+			return chatRequest{}, apiErr
+		}
+		return parseFormRequest(form, defaults)
+	case "", "text/plain":
+	default:
+		return chatRequest{}, &httpapi.Error{
+			Status:  http.StatusUnsupportedMediaType,
+			Code:    httpapi.CodeUnsupportedMediaType,
+			Message: "Content-Type must be application/json, application/x-www-form-urlencoded, multipart/form-data or text/plain",
+		}
+	}
+	message, ok := decodeCharset(string(body), params["charset"])
+	if !ok {
+		return chatRequest{}, unsupportedCharset(params["charset"])
+	}
+	req.Message = message
+	return req, req.validate()
+}
+
+// parseFormRequest reads the fields of a form body, already in UTF-8. They are named as in
+// the JSON body; persona_overrides can only be sent as JSON.
+func parseFormRequest(form url.Values, defaults chatRequest) (chatRequest, *httpapi.Error) {
+	req := defaults
+	req.Message = form.Get("message")
+	req.SessionID = form.Get("session_id")
+	req.Language = form.Get("language")
+	req.CallbackURL = form.Get("callback_url")
+	req.IdempotencyKey = form.Get("idempotency_key")
+	if apiErr := parseModes(form, &req); apiErr != nil {
+		return chatRequest{}, apiErr
+	}
+	return req, req.validate()
+}
+
+// readMultipartForm reads the fields of a multipart/form-data body, transcoded to UTF-8 from
+// each part's charset or, failing that, the form's _charset_ field. File parts count as fields
+// too, so a message can be uploaded as a text file.
+func readMultipartForm(body []byte, boundary string) (url.Values, *httpapi.Error) {
+	if boundary == "" {
+		return nil, httpapi.BadRequest(httpapi.CodeMalformedForm, "multipart/form-data needs a boundary")
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	form := url.Values{}
+	charsets := map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, httpapi.BadRequest(httpapi.CodeMalformedForm, "Request body is not a valid multipart form: "+err.Error())
+		}
+		value, err := io.ReadAll(part) // Bounded by the body.
+		if err != nil {
+			return nil, httpapi.BadRequest(httpapi.CodeMalformedForm, "Request body is not a valid multipart form: "+err.Error())
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+		if _, params, err := mime.ParseMediaType(part.Header.Get("Content-Type")); err == nil && params["charset"] != "" {
+			charsets[name] = params["charset"]
+		}
+		form.Add(name, string(value))
+	}
+	for name, values := range form {
+		charset := charsets[name]
+		if charset == "" {
+			charset = form.Get("_charset_")
+		}
+		for i, value := range values {
+			decoded, ok := decodeCharset(value, charset)
+			if !ok {
+				return nil, unsupportedCharset(charset)
+			}
+			values[i] = decoded
+		}
+	}
+	return form, nil
+}
+
+// decodeForm transcodes the fields of a URL-encoded form to UTF-8 from charset, the body's
+// declared charset, or failing that the form's _charset_ field, as browsers send it.
+func decodeForm(form url.Values, charset string) *httpapi.Error {
+	if charset == "" {
+		charset = form.Get("_charset_")
+	}
+	for _, values := range form {
+		for i, value := range values {
+			decoded, ok := decodeCharset(value, charset)
+			if !ok {
+				return unsupportedCharset(charset)
+			}
+			values[i] = decoded
+		}
+	}
+	return nil
+}
+
+// decodeCharset converts text in charset to UTF-8. UTF-8 (also when charset is empty), ASCII
+// and ISO-8859-1 are supported; ok is false for other charsets.
+func decodeCharset(text, charset string) (string, bool) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return text, true
+	case "iso-8859-1", "iso8859-1", "iso_8859-1", "latin1", "l1":
+		// Each ISO-8859-1 byte is the Unicode code point of the same value.
+		runes := make([]rune, len(text))
+		for i := 0; i < len(text); i++ {
+			runes[i] = rune(text[i])
+		}
+		return string(runes), true
+	}
+	return "", false
+}
+
+// unsupportedCharset is the 415 rejection of a body in a charset decodeCharset doesn't know.
+func unsupportedCharset(charset string) *httpapi.Error {
+	return &httpapi.Error{
+		Status:  http.StatusUnsupportedMediaType,
+		Code:    httpapi.CodeUnsupportedCharset,
+		Message: "Unsupported charset " + strconv.Quote(charset) + "; send UTF-8 or ISO-8859-1",
+	}
+}
+
+// parseQueryRequest reads a GET /api request: ?q=...&session_id=...&lang=es&stream=true&aggregate=false
+// (and idempotency_key=...).
+// It applies the same validation as the POST body.
+func parseQueryRequest(r *http.Request, defaults chatRequest) (chatRequest, *httpapi.Error) {
+	if len(r.URL.RawQuery) > maxQueryBytes {
+		return chatRequest{}, &httpapi.Error{Status: http.StatusRequestURITooLong, Code: httpapi.CodeQueryTooLong, Message: "Query string is too long; use POST for long messages"}
+	}
+	query := r.URL.Query()
+	req := defaults
+	req.Message = query.Get("q")
+	req.SessionID = query.Get("session_id")
+	req.Language = query.Get("lang")
+	req.IdempotencyKey = query.Get("idempotency_key")
+	if apiErr := parseModes(query, &req); apiErr != nil {
+		return chatRequest{}, apiErr
+	}
+	return req, req.validate()
+}
+
+// parseModes sets the stream, aggregate and force options of req from the parameters of a
+// query string or form, where they are given.
+func parseModes(values url.Values, req *chatRequest) *httpapi.Error {
+	if raw := values.Get("stream"); raw != "" {
+		stream, err := strconv.ParseBool(raw)
+		if err != nil {
+			return httpapi.BadRequest(httpapi.CodeInvalidStream, "stream must be true or false (or 1 or 0)")
+		}
+		req.Stream = stream
+	}
+	if raw := values.Get("aggregate"); raw != "" {
+		aggregate, err := strconv.ParseBool(raw)
+		if err != nil {
+			return httpapi.BadRequest(httpapi.CodeInvalidAggregate, "aggregate must be true or false")
+		}
+		req.Aggregate = aggregate
+	}
+	if raw := values.Get("force"); raw != "" {
+		force, err := strconv.ParseBool(raw)
+		if err != nil {
+			return httpapi.BadRequest(httpapi.CodeInvalidForce, "force must be true or false")
+		}
+		req.Force = force
+	}
+	return nil
+}
+
+// acceptStream reads the streaming hint of the Accept header: a stream parameter on the
+// event media type, as in "Accept: text/event-stream; stream=true" (or on
+// application/x-ndjson). It lets clients that can't change the body or the URL, such as a
+// proxy serving plain-text clients, pick the mode. ok is false when there is no valid hint.
+func acceptStream(r *http.Request) (stream, ok bool) {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || (mediaType != "text/event-stream" && mediaType != sse.NDJSONContentType) {
+				continue
+			}
+			if stream, err := strconv.ParseBool(params["stream"]); err == nil {
+				return stream, true
+			}
+		}
+	}
+	return false, false
+}
+
+// validate checks the fields of a request.
+func (req chatRequest) validate() *httpapi.Error {
+	if strings.TrimSpace(req.Message) == "" {
+		return httpapi.BadRequest(httpapi.CodeEmptyMessage, "User message cannot be empty")
+	}
+	if len([]rune(req.Message)) > maxMessageLength {
+		return httpapi.BadRequest(httpapi.CodeMessageTooLong, "User message is too long")
+	}
+	if req.SessionID != "" && (len(req.SessionID) > maxSessionIDLen || !sessionIDPattern.MatchString(req.SessionID)) {
+		return httpapi.BadRequest(httpapi.CodeInvalidSessionID, "session_id must be at most 128 letters, digits or _.:-")
+	}
+	if _, ok := requestLanguages[strings.ToLower(req.Language)]; !ok {
+		return httpapi.BadRequest(httpapi.CodeInvalidLanguage, `language must be "en" or "es"`)
+	}
+	if req.CallbackURL != "" {
+		u, err := url.Parse(req.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(req.CallbackURL) > maxCallbackURLLen {
+			return httpapi.BadRequest(httpapi.CodeInvalidCallbackURL, "callback_url must be an absolute http(s) URL of at most 2048 characters")
+		}
+	}
+	if !validIdempotencyKey(req.IdempotencyKey) {
+		return httpapi.BadRequest(httpapi.CodeInvalidIdempotency, "Idempotency keys must be at most 255 printable ASCII characters")
+	}
+	if err := orchestrator.ValidatePersonaOverrides(req.PersonaOverrides); err != nil {
+		return httpapi.BadRequest(httpapi.CodeInvalidOverrides, "persona_overrides: "+err.Error())
+	}
+	return nil
+}
+
+// requestLanguages maps the language codes clients may send to the orchestrator's languages.
+var requestLanguages = map[string]string{
+	"":   "",
+	"en": orchestrator.LanguageEnglish,
+	"es": orchestrator.LanguageSpanish,
+}
+
+// options converts a validated request into orchestrator options.
+func (req chatRequest) options() orchestrator.Options {
+	return orchestrator.Options{
+		SessionID:       req.SessionID,
+		Language:        requestLanguages[strings.ToLower(req.Language)],
+		SkipAggregation: !req.Aggregate,
+
+		PersonaOverrides: req.PersonaOverrides,
+	}
+}
+
+// maskedStatus is the Status note telling the user which kinds of personal data (see pii.Mask)
+// were masked in their message.
+func maskedStatus(lang string, kinds []string) string {
+	names := make([]string, len(kinds))
+	for i, kind := range kinds {
+		names[i] = i18n.T(lang, "pii."+kind)
+	}
+	return i18n.T(lang, "status.pii_masked", strings.Join(names, ", "))
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/orchestrator"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// testDefaults are the server's request defaults in these tests: streamed and aggregated.
+var testDefaults = chatRequest{Stream: true, Aggregate: true}
+
+// chatPost returns a POST /api request with the given Content-Type (none if empty) and body.
+func chatPost(contentType, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+func TestParseChatRequestJSON(t *testing.T) {
+	req, apiErr := parseChatRequest(chatPost("application/json; charset=utf-8",
+		`{"message":"Flights to Paris","session_id":"s-1","language":"ES","stream":false,"aggregate":false,"future_field":{"x":1}}`), testDefaults)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	want := chatRequest{Message: "Flights to Paris", SessionID: "s-1", Language: "ES"}
+	if req.Message != want.Message || req.SessionID != want.SessionID || req.Language != want.Language || req.Stream || req.Aggregate {
+		t.Errorf("request = %+v, want %+v", req, want)
+	}
+	opts := req.options()
+	if opts.SessionID != "s-1" || opts.Language != orchestrator.LanguageSpanish || !opts.SkipAggregation {
+		t.Errorf("options = %+v", opts)
+	}
+
+	// Fields left out keep the server's defaults.
+	req, apiErr = parseChatRequest(chatPost("application/json", `{"message":"Hi"}`), testDefaults)
+	if apiErr != nil || !req.Stream || !req.Aggregate || req.options().Language != "" {
+		t.Errorf("request = %+v, %v; want the defaults", req, apiErr)
+	}
+}
+
+func TestParseChatRequestPlainText(t *testing.T) {
+	for _, contentType := range []string{"", "text/plain", "text/plain; charset=utf-8"} {
+		req, apiErr := parseChatRequest(chatPost(contentType, `{"message": "not JSON to this client"}`), testDefaults)
+		if apiErr != nil || req.Message != `{"message": "not JSON to this client"}` || !req.Stream || !req.Aggregate {
+			t.Errorf("Content-Type %q: request = %+v, %v; want the body as the message", contentType, req, apiErr)
+		}
+	}
+}
+
+// latin1 encodes s, which must be in ISO-8859-1's range, in ISO-8859-1.
+func latin1(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
+
+// multipartBody returns a multipart/form-data body of fields, each name followed by its value
+// and the charset of its part ("" for none), and its Content-Type.
+func multipartBody(t *testing.T, fields ...[3]string) (contentType, body string) {
+	t.Helper()
+	var b strings.Builder
+	w := multipart.NewWriter(&b)
+	for _, f := range fields {
+		header := textproto.MIMEHeader{"Content-Disposition": {`form-data; name="` + f[0] + `"`}}
+		if f[2] != "" {
+			header.Set("Content-Type", "text/plain; charset="+f[2])
+		}
+		part, err := w.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(part, f[1])
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w.FormDataContentType(), b.String()
+}
+
+func TestParseChatRequestEncodings(t *testing.T) {
+	const message = "¿Vuelos de Madrid a París por menos de 200€?"
+	want := chatRequest{Message: message, SessionID: "s-1", Language: "es", Stream: false, Aggregate: true}
+	form := url.Values{"message": {message}, "session_id": {"s-1"}, "language": {"es"}, "stream": {"false"}}
+	multipartType, multipartForm := multipartBody(t, [3]string{"message", message, ""}, [3]string{"session_id", "s-1", ""},
+		[3]string{"language", "es", ""}, [3]string{"stream", "false", ""})
+
+	// The same question, sent in each encoding, is the same request to the orchestrator.
+	for _, tt := range []struct {
+		name              string
+		contentType, body string
+	}{
+		{"JSON", "application/json", `{"message":"` + message + `","session_id":"s-1","language":"es","stream":false}`},
+		{"URL-encoded", "application/x-www-form-urlencoded", form.Encode()},
+		{"multipart", multipartType, multipartForm},
+	} {
+		req, apiErr := parseChatRequest(chatPost(tt.contentType, tt.body), testDefaults)
+		if apiErr != nil || !reflect.DeepEqual(req, want) || !reflect.DeepEqual(req.options(), want.options()) {
+			t.Errorf("%s: request = %+v, %v; want %+v", tt.name, req, apiErr, want)
+		}
+	}
+	// Plain text carries only the message.
+	req, apiErr := parseChatRequest(chatPost("text/plain", message), testDefaults)
+	if apiErr != nil || req.Message != message || req.options().Language != "" || !req.Stream {
+		t.Errorf("plain text: request = %+v, %v", req, apiErr)
+	}
+	// So does a URL-encoded body without a message field, as curl -d sends it.
+	req, apiErr = parseChatRequest(chatPost("application/x-www-form-urlencoded", "Flights to Paris"), testDefaults)
+	if apiErr != nil || req.Message != "Flights to Paris" {
+		t.Errorf("curl -d: request = %+v, %v", req, apiErr)
+	}
+}
+
+func TestParseChatRequestCharsets(t *testing.T) {
+	const message = "¿Vuelos a París?"
+	latin1Type, latin1Form := multipartBody(t, [3]string{"message", latin1(message), "ISO-8859-1"}, [3]string{"session_id", "s-1", ""})
+	fallbackType, fallbackForm := multipartBody(t, [3]string{"_charset_", "iso-8859-1", ""}, [3]string{"message", latin1(message), ""})
+	for _, tt := range []struct {
+		name              string
+		contentType, body string
+	}{
+		{"text", "text/plain; charset=iso-8859-1", latin1(message)},
+		{"URL-encoded", "application/x-www-form-urlencoded; charset=latin1", url.Values{"message": {latin1(message)}}.Encode()},
+		{"URL-encoded _charset_", "application/x-www-form-urlencoded", url.Values{"message": {latin1(message)}, "_charset_": {"ISO-8859-1"}}.Encode()},
+		{"multipart part charset", latin1Type, latin1Form},
+		{"multipart _charset_", fallbackType, fallbackForm},
+		{"UTF-8", "text/plain; charset=UTF-8", message},
+	} {
+		req, apiErr := parseChatRequest(chatPost(tt.contentType, tt.body), testDefaults)
+		if apiErr != nil || req.Message != message {
+			t.Errorf("%s: message %q, %v; want %q", tt.name, req.Message, apiErr, message)
+		}
+	}
+
+	// Charsets that can't be transcoded, and broken forms, are rejected.
+	for _, tt := range []struct {
+		name              string
+		contentType, body string
+		status            int
+		code              string
+	}{
+		{"text in Shift JIS", "text/plain; charset=shift_jis", "Hi", http.StatusUnsupportedMediaType, httpapi.CodeUnsupportedCharset},
+		{"form in UTF-16", "application/x-www-form-urlencoded; charset=utf-16", "message=Hi", http.StatusUnsupportedMediaType, httpapi.CodeUnsupportedCharset},
+		{"multipart without a boundary", "multipart/form-data", "message=Hi", http.StatusBadRequest, httpapi.CodeMalformedForm},
+		{"multipart cut short", "multipart/form-data; boundary=xyz", "--xyz\r\nContent-Disposition: form-data; name=\"message\"\r\n\r\nHi", http.StatusBadRequest, httpapi.CodeMalformedForm},
+		{"form without a message", "application/x-www-form-urlencoded", "message=&session_id=s-1", http.StatusBadRequest, httpapi.CodeEmptyMessage},
+		{"form with a bad mode", "application/x-www-form-urlencoded", "message=Hi&stream=maybe", http.StatusBadRequest, httpapi.CodeInvalidStream},
+	} {
+		_, apiErr := parseChatRequest(chatPost(tt.contentType, tt.body), testDefaults)
+		if apiErr == nil || apiErr.Status != tt.status || apiErr.Code != tt.code {
+			t.Errorf("%s: error = %+v, want %d %s", tt.name, apiErr, tt.status, tt.code)
+		}
+	}
+}
+
+func TestChatRequestEncodings(t *testing.T) {
+	s := startServer(t)
+	const message = "What is the capital of España?"
+	multipartType, multipartForm := multipartBody(t, [3]string{"message", latin1(message), "iso-8859-1"}, [3]string{"language", "en", ""})
+
+	// The mock LLMs tell the length of their prompts, so the same answer is the same input.
+	var answers []string
+	for _, tt := range []struct {
+		contentType, body string
+	}{
+		{"application/json", `{"message":"` + message + `","language":"en"}`},
+		{"application/x-www-form-urlencoded; charset=utf-8", url.Values{"message": {message}, "language": {"en"}}.Encode()},
+		{multipartType, multipartForm},
+	} {
+		resp, err := http.Post(s.url+"/api", tt.contentType, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var answer strings.Builder
+		for _, frame := range readAll(t, sse.NewReader(resp.Body)) {
+			if frame.Event == sse.TypeMessage {
+				answer.WriteString(frame.Data)
+			}
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || answer.Len() == 0 {
+			t.Fatalf("%s: %d, answer %q", tt.contentType, resp.StatusCode, answer.String())
+		}
+		answers = append(answers, answer.String())
+	}
+	if answers[1] != answers[0] || answers[2] != answers[0] {
+		t.Errorf("answers differ:\n%s", strings.Join(answers, "\n"))
+	}
+
+	resp, err := http.Post(s.url+"/api", "application/xml", strings.NewReader("<message>Hi</message>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType || errorCode(t, resp) != httpapi.CodeUnsupportedMediaType {
+		t.Errorf("XML answered %d", resp.StatusCode)
+	}
+}
+
+func TestParseChatRequestErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		contentType, body string
+		status            int
+		code              string
+	}{
+		{"malformed JSON", "application/json", `{"message":`, http.StatusBadRequest, httpapi.CodeMalformedJSON},
+		{"wrong JSON type", "application/json", `{"message":42}`, http.StatusBadRequest, httpapi.CodeMalformedJSON},
+		{"no message", "application/json", `{"session_id":"s-1"}`, http.StatusBadRequest, httpapi.CodeEmptyMessage},
+		{"blank message", "text/plain", " \n\t", http.StatusBadRequest, httpapi.CodeEmptyMessage},
+		{"message too long", "text/plain", strings.Repeat("é", maxMessageLength+1), http.StatusBadRequest, httpapi.CodeMessageTooLong},
+		{"bad session ID", "application/json", `{"message":"Hi","session_id":"a b"}`, http.StatusBadRequest, httpapi.CodeInvalidSessionID},
+		{"long session ID", "application/json", `{"message":"Hi","session_id":"` + strings.Repeat("a", maxSessionIDLen+1) + `"}`, http.StatusBadRequest, httpapi.CodeInvalidSessionID},
+		{"unknown language", "application/json", `{"message":"Hi","language":"fr"}`, http.StatusBadRequest, httpapi.CodeInvalidLanguage},
+		{"other media type", "application/xml", `<message>Hi</message>`, http.StatusUnsupportedMediaType, httpapi.CodeUnsupportedMediaType},
+	} {
+		_, apiErr := parseChatRequest(chatPost(tt.contentType, tt.body), testDefaults)
+		if apiErr == nil || apiErr.Status != tt.status || apiErr.Code != tt.code {
+			t.Errorf("%s: error = %+v, want %d %s", tt.name, apiErr, tt.status, tt.code)
+		}
+	}
+
+	// A message of exactly the limit, counted in characters, is accepted.
+	if _, apiErr := parseChatRequest(chatPost("text/plain", strings.Repeat("é", maxMessageLength)), testDefaults); apiErr != nil {
+		t.Errorf("message at the limit: %v", apiErr)
+	}
+}
+
+func TestChatRequestErrorBody(t *testing.T) {
+	r := chatPost("application/json", `{"message":`)
+	_, apiErr := parseChatRequest(r, testDefaults)
+	rec := httptest.NewRecorder()
+	httpapi.Write(rec, r, apiErr)
+
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("response %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Code != httpapi.CodeMalformedJSON || body.Error.Message == "" {
+		t.Errorf("body %s, %v; want a malformed_json error", rec.Body, err)
+	}
+}
+
+func TestParseQueryRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api?q=Vuelos+a+Par%C3%ADs&session_id=s-1&lang=es&stream=0&aggregate=false", nil)
+	req, apiErr := parseChatRequest(r, testDefaults)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if req.Message != "Vuelos a París" || req.SessionID != "s-1" || req.Language != "es" || req.Stream || req.Aggregate {
+		t.Errorf("request = %+v", req)
+	}
+
+	for _, tt := range []struct {
+		name, query string
+		status      int
+		code        string
+	}{
+		{"no message", "lang=es", http.StatusBadRequest, httpapi.CodeEmptyMessage},
+		{"bad stream", "q=Hi&stream=maybe", http.StatusBadRequest, httpapi.CodeInvalidStream},
+		{"bad aggregate", "q=Hi&aggregate=2", http.StatusBadRequest, httpapi.CodeInvalidAggregate},
+		{"bad language", "q=Hi&lang=de", http.StatusBadRequest, httpapi.CodeInvalidLanguage},
+		{"bad session ID", "q=Hi&session_id=%2F..%2F", http.StatusBadRequest, httpapi.CodeInvalidSessionID},
+		{"query too long", "q=" + strings.Repeat("a", maxQueryBytes), http.StatusRequestURITooLong, httpapi.CodeQueryTooLong},
+	} {
+		_, apiErr := parseChatRequest(httptest.NewRequest(http.MethodGet, "/api?"+tt.query, nil), testDefaults)
+		if apiErr == nil || apiErr.Status != tt.status || apiErr.Code != tt.code {
+			t.Errorf("%s: error = %+v, want %d %s", tt.name, apiErr, tt.status, tt.code)
+		}
+	}
+}
+
+func TestEventSourceGET(t *testing.T) {
+	const origin = "https://app.example.com"
+	s := startServer(t, "CORS_ALLOWED_ORIGINS="+origin)
+
+	// Browsers send a preflight before a cross-origin POST with a JSON body; both methods pass.
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req, _ := http.NewRequest(http.MethodOptions, s.url+"/api", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", "content-type")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != origin ||
+			!strings.Contains(resp.Header.Get("Access-Control-Allow-Methods"), method) {
+			t.Errorf("preflight for %s: %d %v", method, resp.StatusCode, resp.Header)
+		}
+	}
+
+	// What new EventSource("/api?q=...") sends.
+	req, _ := http.NewRequest(http.MethodGet, s.url+"/api?q=What+is+the+capital+of+France%3F&lang=en", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("Origin", origin)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" || resp.Header.Get("Access-Control-Allow-Origin") != origin {
+		t.Fatalf("response %d %v", resp.StatusCode, resp.Header)
+	}
+	frames := readAll(t, sse.NewReader(resp.Body))
+	if len(frames) == 0 || frames[0].Event != sse.TypeStarted || frames[len(frames)-1].Event != sse.TypeDone || frames[len(frames)-1].Data != sse.OutcomeOK {
+		t.Errorf("events %+v, want Started ... Done ok", frames)
+	}
+	var answer strings.Builder
+	for _, frame := range frames {
+		if frame.Event == sse.TypeMessage {
+			answer.WriteString(frame.Data)
+		}
+	}
+	if answer.Len() == 0 {
+		t.Error("no answer in the stream")
+	}
+
+	// A bad request gets the same JSON error as the POST path.
+	resp, err = http.Get(s.url + "/api?lang=en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("GET without q: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestStreamModeSelection(t *testing.T) {
+	buffered := chatRequest{Aggregate: true}
+	for _, tt := range []struct {
+		name     string
+		r        *http.Request
+		defaults chatRequest
+		want     bool
+	}{
+		{"default buffered", chatPost("application/json", `{"message":"Hi"}`), buffered, false},
+		{"default streamed", chatPost("application/json", `{"message":"Hi"}`), testDefaults, true},
+		{"JSON field", chatPost("application/json", `{"message":"Hi","stream":true}`), buffered, true},
+		{"query", httptest.NewRequest(http.MethodGet, "/api?q=Hi&stream=1", nil), buffered, true},
+		{"form", chatPost("application/x-www-form-urlencoded", "message=Hi&stream=1"), buffered, true},
+		{"Accept hint", withAccept(chatPost("application/json", `{"message":"Hi"}`), "text/event-stream; stream=true"), buffered, true},
+		{"NDJSON Accept hint", withAccept(chatPost("text/plain", "Hi"), "application/x-ndjson;stream=0"), testDefaults, false},
+		{"field over hint", withAccept(chatPost("application/json", `{"message":"Hi","stream":false}`), "text/event-stream; stream=true"), buffered, false},
+		{"query over hint", withAccept(httptest.NewRequest(http.MethodGet, "/api?q=Hi&stream=0", nil), "text/event-stream; stream=1"), buffered, false},
+		{"invalid hint", withAccept(chatPost("text/plain", "Hi"), "text/event-stream; stream=maybe"), buffered, false},
+		{"hint on another type", withAccept(chatPost("text/plain", "Hi"), "application/json; stream=true"), buffered, false},
+	} {
+		req, apiErr := parseChatRequest(tt.r, tt.defaults)
+		if apiErr != nil || req.Stream != tt.want {
+			t.Errorf("%s: stream = %v, %v; want %v", tt.name, req.Stream, apiErr, tt.want)
+		}
+	}
+}
+
+// withAccept sets the Accept header of r.
+func withAccept(r *http.Request, accept string) *http.Request {
+	r.Header.Set("Accept", accept)
+	return r
+}
+
+func TestStreamingModes(t *testing.T) {
+	// Without coalescing, each chunk of a streamed answer is an event of its own.
+	s := startServer(t, "SSE_COALESCE_WINDOW=0", "LLM_MOCK_LATENCY=50ms")
+	frames := map[bool][]sse.Frame{}
+	for _, stream := range []bool{false, true} {
+		resp := postChat(t, s, "Show me flights from Madrid to Paris", stream)
+		frames[stream] = readAll(t, sse.NewReader(resp.Body))
+		resp.Body.Close()
+	}
+
+	// Both modes report the same progress; only the answer arrives differently. The workers run
+	// concurrently, so their statuses come in either order.
+	statuses := func(frames []sse.Frame) (out []string) {
+		for _, frame := range frames {
+			if frame.Event == sse.TypeStatus {
+				out = append(out, frame.Data)
+			}
+		}
+		slices.Sort(out)
+		return out
+	}
+	messages := func(frames []sse.Frame) (n int, text string) {
+		for _, frame := range frames {
+			if frame.Event == sse.TypeMessage {
+				n++
+				text += frame.Data
+			}
+		}
+		return n, text
+	}
+	if buffered, streamed := statuses(frames[false]), statuses(frames[true]); len(buffered) == 0 || strings.Join(buffered, "\n") != strings.Join(streamed, "\n") {
+		t.Errorf("statuses differ:\nbuffered %q\nstreamed %q", buffered, streamed)
+	}
+	if n, text := messages(frames[false]); n != 1 || text == "" {
+		t.Errorf("buffered answer in %d Message events", n)
+	}
+	if n, text := messages(frames[true]); n < 2 || text == "" {
+		t.Errorf("streamed answer in %d Message events, want chunks", n)
+	}
+	for stream, f := range frames {
+		if f[len(f)-1].Event != sse.TypeDone || f[len(f)-1].Data != sse.OutcomeOK {
+			t.Errorf("stream=%v ended with %+v", stream, f[len(f)-1])
+		}
+	}
+}
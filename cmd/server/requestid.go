@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Cris245/go-llm-chat/internal/logging"
+)
+
+// withRequestID gives each request an ID for correlating logs: the client's X-Request-ID when it
+// sends a well-formed one, otherwise a fresh random ID. The ID is echoed in the X-Request-ID
+// response header and stored in the request context, where slog's *Context functions pick it up
+// and the orchestrator copies it into the Done event's telemetry.
+func withRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+		next(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestWithRequestID(t *testing.T) {
+	var seen string
+	handler := withRequestID(func(w http.ResponseWriter, r *http.Request) { seen = logging.RequestID(r.Context()) })
+	for _, tt := range []struct {
+		name, sent string
+		adopted    bool
+	}{
+		{"none sent", "", false},
+		{"well-formed", "client-id-1", true},
+		{"malformed", "bad id\r\nX-Injected: 1", false},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api", nil)
+		if tt.sent != "" {
+			r.Header.Set(logging.RequestIDHeader, tt.sent)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		echoed := rec.Header().Get(logging.RequestIDHeader)
+		if echoed == "" || echoed != seen || (echoed == tt.sent) != tt.adopted {
+			t.Errorf("%s: echoed %q, context %q", tt.name, echoed, seen)
+		}
+	}
+}
+
+func TestRequestIDAcrossComponents(t *testing.T) {
+	s := startServer(t, "LOG_LEVEL=debug", "LOG_FORMAT=json")
+	const id = "corr-42"
+	req, _ := http.NewRequest(http.MethodPost, s.url+"/api?format=json", strings.NewReader("Flights from Madrid to Paris"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set(logging.RequestIDHeader, id)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get(logging.RequestIDHeader); got != id {
+		t.Errorf("response header %q, want %q", got, id)
+	}
+	if done := doneOf(t, readAll(t, sse.NewReader(resp.Body))); done.Telemetry == nil {
+		t.Error("Done has no telemetry")
+	} else if telemetry, _ := json.Marshal(done.Telemetry); !strings.Contains(string(telemetry), `"request_id":"`+id+`"`) {
+		t.Errorf("telemetry %s, want request_id %q", telemetry, id)
+	}
+
+	// Every component's lines for the request carry its ID: the handler, the LLM and database
+	// decorators, and the SSE handler. The last are written after the response ends, so the
+	// check waits for them a little.
+	want := []string{"HTTP request", "Chat request", "LLM call", "Database operation", "SSE stream closed"}
+	var found map[string]bool
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		found = requestLogLines(t, s.logs.String(), id)
+		if !slices.ContainsFunc(want, func(msg string) bool { return !found[msg] }) || time.Now().After(deadline) {
+			break
+		}
+	}
+	for _, msg := range want {
+		if !found[msg] {
+			t.Errorf("no %q line with the request ID; lines with it: %v", msg, found)
+		}
+	}
+}
+
+// requestLogLines returns the messages of the JSON log lines with request ID id. Lines with
+// another ID fail the test, but for the startup checks' GET /version.
+func requestLogLines(t *testing.T, logs, id string) map[string]bool {
+	t.Helper()
+	found := map[string]bool{}
+	for _, line := range strings.Split(logs, "\n") {
+		var record struct {
+			Msg       string `json:"msg"`
+			RequestID string `json:"request_id"`
+		}
+		if json.Unmarshal([]byte(line), &record) != nil || record.RequestID == "" {
+			continue
+		}
+		if record.RequestID == id {
+			found[record.Msg] = true
+		} else if !strings.Contains(line, `"path":"/version"`) {
+			t.Fatalf("line with another request ID: %s", line)
+		}
+	}
+	return found
+}
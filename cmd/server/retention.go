@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+const purgeTimeout = time.Minute // Bounds one sweep of expired records
+
+// deleteSessionDataHandler serves DELETE /api/admin/data?session_id=...: it deletes every
+// record tied to the session, in every collection, and reports how many it deleted per
+// collection. With dry_run=true it deletes nothing and reports what it would delete.
+func deleteSessionDataHandler(store db.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		sessionID := query.Get("session_id")
+		if sessionID == "" || len(sessionID) > maxSessionIDLen || !sessionIDPattern.MatchString(sessionID) {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidSessionID, "session_id is required and must be at most 128 letters, digits or _.:-"))
+			return
+		}
+		dryRun := false
+		if raw := query.Get("dry_run"); raw != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(raw); err != nil {
+				httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidDryRun, "dry_run must be true or false"))
+				return
+			}
+		}
+
+		counts, err := store.DeleteSessionData(r.Context(), sessionID, dryRun)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Deleting session data failed", "session_id", sessionID, "deleted", counts.Total(), "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodeDeleteFailed, Message: "The session's data could not all be deleted; please retry"})
+			return
+		}
+		if !dryRun {
+			slog.InfoContext(r.Context(), "Session data deleted", "session_id", sessionID, "records", counts.Total())
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"session_id": sessionID, "dry_run": dryRun, "total": counts.Total(), "deleted": counts})
+	}
+}
+
+// purgeExpired deletes the records older than retention allows every interval, until ctx is
+// cancelled. A failed sweep is logged and tried again at the next one.
+func purgeExpired(ctx context.Context, store db.Client, retention db.Retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sweepCtx, cancel := context.WithTimeout(ctx, purgeTimeout)
+		counts, err := store.PurgeExpired(sweepCtx, retention, time.Now())
+		cancel()
+		switch {
+		case err != nil:
+			slog.WarnContext(ctx, "Purging expired records failed", "deleted", counts.Total(), "error", err)
+		case counts.Total() > 0:
+			slog.InfoContext(ctx, "Expired records purged", "conversations", counts.Conversations, "query_logs", counts.QueryLogs, "preferences", counts.Preferences)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
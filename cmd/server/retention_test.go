@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+)
+
+func TestDeleteSessionData(t *testing.T) {
+	store := db.NewMemoryClient()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	if err := store.AppendTurns(ctx, "s-1", "key:aaaa", db.Turn{Role: db.RoleUser, Content: "Flights to Paris", Timestamp: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertQueryLog(ctx, db.QueryLog{RequestID: "req-1", SessionID: "s-1", Message: "Flights to Paris", Timestamp: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SavePreferences(ctx, db.Preferences{SessionID: "s-1", HomeCity: "Madrid", UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	handler := deleteSessionDataHandler(store)
+	del := func(query string) (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodDelete, "/api/admin/data?"+query, nil))
+		var body map[string]any
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body
+	}
+
+	// A dry run reports what would go.
+	status, body := del("session_id=s-1&dry_run=true")
+	if status != http.StatusOK || body["dry_run"] != true || body["total"] != 3.0 {
+		t.Fatalf("dry run: %d %v", status, body)
+	}
+	if _, err := store.GetConversation(ctx, "s-1"); err != nil {
+		t.Fatalf("the dry run deleted the conversation: %v", err)
+	}
+
+	status, body = del("session_id=s-1")
+	deleted, _ := body["deleted"].(map[string]any)
+	if status != http.StatusOK || body["total"] != 3.0 || deleted["conversations"] != 1.0 || deleted["query_logs"] != 1.0 || deleted["preferences"] != 1.0 {
+		t.Fatalf("delete: %d %v", status, body)
+	}
+	for name, err := range map[string]error{
+		"conversation": func() error { _, err := store.GetConversation(ctx, "s-1"); return err }(),
+		"query log":    func() error { _, err := store.GetQueryLog(ctx, "req-1"); return err }(),
+		"preferences":  func() error { _, err := store.GetPreferences(ctx, "s-1"); return err }(),
+	} {
+		if !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("%s left: %v", name, err)
+		}
+	}
+
+	for _, query := range []string{"", "session_id=bad%20id", "session_id=s-1&dry_run=maybe"} {
+		if status, body := del(query); status != http.StatusBadRequest {
+			t.Errorf("%q: %d %v", query, status, body)
+		}
+	}
+}
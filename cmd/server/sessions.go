@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// conversationWriteTimeout bounds storing a finished exchange in its conversation.
+const conversationWriteTimeout = 5 * time.Second
+
+// exchangeTurns builds the conversation turns for a finished request from its events: the user's
+// message (unless the request regenerated an earlier answer) and, if the request succeeded, the
+// answer with the flights it showed.
+func exchangeTurns(req chatRequest, events []sse.Event, requestID string, regenerate bool) []db.Turn {
+	var answer strings.Builder
+	var flights []db.Flight
+	outcome := ""
+	for _, event := range events {
+		switch event.Type {
+		case sse.TypeMessage:
+			answer.WriteString(event.Data)
+		case sse.TypeFlightResults:
+			flights, _ = event.Payload.([]db.Flight)
+		case sse.TypeDone:
+			if done, ok := event.Payload.(sse.DonePayload); ok {
+				outcome = done.Outcome
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	var turns []db.Turn
+	if !regenerate {
+		turns = append(turns, db.Turn{Role: db.RoleUser, Content: req.Message, RequestID: requestID, Timestamp: now})
+	}
+	if outcome == sse.OutcomeOK && answer.Len() > 0 {
+		turns = append(turns, db.Turn{
+			Role:        db.RoleAssistant,
+			Content:     answer.String(),
+			Flights:     flights,
+			RequestID:   requestID,
+			Regenerated: regenerate,
+			Timestamp:   now,
+		})
+	}
+	return turns
+}
+
+// recordExchange stores a finished request in its session's conversation, which belongs to the
+// client identified by key, and has titler title the conversation once it has an answer.
+// Requests without a session ID are not stored. Failures are logged; the client already has
+// its answer.
+func recordExchange(ctx context.Context, store db.Client, titler *conversationTitler, key string, req chatRequest, events []sse.Event, regenerate bool) {
+	if req.SessionID == "" {
+		return
+	}
+	turns := exchangeTurns(req, events, logging.RequestID(ctx), regenerate)
+	writeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), conversationWriteTimeout)
+	defer cancel()
+	if err := store.AppendTurns(writeCtx, req.SessionID, usageAccount(key), turns...); err != nil {
+		slog.ErrorContext(ctx, "Failed to store conversation turns", "session_id", req.SessionID, "error", err)
+		return
+	}
+	if !regenerate && turns[len(turns)-1].Role == db.RoleAssistant {
+		titler.titleLater(ctx, req.SessionID, usageAccount(key))
+	}
+}
+
+// repeatWindow is how long after its answer a message sent again gets the same answer rather
+// than a new one (see repeatedAnswer).
+const repeatWindow = 3 * time.Minute
+
+// repeatedAnswer returns the answer a session's last message got, if req sends that message
+// again and the answer came less than repeatWindow before now, so a message sent twice, say
+// by a user who thought the first was lost, doesn't cost the LLM calls again. It returns nil
+// otherwise: without a session ID, for another client's session, or if the conversation can't
+// be loaded. Messages are compared as sameMessage does.
+func repeatedAnswer(ctx context.Context, store db.Client, key string, req chatRequest, now time.Time) *db.Turn {
+	if req.SessionID == "" {
+		return nil
+	}
+	conv, err := store.GetConversation(ctx, req.SessionID)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			slog.WarnContext(ctx, "Failed to load conversation to look for a repeated message", "session_id", req.SessionID, "error", err)
+		}
+		return nil
+	}
+	if conv.Client != usageAccount(key) || len(conv.Turns) == 0 {
+		return nil
+	}
+	answer := conv.Turns[len(conv.Turns)-1]
+	question, _ := conv.LastTurn(db.RoleUser)
+	if answer.Role != db.RoleAssistant || now.Sub(answer.Timestamp) >= repeatWindow || !sameMessage(question.Content, req.Message) {
+		return nil
+	}
+	slog.InfoContext(ctx, "Message repeated; sending the previous answer again", "session_id", req.SessionID, "answered_at", answer.Timestamp)
+	return &answer
+}
+
+// sameMessage reports whether two messages are the same but for case, spacing and the
+// punctuation around them ("Flights to Paris?" and "flights  to paris").
+func sameMessage(a, b string) bool {
+	normalize := func(message string) string {
+		return strings.Trim(strings.Join(strings.Fields(strings.ToLower(message)), " "), ".!?¿¡ ")
+	}
+	return normalize(a) == normalize(b)
+}
+
+// regenerateHandler serves POST /api/sessions/{id}/regenerate: it answers the session's last
+// user message again, asking the LLMs for a different answer, and streams the result like
+// POST /api. The new answer is stored as an extra assistant turn marked as regenerated.
+// Sessions without a user message get 409; so do sessions with a request already running,
+// which run refuses.
+func regenerateHandler(store db.Client, defaults chatRequest, run func(http.ResponseWriter, *http.Request, chatRequest, bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST, OPTIONS")
+			httpapi.Write(w, r, httpapi.MethodNotAllowed())
+			return
+		}
+		// The body is optional: a JSON object with the same options as POST /api (language,
+		// stream, aggregate). The message comes from the conversation.
+		req := defaults
+		body, err := io.ReadAll(r.Body) // Bounded by the route's httpmw.MaxBytes.
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				httpapi.Write(w, r, &httpapi.Error{Status: http.StatusRequestEntityTooLarge, Code: httpapi.CodeBodyTooLarge, Message: "Request body is too large"})
+				return
+			}
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeUnreadableBody, "Error reading request body"))
+			return
+		}
+		if len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, &req); err != nil {
+				httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeMalformedJSON, "Request body is not valid JSON: "+err.Error()))
+				return
+			}
+		}
+		sessionID := r.PathValue("id")
+		if len(sessionID) > maxSessionIDLen || !sessionIDPattern.MatchString(sessionID) {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidSessionID, "session_id must be at most 128 letters, digits or _.:-"))
+			return
+		}
+
+		conv, err := store.GetConversation(r.Context(), sessionID)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			slog.ErrorContext(r.Context(), "Failed to load conversation", "session_id", sessionID, "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeConversationUnavailable, Message: "The conversation could not be loaded; please retry"})
+			return
+		}
+		last, ok := conv.LastTurn(db.RoleUser)
+		if !ok {
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusConflict, Code: httpapi.CodeNothingToRegenerate, Message: "This session has no message to answer again"})
+			return
+		}
+		req.Message, req.SessionID = last.Content, sessionID
+		if apiErr := req.validate(); apiErr != nil {
+			httpapi.Write(w, r, apiErr)
+			return
+		}
+		run(w, r, req, true)
+	}
+}
+
+const (
+	defaultSessionListLimit = 50
+	maxSessionListLimit     = 200
+)
+
+// listSessionsHandler serves GET /api/sessions: the caller's conversations, most recently
+// updated first, with their titles and turn counts. ?limit= caps the list (default 50, at
+// most 200). Callers are identified like for rate limiting, so a client sees the sessions it
+// started with the same API key (or from the same address).
+func listSessionsHandler(store db.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET, OPTIONS")
+			httpapi.Write(w, r, httpapi.MethodNotAllowed())
+			return
+		}
+		limit := defaultSessionListLimit
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxSessionListLimit {
+				httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidLimit, "limit must be a number from 1 to 200"))
+				return
+			}
+			limit = n
+		}
+		sessions, err := store.ListConversations(r.Context(), usageAccount(clientKey(r)), limit)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to list conversations", "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeConversationUnavailable, Message: "The sessions could not be loaded; please retry"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"sessions": sessions, "count": len(sessions)})
+	}
+}
+
+// renameSessionHandler serves PATCH /api/sessions/{id} with {"title":"..."}: it replaces the
+// title of one of the caller's conversations. Conversations of other clients, and unknown
+// ones, get 404.
+func renameSessionHandler(store db.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			w.Header().Set("Allow", "PATCH, OPTIONS")
+			httpapi.Write(w, r, httpapi.MethodNotAllowed())
+			return
+		}
+		sessionID := r.PathValue("id")
+		if len(sessionID) > maxSessionIDLen || !sessionIDPattern.MatchString(sessionID) {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidSessionID, "session_id must be at most 128 letters, digits or _.:-"))
+			return
+		}
+		var body struct {
+			Title string `json:"title"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil { // Bounded by the route's httpmw.MaxBytes.
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeMalformedJSON, "Request body is not valid JSON: "+err.Error()))
+			return
+		}
+		title := strings.Join(strings.Fields(body.Title), " ")
+		if title == "" {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeEmptyTitle, "title cannot be empty"))
+			return
+		}
+		if len([]rune(title)) > maxTitleLength {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeTitleTooLong, "title must be at most 80 characters"))
+			return
+		}
+
+		err := store.SetConversationTitle(r.Context(), sessionID, usageAccount(clientKey(r)), title, true)
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeSessionNotFound, Message: "No conversation " + sessionID + " of yours exists"})
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to rename conversation", "session_id", sessionID, "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeConversationUnavailable, Message: "The title could not be saved; please retry"})
+		default:
+			writeJSON(w, http.StatusOK, map[string]string{"session_id": sessionID, "title": title})
+		}
+	}
+}
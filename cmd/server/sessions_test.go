@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestExchangeTurns(t *testing.T) {
+	req := chatRequest{Message: "Flights to Paris?"}
+	flights := []db.Flight{{FlightNumber: "FL101"}}
+	answered := []sse.Event{
+		sse.FlightResults(flights),
+		sse.MessageChunk("FL101 ", false),
+		sse.MessageChunk("leaves at 08:00.", true),
+		sse.Done(sse.DonePayload{Outcome: sse.OutcomeOK}),
+	}
+	turns := exchangeTurns(req, answered, "req-1", false)
+	if len(turns) != 2 || turns[0].Role != db.RoleUser || turns[0].Content != req.Message ||
+		turns[1].Content != "FL101 leaves at 08:00." || len(turns[1].Flights) != 1 || turns[1].Regenerated {
+		t.Errorf("turns %+v", turns)
+	}
+
+	// A regeneration adds only the new answer, marked as such.
+	turns = exchangeTurns(req, answered, "req-2", true)
+	if len(turns) != 1 || turns[0].Role != db.RoleAssistant || !turns[0].Regenerated || turns[0].RequestID != "req-2" {
+		t.Errorf("regenerated turns %+v", turns)
+	}
+
+	// A failed request keeps the question but no answer.
+	failed := []sse.Event{sse.MessageChunk("FL1", false), sse.Done(sse.DonePayload{Outcome: sse.OutcomeError})}
+	if turns := exchangeTurns(req, failed, "req-3", false); len(turns) != 1 || turns[0].Role != db.RoleUser {
+		t.Errorf("failed turns %+v", turns)
+	}
+}
+
+// sessionPost sends a POST with a JSON body to path on s.
+func sessionPost(t *testing.T, s *testServer, path, body string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(s.url+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// transcript returns the turns of the session's JSON export once it has want of them.
+func transcript(t *testing.T, s *testServer, sessionID string, want int) []exportedTurn {
+	t.Helper()
+	var export exportedConversation
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		resp, err := http.Get(s.url + "/api/sessions/" + sessionID + "/export")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			json.NewDecoder(resp.Body).Decode(&export)
+		}
+		resp.Body.Close()
+		if len(export.Turns) >= want || time.Now().After(deadline) {
+			return export.Turns
+		}
+	}
+}
+
+func TestRegenerate(t *testing.T) {
+	s := startServer(t)
+
+	// Nothing to answer again before the session has a message.
+	resp := sessionPost(t, s, "/api/sessions/trip-1/regenerate", "")
+	if resp.StatusCode != http.StatusConflict || errorCode(t, resp) != httpapi.CodeNothingToRegenerate {
+		t.Errorf("regenerate of an empty session answered %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	resp = sessionPost(t, s, "/api", `{"message":"What is the capital of France?","language":"en","session_id":"trip-1"}`)
+	readAll(t, sse.NewReader(resp.Body))
+	resp.Body.Close()
+	transcript(t, s, "trip-1", 2)
+
+	// The regeneration streams like a normal request.
+	resp = sessionPost(t, s, "/api/sessions/trip-1/regenerate", `{"language":"en"}`)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("regenerate answered %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if frames := readAll(t, sse.NewReader(resp.Body)); frames[len(frames)-1].Data != sse.OutcomeOK {
+		t.Fatalf("regeneration ended with %+v", frames[len(frames)-1])
+	}
+
+	// The transcript keeps both answers to the one question, the second marked as regenerated.
+	turns := transcript(t, s, "trip-1", 3)
+	if len(turns) != 3 {
+		t.Fatalf("transcript %+v, want the question and two answers", turns)
+	}
+	question, first, second := turns[0], turns[1], turns[2]
+	if question.Role != db.RoleUser || question.Content != "What is the capital of France?" {
+		t.Errorf("question %+v", question)
+	}
+	if first.Role != db.RoleAssistant || first.Regenerated || second.Role != db.RoleAssistant || !second.Regenerated {
+		t.Errorf("answers %+v and %+v", first, second)
+	}
+	// The mock answers with the prompt's length, so the variation hint shows as another answer.
+	if first.Content == "" || first.Content == second.Content {
+		t.Errorf("answers %q and %q, want two different ones", first.Content, second.Content)
+	}
+}
+
+func TestRegenerateWhileRunning(t *testing.T) {
+	s := startServer(t, "LLM_MOCK_LATENCY=500ms")
+	resp := sessionPost(t, s, "/api", `{"message":"What is the capital of France?","language":"en","session_id":"trip-2"}`)
+	defer resp.Body.Close()
+	// The user message is stored once the first request finishes; a regeneration sent while a
+	// second request of the session runs is refused.
+	readAll(t, sse.NewReader(resp.Body))
+	transcript(t, s, "trip-2", 2)
+
+	running := sessionPost(t, s, "/api", `{"message":"And of Spain?","language":"en","session_id":"trip-2"}`)
+	defer running.Body.Close()
+	if _, err := sse.NewReader(running.Body).Next(); err != nil {
+		t.Fatal(err)
+	}
+	again := sessionPost(t, s, "/api/sessions/trip-2/regenerate", "")
+	defer again.Body.Close()
+	if again.StatusCode != http.StatusConflict || errorCode(t, again) != httpapi.CodeGenerationInProgress {
+		t.Errorf("regenerate during a request answered %d", again.StatusCode)
+	}
+}
+
+func TestSameMessage(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		same bool
+	}{
+		{"Flights to Paris?", "flights  to paris", true},
+		{"¿Vuelos a París?", "vuelos a parís", true},
+		{" Flights to Paris! ", "Flights to Paris.", true},
+		{"Flights to Paris", "Flights to Rome", false},
+		{"Flights to Paris", "Flights, to Paris", false},
+	} {
+		if got := sameMessage(tt.a, tt.b); got != tt.same {
+			t.Errorf("sameMessage(%q, %q) = %v", tt.a, tt.b, got)
+		}
+	}
+}
+
+func TestRepeatedAnswer(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryClient()
+	answeredAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := store.AppendTurns(ctx, "trip-1", usageAccount("key:abcd"),
+		db.Turn{Role: db.RoleUser, Content: "Flights to Paris?", Timestamp: answeredAt.Add(-time.Second)},
+		db.Turn{Role: db.RoleAssistant, Content: "FL101 goes to Paris.", Timestamp: answeredAt},
+	); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name, key, session, message string
+		at                          time.Time
+		repeated                    bool
+	}{
+		{"same message", "key:abcd", "trip-1", "flights to paris", answeredAt.Add(time.Minute), true},
+		{"another message", "key:abcd", "trip-1", "Flights to Rome?", answeredAt.Add(time.Minute), false},
+		{"too late", "key:abcd", "trip-1", "Flights to Paris?", answeredAt.Add(repeatWindow), false},
+		{"another client", "key:efgh", "trip-1", "Flights to Paris?", answeredAt.Add(time.Minute), false},
+		{"no session", "key:abcd", "", "Flights to Paris?", answeredAt.Add(time.Minute), false},
+		{"unknown session", "key:abcd", "trip-2", "Flights to Paris?", answeredAt.Add(time.Minute), false},
+	} {
+		turn := repeatedAnswer(ctx, store, tt.key, chatRequest{Message: tt.message, SessionID: tt.session}, tt.at)
+		if (turn != nil) != tt.repeated || (turn != nil && turn.Content != "FL101 goes to Paris.") {
+			t.Errorf("%s: repeated %+v", tt.name, turn)
+		}
+	}
+
+	// A question still unanswered, say because its request failed, isn't repeated.
+	if err := store.AppendTurns(ctx, "trip-1", usageAccount("key:abcd"), db.Turn{Role: db.RoleUser, Content: "Flights to Rome?", Timestamp: answeredAt}); err != nil {
+		t.Fatal(err)
+	}
+	if turn := repeatedAnswer(ctx, store, "key:abcd", chatRequest{Message: "Flights to Rome?", SessionID: "trip-1"}, answeredAt.Add(time.Minute)); turn != nil {
+		t.Errorf("unanswered question repeated %+v", turn)
+	}
+}
+
+// sessionChat sends message in session, reads the whole stream, and returns its answer and
+// its Status notes.
+func sessionChat(t *testing.T, s *testServer, body string) (answer string, statuses []string) {
+	t.Helper()
+	resp := sessionPost(t, s, "/api", body)
+	defer resp.Body.Close()
+	for _, frame := range readAll(t, sse.NewReader(resp.Body)) {
+		switch frame.Event {
+		case sse.TypeMessage:
+			answer += frame.Data
+		case sse.TypeStatus:
+			statuses = append(statuses, frame.Data)
+		}
+	}
+	return answer, statuses
+}
+
+func TestRepeatedMessage(t *testing.T) {
+	s := startServer(t)
+	const body = `{"message":"What is the capital of France?","language":"en","session_id":"trip-3","stream":true}`
+	first, _ := sessionChat(t, s, body)
+	transcript(t, s, "trip-3", 2)
+	calls := llmCalls(t, s)
+
+	// The same message again gets the same answer, with a note, and no LLM calls.
+	again, statuses := sessionChat(t, s, `{"message":"what is the capital of france","language":"en","session_id":"trip-3","stream":true}`)
+	if again != first || !slices.Contains(statuses, i18n.T("en", "status.repeat")) {
+		t.Errorf("repeated message answered %q with statuses %q, want %q", again, statuses, first)
+	}
+	if n := llmCalls(t, s); n != calls {
+		t.Errorf("%d LLM calls for the repeated message", n-calls)
+	}
+
+	// Forced, or in another session, it is answered anew.
+	_, statuses = sessionChat(t, s, `{"message":"What is the capital of France?","language":"en","session_id":"trip-3","force":true}`)
+	if slices.Contains(statuses, i18n.T("en", "status.repeat")) || llmCalls(t, s) == calls {
+		t.Errorf("forced message repeated, statuses %q", statuses)
+	}
+	calls = llmCalls(t, s)
+	sessionChat(t, s, `{"message":"What is the capital of France?","language":"en","session_id":"trip-4"}`)
+	if llmCalls(t, s) == calls {
+		t.Error("message of another session repeated")
+	}
+}
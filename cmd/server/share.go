@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// shareTokenBytes is how many random bytes a share token has: 256 bits, so links can't be
+// guessed or enumerated.
+const shareTokenBytes = 32
+
+// shareTokenPattern is a share token as newShareToken writes it: unpadded base64url.
+var shareTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// newShareToken returns a random share token.
+func newShareToken() (string, error) {
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// shareTokenHash is the hash a share token is stored and looked up by.
+func shareTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// shareResponse is the body of POST /api/sessions/{id}/share.
+type shareResponse struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url"` // The link's path on this server
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// shareSessionHandler serves POST and DELETE /api/sessions/{id}/share. POST creates a link that
+// shows one of the caller's conversations, read-only, to anyone who has it, for ttl; it
+// replaces the conversation's previous link, which stops working. DELETE removes the link.
+// Conversations of other clients, and unknown ones, get 404, as does DELETE of a conversation
+// that isn't shared.
+func shareSessionHandler(store db.Client, ttl time.Duration, now func() time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "POST, DELETE, OPTIONS")
+			httpapi.Write(w, r, httpapi.MethodNotAllowed())
+			return
+		}
+		sessionID := r.PathValue("id")
+		if len(sessionID) > maxSessionIDLen || !sessionIDPattern.MatchString(sessionID) {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeInvalidSessionID, "session_id must be at most 128 letters, digits or _.:-"))
+			return
+		}
+		client := usageAccount(clientKey(r))
+
+		if r.Method == http.MethodDelete {
+			err := store.UnshareConversation(r.Context(), sessionID, client)
+			switch {
+			case errors.Is(err, db.ErrNotFound):
+				httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeShareNotFound, Message: "No conversation " + sessionID + " of yours is shared"})
+			case err != nil:
+				slog.ErrorContext(r.Context(), "Failed to unshare conversation", "session_id", sessionID, "error", err)
+				httpapi.Write(w, r, &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeConversationUnavailable, Message: "The link could not be removed; please retry"})
+			default:
+				slog.InfoContext(r.Context(), "Conversation unshared", "session_id", sessionID)
+				w.WriteHeader(http.StatusNoContent)
+			}
+			return
+		}
+
+		token, err := newShareToken()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to generate share token", "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusInternalServerError, Code: httpapi.CodeInternal, Message: "The link could not be created"})
+			return
+		}
+		created := now().UTC()
+		share := db.ConversationShare{TokenHash: shareTokenHash(token), CreatedAt: created, ExpiresAt: created.Add(ttl)}
+		err = store.ShareConversation(r.Context(), sessionID, client, share)
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeSessionNotFound, Message: "No conversation " + sessionID + " of yours exists"})
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to share conversation", "session_id", sessionID, "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeConversationUnavailable, Message: "The link could not be created; please retry"})
+		default:
+			slog.InfoContext(r.Context(), "Conversation shared", "session_id", sessionID, "expires_at", share.ExpiresAt)
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, http.StatusCreated, shareResponse{Token: token, URL: "/share/" + token, ExpiresAt: share.ExpiresAt})
+		}
+	}
+}
+
+// sharedConversation is the JSON of a shared conversation. It leaves out the session ID and
+// the owner, so a link reveals nothing but the transcript.
+type sharedConversation struct {
+	Title     string         `json:"title"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	ExpiresAt time.Time      `json:"expires_at"` // When the link stops working
+	Turns     []exportedTurn `json:"turns"`
+}
+
+// newSharedConversation converts a shared conversation to what its link shows.
+func newSharedConversation(conv db.Conversation) sharedConversation {
+	export := newExport(conv, time.Time{})
+	return sharedConversation{
+		Title:     conv.Title,
+		CreatedAt: conv.CreatedAt,
+		UpdatedAt: conv.UpdatedAt,
+		ExpiresAt: conv.Share.ExpiresAt,
+		Turns:     export.Turns,
+	}
+}
+
+// shareCSP allows a shared transcript page its inline styles and nothing else: no scripts,
+// frames, forms or requests elsewhere.
+const shareCSP = "default-src 'none'; style-src 'unsafe-inline'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+// viewShareHandler serves GET /share/{token}: the conversation shared with the link, as a
+// read-only HTML transcript, or as JSON for ?format=json or clients that accept JSON and not
+// HTML. It needs no API key; the token is the credential. Unknown, removed and expired links
+// all get 404, so a removed link can't be told from one that never existed.
+func viewShareHandler(store db.Client, now func() time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET, OPTIONS")
+			httpapi.Write(w, r, httpapi.MethodNotAllowed())
+			return
+		}
+		asJSON := wantsShareJSON(r)
+		// The link is the credential: keep it out of caches, search engines and the Referer of
+		// links followed from the page.
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+
+		token := r.PathValue("token")
+		var conv db.Conversation
+		err := db.ErrNotFound
+		if shareTokenPattern.MatchString(token) {
+			conv, err = store.GetSharedConversation(r.Context(), shareTokenHash(token), now())
+		}
+		if err != nil {
+			apiErr := &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeShareNotFound, Message: "This link doesn't exist or has expired"}
+			if !errors.Is(err, db.ErrNotFound) {
+				slog.ErrorContext(r.Context(), "Failed to load shared conversation", "error", err)
+				apiErr = &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeConversationUnavailable, Message: "The conversation could not be loaded; please retry"}
+			}
+			if asJSON {
+				httpapi.Write(w, r, apiErr)
+				return
+			}
+			renderSharePage(w, r, apiErr.Status, sharePage{Error: apiErr.Message})
+			return
+		}
+
+		shared := newSharedConversation(conv)
+		if asJSON {
+			writeJSON(w, http.StatusOK, shared)
+			return
+		}
+		renderSharePage(w, r, http.StatusOK, sharePage{Conversation: shared})
+	}
+}
+
+// wantsShareJSON reports whether a shared conversation is asked for as JSON: with
+// ?format=json, or by a client whose Accept names JSON but not HTML. Browsers get HTML.
+func wantsShareJSON(r *http.Request) bool {
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return true
+	case "html":
+		return false
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// sharePage is what sharePageTemplate shows: a conversation, or why there is none.
+type sharePage struct {
+	Conversation sharedConversation
+	Error        string
+}
+
+// renderSharePage writes page as a standalone HTML document with status.
+func renderSharePage(w http.ResponseWriter, r *http.Request, status int, page sharePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", shareCSP)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := sharePageTemplate.Execute(w, page); err != nil {
+		slog.WarnContext(r.Context(), "Failed to write shared conversation", "error", err)
+	}
+}
+
+// sharePageTemplate is the read-only transcript of a shared conversation. It is self-contained,
+// with inline styles and no scripts.
+var sharePageTemplate = template.Must(template.New("share").Funcs(template.FuncMap{
+	"place": db.PlaceName,
+	"time":  func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 MST") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>{{with .Conversation.Title}}{{.}}{{else}}Shared conversation{{end}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 46rem; margin: 2rem auto; padding: 0 1rem; color: #1f2328; line-height: 1.5; }
+header p, footer { color: #59636e; font-size: 0.875rem; }
+.turn { margin: 1rem 0; padding: 0.75rem 1rem; border-radius: 0.5rem; }
+.user { background: #ddf4ff; }
+.assistant { background: #f6f8fa; }
+.speaker { font-weight: 600; font-size: 0.875rem; }
+.content { white-space: pre-wrap; margin: 0.25rem 0 0; }
+table { border-collapse: collapse; margin-top: 0.5rem; font-size: 0.875rem; }
+th, td { border: 1px solid #d1d9e0; padding: 0.25rem 0.5rem; text-align: left; }
+td.number { text-align: right; }
+</style>
+</head>
+<body>
+{{- if .Error}}
+<h1>Conversation unavailable</h1>
+<p>{{.Error}}</p>
+{{- else}}{{with .Conversation}}
+<header>
+<h1>{{with .Title}}{{.}}{{else}}Shared conversation{{end}}</h1>
+<p>{{time .CreatedAt}} – {{time .UpdatedAt}} · read-only</p>
+</header>
+<main>
+{{- range .Turns}}
+<section class="turn {{.Role}}">
+<div class="speaker">{{if eq .Role "user"}}User{{else}}Assistant{{if .Regenerated}} (regenerated){{end}}{{end}} · {{time .Timestamp}}</div>
+<p class="content">{{.Content}}</p>
+{{- if .Flights}}
+<table>
+<thead><tr><th>Flight</th><th>From</th><th>To</th><th>Departure</th><th>Arrival</th><th>Price</th><th>Seats</th></tr></thead>
+<tbody>
+{{- range .Flights}}
+<tr><td>{{.FlightNumber}}</td><td>{{place .Origin .OriginAirport}}</td><td>{{place .Destination .DestinationAirport}}</td><td>{{.DepartureTime}}</td><td>{{.ArrivalTime}}</td><td class="number">{{printf "%.2f" .Price}}</td><td class="number">{{.AvailableSeats}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+</section>
+{{- end}}
+</main>
+<footer>This link stops working on {{time .ExpiresAt}}.</footer>
+{{- end}}{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+func TestShareToken(t *testing.T) {
+	seen := map[string]bool{}
+	for range 100 {
+		token, err := newShareToken()
+		if err != nil || !shareTokenPattern.MatchString(token) || seen[token] {
+			t.Fatalf("newShareToken = %q, %v", token, err)
+		}
+		seen[token] = true
+	}
+	if got := shareTokenHash("token"); len(got) != 64 || got == shareTokenHash("token2") {
+		t.Errorf("shareTokenHash = %q", got)
+	}
+}
+
+// shareTest serves the share endpoints over a store with a conversation of team-one-key's,
+// "trip:1", and one of team-two-key's, on a clock the test moves.
+type shareTest struct {
+	store *db.MemoryClient
+	now   time.Time
+	mux   *http.ServeMux
+}
+
+func newShareTest(t *testing.T) *shareTest {
+	s := &shareTest{store: db.NewMemoryClient(), now: exportedAt, mux: http.NewServeMux()}
+	ctx := context.Background()
+	conv := twoTurnConversation()
+	conv.Turns[1].Content += "<script>alert(1)</script>"
+	s.store.AppendTurns(ctx, conv.SessionID, conv.Client, conv.Turns...)
+	s.store.SetConversationTitle(ctx, conv.SessionID, conv.Client, conv.Title, true)
+	s.store.AppendTurns(ctx, "trip:2", usageAccount("key:team-two-key"), db.Turn{Role: db.RoleUser, Content: "Flights to Tokyo, the other team's", Timestamp: exportedAt})
+	clock := func() time.Time { return s.now }
+	s.mux.HandleFunc("/api/sessions/{id}/share", shareSessionHandler(s.store, time.Hour, clock))
+	s.mux.HandleFunc("/share/{token}", viewShareHandler(s.store, clock))
+	return s
+}
+
+// do sends a request with key as its API key, if it is set.
+func (s *shareTest) do(method, path, key, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if key != "" {
+		r.Header.Set("X-API-Key", key)
+	}
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	return w
+}
+
+// share creates a link to trip:1 and returns it.
+func (s *shareTest) share(t *testing.T) shareResponse {
+	t.Helper()
+	w := s.do(http.MethodPost, "/api/sessions/trip:1/share", "team-one-key", "")
+	var link shareResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("share: %d %s", w.Code, w.Body)
+	}
+	return link
+}
+
+// checkNoLeak fails the test if body shows the session, its owner or the other conversation.
+func checkNoLeak(t *testing.T, what, body string) {
+	t.Helper()
+	for _, secret := range []string{"trip:1", "trip_1", "team-one-key", usageAccount("key:team-one-key"), "Tokyo"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("%s shows %q:\n%s", what, secret, body)
+		}
+	}
+}
+
+func TestShareView(t *testing.T) {
+	s := newShareTest(t)
+	link := s.share(t)
+	if link.URL != "/share/"+link.Token || !link.ExpiresAt.Equal(exportedAt.Add(time.Hour)) || !shareTokenPattern.MatchString(link.Token) {
+		t.Errorf("link %+v", link)
+	}
+
+	// The link shows the transcript without an API key, as JSON or HTML.
+	for _, tt := range []struct{ query, accept string }{{"?format=json", ""}, {"", "application/json"}} {
+		w := s.do(http.MethodGet, link.URL+tt.query, "", tt.accept)
+		var shared sharedConversation
+		if err := json.Unmarshal(w.Body.Bytes(), &shared); err != nil || w.Code != http.StatusOK || shared.Title != "Madrid to Paris" ||
+			len(shared.Turns) != 2 || len(shared.Turns[1].Flights) != 2 || !shared.ExpiresAt.Equal(link.ExpiresAt) {
+			t.Errorf("JSON %+v: %d %s", tt, w.Code, w.Body)
+		}
+		checkNoLeak(t, "JSON", w.Body.String())
+	}
+	w := s.do(http.MethodGet, link.URL, "", "text/html,application/xhtml+xml,application/json;q=0.9")
+	page := w.Body.String()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/html; charset=utf-8" || w.Header().Get("Content-Security-Policy") != shareCSP {
+		t.Errorf("HTML: %d %v", w.Code, w.Header())
+	}
+	for _, want := range []string{"<title>Madrid to Paris</title>", "Flights from Madrid to Paris?", "<td>FL102</td>", "&lt;script&gt;alert(1)&lt;/script&gt;"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("HTML doesn't contain %q:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "<script>") {
+		t.Errorf("HTML runs the conversation's script:\n%s", page)
+	}
+	checkNoLeak(t, "HTML", page)
+	for header, want := range map[string]string{"Cache-Control": "no-store", "Referrer-Policy": "no-referrer", "X-Robots-Tag": "noindex, nofollow"} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s: %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestShareRevokeAndExpiry(t *testing.T) {
+	s := newShareTest(t)
+	notFound := func(what, path string) {
+		t.Helper()
+		if w := s.do(http.MethodGet, path+"?format=json", "", ""); w.Code != http.StatusNotFound || errorCodeOf(w) != httpapi.CodeShareNotFound {
+			t.Errorf("%s: %d %s", what, w.Code, w.Body)
+		}
+		if w := s.do(http.MethodGet, path, "", ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "Conversation unavailable") {
+			t.Errorf("%s as HTML: %d %s", what, w.Code, w.Body)
+		}
+	}
+
+	// Only the owner can share or unshare a conversation.
+	for _, key := range []string{"team-two-key", ""} {
+		if w := s.do(http.MethodPost, "/api/sessions/trip:1/share", key, ""); w.Code != http.StatusNotFound || errorCodeOf(w) != httpapi.CodeSessionNotFound {
+			t.Errorf("shared with key %q: %d %s", key, w.Code, w.Body)
+		}
+	}
+	if w := s.do(http.MethodPost, "/api/sessions/bad%20id/share", "team-one-key", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bad session ID: %d", w.Code)
+	}
+	first := s.share(t)
+	if w := s.do(http.MethodDelete, "/api/sessions/trip:1/share", "team-two-key", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unshared by another client: %d", w.Code)
+	}
+
+	// Sharing again replaces the link; unsharing removes it.
+	second := s.share(t)
+	notFound("replaced link", first.URL)
+	if w := s.do(http.MethodGet, second.URL+"?format=json", "", ""); w.Code != http.StatusOK {
+		t.Errorf("new link: %d", w.Code)
+	}
+	if w := s.do(http.MethodDelete, "/api/sessions/trip:1/share", "team-one-key", ""); w.Code != http.StatusNoContent {
+		t.Errorf("unshare: %d %s", w.Code, w.Body)
+	}
+	notFound("revoked link", second.URL)
+	if w := s.do(http.MethodDelete, "/api/sessions/trip:1/share", "team-one-key", ""); w.Code != http.StatusNotFound || errorCodeOf(w) != httpapi.CodeShareNotFound {
+		t.Errorf("unshare again: %d %s", w.Code, w.Body)
+	}
+
+	// A link stops working when it expires.
+	third := s.share(t)
+	s.now = s.now.Add(time.Hour - time.Second)
+	if w := s.do(http.MethodGet, third.URL+"?format=json", "", ""); w.Code != http.StatusOK {
+		t.Errorf("before expiry: %d", w.Code)
+	}
+	s.now = s.now.Add(time.Second)
+	notFound("expired link", third.URL)
+
+	// Unknown and malformed tokens look the same.
+	unknown, _ := newShareToken()
+	notFound("unknown link", "/share/"+unknown)
+	notFound("malformed link", "/share/trip:1")
+	if w := s.do(http.MethodPost, "/share/"+unknown, "", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST to a link: %d", w.Code)
+	}
+}
+
+func TestShareServer(t *testing.T) {
+	s := startServer(t, "SHARE_LINK_TTL=1h")
+	sessionChat(t, s, `{"message":"Flights from Madrid to Paris","session_id":"share-1"}`)
+	transcript(t, s, "share-1", 2)
+	resp := sessionPost(t, s, "/api/sessions/share-1/share", "")
+	var link shareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&link); err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("share: %d, %v", resp.StatusCode, err)
+	}
+	resp.Body.Close()
+
+	view, err := http.Get(s.url + link.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(view.Body)
+	view.Body.Close()
+	if view.StatusCode != http.StatusOK || !strings.Contains(string(page), "Flights from Madrid to Paris") || strings.Contains(string(page), "share-1") {
+		t.Errorf("view: %d\n%s", view.StatusCode, page)
+	}
+	// The access log doesn't keep the token.
+	if logs := s.logs.String(); strings.Contains(logs, link.Token) {
+		t.Errorf("logs show the token:\n%s", logs)
+	}
+}
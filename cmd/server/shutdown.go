@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// inflight tracks running orchestrations so shutdown can let them finish before exiting.
+type inflight struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	draining bool
+}
+
+// start registers a new orchestration. It returns false once the server is draining,
+// in which case the caller must not start one.
+func (f *inflight) start() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.draining {
+		return false
+	}
+	f.wg.Add(1)
+	return true
+}
+
+// done marks an orchestration registered with start as finished.
+func (f *inflight) done() {
+	f.wg.Done()
+}
+
+// drain stops new orchestrations from starting and waits for the running ones.
+// It reports whether they all finished before ctx expired.
+func (f *inflight) drain(ctx context.Context) bool {
+	f.mu.Lock()
+	f.draining = true
+	f.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
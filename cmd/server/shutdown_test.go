@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestInflightDrain(t *testing.T) {
+	var f inflight
+	if !f.start() || !f.start() {
+		t.Fatal("start refused before draining")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if f.drain(ctx) {
+		t.Error("drain reported running orchestrations as finished")
+	}
+	if f.start() {
+		t.Error("start accepted while draining")
+	}
+
+	f.done()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f.done()
+	}()
+	if !f.drain(context.Background()) {
+		t.Error("drain didn't see the orchestrations finish")
+	}
+}
+
+// testServer is the server binary running on the memory backend with mock LLMs.
+type testServer struct {
+	cmd    *exec.Cmd
+	url    string
+	exited chan error
+	logs   logBuffer // What it wrote to stdout and stderr
+}
+
+// logBuffer collects a server's output, which it writes from several goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// startServer builds and starts the server with the extra environment env, and waits until it
+// answers. It is stopped when the test ends.
+func startServer(t *testing.T, env ...string) *testServer {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("starts the server binary")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("needs SIGTERM")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "server")
+	build := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), "build", "-o", bin, ".")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s := &testServer{url: "http://" + addr, exited: make(chan error, 1)}
+	s.cmd = exec.Command(bin)
+	s.cmd.Dir = dir
+	s.cmd.Env = append(os.Environ(), "HTTP_ADDR="+addr, "DB_BACKEND=memory", "LLM_PROVIDER=mock")
+	s.cmd.Env = append(s.cmd.Env, env...)
+	s.cmd.Stdout, s.cmd.Stderr = &s.logs, &s.logs
+	if testing.Verbose() {
+		s.cmd.Stdout, s.cmd.Stderr = io.MultiWriter(&s.logs, os.Stdout), io.MultiWriter(&s.logs, os.Stderr)
+	}
+	if err := s.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() { s.exited <- s.cmd.Wait() }()
+	t.Cleanup(func() {
+		s.cmd.Process.Kill()
+		<-s.exited
+	})
+
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		resp, err := http.Get(s.url + "/version")
+		if err == nil {
+			resp.Body.Close()
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the server didn't start: %v", err)
+		}
+	}
+}
+
+// terminate sends SIGTERM and returns how the process exited.
+func (s *testServer) terminate() error {
+	if err := s.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return err
+	}
+	select {
+	case err := <-s.exited:
+		s.exited <- err // For the cleanup
+		return err
+	case <-time.After(30 * time.Second):
+		return errors.New("the server didn't exit after SIGTERM")
+	}
+}
+
+// readAll reads the rest of a stream.
+func readAll(t *testing.T, reader *sse.Reader) []sse.Frame {
+	t.Helper()
+	var frames []sse.Frame
+	for {
+		frame, err := reader.Next()
+		if err == io.EOF {
+			return frames
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// shutdownMidRequest starts a chat request on s, sends SIGTERM once its first event has
+// arrived, and returns every event the client received and how the server exited.
+func shutdownMidRequest(t *testing.T, s *testServer) ([]sse.Frame, error) {
+	t.Helper()
+	resp, err := http.Post(s.url+"/api?format=json", "text/plain", strings.NewReader("What is the capital of France?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := sse.NewReader(resp.Body)
+	first, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- s.terminate() }()
+	frames := append([]sse.Frame{first}, readAll(t, reader)...)
+	return frames, <-exited
+}
+
+// doneOf returns the Done event that must end frames.
+func doneOf(t *testing.T, frames []sse.Frame) sse.DonePayload {
+	t.Helper()
+	last := frames[len(frames)-1]
+	if last.Event != sse.TypeDone {
+		t.Fatalf("stream ended with %+v, want Done", last)
+	}
+	env, err := sse.ParseEnvelope(last.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var done sse.DonePayload
+	if err := json.Unmarshal(env.Data, &done); err != nil {
+		t.Fatal(err)
+	}
+	return done
+}
+
+func TestShutdownFinishesInFlightStream(t *testing.T) {
+	s := startServer(t, "LLM_MOCK_LATENCY=300ms", "SHUTDOWN_GRACE_PERIOD=20s")
+	frames, err := shutdownMidRequest(t, s)
+	if err != nil {
+		t.Errorf("server exited with %v", err)
+	}
+	if done := doneOf(t, frames); done.Outcome != sse.OutcomeOK {
+		t.Errorf("Done = %+v, want the answer finished during the grace period", done)
+	}
+	if len(frames) < 3 || frames[len(frames)-2].Event != sse.TypeMessage {
+		t.Errorf("events %+v, want the answer before Done", frames)
+	}
+}
+
+func TestShutdownCancelsPastGracePeriod(t *testing.T) {
+	s := startServer(t, "LLM_MOCK_LATENCY=5s", "SHUTDOWN_GRACE_PERIOD=200ms")
+	start := time.Now()
+	frames, err := shutdownMidRequest(t, s)
+	if err != nil {
+		t.Errorf("server exited with %v", err)
+	}
+	if done := doneOf(t, frames); done.Outcome != sse.OutcomeError {
+		t.Errorf("Done = %+v, want the request cancelled", done)
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("shutdown took %v, waiting for the mock LLM past the grace period", elapsed)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// requestSnapshot is what GET /api/admin/requests/{request_id} returns: the pipeline of one
+// request as its query log record has it, from the language and intent detected to the answer.
+type requestSnapshot struct {
+	RequestID   string    `json:"request_id"`
+	Timestamp   time.Time `json:"timestamp"`
+	SessionID   string    `json:"session_id,omitempty"`
+	Message     string    `json:"message"`
+	Language    string    `json:"language"`
+	Intent      string    `json:"intent"`
+	Flags       []string  `json:"flags,omitempty"`
+	Preferences []string  `json:"preferences,omitempty"`
+
+	Search *snapshotSearch `json:"search,omitempty"` // Flight questions only
+
+	// PersonaOverrides are the system prompts the request gave in place of the persona's.
+	PersonaOverrides map[string]string `json:"persona_overrides,omitempty"`
+
+	// Calls are the LLM calls in the order they finished; empty if prompts weren't stored.
+	Calls    []db.LLMCall      `json:"calls"`
+	Models   map[string]string `json:"models,omitempty"`
+	StagesMs map[string]int64  `json:"stages_ms,omitempty"`
+
+	Answer     string        `json:"answer,omitempty"`
+	Truncated  bool          `json:"truncated,omitempty"`
+	Grounding  *db.Grounding `json:"grounding,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	DurationMs int64         `json:"duration_ms"`
+}
+
+// snapshotSearch is the flight search of a request: what it asked the database for and how
+// many flights it found.
+type snapshotSearch struct {
+	Origin      string  `json:"origin,omitempty"`
+	Destination string  `json:"destination,omitempty"`
+	MaxPrice    float64 `json:"max_price,omitempty"`
+	Currency    string  `json:"currency,omitempty"`
+	ResultCount int     `json:"result_count"`
+
+	OriginAirport      string `json:"origin_airport,omitempty"`
+	DestinationAirport string `json:"destination_airport,omitempty"`
+}
+
+// newRequestSnapshot assembles the snapshot of the request entry records.
+func newRequestSnapshot(entry db.QueryLog) requestSnapshot {
+	snapshot := requestSnapshot{
+		RequestID:   entry.RequestID,
+		Timestamp:   entry.Timestamp,
+		SessionID:   entry.SessionID,
+		Message:     entry.Message,
+		Language:    entry.DetectedLanguage,
+		Intent:      entry.Intent,
+		Flags:       entry.Flags,
+		Preferences: entry.Preferences,
+		Calls:       entry.Calls,
+		Models:      entry.Models,
+		StagesMs:    entry.StagesMs,
+		Answer:      entry.Answer,
+		Truncated:   entry.Truncated,
+		Grounding:   entry.Grounding,
+		Error:       entry.Error,
+		DurationMs:  entry.DurationMs,
+
+		PersonaOverrides: entry.PersonaOverrides,
+	}
+	if snapshot.Calls == nil {
+		snapshot.Calls = []db.LLMCall{}
+	}
+	if entry.Intent == "flight" {
+		snapshot.Search = &snapshotSearch{
+			Origin:      entry.Origin,
+			Destination: entry.Destination,
+			MaxPrice:    entry.MaxPrice,
+			Currency:    entry.Currency,
+			ResultCount: entry.ResultCount,
+
+			OriginAirport:      entry.OriginAirport,
+			DestinationAirport: entry.DestinationAirport,
+		}
+	}
+	return snapshot
+}
+
+// requestSnapshotHandler handles GET /api/admin/requests/{request_id}: the snapshot of a logged
+// request, found by the request ID its response had in X-Request-ID and its telemetry.
+func requestSnapshotHandler(store db.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("request_id")
+		entry, err := store.GetQueryLog(r.Context(), id)
+		if errors.Is(err, db.ErrNotFound) {
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusNotFound, Code: httpapi.CodeRequestNotFound, Message: "No logged request has the ID " + id})
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to load request snapshot", "request_id", id, "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodeRequestUnavailable, Message: "The request could not be loaded; please retry"})
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, newRequestSnapshot(entry))
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestRequestSnapshotHandler(t *testing.T) {
+	store := db.NewMemoryClient()
+	ctx := context.Background()
+	for _, entry := range []db.QueryLog{
+		{RequestID: "req-flight", Message: "Flights from Madrid to Paris", Intent: "flight", Origin: "Madrid", Destination: "Paris", ResultCount: 4},
+		{RequestID: "req-general", Message: "What is the capital of France?", Intent: "general"},
+	} {
+		if err := store.InsertQueryLog(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/admin/requests/{request_id}", requestSnapshotHandler(store))
+	get := func(id string) (*httptest.ResponseRecorder, map[string]any) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/requests/"+id, nil))
+		var body map[string]any
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, body
+	}
+
+	rec, body := get("req-flight")
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("flight: %d %q", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+	search, _ := body["search"].(map[string]any)
+	if search["origin"] != "Madrid" || search["destination"] != "Paris" || search["result_count"] != 4.0 {
+		t.Errorf("flight search %v", body["search"])
+	}
+	// Without stored prompts, calls is empty rather than null.
+	if calls, ok := body["calls"].([]any); !ok || len(calls) != 0 {
+		t.Errorf("calls %v", body["calls"])
+	}
+
+	if _, body := get("req-general"); body["search"] != nil || body["intent"] != "general" {
+		t.Errorf("general: %v", body)
+	}
+	if rec, _ := get("req-unknown"); rec.Code != http.StatusNotFound || errorCodeOf(rec) != httpapi.CodeRequestNotFound {
+		t.Errorf("unknown: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// chatAs sends message as request id, streamed, and returns the answer and the number of
+// flights found.
+func chatAs(t *testing.T, s *testServer, id, message string) (answer string, flights int) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, s.url+"/api", strings.NewReader(`{"message":"`+message+`","stream":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(logging.RequestIDHeader, id)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	for _, f := range readAll(t, sse.NewReader(resp.Body)) {
+		switch f.Event {
+		case sse.TypeMessage:
+			answer += f.Data
+		case sse.TypeFlightResults:
+			var n int
+			fmt.Sscanf(f.Data, "Found %d flights", &n) // The plain-text stream's summary
+			flights += n
+		}
+	}
+	return answer, flights
+}
+
+// snapshotOf waits for the snapshot of request id, which is logged in the background.
+func snapshotOf(t *testing.T, s *testServer, id string) requestSnapshot {
+	t.Helper()
+	var snapshot requestSnapshot
+	for deadline := time.Now().Add(2 * time.Second); adminGet(t, s, "/api/admin/requests/"+id, &snapshot) != http.StatusOK; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("request %s wasn't logged", id)
+		}
+	}
+	return snapshot
+}
+
+func TestRequestSnapshot(t *testing.T) {
+	s := startServer(t, "ADMIN_API_KEYS=admin-key", "QUERY_LOG_ENABLED=true", "LLM_MODEL=mock-model")
+	answer, flights := chatAs(t, s, "snapshot-1", "Show me flights from Madrid to Paris")
+	snapshot := snapshotOf(t, s, "snapshot-1")
+
+	// The snapshot has the pipeline the stream went through, stage by stage.
+	if snapshot.RequestID != "snapshot-1" || snapshot.Intent != "flight" || snapshot.Language != "English" || snapshot.Message != "Show me flights from Madrid to Paris" {
+		t.Errorf("snapshot %+v", snapshot)
+	}
+	if search := snapshot.Search; search == nil || search.Origin != "Madrid" || search.Destination != "Paris" || search.ResultCount != flights || flights != 4 {
+		t.Errorf("search %+v, %d flights streamed", snapshot.Search, flights)
+	}
+	var stages []string
+	for _, call := range snapshot.Calls {
+		stages = append(stages, call.Stage)
+		if call.Prompt == "" || call.Response == "" || call.Model != "mock-model" {
+			t.Errorf("%s call %+v", call.Stage, call)
+		}
+	}
+	slices.Sort(stages)
+	if !slices.Equal(stages, []string{"aggregation", "llm1", "llm2"}) || snapshot.Calls[2].Stage != "aggregation" {
+		t.Errorf("calls in stages %v", stages)
+	}
+	if !strings.Contains(snapshot.Calls[0].Prompt, "FL101") {
+		t.Errorf("worker prompt without the flights: %q", snapshot.Calls[0].Prompt)
+	}
+	if snapshot.Answer != answer || snapshot.Calls[2].Response != answer {
+		t.Errorf("answer %q, streamed %q", snapshot.Answer, answer)
+	}
+	if snapshot.StagesMs == nil || snapshot.Models["aggregation"] != "mock-model" {
+		t.Errorf("stages %v, models %v", snapshot.StagesMs, snapshot.Models)
+	}
+
+	// Admin only.
+	resp, err := http.Get(s.url + "/api/admin/requests/snapshot-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without a key: %d", resp.StatusCode)
+	}
+}
+
+func TestRequestSnapshotWithoutPrompts(t *testing.T) {
+	s := startServer(t, "ADMIN_API_KEYS=admin-key", "QUERY_LOG_ENABLED=true", "QUERY_LOG_PROMPTS=false")
+	chatAs(t, s, "snapshot-2", "What is the capital of France?")
+	if snapshot := snapshotOf(t, s, "snapshot-2"); len(snapshot.Calls) != 0 || snapshot.Answer != "" || snapshot.Intent != "general" {
+		t.Errorf("snapshot without prompt storage %+v", snapshot)
+	}
+}
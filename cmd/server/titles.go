@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+)
+
+const (
+	titleTimeout   = 20 * time.Second // Bounds generating and storing one title
+	maxTitleLength = 80               // Characters; longer titles are cut at a word boundary
+)
+
+// titlePrompt asks for a title in the language of the question; %s is the question.
+const titlePrompt = "Write a title of at most six words for a chat that starts with the question below. " +
+	"Use the language of the question. Answer with the title only, without quotes or a final period.\n\nQuestion: %s"
+
+// conversationTitler titles new conversations after their first exchange. Titles are
+// generated in the background once the answer has been sent, so they never delay it.
+type conversationTitler struct {
+	store db.Client
+	llm   llmclient.LLMClient // nil titles every conversation with its shortened first question
+
+	wg sync.WaitGroup // Titles being generated
+}
+
+// titleLater titles client's conversation sessionID from its first question, unless it
+// already has a title. It returns at once; the title is stored within titleTimeout.
+func (t *conversationTitler) titleLater(ctx context.Context, sessionID, client string) {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), titleTimeout)
+		defer cancel()
+		conv, err := t.store.GetConversation(ctx, sessionID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load conversation to title", "session_id", sessionID, "error", err)
+			return
+		}
+		if conv.Title != "" || conv.Client != client || len(conv.Turns) == 0 {
+			return // Titled already (only the first exchange costs an LLM call), or not client's.
+		}
+		title := t.generate(ctx, conv.Turns[0].Content)
+		err = t.store.SetConversationTitle(ctx, sessionID, client, title, false)
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			// Titled meanwhile, by the user or an earlier exchange.
+		case err != nil:
+			slog.ErrorContext(ctx, "Failed to store conversation title", "session_id", sessionID, "error", err)
+		default:
+			slog.DebugContext(ctx, "Conversation titled", "session_id", sessionID, "title", title)
+		}
+	}()
+}
+
+// generate asks the LLM for a title, falling back to the shortened question when the call
+// fails or returns nothing usable. The call waits for the provider's quota behind those of
+// requests being answered.
+func (t *conversationTitler) generate(ctx context.Context, question string) string {
+	if t.llm != nil {
+		ctx = llmclient.WithPriority(ctx, llmclient.PriorityBackground)
+		answer, err := t.llm.ChatCompletion(ctx, fmt.Sprintf(titlePrompt, question))
+		if err != nil {
+			slog.WarnContext(ctx, "Title generation failed; using the question", "error", err)
+		} else if title := cleanTitle(answer); title != "" {
+			return title
+		}
+	}
+	return cleanTitle(question)
+}
+
+// Wait blocks until the titles being generated are stored or ctx is done, and reports whether
+// they all were. Shutdown calls it before disconnecting the database.
+func (t *conversationTitler) Wait(ctx context.Context) bool {
+	finished := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// cleanTitle turns text into a title: its first line, without surrounding quotes, markdown
+// emphasis or a final period, and at most maxTitleLength characters.
+func cleanTitle(text string) string {
+	text, _, _ = strings.Cut(strings.TrimSpace(text), "\n")
+	const decoration = " \t\"'`*#“”«»"
+	text = strings.TrimLeft(text, decoration)
+	text = strings.TrimPrefix(text, "Title:") // Also as "**Title:** ..."
+	text = strings.Trim(text, decoration)
+	text = strings.TrimSuffix(text, ".")
+	text = strings.Join(strings.Fields(text), " ")
+	return truncateTitle(text)
+}
+
+// truncateTitle cuts title to maxTitleLength characters, at the last space when there is one.
+func truncateTitle(title string) string {
+	runes := []rune(title)
+	if len(runes) <= maxTitleLength {
+		return title
+	}
+	cut := string(runes[:maxTitleLength-1])
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > maxTitleLength/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRightFunc(cut, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsPunct(r) }) + "…"
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestCleanTitle(t *testing.T) {
+	for text, want := range map[string]string{
+		`"Flights from Madrid to Paris."`:            "Flights from Madrid to Paris",
+		"**Title:** Weekend in Rome\nSome more text": "Weekend in Rome",
+		"Title: “Vuelos a París”":                    "Vuelos a París",
+		"  spaced   out\ttitle  ":                    "spaced out title",
+		strings.Repeat("word ", 30):                  strings.TrimSpace(strings.Repeat("word ", 15)) + "…",
+	} {
+		if got := cleanTitle(text); got != want {
+			t.Errorf("cleanTitle(%q) = %q, want %q", text, got, want)
+		}
+	}
+	if got := cleanTitle(strings.Repeat("x", 200)); len([]rune(got)) != maxTitleLength {
+		t.Errorf("a title without spaces cut to %d characters", len([]rune(got)))
+	}
+}
+
+// failingLLM fails every call.
+type failingLLM struct{}
+
+func (failingLLM) ChatCompletion(context.Context, string) (string, error) {
+	return "", errors.New("provider down")
+}
+
+func (failingLLM) StreamChatCompletion(context.Context, string) (<-chan string, error) {
+	return nil, errors.New("provider down")
+}
+
+func TestConversationTitler(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		llm  llmclient.LLMClient
+		want string
+	}{
+		{"generated", &llmclient.MockClient{Response: `"Paris by air."`, Latency: 100 * time.Millisecond}, "Paris by air"},
+		{"failed call", failingLLM{}, "Which flights go from Madrid to Paris on Friday?"},
+		{"no LLM", nil, "Which flights go from Madrid to Paris on Friday?"},
+	} {
+		store := db.NewMemoryClient()
+		store.AppendTurns(context.Background(), "s-1", "key:a",
+			db.Turn{Role: db.RoleUser, Content: "Which flights go from Madrid to Paris on Friday?"},
+			db.Turn{Role: db.RoleAssistant, Content: "FL101."})
+		titler := &conversationTitler{store: store, llm: tt.llm}
+
+		start := time.Now()
+		titler.titleLater(context.Background(), "s-1", "key:a")
+		titler.titleLater(context.Background(), "s-1", "key:b") // Not b's conversation
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Errorf("%s: titleLater took %v, want it to return at once", tt.name, elapsed)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if !titler.Wait(ctx) {
+			t.Fatalf("%s: titles still being generated", tt.name)
+		}
+		cancel()
+		if conv, _ := store.GetConversation(context.Background(), "s-1"); conv.Title != tt.want {
+			t.Errorf("%s: title %q, want %q", tt.name, conv.Title, tt.want)
+		}
+	}
+}
+
+func TestConversationTitlerKeepsTitle(t *testing.T) {
+	store := db.NewMemoryClient()
+	store.AppendTurns(context.Background(), "s-1", "key:a", db.Turn{Role: db.RoleUser, Content: "Flights to Rome"})
+	store.SetConversationTitle(context.Background(), "s-1", "key:a", "My trip", true)
+	titler := &conversationTitler{store: store, llm: failingLLM{}}
+	titler.titleLater(context.Background(), "s-1", "key:a")
+	titler.Wait(context.Background())
+	if conv, _ := store.GetConversation(context.Background(), "s-1"); conv.Title != "My trip" {
+		t.Errorf("title %q, want the user's", conv.Title)
+	}
+}
+
+// decodeJSON decodes the JSON body of resp into v and closes it.
+func decodeJSON(t *testing.T, resp *http.Response, v any) {
+	t.Helper()
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sessionList returns the sessions the API key key lists once one of them has a title.
+func sessionList(t *testing.T, s *testServer, key string) []db.ConversationSummary {
+	t.Helper()
+	var list struct {
+		Sessions []db.ConversationSummary `json:"sessions"`
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		req, _ := http.NewRequest(http.MethodGet, s.url+"/api/sessions", nil)
+		req.Header.Set("X-API-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decodeJSON(t, resp, &list)
+		if (len(list.Sessions) > 0 && list.Sessions[0].Title != "") || time.Now().After(deadline) {
+			return list.Sessions
+		}
+	}
+}
+
+// renameSession sends PATCH /api/sessions/{id} with key.
+func renameSession(t *testing.T, s *testServer, key, sessionID, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPatch, s.url+"/api/sessions/"+sessionID, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestSessionTitleAndRename(t *testing.T) {
+	s := startServer(t)
+	req, _ := http.NewRequest(http.MethodPost, s.url+"/api", strings.NewReader(`{"message":"What is the capital of France?","language":"en","session_id":"trip-1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "team-one-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, sse.NewReader(resp.Body))
+	resp.Body.Close()
+
+	// The title is generated after the answer, by the mock LLM.
+	sessions := sessionList(t, s, "team-one-key")
+	if len(sessions) != 1 || sessions[0].SessionID != "trip-1" || sessions[0].Title == "" || sessions[0].TurnCount != 2 {
+		t.Fatalf("sessions %+v, want trip-1 with a title", sessions)
+	}
+	if len([]rune(sessions[0].Title)) > maxTitleLength {
+		t.Errorf("title %q is longer than %d characters", sessions[0].Title, maxTitleLength)
+	}
+
+	resp = renameSession(t, s, "team-one-key", "trip-1", `{"title":"  Weekend   in Paris "}`)
+	var renamed map[string]string
+	decodeJSON(t, resp, &renamed)
+	if resp.StatusCode != http.StatusOK || renamed["title"] != "Weekend in Paris" {
+		t.Errorf("rename answered %d %v", resp.StatusCode, renamed)
+	}
+	if sessions := sessionList(t, s, "team-one-key"); sessions[0].Title != "Weekend in Paris" {
+		t.Errorf("listed title %q after the rename", sessions[0].Title)
+	}
+
+	for _, tt := range []struct {
+		name, key, sessionID, body string
+		status                     int
+		code                       string
+	}{
+		{"another key", "team-two-key", "trip-1", `{"title":"Mine"}`, http.StatusNotFound, httpapi.CodeSessionNotFound},
+		{"unknown session", "team-one-key", "trip-9", `{"title":"Mine"}`, http.StatusNotFound, httpapi.CodeSessionNotFound},
+		{"empty title", "team-one-key", "trip-1", `{"title":"  "}`, http.StatusBadRequest, httpapi.CodeEmptyTitle},
+		{"long title", "team-one-key", "trip-1", `{"title":"` + strings.Repeat("a", maxTitleLength+1) + `"}`, http.StatusBadRequest, httpapi.CodeTitleTooLong},
+		{"malformed", "team-one-key", "trip-1", `{"title":`, http.StatusBadRequest, httpapi.CodeMalformedJSON},
+	} {
+		resp := renameSession(t, s, tt.key, tt.sessionID, tt.body)
+		if resp.StatusCode != tt.status || errorCode(t, resp) != tt.code {
+			t.Errorf("%s: rename answered %d", tt.name, resp.StatusCode)
+		}
+		resp.Body.Close()
+	}
+	if sessions := sessionList(t, s, "team-two-key"); len(sessions) != 0 {
+		t.Errorf("another key lists %+v", sessions)
+	}
+}
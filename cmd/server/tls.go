@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+)
+
+// listen starts the HTTP server on cfg.Addr, over HTTPS when cfg.TLS enables it, and the
+// redirecting listener of cfg.TLS.RedirectAddr, if any (nil otherwise). Each sends the error
+// that ends it to serveErr, which must have room for both. HTTPS is served with HTTP/2, which
+// carries many streams over one connection, so a browser's concurrent SSE streams to the
+// server don't use up its connections per host.
+func listen(cfg config.Server, serveErr chan<- error) (srv, redirect *http.Server) {
+	srv = &http.Server{Addr: cfg.Addr}
+	if !cfg.TLS.Enabled() {
+		go func() {
+			serveErr <- srv.ListenAndServe()
+		}()
+		return srv, nil
+	}
+
+	var redirectHandler http.Handler = httpsRedirect(cfg.Addr)
+	if cfg.TLS.Autocert() {
+		certs := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLS.AutocertHosts...),
+			Cache:      autocert.DirCache(cfg.TLS.AutocertCacheDir),
+			Email:      cfg.TLS.AutocertEmail,
+		}
+		srv.TLSConfig = certs.TLSConfig() // Offers h2 and answers the authority's TLS challenges
+		redirectHandler = certs.HTTPHandler(redirectHandler)
+	}
+	go func() {
+		// The files are empty with automatic certificates, which TLSConfig provides.
+		serveErr <- srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	}()
+	if cfg.TLS.RedirectAddr != "" {
+		redirect = &http.Server{Addr: cfg.TLS.RedirectAddr, Handler: redirectHandler}
+		go func() {
+			serveErr <- redirect.ListenAndServe()
+		}()
+	}
+	return srv, redirect
+}
+
+// httpsRedirect redirects every request to the same host and path over HTTPS, on the port of
+// addr, the HTTPS listener's address. The redirect is permanent and keeps the method and body
+// (308), so a POST to /api is sent again as a POST.
+func httpsRedirect(addr string) http.HandlerFunc {
+	_, port, _ := net.SplitHostPort(addr)
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	}
+}
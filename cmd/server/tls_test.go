@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and localhost, valid for an
+// hour, and its key, and returns their files and a pool that trusts it.
+func writeTestCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+// freeAddr returns a local address no one listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	for _, tt := range []struct {
+		addr, host, target, want string
+	}{
+		{":8443", "chat.example.com", "/api?format=json", "https://chat.example.com:8443/api?format=json"},
+		{":8443", "chat.example.com:8080", "/healthz", "https://chat.example.com:8443/healthz"},
+		{":443", "chat.example.com:80", "/api", "https://chat.example.com/api"},
+		{"127.0.0.1:8443", "[::1]:80", "/", "https://[::1]:8443/"},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader("hi"))
+		req.Host = tt.host
+		httpsRedirect(tt.addr)(rec, req)
+		// 308 keeps the method and body, so a POST to /api is sent again as a POST.
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != tt.want {
+			t.Errorf("%s%s on %s: %d to %q, want %q", tt.host, tt.target, tt.addr, rec.Code, rec.Header().Get("Location"), tt.want)
+		}
+	}
+}
+
+func TestCheckTLSCertificate(t *testing.T) {
+	certFile, keyFile, _ := writeTestCert(t)
+	cfg := config.TLS{CertFile: certFile, KeyFile: keyFile}
+	if r := checkTLS(cfg, time.Now()); r.Status != checkPass || !strings.Contains(r.Detail, "localhost") {
+		t.Errorf("valid certificate: %+v", r)
+	}
+	if r := checkTLS(cfg, time.Now().Add(2*time.Hour)); r.Status != checkWarn {
+		t.Errorf("expired certificate: %+v", r)
+	}
+	if r := checkTLS(config.TLS{CertFile: certFile, KeyFile: certFile}, time.Now()); r.Status != checkFail {
+		t.Errorf("certificate as its own key: %+v", r)
+	}
+}
+
+func TestTLSServer(t *testing.T) {
+	certFile, keyFile, pool := writeTestCert(t)
+	redirectAddr := freeAddr(t)
+	s := startServer(t, "TLS_CERT_FILE="+certFile, "TLS_KEY_FILE="+keyFile, "TLS_REDIRECT_ADDR="+redirectAddr)
+	httpsURL := "https://" + strings.TrimPrefix(s.url, "http://")
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}, ForceAttemptHTTP2: true}}
+	defer client.CloseIdleConnections()
+
+	// A whole streamed answer over HTTPS, with HTTP/2.
+	resp, err := client.Post(httpsURL+"/api?format=json", "application/json", strings.NewReader(`{"message":"Flights from Madrid to Paris","stream":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := readAll(t, sse.NewReader(resp.Body))
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Errorf("answered over %s with %s", resp.Proto, resp.Header.Get("Content-Type"))
+	}
+	if done := doneOf(t, frames); done.Outcome != sse.OutcomeOK {
+		t.Errorf("Done = %+v", done)
+	}
+
+	// Plain HTTP is redirected to HTTPS, keeping the POST, which a client follows to the answer.
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err = noFollow.Post("http://"+redirectAddr+"/api?format=json", "application/json", strings.NewReader(`{"message":"Flights from Madrid to Paris"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPermanentRedirect || resp.Header.Get("Location") != httpsURL+"/api?format=json" {
+		t.Errorf("redirect %d to %q, want %s", resp.StatusCode, resp.Header.Get("Location"), httpsURL+"/api?format=json")
+	}
+	resp, err = client.Post("http://"+redirectAddr+"/api?format=json", "application/json", strings.NewReader(`{"message":"Flights from Madrid to Paris"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames = readAll(t, sse.NewReader(resp.Body))
+	resp.Body.Close()
+	if resp.Request.URL.Scheme != "https" || doneOf(t, frames).Outcome != sse.OutcomeOK {
+		t.Errorf("followed the redirect to %s, ending with %+v", resp.Request.URL, frames[len(frames)-1])
+	}
+
+	// Shutdown stops both listeners.
+	if err := s.terminate(); err != nil {
+		t.Fatalf("server exited with %v", err)
+	}
+	for _, addr := range []string{strings.TrimPrefix(s.url, "http://"), redirectAddr} {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			t.Errorf("%s still listening after shutdown", addr)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/metrics"
+)
+
+const (
+	usageWriteTimeout = 5 * time.Second // Bounds recording a finished request's usage
+	quotaCheckTimeout = 2 * time.Second // Bounds the quota lookup before a request starts
+)
+
+// usageAccount is the account a client's usage is billed to. API keys are stored as a hash
+// prefix, never in clear; IP addresses and bot users are stored as their client key.
+func usageAccount(key string) string {
+	apiKey, ok := strings.CutPrefix(key, "key:")
+	if !ok {
+		return key
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return "key:" + hex.EncodeToString(sum[:8])
+}
+
+// usageMeter adds up the tokens one request's LLM calls used, by model. The orchestrator's
+// calls run concurrently, so it is safe for concurrent use.
+type usageMeter struct {
+	mu      sync.Mutex
+	byModel map[string]llmclient.Usage
+}
+
+type usageMeterKey struct{}
+
+// withUsageMeter returns a context whose LLM calls are counted in the returned meter.
+func withUsageMeter(ctx context.Context) (context.Context, *usageMeter) {
+	m := &usageMeter{byModel: make(map[string]llmclient.Usage)}
+	return context.WithValue(ctx, usageMeterKey{}, m), m
+}
+
+// countUsage is the LLM clients' usage hook: it adds usage to the meter of the request that
+// made the call, if it has one.
+func countUsage(ctx context.Context, model string, usage llmclient.Usage) {
+	m, ok := ctx.Value(usageMeterKey{}).(*usageMeter)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sum := m.byModel[model]
+	sum.PromptTokens += usage.PromptTokens
+	sum.CompletionTokens += usage.CompletionTokens
+	sum.TotalTokens += usage.TotalTokens
+	m.byModel[model] = sum
+}
+
+// usageTracker records each request's usage per account and day, and enforces the monthly
+// token quota.
+type usageTracker struct {
+	store  db.Client
+	quota  int64                                      // Tokens per account per calendar month; 0 means unlimited
+	prices atomic.Pointer[map[string]llmclient.Price] // Per-model prices for the cost estimate; see setPrices
+	now    func() time.Time
+}
+
+// newUsageTracker returns a tracker pricing usage at llmclient.DefaultPrices, overridden and
+// extended by prices.
+func newUsageTracker(store db.Client, quota int, prices map[string]llmclient.Price) *usageTracker {
+	t := &usageTracker{store: store, quota: int64(quota), now: time.Now}
+	t.setPrices(prices)
+	return t
+}
+
+// pricesWith returns llmclient.DefaultPrices, overridden and extended by prices.
+func pricesWith(prices map[string]llmclient.Price) map[string]llmclient.Price {
+	merged := maps.Clone(llmclient.DefaultPrices)
+	maps.Copy(merged, prices)
+	return merged
+}
+
+// setPrices replaces the configured prices, as newUsageTracker takes them. Requests are priced
+// when they finish, so those running when it is called get the new prices.
+func (t *usageTracker) setPrices(prices map[string]llmclient.Price) {
+	merged := pricesWith(prices)
+	t.prices.Store(&merged)
+}
+
+// record adds one finished request and the tokens in m to key's usage of today. It runs after
+// the request, so its own failure is only logged.
+func (t *usageTracker) record(ctx context.Context, key string, m *usageMeter) {
+	delta := db.UsageDelta{Client: usageAccount(key), Time: t.now(), Requests: 1}
+	prices := *t.prices.Load()
+	m.mu.Lock()
+	for model, usage := range m.byModel {
+		delta.PromptTokens += int64(usage.PromptTokens)
+		delta.CompletionTokens += int64(usage.CompletionTokens)
+		cost, ok := llmclient.EstimateCost(prices, model, usage)
+		if !ok {
+			slog.WarnContext(ctx, "No price for model; its usage is counted at no cost", "model", model)
+		}
+		delta.CostUSD += cost
+	}
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), usageWriteTimeout)
+	defer cancel()
+	if err := t.store.IncrementUsage(ctx, delta); err != nil {
+		slog.ErrorContext(ctx, "Failed to record usage", "client", maskClient(key), "error", err)
+	}
+}
+
+// checkQuota rejects a request from key once its account has used its monthly token quota,
+// with 402 and a Retry-After pointing at the start of next month. The request that crosses
+// the quota still finishes; the ones after it are refused. When the usage can't be read the
+// request is allowed, since an outage of the usage store shouldn't take the chat down.
+func (t *usageTracker) checkQuota(ctx context.Context, key string) *httpapi.Error {
+	if t.quota <= 0 {
+		return nil
+	}
+	now := t.now().UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	ctx, cancel := context.WithTimeout(ctx, quotaCheckTimeout)
+	defer cancel()
+	days, err := t.store.ListUsage(ctx, db.UsageQuery{
+		Client: usageAccount(key),
+		From:   monthStart.Format(db.UsageDayFormat),
+		To:     now.Format(db.UsageDayFormat),
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Quota check failed; allowing the request", "client", maskClient(key), "error", err)
+		return nil
+	}
+	var used int64
+	for _, day := range days {
+		used += day.TotalTokens()
+	}
+	if used < t.quota {
+		return nil
+	}
+	metrics.RateLimited.WithLabelValues("quota").Inc()
+	slog.InfoContext(ctx, "Request rejected: monthly token quota used up", "client", maskClient(key), "used", used, "quota", t.quota)
+	return &httpapi.Error{
+		Status:     http.StatusPaymentRequired,
+		Code:       httpapi.CodeQuotaExceeded,
+		Message:    "Monthly token quota used up; it resets on the first of next month (UTC)",
+		RetryAfter: monthStart.AddDate(0, 1, 0).Sub(now),
+	}
+}
+
+// usageTotal sums one account's daily records over the queried period.
+type usageTotal struct {
+	Client           string  `json:"client"`
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// usageHandler serves GET /api/admin/usage: daily usage records and per-account totals for
+// ?from= to ?to= (YYYY-MM-DD, inclusive; the current month by default), optionally only for
+// ?client= (an account as listed, e.g. "key:3f2a…", or ?api_key= to look one up by its key).
+func usageHandler(store db.Client, now func() time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		today := now().UTC()
+		q := db.UsageQuery{
+			Client: query.Get("client"),
+			From:   time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC).Format(db.UsageDayFormat),
+			To:     today.Format(db.UsageDayFormat),
+		}
+		if key := query.Get("api_key"); key != "" {
+			q.Client = usageAccount("key:" + key)
+		}
+		for name, dst := range map[string]*string{"from": &q.From, "to": &q.To} {
+			raw := query.Get(name)
+			if raw == "" {
+				continue
+			}
+			if _, err := time.Parse(db.UsageDayFormat, raw); err != nil {
+				httpapi.Write(w, r, httpapi.BadRequest("invalid_"+name, name+" must be a date like 2025-08-01"))
+				return
+			}
+			*dst = raw
+		}
+
+		days, err := store.ListUsage(r.Context(), q)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Listing usage failed", "error", err)
+			httpapi.Write(w, r, &httpapi.Error{Status: statusForDBError(err), Code: httpapi.CodeUsageUnavailable, Message: "Usage could not be loaded"})
+			return
+		}
+		totals := []usageTotal{}
+		for _, day := range days { // Ordered by client, so each account's days are adjacent.
+			if len(totals) == 0 || totals[len(totals)-1].Client != day.Client {
+				totals = append(totals, usageTotal{Client: day.Client})
+			}
+			total := &totals[len(totals)-1]
+			total.Requests += day.Requests
+			total.PromptTokens += day.PromptTokens
+			total.CompletionTokens += day.CompletionTokens
+			total.TotalTokens += day.TotalTokens()
+			total.CostUSD += day.CostUSD
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"from": q.From, "to": q.To, "totals": totals, "days": days})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestUsageAccount(t *testing.T) {
+	account := usageAccount("key:sk-live-abcd1234")
+	if !strings.HasPrefix(account, "key:") || strings.Contains(account, "abcd1234") || len(account) != len("key:")+16 {
+		t.Errorf("usageAccount = %q, want a hash prefix of the key", account)
+	}
+	if account != usageAccount("key:sk-live-abcd1234") || account == usageAccount("key:sk-live-other") {
+		t.Error("accounts aren't one per key")
+	}
+	if got := usageAccount("ip:192.0.2.1"); got != "ip:192.0.2.1" {
+		t.Errorf("usageAccount of an address = %q", got)
+	}
+}
+
+func TestUsageTrackerQuota(t *testing.T) {
+	store := db.NewMemoryClient()
+	tracker := newUsageTracker(store, 100, map[string]llmclient.Price{"test-model": {Prompt: 1e6, Completion: 2e6}})
+	now := time.Date(2026, 3, 31, 22, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	// Last month's usage doesn't count against this month's quota.
+	store.IncrementUsage(context.Background(), db.UsageDelta{Client: usageAccount("key:a"), Time: time.Date(2026, 2, 28, 12, 0, 0, 0, time.UTC), PromptTokens: 500})
+
+	request := func(prompt, completion int) {
+		ctx, meter := withUsageMeter(context.Background())
+		countUsage(ctx, "test-model", llmclient.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion})
+		countUsage(ctx, "test-model", llmclient.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion})
+		tracker.record(ctx, "key:a", meter)
+	}
+	request(20, 20) // 80 tokens
+	if apiErr := tracker.checkQuota(context.Background(), "key:a"); apiErr != nil {
+		t.Fatalf("rejected under the quota: %+v", apiErr)
+	}
+	request(5, 5) // 100: the quota is used up
+	apiErr := tracker.checkQuota(context.Background(), "key:a")
+	if apiErr == nil || apiErr.Status != http.StatusPaymentRequired || apiErr.Code != httpapi.CodeQuotaExceeded || apiErr.RetryAfter != 2*time.Hour {
+		t.Fatalf("error %+v, want 402 until the start of April", apiErr)
+	}
+	if apiErr := tracker.checkQuota(context.Background(), "key:b"); apiErr != nil {
+		t.Errorf("another key rejected: %+v", apiErr)
+	}
+
+	usage, _ := store.ListUsage(context.Background(), db.UsageQuery{Client: usageAccount("key:a"), From: "2026-03-01"})
+	if len(usage) != 1 || usage[0].Requests != 2 || usage[0].PromptTokens != 50 || usage[0].CompletionTokens != 50 || usage[0].CostUSD != 150 {
+		t.Errorf("usage %+v", usage)
+	}
+}
+
+// keyedChat starts a chat request sent with an API key, asking for JSON envelopes.
+func keyedChat(t *testing.T, s *testServer, key, message string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, s.url+"/api", strings.NewReader(`{"message":"`+message+`","language":"en"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream, application/json")
+	req.Header.Set("X-API-Key", key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestUsageReport(t *testing.T) {
+	s := startServer(t, "ADMIN_API_KEYS=admin-key")
+	for key, n := range map[string]int{"team-one-key": 3, "team-two-key": 2} {
+		for i := range n {
+			resp := keyedChat(t, s, key, []string{"Show me flights from Madrid to Paris", "What is the capital of France?"}[i%2])
+			readAll(t, sse.NewReader(resp.Body))
+			resp.Body.Close()
+		}
+	}
+
+	// Usage is recorded as each request finishes, just after its stream ends.
+	var report struct {
+		Totals []usageTotal `json:"totals"`
+		Days   []db.Usage   `json:"days"`
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		if status := adminGet(t, s, "/api/admin/usage", &report); status != http.StatusOK {
+			t.Fatalf("usage answered %d", status)
+		}
+		var requests int64
+		for _, total := range report.Totals {
+			requests += total.Requests
+		}
+		if requests == 5 || time.Now().After(deadline) {
+			break
+		}
+	}
+	requests := map[string]int64{}
+	for _, total := range report.Totals {
+		requests[total.Client] = total.Requests
+		if total.PromptTokens <= 0 || total.CompletionTokens <= 0 || total.TotalTokens != total.PromptTokens+total.CompletionTokens || total.CostUSD <= 0 {
+			t.Errorf("total %+v", total)
+		}
+	}
+	if requests[usageAccount("key:team-one-key")] != 3 || requests[usageAccount("key:team-two-key")] != 2 || len(requests) != 2 {
+		t.Errorf("requests per account %v, want 3 and 2", requests)
+	}
+	if today := time.Now().UTC().Format(db.UsageDayFormat); len(report.Days) != 2 || report.Days[0].Day != today {
+		t.Errorf("days %+v, want one record per account for today", report.Days)
+	}
+
+	// One key's usage, looked up by the key.
+	if adminGet(t, s, "/api/admin/usage?api_key=team-two-key", &report); len(report.Totals) != 1 || report.Totals[0].Requests != 2 {
+		t.Errorf("totals of one key %+v", report.Totals)
+	}
+	req, _ := http.NewRequest(http.MethodGet, s.url+"/api/admin/usage?from=March", nil)
+	req.Header.Set("X-API-Key", "admin-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, resp) != "invalid_from" {
+		t.Errorf("a bad date answered %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+}
+
+func TestUsageQuotaCutoff(t *testing.T) {
+	// One stream per client, the next one queued: the queued request starts once the first
+	// has recorded the tokens that use up the quota.
+	s := startServer(t, "USAGE_MONTHLY_TOKEN_QUOTA=1", "RATE_LIMIT_MAX_STREAMS=1", "RATE_LIMIT_QUEUE=true", "LLM_MOCK_LATENCY=300ms")
+	first := keyedChat(t, s, "team-one-key", "What is the capital of France?")
+	defer first.Body.Close()
+	firstReader := sse.NewReader(first.Body)
+	if _, err := firstReader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	queued := keyedChat(t, s, "team-one-key", "What is the capital of Spain?")
+	defer queued.Body.Close()
+
+	if frames := readAll(t, firstReader); doneOf(t, frames).Outcome != sse.OutcomeOK {
+		t.Errorf("the request crossing the quota ended with %+v", frames[len(frames)-1])
+	}
+	var code string
+	frames := readAll(t, sse.NewReader(queued.Body))
+	for _, frame := range frames {
+		if env, err := sse.ParseEnvelope(frame.Data); err == nil && env.Type == sse.TypeError {
+			var payload sse.ErrorPayload
+			json.Unmarshal(env.Data, &payload)
+			code = payload.Code
+		}
+	}
+	if code != httpapi.CodeQuotaExceeded || doneOf(t, frames).Outcome != sse.OutcomeError {
+		t.Errorf("queued request got error %q and ended with %+v", code, frames[len(frames)-1])
+	}
+
+	// Later requests are refused before they start; another key still gets answers.
+	resp := keyedChat(t, s, "team-one-key", "Hi")
+	if resp.StatusCode != http.StatusPaymentRequired || resp.Header.Get("Retry-After") == "" || errorCode(t, resp) != httpapi.CodeQuotaExceeded {
+		t.Errorf("request over the quota answered %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+	resp = keyedChat(t, s, "team-two-key", "Hi")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("another key's request answered %d", resp.StatusCode)
+	}
+	readAll(t, sse.NewReader(resp.Body))
+	resp.Body.Close()
+}
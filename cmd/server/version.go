@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/version"
+)
+
+// versionResponse is the body of GET /version: the build plus the server-wide feature switches,
+// which together explain most differences in behavior between deployments.
+type versionResponse struct {
+	version.Info
+	Replica  string          `json:"replica"` // This server, among replicas
+	Features map[string]bool `json:"features"`
+}
+
+// enabledFeatures lists the feature switches and optional integrations and whether each is on.
+func enabledFeatures(cfg *config.Config, tracingEnabled bool) map[string]bool {
+	return map[string]bool{
+		"streaming":      cfg.Features.Streaming,
+		"aggregation":    cfg.Features.Aggregation,
+		"telemetry":      cfg.Features.Telemetry,
+		"titles":         cfg.Features.Titles,
+		"grounding":      cfg.Features.Grounding,
+		"pii_masking":    cfg.Features.PIIMasking,
+		"language_check": cfg.Features.LanguageCheck,
+		"route_phrasing": cfg.Features.RoutePhrasing,
+		"tracing":        tracingEnabled,
+		"slack":          cfg.Slack.Enabled(),
+		"telegram":       cfg.Telegram.Enabled(),
+		"callbacks":      cfg.Callbacks.Enabled(),
+		"idempotency":    cfg.Idempotency.Retention > 0,
+		"shared_streams": cfg.SSE.Shared,
+		"sharing":        cfg.Sharing.Enabled(),
+		"weather":        cfg.Weather.Enabled(),
+		"persona":        cfg.Persona.Enabled(),
+	}
+}
+
+// versionHandler serves GET /version. The response never changes while the server runs.
+func versionHandler(info version.Info, replica string, features map[string]bool) http.HandlerFunc {
+	body, _ := json.Marshal(versionResponse{Info: info, Replica: replica, Features: features})
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/config"
+	"github.com/Cris245/go-llm-chat/internal/version"
+)
+
+func TestVersionHandler(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Features.Streaming = true
+	info := version.Info{Version: "v1.2.0", Commit: "abc1234", BuildDate: "2026-05-01T08:00:00Z", GoVersion: "go1.23.6"}
+	h := versionHandler(info, "replica-a", enabledFeatures(cfg, false))
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Version   string          `json:"version"`
+		Commit    string          `json:"commit"`
+		BuildDate string          `json:"build_date"`
+		GoVersion string          `json:"go_version"`
+		Replica   string          `json:"replica"`
+		Features  map[string]bool `json:"features"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Version != "v1.2.0" || body.Commit != "abc1234" || body.BuildDate != info.BuildDate || body.GoVersion != "go1.23.6" || body.Replica != "replica-a" {
+		t.Errorf("body %+v", body)
+	}
+	if on, listed := body.Features["streaming"]; !on || !listed {
+		t.Errorf("streaming feature %v, listed %v", on, listed)
+	}
+	if on, listed := body.Features["tracing"]; on || !listed {
+		t.Errorf("tracing feature %v, listed %v; want it listed as off", on, listed)
+	}
+}
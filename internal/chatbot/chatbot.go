@@ -0,0 +1,211 @@
+// Package chatbot relays chat answers to messaging platforms such as Slack. A platform adapter
+// receives a user's message, starts it through a ChatFunc and hands the resulting event stream
+// to Reply, which renders it as one or a few chat messages: a placeholder is posted right away
+// and edited as the answer streams in, so the user sees it being "typed".
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+	"github.com/Cris245/go-llm-chat/internal/timezone"
+)
+
+// Defaults for Options fields left at zero.
+const (
+	DefaultMaxLength    = 3500
+	DefaultEditInterval = time.Second
+)
+
+// ChatFunc starts a chat request for user (a platform-specific client key, used for rate
+// limiting) in sessionID and returns the stream its events go to. The error, if any, is a
+// message that can be shown to the user.
+type ChatFunc func(ctx context.Context, user, sessionID, text string) (*sse.Stream, error)
+
+// Messenger posts and edits the messages of one reply, e.g. in a Slack thread or a Telegram chat.
+type Messenger interface {
+	// Post sends a new message and returns its platform ID.
+	Post(ctx context.Context, text string) (id string, err error)
+	// Edit replaces the text of a message sent with Post.
+	Edit(ctx context.Context, id, text string) error
+}
+
+// Options tune how Reply renders an answer.
+type Options struct {
+	MaxLength    int           // Characters per message; longer answers continue in new messages
+	EditInterval time.Duration // Minimum time between edits while the answer streams in
+	ShowFlights  bool          // Append the FlightResults as a list after the answer
+	Language     string        // i18n code of the placeholders and flight list; empty means English
+}
+
+// Reply renders stream through m until the stream finishes: it posts a placeholder, edits it as
+// status updates and answer chunks arrive (at most once per EditInterval), and writes the final
+// text once the Done event arrives. An answer longer than MaxLength is split over several
+// messages. Failed intermediate edits are logged and skipped, since the next one replaces them;
+// the error returned is that of the final update.
+func Reply(ctx context.Context, stream *sse.Stream, m Messenger, opts Options) error {
+	if opts.MaxLength <= 0 {
+		opts.MaxLength = DefaultMaxLength
+	}
+	if opts.EditInterval <= 0 {
+		opts.EditInterval = DefaultEditInterval
+	}
+	r := &reply{m: m, opts: opts}
+	if err := r.sync(ctx); err != nil {
+		return err
+	}
+
+	lastEdit := time.Now()
+	err := stream.Follow(ctx, func(event sse.Event) {
+		r.add(event)
+		if !r.done && time.Since(lastEdit) >= opts.EditInterval {
+			if err := r.sync(ctx); err != nil {
+				slog.WarnContext(ctx, "Updating chat reply failed", "stream", stream.ID(), "error", err)
+			}
+			lastEdit = time.Now()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return r.sync(ctx)
+}
+
+// reply is the rendering state of one answer.
+type reply struct {
+	m    Messenger
+	opts Options
+
+	status  string // Latest Status event
+	answer  strings.Builder
+	flights []db.Flight
+	errMsg  string // Message of the Error event, if any
+	outcome string // Set by the Done event
+	done    bool
+
+	ids  []string // Messages posted so far, in order
+	sent []string // Text each message currently shows
+}
+
+// add applies one event to the state.
+func (r *reply) add(event sse.Event) {
+	switch event.Type {
+	case sse.TypeStatus:
+		r.status = event.Data
+	case sse.TypeMessage:
+		r.answer.WriteString(event.Data)
+	case sse.TypeFlightResults:
+		r.flights, _ = event.Payload.([]db.Flight)
+	case sse.TypeError:
+		r.errMsg = event.Data
+	case sse.TypeDone:
+		r.done = true
+		if done, ok := event.Payload.(sse.DonePayload); ok {
+			r.outcome = done.Outcome
+		}
+	}
+}
+
+// text renders the current state.
+func (r *reply) text() string {
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(r.answer.String()))
+	if r.opts.ShowFlights && len(r.flights) > 0 {
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString(FormatFlights(r.opts.Language, r.flights))
+	}
+	if r.errMsg != "" {
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString("⚠️ " + r.errMsg)
+	}
+
+	switch {
+	case b.Len() == 0 && r.outcome == sse.OutcomeCancelled:
+		return i18n.T(r.opts.Language, "bot.cancelled")
+	case b.Len() == 0 && r.done:
+		return i18n.T(r.opts.Language, "bot.no_answer")
+	case b.Len() == 0 && r.status != "":
+		return "⏳ " + r.status + "…"
+	case b.Len() == 0:
+		return "⏳ " + i18n.T(r.opts.Language, "bot.thinking")
+	case !r.done:
+		b.WriteString(" …") // Still typing.
+	}
+	return b.String()
+}
+
+// sync brings the posted messages up to date with the current text, posting new messages
+// for parts that don't fit in the existing ones.
+func (r *reply) sync(ctx context.Context) error {
+	for i, part := range splitText(r.text(), r.opts.MaxLength) {
+		if i < len(r.ids) {
+			if r.sent[i] == part {
+				continue
+			}
+			if err := r.m.Edit(ctx, r.ids[i], part); err != nil {
+				return fmt.Errorf("edit message %d: %w", i+1, err)
+			}
+			r.sent[i] = part
+			continue
+		}
+		id, err := r.m.Post(ctx, part)
+		if err != nil {
+			return fmt.Errorf("post message %d: %w", i+1, err)
+		}
+		r.ids = append(r.ids, id)
+		r.sent = append(r.sent, part)
+	}
+	return nil
+}
+
+// splitText cuts text into parts of at most limit characters, preferring to break at a line
+// end, then at a space, so words and list items stay whole where possible.
+func splitText(text string, limit int) []string {
+	var parts []string
+	for utf8.RuneCountInString(text) > limit {
+		cut := runeOffset(text, limit)
+		if i := strings.LastIndex(text[:cut], "\n"); i > 0 {
+			cut = i
+		} else if i := strings.LastIndex(text[:cut], " "); i > 0 {
+			cut = i
+		}
+		parts = append(parts, strings.TrimRight(text[:cut], " \n"))
+		text = strings.TrimLeft(text[cut:], " \n")
+	}
+	return append(parts, text)
+}
+
+// runeOffset returns the byte offset of the n-th rune of s.
+func runeOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(s)
+}
+
+// FormatFlights renders flights as a plain-text list in lang, one flight per line, with their
+// times in local time and UTC where the flights carry their time zones.
+func FormatFlights(lang string, flights []db.Flight) string {
+	var b strings.Builder
+	b.WriteString(i18n.T(lang, "bot.flights_found", len(flights)))
+	for _, f := range flights {
+		b.WriteString("\n" + i18n.T(lang, "bot.flight_line",
+			f.FlightNumber, db.PlaceName(f.Origin, f.OriginAirport), db.PlaceName(f.Destination, f.DestinationAirport),
+			timezone.Show(f.DepartureTime, f.DepartureTimeZone), timezone.Show(f.ArrivalTime, f.ArrivalTimeZone), f.Price, f.AvailableSeats))
+	}
+	return b.String()
+}
@@ -0,0 +1,1268 @@
+// Package config loads the server's settings into a typed Config.
+//
+// Settings come from four layers; later layers override earlier ones:
+//
+//  1. Defaults (see Default).
+//  2. A YAML or JSON file named by -config or CONFIG_FILE (JSON is valid YAML, so one parser reads both).
+//  3. Environment variables (see the table in applyEnv).
+//  4. Command-line flags (see Load).
+//
+// Load validates the merged result, so the server can refuse to start with a clear list of problems
+// instead of failing later on a bad value.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Cris245/go-llm-chat/internal/currency"
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/flags"
+	"github.com/Cris245/go-llm-chat/internal/httpmw"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/persona"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+	"github.com/Cris245/go-llm-chat/internal/timezone"
+	"github.com/Cris245/go-llm-chat/internal/weather"
+)
+
+// Database backends.
+const (
+	BackendMongo  = "mongo"
+	BackendMemory = "memory"
+)
+
+// Orchestration modes.
+const (
+	ModeFull   = "full"    // Answers come from the LLM pipelines
+	ModeDBOnly = "db-only" // Answers are written from the database, without LLM calls
+)
+
+// Config is the complete server configuration.
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Log       Log       `yaml:"log"`
+	DB        DB        `yaml:"db"`
+	LLM       LLM       `yaml:"llm"`
+	SSE       SSE       `yaml:"sse"`
+	RateLimit RateLimit `yaml:"rate_limit"`
+	Admin     Admin     `yaml:"admin"`
+	CORS      CORS      `yaml:"cors"`
+	Features  Features  `yaml:"features"`
+	Slack     Slack     `yaml:"slack"`
+	Telegram  Telegram  `yaml:"telegram"`
+	Usage     Usage     `yaml:"usage"`
+	Callbacks Callbacks `yaml:"callbacks"`
+
+	Idempotency Idempotency `yaml:"idempotency"`
+	Weather     Weather     `yaml:"weather"`
+	Currency    Currency    `yaml:"currency"`
+	Persona     Persona     `yaml:"persona"`
+	Flags       Flags       `yaml:"flags"`
+	Retention   Retention   `yaml:"retention"`
+	Cities      Cities      `yaml:"cities"`
+	Abuse       Abuse       `yaml:"abuse"`
+	Sharing     Sharing     `yaml:"sharing"`
+
+	// Orchestrator says how requests are answered: by the LLM pipelines, or from the database.
+	Orchestrator Orchestrator `yaml:"orchestrator"`
+
+	// PromptDir is a directory of prompt template overrides. It is validated here; the
+	// orchestrator still uses its built-in prompts.
+	PromptDir string `yaml:"prompt_dir"`
+
+	// I18nDir is a directory of message catalogs (<language>.json) overlaid on the built-in
+	// translations of status and system messages; see package i18n.
+	I18nDir string `yaml:"i18n_dir"`
+
+	// Check and SkipLLM are command-line only (-check, -skip-llm): run the preflight checks and
+	// exit instead of serving, leaving out the LLM calls with SkipLLM.
+	Check   bool `yaml:"-"`
+	SkipLLM bool `yaml:"-"`
+
+	// MigrateDryRun and MigrateDown are command-line only too (-migrate-dry-run, -migrate-down):
+	// list the pending migrations, or revert those above version MigrateDown (-1 for none), and
+	// exit instead of serving. With both, the migrations that would be reverted are listed.
+	MigrateDryRun bool `yaml:"-"`
+	MigrateDown   int  `yaml:"-"`
+}
+
+// Server holds the HTTP server's settings.
+type Server struct {
+	HTTPEnabled          bool          `yaml:"http_enabled"`          // False runs only the bots (e.g. Telegram), without listening
+	Addr                 string        `yaml:"addr"`                  // Listen address, e.g. ":8080"
+	OrchestrationTimeout time.Duration `yaml:"orchestration_timeout"` // Bounds a single request's LLM pipeline
+	RequestTimeout       time.Duration `yaml:"request_timeout"`       // Bounds the time before a response starts; 0 disables
+	ShutdownGracePeriod  time.Duration `yaml:"shutdown_grace_period"` // How long in-flight requests may finish after SIGTERM
+
+	// MaxConcurrentChats bounds the orchestrations running at once across all clients; 0 is
+	// unlimited. Requests over it are rejected with 503, or with ChatQueue wait in a FIFO queue
+	// of up to ChatMaxQueue requests.
+	MaxConcurrentChats int  `yaml:"max_concurrent_chats"`
+	ChatQueue          bool `yaml:"chat_queue"`
+	ChatMaxQueue       int  `yaml:"chat_max_queue"`
+
+	// TLS serves HTTPS, and HTTP/2, on Addr instead of plain HTTP; see TLS.
+	TLS TLS `yaml:"tls"`
+
+	// ReplicaID names this server in its logs and the Done telemetry, to tell replicas apart;
+	// empty uses the host name.
+	ReplicaID string `yaml:"replica_id"`
+}
+
+// TLS holds the HTTPS settings. The certificate comes from CertFile and KeyFile, or is obtained
+// automatically from Let's Encrypt for AutocertHosts and kept in AutocertCacheDir. Without
+// either the server speaks plain HTTP.
+type TLS struct {
+	CertFile string `yaml:"cert_file"` // PEM certificate chain
+	KeyFile  string `yaml:"key_file"`  // PEM private key
+
+	AutocertHosts    []string `yaml:"autocert_hosts"`     // Host names certificates may be obtained for
+	AutocertCacheDir string   `yaml:"autocert_cache_dir"` // Where obtained certificates are kept across restarts
+	AutocertEmail    string   `yaml:"autocert_email"`     // Contact for the certificate authority; optional
+
+	// RedirectAddr is the address of a plain HTTP listener that redirects to HTTPS, e.g. ":80";
+	// empty runs none. With automatic certificates it also answers the authority's HTTP
+	// challenges.
+	RedirectAddr string `yaml:"redirect_addr"`
+}
+
+// Enabled reports whether the server serves HTTPS.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || len(t.AutocertHosts) > 0
+}
+
+// Autocert reports whether certificates are obtained automatically.
+func (t TLS) Autocert() bool {
+	return len(t.AutocertHosts) > 0
+}
+
+// Log holds the logging settings passed to logging.Setup.
+type Log struct {
+	Level  string `yaml:"level"`  // debug, info, warn or error
+	Format string `yaml:"format"` // text or json
+}
+
+// DB holds the database settings.
+type DB struct {
+	Backend        string        `yaml:"backend"`   // "mongo" or "memory"
+	MongoURI       string        `yaml:"mongo_uri"` // Required for the mongo backend
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	SearchCacheTTL time.Duration `yaml:"search_cache_ttl"` // 0 disables caching of flight searches
+	QueryLog       bool          `yaml:"query_log"`        // Record every request in the query audit log
+
+	// QueryLogPrompts adds each request's LLM prompts and responses and its answer to its query
+	// log record, for GET /api/admin/requests/{request_id}. Turn it off where prompts must not be
+	// stored.
+	QueryLogPrompts bool `yaml:"query_log_prompts"`
+
+	// StaleAfter is how long a search with an expired cache entry waits for the database
+	// before it is answered with the entry instead (see db.CachedClient.ServeStale); 0 never
+	// serves expired entries. MaxStale is how long after expiry an entry can still be served.
+	StaleAfter time.Duration `yaml:"stale_after"`
+	MaxStale   time.Duration `yaml:"max_stale"`
+
+	// ReadPreference and MaxQueryTime route the searches and conversation reads of the mongo
+	// backend, and bound them; see db.Config.
+	ReadPreference string        `yaml:"read_preference"`
+	MaxQueryTime   time.Duration `yaml:"max_query_time"`
+}
+
+// Mongo returns the settings of the mongo backend's client.
+func (d DB) Mongo() db.Config {
+	return db.Config{ReadPreference: d.ReadPreference, MaxQueryTime: d.MaxQueryTime}
+}
+
+// LLM holds the settings of the three pipeline slots. Provider and Model are shared defaults
+// for slots that leave theirs empty; MaxRetries and RPS apply to every slot.
+type LLM struct {
+	APIKey      string        `yaml:"api_key"` // OpenAI API key; normally set through OPENAI_API_KEY rather than a file
+	Provider    string        `yaml:"provider"`
+	Model       string        `yaml:"model"`
+	MaxRetries  int           `yaml:"max_retries"`  // Retries of rate-limited, 5xx or network failures; 0 disables
+	RPS         float64       `yaml:"rps"`          // Calls per second across all slots of a provider; 0 means unlimited
+	MockLatency time.Duration `yaml:"mock_latency"` // How long the "mock" provider takes to answer
+	LLM1        Slot          `yaml:"llm1"`         // Lists flights / answers general questions
+	LLM2        Slot          `yaml:"llm2"`         // Computes durations and costs
+	LLM3        Slot          `yaml:"llm3"`         // Aggregates the worker answers
+	Budget      TokenBudget   `yaml:"budget"`       // Per-request token limit across the three slots
+	Output      OutputLimit   `yaml:"output"`       // Longest answer sent to the user
+	Routing     ModelRouting  `yaml:"routing"`      // Models for some calls in place of the slots'
+
+	// Quotas are the tokens and requests per minute of each provider's account, by provider
+	// name. Every slot and route on a provider queues for its quota; see ProviderQuota.
+	Quotas map[string]ProviderQuota `yaml:"quotas"`
+
+	// WorkerProgress is how often LLM 1 and LLM 2, called with streamed completions, report how
+	// many tokens they have written (see orchestrator.SetWorkerProgress); 0 calls them without
+	// streaming.
+	WorkerProgress time.Duration `yaml:"worker_progress"`
+
+	// Signing signs every request to the OpenAI API, for a gateway in front of it that checks
+	// the signatures; see RequestSigning.
+	Signing RequestSigning `yaml:"signing"`
+
+	// Condense shortens worker answers too long to paste into the aggregation prompt whole;
+	// see Condensation.
+	Condense Condensation `yaml:"condense"`
+
+	// FlightLines rewrites the lines of flight answers that present one flight into a
+	// canonical line; see FlightFormat.
+	FlightLines FlightFormat `yaml:"flight_lines"`
+}
+
+// FlightFormat rewrites each line of LLM 3's answer to a flight question that presents one of
+// the flights found into the line Template writes from its record (see
+// orchestrator.SetFlightFormat and orchestrator.FlightLine for the fields).
+type FlightFormat struct {
+	Enabled  bool   `yaml:"enabled"`
+	Template string `yaml:"template"` // text/template; "" is the default of the answer's language
+}
+
+// Condensation shortens the worker answers estimated at more than MaxTokens tokens before
+// LLM 3 aggregates them (see orchestrator.SetCondensation): by keeping their highest-ranked
+// sentences, or with Summarize by asking an LLM, routing.condensation's model or LLM 1's.
+type Condensation struct {
+	MaxTokens int  `yaml:"max_tokens"` // 0 never condenses
+	Summarize bool `yaml:"summarize"`  // Falls back to keeping sentences if the summary fails
+}
+
+// ProviderQuota is what a provider's account allows per minute (see llmclient.Quota). Calls
+// that would go over it wait, those a request's answer waits for before conversation titles.
+type ProviderQuota struct {
+	TPM int `yaml:"tpm"` // Tokens per minute, prompts and completions; 0 means unlimited
+	RPM int `yaml:"rpm"` // Requests per minute; 0 means unlimited
+}
+
+// RequestSigning holds the HMAC signing of LLM requests (see llmclient.HMACSigner). Each
+// request gets its Unix time in TimestampHeader and the HMAC-SHA256, under Key, of that time,
+// a "." and the body in SignatureHeader. Requests are signed when Key is set.
+type RequestSigning struct {
+	Key             string `yaml:"key"` // Normally set through LLM_SIGNING_KEY rather than a file
+	SignatureHeader string `yaml:"signature_header"`
+	TimestampHeader string `yaml:"timestamp_header"`
+}
+
+// Enabled reports whether LLM requests are signed.
+func (s RequestSigning) Enabled() bool {
+	return s.Key != ""
+}
+
+// Orchestrator holds how requests are answered.
+type Orchestrator struct {
+	// Mode is ModeFull, or ModeDBOnly to answer from the database alone, with no LLM clients
+	// and no API key (see orchestrator.EnableDBOnly).
+	Mode string `yaml:"mode"`
+}
+
+// DBOnly reports whether requests are answered without LLM calls.
+func (o Orchestrator) DBOnly() bool {
+	return o.Mode == ModeDBOnly
+}
+
+// TokenBudget limits the tokens one request's LLM calls may use together (see
+// orchestrator.TokenBudget). Each call's completion is capped from what is left; a request
+// whose next call would get less than its minimum is stopped.
+type TokenBudget struct {
+	MaxTokens            int `yaml:"max_tokens"`             // Prompt and completion tokens per request; 0 means unlimited
+	MinWorkerTokens      int `yaml:"min_worker_tokens"`      // Smallest completion cap for LLM 1 and LLM 2
+	MinAggregationTokens int `yaml:"min_aggregation_tokens"` // Smallest completion cap for LLM 3
+}
+
+// OutputLimit caps the length of an answer (see orchestrator.OutputLimit). An answer that
+// reaches it is cut short, with a notice; a streamed one stops its LLM call.
+type OutputLimit struct {
+	MaxChars  int `yaml:"max_chars"`  // Characters per answer; 0 means unlimited
+	MaxTokens int `yaml:"max_tokens"` // Estimated tokens per answer (four characters each); 0 means unlimited
+}
+
+// ModelRouting names the models that make some calls in place of the slots' (see
+// llmclient.Router), on the shared provider. Empty ones leave the slots' models in place.
+type ModelRouting struct {
+	Flight      string `yaml:"flight"`      // LLM 1 and LLM 2 on flight questions
+	General     string `yaml:"general"`     // LLM 1 and LLM 2 on general questions
+	Aggregation string `yaml:"aggregation"` // LLM 3, on every question
+
+	// Condensation summarizes worker answers that are too long, with llm.condense.summarize.
+	Condensation string `yaml:"condensation"`
+}
+
+// RouteSlots returns a slot for each route of Routing that is set, named by its llmclient
+// route name, on the shared provider.
+func (l *LLM) RouteSlots() []NamedSlot {
+	var slots []NamedSlot
+	for _, route := range []struct{ name, model string }{
+		{llmclient.RouteFlight, l.Routing.Flight},
+		{llmclient.RouteGeneral, l.Routing.General},
+		{llmclient.RouteAggregation, l.Routing.Aggregation},
+		{llmclient.RouteCondensation, l.Routing.Condensation},
+	} {
+		if route.model != "" {
+			slots = append(slots, NamedSlot{route.name, Slot{Provider: l.Provider, Model: route.model}})
+		}
+	}
+	return slots
+}
+
+// Slots returns the three slots keyed by their pipeline names ("llm1", "llm2", "llm3").
+func (l *LLM) Slots() []NamedSlot {
+	return []NamedSlot{{"llm1", l.LLM1}, {"llm2", l.LLM2}, {"llm3", l.LLM3}}
+}
+
+// NamedSlot is a slot with its pipeline name.
+type NamedSlot struct {
+	Name string
+	Slot
+}
+
+// resolveSlots fills the slots' empty provider and model from the shared defaults.
+func (l *LLM) resolveSlots() {
+	for _, slot := range []*Slot{&l.LLM1, &l.LLM2, &l.LLM3} {
+		if slot.Provider == "" {
+			slot.Provider = l.Provider
+		}
+		if slot.Model == "" {
+			slot.Model = l.Model
+		}
+	}
+}
+
+// Slot selects the provider and model for one LLM slot.
+type Slot struct {
+	Provider string `yaml:"provider"`
+	Model    string `yaml:"model"`
+}
+
+// SSE holds the event-stream delivery settings (see sse.Handler).
+type SSE struct {
+	BufferSize      int           `yaml:"buffer_size"` // Events a client may lag behind before it is disconnected
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	RetryInterval   time.Duration `yaml:"retry_interval"`
+	CoalesceWindow  time.Duration `yaml:"coalesce_window"`
+	StreamRetention time.Duration `yaml:"stream_retention"` // How long finished streams can be resumed
+
+	// Shared stores every stream's events in the database as well, so a client can resume a
+	// stream, or retry an idempotent request, on any replica.
+	Shared bool `yaml:"shared"`
+}
+
+// RateLimit holds the per-client limits; zero values turn a limit off.
+type RateLimit struct {
+	RPS        float64 `yaml:"rps"`
+	Burst      int     `yaml:"burst"`
+	MaxStreams int     `yaml:"max_streams"`
+	Queue      bool    `yaml:"queue"`
+	MaxQueue   int     `yaml:"max_queue"`
+}
+
+// Admin holds the admin endpoint settings.
+type Admin struct {
+	APIKeys []string `yaml:"api_keys"` // With none, admin endpoints reject every request
+
+	// DeveloperKeys are the client API keys whose chat requests may replace the persona's
+	// system prompts (persona_overrides). With none, no request may.
+	DeveloperKeys []string `yaml:"developer_keys"`
+}
+
+// CORS is the cross-origin policy for browser clients (see httpmw.CORSConfig).
+type CORS struct {
+	AllowedOrigins []string      `yaml:"allowed_origins"` // "*", exact origins, or wildcard subdomains like https://*.example.com
+	AllowedMethods []string      `yaml:"allowed_methods"`
+	AllowedHeaders []string      `yaml:"allowed_headers"`
+	MaxAge         time.Duration `yaml:"max_age"` // How long browsers cache a preflight
+}
+
+// Features are server-wide switches for optional pipeline behavior.
+type Features struct {
+	Streaming   bool `yaml:"streaming"`   // Default for requests that don't say whether to stream the answer
+	Aggregation bool `yaml:"aggregation"` // Default for requests that don't say whether to aggregate
+	Telemetry   bool `yaml:"telemetry"`   // Include the telemetry summary in Done events
+	Titles      bool `yaml:"titles"`      // Title new conversations with an extra LLM call
+	Grounding   bool `yaml:"grounding"`   // Check flight answers' facts against their records, after answering
+	PIIMasking  bool `yaml:"pii_masking"` // Mask card numbers, IBANs, emails and phone numbers in user messages
+
+	LanguageCheck bool `yaml:"language_check"` // Ask LLM 3 again when it answers in another language than the request's
+
+	RoutePhrasing bool `yaml:"route_phrasing"` // Have LLM 3 reword the answers to route questions
+}
+
+// Usage holds the settings of per-client LLM usage accounting. Usage is always recorded;
+// the quota is enforced only when set.
+type Usage struct {
+	MonthlyTokenQuota int                        `yaml:"monthly_token_quota"` // Tokens per client per calendar month (UTC); 0 means unlimited
+	Prices            map[string]llmclient.Price `yaml:"prices"`              // Per-model prices, over llmclient.DefaultPrices; file only
+}
+
+// Callbacks holds the settings of asynchronous requests, whose progress and answer are POSTed
+// to a URL the client gives. They are enabled when the signing secret is set.
+type Callbacks struct {
+	SigningSecret string        `yaml:"signing_secret"` // Signs every callback, so receivers can verify it came from this server
+	JobTTL        time.Duration `yaml:"job_ttl"`        // How long a job and its result can be polled
+	MaxAttempts   int           `yaml:"max_attempts"`   // Deliveries of the final callback before giving up
+	Timeout       time.Duration `yaml:"timeout"`        // Bounds one delivery attempt
+}
+
+// Enabled reports whether asynchronous requests are configured.
+func (c Callbacks) Enabled() bool {
+	return c.SigningSecret != ""
+}
+
+// Idempotency holds the settings of idempotency keys, which let clients retry a chat request
+// without running it twice.
+type Idempotency struct {
+	Retention time.Duration `yaml:"retention"` // How long a finished request can be replayed by its key; 0 turns keys off
+}
+
+// Retention holds how many days the records holding users' text are kept before a periodic
+// sweep deletes them; 0 days keeps them forever. Jobs and idempotency keys expire on their own.
+type Retention struct {
+	ConversationDays int           `yaml:"conversation_days"` // After a conversation's last turn
+	QueryLogDays     int           `yaml:"query_log_days"`    // After the request
+	PreferenceDays   int           `yaml:"preference_days"`   // After the preferences' last change
+	SweepInterval    time.Duration `yaml:"sweep_interval"`    // How often expired records are deleted
+}
+
+// Enabled reports whether any records expire.
+func (r Retention) Enabled() bool {
+	return r.ConversationDays > 0 || r.QueryLogDays > 0 || r.PreferenceDays > 0
+}
+
+// Settings returns the retention as the db package takes it.
+func (r Retention) Settings() db.Retention {
+	day := 24 * time.Hour
+	return db.Retention{
+		Conversations: time.Duration(r.ConversationDays) * day,
+		QueryLogs:     time.Duration(r.QueryLogDays) * day,
+		Preferences:   time.Duration(r.PreferenceDays) * day,
+	}
+}
+
+// Weather holds the settings of the weather enrichment of flight answers (see
+// orchestrator.SetWeather). It is enabled when a provider is set.
+type Weather struct {
+	Provider     string        `yaml:"provider"`      // "open-meteo"; empty turns enrichment off
+	Always       bool          `yaml:"always"`        // Enrich every flight answer with a destination, not only weather questions
+	Timeout      time.Duration `yaml:"timeout"`       // Bounds one forecast lookup
+	GeocodingURL string        `yaml:"geocoding_url"` // Overrides the provider's geocoding endpoint
+	ForecastURL  string        `yaml:"forecast_url"`  // Overrides the provider's forecast endpoint
+}
+
+// Enabled reports whether weather enrichment is configured.
+func (w Weather) Enabled() bool {
+	return w.Provider != ""
+}
+
+// Currency holds the settings of price conversion for questions asked in another currency
+// (see orchestrator.SetCurrency).
+type Currency struct {
+	Base     string        `yaml:"base"`      // Currency the flight prices are stored in
+	Provider string        `yaml:"provider"`  // Exchange rates: "static" (built-in table) or "frankfurter"
+	RatesURL string        `yaml:"rates_url"` // Overrides the Frankfurter API endpoint
+	Refresh  time.Duration `yaml:"refresh"`   // How long fetched rates are used before refetching
+}
+
+// Persona holds the deployment's system prompt (see package persona), given to every worker
+// and aggregation call. It is enabled when a prompt or a prompt file is set.
+type Persona struct {
+	BotName    string            `yaml:"bot_name"`    // {{.BotName}} in prompts
+	Company    string            `yaml:"company"`     // {{.Company}} in prompts
+	Prompt     string            `yaml:"prompt"`      // Default prompt template
+	Prompts    map[string]string `yaml:"prompts"`     // Per-language templates by language code; file only
+	PromptFile string            `yaml:"prompt_file"` // YAML file of templates by language code and "default"; reloaded on SIGHUP
+}
+
+// Enabled reports whether a system prompt is configured.
+func (p Persona) Enabled() bool {
+	return p.Prompt != "" || len(p.Prompts) > 0 || p.PromptFile != ""
+}
+
+// Settings returns the persona package's configuration.
+func (p Persona) Settings() persona.Config {
+	return persona.Config{BotName: p.BotName, Company: p.Company, Prompt: p.Prompt, Prompts: p.Prompts, File: p.PromptFile}
+}
+
+// Cities holds how the cities questions name are recognized: the names the database gives
+// them, reloaded every RefreshInterval and when flights change, plus aliases. TimeZones are
+// the tz database names of cities and airports flight times are shown in.
+type Cities struct {
+	AliasesFile     string        `yaml:"aliases_file"`     // YAML map of other names to cities, added to the built-in ones
+	RefreshInterval time.Duration `yaml:"refresh_interval"` // 0 reloads them only when flights change
+
+	TimeZones map[string]string `yaml:"time_zones"` // By city or IATA airport code, over timezone.Default; file only
+}
+
+// Abuse holds when a client is banned for abuse: after Threshold rejected requests (invalid
+// ones and rate limit hits) within Window, its requests are refused for BanDuration.
+type Abuse struct {
+	Threshold   int           `yaml:"threshold"` // 0 never bans
+	Window      time.Duration `yaml:"window"`
+	BanDuration time.Duration `yaml:"ban_duration"`
+}
+
+// Enabled reports whether clients are banned for abuse.
+func (a Abuse) Enabled() bool {
+	return a.Threshold > 0
+}
+
+// Sharing holds the settings of conversation share links, which show a conversation read-only
+// to anyone who has the link.
+type Sharing struct {
+	LinkTTL time.Duration `yaml:"link_ttl"` // How long a link works after it is created; 0 turns sharing off
+}
+
+// Enabled reports whether conversations can be shared.
+func (s Sharing) Enabled() bool {
+	return s.LinkTTL > 0
+}
+
+// Flags holds the feature flags' rules (see package flags) and how often the overrides stored
+// in the database are read again.
+type Flags struct {
+	Rules        map[string]FlagRule `yaml:"rules"`         // By flag name; file only
+	PollInterval time.Duration       `yaml:"poll_interval"` // 0 reads the overrides only at startup
+}
+
+// FlagRule is one flag's rule; see flags.Rule.
+type FlagRule struct {
+	Enabled  bool     `yaml:"enabled"`
+	Percent  float64  `yaml:"percent"`
+	Clients  []string `yaml:"clients"`
+	Sessions []string `yaml:"sessions"`
+}
+
+// Settings returns the rules as the flags package takes them.
+func (f Flags) Settings() map[string]flags.Rule {
+	rules := make(map[string]flags.Rule, len(f.Rules))
+	for name, rule := range f.Rules {
+		rules[name] = flags.Rule(rule)
+	}
+	return rules
+}
+
+// Slack holds the Slack integration settings. The integration is enabled when the signing
+// secret and bot token are both set.
+type Slack struct {
+	SigningSecret string `yaml:"signing_secret"` // Verifies that events come from Slack
+	BotToken      string `yaml:"bot_token"`      // xoxb- token used to post the answers
+	APIURL        string `yaml:"api_url"`        // Base URL of the Slack Web API
+}
+
+// Enabled reports whether the Slack integration is configured.
+func (s Slack) Enabled() bool {
+	return s.SigningSecret != "" && s.BotToken != ""
+}
+
+// Telegram holds the Telegram bot settings. The bot runs when the token is set.
+type Telegram struct {
+	BotToken    string        `yaml:"bot_token"`    // From @BotFather
+	APIURL      string        `yaml:"api_url"`      // Base URL of the Bot API
+	PollTimeout time.Duration `yaml:"poll_timeout"` // How long each getUpdates long poll waits for messages
+}
+
+// Enabled reports whether the Telegram bot is configured.
+func (t Telegram) Enabled() bool {
+	return t.BotToken != ""
+}
+
+// Default returns the configuration used when nothing overrides it.
+func Default() Config {
+	return Config{
+		MigrateDown: -1,
+		Server: Server{
+			HTTPEnabled:          true,
+			Addr:                 ":8080",
+			OrchestrationTimeout: 2 * time.Minute,
+			RequestTimeout:       30 * time.Second,
+			ShutdownGracePeriod:  30 * time.Second,
+			ChatMaxQueue:         50,
+			TLS:                  TLS{AutocertCacheDir: "autocert-cache"},
+		},
+		Log: Log{Level: "info", Format: "text"},
+		DB: DB{
+			Backend:        BackendMongo,
+			ConnectTimeout: 10 * time.Second,
+			SearchCacheTTL: time.Minute,
+			StaleAfter:     500 * time.Millisecond,
+			MaxStale:       15 * time.Minute,
+			ReadPreference: "secondaryPreferred",
+			MaxQueryTime:   10 * time.Second,
+
+			QueryLogPrompts: true,
+		},
+		LLM: LLM{
+			Provider:   llmclient.ProviderOpenAI,
+			Model:      "gpt-4o-mini",
+			MaxRetries: 2,
+			Budget:     TokenBudget{MinWorkerTokens: 64, MinAggregationTokens: 128},
+			Output:     OutputLimit{MaxChars: 32000},
+			Signing:    RequestSigning{SignatureHeader: llmclient.DefaultSignatureHeader, TimestampHeader: llmclient.DefaultTimestampHeader},
+		},
+		Orchestrator: Orchestrator{Mode: ModeFull},
+		SSE: SSE{
+			BufferSize:      sse.DefaultBufferSize,
+			WriteTimeout:    sse.DefaultWriteTimeout,
+			RetryInterval:   sse.DefaultRetryInterval,
+			CoalesceWindow:  sse.DefaultCoalesceWindow,
+			StreamRetention: 2 * time.Minute,
+		},
+		RateLimit: RateLimit{Burst: 5, MaxQueue: 5},
+		CORS: CORS{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "Accept", "Last-Event-ID", "Authorization", "X-API-Key", "X-Request-ID", "Idempotency-Key"},
+			MaxAge:         10 * time.Minute,
+		},
+		Features:    Features{Aggregation: true, Telemetry: true, Titles: true, PIIMasking: true, LanguageCheck: true},
+		Callbacks:   Callbacks{JobTTL: 24 * time.Hour, MaxAttempts: 5, Timeout: 10 * time.Second},
+		Idempotency: Idempotency{Retention: 24 * time.Hour},
+		Weather:     Weather{Timeout: 3 * time.Second},
+		Flags:       Flags{PollInterval: 30 * time.Second},
+		Retention:   Retention{SweepInterval: time.Hour},
+		Cities:      Cities{RefreshInterval: 5 * time.Minute},
+		Abuse:       Abuse{Window: time.Minute, BanDuration: 15 * time.Minute},
+		Sharing:     Sharing{LinkTTL: 7 * 24 * time.Hour},
+		Currency:    Currency{Base: currency.USD, Provider: currency.ProviderStatic, Refresh: time.Hour},
+		Slack:       Slack{APIURL: "https://slack.com/api"},
+		Telegram:    Telegram{APIURL: "https://api.telegram.org", PollTimeout: 30 * time.Second},
+	}
+}
+
+// Load builds the configuration from defaults, the optional config file, the environment
+// (read through getenv, normally os.Getenv) and the command-line args (without the program name).
+// If the flags parse but the settings don't, the error comes with the configuration as far as
+// it got, so -check can report it.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to a YAML or JSON config file (default $CONFIG_FILE)")
+	fs.String("addr", "", "listen address, e.g. :8080 (overrides HTTP_ADDR)")
+	fs.String("log-level", "", "log level: debug, info, warn or error (overrides LOG_LEVEL)")
+	fs.String("log-format", "", "log format: text or json (overrides LOG_FORMAT)")
+	fs.String("db-backend", "", "database backend: mongo or memory (overrides DB_BACKEND)")
+	check := fs.Bool("check", false, "check the configuration, database and LLMs, print a report and exit")
+	skipLLM := fs.Bool("skip-llm", false, "with -check, leave out the LLM calls")
+	migrateDryRun := fs.Bool("migrate-dry-run", false, "list the database migrations that would run and exit")
+	migrateDown := fs.Int("migrate-down", -1, "revert the database migrations above this version and exit")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	cfg.Check, cfg.SkipLLM = *check, *skipLLM
+	cfg.MigrateDryRun, cfg.MigrateDown = *migrateDryRun, *migrateDown
+	path := *configPath
+	if path == "" {
+		path = getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return &cfg, err
+		}
+	}
+	if err := cfg.applyEnv(getenv); err != nil {
+		return &cfg, err
+	}
+	// Only flags given on the command line override; their zero defaults must not.
+	fs.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "addr":
+			cfg.Server.Addr = value
+		case "log-level":
+			cfg.Log.Level = value
+		case "log-format":
+			cfg.Log.Format = value
+		case "db-backend":
+			cfg.DB.Backend = value
+		}
+	})
+
+	cfg.LLM.resolveSlots()
+	if err := cfg.Validate(); err != nil {
+		return &cfg, err
+	}
+	return &cfg, nil
+}
+
+// loadFile overlays the settings present in the file at path. Unknown keys are errors,
+// so a typo doesn't silently leave a setting at its default.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) { // An empty file changes nothing.
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overlays the environment variables that are set (non-empty).
+func (c *Config) applyEnv(getenv func(string) string) error {
+	vars := []struct {
+		name string
+		set  func(string) error
+	}{
+		{"HTTP_ENABLED", setBool(&c.Server.HTTPEnabled)},
+		{"HTTP_ADDR", setString(&c.Server.Addr)},
+		{"ORCHESTRATION_TIMEOUT", setDuration(&c.Server.OrchestrationTimeout)},
+		{"REQUEST_TIMEOUT", setDuration(&c.Server.RequestTimeout)},
+		{"SHUTDOWN_GRACE_PERIOD", setDuration(&c.Server.ShutdownGracePeriod)},
+		{"MAX_CONCURRENT_CHATS", setInt(&c.Server.MaxConcurrentChats)},
+		{"CHAT_QUEUE", setBool(&c.Server.ChatQueue)},
+		{"CHAT_MAX_QUEUE", setInt(&c.Server.ChatMaxQueue)},
+		{"TLS_CERT_FILE", setString(&c.Server.TLS.CertFile)},
+		{"TLS_KEY_FILE", setString(&c.Server.TLS.KeyFile)},
+		{"TLS_AUTOCERT_HOSTS", setList(&c.Server.TLS.AutocertHosts)},
+		{"TLS_AUTOCERT_CACHE_DIR", setString(&c.Server.TLS.AutocertCacheDir)},
+		{"TLS_AUTOCERT_EMAIL", setString(&c.Server.TLS.AutocertEmail)},
+		{"TLS_REDIRECT_ADDR", setString(&c.Server.TLS.RedirectAddr)},
+		{"REPLICA_ID", setString(&c.Server.ReplicaID)},
+		{"LOG_LEVEL", setString(&c.Log.Level)},
+		{"LOG_FORMAT", setString(&c.Log.Format)},
+		{"DB_BACKEND", setString(&c.DB.Backend)},
+		{"MONGO_URI", setString(&c.DB.MongoURI)},
+		{"DB_CONNECT_TIMEOUT", setDuration(&c.DB.ConnectTimeout)},
+		{"SEARCH_CACHE_TTL", setDuration(&c.DB.SearchCacheTTL)},
+		{"QUERY_LOG_ENABLED", setBool(&c.DB.QueryLog)},
+		{"QUERY_LOG_PROMPTS", setBool(&c.DB.QueryLogPrompts)},
+		{"SEARCH_STALE_AFTER", setDuration(&c.DB.StaleAfter)},
+		{"SEARCH_MAX_STALE", setDuration(&c.DB.MaxStale)},
+		{"MONGO_READ_PREFERENCE", setString(&c.DB.ReadPreference)},
+		{"MONGO_MAX_QUERY_TIME", setDuration(&c.DB.MaxQueryTime)},
+		{"ORCH_MODE", setString(&c.Orchestrator.Mode)},
+		{"OPENAI_API_KEY", setString(&c.LLM.APIKey)},
+		{"LLM_PROVIDER", setString(&c.LLM.Provider)},
+		{"LLM_MODEL", setString(&c.LLM.Model)},
+		{"LLM_MAX_RETRIES", setInt(&c.LLM.MaxRetries)},
+		{"LLM_RATE_LIMIT_RPS", setFloat(&c.LLM.RPS)},
+		{"LLM_MOCK_LATENCY", setDuration(&c.LLM.MockLatency)},
+		{"LLM_QUOTA_OPENAI_TPM", setQuota(&c.LLM.Quotas, llmclient.ProviderOpenAI, false)},
+		{"LLM_QUOTA_OPENAI_RPM", setQuota(&c.LLM.Quotas, llmclient.ProviderOpenAI, true)},
+		{"LLM_QUOTA_MOCK_TPM", setQuota(&c.LLM.Quotas, llmclient.ProviderMock, false)},
+		{"LLM_QUOTA_MOCK_RPM", setQuota(&c.LLM.Quotas, llmclient.ProviderMock, true)},
+		{"LLM1_PROVIDER", setString(&c.LLM.LLM1.Provider)},
+		{"LLM1_MODEL", setString(&c.LLM.LLM1.Model)},
+		{"LLM2_PROVIDER", setString(&c.LLM.LLM2.Provider)},
+		{"LLM2_MODEL", setString(&c.LLM.LLM2.Model)},
+		{"LLM3_PROVIDER", setString(&c.LLM.LLM3.Provider)},
+		{"LLM3_MODEL", setString(&c.LLM.LLM3.Model)},
+		{"LLM_TOKEN_BUDGET", setInt(&c.LLM.Budget.MaxTokens)},
+		{"LLM_MIN_WORKER_TOKENS", setInt(&c.LLM.Budget.MinWorkerTokens)},
+		{"LLM_MIN_AGGREGATION_TOKENS", setInt(&c.LLM.Budget.MinAggregationTokens)},
+		{"LLM_MAX_OUTPUT_CHARS", setInt(&c.LLM.Output.MaxChars)},
+		{"LLM_MAX_OUTPUT_TOKENS", setInt(&c.LLM.Output.MaxTokens)},
+		{"LLM_WORKER_PROGRESS", setDuration(&c.LLM.WorkerProgress)},
+		{"LLM_CONDENSE_MAX_TOKENS", setInt(&c.LLM.Condense.MaxTokens)},
+		{"LLM_CONDENSE_SUMMARIZE", setBool(&c.LLM.Condense.Summarize)},
+		{"LLM_FLIGHT_LINES", setBool(&c.LLM.FlightLines.Enabled)},
+		{"LLM_FLIGHT_LINE_TEMPLATE", setString(&c.LLM.FlightLines.Template)},
+		{"LLM_SIGNING_KEY", setString(&c.LLM.Signing.Key)},
+		{"LLM_SIGNING_HEADER", setString(&c.LLM.Signing.SignatureHeader)},
+		{"LLM_SIGNING_TIMESTAMP_HEADER", setString(&c.LLM.Signing.TimestampHeader)},
+		{"MODEL_FOR_FLIGHT", setString(&c.LLM.Routing.Flight)},
+		{"MODEL_FOR_GENERAL", setString(&c.LLM.Routing.General)},
+		{"MODEL_FOR_AGGREGATION", setString(&c.LLM.Routing.Aggregation)},
+		{"MODEL_FOR_CONDENSATION", setString(&c.LLM.Routing.Condensation)},
+		{"SSE_BUFFER_SIZE", setInt(&c.SSE.BufferSize)},
+		{"SSE_WRITE_TIMEOUT", setDuration(&c.SSE.WriteTimeout)},
+		{"SSE_RETRY_INTERVAL", setDuration(&c.SSE.RetryInterval)},
+		{"SSE_COALESCE_WINDOW", setDuration(&c.SSE.CoalesceWindow)},
+		{"STREAM_RETENTION", setDuration(&c.SSE.StreamRetention)},
+		{"SSE_SHARED_STREAMS", setBool(&c.SSE.Shared)},
+		{"RATE_LIMIT_RPS", setFloat(&c.RateLimit.RPS)},
+		{"RATE_LIMIT_BURST", setInt(&c.RateLimit.Burst)},
+		{"RATE_LIMIT_MAX_STREAMS", setInt(&c.RateLimit.MaxStreams)},
+		{"RATE_LIMIT_QUEUE", setBool(&c.RateLimit.Queue)},
+		{"RATE_LIMIT_MAX_QUEUE", setInt(&c.RateLimit.MaxQueue)},
+		{"USAGE_MONTHLY_TOKEN_QUOTA", setInt(&c.Usage.MonthlyTokenQuota)},
+		{"CALLBACK_SIGNING_SECRET", setString(&c.Callbacks.SigningSecret)},
+		{"CALLBACK_JOB_TTL", setDuration(&c.Callbacks.JobTTL)},
+		{"CALLBACK_MAX_ATTEMPTS", setInt(&c.Callbacks.MaxAttempts)},
+		{"CALLBACK_TIMEOUT", setDuration(&c.Callbacks.Timeout)},
+		{"IDEMPOTENCY_RETENTION", setDuration(&c.Idempotency.Retention)},
+		{"WEATHER_PROVIDER", setString(&c.Weather.Provider)},
+		{"WEATHER_ALWAYS", setBool(&c.Weather.Always)},
+		{"WEATHER_TIMEOUT", setDuration(&c.Weather.Timeout)},
+		{"WEATHER_GEOCODING_URL", setString(&c.Weather.GeocodingURL)},
+		{"WEATHER_FORECAST_URL", setString(&c.Weather.ForecastURL)},
+		{"CURRENCY_BASE", setString(&c.Currency.Base)},
+		{"CURRENCY_PROVIDER", setString(&c.Currency.Provider)},
+		{"CURRENCY_RATES_URL", setString(&c.Currency.RatesURL)},
+		{"CURRENCY_REFRESH", setDuration(&c.Currency.Refresh)},
+		{"PERSONA_BOT_NAME", setString(&c.Persona.BotName)},
+		{"PERSONA_COMPANY", setString(&c.Persona.Company)},
+		{"PERSONA_PROMPT", setString(&c.Persona.Prompt)},
+		{"PERSONA_PROMPT_FILE", setString(&c.Persona.PromptFile)},
+		{"FLAGS_POLL_INTERVAL", setDuration(&c.Flags.PollInterval)},
+		{"RETENTION_CONVERSATION_DAYS", setInt(&c.Retention.ConversationDays)},
+		{"RETENTION_QUERY_LOG_DAYS", setInt(&c.Retention.QueryLogDays)},
+		{"RETENTION_PREFERENCE_DAYS", setInt(&c.Retention.PreferenceDays)},
+		{"RETENTION_SWEEP_INTERVAL", setDuration(&c.Retention.SweepInterval)},
+		{"CITY_ALIASES_FILE", setString(&c.Cities.AliasesFile)},
+		{"CITY_REFRESH_INTERVAL", setDuration(&c.Cities.RefreshInterval)},
+		{"ABUSE_THRESHOLD", setInt(&c.Abuse.Threshold)},
+		{"ABUSE_WINDOW", setDuration(&c.Abuse.Window)},
+		{"ABUSE_BAN_DURATION", setDuration(&c.Abuse.BanDuration)},
+		{"SHARE_LINK_TTL", setDuration(&c.Sharing.LinkTTL)},
+		{"ADMIN_API_KEYS", setList(&c.Admin.APIKeys)},
+		{"DEVELOPER_API_KEYS", setList(&c.Admin.DeveloperKeys)},
+		{"CORS_ALLOWED_ORIGINS", setList(&c.CORS.AllowedOrigins)},
+		{"CORS_ALLOWED_METHODS", setList(&c.CORS.AllowedMethods)},
+		{"CORS_ALLOWED_HEADERS", setList(&c.CORS.AllowedHeaders)},
+		{"CORS_MAX_AGE", setDuration(&c.CORS.MaxAge)},
+		{"PROMPT_DIR", setString(&c.PromptDir)},
+		{"I18N_DIR", setString(&c.I18nDir)},
+		{"FEATURE_STREAMING", setBool(&c.Features.Streaming)},
+		{"FEATURE_AGGREGATION", setBool(&c.Features.Aggregation)},
+		{"FEATURE_TELEMETRY", setBool(&c.Features.Telemetry)},
+		{"FEATURE_TITLES", setBool(&c.Features.Titles)},
+		{"FEATURE_GROUNDING", setBool(&c.Features.Grounding)},
+		{"FEATURE_ROUTE_PHRASING", setBool(&c.Features.RoutePhrasing)},
+		{"FEATURE_PII_MASKING", setBool(&c.Features.PIIMasking)},
+		{"FEATURE_LANGUAGE_CHECK", setBool(&c.Features.LanguageCheck)},
+		{"SLACK_SIGNING_SECRET", setString(&c.Slack.SigningSecret)},
+		{"SLACK_BOT_TOKEN", setString(&c.Slack.BotToken)},
+		{"SLACK_API_URL", setString(&c.Slack.APIURL)},
+		{"TELEGRAM_BOT_TOKEN", setString(&c.Telegram.BotToken)},
+		{"TELEGRAM_API_URL", setString(&c.Telegram.APIURL)},
+		{"TELEGRAM_POLL_TIMEOUT", setDuration(&c.Telegram.PollTimeout)},
+	}
+	for _, v := range vars {
+		if raw := getenv(v.name); raw != "" {
+			if err := v.set(raw); err != nil {
+				return fmt.Errorf("invalid %s %q: %w", v.name, raw, err)
+			}
+		}
+	}
+	return nil
+}
+
+func setString(dst *string) func(string) error {
+	return func(raw string) error {
+		*dst = raw
+		return nil
+	}
+}
+
+func setDuration(dst *time.Duration) func(string) error {
+	return func(raw string) (err error) {
+		*dst, err = time.ParseDuration(raw)
+		return err
+	}
+}
+
+func setInt(dst *int) func(string) error {
+	return func(raw string) (err error) {
+		*dst, err = strconv.Atoi(raw)
+		return err
+	}
+}
+
+func setFloat(dst *float64) func(string) error {
+	return func(raw string) (err error) {
+		*dst, err = strconv.ParseFloat(raw, 64)
+		return err
+	}
+}
+
+func setBool(dst *bool) func(string) error {
+	return func(raw string) (err error) {
+		*dst, err = strconv.ParseBool(raw)
+		return err
+	}
+}
+
+// setQuota sets provider's tokens per minute in quotas, or with requests its requests per
+// minute, keeping the other.
+func setQuota(quotas *map[string]ProviderQuota, provider string, requests bool) func(string) error {
+	return func(raw string) error {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		if *quotas == nil {
+			*quotas = make(map[string]ProviderQuota)
+		}
+		quota := (*quotas)[provider]
+		if requests {
+			quota.RPM = n
+		} else {
+			quota.TPM = n
+		}
+		(*quotas)[provider] = quota
+		return nil
+	}
+}
+
+// setList splits a comma-separated value, ignoring blanks.
+func setList(dst *[]string) func(string) error {
+	return func(raw string) error {
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*dst = items
+		return nil
+	}
+}
+
+// Validate reports every invalid setting at once, joined into one error.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.MigrateDown >= -1, "-migrate-down %d must be a version, or 0 to revert every migration", c.MigrateDown)
+	check(c.Server.Addr != "", "server.addr must not be empty")
+	check(c.Server.HTTPEnabled || c.Telegram.Enabled(), "server.http_enabled is false and no bot is configured; there is nothing to serve")
+	check(c.Server.OrchestrationTimeout > 0, "server.orchestration_timeout must be positive")
+	check(c.Server.RequestTimeout >= 0, "server.request_timeout must not be negative")
+	check(c.Server.ShutdownGracePeriod >= 0, "server.shutdown_grace_period must not be negative")
+	check(c.Server.MaxConcurrentChats >= 0, "server.max_concurrent_chats must not be negative")
+	check(c.Server.ChatMaxQueue >= 0, "server.chat_max_queue must not be negative")
+	tlsCfg := c.Server.TLS
+	check((tlsCfg.CertFile == "") == (tlsCfg.KeyFile == ""), "server.tls.cert_file and server.tls.key_file must be set together")
+	check(tlsCfg.CertFile == "" || !tlsCfg.Autocert(), "server.tls.cert_file and server.tls.autocert_hosts can't both be set")
+	check(!tlsCfg.Autocert() || tlsCfg.AutocertCacheDir != "", "server.tls.autocert_cache_dir is required with server.tls.autocert_hosts")
+	check(tlsCfg.RedirectAddr == "" || tlsCfg.Enabled(), "server.tls.redirect_addr needs TLS: set server.tls.cert_file or server.tls.autocert_hosts")
+	check(tlsCfg.RedirectAddr == "" || tlsCfg.RedirectAddr != c.Server.Addr, "server.tls.redirect_addr must differ from server.addr")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level %q must be debug, info, warn or error", c.Log.Level)
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format %q must be text or json", c.Log.Format)
+
+	switch c.DB.Backend {
+	case BackendMongo:
+		check(c.DB.MongoURI != "", "db.mongo_uri (MONGO_URI) is required for the mongo backend")
+	case BackendMemory:
+	default:
+		errs = append(errs, fmt.Errorf("db.backend %q must be %q or %q", c.DB.Backend, BackendMongo, BackendMemory))
+	}
+	check(c.DB.ConnectTimeout > 0, "db.connect_timeout must be positive")
+	check(c.DB.SearchCacheTTL >= 0, "db.search_cache_ttl must not be negative")
+	check(c.DB.StaleAfter >= 0, "db.stale_after must not be negative")
+	check(c.DB.MaxStale >= 0, "db.max_stale must not be negative")
+	check(db.ValidReadPreference(c.DB.ReadPreference), "db.read_preference %q must be primary, primaryPreferred, secondary, secondaryPreferred or nearest", c.DB.ReadPreference)
+	check(c.DB.MaxQueryTime >= 0, "db.max_query_time must not be negative")
+
+	needsKey := false
+	for _, slot := range c.LLM.Slots() {
+		needsKey = needsKey || slot.Provider == llmclient.ProviderOpenAI
+		check(llmclient.KnownProvider(slot.Provider), "llm.%s.provider %q is not supported (want one of %v)", slot.Name, slot.Provider, llmclient.Providers)
+		check(slot.Model != "", "llm.%s.model must not be empty", slot.Name)
+	}
+	if len(c.LLM.RouteSlots()) > 0 {
+		needsKey = needsKey || c.LLM.Provider == llmclient.ProviderOpenAI
+		check(llmclient.KnownProvider(c.LLM.Provider), "llm.provider %q, which llm.routing uses, is not supported (want one of %v)", c.LLM.Provider, llmclient.Providers)
+	}
+	check(c.Orchestrator.Mode == ModeFull || c.Orchestrator.Mode == ModeDBOnly, "orchestrator.mode %q must be %q or %q", c.Orchestrator.Mode, ModeFull, ModeDBOnly)
+	check(c.LLM.APIKey != "" || !needsKey || c.Orchestrator.DBOnly(), "llm.api_key (OPENAI_API_KEY) is required")
+	check(c.LLM.MaxRetries >= 0, "llm.max_retries must not be negative")
+	check(c.LLM.MockLatency >= 0, "llm.mock_latency must not be negative")
+	check(c.LLM.RPS >= 0, "llm.rps must not be negative")
+	for _, provider := range slices.Sorted(maps.Keys(c.LLM.Quotas)) {
+		quota := c.LLM.Quotas[provider]
+		check(llmclient.KnownProvider(provider), "llm.quotas has provider %q, which is not supported (want one of %v)", provider, llmclient.Providers)
+		check(quota.TPM >= 0 && quota.RPM >= 0, "llm.quotas.%s must not be negative", provider)
+	}
+	check(c.LLM.Budget.MaxTokens >= 0, "llm.budget.max_tokens must not be negative")
+	check(c.LLM.Budget.MinWorkerTokens >= 1, "llm.budget.min_worker_tokens must be at least 1")
+	check(c.LLM.Budget.MinAggregationTokens >= 1, "llm.budget.min_aggregation_tokens must be at least 1")
+	check(c.LLM.Output.MaxChars >= 0, "llm.output.max_chars must not be negative")
+	check(c.LLM.Output.MaxTokens >= 0, "llm.output.max_tokens must not be negative")
+	check(c.LLM.WorkerProgress >= 0, "llm.worker_progress must not be negative")
+	check(c.LLM.Condense.MaxTokens >= 0, "llm.condense.max_tokens must not be negative")
+	check(!c.LLM.Condense.Summarize || c.LLM.Condense.MaxTokens > 0, "llm.condense.summarize needs llm.condense.max_tokens")
+	if c.LLM.FlightLines.Template != "" {
+		_, err := template.New("flight_line").Parse(c.LLM.FlightLines.Template)
+		check(err == nil, "llm.flight_lines.template: %v", err)
+	}
+	if signing := c.LLM.Signing; signing.Enabled() {
+		check(signing.SignatureHeader != "" && signing.TimestampHeader != "", "llm.signing.signature_header and .timestamp_header are required with llm.signing.key")
+		check(!strings.EqualFold(signing.SignatureHeader, signing.TimestampHeader), "llm.signing.signature_header and .timestamp_header must differ")
+	}
+
+	check(c.SSE.BufferSize >= 0, "sse.buffer_size must not be negative")
+	check(c.SSE.WriteTimeout >= 0, "sse.write_timeout must not be negative")
+	check(c.SSE.RetryInterval >= 0, "sse.retry_interval must not be negative")
+	check(c.SSE.CoalesceWindow >= 0, "sse.coalesce_window must not be negative")
+	check(c.SSE.StreamRetention >= 0, "sse.stream_retention must not be negative")
+
+	check(c.RateLimit.RPS >= 0, "rate_limit.rps must not be negative")
+	check(c.RateLimit.Burst >= 0, "rate_limit.burst must not be negative")
+	check(c.RateLimit.MaxStreams >= 0, "rate_limit.max_streams must not be negative")
+	check(c.RateLimit.MaxQueue >= 0, "rate_limit.max_queue must not be negative")
+	check(c.Usage.MonthlyTokenQuota >= 0, "usage.monthly_token_quota must not be negative")
+	for model, price := range c.Usage.Prices {
+		check(price.Prompt >= 0 && price.Completion >= 0, "usage.prices.%s must not be negative", model)
+	}
+
+	check(c.Callbacks.JobTTL > 0, "callbacks.job_ttl must be positive")
+	check(c.Callbacks.MaxAttempts >= 1, "callbacks.max_attempts must be at least 1")
+	check(c.Callbacks.Timeout > 0, "callbacks.timeout must be positive")
+	check(c.Idempotency.Retention >= 0, "idempotency.retention must not be negative")
+	if c.Weather.Enabled() {
+		check(slices.Contains(weather.Providers, c.Weather.Provider), "weather.provider %q is not supported (want one of %v)", c.Weather.Provider, weather.Providers)
+		check(c.Weather.Timeout > 0, "weather.timeout must be positive")
+		for _, endpoint := range []struct{ name, raw string }{{"geocoding_url", c.Weather.GeocodingURL}, {"forecast_url", c.Weather.ForecastURL}} {
+			if endpoint.raw != "" {
+				u, err := url.Parse(endpoint.raw)
+				check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "weather.%s %q must be an http(s) URL", endpoint.name, endpoint.raw)
+			}
+		}
+	}
+	check(slices.Contains(currency.Providers, c.Currency.Provider), "currency.provider %q is not supported (want one of %v)", c.Currency.Provider, currency.Providers)
+	if c.Currency.Provider == currency.ProviderStatic {
+		_, known := currency.DefaultRates[c.Currency.Base]
+		check(known, "currency.base %q has no static rate (known: %v)", c.Currency.Base, slices.Sorted(maps.Keys(currency.DefaultRates)))
+	} else {
+		check(len(c.Currency.Base) == 3 && strings.ToUpper(c.Currency.Base) == c.Currency.Base, "currency.base %q must be an upper-case ISO 4217 code", c.Currency.Base)
+	}
+	if c.Currency.Provider == currency.ProviderFrankfurter {
+		check(c.Currency.Refresh > 0, "currency.refresh must be positive")
+		if c.Currency.RatesURL != "" {
+			u, err := url.Parse(c.Currency.RatesURL)
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "currency.rates_url %q must be an http(s) URL", c.Currency.RatesURL)
+		}
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if err := httpmw.ValidOrigin(origin); err != nil {
+			errs = append(errs, fmt.Errorf("cors.allowed_origins: %w", err))
+		}
+	}
+	check(len(c.CORS.AllowedMethods) > 0, "cors.allowed_methods must not be empty")
+	check(c.CORS.MaxAge >= 0, "cors.max_age must not be negative")
+
+	if c.Slack.SigningSecret != "" || c.Slack.BotToken != "" {
+		check(c.Slack.SigningSecret != "", "slack.signing_secret (SLACK_SIGNING_SECRET) is required when slack.bot_token is set")
+		check(c.Slack.BotToken != "", "slack.bot_token (SLACK_BOT_TOKEN) is required when slack.signing_secret is set")
+		u, err := url.Parse(c.Slack.APIURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "slack.api_url %q must be an http(s) URL", c.Slack.APIURL)
+	}
+	if c.Telegram.Enabled() {
+		u, err := url.Parse(c.Telegram.APIURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "telegram.api_url %q must be an http(s) URL", c.Telegram.APIURL)
+		check(c.Telegram.PollTimeout > 0, "telegram.poll_timeout must be positive")
+	}
+
+	if c.Persona.Enabled() {
+		// Rendering the prompts checks the file and the templates.
+		_, err := persona.New(c.Persona.Settings())
+		check(err == nil, "persona: %v", err)
+	}
+	_, err := flags.New(c.Flags.Settings())
+	check(err == nil, "flags.rules: %v", err)
+	check(c.Flags.PollInterval >= 0, "flags.poll_interval must not be negative")
+	check(c.Retention.ConversationDays >= 0, "retention.conversation_days must not be negative")
+	check(c.Retention.QueryLogDays >= 0, "retention.query_log_days must not be negative")
+	check(c.Retention.PreferenceDays >= 0, "retention.preference_days must not be negative")
+	check(!c.Retention.Enabled() || c.Retention.SweepInterval > 0, "retention.sweep_interval must be positive when records expire")
+	check(c.Cities.RefreshInterval >= 0, "cities.refresh_interval must not be negative")
+	if c.Cities.AliasesFile != "" {
+		info, err := os.Stat(c.Cities.AliasesFile)
+		check(err == nil && !info.IsDir(), "cities.aliases_file %q is not a readable file", c.Cities.AliasesFile)
+	}
+	_, err = timezone.New(c.Cities.TimeZones)
+	check(err == nil, "cities.time_zones: %v", err)
+	check(c.Abuse.Threshold >= 0, "abuse.threshold must not be negative")
+	if c.Abuse.Enabled() {
+		check(c.Abuse.Window > 0, "abuse.window must be positive")
+		check(c.Abuse.BanDuration > 0, "abuse.ban_duration must be positive")
+	}
+	check(c.Sharing.LinkTTL >= 0, "sharing.link_ttl must not be negative")
+	if c.PromptDir != "" {
+		info, err := os.Stat(c.PromptDir)
+		check(err == nil && info.IsDir(), "prompt_dir %q is not a readable directory", c.PromptDir)
+	}
+	if c.I18nDir != "" {
+		info, err := os.Stat(c.I18nDir)
+		check(err == nil && info.IsDir(), "i18n_dir %q is not a readable directory", c.I18nDir)
+	}
+	return errors.Join(errs...)
+}
+
+// LogValue renders the configuration for the startup log with secrets redacted:
+// API keys are never printed and the Mongo URI loses its password.
+func (c *Config) LogValue() slog.Value {
+	slot := func(s Slot) slog.Value {
+		return slog.StringValue(s.Provider + "/" + s.Model)
+	}
+	return slog.GroupValue(
+		slog.Group("server",
+			"http_enabled", c.Server.HTTPEnabled,
+			"addr", c.Server.Addr,
+			"orchestration_timeout", c.Server.OrchestrationTimeout,
+			"request_timeout", c.Server.RequestTimeout,
+			"shutdown_grace_period", c.Server.ShutdownGracePeriod,
+			"max_concurrent_chats", c.Server.MaxConcurrentChats,
+			"chat_queue", c.Server.ChatQueue,
+			"chat_max_queue", c.Server.ChatMaxQueue,
+			"tls", c.Server.TLS.Enabled(),
+			"tls_autocert_hosts", c.Server.TLS.AutocertHosts,
+			"tls_redirect_addr", c.Server.TLS.RedirectAddr,
+			"replica_id", c.Server.ReplicaID),
+		slog.Group("log", "level", c.Log.Level, "format", c.Log.Format),
+		slog.Group("db",
+			"backend", c.DB.Backend,
+			"mongo_uri", redactURI(c.DB.MongoURI),
+			"connect_timeout", c.DB.ConnectTimeout,
+			"search_cache_ttl", c.DB.SearchCacheTTL,
+			"stale_after", c.DB.StaleAfter,
+			"max_stale", c.DB.MaxStale,
+			"read_preference", c.DB.ReadPreference,
+			"max_query_time", c.DB.MaxQueryTime,
+			"query_log", c.DB.QueryLog,
+			"query_log_prompts", c.DB.QueryLogPrompts),
+		slog.Group("llm",
+			"api_key", redact(c.LLM.APIKey),
+			"max_retries", c.LLM.MaxRetries,
+			"rps", c.LLM.RPS,
+			"quotas", c.LLM.Quotas,
+			"mock_latency", c.LLM.MockLatency,
+			slog.Attr{Key: "llm1", Value: slot(c.LLM.LLM1)},
+			slog.Attr{Key: "llm2", Value: slot(c.LLM.LLM2)},
+			slog.Attr{Key: "llm3", Value: slot(c.LLM.LLM3)},
+			slog.Group("budget",
+				"max_tokens", c.LLM.Budget.MaxTokens,
+				"min_worker_tokens", c.LLM.Budget.MinWorkerTokens,
+				"min_aggregation_tokens", c.LLM.Budget.MinAggregationTokens),
+			slog.Group("output",
+				"max_chars", c.LLM.Output.MaxChars,
+				"max_tokens", c.LLM.Output.MaxTokens),
+			slog.Group("routing",
+				"flight", c.LLM.Routing.Flight,
+				"general", c.LLM.Routing.General,
+				"aggregation", c.LLM.Routing.Aggregation,
+				"condensation", c.LLM.Routing.Condensation),
+			"worker_progress", c.LLM.WorkerProgress,
+			slog.Group("signing",
+				"key", redact(c.LLM.Signing.Key),
+				"signature_header", c.LLM.Signing.SignatureHeader,
+				"timestamp_header", c.LLM.Signing.TimestampHeader),
+			slog.Group("condense",
+				"max_tokens", c.LLM.Condense.MaxTokens,
+				"summarize", c.LLM.Condense.Summarize),
+			slog.Group("flight_lines",
+				"enabled", c.LLM.FlightLines.Enabled,
+				"template", c.LLM.FlightLines.Template)),
+		slog.Group("orchestrator", "mode", c.Orchestrator.Mode),
+		slog.Group("sse",
+			"buffer_size", c.SSE.BufferSize,
+			"write_timeout", c.SSE.WriteTimeout,
+			"retry_interval", c.SSE.RetryInterval,
+			"coalesce_window", c.SSE.CoalesceWindow,
+			"stream_retention", c.SSE.StreamRetention,
+			"shared", c.SSE.Shared),
+		slog.Group("rate_limit",
+			"rps", c.RateLimit.RPS,
+			"burst", c.RateLimit.Burst,
+			"max_streams", c.RateLimit.MaxStreams,
+			"queue", c.RateLimit.Queue,
+			"max_queue", c.RateLimit.MaxQueue),
+		slog.Group("usage",
+			"monthly_token_quota", c.Usage.MonthlyTokenQuota,
+			"prices", len(c.Usage.Prices)),
+		slog.Group("callbacks",
+			"signing_secret", redact(c.Callbacks.SigningSecret),
+			"job_ttl", c.Callbacks.JobTTL,
+			"max_attempts", c.Callbacks.MaxAttempts,
+			"timeout", c.Callbacks.Timeout),
+		slog.Group("idempotency", "retention", c.Idempotency.Retention),
+		slog.Group("weather",
+			"provider", c.Weather.Provider,
+			"always", c.Weather.Always,
+			"timeout", c.Weather.Timeout),
+		slog.Group("persona",
+			"bot_name", c.Persona.BotName,
+			"company", c.Persona.Company,
+			"prompt_chars", len(c.Persona.Prompt),
+			"prompts", len(c.Persona.Prompts),
+			"prompt_file", c.Persona.PromptFile),
+		slog.Group("flags",
+			"rules", len(c.Flags.Rules),
+			"poll_interval", c.Flags.PollInterval),
+		slog.Group("retention",
+			"conversation_days", c.Retention.ConversationDays,
+			"query_log_days", c.Retention.QueryLogDays,
+			"preference_days", c.Retention.PreferenceDays,
+			"sweep_interval", c.Retention.SweepInterval),
+		slog.Group("cities",
+			"aliases_file", c.Cities.AliasesFile,
+			"refresh_interval", c.Cities.RefreshInterval,
+			"time_zones", len(c.Cities.TimeZones)),
+		slog.Group("abuse",
+			"threshold", c.Abuse.Threshold,
+			"window", c.Abuse.Window,
+			"ban_duration", c.Abuse.BanDuration),
+		slog.Group("sharing", "link_ttl", c.Sharing.LinkTTL),
+		slog.Group("currency",
+			"base", c.Currency.Base,
+			"provider", c.Currency.Provider,
+			"refresh", c.Currency.Refresh),
+		slog.Group("admin", "api_keys", len(c.Admin.APIKeys), "developer_keys", len(c.Admin.DeveloperKeys)),
+		slog.Group("cors",
+			"allowed_origins", c.CORS.AllowedOrigins,
+			"allowed_methods", c.CORS.AllowedMethods,
+			"allowed_headers", c.CORS.AllowedHeaders,
+			"max_age", c.CORS.MaxAge),
+		slog.Group("features",
+			"streaming", c.Features.Streaming,
+			"aggregation", c.Features.Aggregation,
+			"telemetry", c.Features.Telemetry,
+			"titles", c.Features.Titles,
+			"grounding", c.Features.Grounding,
+			"pii_masking", c.Features.PIIMasking,
+			"language_check", c.Features.LanguageCheck,
+			"route_phrasing", c.Features.RoutePhrasing),
+		slog.Group("slack",
+			"signing_secret", redact(c.Slack.SigningSecret),
+			"bot_token", redact(c.Slack.BotToken),
+			"api_url", c.Slack.APIURL),
+		slog.Group("telegram",
+			"bot_token", redact(c.Telegram.BotToken),
+			"api_url", c.Telegram.APIURL,
+			"poll_timeout", c.Telegram.PollTimeout),
+		slog.String("prompt_dir", c.PromptDir),
+		slog.String("i18n_dir", c.I18nDir),
+	)
+}
+
+// redact hides a secret, showing only whether it is set.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+// redactURI masks the password in a connection URI.
+func redactURI(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redact(raw) // Unparseable; don't risk printing credentials.
+	}
+	return u.Redacted()
+}
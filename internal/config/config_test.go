@@ -0,0 +1,223 @@
+package config
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a getenv over vars ("NAME=value"), plus the settings every test needs to pass
+// validation without a database or an API key.
+func env(vars ...string) func(string) string {
+	m := map[string]string{"DB_BACKEND": BackendMemory, "LLM_PROVIDER": "mock"}
+	for _, v := range vars {
+		name, value, _ := strings.Cut(v, "=")
+		m[name] = value
+	}
+	return func(name string) string { return m[name] }
+}
+
+// writeFile writes a config file for the test and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(nil, env())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Addr != ":8080" || cfg.Log.Level != "info" || cfg.Server.OrchestrationTimeout != 2*time.Minute || !cfg.Features.Aggregation {
+		t.Errorf("defaults %+v", cfg)
+	}
+	// The slots take the shared provider and model unless they set their own.
+	for _, slot := range cfg.LLM.Slots() {
+		if slot.Provider != "mock" || slot.Model != "gpt-4o-mini" {
+			t.Errorf("%s = %+v, want the shared provider and model", slot.Name, slot.Slot)
+		}
+	}
+	cfg, err = Load(nil, env("LLM_MODEL=shared", "LLM3_MODEL=big"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LLM.LLM1.Model != "shared" || cfg.LLM.LLM3.Model != "big" {
+		t.Errorf("slots %+v %+v", cfg.LLM.LLM1, cfg.LLM.LLM3)
+	}
+	// The mongo client's reads go to secondaries when there are any, and are bounded.
+	if got := cfg.DB.Mongo(); got.ReadPreference != "secondaryPreferred" || got.MaxQueryTime != 10*time.Second {
+		t.Errorf("mongo client settings %+v", got)
+	}
+	cfg, err = Load(nil, env("MONGO_READ_PREFERENCE=primary", "MONGO_MAX_QUERY_TIME=0"))
+	if err != nil || cfg.DB.Mongo().ReadPreference != "primary" || cfg.DB.Mongo().MaxQueryTime != 0 {
+		t.Errorf("mongo client settings from the environment %+v, %v", cfg.DB, err)
+	}
+	// Flight lines are left as the model writes them unless enabled.
+	if cfg.LLM.FlightLines.Enabled {
+		t.Errorf("flight lines enabled by default")
+	}
+	cfg, err = Load(nil, env("LLM_FLIGHT_LINES=true", "LLM_FLIGHT_LINE_TEMPLATE={{.Number}}: {{.Price}}"))
+	if err != nil || !cfg.LLM.FlightLines.Enabled || cfg.LLM.FlightLines.Template != "{{.Number}}: {{.Price}}" {
+		t.Errorf("flight lines from the environment %+v, %v", cfg.LLM.FlightLines, err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", "server:\n  addr: \":1000\"\nlog:\n  level: debug\nsse:\n  buffer_size: 7\n")
+	jsonFile := writeFile(t, "config.json", `{"server": {"addr": ":1000"}, "log": {"level": "debug"}, "sse": {"buffer_size": 7}}`)
+
+	for _, tt := range []struct {
+		name     string
+		args     []string
+		getenv   func(string) string
+		wantAddr string
+	}{
+		{"default", nil, env(), ":8080"},
+		{"file over default", []string{"-config", yamlFile}, env(), ":1000"},
+		{"JSON file", []string{"-config", jsonFile}, env(), ":1000"},
+		{"file named by CONFIG_FILE", nil, env("CONFIG_FILE=" + yamlFile), ":1000"},
+		{"env over file", []string{"-config", yamlFile}, env("HTTP_ADDR=:2000"), ":2000"},
+		{"flag over env", []string{"-config", yamlFile, "-addr", ":3000"}, env("HTTP_ADDR=:2000"), ":3000"},
+	} {
+		cfg, err := Load(tt.args, tt.getenv)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if cfg.Server.Addr != tt.wantAddr {
+			t.Errorf("%s: addr %q, want %q", tt.name, cfg.Server.Addr, tt.wantAddr)
+		}
+	}
+
+	// Settings a layer leaves out keep the lower layer's.
+	cfg, err := Load([]string{"-config", yamlFile, "-log-format", "json"}, env("LOG_LEVEL=warn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Log.Level != "warn" || cfg.Log.Format != "json" || cfg.SSE.BufferSize != 7 || cfg.Server.RequestTimeout != 30*time.Second {
+		t.Errorf("merged %+v %+v, buffer %d", cfg.Log, cfg.Server, cfg.SSE.BufferSize)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		args   []string
+		getenv func(string) string
+		want   string
+	}{
+		{"missing file", []string{"-config", filepath.Join(t.TempDir(), "none.yaml")}, env(), "open config file"},
+		{"unknown key", []string{"-config", writeFile(t, "typo.yaml", "server:\n  adr: \":1\"\n")}, env(), "field adr not found"},
+		{"bad duration", nil, env("REQUEST_TIMEOUT=soon"), "REQUEST_TIMEOUT"},
+		{"bad number", nil, env("RATE_LIMIT_BURST=lots"), "RATE_LIMIT_BURST"},
+	} {
+		if _, err := Load(tt.args, tt.getenv); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want one mentioning %q", tt.name, err, tt.want)
+		}
+	}
+
+	// An empty file changes nothing.
+	if _, err := Load([]string{"-config", writeFile(t, "empty.yaml", "")}, env()); err != nil {
+		t.Errorf("empty file: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, err := Load([]string{"-log-level", "loud"}, env(
+		"DB_BACKEND=mongo", "LLM_PROVIDER=openai", "ORCHESTRATION_TIMEOUT=0s", "LLM2_PROVIDER=carrier-pigeon", "TLS_CERT_FILE=cert.pem",
+		"MONGO_READ_PREFERENCE=replica", "MONGO_MAX_QUERY_TIME=-1s", "LLM_FLIGHT_LINE_TEMPLATE={{.Number"))
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	// Every problem is reported at once.
+	for _, want := range []string{
+		`log.level "loud"`,
+		"db.mongo_uri (MONGO_URI) is required",
+		"llm.api_key (OPENAI_API_KEY) is required",
+		"server.orchestration_timeout must be positive",
+		`llm.llm2.provider "carrier-pigeon" is not supported`,
+		"server.tls.cert_file and server.tls.key_file must be set together",
+		`db.read_preference "replica"`,
+		"db.max_query_time must not be negative",
+		"llm.flight_lines.template: template: flight_line",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("errors %q don't mention %q", err, want)
+		}
+	}
+
+	// In db-only mode no LLM is called, so the API key isn't needed.
+	if _, err := Load(nil, env("LLM_PROVIDER=openai", "ORCH_MODE=db-only")); err != nil {
+		t.Errorf("db-only without an API key: %v", err)
+	}
+	if _, err := Load(nil, env("ORCH_MODE=llm-free")); err == nil || !strings.Contains(err.Error(), `orchestrator.mode "llm-free"`) {
+		t.Errorf("unknown mode: %v", err)
+	}
+	if _, err := Load([]string{"-migrate-down", "-2"}, env()); err == nil || !strings.Contains(err.Error(), "-migrate-down -2 must be a version") {
+		t.Errorf("-migrate-down -2: %v", err)
+	}
+	if cfg, err := Load([]string{"-migrate-dry-run", "-migrate-down", "0"}, env()); err != nil || !cfg.MigrateDryRun || cfg.MigrateDown != 0 {
+		t.Errorf("-migrate-dry-run -migrate-down 0: %+v, %v", cfg, err)
+	}
+}
+
+func TestLogValueRedactsSecrets(t *testing.T) {
+	cfg, err := Load(nil, env("DB_BACKEND=mongo", "MONGO_URI=mongodb://app:hunter2@db:27017/chat",
+		"LLM_PROVIDER=openai", "OPENAI_API_KEY=sk-secret", "SLACK_SIGNING_SECRET=slack-secret", "SLACK_BOT_TOKEN=xoxb-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("Configuration", "config", cfg)
+	out := buf.String()
+	for _, secret := range []string{"hunter2", "sk-secret", "slack-secret", "xoxb-secret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("summary shows %q:\n%s", secret, out)
+		}
+	}
+	for _, want := range []string{"config.llm.api_key=[redacted]", "app:xxxxx@db:27017", "config.server.addr=:8080", "config.llm.llm1=openai/gpt-4o-mini"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("summary lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestModelRouting(t *testing.T) {
+	cfg, err := Load(nil, env())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slots := cfg.LLM.RouteSlots(); len(slots) != 0 {
+		t.Errorf("routes without routing: %+v", slots)
+	}
+
+	// Routes run on the shared provider, from the file or the environment.
+	file := writeFile(t, "config.yaml", "llm:\n  routing:\n    general: gpt-4o\n")
+	cfg, err = Load([]string{"-config", file}, env("MODEL_FOR_FLIGHT=gpt-4o-mini", "MODEL_FOR_AGGREGATION=gpt-4o"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []NamedSlot{
+		{"flight", Slot{Provider: "mock", Model: "gpt-4o-mini"}},
+		{"general", Slot{Provider: "mock", Model: "gpt-4o"}},
+		{"aggregation", Slot{Provider: "mock", Model: "gpt-4o"}},
+	}
+	if got := cfg.LLM.RouteSlots(); !slices.Equal(got, want) {
+		t.Errorf("RouteSlots() = %+v, want %+v", got, want)
+	}
+
+	// A route on OpenAI needs the key even if every slot is on another provider.
+	_, err = Load(nil, env("LLM_PROVIDER=openai", "LLM1_PROVIDER=mock", "LLM2_PROVIDER=mock", "LLM3_PROVIDER=mock", "MODEL_FOR_GENERAL=gpt-4o"))
+	if err == nil || !strings.Contains(err.Error(), "llm.api_key (OPENAI_API_KEY) is required") {
+		t.Errorf("OpenAI route without a key: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+// Package currency recognizes the currency a question is asked in, converts amounts between
+// currencies and formats prices the way English and Spanish readers expect.
+//
+// Flight prices are stored in one base currency (US dollars unless configured otherwise).
+// Converter turns a price limit given in another currency into the base currency, and a stored
+// price into the currency the user asked in, using the exchange rates of a RateProvider.
+package currency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Currency codes (ISO 4217) with symbols or names this package recognizes in questions.
+const (
+	USD = "USD"
+	EUR = "EUR"
+	GBP = "GBP"
+	JPY = "JPY"
+	CHF = "CHF"
+	CAD = "CAD"
+	AUD = "AUD"
+	MXN = "MXN"
+)
+
+// ErrUnknownCurrency is returned for a currency the rate provider has no rate for.
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// RateProvider supplies exchange rates.
+type RateProvider interface {
+	// Rate returns how many units of to one unit of from buys. It returns an error wrapping
+	// ErrUnknownCurrency if either currency has no rate.
+	Rate(ctx context.Context, from, to string) (float64, error)
+}
+
+// Converter converts amounts between a base currency, the one prices are stored in, and
+// others.
+type Converter struct {
+	base  string
+	rates RateProvider
+}
+
+// NewConverter returns a converter for prices stored in base, using rates.
+func NewConverter(base string, rates RateProvider) *Converter {
+	return &Converter{base: strings.ToUpper(base), rates: rates}
+}
+
+// Base returns the currency prices are stored in.
+func (c *Converter) Base() string {
+	return c.base
+}
+
+// Convert converts amount from one currency to another. The result is not rounded: a price
+// limit keeps its exact value, so flights right at the limit are treated alike in every
+// currency. Round what is shown to people with Round or Format.
+func (c *Converter) Convert(ctx context.Context, amount float64, from, to string) (float64, error) {
+	if from == to {
+		return amount, nil
+	}
+	rate, err := c.rates.Rate(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return amount * rate, nil
+}
+
+// minorUnits are the decimals of currencies that don't use two.
+var minorUnits = map[string]int{JPY: 0, "KRW": 0, "CLP": 0, "ISK": 0, "HUF": 0}
+
+// decimals returns how many decimals amounts in code are shown with.
+func decimals(code string) int {
+	if d, ok := minorUnits[code]; ok {
+		return d
+	}
+	return 2
+}
+
+// Round rounds amount to the smallest unit of code (cents, or whole yen), with halves rounded
+// away from zero.
+func Round(amount float64, code string) float64 {
+	scale := math.Pow10(decimals(code))
+	return math.Round(amount*scale) / scale
+}
+
+// symbols are the signs prices are written with; other currencies are written with their code.
+var symbols = map[string]string{USD: "$", EUR: "€", GBP: "£", JPY: "¥"}
+
+// Format renders amount in code for readers of language ("en" or "es"), rounded with Round.
+// English puts the symbol first and groups thousands with commas ("£1,234.50", "CHF 80.00");
+// Spanish puts it last, after a space, and swaps the separators ("1.234,50 £", "80,00 CHF").
+func Format(amount float64, code, language string) string {
+	digits := decimals(code)
+	number := fmt.Sprintf("%.*f", digits, math.Abs(Round(amount, code)))
+	whole, fraction, _ := strings.Cut(number, ".")
+	thousands, point := ",", "."
+	if language == "es" {
+		thousands, point = ".", ","
+	}
+	var b strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(thousands)
+		}
+		b.WriteRune(digit)
+	}
+	if digits > 0 {
+		b.WriteString(point + fraction)
+	}
+
+	sign := ""
+	if Round(amount, code) < 0 {
+		sign = "-"
+	}
+	symbol, ok := symbols[code]
+	switch {
+	case language == "es":
+		if !ok {
+			symbol = code
+		}
+		return sign + b.String() + " " + symbol
+	case ok:
+		return sign + symbol + b.String()
+	default:
+		return sign + code + " " + b.String()
+	}
+}
+
+// currencyWords map the currency names and symbols people write to their codes. Dollar signs
+// and "dollars" are taken as US dollars.
+var currencyWords = []struct {
+	pattern *regexp.Regexp
+	code    string
+}{
+	{regexp.MustCompile(`£|(?i)(?:^|\PL)(pounds?|sterling|libras?( esterlinas?)?|gbp)(?:\PL|$)`), GBP},
+	{regexp.MustCompile(`€|(?i)(?:^|\PL)(euros?|eur)(?:\PL|$)`), EUR},
+	{regexp.MustCompile(`¥|(?i)(?:^|\PL)(yen|yenes|jpy)(?:\PL|$)`), JPY},
+	{regexp.MustCompile(`(?i)(?:^|\PL)(swiss francs?|francos? suizos?|chf)(?:\PL|$)`), CHF},
+	{regexp.MustCompile(`(?i)(?:^|\PL)(canadian dollars?|dólares? canadienses?|cad)(?:\PL|$)`), CAD},
+	{regexp.MustCompile(`(?i)(?:^|\PL)(australian dollars?|dólares? australianos?|aud)(?:\PL|$)`), AUD},
+	{regexp.MustCompile(`(?i)(?:^|\PL)(mexican pesos?|pesos? mexicanos?|mxn)(?:\PL|$)`), MXN},
+	{regexp.MustCompile(`\$|(?i)(?:^|\PL)(dollars?|d[oó]lar(es)?|bucks|usd)(?:\PL|$)`), USD},
+}
+
+// isoAmount matches an amount followed or preceded by an upper-case currency code, such as
+// "500 INR", for currencies without a name above. Lower-case words next to numbers ("300 for")
+// are too often not currencies to count.
+var isoAmount = regexp.MustCompile(`\d\s*([A-Z]{3})(?:\PL|$)|(?:^|\PL)([A-Z]{3})\s*\d`)
+
+// Detect returns the currency message mentions, and false if it mentions none. The code may
+// be one the rate provider doesn't know (e.g. "INR" from "under 500 INR"); Convert then fails
+// with ErrUnknownCurrency. Names are checked before symbols, so "100 canadian dollars" is CAD
+// rather than USD.
+func Detect(message string) (string, bool) {
+	for _, w := range currencyWords {
+		if w.pattern.MatchString(message) {
+			return w.code, true
+		}
+	}
+	if m := isoAmount.FindStringSubmatch(message); m != nil {
+		if m[1] != "" {
+			return m[1], true
+		}
+		return m[2], true
+	}
+	return "", false
+}
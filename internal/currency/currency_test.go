@@ -0,0 +1,104 @@
+package currency
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	for message, want := range map[string]string{
+		"flights under 100 pounds":                 GBP,
+		"vuelos por menos de 80 libras esterlinas": GBP,
+		"under £99.50":                             GBP,
+		"menos de 200 euros":                       EUR,
+		"below 150€":                               EUR,
+		"under $300":                               USD,
+		"menos de 300 dólares":                     USD,
+		"under 100 canadian dollars":               CAD,
+		"under 20000 yen":                          JPY,
+		"under 500 INR":                            "INR",
+	} {
+		if got, ok := Detect(message); !ok || got != want {
+			t.Errorf("Detect(%q) = %q, %v, want %q", message, got, ok, want)
+		}
+	}
+	for _, message := range []string{"flights from Madrid to Paris", "under 300 for two", "Europe in august"} {
+		if got, ok := Detect(message); ok {
+			t.Errorf("Detect(%q) = %q, want none", message, got)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	c := NewConverter("usd", DefaultRates)
+	ctx := context.Background()
+	for _, tt := range []struct {
+		amount   float64
+		from, to string
+		want     float64
+	}{
+		{100, GBP, USD, 100 / 0.79},
+		{200, EUR, USD, 200 / 0.92},
+		{120, USD, GBP, 94.8},
+		{120, USD, EUR, 110.4},
+		{100, GBP, EUR, 100 * 0.92 / 0.79},
+		{120, USD, USD, 120},
+	} {
+		got, err := c.Convert(ctx, tt.amount, tt.from, tt.to)
+		if err != nil || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Convert(%v %s to %s) = %v, %v, want %v", tt.amount, tt.from, tt.to, got, err, tt.want)
+		}
+	}
+	if c.Base() != USD {
+		t.Errorf("base %q, want it upper-cased", c.Base())
+	}
+	if _, err := c.Convert(ctx, 500, "INR", USD); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("unknown currency: %v", err)
+	}
+}
+
+func TestRound(t *testing.T) {
+	for _, tt := range []struct {
+		amount float64
+		code   string
+		want   float64
+	}{
+		{94.805, GBP, 94.81},
+		{94.804, GBP, 94.8},
+		{-0.125, EUR, -0.13},
+		{18000.5, JPY, 18001},
+		{17999.4, JPY, 17999},
+	} {
+		if got := Round(tt.amount, tt.code); got != tt.want {
+			t.Errorf("Round(%v, %s) = %v, want %v", tt.amount, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, tt := range []struct {
+		amount         float64
+		code, language string
+		want           string
+	}{
+		{94.8, GBP, "en", "£94.80"},
+		{94.8, GBP, "es", "94,80 £"},
+		{1234.5, EUR, "en", "€1,234.50"},
+		{1234.5, EUR, "es", "1.234,50 €"},
+		{120, USD, "en", "$120.00"},
+		{120, USD, "es", "120,00 $"},
+		{1234567.891, USD, "en", "$1,234,567.89"},
+		{18000, JPY, "en", "¥18,000"},
+		{18000, JPY, "es", "18.000 ¥"},
+		{80, CHF, "en", "CHF 80.00"},
+		{80, CHF, "es", "80,00 CHF"},
+		{-5.5, USD, "en", "-$5.50"},
+		{-0.001, USD, "en", "$0.00"},
+	} {
+		if got := Format(tt.amount, tt.code, tt.language); got != tt.want {
+			t.Errorf("Format(%v, %s, %s) = %q, want %q", tt.amount, tt.code, tt.language, got, tt.want)
+		}
+	}
+}
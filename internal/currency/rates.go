@@ -0,0 +1,176 @@
+package currency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate providers accepted by NewRateProvider.
+const (
+	ProviderStatic      = "static"
+	ProviderFrankfurter = "frankfurter"
+)
+
+// Providers lists the rate providers NewRateProvider can construct, for validation and error
+// messages.
+var Providers = []string{ProviderStatic, ProviderFrankfurter}
+
+// StaticRates are fixed exchange rates, as units of each currency one US dollar buys. They are
+// the default, needing no network, and are close enough to filter and show prices; they are
+// not meant to quote what a card will be charged. Rates between two other currencies go
+// through the dollar.
+type StaticRates map[string]float64
+
+// DefaultRates are the rates used when none are configured.
+var DefaultRates = StaticRates{
+	USD: 1,
+	EUR: 0.92,
+	GBP: 0.79,
+	JPY: 150,
+	CHF: 0.88,
+	CAD: 1.37,
+	AUD: 1.52,
+	MXN: 18,
+}
+
+// Rate returns how many units of to one unit of from buys.
+func (r StaticRates) Rate(_ context.Context, from, to string) (float64, error) {
+	fromRate, ok := r[from]
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnknownCurrency, from)
+	}
+	toRate, ok := r[to]
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnknownCurrency, to)
+	}
+	return toRate / fromRate, nil
+}
+
+// refetchDelay is how long Frankfurter waits after a failed fetch before trying again, so an
+// outage costs one failed request a minute rather than one per question.
+const refetchDelay = time.Minute
+
+// DefaultFrankfurterURL is the public Frankfurter API, which publishes the European Central
+// Bank's reference rates and needs no API key.
+const DefaultFrankfurterURL = "https://api.frankfurter.app"
+
+// Frankfurter fetches rates from a Frankfurter API and keeps them for refresh. When a fetch
+// fails it answers from the last rates it fetched, or from a fallback table before the first
+// fetch succeeds, and tries again a minute later; a rates outage degrades prices rather than
+// failing questions.
+type Frankfurter struct {
+	baseURL    string
+	refresh    time.Duration
+	fallback   StaticRates
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	rates     StaticRates // Per US dollar; nil until the first successful fetch
+	fetchedAt time.Time
+	failedAt  time.Time // Last failed fetch
+}
+
+// NewFrankfurter returns a provider using the API at baseURL (DefaultFrankfurterURL if empty),
+// refetching rates once they are older than refresh and falling back to fallback.
+func NewFrankfurter(baseURL string, refresh time.Duration, fallback StaticRates) *Frankfurter {
+	if baseURL == "" {
+		baseURL = DefaultFrankfurterURL
+	}
+	return &Frankfurter{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		refresh:    refresh,
+		fallback:   fallback,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Rate returns how many units of to one unit of from buys.
+func (f *Frankfurter) Rate(ctx context.Context, from, to string) (float64, error) {
+	return f.current(ctx).Rate(ctx, from, to)
+}
+
+// current returns the rates to answer from, fetching them when they are missing or stale.
+// The lock is held during the fetch so concurrent questions wait for one fetch instead of each
+// starting their own.
+func (f *Frankfurter) current(ctx context.Context) StaticRates {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fresh := f.rates != nil && time.Since(f.fetchedAt) < f.refresh
+	if !fresh && time.Since(f.failedAt) >= refetchDelay {
+		rates, err := f.fetch(ctx)
+		if err == nil {
+			f.rates, f.fetchedAt = rates, time.Now()
+			return rates
+		}
+		f.failedAt = time.Now()
+		slog.WarnContext(ctx, "Fetching exchange rates failed; using older rates", "error", err, "fetched", f.rates != nil)
+	}
+	if f.rates == nil {
+		return f.fallback
+	}
+	return f.rates
+}
+
+// fetch gets the latest rates per US dollar.
+func (f *Frankfurter) fetch(ctx context.Context) (StaticRates, error) {
+	endpoint := f.baseURL + "/latest?" + url.Values{"from": {USD}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("frankfurter: status %d", resp.StatusCode)
+	}
+	var latest struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.Unmarshal(body, &latest); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if len(latest.Rates) == 0 {
+		return nil, fmt.Errorf("frankfurter: no rates")
+	}
+	rates := StaticRates{USD: 1}
+	for code, rate := range latest.Rates {
+		if rate > 0 {
+			rates[code] = rate
+		}
+	}
+	return rates, nil
+}
+
+// Config describes the rate provider to construct.
+type Config struct {
+	Provider string
+	RatesURL string        // Frankfurter only; the public API if empty
+	Refresh  time.Duration // Frankfurter only; how long fetched rates are used
+}
+
+// NewRateProvider constructs the provider named by cfg.Provider.
+func NewRateProvider(cfg Config) (RateProvider, error) {
+	switch cfg.Provider {
+	case ProviderStatic, "":
+		return DefaultRates, nil
+	case ProviderFrankfurter:
+		return NewFrankfurter(cfg.RatesURL, cfg.Refresh, DefaultRates), nil
+	default:
+		return nil, fmt.Errorf("unknown currency rate provider %q (supported: %v)", cfg.Provider, Providers)
+	}
+}
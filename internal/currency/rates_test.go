@@ -0,0 +1,83 @@
+package currency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStaticRates(t *testing.T) {
+	ctx := context.Background()
+	if rate, err := DefaultRates.Rate(ctx, GBP, EUR); err != nil || rate != 0.92/0.79 {
+		t.Errorf("GBP to EUR = %v, %v", rate, err)
+	}
+	for _, pair := range [][2]string{{"INR", USD}, {USD, "INR"}} {
+		if _, err := DefaultRates.Rate(ctx, pair[0], pair[1]); !errors.Is(err, ErrUnknownCurrency) {
+			t.Errorf("%s to %s: %v", pair[0], pair[1], err)
+		}
+	}
+}
+
+func TestFrankfurter(t *testing.T) {
+	var calls atomic.Int32
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() || r.URL.Path != "/latest" || r.URL.Query().Get("from") != USD {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"amount":1.0,"base":"USD","rates":{"EUR":0.9,"GBP":0.8,"INR":83.0}}`))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	// Fetched rates are used, including currencies the static table lacks, and kept until stale.
+	f := NewFrankfurter(srv.URL+"/", time.Hour, DefaultRates)
+	for range 3 {
+		if rate, err := f.Rate(ctx, USD, "INR"); err != nil || rate != 83 {
+			t.Fatalf("USD to INR = %v, %v", rate, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("%d fetches, want 1", calls.Load())
+	}
+
+	// Stale rates are kept when the refetch fails, and the failure isn't retried at once.
+	f.mu.Lock()
+	f.fetchedAt = time.Now().Add(-2 * time.Hour)
+	f.mu.Unlock()
+	failing.Store(true)
+	for range 3 {
+		if rate, err := f.Rate(ctx, USD, GBP); err != nil || rate != 0.8 {
+			t.Errorf("USD to GBP after a failed refresh = %v, %v", rate, err)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("%d fetches, want one retry", calls.Load())
+	}
+
+	// Before the first successful fetch the fallback table answers.
+	f = NewFrankfurter(srv.URL, time.Hour, DefaultRates)
+	if rate, err := f.Rate(ctx, USD, GBP); err != nil || rate != DefaultRates[GBP] {
+		t.Errorf("USD to GBP from the fallback = %v, %v", rate, err)
+	}
+	if _, err := f.Rate(ctx, USD, "INR"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("USD to INR from the fallback: %v", err)
+	}
+}
+
+func TestNewRateProvider(t *testing.T) {
+	for _, name := range []string{"", ProviderStatic, ProviderFrankfurter} {
+		if p, err := NewRateProvider(Config{Provider: name}); err != nil || p == nil {
+			t.Errorf("NewRateProvider(%q) = %v, %v", name, p, err)
+		}
+	}
+	if _, err := NewRateProvider(Config{Provider: "oanda"}); err == nil {
+		t.Error("NewRateProvider accepted an unknown provider")
+	}
+}
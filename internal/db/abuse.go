@@ -0,0 +1,205 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Rejection is a request of a client the server turned down for the client's own fault: an
+// invalid request or a rate limit hit. Rejections are stored in the "rejections" collection
+// so every replica counts them, and removed once ExpiresAt has passed.
+type Rejection struct {
+	Client    string    `bson:"client"` // The account, as usage is recorded (API keys hashed)
+	Kind      string    `bson:"kind"`   // "invalid_request" or "rate_limited"
+	At        time.Time `bson:"at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Ban refuses a client's requests until ExpiresAt, unless lifted earlier. Bans are stored in
+// the "bans" collection and kept once over, as the record of why a client was refused.
+type Ban struct {
+	ID         string     `bson:"_id" json:"id"`
+	Client     string     `bson:"client" json:"client"`
+	Reason     string     `bson:"reason" json:"reason"`
+	Rejections int        `bson:"rejections" json:"rejections"` // Rejected requests in the window that led to it
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	ExpiresAt  time.Time  `bson:"expires_at" json:"expires_at"`
+	LiftedAt   *time.Time `bson:"lifted_at,omitempty" json:"lifted_at,omitempty"`
+	LiftedBy   string     `bson:"lifted_by,omitempty" json:"lifted_by,omitempty"` // The admin, as a masked key
+}
+
+// Active reports whether the ban refuses requests at now.
+func (b Ban) Active(now time.Time) bool {
+	return b.LiftedAt == nil && b.ExpiresAt.After(now)
+}
+
+// ensureAbuseIndexes creates the TTL index that makes MongoDB delete expired rejections and
+// the indexes rejections and bans are looked up by client with.
+func ensureAbuseIndexes(ctx context.Context, rejections, bans *mongo.Collection) error {
+	_, err := rejections.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "client", Value: 1}, {Key: "at", Value: 1}}},
+	})
+	if err != nil {
+		return wrapErr("create rejections indexes", err)
+	}
+	_, err = bans.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "client", Value: 1}, {Key: "expires_at", Value: -1}}})
+	return wrapErr("create bans client index", err)
+}
+
+// RecordRejection stores rejection and returns how many rejections its client has had since
+// since, this one included.
+func (m *MongoDBClient) RecordRejection(ctx context.Context, rejection Rejection, since time.Time) (int, error) {
+	if _, err := m.rejections.InsertOne(ctx, rejection); err != nil {
+		return 0, wrapErr("record rejection", err)
+	}
+	n, err := m.rejections.CountDocuments(ctx, bson.M{"client": rejection.Client, "at": bson.M{"$gt": since}})
+	if err != nil {
+		return 0, wrapErr("count rejections", err)
+	}
+	return int(n), nil
+}
+
+// SaveBan stores ban, replacing the stored version if there is one.
+func (m *MongoDBClient) SaveBan(ctx context.Context, ban Ban) error {
+	_, err := m.bans.ReplaceOne(ctx, bson.M{"_id": ban.ID}, ban, options.Replace().SetUpsert(true))
+	return wrapErr("save ban "+ban.ID, err)
+}
+
+// GetBan returns the ban that refuses client's requests at now, or an ErrNotFound error if
+// none does. Of several, it is the one that lasts longest.
+func (m *MongoDBClient) GetBan(ctx context.Context, client string, now time.Time) (Ban, error) {
+	var ban Ban
+	filter := bson.M{"client": client, "expires_at": bson.M{"$gt": now}, "lifted_at": nil}
+	opts := options.FindOne().SetSort(bson.D{{Key: "expires_at", Value: -1}})
+	if err := m.bans.FindOne(ctx, filter, opts).Decode(&ban); err != nil {
+		return Ban{}, wrapErr("get ban of "+client, err)
+	}
+	return ban, nil
+}
+
+// ListBans returns the bans in force at activeAt, or every ban if it is zero, newest first.
+func (m *MongoDBClient) ListBans(ctx context.Context, activeAt time.Time) ([]Ban, error) {
+	filter := bson.M{}
+	if !activeAt.IsZero() {
+		filter = bson.M{"expires_at": bson.M{"$gt": activeAt}, "lifted_at": nil}
+	}
+	cur, err := m.bans.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, wrapErr("list bans", err)
+	}
+	bans := []Ban{}
+	if err := cur.All(ctx, &bans); err != nil {
+		return nil, wrapErr("decode bans", err)
+	}
+	return bans, nil
+}
+
+// LiftBan ends the bans of client in force at at, recording who lifted them, and returns the
+// one that would have lasted longest. It returns an ErrNotFound error if none was in force.
+func (m *MongoDBClient) LiftBan(ctx context.Context, client, by string, at time.Time) (Ban, error) {
+	ban, err := m.GetBan(ctx, client, at)
+	if err != nil {
+		return Ban{}, err
+	}
+	filter := bson.M{"client": client, "expires_at": bson.M{"$gt": at}, "lifted_at": nil}
+	update := bson.M{"$set": bson.M{"lifted_at": at, "lifted_by": by}}
+	if _, err := m.bans.UpdateMany(ctx, filter, update); err != nil {
+		return Ban{}, wrapErr("lift bans of "+client, err)
+	}
+	ban.LiftedAt, ban.LiftedBy = &at, by
+	return ban, nil
+}
+
+// RecordRejection mirrors MongoDBClient.RecordRejection. Expired rejections are dropped on
+// the way.
+func (m *MemoryClient) RecordRejection(ctx context.Context, rejection Rejection, since time.Time) (int, error) {
+	if err := checkContext(ctx, "record rejection"); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.rejections[:0]
+	n := 0
+	for _, r := range append(m.rejections, rejection) {
+		if !r.ExpiresAt.After(rejection.At) {
+			continue
+		}
+		kept = append(kept, r)
+		if r.Client == rejection.Client && r.At.After(since) {
+			n++
+		}
+	}
+	m.rejections = kept
+	return n, nil
+}
+
+// SaveBan mirrors MongoDBClient.SaveBan.
+func (m *MemoryClient) SaveBan(ctx context.Context, ban Ban) error {
+	if err := checkContext(ctx, "save ban "+ban.ID); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bans[ban.ID] = ban
+	return nil
+}
+
+// GetBan mirrors MongoDBClient.GetBan.
+func (m *MemoryClient) GetBan(ctx context.Context, client string, now time.Time) (Ban, error) {
+	if err := checkContext(ctx, "get ban of "+client); err != nil {
+		return Ban{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var found *Ban
+	for _, ban := range m.bans {
+		if ban.Client == client && ban.Active(now) && (found == nil || ban.ExpiresAt.After(found.ExpiresAt)) {
+			found = &ban
+		}
+	}
+	if found == nil {
+		return Ban{}, wrapErr("get ban of "+client, ErrNotFound)
+	}
+	return *found, nil
+}
+
+// ListBans mirrors MongoDBClient.ListBans.
+func (m *MemoryClient) ListBans(ctx context.Context, activeAt time.Time) ([]Ban, error) {
+	if err := checkContext(ctx, "list bans"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	bans := []Ban{}
+	for _, ban := range m.bans {
+		if activeAt.IsZero() || ban.Active(activeAt) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].CreatedAt.After(bans[j].CreatedAt) })
+	return bans, nil
+}
+
+// LiftBan mirrors MongoDBClient.LiftBan.
+func (m *MemoryClient) LiftBan(ctx context.Context, client, by string, at time.Time) (Ban, error) {
+	ban, err := m.GetBan(ctx, client, at)
+	if err != nil {
+		return Ban{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, stored := range m.bans {
+		if stored.Client == client && stored.Active(at) {
+			stored.LiftedAt, stored.LiftedBy = &at, by
+			m.bans[id] = stored
+		}
+	}
+	ban.LiftedAt, ban.LiftedBy = &at, by
+	return ban, nil
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// checkAbuse checks a backend's rejection counts and bans.
+func checkAbuse(t *testing.T, c Client) {
+	ctx := context.Background()
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	reject := func(client string, at time.Time) int {
+		t.Helper()
+		n, err := c.RecordRejection(ctx, Rejection{Client: client, Kind: "invalid_request", At: at, ExpiresAt: at.Add(time.Minute)}, at.Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// Each client's rejections are counted within the window only.
+	for i, want := range []int{1, 2, 3} {
+		if n := reject("ip:192.0.2.1", start.Add(time.Duration(i)*10*time.Second)); n != want {
+			t.Errorf("rejection %d counted %d", i+1, n)
+		}
+	}
+	if n := reject("ip:192.0.2.2", start.Add(30*time.Second)); n != 1 {
+		t.Errorf("another client's first rejection counted %d", n)
+	}
+	if n := reject("ip:192.0.2.1", start.Add(65*time.Second)); n != 3 {
+		t.Errorf("a rejection after the first left the window counted %d, want 3", n)
+	}
+
+	// A ban refuses until it expires.
+	ban := Ban{ID: "ban-1", Client: "ip:192.0.2.1", Reason: "3 rejected requests", Rejections: 3, CreatedAt: start, ExpiresAt: start.Add(15 * time.Minute)}
+	if err := c.SaveBan(ctx, ban); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.GetBan(ctx, "ip:192.0.2.1", start.Add(time.Minute)); err != nil || got.ID != "ban-1" || !got.ExpiresAt.Equal(ban.ExpiresAt) {
+		t.Errorf("GetBan = %+v, %v", got, err)
+	}
+	if _, err := c.GetBan(ctx, "ip:192.0.2.1", ban.ExpiresAt); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetBan once expired = %v, want ErrNotFound", err)
+	}
+	if _, err := c.GetBan(ctx, "ip:192.0.2.2", start); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetBan of a client not banned = %v", err)
+	}
+
+	// Of two bans, the longer one is in force.
+	longer := Ban{ID: "ban-2", Client: "ip:192.0.2.1", Reason: "again", Rejections: 4, CreatedAt: start.Add(time.Minute), ExpiresAt: start.Add(time.Hour)}
+	if err := c.SaveBan(ctx, longer); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.GetBan(ctx, "ip:192.0.2.1", start.Add(2*time.Minute)); err != nil || got.ID != "ban-2" {
+		t.Errorf("GetBan of two = %+v, %v", got, err)
+	}
+	if bans, err := c.ListBans(ctx, start.Add(30*time.Minute)); err != nil || len(bans) != 1 || bans[0].ID != "ban-2" {
+		t.Errorf("bans in force %+v, %v", bans, err)
+	}
+	if bans, err := c.ListBans(ctx, time.Time{}); err != nil || len(bans) != 2 || bans[0].ID != "ban-2" || bans[1].ID != "ban-1" {
+		t.Errorf("every ban %+v, %v", bans, err)
+	}
+
+	// Lifting ends every ban of the client in force, and says who lifted it.
+	liftedAt := start.Add(5 * time.Minute)
+	lifted, err := c.LiftBan(ctx, "ip:192.0.2.1", "key:…1234", liftedAt)
+	if err != nil || lifted.ID != "ban-2" || lifted.LiftedAt == nil || !lifted.LiftedAt.Equal(liftedAt) || lifted.LiftedBy != "key:…1234" {
+		t.Errorf("LiftBan = %+v, %v", lifted, err)
+	}
+	if _, err := c.GetBan(ctx, "ip:192.0.2.1", liftedAt.Add(time.Second)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetBan once lifted = %v", err)
+	}
+	if _, err := c.LiftBan(ctx, "ip:192.0.2.1", "key:…1234", liftedAt.Add(time.Second)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("lifting again = %v, want ErrNotFound", err)
+	}
+	bans, err := c.ListBans(ctx, time.Time{})
+	if err != nil || len(bans) != 2 {
+		t.Fatalf("every ban after the lift %+v, %v", bans, err)
+	}
+	for _, b := range bans {
+		if b.LiftedAt == nil || b.LiftedBy != "key:…1234" {
+			t.Errorf("ban %s not lifted: %+v", b.ID, b)
+		}
+	}
+}
+
+func TestMemoryAbuse(t *testing.T) {
+	checkAbuse(t, NewMemoryClient())
+}
+
+func TestMongoAbuse(t *testing.T) {
+	checkAbuse(t, newMongoTestClient(t))
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Airport is an airport the flights or schedules use, by its IATA code, with the city it
+// serves. A city may have several ("New York": "JFK" and "EWR").
+type Airport struct {
+	Code string `bson:"code" json:"code"`
+	City string `bson:"city" json:"city"`
+}
+
+// PlaceName names a city for people, with the airport when there is one: "New York (JFK)".
+func PlaceName(city, airport string) string {
+	if airport == "" {
+		return city
+	}
+	return city + " (" + airport + ")"
+}
+
+// validAirportCode reports whether code is an IATA airport code: three uppercase letters.
+func validAirportCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// airportProblems checks the optional airport codes of a flight or schedule.
+func airportProblems(origin, destination string) []string {
+	var problems []string
+	if origin != "" && !validAirportCode(origin) {
+		problems = append(problems, "origin_airport must be a three-letter IATA code in capitals")
+	}
+	if destination != "" && !validAirportCode(destination) {
+		problems = append(problems, "destination_airport must be a three-letter IATA code in capitals")
+	}
+	if origin != "" && origin == destination {
+		problems = append(problems, "origin_airport and destination_airport must differ")
+	}
+	return problems
+}
+
+// sortAirports drops airports without a code and duplicate codes, keeping the first city
+// seen for each, and orders the rest by code.
+func sortAirports(airports []Airport) []Airport {
+	seen := make(map[string]bool, len(airports))
+	unique := airports[:0]
+	for _, a := range airports {
+		if a.Code == "" || a.City == "" || seen[a.Code] {
+			continue
+		}
+		seen[a.Code] = true
+		unique = append(unique, a)
+	}
+	sort.Slice(unique, func(i, j int) bool { return unique[i].Code < unique[j].Code })
+	return unique
+}
+
+// ListAirports returns every airport a stored flight or schedule departs from or arrives at,
+// ordered by code.
+func (m *MongoDBClient) ListAirports(ctx context.Context) ([]Airport, error) {
+	var airports []Airport
+	for name, collection := range map[string]*mongo.Collection{"flights": m.flightReads, "schedules": m.scheduleReads} {
+		for _, end := range []string{"origin", "destination"} {
+			pipeline := []bson.M{
+				{"$match": bson.M{end + "_airport": bson.M{"$nin": bson.A{nil, ""}}}},
+				{"$group": bson.M{"_id": bson.M{"code": "$" + end + "_airport", "city": "$" + end}}},
+				{"$replaceWith": "$_id"},
+			}
+			cur, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(m.maxQueryTime))
+			if err != nil {
+				return nil, wrapErr("list airports of "+name, err)
+			}
+			var found []Airport
+			if err := cur.All(ctx, &found); err != nil {
+				return nil, wrapErr("decode airports of "+name, err)
+			}
+			airports = append(airports, found...)
+		}
+	}
+	return sortAirports(airports), nil
+}
+
+// ListAirports mirrors MongoDBClient.ListAirports.
+func (m *MemoryClient) ListAirports(ctx context.Context) ([]Airport, error) {
+	if err := checkContext(ctx, "list airports"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	airports := make([]Airport, 0, 2*(len(m.flights)+len(m.schedules)))
+	for _, f := range m.flights {
+		airports = append(airports, Airport{Code: f.OriginAirport, City: f.Origin}, Airport{Code: f.DestinationAirport, City: f.Destination})
+	}
+	for _, s := range m.schedules {
+		airports = append(airports, Airport{Code: s.OriginAirport, City: s.Origin}, Airport{Code: s.DestinationAirport, City: s.Destination})
+	}
+	return sortAirports(airports), nil
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// checkAirports checks a seeded backend's airports and the searches by city and by airport.
+func checkAirports(t *testing.T, c Client) {
+	ctx := context.Background()
+	airports, err := c.ListAirports(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Airport{{"EWR", "New York"}, {"HND", "Tokyo"}, {"JFK", "New York"}, {"LAX", "Los Angeles"}, {"LGW", "London"}, {"LHR", "London"}}
+	if !slices.Equal(airports, want) {
+		t.Errorf("airports %v, want %v", airports, want)
+	}
+
+	// A city matches the flights of all its airports; an airport code only its own.
+	for _, tt := range []struct {
+		name  string
+		query FlightQuery
+		want  string
+	}{
+		{"city", FlightQuery{Origin: "New York"}, "FL108 FL120"},
+		{"airport", FlightQuery{Origin: "New York", OriginAirport: "JFK"}, "FL120"},
+		{"other airport", FlightQuery{Origin: "New York", OriginAirport: "EWR"}, "FL108"},
+		{"lowercase airport", FlightQuery{OriginAirport: "ewr"}, "FL108"},
+		{"airport without its city", FlightQuery{OriginAirport: "JFK"}, "FL120"},
+		{"route by city", FlightQuery{Origin: "London", Destination: "New York"}, "FL107"},
+		{"route by airports", FlightQuery{OriginAirport: "LHR", DestinationAirport: "JFK"}, "FL107"},
+		{"route from the other airport", FlightQuery{Origin: "London", OriginAirport: "LGW", Destination: "New York"}, ""},
+		{"destination only", FlightQuery{DestinationAirport: "LHR"}, "FL107 FL111 FL112"},
+		{"city and another city's airport", FlightQuery{Origin: "London", OriginAirport: "JFK"}, ""},
+	} {
+		flights, err := c.QueryFlights(ctx, tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		numbers := make([]string, len(flights))
+		for i, f := range flights {
+			numbers[i] = f.FlightNumber
+		}
+		slices.Sort(numbers)
+		if got := strings.Join(numbers, " "); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryAirports(t *testing.T) {
+	c := NewMemoryClient()
+	if err := c.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkAirports(t, c)
+}
+
+func TestMongoAirports(t *testing.T) {
+	c := newMongoTestClient(t)
+	if err := c.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkAirports(t, c)
+}
+
+func TestSortAirports(t *testing.T) {
+	got := sortAirports([]Airport{{"LHR", "London"}, {"", "Paris"}, {"JFK", "New York"}, {"LHR", "Londres"}, {"EWR", ""}})
+	want := []Airport{{"JFK", "New York"}, {"LHR", "London"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("sortAirports = %v, want %v", got, want)
+	}
+}
+
+func TestAirportValidation(t *testing.T) {
+	f := Flight{FlightNumber: "FL900", Origin: "New York", Destination: "London", DepartureTime: "2025-08-20T08:00:00Z",
+		ArrivalTime: "2025-08-20T15:00:00Z", Price: 500, AvailableSeats: 100, OriginAirport: "JFK", DestinationAirport: "LHR"}
+	if err := f.Validate(); err != nil {
+		t.Errorf("valid airports: %v", err)
+	}
+	for _, airports := range [][2]string{{"jfk", ""}, {"", "LHRX"}, {"J1K", ""}, {"JFK", "JFK"}} {
+		f.OriginAirport, f.DestinationAirport = airports[0], airports[1]
+		if err := f.Validate(); err == nil || !strings.Contains(err.Error(), "_airport") {
+			t.Errorf("airports %v: %v", airports, err)
+		}
+	}
+}
+
+func TestPlaceName(t *testing.T) {
+	if got := PlaceName("New York", "JFK"); got != "New York (JFK)" {
+		t.Errorf("PlaceName with an airport = %q", got)
+	}
+	if got := PlaceName("Paris", ""); got != "Paris" {
+		t.Errorf("PlaceName without = %q", got)
+	}
+}
@@ -0,0 +1,262 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// staleRefreshTimeout bounds a background refresh, which no request's deadline covers.
+const staleRefreshTimeout = 30 * time.Second
+
+// CachedClient wraps a Client and caches SearchFlights results for a fixed TTL.
+// All other methods pass straight through to the wrapped client; the ones that
+// write flights also clear the cache so callers see their own writes immediately.
+type CachedClient struct {
+	Client
+
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	gen     int64 // Bumped by Invalidate, so a query that started before doesn't store its result
+
+	softDeadline time.Duration       // Wait for a fresh result this long before serving a stale one; see ServeStale
+	maxStale     time.Duration       // How long after expiry an entry may still be served
+	refreshing   map[string]*refresh // Background queries by cache key
+
+	hits, misses, stale atomic.Int64
+}
+
+// CacheStats counts cache lookups since the client was created.
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	Stale  int64 // Misses answered with an expired entry because the database was slow
+}
+
+type cacheEntry struct {
+	flights []Flight
+	stored  time.Time
+	expires time.Time
+}
+
+// refresh is a query that runs in the background while callers wait for it, or don't.
+type refresh struct {
+	done    chan struct{} // Closed when flights and err are set
+	flights []Flight
+	err     error
+}
+
+// NewCachedClient wraps client with a search cache whose entries live for ttl.
+func NewCachedClient(client Client, ttl time.Duration) *CachedClient {
+	return &CachedClient{
+		Client:     client,
+		ttl:        ttl,
+		entries:    make(map[string]cacheEntry),
+		refreshing: make(map[string]*refresh),
+	}
+}
+
+// ServeStale keeps expired entries for up to maxStale, to stand in when the database is
+// slow: a query with such an entry gets the fresh result if it arrives within softDeadline,
+// and the expired entry otherwise. The query goes on in the background, so the entry is
+// fresh for the next caller; it is shared by concurrent callers of the same query. A query
+// that fails before the deadline still returns its error. Callers can tell a stale answer
+// by WithStaleReport. It must be called before the client is used.
+func (c *CachedClient) ServeStale(softDeadline, maxStale time.Duration) {
+	c.softDeadline, c.maxStale = softDeadline, maxStale
+}
+
+// staleReportKey is the context key of a StaleReport.
+type staleReportKey struct{}
+
+// StaleReport tells whether flight queries were answered with expired cache entries.
+type StaleReport struct {
+	mu     sync.Mutex
+	served bool
+	age    time.Duration // Of the oldest entry served
+}
+
+// WithStaleReport returns a context whose flight queries report into the returned
+// StaleReport when a CachedClient answers them with an expired entry.
+func WithStaleReport(ctx context.Context) (context.Context, *StaleReport) {
+	r := &StaleReport{}
+	return context.WithValue(ctx, staleReportKey{}, r), r
+}
+
+// Stale returns the age of the oldest expired entry served, and whether one was.
+func (r *StaleReport) Stale() (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.age, r.served
+}
+
+// reportStale records in ctx's StaleReport, if it has one, that an entry of age was served.
+func reportStale(ctx context.Context, age time.Duration) {
+	r, ok := ctx.Value(staleReportKey{}).(*StaleReport)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.served = true
+	r.age = max(r.age, age)
+}
+
+// SearchFlights is routed through QueryFlights so it shares the cache.
+func (c *CachedClient) SearchFlights(ctx context.Context, origin, destination string, maxPrice float64) ([]Flight, error) {
+	return c.QueryFlights(ctx, FlightQuery{Origin: origin, Destination: destination, MaxPrice: maxPrice})
+}
+
+// QueryFlights serves repeated queries from the cache until they expire.
+func (c *CachedClient) QueryFlights(ctx context.Context, q FlightQuery) ([]Flight, error) {
+	key := q.cacheKey()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	now := time.Now()
+	if ok && now.Before(entry.expires) {
+		c.hits.Add(1)
+		return append([]Flight(nil), entry.flights...), nil // Copy so callers can't modify the cached slice.
+	}
+	c.misses.Add(1)
+
+	if !ok || c.ttl <= 0 || c.softDeadline <= 0 || now.Sub(entry.expires) > c.maxStale {
+		c.mu.Lock()
+		gen := c.gen
+		c.mu.Unlock()
+		flights, err := c.Client.QueryFlights(ctx, q)
+		if err != nil {
+			return nil, err // Errors are never cached.
+		}
+		c.store(key, gen, flights)
+		return append([]Flight(nil), flights...), nil
+	}
+
+	// The expired entry stands in if the database is slow to answer.
+	r := c.refresh(ctx, key, q)
+	timer := time.NewTimer(c.softDeadline)
+	defer timer.Stop()
+	select {
+	case <-r.done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return append([]Flight(nil), r.flights...), nil
+	case <-timer.C:
+		c.stale.Add(1)
+		age := time.Since(entry.stored)
+		reportStale(ctx, age)
+		slog.WarnContext(ctx, "Flight search is slow; answering from the cache while it refreshes",
+			"age", age.Round(time.Second), "soft_deadline", c.softDeadline)
+		return append([]Flight(nil), entry.flights...), nil
+	case <-ctx.Done():
+		return nil, checkContext(ctx, "query flights")
+	}
+}
+
+// refresh returns the running background query for key, starting one if there is none.
+func (c *CachedClient) refresh(ctx context.Context, key string, q FlightQuery) *refresh {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.refreshing[key]; ok {
+		return r
+	}
+	r := &refresh{done: make(chan struct{})}
+	c.refreshing[key] = r
+	gen := c.gen
+	// The query outlives the request that started it when that request is answered from the
+	// cache. It keeps the request's values, so its logs and spans still carry the request ID,
+	// but not its cancellation or deadline.
+	bg, cancel := context.WithTimeout(context.WithoutCancel(ctx), staleRefreshTimeout)
+	go func() {
+		defer cancel()
+		r.flights, r.err = c.Client.QueryFlights(bg, q)
+		// Stored before the refresh is done, so no caller in between starts another.
+		if r.err == nil {
+			c.store(key, gen, r.flights)
+		}
+		c.mu.Lock()
+		delete(c.refreshing, key)
+		c.mu.Unlock()
+		close(r.done)
+	}()
+	return r
+}
+
+// store caches the result of a query that started in generation gen, unless the cache has
+// been invalidated since.
+func (c *CachedClient) store(key string, gen int64, flights []Flight) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return
+	}
+	now := time.Now()
+	c.entries[key] = cacheEntry{flights: flights, stored: now, expires: now.Add(c.ttl)}
+}
+
+// Stats returns the hit, miss and stale counts, e.g. for metrics.
+func (c *CachedClient) Stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Stale: c.stale.Load()}
+}
+
+// Invalidate drops every cached search, e.g. after the flights collection changed. Expired
+// entries go too: they are no longer just old but wrong.
+func (c *CachedClient) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+	c.gen++
+}
+
+// InsertFlights writes through and invalidates the cache.
+func (c *CachedClient) InsertFlights(ctx context.Context, flights []Flight) error {
+	defer c.Invalidate()
+	return c.Client.InsertFlights(ctx, flights)
+}
+
+// UpsertFlights writes through and invalidates the cache.
+func (c *CachedClient) UpsertFlights(ctx context.Context, flights []Flight) (UpsertResult, error) {
+	defer c.Invalidate()
+	return c.Client.UpsertFlights(ctx, flights)
+}
+
+// CreateFlight writes through and invalidates the cache.
+func (c *CachedClient) CreateFlight(ctx context.Context, flight Flight) error {
+	defer c.Invalidate()
+	return c.Client.CreateFlight(ctx, flight)
+}
+
+// UpdateFlight writes through and invalidates the cache.
+func (c *CachedClient) UpdateFlight(ctx context.Context, flight Flight) error {
+	defer c.Invalidate()
+	return c.Client.UpdateFlight(ctx, flight)
+}
+
+// DeleteFlight writes through and invalidates the cache.
+func (c *CachedClient) DeleteFlight(ctx context.Context, flightNumber string) error {
+	defer c.Invalidate()
+	return c.Client.DeleteFlight(ctx, flightNumber)
+}
+
+// UpsertSchedule writes through and invalidates the cache.
+func (c *CachedClient) UpsertSchedule(ctx context.Context, schedule FlightSchedule) error {
+	defer c.Invalidate()
+	return c.Client.UpsertSchedule(ctx, schedule)
+}
+
+// DeleteSchedule writes through and invalidates the cache.
+func (c *CachedClient) DeleteSchedule(ctx context.Context, flightNumber string) error {
+	defer c.Invalidate()
+	return c.Client.DeleteSchedule(ctx, flightNumber)
+}
+
+// SeedFlights writes through and invalidates the cache.
+func (c *CachedClient) SeedFlights(ctx context.Context) error {
+	defer c.Invalidate()
+	return c.Client.SeedFlights(ctx)
+}
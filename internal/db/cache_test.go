@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowClient is a database whose flight queries take delay, or fail with err.
+type slowClient struct {
+	Client
+	delay   atomic.Int64 // A time.Duration
+	err     atomic.Pointer[error]
+	queries atomic.Int64
+}
+
+func (c *slowClient) QueryFlights(ctx context.Context, q FlightQuery) ([]Flight, error) {
+	c.queries.Add(1)
+	select {
+	case <-time.After(time.Duration(c.delay.Load())):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err := c.err.Load(); err != nil {
+		return nil, *err
+	}
+	return c.Client.QueryFlights(ctx, q)
+}
+
+// newStaleCache returns a cache over a slow seeded database, serving entries that expired
+// up to a minute ago when a query takes over 50ms.
+func newStaleCache(t *testing.T, ttl time.Duration) (*CachedClient, *slowClient, *MemoryClient) {
+	t.Helper()
+	m := NewMemoryClient()
+	if err := m.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	slow := &slowClient{Client: m}
+	cached := NewCachedClient(slow, ttl)
+	cached.ServeStale(50*time.Millisecond, time.Minute)
+	return cached, slow, m
+}
+
+// waitForRefresh waits until no background query is running.
+func waitForRefresh(t *testing.T, c *CachedClient) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		c.mu.Lock()
+		running := len(c.refreshing)
+		c.mu.Unlock()
+		if running == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the background refresh didn't finish")
+		}
+	}
+}
+
+func TestServeStale(t *testing.T) {
+	cached, slow, m := newStaleCache(t, 20*time.Millisecond)
+	search := func(ctx context.Context) ([]Flight, time.Duration, bool) {
+		t.Helper()
+		ctx, report := WithStaleReport(ctx)
+		flights, err := cached.SearchFlights(ctx, "Madrid", "Barcelona", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		age, stale := report.Stale()
+		return flights, age, stale
+	}
+	if flights, _, stale := search(context.Background()); len(flights) != 1 || stale {
+		t.Fatalf("first search: %d flights, stale %v", len(flights), stale)
+	}
+
+	// The entry expires, the database changes and slows down: the expired entry is served
+	// at the soft deadline, and the request is done before the query is.
+	time.Sleep(30 * time.Millisecond)
+	m.UpsertFlights(context.Background(), []Flight{testFlight("IB900", "Madrid", "Barcelona", 60)})
+	slow.delay.Store(int64(300 * time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	flights, age, stale := search(ctx)
+	cancel()
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("the stale answer took %s", elapsed)
+	}
+	if len(flights) != 1 || !stale || age < 30*time.Millisecond {
+		t.Errorf("slow search: %d flights, stale %v, age %s", len(flights), stale, age)
+	}
+	if stats := cached.Stats(); stats.Stale != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	// The query goes on without the request, and its result is what the next request gets.
+	waitForRefresh(t, cached)
+	slow.delay.Store(0)
+	queries := slow.queries.Load()
+	if flights, _, stale := search(context.Background()); len(flights) != 2 || stale || slow.queries.Load() != queries {
+		t.Errorf("after the refresh: %d flights, stale %v, %d more queries", len(flights), stale, slow.queries.Load()-queries)
+	}
+}
+
+func TestServeStaleFreshInTime(t *testing.T) {
+	cached, slow, _ := newStaleCache(t, 10*time.Millisecond)
+	if _, err := cached.SearchFlights(context.Background(), "Madrid", "Paris", 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// A query that answers before the soft deadline is served fresh.
+	slow.delay.Store(int64(10 * time.Millisecond))
+	ctx, report := WithStaleReport(context.Background())
+	if flights, err := cached.SearchFlights(ctx, "Madrid", "Paris", 0); err != nil || len(flights) != 4 {
+		t.Fatalf("%d flights, %v", len(flights), err)
+	}
+	if _, stale := report.Stale(); stale {
+		t.Error("a fresh answer reported stale")
+	}
+
+	// A query that fails before the deadline fails the request: hard failures aren't hidden.
+	time.Sleep(20 * time.Millisecond)
+	down := error(ErrUnavailable)
+	slow.err.Store(&down)
+	if _, err := cached.SearchFlights(context.Background(), "Madrid", "Paris", 0); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("failed query: %v", err)
+	}
+}
+
+func TestServeStaleLimits(t *testing.T) {
+	// Without an entry, or one expired longer ago than maxStale, the query is waited for.
+	cached, slow, _ := newStaleCache(t, 10*time.Millisecond)
+	cached.ServeStale(20*time.Millisecond, 10*time.Millisecond)
+	slow.delay.Store(int64(60 * time.Millisecond))
+	ctx, report := WithStaleReport(context.Background())
+	if flights, err := cached.SearchFlights(ctx, "Madrid", "Paris", 0); err != nil || len(flights) != 4 {
+		t.Fatalf("%d flights, %v", len(flights), err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if flights, err := cached.SearchFlights(ctx, "Madrid", "Paris", 0); err != nil || len(flights) != 4 {
+		t.Fatalf("%d flights, %v", len(flights), err)
+	}
+	if _, stale := report.Stale(); stale || cached.Stats().Stale != 0 {
+		t.Error("an entry past maxStale was served")
+	}
+}
+
+func TestServeStaleInvalidated(t *testing.T) {
+	cached, slow, _ := newStaleCache(t, 10*time.Millisecond)
+	if _, err := cached.SearchFlights(context.Background(), "Madrid", "Valencia", 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	slow.delay.Store(int64(100 * time.Millisecond))
+	if _, err := cached.SearchFlights(context.Background(), "Madrid", "Valencia", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write while the refresh runs drops its result, which may not include the write.
+	if err := cached.InsertFlights(context.Background(), []Flight{testFlight("IB901", "Madrid", "Valencia", 50)}); err != nil {
+		t.Fatal(err)
+	}
+	waitForRefresh(t, cached)
+	cached.mu.Lock()
+	stored := len(cached.entries)
+	cached.mu.Unlock()
+	if stored != 0 {
+		t.Errorf("%d entries stored after the write", stored)
+	}
+	slow.delay.Store(0)
+	flights, err := cached.SearchFlights(context.Background(), "Madrid", "Valencia", 0)
+	if err != nil || len(flights) != 2 {
+		t.Errorf("after the write: %d flights, %v", len(flights), err)
+	}
+}
@@ -3,37 +3,146 @@ package db
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"          // BSON (Binary JSON) package for MongoDB documents
+	"go.mongodb.org/mongo-driver/event"         // Command monitoring, used by tests
 	"go.mongodb.org/mongo-driver/mongo"         // MongoDB Go Driver main package
 	"go.mongodb.org/mongo-driver/mongo/options" // Options for MongoDB client and operations
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 // Client defines the interface for database operations.
 // Using an interface allows easy swapping between a real MongoDB client and a mock client for testing.
+// Errors are *OpError values wrapping ErrNotFound, ErrConflict or ErrUnavailable where applicable.
 type Client interface {
 	Connect(ctx context.Context, uri string) error
 	Disconnect(ctx context.Context) error
 	InsertFlights(ctx context.Context, flights []Flight) error // New method for inserting flights
+	UpsertFlights(ctx context.Context, flights []Flight) (UpsertResult, error)
+	CreateFlight(ctx context.Context, flight Flight) error // ErrConflict if the flight number exists
+	UpdateFlight(ctx context.Context, flight Flight) error // ErrNotFound if the flight number doesn't exist
+	DeleteFlight(ctx context.Context, flightNumber string) error
+	GetFlight(ctx context.Context, flightNumber string) (Flight, error) // ErrNotFound if there is none
+	SeedFlights(ctx context.Context) error
 	SearchFlights(ctx context.Context, origin, destination string, maxPrice float64) ([]Flight, error)
+	QueryFlights(ctx context.Context, q FlightQuery) ([]Flight, error)
+	UpsertSchedule(ctx context.Context, schedule FlightSchedule) error
+	GetSchedule(ctx context.Context, flightNumber string) (FlightSchedule, error)
+	ListSchedules(ctx context.Context) ([]FlightSchedule, error)
+	ListRoutes(ctx context.Context) ([]Route, error)
+	ListAirports(ctx context.Context) ([]Airport, error)
+	DeleteSchedule(ctx context.Context, flightNumber string) error
+	InsertQueryLog(ctx context.Context, entry QueryLog) error
+	GetQueryLog(ctx context.Context, requestID string) (QueryLog, error) // ErrNotFound if the request wasn't logged
+	GetQueryStats(ctx context.Context, since time.Time) (QueryStats, error)
+	AppendTurns(ctx context.Context, sessionID, client string, turns ...Turn) error
+	GetConversation(ctx context.Context, sessionID string) (Conversation, error) // ErrNotFound if the session has none
+	SetConversationTitle(ctx context.Context, sessionID, client, title string, overwrite bool) error
+	ListConversations(ctx context.Context, client string, limit int) ([]ConversationSummary, error)
+	ShareConversation(ctx context.Context, sessionID, client string, share ConversationShare) error   // ErrNotFound if client has no such conversation
+	UnshareConversation(ctx context.Context, sessionID, client string) error                          // ErrNotFound if it isn't shared
+	GetSharedConversation(ctx context.Context, tokenHash string, now time.Time) (Conversation, error) // ErrNotFound if no link has the token or it expired
+	IncrementUsage(ctx context.Context, delta UsageDelta) error
+	ListUsage(ctx context.Context, q UsageQuery) ([]Usage, error)
+	SaveJob(ctx context.Context, job Job) error
+	GetJob(ctx context.Context, id string) (Job, error)                          // ErrNotFound if there is none or it has expired
+	CreateIdempotencyKey(ctx context.Context, record IdempotencyRecord) error    // ErrConflict if an unexpired one exists
+	GetIdempotencyKey(ctx context.Context, id string) (IdempotencyRecord, error) // ErrNotFound if there is none or it has expired
+	SaveIdempotencyKey(ctx context.Context, record IdempotencyRecord) error
+	DeleteIdempotencyKey(ctx context.Context, id string) error
+	AppendStreamEvents(ctx context.Context, id, sessionID string, events []StoredEvent, done bool, expiresAt time.Time) error
+	GetStream(ctx context.Context, id string) (SharedStream, error) // ErrNotFound if there is none or it has expired
+	ListFlags(ctx context.Context) ([]Flag, error)
+	SaveFlag(ctx context.Context, flag Flag) error
+	DeleteFlag(ctx context.Context, name string) error                         // ErrNotFound if the flag has no override
+	GetPreferences(ctx context.Context, sessionID string) (Preferences, error) // ErrNotFound if the session has none
+	SavePreferences(ctx context.Context, prefs Preferences) error
+	DeleteSessionData(ctx context.Context, sessionID string, dryRun bool) (RecordCounts, error)
+	PurgeExpired(ctx context.Context, r Retention, now time.Time) (RecordCounts, error)
+	RecordRejection(ctx context.Context, rejection Rejection, since time.Time) (int, error)
+	SaveBan(ctx context.Context, ban Ban) error
+	GetBan(ctx context.Context, client string, now time.Time) (Ban, error) // ErrNotFound if no ban is in force
+	ListBans(ctx context.Context, activeAt time.Time) ([]Ban, error)
+	LiftBan(ctx context.Context, client, by string, at time.Time) (Ban, error) // ErrNotFound if no ban is in force
 }
 
 // MongoDBClient implements the Client interface for MongoDB.
 type MongoDBClient struct {
 	client     *mongo.Client     // The underlying MongoDB client connection
 	collection *mongo.Collection // The specific MongoDB collection to work with (e.g., "flights")
+	queryLogs  *mongo.Collection // Audit records of user queries ("query_logs")
+	schedules  *mongo.Collection // Recurring flight schedules ("schedules")
+
+	conversations *mongo.Collection // Chat transcripts by session ("conversations")
+	usage         *mongo.Collection // LLM usage per client and day ("usage")
+	jobs          *mongo.Collection // Asynchronous requests and their results ("jobs")
+
+	idempotencyKeys *mongo.Collection // Requests sent with an idempotency key, for replay ("idempotency_keys")
+	streams         *mongo.Collection // Request streams shared between replicas ("streams")
+	flags           *mongo.Collection // Feature flag overrides ("flags")
+	preferences     *mongo.Collection // Remembered defaults by session ("preferences")
+
+	rejections *mongo.Collection // Recently rejected requests by client ("rejections")
+	bans       *mongo.Collection // Clients refused for abuse, current and past ("bans")
+	migrations *mongo.Collection // Applied migrations and the migration lock ("migrations")
+
+	// The flights, schedules and conversations for the reads Config routes, with its read
+	// preference; see Config.
+	flightReads       *mongo.Collection
+	scheduleReads     *mongo.Collection
+	conversationReads *mongo.Collection
+	maxQueryTime      time.Duration
+}
+
+// Config tunes the reads of a MongoDBClient that are made on every request: flight searches
+// (SearchFlights, QueryFlights), ListRoutes, ListAirports, GetConversation and
+// ListConversations. Other reads, and all writes, go to the primary without a time limit.
+type Config struct {
+	// ReadPreference is where those reads go, as a MongoDB read preference mode: "primary",
+	// "primaryPreferred", "secondary", "secondaryPreferred" or "nearest"; empty is "primary".
+	// Secondaries may lag the primary by a moment, so a conversation read right after a turn
+	// was stored may miss it. A standalone server answers every mode.
+	ReadPreference string
+
+	// MaxQueryTime is how long the server may run each of those reads before it aborts it
+	// (maxTimeMS), so a slow query fails rather than holding the request; 0 leaves them
+	// unbounded.
+	MaxQueryTime time.Duration
+
+	monitor *event.CommandMonitor // Sees every command sent; set by tests
+}
+
+// ValidReadPreference reports whether mode is a read preference mode Config accepts.
+func ValidReadPreference(mode string) bool {
+	_, err := readpref.ModeFromString(mode)
+	return mode == "" || err == nil
 }
 
 // NewClient creates a new MongoDBClient instance and establishes a connection to the database.
-func NewClient(ctx context.Context, uri string) (*MongoDBClient, error) {
+func NewClient(ctx context.Context, uri string, cfg Config) (*MongoDBClient, error) {
+	readPref := readpref.Primary()
+	if cfg.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(cfg.ReadPreference)
+		if err != nil {
+			return nil, fmt.Errorf("read preference: %w", err)
+		}
+		if readPref, err = readpref.New(mode); err != nil {
+			return nil, fmt.Errorf("read preference: %w", err)
+		}
+	}
+
 	// Set client options using the provided URI (connection string).
 	clientOptions := options.Client().ApplyURI(uri)
+	if cfg.monitor != nil {
+		clientOptions.SetMonitor(cfg.monitor)
+	}
 
 	// Connect to MongoDB. This does not block for server discovery.
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+		return nil, wrapErr("connect to MongoDB", err)
 	}
 
 	// Ping the database to verify a successful connection.
@@ -41,18 +150,73 @@ func NewClient(ctx context.Context, uri string) (*MongoDBClient, error) {
 	if err != nil {
 		// Disconnect if ping fails to clean up resources.
 		if disconnectErr := client.Disconnect(ctx); disconnectErr != nil {
-			log.Printf("Error disconnecting after failed ping: %v", disconnectErr)
+			slog.WarnContext(ctx, "Error disconnecting after failed ping", "error", disconnectErr)
 		}
-		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
+		return nil, wrapErr("ping MongoDB", err)
 	}
-	log.Println("Successfully connected to MongoDB!")
+	slog.InfoContext(ctx, "Successfully connected to MongoDB")
 
-	// Select the database ("flightdb") and collection ("flights") to use.
-	collection := client.Database("flightdb").Collection("flights")
+	// Select the database ("flightdb") and collections to use.
+	database := client.Database("flightdb")
+
+	// Expired jobs are deleted by MongoDB. Without the index they are only hidden, so a
+	// failure (e.g. missing privileges) is not fatal.
+	jobs := database.Collection("jobs")
+	if err := ensureJobIndexes(ctx, jobs); err != nil {
+		slog.WarnContext(ctx, "Could not create the jobs TTL index; expired jobs will not be deleted", "error", err)
+	}
+	idempotencyKeys := database.Collection("idempotency_keys")
+	if err := ensureIdempotencyIndexes(ctx, idempotencyKeys); err != nil {
+		slog.WarnContext(ctx, "Could not create the idempotency keys TTL index; expired keys will not be deleted", "error", err)
+	}
+	streams := database.Collection("streams")
+	if err := ensureStreamIndexes(ctx, streams); err != nil {
+		slog.WarnContext(ctx, "Could not create the streams TTL index; expired streams will not be deleted", "error", err)
+	}
+
+	// Query logs are looked up by request ID for the admin request snapshots.
+	queryLogs := database.Collection("query_logs")
+	if err := ensureQueryLogIndexes(ctx, queryLogs); err != nil {
+		slog.WarnContext(ctx, "Could not create the query logs request ID index; request lookups will scan the collection", "error", err)
+	}
+
+	// Shared conversations are looked up by their link's token.
+	conversations := database.Collection("conversations")
+	if err := ensureConversationIndexes(ctx, conversations); err != nil {
+		slog.WarnContext(ctx, "Could not create the conversations share index; shared conversation lookups will scan the collection", "error", err)
+	}
 
+	// Rejections are counted by client, and expired ones deleted by MongoDB. Without the indexes,
+	// counting scans the collection and expired rejections are never deleted.
+	rejections, bans := database.Collection("rejections"), database.Collection("bans")
+	if err := ensureAbuseIndexes(ctx, rejections, bans); err != nil {
+		slog.WarnContext(ctx, "Could not create the rejections and bans indexes; abuse checks will scan the collections", "error", err)
+	}
+
+	reads := options.Collection().SetReadPreference(readPref)
 	return &MongoDBClient{
 		client:     client,
-		collection: collection,
+		collection: database.Collection("flights"),
+		queryLogs:  queryLogs,
+		schedules:  database.Collection("schedules"),
+
+		conversations: conversations,
+		usage:         database.Collection("usage"),
+		jobs:          jobs,
+
+		idempotencyKeys: idempotencyKeys,
+		streams:         streams,
+		flags:           database.Collection("flags"),
+		preferences:     database.Collection("preferences"),
+
+		rejections: rejections,
+		bans:       bans,
+		migrations: database.Collection("migrations"),
+
+		flightReads:       database.Collection("flights", reads),
+		scheduleReads:     database.Collection("schedules", reads),
+		conversationReads: database.Collection("conversations", reads),
+		maxQueryTime:      cfg.MaxQueryTime,
 	}, nil
 }
 
@@ -68,7 +232,7 @@ func (m *MongoDBClient) Disconnect(ctx context.Context) error {
 	if m.client == nil {
 		return nil // No client to disconnect.
 	}
-	log.Println("Disconnecting from MongoDB...")
+	slog.InfoContext(ctx, "Disconnecting from MongoDB")
 	return m.client.Disconnect(ctx)
 }
 
@@ -86,12 +250,89 @@ func (m *MongoDBClient) InsertFlights(ctx context.Context, flights []Flight) err
 
 	_, err := m.collection.InsertMany(ctx, docs)
 	if err != nil {
-		return fmt.Errorf("failed to insert flights: %w", err)
+		return wrapErr("insert flights", err)
+	}
+	slog.InfoContext(ctx, "Inserted flight documents", "count", len(flights))
+	return nil
+}
+
+// UpsertFlights inserts or updates multiple flights in a single bulk write, keyed by flight_number.
+// Existing flights are overwritten field by field, so re-importing the same data is idempotent.
+func (m *MongoDBClient) UpsertFlights(ctx context.Context, flights []Flight) (UpsertResult, error) {
+	if len(flights) == 0 {
+		return UpsertResult{}, nil // Nothing to upsert.
+	}
+
+	// One UpdateOne model per flight; upsert creates the document when the flight number is new.
+	models := make([]mongo.WriteModel, len(flights))
+	for i, f := range flights {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"flight_number": f.FlightNumber}).
+			SetUpdate(bson.M{"$set": f}).
+			SetUpsert(true)
+	}
+
+	// Unordered writes let MongoDB apply the batch in parallel; one bad document doesn't stop the rest.
+	res, err := m.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return UpsertResult{}, wrapErr("upsert flights", err)
+	}
+	return UpsertResult{
+		Inserted: int(res.UpsertedCount),
+		Updated:  int(res.MatchedCount),
+	}, nil
+}
+
+// CreateFlight inserts a flight, returning an ErrConflict error if one with the same number exists.
+// The check and insert are a single upsert that only sets fields on insert, so concurrent creates can't both succeed.
+func (m *MongoDBClient) CreateFlight(ctx context.Context, flight Flight) error {
+	res, err := m.collection.UpdateOne(ctx,
+		bson.M{"flight_number": flight.FlightNumber},
+		bson.M{"$setOnInsert": flight},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return wrapErr("create flight "+flight.FlightNumber, err)
+	}
+	if res.MatchedCount > 0 {
+		return wrapErr("create flight "+flight.FlightNumber, ErrConflict)
 	}
-	log.Printf("Inserted %d flight documents.", len(flights))
 	return nil
 }
 
+// UpdateFlight overwrites the flight with the same number, returning an ErrNotFound error if there is none.
+func (m *MongoDBClient) UpdateFlight(ctx context.Context, flight Flight) error {
+	res, err := m.collection.UpdateOne(ctx, bson.M{"flight_number": flight.FlightNumber}, bson.M{"$set": flight})
+	if err != nil {
+		return wrapErr("update flight "+flight.FlightNumber, err)
+	}
+	if res.MatchedCount == 0 {
+		return wrapErr("update flight "+flight.FlightNumber, ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteFlight removes every flight with the given number, returning an ErrNotFound error if there is none.
+func (m *MongoDBClient) DeleteFlight(ctx context.Context, flightNumber string) error {
+	res, err := m.collection.DeleteMany(ctx, bson.M{"flight_number": flightNumber})
+	if err != nil {
+		return wrapErr("delete flight "+flightNumber, err)
+	}
+	if res.DeletedCount == 0 {
+		return wrapErr("delete flight "+flightNumber, ErrNotFound)
+	}
+	return nil
+}
+
+// GetFlight returns the flight with the given number, or an ErrNotFound error if there is none.
+func (m *MongoDBClient) GetFlight(ctx context.Context, flightNumber string) (Flight, error) {
+	var flight Flight
+	err := m.collection.FindOne(ctx, bson.M{"flight_number": flightNumber}).Decode(&flight)
+	if err != nil {
+		return Flight{}, wrapErr("get flight "+flightNumber, err)
+	}
+	return flight, nil
+}
+
 // SeedFlightData inserts some initial fictional flight data if the collection is empty.
 // This function is called once on application startup to populate the database.
 func SeedFlightData(ctx context.Context, client Client) error {
@@ -105,10 +346,10 @@ func SeedFlightData(ctx context.Context, client Client) error {
 	}
 	count, err := mongoClient.collection.CountDocuments(ctx, bson.M{})
 	if err != nil {
-		return fmt.Errorf("failed to count documents: %w", err)
+		return wrapErr("count flights", err)
 	}
 	if count > 0 {
-		log.Println("Flight data already exists. Skipping seeding.")
+		slog.InfoContext(ctx, "Flight data already exists; skipping seeding")
 		return nil
 	}
 
@@ -165,9 +406,10 @@ func SeedFlightData(ctx context.Context, client Client) error {
 	return client.InsertFlights(ctx, flights)
 }
 
-func (m *MongoDBClient) SeedFlights(ctx context.Context) error {
-	log.Println("Ensuring sample flights are present (upsert)...")
-	flights := []Flight{
+// sampleFlights returns the demo flights every backend seeds on startup. London and New York
+// have two airports each, which their flights name.
+func sampleFlights() []Flight {
+	return []Flight{
 		{
 			FlightNumber:   "FL101",
 			Origin:         "Madrid",
@@ -231,6 +473,9 @@ func (m *MongoDBClient) SeedFlights(ctx context.Context) error {
 			ArrivalTime:    "2025-08-13T17:00:00Z",
 			Price:          550.0,
 			AvailableSeats: 120,
+
+			OriginAirport:      "LHR",
+			DestinationAirport: "JFK",
 		},
 		{
 			FlightNumber:   "FL108",
@@ -240,6 +485,9 @@ func (m *MongoDBClient) SeedFlights(ctx context.Context) error {
 			ArrivalTime:    "2025-08-14T18:00:00Z",
 			Price:          540.0,
 			AvailableSeats: 110,
+
+			OriginAirport:      "EWR",
+			DestinationAirport: "LGW",
 		},
 		{
 			FlightNumber:   "FL109",
@@ -258,6 +506,8 @@ func (m *MongoDBClient) SeedFlights(ctx context.Context) error {
 			ArrivalTime:    "2025-08-16T11:30:00Z",
 			Price:          200.0,
 			AvailableSeats: 100,
+
+			OriginAirport: "LGW",
 		},
 		{
 			FlightNumber:   "FL111",
@@ -267,6 +517,8 @@ func (m *MongoDBClient) SeedFlights(ctx context.Context) error {
 			ArrivalTime:    "2025-08-16T16:30:00Z",
 			Price:          195.0,
 			AvailableSeats: 100,
+
+			DestinationAirport: "LHR",
 		},
 		{
 			FlightNumber:   "FL112",
@@ -276,6 +528,8 @@ func (m *MongoDBClient) SeedFlights(ctx context.Context) error {
 			ArrivalTime:    "2025-08-17T10:00:00Z",
 			Price:          160.0,
 			AvailableSeats: 80,
+
+			OriginAirport: "LHR",
 		},
 		{
 			FlightNumber:   "FL113",
@@ -285,6 +539,8 @@ func (m *MongoDBClient) SeedFlights(ctx context.Context) error {
 			ArrivalTime:    "2025-08-17T20:00:00Z",
 			Price:          155.0,
 			AvailableSeats: 85,
+
+			DestinationAirport: "LGW",
 		},
 		{
 			FlightNumber:   "FL114",
@@ -330,6 +586,9 @@ func (m *MongoDBClient) SeedFlights(ctx context.Context) error {
 			ArrivalTime:    "2025-08-20T12:00:00Z",
 			Price:          900.0,
 			AvailableSeats: 250,
+
+			OriginAirport:      "HND",
+			DestinationAirport: "LAX",
 		},
 		{
 			FlightNumber:   "FL119",
@@ -339,6 +598,9 @@ func (m *MongoDBClient) SeedFlights(ctx context.Context) error {
 			ArrivalTime:    "2025-08-21T13:00:00Z",
 			Price:          880.0,
 			AvailableSeats: 245,
+
+			OriginAirport:      "LAX",
+			DestinationAirport: "HND",
 		},
 		{
 			FlightNumber:   "FL120",
@@ -348,45 +610,46 @@ func (m *MongoDBClient) SeedFlights(ctx context.Context) error {
 			ArrivalTime:    "2025-08-22T18:00:00Z",
 			Price:          950.0,
 			AvailableSeats: 200,
+
+			OriginAirport:      "JFK",
+			DestinationAirport: "HND",
 		},
 	}
+}
+
+// SeedFlights upserts the sample flights and schedules so a fresh database has data to search.
+func (m *MongoDBClient) SeedFlights(ctx context.Context) error {
+	slog.InfoContext(ctx, "Ensuring sample flights are present (upsert)")
+	flights := sampleFlights()
 	for _, f := range flights {
 		filter := bson.M{"flight_number": f.FlightNumber}
 		update := bson.M{"$set": f}
 		opts := options.Update().SetUpsert(true)
 		if _, err := m.collection.UpdateOne(ctx, filter, update, opts); err != nil {
-			log.Printf("Error upserting flight %s: %v", f.FlightNumber, err)
+			slog.ErrorContext(ctx, "Error upserting flight", "flight_number", f.FlightNumber, "error", err)
+			return wrapErr("seed flight "+f.FlightNumber, err)
+		}
+	}
+	for _, s := range sampleSchedules() {
+		if err := m.UpsertSchedule(ctx, s); err != nil {
 			return err
 		}
 	}
-	log.Println("Sample flights ensured (upsert complete).")
+	slog.InfoContext(ctx, "Sample flights ensured (upsert complete)")
 	return nil
 }
 
+// SearchFlights finds flights by route and maximum price. It is shorthand for QueryFlights without dates.
 func (m *MongoDBClient) SearchFlights(ctx context.Context, origin, destination string, maxPrice float64) ([]Flight, error) {
-	// Build MongoDB filter dynamically based on provided parameters.
-	filter := bson.M{}
-	if origin != "" {
-		filter["origin"] = bson.M{"$regex": origin, "$options": "i"} // Case-insensitive match
-	}
-	if destination != "" {
-		if origin == "" {
-			// If only destination provided, search where either origin or destination matches
-			filter["$or"] = []bson.M{
-				{"destination": bson.M{"$regex": destination, "$options": "i"}},
-				{"origin": bson.M{"$regex": destination, "$options": "i"}},
-			}
-		} else {
-			filter["destination"] = bson.M{"$regex": destination, "$options": "i"}
-		}
-	}
-	// Add price filter if maxPrice is specified (> 0)
-	if maxPrice > 0 {
-		filter["price"] = bson.M{"$lte": maxPrice}
-	}
-	cur, err := m.collection.Find(ctx, filter)
+	return m.QueryFlights(ctx, FlightQuery{Origin: origin, Destination: destination, MaxPrice: maxPrice})
+}
+
+// QueryFlights returns the stored flights matching q. When q has a date filter,
+// matching schedules are expanded into dated instances and merged in, ordered by departure.
+func (m *MongoDBClient) QueryFlights(ctx context.Context, q FlightQuery) ([]Flight, error) {
+	cur, err := m.flightReads.Find(ctx, q.mongoFilter(), options.Find().SetMaxTime(m.maxQueryTime))
 	if err != nil {
-		return nil, err
+		return nil, wrapErr("search flights", err)
 	}
 	defer cur.Close(ctx)
 	var flights []Flight
@@ -396,5 +659,18 @@ func (m *MongoDBClient) SearchFlights(ctx context.Context, origin, destination s
 			flights = append(flights, f)
 		}
 	}
+	if err := cur.Err(); err != nil {
+		return nil, wrapErr("search flights", err)
+	}
+
+	if !q.hasDateFilter() {
+		return flights, nil
+	}
+	schedules, err := listSchedules(ctx, m.scheduleReads, options.Find().SetMaxTime(m.maxQueryTime))
+	if err != nil {
+		return nil, err
+	}
+	flights = append(flights, expandSchedules(schedules, q)...)
+	sortByDeparture(flights)
 	return flights, nil
 }
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newMongoTestClient connects to the MongoDB server at $MONGO_TEST_URI, skipping the test if it
+// isn't set. The server must be a disposable one: its flightdb database is dropped before and
+// after the test.
+func newMongoTestClient(t *testing.T) *MongoDBClient {
+	t.Helper()
+	return newMongoTestClientWith(t, Config{})
+}
+
+// newMongoTestClientWith is newMongoTestClient with the client's settings.
+func newMongoTestClientWith(t *testing.T, cfg Config) *MongoDBClient {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	admin, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Disconnect(ctx)
+	if err := admin.Database("flightdb").Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewClient(ctx, uri, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		m.client.Database("flightdb").Drop(ctx)
+		m.Disconnect(ctx)
+	})
+	return m
+}
+
+func TestValidReadPreference(t *testing.T) {
+	for mode, want := range map[string]bool{
+		"": true, "primary": true, "primaryPreferred": true, "secondary": true,
+		"secondaryPreferred": true, "nearest": true, "secondaries": false, "replica": false,
+	} {
+		if got := ValidReadPreference(mode); got != want {
+			t.Errorf("ValidReadPreference(%q) = %v", mode, got)
+		}
+	}
+	// A bad mode fails before connecting.
+	if _, err := NewClient(context.Background(), "mongodb://192.0.2.1:27017", Config{ReadPreference: "replica"}); err == nil || !strings.Contains(err.Error(), "read preference") {
+		t.Errorf("NewClient with a bad read preference = %v", err)
+	}
+}
+
+// sentCommand is what a test sees of a command sent to the server.
+type sentCommand struct {
+	name, collection string
+	readPreference   string // The $readPreference mode; empty if there is none
+	maxTimeMS        int64  // 0 if there is none
+}
+
+// commandRecorder records the commands sent to the flightdb database.
+type commandRecorder struct {
+	mu       sync.Mutex
+	commands []sentCommand
+}
+
+func (r *commandRecorder) monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{Started: func(_ context.Context, e *event.CommandStartedEvent) {
+		if e.DatabaseName != "flightdb" {
+			return
+		}
+		cmd := sentCommand{name: e.CommandName}
+		cmd.collection, _ = e.Command.Lookup(e.CommandName).StringValueOK()
+		cmd.readPreference, _ = e.Command.Lookup("$readPreference", "mode").StringValueOK()
+		if v, ok := e.Command.Lookup("maxTimeMS").AsInt64OK(); ok {
+			cmd.maxTimeMS = v
+		}
+		r.mu.Lock()
+		r.commands = append(r.commands, cmd)
+		r.mu.Unlock()
+	}}
+}
+
+// take returns the commands recorded since the last call.
+func (r *commandRecorder) take() []sentCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	commands := r.commands
+	r.commands = nil
+	return commands
+}
+
+func TestMongoReadRouting(t *testing.T) {
+	var recorder commandRecorder
+	m := newMongoTestClientWith(t, Config{ReadPreference: "secondaryPreferred", MaxQueryTime: 3 * time.Second, monitor: recorder.monitor()})
+	ctx := context.Background()
+	var hello bson.M
+	if err := m.client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		t.Fatal(err)
+	}
+	// A standalone server is sent no read preference, and answers every read from itself.
+	wantPreference := ""
+	if _, replicaSet := hello["setName"]; replicaSet {
+		wantPreference = "secondaryPreferred"
+	}
+	if err := m.InsertFlights(ctx, []Flight{testFlight("FL1", "Madrid", "Paris", 100)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AppendTurns(ctx, "session-1", "ip:192.0.2.1", Turn{Role: RoleUser, Content: "Flights to Paris", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	recorder.take()
+
+	// The reads made on every request are routed and bounded.
+	for name, read := range map[string]func() error{
+		"SearchFlights": func() error { _, err := m.SearchFlights(ctx, "Madrid", "Paris", 0); return err },
+		"QueryFlights": func() error {
+			_, err := m.QueryFlights(ctx, FlightQuery{Origin: "Madrid", DepartAfter: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)})
+			return err
+		},
+		"ListRoutes":        func() error { _, err := m.ListRoutes(ctx); return err },
+		"ListAirports":      func() error { _, err := m.ListAirports(ctx); return err },
+		"GetConversation":   func() error { _, err := m.GetConversation(ctx, "session-1"); return err },
+		"ListConversations": func() error { _, err := m.ListConversations(ctx, "ip:192.0.2.1", 10); return err },
+	} {
+		if err := read(); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		commands := recorder.take()
+		if len(commands) == 0 {
+			t.Errorf("%s sent no commands", name)
+		}
+		for _, cmd := range commands {
+			if cmd.readPreference != wantPreference || cmd.maxTimeMS != 3000 {
+				t.Errorf("%s sent %+v, want read preference %q and maxTimeMS 3000", name, cmd, wantPreference)
+			}
+		}
+	}
+
+	// Writes, and the other reads, go to the primary unbounded.
+	for name, op := range map[string]func() error{
+		"UpdateFlight": func() error { return m.UpdateFlight(ctx, testFlight("FL1", "Madrid", "Paris", 90)) },
+		"AppendTurns": func() error {
+			return m.AppendTurns(ctx, "session-1", "ip:192.0.2.1", Turn{Role: RoleAssistant, Content: "FL1.", Timestamp: time.Now()})
+		},
+		"ListSchedules": func() error { _, err := m.ListSchedules(ctx); return err },
+	} {
+		if err := op(); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		for _, cmd := range recorder.take() {
+			if cmd.readPreference != "" || cmd.maxTimeMS != 0 {
+				t.Errorf("%s sent %+v, want it on the primary without a limit", name, cmd)
+			}
+		}
+	}
+}
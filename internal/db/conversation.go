@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Roles of conversation turns.
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Turn is one message of a conversation.
+type Turn struct {
+	Role        string    `bson:"role" json:"role"` // RoleUser or RoleAssistant
+	Content     string    `bson:"content" json:"content"`
+	Flights     []Flight  `bson:"flights,omitempty" json:"flights,omitempty"`         // Search results shown with an assistant answer
+	RequestID   string    `bson:"request_id,omitempty" json:"request_id,omitempty"`   // The request that produced the turn
+	Regenerated bool      `bson:"regenerated,omitempty" json:"regenerated,omitempty"` // An assistant answer produced by "try again"
+	Timestamp   time.Time `bson:"timestamp" json:"timestamp"`
+}
+
+// Conversation is the transcript of one chat session, stored in the "conversations"
+// collection keyed by the client's session ID. Turns are in the order they happened.
+type Conversation struct {
+	SessionID string    `bson:"session_id" json:"session_id"`
+	Client    string    `bson:"client,omitempty" json:"-"`              // Account that started it; only it can list and rename it
+	Title     string    `bson:"title,omitempty" json:"title,omitempty"` // Generated after the first exchange, or set by the client
+	Turns     []Turn    `bson:"turns" json:"turns"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+
+	Share *ConversationShare `bson:"share,omitempty" json:"-"` // The link showing it to others, if it is shared
+}
+
+// ConversationShare is a link that shows a conversation, read-only, to anyone who has its token
+// until ExpiresAt. Only the token's SHA-256 hash is stored, so the token can't be read back from
+// the database; a conversation has at most one link, and sharing it again replaces it.
+type ConversationShare struct {
+	TokenHash string    `bson:"token_hash"` // Hex SHA-256 of the token
+	CreatedAt time.Time `bson:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// ConversationSummary describes a conversation without its turns, for session lists.
+type ConversationSummary struct {
+	SessionID string    `bson:"session_id" json:"session_id"`
+	Title     string    `bson:"title" json:"title"`
+	TurnCount int       `bson:"turn_count" json:"turn_count"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// LastTurn returns the most recent turn with the given role.
+func (c Conversation) LastTurn(role string) (Turn, bool) {
+	for i := len(c.Turns) - 1; i >= 0; i-- {
+		if c.Turns[i].Role == role {
+			return c.Turns[i], true
+		}
+	}
+	return Turn{}, false
+}
+
+// copy returns a copy of c that shares none of its slices or pointers.
+func (c Conversation) copy() Conversation {
+	c.Turns = append([]Turn(nil), c.Turns...)
+	if c.Share != nil {
+		share := *c.Share
+		c.Share = &share
+	}
+	return c
+}
+
+// AppendTurns adds turns to the end of a session's conversation, creating it if needed; a new
+// conversation belongs to client. The update is a single atomic upsert, so concurrent appends
+// never lose turns.
+func (m *MongoDBClient) AppendTurns(ctx context.Context, sessionID, client string, turns ...Turn) error {
+	if len(turns) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	update := bson.M{
+		"$push":        bson.M{"turns": bson.M{"$each": turns}},
+		"$set":         bson.M{"updated_at": now},
+		"$setOnInsert": bson.M{"created_at": now, "client": client},
+	}
+	_, err := m.conversations.UpdateOne(ctx, bson.M{"session_id": sessionID}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return wrapErr("append turns to conversation "+sessionID, err)
+	}
+	return nil
+}
+
+// GetConversation returns a session's conversation, or an ErrNotFound error if it has none.
+func (m *MongoDBClient) GetConversation(ctx context.Context, sessionID string) (Conversation, error) {
+	var conv Conversation
+	opts := options.FindOne().SetMaxTime(m.maxQueryTime)
+	if err := m.conversationReads.FindOne(ctx, bson.M{"session_id": sessionID}, opts).Decode(&conv); err != nil {
+		return Conversation{}, wrapErr("get conversation "+sessionID, err)
+	}
+	return conv, nil
+}
+
+// ensureConversationIndexes creates the index GetSharedConversation looks shared conversations
+// up by. It is sparse, so conversations that were never shared are left out of it.
+func ensureConversationIndexes(ctx context.Context, conversations *mongo.Collection) error {
+	_, err := conversations.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "share.token_hash", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return wrapErr("create conversations share index", err)
+}
+
+// ShareConversation sets the share link of client's conversation sessionID, replacing the one
+// it had, if any. It returns an ErrNotFound error when client has no such conversation.
+func (m *MongoDBClient) ShareConversation(ctx context.Context, sessionID, client string, share ConversationShare) error {
+	res, err := m.conversations.UpdateOne(ctx, bson.M{"session_id": sessionID, "client": client}, bson.M{"$set": bson.M{"share": share}})
+	if err != nil {
+		return wrapErr("share conversation "+sessionID, err)
+	}
+	if res.MatchedCount == 0 {
+		return wrapErr("share conversation "+sessionID, ErrNotFound)
+	}
+	return nil
+}
+
+// UnshareConversation removes the share link of client's conversation sessionID. It returns an
+// ErrNotFound error when client has no such conversation or it isn't shared.
+func (m *MongoDBClient) UnshareConversation(ctx context.Context, sessionID, client string) error {
+	filter := bson.M{"session_id": sessionID, "client": client, "share": bson.M{"$exists": true}}
+	res, err := m.conversations.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"share": ""}})
+	if err != nil {
+		return wrapErr("unshare conversation "+sessionID, err)
+	}
+	if res.MatchedCount == 0 {
+		return wrapErr("unshare conversation "+sessionID, ErrNotFound)
+	}
+	return nil
+}
+
+// GetSharedConversation returns the conversation whose share link has the token with hash
+// tokenHash, or an ErrNotFound error if there is none or the link expired before now. It reads
+// from the primary, so a link works as soon as it is created and stops when it is removed.
+func (m *MongoDBClient) GetSharedConversation(ctx context.Context, tokenHash string, now time.Time) (Conversation, error) {
+	var conv Conversation
+	filter := bson.M{"share.token_hash": tokenHash, "share.expires_at": bson.M{"$gt": now}}
+	if err := m.conversations.FindOne(ctx, filter, options.FindOne().SetMaxTime(m.maxQueryTime)).Decode(&conv); err != nil {
+		return Conversation{}, wrapErr("get shared conversation", err)
+	}
+	return conv, nil
+}
+
+// SetConversationTitle sets the title of client's conversation sessionID. Unless overwrite is
+// set, a conversation that already has a title keeps it, so a generated title never replaces
+// one the user chose. It returns an ErrNotFound error when no conversation was changed.
+func (m *MongoDBClient) SetConversationTitle(ctx context.Context, sessionID, client, title string, overwrite bool) error {
+	filter := bson.M{"session_id": sessionID, "client": client}
+	if !overwrite {
+		filter["title"] = bson.M{"$in": bson.A{nil, ""}} // Matches a missing title too.
+	}
+	res, err := m.conversations.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"title": title}})
+	if err != nil {
+		return wrapErr("set title of conversation "+sessionID, err)
+	}
+	if res.MatchedCount == 0 {
+		return wrapErr("set title of conversation "+sessionID, ErrNotFound)
+	}
+	return nil
+}
+
+// ListConversations returns client's conversations, most recently updated first, at most limit.
+func (m *MongoDBClient) ListConversations(ctx context.Context, client string, limit int) ([]ConversationSummary, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"client": client}}},
+		{{Key: "$sort", Value: bson.D{{Key: "updated_at", Value: -1}, {Key: "session_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$project", Value: bson.M{
+			"_id": 0, "session_id": 1, "title": 1, "created_at": 1, "updated_at": 1,
+			"turn_count": bson.M{"$size": "$turns"},
+		}}},
+	}
+	cur, err := m.conversationReads.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(m.maxQueryTime))
+	if err != nil {
+		return nil, wrapErr("list conversations", err)
+	}
+	summaries := []ConversationSummary{}
+	if err := cur.All(ctx, &summaries); err != nil {
+		return nil, wrapErr("decode conversations", err)
+	}
+	return summaries, nil
+}
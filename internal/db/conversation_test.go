@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// checkShares checks a backend's conversation share links.
+func checkShares(t *testing.T, c Client) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, conv := range []struct{ sessionID, client string }{{"trip-1", "key:one"}, {"trip-2", "key:two"}} {
+		if err := c.AppendTurns(ctx, conv.sessionID, conv.client, Turn{Role: RoleUser, Content: "Flights from " + conv.sessionID, Timestamp: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	share := ConversationShare{TokenHash: "hash-1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+
+	// Only the owner can share a conversation.
+	if err := c.ShareConversation(ctx, "trip-1", "key:two", share); !errors.Is(err, ErrNotFound) {
+		t.Errorf("sharing another client's conversation = %v, want ErrNotFound", err)
+	}
+	if err := c.ShareConversation(ctx, "trip-9", "key:one", share); !errors.Is(err, ErrNotFound) {
+		t.Errorf("sharing an unknown conversation = %v, want ErrNotFound", err)
+	}
+	if err := c.ShareConversation(ctx, "trip-1", "key:one", share); err != nil {
+		t.Fatal(err)
+	}
+
+	// The link finds its conversation, and only it, until it expires.
+	got, err := c.GetSharedConversation(ctx, "hash-1", now.Add(time.Minute))
+	if err != nil || got.SessionID != "trip-1" || len(got.Turns) != 1 || got.Share == nil || !got.Share.ExpiresAt.Equal(share.ExpiresAt) {
+		t.Errorf("GetSharedConversation = %+v, %v", got, err)
+	}
+	if _, err := c.GetSharedConversation(ctx, "hash-1", share.ExpiresAt); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetSharedConversation once expired = %v, want ErrNotFound", err)
+	}
+	if _, err := c.GetSharedConversation(ctx, "hash-2", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetSharedConversation of an unknown token = %v, want ErrNotFound", err)
+	}
+
+	// Sharing again replaces the link.
+	if err := c.ShareConversation(ctx, "trip-1", "key:one", ConversationShare{TokenHash: "hash-2", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetSharedConversation(ctx, "hash-1", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("the replaced link = %v, want ErrNotFound", err)
+	}
+	if got, err := c.GetSharedConversation(ctx, "hash-2", now); err != nil || got.SessionID != "trip-1" {
+		t.Errorf("the new link = %+v, %v", got, err)
+	}
+
+	// Unsharing removes the link, and only the owner can.
+	if err := c.UnshareConversation(ctx, "trip-1", "key:two"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unsharing another client's conversation = %v, want ErrNotFound", err)
+	}
+	if err := c.UnshareConversation(ctx, "trip-1", "key:one"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetSharedConversation(ctx, "hash-2", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("the removed link = %v, want ErrNotFound", err)
+	}
+	if err := c.UnshareConversation(ctx, "trip-1", "key:one"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unsharing again = %v, want ErrNotFound", err)
+	}
+	if err := c.UnshareConversation(ctx, "trip-2", "key:two"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unsharing a conversation never shared = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryShares(t *testing.T) {
+	checkShares(t, NewMemoryClient())
+}
+
+func TestMongoShares(t *testing.T) {
+	checkShares(t, newMongoTestClient(t))
+}
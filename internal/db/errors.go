@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Error kinds returned (wrapped) by every Client implementation. Callers should test for them
+// with errors.Is rather than inspecting driver errors, e.g. errors.Is(err, db.ErrNotFound).
+var (
+	// ErrNotFound means the requested document does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the write clashed with existing data (e.g. a duplicate key).
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable means the database could not be reached in time; retrying later may succeed.
+	ErrUnavailable = errors.New("database unavailable")
+)
+
+// OpError records which operation failed, the error kind (one of the Err* values, or nil when
+// the failure doesn't fit any of them) and the underlying cause. errors.Is matches both the kind
+// and anything in the cause's chain, so context.DeadlineExceeded is still visible to callers.
+type OpError struct {
+	Op   string // e.g. "search flights"
+	Kind error
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	if e.Kind != nil && !errors.Is(e.Err, e.Kind) {
+		return fmt.Sprintf("failed to %s: %v: %v", e.Op, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("failed to %s: %v", e.Op, e.Err)
+}
+
+// Unwrap exposes both the kind and the cause to errors.Is / errors.As.
+func (e *OpError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// wrapErr wraps err in an OpError for op, classifying driver and context errors into an error kind.
+// It returns nil for a nil err.
+func wrapErr(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &OpError{Op: op, Kind: classify(err), Err: err}
+}
+
+// classify maps an error onto ErrNotFound, ErrConflict or ErrUnavailable, or nil if none applies.
+func classify(err error) error {
+	for _, kind := range []error{ErrNotFound, ErrConflict, ErrUnavailable} {
+		if errors.Is(err, kind) {
+			return kind // Already classified further down the chain.
+		}
+	}
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return ErrConflict
+	case errors.Is(err, context.DeadlineExceeded),
+		mongo.IsTimeout(err),
+		mongo.IsNetworkError(err),
+		errors.Is(err, mongo.ErrClientDisconnected):
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// checkContext gives in-process backends the same behaviour as the driver for
+// cancelled or expired contexts, so callers see identical error kinds on every backend.
+func checkContext(ctx context.Context, op string) error {
+	return wrapErr(op, ctx.Err())
+}
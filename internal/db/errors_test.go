@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestWrapErrKinds(t *testing.T) {
+	duplicate := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	for _, tt := range []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"no documents", mongo.ErrNoDocuments, ErrNotFound},
+		{"duplicate key", duplicate, ErrConflict},
+		{"deadline", context.DeadlineExceeded, ErrUnavailable},
+		{"deadline, wrapped by the driver", fmt.Errorf("server selection error: %w", context.DeadlineExceeded), ErrUnavailable},
+		{"disconnected", mongo.ErrClientDisconnected, ErrUnavailable},
+		{"already classified", fmt.Errorf("lookup: %w", ErrNotFound), ErrNotFound},
+		{"cancelled", context.Canceled, nil},
+		{"anything else", errors.New("bad query"), nil},
+	} {
+		err := wrapErr("search flights", tt.err)
+		var op *OpError
+		if !errors.As(err, &op) || op.Op != "search flights" || op.Kind != tt.kind {
+			t.Errorf("%s: wrapErr = %#v, want kind %v", tt.name, err, tt.kind)
+			continue
+		}
+		if op.Err.Error() != tt.err.Error() {
+			t.Errorf("%s: cause = %v, want %v", tt.name, op.Err, tt.err)
+		}
+		if tt.kind != nil && !errors.Is(err, tt.kind) {
+			t.Errorf("%s: errors.Is(%v, %v) = false", tt.name, err, tt.kind)
+		}
+		for _, other := range []error{ErrNotFound, ErrConflict, ErrUnavailable} {
+			if other != tt.kind && errors.Is(err, other) {
+				t.Errorf("%s: %v is also %v", tt.name, err, other)
+			}
+		}
+	}
+	if wrapErr("search flights", nil) != nil {
+		t.Error("wrapErr(nil) isn't nil")
+	}
+}
+
+func TestOpErrorMessage(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want string
+	}{
+		{wrapErr("get flight IB101", ErrNotFound), "failed to get flight IB101: not found"},
+		{wrapErr("search flights", context.DeadlineExceeded), "failed to search flights: database unavailable: context deadline exceeded"},
+		{wrapErr("search flights", errors.New("bad query")), "failed to search flights: bad query"},
+	} {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// expiredContext returns a context whose deadline has passed.
+func expiredContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// checkErrorKinds checks the error kinds c returns for a missing flight, a duplicate one, and
+// a database that doesn't answer in time.
+func checkErrorKinds(t *testing.T, c Client) {
+	t.Helper()
+	ctx := context.Background()
+	flight := testFlight("IB101", "Madrid", "Paris", 100)
+	if err := c.CreateFlight(ctx, flight); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateFlight(ctx, flight); !errors.Is(err, ErrConflict) {
+		t.Errorf("duplicate CreateFlight err = %v, want ErrConflict", err)
+	}
+	if _, err := c.GetFlight(ctx, "XX999"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetFlight(missing) err = %v, want ErrNotFound", err)
+	}
+	if err := c.UpdateFlight(ctx, testFlight("XX999", "Madrid", "Paris", 100)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateFlight(missing) err = %v, want ErrNotFound", err)
+	}
+	if err := c.DeleteFlight(ctx, "XX999"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteFlight(missing) err = %v, want ErrNotFound", err)
+	}
+
+	_, err := c.QueryFlights(expiredContext(t), FlightQuery{Origin: "Madrid"})
+	if !errors.Is(err, ErrUnavailable) || !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "flights") {
+		t.Errorf("QueryFlights on an expired context err = %v, want ErrUnavailable naming the operation", err)
+	}
+	if _, err := c.GetFlight(expiredContext(t), "IB101"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("GetFlight on an expired context err = %v, want ErrUnavailable", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.QueryFlights(cancelled, FlightQuery{Origin: "Madrid"})
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrNotFound) {
+		t.Errorf("QueryFlights on a cancelled context err = %v, want context.Canceled", err)
+	}
+}
+
+func TestMemoryErrorKinds(t *testing.T) {
+	checkErrorKinds(t, NewMemoryClient())
+}
+
+func TestMongoErrorKinds(t *testing.T) {
+	checkErrorKinds(t, newMongoTestClient(t))
+}
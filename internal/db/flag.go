@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Flag is a feature flag's rule as overridden at runtime, stored in the "flags" collection
+// keyed by the flag's name. The server polls the collection, so a change reaches every
+// replica without a restart; see package flags for how rules are evaluated.
+type Flag struct {
+	Name      string    `bson:"_id" json:"name"`
+	Enabled   bool      `bson:"enabled" json:"enabled"`
+	Percent   float64   `bson:"percent" json:"percent"`
+	Clients   []string  `bson:"clients,omitempty" json:"clients,omitempty"`
+	Sessions  []string  `bson:"sessions,omitempty" json:"sessions,omitempty"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// ListFlags returns the stored flag overrides, ordered by name.
+func (m *MongoDBClient) ListFlags(ctx context.Context) ([]Flag, error) {
+	cur, err := m.flags.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, wrapErr("list flags", err)
+	}
+	flags := []Flag{}
+	if err := cur.All(ctx, &flags); err != nil {
+		return nil, wrapErr("decode flags", err)
+	}
+	return flags, nil
+}
+
+// SaveFlag stores flag, replacing the stored override of the same name if there is one.
+func (m *MongoDBClient) SaveFlag(ctx context.Context, flag Flag) error {
+	_, err := m.flags.ReplaceOne(ctx, bson.M{"_id": flag.Name}, flag, options.Replace().SetUpsert(true))
+	return wrapErr("save flag "+flag.Name, err)
+}
+
+// DeleteFlag removes the override of the named flag, returning an ErrNotFound error if there is none.
+func (m *MongoDBClient) DeleteFlag(ctx context.Context, name string) error {
+	res, err := m.flags.DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return wrapErr("delete flag "+name, err)
+	}
+	if res.DeletedCount == 0 {
+		return wrapErr("delete flag "+name, ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// checkFlags checks storing, replacing and deleting flag overrides.
+func checkFlags(t *testing.T, c Client) {
+	ctx := context.Background()
+	if flags, err := c.ListFlags(ctx); err != nil || len(flags) != 0 {
+		t.Fatalf("ListFlags = %v, %v, want none", flags, err)
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for _, flag := range []Flag{
+		{Name: "route_phrasing", Percent: 10, Clients: []string{"key:aaaa"}, UpdatedAt: now},
+		{Name: "db_only", Enabled: true, UpdatedAt: now},
+		{Name: "route_phrasing", Percent: 25, Sessions: []string{"session-1"}, UpdatedAt: now},
+	} {
+		if err := c.SaveFlag(ctx, flag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flags, err := c.ListFlags(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flags) != 2 || flags[0].Name != "db_only" || !flags[0].Enabled ||
+		flags[1].Name != "route_phrasing" || flags[1].Percent != 25 || len(flags[1].Clients) != 0 ||
+		!slices.Equal(flags[1].Sessions, []string{"session-1"}) || !flags[1].UpdatedAt.Equal(now) {
+		t.Errorf("flags %+v", flags)
+	}
+
+	if err := c.DeleteFlag(ctx, "db_only"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteFlag(ctx, "db_only"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second delete: %v, want ErrNotFound", err)
+	}
+	if flags, err := c.ListFlags(ctx); err != nil || len(flags) != 1 || flags[0].Name != "route_phrasing" {
+		t.Errorf("after delete: %+v, %v", flags, err)
+	}
+}
+
+func TestMemoryFlags(t *testing.T) {
+	checkFlags(t, NewMemoryClient())
+}
+
+func TestMongoFlags(t *testing.T) {
+	checkFlags(t, newMongoTestClient(t))
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Idempotency record states.
+const (
+	IdempotencyRunning = "running"
+	IdempotencyDone    = "done"
+)
+
+// IdempotencyRecord remembers a chat request sent with an idempotency key, so a retry with the
+// same key is answered with the original request's events instead of running it again. Records
+// are stored in the "idempotency_keys" collection and removed once ExpiresAt has passed.
+type IdempotencyRecord struct {
+	ID          string        `bson:"_id"`                  // Hash of the client and its key
+	RequestHash string        `bson:"request_hash"`         // Hash of the request, so a key reused for another request is refused
+	SessionID   string        `bson:"session_id,omitempty"` // The request's session, so DeleteSessionData finds its events
+	StreamID    string        `bson:"stream_id,omitempty"`
+	Status      string        `bson:"status"`           // IdempotencyRunning or IdempotencyDone
+	Events      []StoredEvent `bson:"events,omitempty"` // The request's events, once it is done
+	CreatedAt   time.Time     `bson:"created_at"`
+	ExpiresAt   time.Time     `bson:"expires_at"`
+}
+
+// StoredEvent is a stream event as stored for replay. Payload is the event's structured data
+// encoded as JSON, which is how clients receive it.
+type StoredEvent struct {
+	Type      string    `bson:"type"`
+	Data      string    `bson:"data"`
+	Payload   []byte    `bson:"payload,omitempty"`
+	Seq       int64     `bson:"seq"`
+	Timestamp time.Time `bson:"ts"`
+}
+
+// ensureIdempotencyIndexes creates the TTL index that makes MongoDB delete expired records.
+func ensureIdempotencyIndexes(ctx context.Context, keys *mongo.Collection) error {
+	_, err := keys.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return wrapErr("create idempotency keys TTL index", err)
+}
+
+// CreateIdempotencyKey stores a new record, or returns an ErrConflict error if an unexpired
+// record with its ID exists. The insert is atomic, so of concurrent requests with the same
+// key exactly one creates the record.
+func (m *MongoDBClient) CreateIdempotencyKey(ctx context.Context, record IdempotencyRecord) error {
+	// An expired record the TTL monitor hasn't removed yet must not block the key.
+	expired := bson.M{"_id": record.ID, "expires_at": bson.M{"$lte": time.Now().UTC()}}
+	if _, err := m.idempotencyKeys.DeleteOne(ctx, expired); err != nil {
+		return wrapErr("create idempotency key", err)
+	}
+	_, err := m.idempotencyKeys.InsertOne(ctx, record)
+	return wrapErr("create idempotency key", err)
+}
+
+// GetIdempotencyKey returns the record with the given ID, or an ErrNotFound error if there is
+// none or it has expired.
+func (m *MongoDBClient) GetIdempotencyKey(ctx context.Context, id string) (IdempotencyRecord, error) {
+	var record IdempotencyRecord
+	filter := bson.M{"_id": id, "expires_at": bson.M{"$gt": time.Now().UTC()}}
+	if err := m.idempotencyKeys.FindOne(ctx, filter).Decode(&record); err != nil {
+		return IdempotencyRecord{}, wrapErr("get idempotency key", err)
+	}
+	return record, nil
+}
+
+// SaveIdempotencyKey stores record, replacing the stored version if there is one.
+func (m *MongoDBClient) SaveIdempotencyKey(ctx context.Context, record IdempotencyRecord) error {
+	_, err := m.idempotencyKeys.ReplaceOne(ctx, bson.M{"_id": record.ID}, record, options.Replace().SetUpsert(true))
+	return wrapErr("save idempotency key", err)
+}
+
+// DeleteIdempotencyKey removes the record with the given ID, if there is one.
+func (m *MongoDBClient) DeleteIdempotencyKey(ctx context.Context, id string) error {
+	_, err := m.idempotencyKeys.DeleteOne(ctx, bson.M{"_id": id})
+	return wrapErr("delete idempotency key", err)
+}
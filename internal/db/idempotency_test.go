@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// checkIdempotencyKeys claims a key from concurrent requests, completes it, and lets it expire.
+func checkIdempotencyKeys(t *testing.T, c Client) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	record := IdempotencyRecord{ID: "client/key-1", RequestHash: "h1", Status: IdempotencyRunning, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+
+	// Of concurrent requests with the same key, exactly one creates the record.
+	var created, conflicts atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch err := c.CreateIdempotencyKey(ctx, record); {
+			case err == nil:
+				created.Add(1)
+			case errors.Is(err, ErrConflict):
+				conflicts.Add(1)
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if created.Load() != 1 || conflicts.Load() != 9 {
+		t.Errorf("%d created and %d conflicts, want 1 and 9", created.Load(), conflicts.Load())
+	}
+
+	// The finished request's events are stored for replay.
+	record.Status = IdempotencyDone
+	record.StreamID = "s1"
+	record.Events = []StoredEvent{{Type: "Started", Data: "s1", Seq: 1, Timestamp: now}, {Type: "Done", Data: "ok", Payload: []byte(`{"outcome":"ok"}`), Seq: 2, Timestamp: now}}
+	if err := c.SaveIdempotencyKey(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetIdempotencyKey(ctx, record.ID)
+	if err != nil || got.Status != IdempotencyDone || got.StreamID != "s1" || len(got.Events) != 2 || string(got.Events[1].Payload) != `{"outcome":"ok"}` {
+		t.Errorf("GetIdempotencyKey = %+v, %v", got, err)
+	}
+
+	// An expired record is gone for readers and doesn't block the key.
+	expired := IdempotencyRecord{ID: "client/key-2", RequestHash: "h2", Status: IdempotencyDone, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+	if err := c.SaveIdempotencyKey(ctx, expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetIdempotencyKey(ctx, expired.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired record: %v, want ErrNotFound", err)
+	}
+	expired.ExpiresAt = now.Add(time.Hour)
+	if err := c.CreateIdempotencyKey(ctx, expired); err != nil {
+		t.Errorf("claiming an expired key: %v", err)
+	}
+
+	if err := c.DeleteIdempotencyKey(ctx, record.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetIdempotencyKey(ctx, record.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted record: %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryIdempotencyKeys(t *testing.T) {
+	checkIdempotencyKeys(t, NewMemoryClient())
+}
+
+func TestMongoIdempotencyKeys(t *testing.T) {
+	checkIdempotencyKeys(t, newMongoTestClient(t))
+}
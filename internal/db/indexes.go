@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IndexChecker is implemented by backends with indexes the server relies on, so a preflight
+// check can confirm they exist.
+type IndexChecker interface {
+	// CheckIndexes returns an error naming the missing indexes, if any.
+	CheckIndexes(ctx context.Context) error
+}
+
+// CheckIndexes confirms the TTL indexes NewClient creates exist. Creating them only logs a
+// warning on failure (e.g. missing privileges), and without them expired jobs, idempotency
+// keys, streams and rejections are hidden but never deleted.
+func (m *MongoDBClient) CheckIndexes(ctx context.Context) error {
+	var missing []string
+	for _, c := range []struct {
+		name string
+		coll *mongo.Collection
+	}{{"jobs", m.jobs}, {"idempotency_keys", m.idempotencyKeys}, {"streams", m.streams}, {"rejections", m.rejections}} {
+		ok, err := hasTTLIndex(ctx, c.coll, "expires_at")
+		if err != nil {
+			return wrapErr("list "+c.name+" indexes", err)
+		}
+		if !ok {
+			missing = append(missing, c.name+".expires_at (TTL)")
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing indexes: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// hasTTLIndex reports whether coll has a TTL index on field alone.
+func hasTTLIndex(ctx context.Context, coll *mongo.Collection, field string) (bool, error) {
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return false, err
+	}
+	var indexes []struct {
+		Key                bson.D `bson:"key"`
+		ExpireAfterSeconds *int32 `bson:"expireAfterSeconds"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return false, err
+	}
+	for _, index := range indexes {
+		if len(index.Key) == 1 && index.Key[0].Key == field && index.ExpireAfterSeconds != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
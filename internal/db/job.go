@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Job states.
+const (
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is an asynchronous chat request, whose progress and answer go to a callback URL instead
+// of an open connection. Jobs are stored in the "jobs" collection keyed by their ID and
+// removed once ExpiresAt has passed.
+type Job struct {
+	ID          string      `bson:"_id" json:"job_id"`
+	Client      string      `bson:"client" json:"-"` // Account that started it; only it can poll it
+	SessionID   string      `bson:"session_id,omitempty" json:"session_id,omitempty"`
+	CallbackURL string      `bson:"callback_url" json:"callback_url"`
+	Status      string      `bson:"status" json:"status"`                   // JobRunning, JobDone, JobFailed or JobCancelled
+	Phase       string      `bson:"phase,omitempty" json:"phase,omitempty"` // Latest progress message while running
+	Answer      string      `bson:"answer,omitempty" json:"answer,omitempty"`
+	Flights     []Flight    `bson:"flights,omitempty" json:"flights,omitempty"`
+	Error       string      `bson:"error,omitempty" json:"error,omitempty"`
+	Callback    JobCallback `bson:"callback" json:"callback"`
+	CreatedAt   time.Time   `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time   `bson:"updated_at" json:"updated_at"`
+	ExpiresAt   time.Time   `bson:"expires_at" json:"expires_at"`
+}
+
+// JobCallback is the delivery state of a job's final callback.
+type JobCallback struct {
+	Delivered bool   `bson:"delivered" json:"delivered"`
+	Attempts  int    `bson:"attempts" json:"attempts"`
+	LastError string `bson:"last_error,omitempty" json:"last_error,omitempty"`
+}
+
+// ensureJobIndexes creates the TTL index that makes MongoDB delete jobs once they expire.
+// Creating an existing index is a no-op, so it runs at every start.
+func ensureJobIndexes(ctx context.Context, jobs *mongo.Collection) error {
+	_, err := jobs.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return wrapErr("create jobs TTL index", err)
+}
+
+// SaveJob stores job, replacing the stored version if there is one.
+func (m *MongoDBClient) SaveJob(ctx context.Context, job Job) error {
+	_, err := m.jobs.ReplaceOne(ctx, bson.M{"_id": job.ID}, job, options.Replace().SetUpsert(true))
+	return wrapErr("save job "+job.ID, err)
+}
+
+// GetJob returns the job with the given ID, or an ErrNotFound error if there is none or it
+// has expired. MongoDB's TTL monitor runs about once a minute, so expiry is checked here too.
+func (m *MongoDBClient) GetJob(ctx context.Context, id string) (Job, error) {
+	var job Job
+	filter := bson.M{"_id": id, "expires_at": bson.M{"$gt": time.Now().UTC()}}
+	if err := m.jobs.FindOne(ctx, filter).Decode(&job); err != nil {
+		return Job{}, wrapErr("get job "+id, err)
+	}
+	return job, nil
+}
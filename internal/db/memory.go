@@ -0,0 +1,820 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryClient implements the Client interface with in-process data structures.
+// It needs no running MongoDB, which makes it handy for local development (DB_BACKEND=memory) and tests.
+// All data is lost when the process exits.
+type MemoryClient struct {
+	pollInterval time.Duration // How often Watch polls for flight changes; replaced in tests
+
+	mu        sync.RWMutex
+	flights   []Flight                  // Flights in insertion order, mirroring MongoDB's natural order
+	byNumber  map[string]int            // flight_number -> index into flights
+	schedules map[string]FlightSchedule // flight_number -> recurring schedule
+	version   int64                     // Bumped on every flight or schedule write; polled by Watch
+	queryLogs []QueryLog
+
+	conversations map[string]*Conversation // session_id -> transcript
+	usage         map[string]*Usage        // usageDocID -> daily usage
+	jobs          map[string]Job           // ID -> asynchronous request
+
+	idempotencyKeys map[string]IdempotencyRecord // ID -> request sent with an idempotency key
+	streams         map[string]SharedStream      // ID -> request stream shared between replicas
+	flags           map[string]Flag              // name -> feature flag override
+	preferences     map[string]Preferences       // session_id -> remembered defaults
+
+	rejections []Rejection    // Unexpired, oldest first
+	bans       map[string]Ban // ID -> ban, current or past
+
+	migrations    map[int]AppliedMigration // version -> applied migration
+	migrationLock migrationLock
+}
+
+// NewMemoryClient creates an empty in-memory database.
+func NewMemoryClient() *MemoryClient {
+	return &MemoryClient{
+		pollInterval: pollInterval,
+
+		byNumber:  make(map[string]int),
+		schedules: make(map[string]FlightSchedule),
+
+		conversations: make(map[string]*Conversation),
+		usage:         make(map[string]*Usage),
+		jobs:          make(map[string]Job),
+
+		idempotencyKeys: make(map[string]IdempotencyRecord),
+		streams:         make(map[string]SharedStream),
+		flags:           make(map[string]Flag),
+		preferences:     make(map[string]Preferences),
+
+		bans: make(map[string]Ban),
+
+		migrations: make(map[int]AppliedMigration),
+	}
+}
+
+// Connect is part of the Client interface; there is nothing to connect to.
+func (m *MemoryClient) Connect(ctx context.Context, uri string) error {
+	return nil
+}
+
+// Disconnect is part of the Client interface; there is nothing to close.
+func (m *MemoryClient) Disconnect(ctx context.Context) error {
+	return nil
+}
+
+// InsertFlights appends flights without checking for duplicates, like MongoDB's InsertMany.
+// A later flight with the same number shadows the earlier one in lookups by number.
+func (m *MemoryClient) InsertFlights(ctx context.Context, flights []Flight) error {
+	if err := checkContext(ctx, "insert flights"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.version++
+	for _, f := range flights {
+		m.byNumber[f.FlightNumber] = len(m.flights)
+		m.flights = append(m.flights, f)
+	}
+	return nil
+}
+
+// UpsertFlights inserts new flights and overwrites existing ones, keyed by flight number.
+func (m *MemoryClient) UpsertFlights(ctx context.Context, flights []Flight) (UpsertResult, error) {
+	if err := checkContext(ctx, "upsert flights"); err != nil {
+		return UpsertResult{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.version++
+	var res UpsertResult
+	for _, f := range flights {
+		if i, ok := m.byNumber[f.FlightNumber]; ok {
+			m.flights[i] = f
+			res.Updated++
+			continue
+		}
+		m.byNumber[f.FlightNumber] = len(m.flights)
+		m.flights = append(m.flights, f)
+		res.Inserted++
+	}
+	return res, nil
+}
+
+// CreateFlight inserts a flight, returning an ErrConflict error if one with the same number exists.
+func (m *MemoryClient) CreateFlight(ctx context.Context, flight Flight) error {
+	if err := checkContext(ctx, "create flight "+flight.FlightNumber); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.byNumber[flight.FlightNumber]; ok {
+		return wrapErr("create flight "+flight.FlightNumber, ErrConflict)
+	}
+	m.version++
+	m.byNumber[flight.FlightNumber] = len(m.flights)
+	m.flights = append(m.flights, flight)
+	return nil
+}
+
+// UpdateFlight overwrites the flight with the same number, returning an ErrNotFound error if there is none.
+func (m *MemoryClient) UpdateFlight(ctx context.Context, flight Flight) error {
+	if err := checkContext(ctx, "update flight "+flight.FlightNumber); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.byNumber[flight.FlightNumber]
+	if !ok {
+		return wrapErr("update flight "+flight.FlightNumber, ErrNotFound)
+	}
+	m.version++
+	m.flights[i] = flight
+	return nil
+}
+
+// DeleteFlight removes every flight with the given number (InsertFlights allows duplicates),
+// returning an ErrNotFound error if there is none.
+func (m *MemoryClient) DeleteFlight(ctx context.Context, flightNumber string) error {
+	if err := checkContext(ctx, "delete flight "+flightNumber); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.byNumber[flightNumber]; !ok {
+		return wrapErr("delete flight "+flightNumber, ErrNotFound)
+	}
+	m.version++
+	kept := m.flights[:0]
+	for _, f := range m.flights {
+		if f.FlightNumber != flightNumber {
+			kept = append(kept, f)
+		}
+	}
+	m.flights = kept
+	m.byNumber = make(map[string]int, len(kept))
+	for i, f := range kept {
+		m.byNumber[f.FlightNumber] = i
+	}
+	return nil
+}
+
+// GetFlight returns the flight with the given number, or an ErrNotFound error if there is none.
+func (m *MemoryClient) GetFlight(ctx context.Context, flightNumber string) (Flight, error) {
+	if err := checkContext(ctx, "get flight "+flightNumber); err != nil {
+		return Flight{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i, ok := m.byNumber[flightNumber]
+	if !ok {
+		return Flight{}, wrapErr("get flight "+flightNumber, ErrNotFound)
+	}
+	return m.flights[i], nil
+}
+
+// SeedFlights loads the sample flights and schedules (upserting, so repeated calls don't duplicate data).
+func (m *MemoryClient) SeedFlights(ctx context.Context) error {
+	res, err := m.UpsertFlights(ctx, sampleFlights())
+	if err != nil {
+		return err
+	}
+	for _, s := range sampleSchedules() {
+		if err := m.UpsertSchedule(ctx, s); err != nil {
+			return err
+		}
+	}
+	slog.InfoContext(ctx, "Sample flights ensured in memory", "inserted", res.Inserted, "updated", res.Updated)
+	return nil
+}
+
+// SearchFlights finds flights by route and maximum price. It is shorthand for QueryFlights without dates.
+func (m *MemoryClient) SearchFlights(ctx context.Context, origin, destination string, maxPrice float64) ([]Flight, error) {
+	return m.QueryFlights(ctx, FlightQuery{Origin: origin, Destination: destination, MaxPrice: maxPrice})
+}
+
+// QueryFlights mirrors MongoDBClient.QueryFlights, including schedule expansion for date-filtered queries.
+func (m *MemoryClient) QueryFlights(ctx context.Context, q FlightQuery) ([]Flight, error) {
+	if err := checkContext(ctx, "search flights"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var flights []Flight
+	for _, f := range m.flights {
+		if q.matches(f) {
+			flights = append(flights, f)
+		}
+	}
+
+	if q.hasDateFilter() {
+		schedules := make([]FlightSchedule, 0, len(m.schedules))
+		for _, s := range m.schedules {
+			schedules = append(schedules, s)
+		}
+		flights = append(flights, expandSchedules(schedules, q)...)
+		sortByDeparture(flights)
+	}
+	return flights, nil
+}
+
+// UpsertSchedule creates or replaces the schedule with the same flight number.
+func (m *MemoryClient) UpsertSchedule(ctx context.Context, schedule FlightSchedule) error {
+	if err := checkContext(ctx, "upsert schedule "+schedule.FlightNumber); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.version++
+	m.schedules[schedule.FlightNumber] = schedule
+	return nil
+}
+
+// GetSchedule returns the schedule for a flight number, or an ErrNotFound error if there is none.
+func (m *MemoryClient) GetSchedule(ctx context.Context, flightNumber string) (FlightSchedule, error) {
+	if err := checkContext(ctx, "get schedule "+flightNumber); err != nil {
+		return FlightSchedule{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.schedules[flightNumber]
+	if !ok {
+		return FlightSchedule{}, wrapErr("get schedule "+flightNumber, ErrNotFound)
+	}
+	return s, nil
+}
+
+// ListSchedules returns every stored schedule ordered by flight number.
+func (m *MemoryClient) ListSchedules(ctx context.Context) ([]FlightSchedule, error) {
+	if err := checkContext(ctx, "list schedules"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	schedules := make([]FlightSchedule, 0, len(m.schedules))
+	for _, s := range m.schedules {
+		schedules = append(schedules, s)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].FlightNumber < schedules[j].FlightNumber })
+	return schedules, nil
+}
+
+// DeleteSchedule removes the schedule for a flight number, returning an ErrNotFound error if there is none.
+func (m *MemoryClient) DeleteSchedule(ctx context.Context, flightNumber string) error {
+	if err := checkContext(ctx, "delete schedule "+flightNumber); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.schedules[flightNumber]; !ok {
+		return wrapErr("delete schedule "+flightNumber, ErrNotFound)
+	}
+	m.version++
+	delete(m.schedules, flightNumber)
+	return nil
+}
+
+// InsertQueryLog appends an audit record.
+func (m *MemoryClient) InsertQueryLog(ctx context.Context, entry QueryLog) error {
+	if err := checkContext(ctx, "insert query log"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queryLogs = append(m.queryLogs, entry)
+	return nil
+}
+
+// GetQueryLog returns the latest audit record of the request with requestID.
+func (m *MemoryClient) GetQueryLog(ctx context.Context, requestID string) (QueryLog, error) {
+	if err := checkContext(ctx, "get query log "+requestID); err != nil {
+		return QueryLog{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := len(m.queryLogs) - 1; i >= 0; i-- {
+		if entry := m.queryLogs[i]; requestID != "" && entry.RequestID == requestID {
+			entry.Calls = append([]LLMCall(nil), entry.Calls...)
+			return entry, nil
+		}
+	}
+	return QueryLog{}, wrapErr("get query log "+requestID, ErrNotFound)
+}
+
+// AppendTurns adds turns to a session's conversation, creating it for client if needed.
+func (m *MemoryClient) AppendTurns(ctx context.Context, sessionID, client string, turns ...Turn) error {
+	if err := checkContext(ctx, "append turns to conversation "+sessionID); err != nil {
+		return err
+	}
+	if len(turns) == 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	conv, ok := m.conversations[sessionID]
+	if !ok {
+		conv = &Conversation{SessionID: sessionID, Client: client, CreatedAt: now}
+		m.conversations[sessionID] = conv
+	}
+	conv.Turns = append(conv.Turns, turns...)
+	conv.UpdatedAt = now
+	return nil
+}
+
+// GetConversation returns a copy of a session's conversation.
+func (m *MemoryClient) GetConversation(ctx context.Context, sessionID string) (Conversation, error) {
+	if err := checkContext(ctx, "get conversation "+sessionID); err != nil {
+		return Conversation{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	conv, ok := m.conversations[sessionID]
+	if !ok {
+		return Conversation{}, wrapErr("get conversation "+sessionID, ErrNotFound)
+	}
+	return conv.copy(), nil
+}
+
+// SetConversationTitle sets the title of client's conversation, keeping an existing title
+// unless overwrite is set.
+func (m *MemoryClient) SetConversationTitle(ctx context.Context, sessionID, client, title string, overwrite bool) error {
+	if err := checkContext(ctx, "set title of conversation "+sessionID); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conv, ok := m.conversations[sessionID]
+	if !ok || conv.Client != client || (!overwrite && conv.Title != "") {
+		return wrapErr("set title of conversation "+sessionID, ErrNotFound)
+	}
+	conv.Title = title
+	return nil
+}
+
+// ListConversations returns client's conversations, most recently updated first, at most limit.
+func (m *MemoryClient) ListConversations(ctx context.Context, client string, limit int) ([]ConversationSummary, error) {
+	if err := checkContext(ctx, "list conversations"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	summaries := []ConversationSummary{}
+	for _, conv := range m.conversations {
+		if conv.Client == client {
+			summaries = append(summaries, ConversationSummary{
+				SessionID: conv.SessionID,
+				Title:     conv.Title,
+				TurnCount: len(conv.Turns),
+				CreatedAt: conv.CreatedAt,
+				UpdatedAt: conv.UpdatedAt,
+			})
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if !summaries[i].UpdatedAt.Equal(summaries[j].UpdatedAt) {
+			return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt)
+		}
+		return summaries[i].SessionID < summaries[j].SessionID
+	})
+	if len(summaries) > limit {
+		summaries = summaries[:limit]
+	}
+	return summaries, nil
+}
+
+// ShareConversation sets the share link of client's conversation, replacing the one it had.
+func (m *MemoryClient) ShareConversation(ctx context.Context, sessionID, client string, share ConversationShare) error {
+	if err := checkContext(ctx, "share conversation "+sessionID); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conv, ok := m.conversations[sessionID]
+	if !ok || conv.Client != client {
+		return wrapErr("share conversation "+sessionID, ErrNotFound)
+	}
+	conv.Share = &share
+	return nil
+}
+
+// UnshareConversation removes the share link of client's conversation.
+func (m *MemoryClient) UnshareConversation(ctx context.Context, sessionID, client string) error {
+	if err := checkContext(ctx, "unshare conversation "+sessionID); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conv, ok := m.conversations[sessionID]
+	if !ok || conv.Client != client || conv.Share == nil {
+		return wrapErr("unshare conversation "+sessionID, ErrNotFound)
+	}
+	conv.Share = nil
+	return nil
+}
+
+// GetSharedConversation returns a copy of the conversation whose unexpired share link has the
+// token with hash tokenHash.
+func (m *MemoryClient) GetSharedConversation(ctx context.Context, tokenHash string, now time.Time) (Conversation, error) {
+	if err := checkContext(ctx, "get shared conversation"); err != nil {
+		return Conversation{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, conv := range m.conversations {
+		if conv.Share != nil && conv.Share.TokenHash == tokenHash && conv.Share.ExpiresAt.After(now) {
+			return conv.copy(), nil
+		}
+	}
+	return Conversation{}, wrapErr("get shared conversation", ErrNotFound)
+}
+
+// IncrementUsage adds delta to its client's record for the day, creating the record if needed.
+func (m *MemoryClient) IncrementUsage(ctx context.Context, delta UsageDelta) error {
+	if err := checkContext(ctx, "increment usage of "+delta.Client); err != nil {
+		return err
+	}
+	day := delta.Time.UTC().Format(UsageDayFormat)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.usage[usageDocID(delta.Client, day)]
+	if !ok {
+		u = &Usage{Client: delta.Client, Day: day}
+		m.usage[usageDocID(delta.Client, day)] = u
+	}
+	u.Requests += delta.Requests
+	u.PromptTokens += delta.PromptTokens
+	u.CompletionTokens += delta.CompletionTokens
+	u.CostUSD += delta.CostUSD
+	u.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// ListUsage returns copies of the daily records selected by q, ordered by client and day.
+func (m *MemoryClient) ListUsage(ctx context.Context, q UsageQuery) ([]Usage, error) {
+	if err := checkContext(ctx, "list usage"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	usage := []Usage{}
+	for _, u := range m.usage {
+		if q.matches(*u) {
+			usage = append(usage, *u)
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Client != usage[j].Client {
+			return usage[i].Client < usage[j].Client
+		}
+		return usage[i].Day < usage[j].Day
+	})
+	return usage, nil
+}
+
+// SaveJob stores job, replacing the stored version if there is one. Expired jobs are
+// dropped on the way, as MongoDB's TTL index would.
+func (m *MemoryClient) SaveJob(ctx context.Context, job Job) error {
+	if err := checkContext(ctx, "save job "+job.ID); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, stored := range m.jobs {
+		if !stored.ExpiresAt.After(now) {
+			delete(m.jobs, id)
+		}
+	}
+	job.Flights = append([]Flight(nil), job.Flights...)
+	m.jobs[job.ID] = job
+	return nil
+}
+
+// GetJob returns a copy of the job with the given ID, or an ErrNotFound error if there is
+// none or it has expired.
+func (m *MemoryClient) GetJob(ctx context.Context, id string) (Job, error) {
+	if err := checkContext(ctx, "get job "+id); err != nil {
+		return Job{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	job, ok := m.jobs[id]
+	if !ok || !job.ExpiresAt.After(time.Now()) {
+		return Job{}, wrapErr("get job "+id, ErrNotFound)
+	}
+	job.Flights = append([]Flight(nil), job.Flights...)
+	return job, nil
+}
+
+// CreateIdempotencyKey stores a new record, or returns an ErrConflict error if an unexpired
+// record with its ID exists. Expired records are dropped on the way.
+func (m *MemoryClient) CreateIdempotencyKey(ctx context.Context, record IdempotencyRecord) error {
+	if err := checkContext(ctx, "create idempotency key"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, stored := range m.idempotencyKeys {
+		if !stored.ExpiresAt.After(now) {
+			delete(m.idempotencyKeys, id)
+		}
+	}
+	if _, ok := m.idempotencyKeys[record.ID]; ok {
+		return wrapErr("create idempotency key", ErrConflict)
+	}
+	m.idempotencyKeys[record.ID] = copyIdempotencyRecord(record)
+	return nil
+}
+
+// GetIdempotencyKey returns a copy of the record with the given ID, or an ErrNotFound error
+// if there is none or it has expired.
+func (m *MemoryClient) GetIdempotencyKey(ctx context.Context, id string) (IdempotencyRecord, error) {
+	if err := checkContext(ctx, "get idempotency key"); err != nil {
+		return IdempotencyRecord{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, ok := m.idempotencyKeys[id]
+	if !ok || !record.ExpiresAt.After(time.Now()) {
+		return IdempotencyRecord{}, wrapErr("get idempotency key", ErrNotFound)
+	}
+	return copyIdempotencyRecord(record), nil
+}
+
+// SaveIdempotencyKey stores record, replacing the stored version if there is one.
+func (m *MemoryClient) SaveIdempotencyKey(ctx context.Context, record IdempotencyRecord) error {
+	if err := checkContext(ctx, "save idempotency key"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.idempotencyKeys[record.ID] = copyIdempotencyRecord(record)
+	return nil
+}
+
+// DeleteIdempotencyKey removes the record with the given ID, if there is one.
+func (m *MemoryClient) DeleteIdempotencyKey(ctx context.Context, id string) error {
+	if err := checkContext(ctx, "delete idempotency key"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.idempotencyKeys, id)
+	return nil
+}
+
+// copyIdempotencyRecord copies record's events, so callers can't modify the stored ones.
+func copyIdempotencyRecord(record IdempotencyRecord) IdempotencyRecord {
+	record.Events = append([]StoredEvent(nil), record.Events...)
+	return record
+}
+
+// AppendStreamEvents adds events to the end of the stored stream with the given ID, creating
+// it if there is none, and sets whether it is done and when it expires. sessionID is the
+// request's session, if it has one. Expired streams are dropped on the way.
+func (m *MemoryClient) AppendStreamEvents(ctx context.Context, id, sessionID string, events []StoredEvent, done bool, expiresAt time.Time) error {
+	if err := checkContext(ctx, "append stream events"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for other, stored := range m.streams {
+		if !stored.ExpiresAt.After(now) {
+			delete(m.streams, other)
+		}
+	}
+	stream := m.streams[id]
+	stream.ID = id
+	if sessionID != "" {
+		stream.SessionID = sessionID
+	}
+	stream.Events = append(append([]StoredEvent(nil), stream.Events...), events...)
+	stream.Done, stream.ExpiresAt = done, expiresAt
+	m.streams[id] = stream
+	return nil
+}
+
+// GetStream returns a copy of the stored stream with the given ID, or an ErrNotFound error if
+// there is none or it has expired.
+func (m *MemoryClient) GetStream(ctx context.Context, id string) (SharedStream, error) {
+	if err := checkContext(ctx, "get stream"); err != nil {
+		return SharedStream{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stream, ok := m.streams[id]
+	if !ok || !stream.ExpiresAt.After(time.Now()) {
+		return SharedStream{}, wrapErr("get stream", ErrNotFound)
+	}
+	stream.Events = append([]StoredEvent(nil), stream.Events...)
+	return stream, nil
+}
+
+// GetQueryStats computes the same summary as the MongoDB aggregation pipeline.
+func (m *MemoryClient) GetQueryStats(ctx context.Context, since time.Time) (QueryStats, error) {
+	if err := checkContext(ctx, "aggregate query logs"); err != nil {
+		return QueryStats{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := QueryStats{Since: since, ByIntent: []IntentCount{}, TopRoutes: []RouteCount{}}
+	intents := make(map[string]int)
+	routes := make(map[[2]string]int)
+	for _, q := range m.queryLogs {
+		if q.Timestamp.Before(since) {
+			continue
+		}
+		stats.Total++
+		intents[q.Intent]++
+		if q.Intent == "flight" {
+			routes[[2]string{q.Origin, q.Destination}]++
+			if q.ResultCount == 0 {
+				stats.NoResults++
+			}
+		}
+	}
+
+	for intent, n := range intents {
+		stats.ByIntent = append(stats.ByIntent, IntentCount{Intent: intent, Count: n})
+	}
+	sort.Slice(stats.ByIntent, func(i, j int) bool {
+		a, b := stats.ByIntent[i], stats.ByIntent[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Intent < b.Intent
+	})
+
+	for route, n := range routes {
+		stats.TopRoutes = append(stats.TopRoutes, RouteCount{Origin: route[0], Destination: route[1], Count: n})
+	}
+	sort.Slice(stats.TopRoutes, func(i, j int) bool {
+		a, b := stats.TopRoutes[i], stats.TopRoutes[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Origin != b.Origin {
+			return a.Origin < b.Origin
+		}
+		return a.Destination < b.Destination
+	})
+	if len(stats.TopRoutes) > topRoutesLimit {
+		stats.TopRoutes = stats.TopRoutes[:topRoutesLimit]
+	}
+	return stats, nil
+}
+
+// ListFlags returns copies of the flag overrides, ordered by name.
+func (m *MemoryClient) ListFlags(ctx context.Context) ([]Flag, error) {
+	if err := checkContext(ctx, "list flags"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	flags := make([]Flag, 0, len(m.flags))
+	for _, flag := range m.flags {
+		flag.Clients = append([]string(nil), flag.Clients...)
+		flag.Sessions = append([]string(nil), flag.Sessions...)
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags, nil
+}
+
+// SaveFlag stores a copy of flag, replacing the override of the same name if there is one.
+func (m *MemoryClient) SaveFlag(ctx context.Context, flag Flag) error {
+	if err := checkContext(ctx, "save flag "+flag.Name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	flag.Clients = append([]string(nil), flag.Clients...)
+	flag.Sessions = append([]string(nil), flag.Sessions...)
+	m.flags[flag.Name] = flag
+	return nil
+}
+
+// DeleteFlag removes the override of the named flag, returning an ErrNotFound error if there is none.
+func (m *MemoryClient) DeleteFlag(ctx context.Context, name string) error {
+	if err := checkContext(ctx, "delete flag "+name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.flags[name]; !ok {
+		return wrapErr("delete flag "+name, ErrNotFound)
+	}
+	delete(m.flags, name)
+	return nil
+}
+
+// GetPreferences returns a session's preferences.
+func (m *MemoryClient) GetPreferences(ctx context.Context, sessionID string) (Preferences, error) {
+	if err := checkContext(ctx, "get preferences of session "+sessionID); err != nil {
+		return Preferences{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	prefs, ok := m.preferences[sessionID]
+	if !ok {
+		return Preferences{}, wrapErr("get preferences of session "+sessionID, ErrNotFound)
+	}
+	return prefs, nil
+}
+
+// SavePreferences stores prefs, replacing the session's stored preferences if there are any.
+func (m *MemoryClient) SavePreferences(ctx context.Context, prefs Preferences) error {
+	if err := checkContext(ctx, "save preferences of session "+prefs.SessionID); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.preferences[prefs.SessionID] = prefs
+	return nil
+}
+
+// DeleteSessionData deletes every record tied to sessionID and returns how many it deleted;
+// with dryRun it only counts them.
+func (m *MemoryClient) DeleteSessionData(ctx context.Context, sessionID string, dryRun bool) (RecordCounts, error) {
+	if err := checkContext(ctx, "delete data of session "+sessionID); err != nil {
+		return RecordCounts{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var counts RecordCounts
+	if _, ok := m.conversations[sessionID]; ok {
+		counts.Conversations = 1
+		if !dryRun {
+			delete(m.conversations, sessionID)
+		}
+	}
+	counts.QueryLogs = m.deleteQueryLogs(func(q QueryLog) bool { return q.SessionID == sessionID }, dryRun)
+	counts.Jobs = deleteWhere(m.jobs, func(j Job) bool { return j.SessionID == sessionID }, dryRun)
+	counts.IdempotencyKeys = deleteWhere(m.idempotencyKeys, func(r IdempotencyRecord) bool { return r.SessionID == sessionID }, dryRun)
+	counts.Preferences = deleteWhere(m.preferences, func(p Preferences) bool { return p.SessionID == sessionID }, dryRun)
+	counts.Streams = deleteWhere(m.streams, func(s SharedStream) bool { return s.SessionID == sessionID }, dryRun)
+	return counts, nil
+}
+
+// PurgeExpired deletes the conversations, query logs and preferences older than r allows at
+// now, and returns how many it deleted.
+func (m *MemoryClient) PurgeExpired(ctx context.Context, r Retention, now time.Time) (RecordCounts, error) {
+	if err := checkContext(ctx, "purge expired records"); err != nil {
+		return RecordCounts{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var counts RecordCounts
+	if r.Conversations > 0 {
+		counts.Conversations = deleteWhere(m.conversations, func(c *Conversation) bool { return c.UpdatedAt.Before(now.Add(-r.Conversations)) }, false)
+	}
+	if r.QueryLogs > 0 {
+		counts.QueryLogs = m.deleteQueryLogs(func(q QueryLog) bool { return q.Timestamp.Before(now.Add(-r.QueryLogs)) }, false)
+	}
+	if r.Preferences > 0 {
+		counts.Preferences = deleteWhere(m.preferences, func(p Preferences) bool { return p.UpdatedAt.Before(now.Add(-r.Preferences)) }, false)
+	}
+	return counts, nil
+}
+
+// deleteQueryLogs deletes the query logs matching match, or with dryRun counts them. The
+// caller holds m.mu.
+func (m *MemoryClient) deleteQueryLogs(match func(QueryLog) bool, dryRun bool) int64 {
+	kept := m.queryLogs[:0:0]
+	for _, q := range m.queryLogs {
+		if !match(q) {
+			kept = append(kept, q)
+		}
+	}
+	n := int64(len(m.queryLogs) - len(kept))
+	if !dryRun {
+		m.queryLogs = kept
+	}
+	return n
+}
+
+// deleteWhere deletes the values of records matching match, or with dryRun counts them.
+func deleteWhere[K comparable, V any](records map[K]V, match func(V) bool, dryRun bool) int64 {
+	var n int64
+	for k, v := range records {
+		if match(v) {
+			n++
+			if !dryRun {
+				delete(records, k)
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,375 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// migrationLockTTL is how long the migration lock is held without being renewed, so the
+	// lock of a replica that died while migrating is taken over rather than kept forever. It
+	// is renewed before each migration, which must finish within it.
+	migrationLockTTL = 5 * time.Minute
+	// migrationLockPoll is how often a replica waiting for the migration lock tries again.
+	migrationLockPoll = time.Second
+	// migrationLockID is the _id of the lock document in the "migrations" collection.
+	migrationLockID = "lock"
+)
+
+// Migration is a change to the schema or the data, applied once to each database. Up applies
+// it and Down, if the change can be undone, reverts it; both get the backend itself, so a
+// migration that only concerns MongoDB checks for a *MongoDBClient and does nothing otherwise.
+type Migration struct {
+	Version int    // Positive and unique; migrations are applied in increasing order
+	Name    string // e.g. "query_logs_timestamp_index"
+	Up      func(ctx context.Context, c Client) error
+	Down    func(ctx context.Context, c Client) error // nil if the migration can't be reverted
+}
+
+// AppliedMigration records a migration applied to the database, in the "migrations"
+// collection keyed by its version.
+type AppliedMigration struct {
+	Version   int       `bson:"_id" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"applied_at" json:"applied_at"`
+	AppliedBy string    `bson:"applied_by,omitempty" json:"applied_by,omitempty"` // The replica that applied it
+}
+
+// MigrationStore is implemented by backends that record the migrations applied to them, so
+// Migrate and Rollback can run against them.
+type MigrationStore interface {
+	// AppliedMigrations returns the recorded migrations, by version.
+	AppliedMigrations(ctx context.Context) ([]AppliedMigration, error)
+	// RecordMigration records an applied migration, or returns an ErrConflict error if its
+	// version is recorded already.
+	RecordMigration(ctx context.Context, applied AppliedMigration) error
+	// ForgetMigration removes the record of a reverted migration, or returns an ErrNotFound
+	// error if there is none.
+	ForgetMigration(ctx context.Context, version int) error
+	// LockMigrations takes, or renews, the migration lock for owner until the given time, or
+	// returns an ErrConflict error if another owner holds it and it hasn't expired.
+	LockMigrations(ctx context.Context, owner string, until time.Time) error
+	// UnlockMigrations releases owner's migration lock, if it still holds it.
+	UnlockMigrations(ctx context.Context, owner string) error
+}
+
+// MigrateOptions tune Migrate and Rollback.
+type MigrateOptions struct {
+	// Owner names who migrates, normally the replica, in the lock and in the records. It is
+	// required unless DryRun is set.
+	Owner string
+	// DryRun only returns the migrations that would run, without taking the lock or changing
+	// anything.
+	DryRun bool
+}
+
+// Migrate applies the migrations in migrations that c hasn't recorded, in version order, and
+// records each once it is applied; it returns those it applied, or with opts.DryRun those it
+// would apply. Replicas starting together don't apply a migration twice: they take turns
+// holding the migration lock, and each reads what is recorded once it holds it. A failed
+// migration stops the run, and is tried again by the next one. Applied versions that
+// migrations doesn't know, e.g. from a newer release, are logged and otherwise ignored.
+func Migrate(ctx context.Context, c Client, migrations []Migration, opts MigrateOptions) ([]Migration, error) {
+	store, sorted, err := migrationSetup(c, migrations, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.DryRun {
+		if err := lockMigrations(ctx, store, opts.Owner); err != nil {
+			return nil, err
+		}
+		defer unlockMigrations(ctx, store, opts.Owner)
+	}
+	applied, err := appliedVersions(ctx, store, sorted)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range sorted {
+		if applied[m.Version] {
+			continue
+		}
+		if opts.DryRun {
+			done = append(done, m)
+			continue
+		}
+		if err := store.LockMigrations(ctx, opts.Owner, time.Now().Add(migrationLockTTL)); err != nil {
+			return done, fmt.Errorf("renew migration lock: %w", err)
+		}
+		if err := m.Up(ctx, c); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		record := AppliedMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC(), AppliedBy: opts.Owner}
+		if err := store.RecordMigration(ctx, record); err != nil {
+			return done, fmt.Errorf("record migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		slog.InfoContext(ctx, "Migration applied", "version", m.Version, "name", m.Name)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Rollback reverts the applied migrations in migrations with a version above to, newest first,
+// and forgets each once it is reverted; it returns those it reverted, or with opts.DryRun those
+// it would revert. It fails before reverting anything if one of them has no Down, or if c
+// records a version above to that migrations doesn't know.
+func Rollback(ctx context.Context, c Client, migrations []Migration, to int, opts MigrateOptions) ([]Migration, error) {
+	store, sorted, err := migrationSetup(c, migrations, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.DryRun {
+		if err := lockMigrations(ctx, store, opts.Owner); err != nil {
+			return nil, err
+		}
+		defer unlockMigrations(ctx, store, opts.Owner)
+	}
+	records, err := store.AppliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var revert []Migration
+	for _, record := range slices.Backward(records) {
+		if record.Version <= to {
+			continue
+		}
+		i := slices.IndexFunc(sorted, func(m Migration) bool { return m.Version == record.Version })
+		switch {
+		case i < 0:
+			return nil, fmt.Errorf("migration %d (%s) is unknown to this release", record.Version, record.Name)
+		case sorted[i].Down == nil:
+			return nil, fmt.Errorf("migration %d (%s) can't be reverted", record.Version, record.Name)
+		}
+		revert = append(revert, sorted[i])
+	}
+	if opts.DryRun {
+		return revert, nil
+	}
+	var done []Migration
+	for _, m := range revert {
+		if err := store.LockMigrations(ctx, opts.Owner, time.Now().Add(migrationLockTTL)); err != nil {
+			return done, fmt.Errorf("renew migration lock: %w", err)
+		}
+		if err := m.Down(ctx, c); err != nil {
+			return done, fmt.Errorf("revert migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if err := store.ForgetMigration(ctx, m.Version); err != nil {
+			return done, fmt.Errorf("forget migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		slog.InfoContext(ctx, "Migration reverted", "version", m.Version, "name", m.Name)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// migrationSetup returns c as a MigrationStore, and migrations in version order, or an error
+// if c records no migrations, opts has no owner to lock with, or migrations has an invalid or
+// repeated version.
+func migrationSetup(c Client, migrations []Migration, opts MigrateOptions) (MigrationStore, []Migration, error) {
+	store, ok := c.(MigrationStore)
+	if !ok {
+		return nil, nil, fmt.Errorf("the %T backend doesn't record migrations", c)
+	}
+	if opts.Owner == "" && !opts.DryRun {
+		return nil, nil, errors.New("migrations need an owner to lock with")
+	}
+	sorted := slices.Clone(migrations)
+	slices.SortFunc(sorted, func(a, b Migration) int { return a.Version - b.Version })
+	for i, m := range sorted {
+		switch {
+		case m.Version <= 0:
+			return nil, nil, fmt.Errorf("migration %q: version %d is not positive", m.Name, m.Version)
+		case m.Up == nil:
+			return nil, nil, fmt.Errorf("migration %d (%s) has no Up", m.Version, m.Name)
+		case i > 0 && sorted[i-1].Version == m.Version:
+			return nil, nil, fmt.Errorf("migrations %q and %q share version %d", sorted[i-1].Name, m.Name, m.Version)
+		}
+	}
+	return store, sorted, nil
+}
+
+// appliedVersions returns the versions store records. Those not in known, the migrations of
+// this release, are logged.
+func appliedVersions(ctx context.Context, store MigrationStore, known []Migration) (map[int]bool, error) {
+	records, err := store.AppliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool, len(records))
+	for _, record := range records {
+		applied[record.Version] = true
+		if !slices.ContainsFunc(known, func(m Migration) bool { return m.Version == record.Version }) {
+			slog.WarnContext(ctx, "Applied migration unknown to this release", "version", record.Version, "name", record.Name)
+		}
+	}
+	return applied, nil
+}
+
+// lockMigrations waits until owner holds the migration lock, or ctx is done.
+func lockMigrations(ctx context.Context, store MigrationStore, owner string) error {
+	logged := false
+	for {
+		err := store.LockMigrations(ctx, owner, time.Now().Add(migrationLockTTL))
+		if !errors.Is(err, ErrConflict) {
+			return err
+		}
+		if !logged {
+			slog.InfoContext(ctx, "Waiting for another replica's migrations to finish")
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return wrapErr("wait for the migration lock", ctx.Err())
+		case <-time.After(migrationLockPoll):
+		}
+	}
+}
+
+// unlockMigrations releases owner's migration lock, even if ctx is done; a failure is logged,
+// and the lock then expires on its own.
+func unlockMigrations(ctx context.Context, store MigrationStore, owner string) {
+	if err := store.UnlockMigrations(context.WithoutCancel(ctx), owner); err != nil {
+		slog.WarnContext(ctx, "Failed to release the migration lock", "error", err)
+	}
+}
+
+// AppliedMigrations returns the migrations recorded in the "migrations" collection, by version.
+func (m *MongoDBClient) AppliedMigrations(ctx context.Context) ([]AppliedMigration, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := m.migrations.Find(ctx, bson.M{"_id": bson.M{"$type": "number"}}, opts)
+	if err != nil {
+		return nil, wrapErr("list migrations", err)
+	}
+	var applied []AppliedMigration
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, wrapErr("list migrations", err)
+	}
+	return applied, nil
+}
+
+// RecordMigration records an applied migration, or returns an ErrConflict error if its version
+// is recorded already.
+func (m *MongoDBClient) RecordMigration(ctx context.Context, applied AppliedMigration) error {
+	_, err := m.migrations.InsertOne(ctx, applied)
+	return wrapErr(fmt.Sprintf("record migration %d", applied.Version), err)
+}
+
+// ForgetMigration removes the record of a reverted migration, or returns an ErrNotFound error
+// if there is none.
+func (m *MongoDBClient) ForgetMigration(ctx context.Context, version int) error {
+	op := fmt.Sprintf("forget migration %d", version)
+	res, err := m.migrations.DeleteOne(ctx, bson.M{"_id": version})
+	if err != nil {
+		return wrapErr(op, err)
+	}
+	if res.DeletedCount == 0 {
+		return wrapErr(op, ErrNotFound)
+	}
+	return nil
+}
+
+// LockMigrations takes, or renews, the migration lock for owner until the given time, or
+// returns an ErrConflict error if another owner holds it and it hasn't expired. The lock is a
+// document in the "migrations" collection: the upsert matches it only if owner may take it,
+// and otherwise fails on its _id, so of replicas racing for it exactly one gets it.
+func (m *MongoDBClient) LockMigrations(ctx context.Context, owner string, until time.Time) error {
+	filter := bson.M{"_id": migrationLockID, "$or": []bson.M{
+		{"owner": owner},
+		{"expires_at": bson.M{"$lte": time.Now().UTC()}},
+	}}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": until.UTC()}}
+	_, err := m.migrations.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return wrapErr("lock migrations", err)
+}
+
+// UnlockMigrations releases owner's migration lock, if it still holds it.
+func (m *MongoDBClient) UnlockMigrations(ctx context.Context, owner string) error {
+	_, err := m.migrations.DeleteOne(ctx, bson.M{"_id": migrationLockID, "owner": owner})
+	return wrapErr("unlock migrations", err)
+}
+
+// AppliedMigrations returns the recorded migrations, by version.
+func (m *MemoryClient) AppliedMigrations(ctx context.Context) ([]AppliedMigration, error) {
+	if err := checkContext(ctx, "list migrations"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	applied := slices.Collect(maps.Values(m.migrations))
+	slices.SortFunc(applied, func(a, b AppliedMigration) int { return a.Version - b.Version })
+	return applied, nil
+}
+
+// RecordMigration records an applied migration, or returns an ErrConflict error if its version
+// is recorded already.
+func (m *MemoryClient) RecordMigration(ctx context.Context, applied AppliedMigration) error {
+	op := fmt.Sprintf("record migration %d", applied.Version)
+	if err := checkContext(ctx, op); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.migrations[applied.Version]; ok {
+		return wrapErr(op, ErrConflict)
+	}
+	m.migrations[applied.Version] = applied
+	return nil
+}
+
+// ForgetMigration removes the record of a reverted migration, or returns an ErrNotFound error
+// if there is none.
+func (m *MemoryClient) ForgetMigration(ctx context.Context, version int) error {
+	op := fmt.Sprintf("forget migration %d", version)
+	if err := checkContext(ctx, op); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.migrations[version]; !ok {
+		return wrapErr(op, ErrNotFound)
+	}
+	delete(m.migrations, version)
+	return nil
+}
+
+// LockMigrations takes, or renews, the migration lock for owner until the given time, or
+// returns an ErrConflict error if another owner holds it and it hasn't expired.
+func (m *MemoryClient) LockMigrations(ctx context.Context, owner string, until time.Time) error {
+	if err := checkContext(ctx, "lock migrations"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.migrationLock.owner != "" && m.migrationLock.owner != owner && time.Now().Before(m.migrationLock.until) {
+		return wrapErr("lock migrations", ErrConflict)
+	}
+	m.migrationLock = migrationLock{owner: owner, until: until}
+	return nil
+}
+
+// UnlockMigrations releases owner's migration lock, if it still holds it.
+func (m *MemoryClient) UnlockMigrations(ctx context.Context, owner string) error {
+	if err := checkContext(ctx, "unlock migrations"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.migrationLock.owner == owner {
+		m.migrationLock = migrationLock{}
+	}
+	return nil
+}
+
+// migrationLock is the migration lock of a MemoryClient; the zero value is unlocked.
+type migrationLock struct {
+	owner string
+	until time.Time
+}
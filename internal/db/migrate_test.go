@@ -0,0 +1,369 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migrationLog records the Up and Down calls of test migrations, in order.
+type migrationLog struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (l *migrationLog) add(call string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, call)
+}
+
+// take returns the calls since the last take.
+func (l *migrationLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	calls := l.calls
+	l.calls = nil
+	return calls
+}
+
+// migration returns a test migration that logs its calls, and can be reverted.
+func (l *migrationLog) migration(version int) Migration {
+	name := fmt.Sprintf("step_%d", version)
+	return Migration{
+		Version: version,
+		Name:    name,
+		Up:      func(context.Context, Client) error { l.add("up " + name); return nil },
+		Down:    func(context.Context, Client) error { l.add("down " + name); return nil },
+	}
+}
+
+// versions returns the versions of migrations, in order.
+func versions(migrations []Migration) []int {
+	var out []int
+	for _, m := range migrations {
+		out = append(out, m.Version)
+	}
+	return out
+}
+
+// recordedVersions returns the versions c records as applied.
+func recordedVersions(t *testing.T, c Client) []int {
+	t.Helper()
+	records, err := c.(MigrationStore).AppliedMigrations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []int
+	for _, r := range records {
+		out = append(out, r.Version)
+	}
+	return out
+}
+
+// checkMigrations checks applying, re-running and reverting a chain of migrations on c.
+func checkMigrations(t *testing.T, c Client) {
+	ctx := context.Background()
+	var log migrationLog
+	// Registered out of order; they run by version.
+	chain := []Migration{log.migration(2), log.migration(1), log.migration(3)}
+	opts := MigrateOptions{Owner: "replica-a"}
+
+	// A dry run lists them without running or recording anything.
+	pending, err := Migrate(ctx, c, chain, MigrateOptions{DryRun: true})
+	if err != nil || !slices.Equal(versions(pending), []int{1, 2, 3}) || len(log.take()) != 0 || len(recordedVersions(t, c)) != 0 {
+		t.Fatalf("dry run = %v, %v", versions(pending), err)
+	}
+
+	// The chain is applied in order, and recorded.
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	applied, err := Migrate(ctx, c, chain, opts)
+	if err != nil || !slices.Equal(versions(applied), []int{1, 2, 3}) {
+		t.Fatalf("Migrate = %v, %v", versions(applied), err)
+	}
+	if calls := log.take(); !slices.Equal(calls, []string{"up step_1", "up step_2", "up step_3"}) {
+		t.Errorf("calls %v", calls)
+	}
+	records, err := c.(MigrationStore).AppliedMigrations(ctx)
+	if err != nil || len(records) != 3 {
+		t.Fatalf("records %+v, %v", records, err)
+	}
+	if r := records[1]; r.Version != 2 || r.Name != "step_2" || r.AppliedBy != "replica-a" || r.AppliedAt.Before(start) {
+		t.Errorf("record %+v", r)
+	}
+
+	// Running again changes nothing, nor does a dry run find anything.
+	for _, o := range []MigrateOptions{opts, {Owner: "replica-b"}, {DryRun: true}} {
+		if again, err := Migrate(ctx, c, chain, o); err != nil || len(again) != 0 {
+			t.Errorf("re-run as %+v = %v, %v", o, versions(again), err)
+		}
+	}
+	if calls := log.take(); len(calls) != 0 {
+		t.Errorf("re-runs called %v", calls)
+	}
+
+	// A new release's migration is the only one applied.
+	chain = append(chain, log.migration(4))
+	if applied, err := Migrate(ctx, c, chain, opts); err != nil || !slices.Equal(versions(applied), []int{4}) {
+		t.Errorf("with a new migration = %v, %v", versions(applied), err)
+	}
+	log.take()
+
+	// A failed migration stops the run unrecorded, after those before it, and is tried again.
+	failing := true
+	chain = append(chain, Migration{Version: 5, Name: "flaky", Up: func(context.Context, Client) error {
+		if failing {
+			return errors.New("disk full")
+		}
+		return nil
+	}}, log.migration(6))
+	applied, err = Migrate(ctx, c, chain, opts)
+	if err == nil || !strings.Contains(err.Error(), "migration 5 (flaky): disk full") || len(applied) != 0 {
+		t.Errorf("failed migration = %v, %v", versions(applied), err)
+	}
+	if got := recordedVersions(t, c); !slices.Equal(got, []int{1, 2, 3, 4}) || len(log.take()) != 0 {
+		t.Errorf("recorded after the failure %v", got)
+	}
+	failing = false
+	if applied, err := Migrate(ctx, c, chain, opts); err != nil || !slices.Equal(versions(applied), []int{5, 6}) {
+		t.Errorf("retry = %v, %v", versions(applied), err)
+	}
+	log.take()
+
+	// Migration 5 can't be reverted, so reverting past it fails before reverting anything.
+	if reverted, err := Rollback(ctx, c, chain, 3, opts); err == nil || !strings.Contains(err.Error(), "migration 5 (flaky) can't be reverted") || len(reverted) != 0 {
+		t.Errorf("rollback past an irreversible migration = %v, %v", versions(reverted), err)
+	}
+	if calls := log.take(); len(calls) != 0 {
+		t.Errorf("failed rollback called %v", calls)
+	}
+
+	// Rollback reverts newest first, down to the version given; a dry run only lists them.
+	if reverted, err := Rollback(ctx, c, chain, 5, MigrateOptions{DryRun: true}); err != nil || !slices.Equal(versions(reverted), []int{6}) || len(log.take()) != 0 {
+		t.Errorf("rollback dry run = %v, %v", versions(reverted), err)
+	}
+	if reverted, err := Rollback(ctx, c, chain, 5, opts); err != nil || !slices.Equal(versions(reverted), []int{6}) {
+		t.Errorf("Rollback to 5 = %v, %v", versions(reverted), err)
+	}
+	// Once migration 5 is undone by hand and forgotten, the others revert.
+	chain = slices.DeleteFunc(chain, func(m Migration) bool { return m.Version >= 5 })
+	if err := c.(MigrationStore).ForgetMigration(ctx, 5); err != nil {
+		t.Fatal(err)
+	}
+	if reverted, err := Rollback(ctx, c, chain, 1, opts); err != nil || !slices.Equal(versions(reverted), []int{4, 3, 2}) {
+		t.Errorf("Rollback to 1 = %v, %v", versions(reverted), err)
+	}
+	if calls := log.take(); !slices.Equal(calls, []string{"down step_6", "down step_4", "down step_3", "down step_2"}) {
+		t.Errorf("rollback calls %v", calls)
+	}
+	if got := recordedVersions(t, c); !slices.Equal(got, []int{1}) {
+		t.Errorf("recorded after the rollback %v", got)
+	}
+	if err := c.(MigrationStore).ForgetMigration(ctx, 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("forgetting a reverted migration: %v, want ErrNotFound", err)
+	}
+
+	// Reverted migrations are applied again by the next run.
+	if applied, err := Migrate(ctx, c, chain, opts); err != nil || !slices.Equal(versions(applied), []int{2, 3, 4}) {
+		t.Errorf("after the rollback = %v, %v", versions(applied), err)
+	}
+	log.take()
+
+	// A recorded version this release doesn't know is kept by Migrate, but can't be reverted.
+	older := chain[:2]
+	if applied, err := Migrate(ctx, c, older, opts); err != nil || len(applied) != 0 {
+		t.Errorf("an older release's run = %v, %v", versions(applied), err)
+	}
+	if got := recordedVersions(t, c); !slices.Equal(got, []int{1, 2, 3, 4}) {
+		t.Errorf("recorded after an older release's run %v", got)
+	}
+	if _, err := Rollback(ctx, c, older, 0, opts); err == nil || !strings.Contains(err.Error(), "migration 4 (step_4) is unknown to this release") {
+		t.Errorf("rollback of an unknown migration: %v", err)
+	}
+	if calls := log.take(); len(calls) != 0 {
+		t.Errorf("an older release called %v", calls)
+	}
+}
+
+// checkMigrationLock checks c's migration lock, and that replicas migrating together apply
+// each migration once.
+func checkMigrationLock(t *testing.T, c Client) {
+	ctx := context.Background()
+	store := c.(MigrationStore)
+
+	// The lock is held by one owner at a time, who can renew it, until it expires.
+	if err := store.LockMigrations(ctx, "replica-a", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LockMigrations(ctx, "replica-b", time.Now().Add(time.Minute)); !errors.Is(err, ErrConflict) {
+		t.Errorf("taking a held lock: %v, want ErrConflict", err)
+	}
+	if err := store.LockMigrations(ctx, "replica-a", time.Now().Add(200*time.Millisecond)); err != nil {
+		t.Errorf("renewing: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if err := store.LockMigrations(ctx, "replica-b", time.Now().Add(time.Minute)); err != nil {
+		t.Errorf("taking an expired lock: %v", err)
+	}
+	// Only its owner releases it.
+	if err := store.UnlockMigrations(ctx, "replica-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LockMigrations(ctx, "replica-c", time.Now().Add(time.Minute)); !errors.Is(err, ErrConflict) {
+		t.Errorf("the lock was released by another owner: %v", err)
+	}
+	if err := store.UnlockMigrations(ctx, "replica-b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A run waits for the lock held by another replica, and stops if ctx ends first.
+	if err := store.LockMigrations(ctx, "replica-b", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	var log migrationLog
+	chain := []Migration{log.migration(1), log.migration(2)}
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if applied, err := Migrate(waitCtx, c, chain, MigrateOptions{Owner: "replica-a"}); !errors.Is(err, context.DeadlineExceeded) || len(applied) != 0 {
+		t.Errorf("Migrate while locked = %v, %v", versions(applied), err)
+	}
+	if err := store.UnlockMigrations(ctx, "replica-b"); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.take()) != 0 || len(recordedVersions(t, c)) != 0 {
+		t.Fatalf("a run that never held the lock migrated")
+	}
+
+	// Replicas starting together apply each migration once between them.
+	slow := func(m Migration) Migration {
+		up := m.Up
+		m.Up = func(ctx context.Context, c Client) error {
+			time.Sleep(50 * time.Millisecond)
+			return up(ctx, c)
+		}
+		return m
+	}
+	chain = []Migration{slow(log.migration(1)), slow(log.migration(2)), slow(log.migration(3))}
+	var wg sync.WaitGroup
+	applied := make([][]int, 4)
+	errs := make([]error, 4)
+	for i := range applied {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ran, err := Migrate(ctx, c, chain, MigrateOptions{Owner: fmt.Sprintf("replica-%d", i)})
+			applied[i], errs[i] = versions(ran), err
+		}()
+	}
+	wg.Wait()
+	var all []int
+	for i := range applied {
+		if errs[i] != nil {
+			t.Errorf("replica-%d: %v", i, errs[i])
+		}
+		all = append(all, applied[i]...)
+	}
+	slices.Sort(all)
+	if !slices.Equal(all, []int{1, 2, 3}) {
+		t.Errorf("replicas applied %v between them", applied)
+	}
+	if calls := log.take(); !slices.Equal(calls, []string{"up step_1", "up step_2", "up step_3"}) {
+		t.Errorf("calls %v", calls)
+	}
+
+	// Each run released the lock.
+	if err := store.LockMigrations(ctx, "replica-z", time.Now().Add(time.Minute)); err != nil {
+		t.Errorf("the lock is still held after the runs: %v", err)
+	}
+}
+
+func TestMemoryMigrations(t *testing.T) {
+	checkMigrations(t, NewMemoryClient())
+}
+
+func TestMongoMigrations(t *testing.T) {
+	checkMigrations(t, newMongoTestClient(t))
+}
+
+func TestMemoryMigrationLock(t *testing.T) {
+	checkMigrationLock(t, NewMemoryClient())
+}
+
+func TestMongoMigrationLock(t *testing.T) {
+	checkMigrationLock(t, newMongoTestClient(t))
+}
+
+func TestMigrationSetup(t *testing.T) {
+	up := func(context.Context, Client) error { return nil }
+	c := NewMemoryClient()
+	for _, tt := range []struct {
+		name       string
+		c          Client
+		migrations []Migration
+		opts       MigrateOptions
+		want       string
+	}{
+		{"backend without records", struct{ Client }{c}, nil, MigrateOptions{Owner: "a"}, "doesn't record migrations"},
+		{"no owner", c, nil, MigrateOptions{}, "need an owner"},
+		{"version zero", c, []Migration{{Version: 0, Name: "zero", Up: up}}, MigrateOptions{DryRun: true}, "version 0 is not positive"},
+		{"no Up", c, []Migration{{Version: 1, Name: "empty"}}, MigrateOptions{DryRun: true}, "migration 1 (empty) has no Up"},
+		{"repeated version", c, []Migration{{Version: 1, Name: "a", Up: up}, {Version: 1, Name: "b", Up: up}}, MigrateOptions{DryRun: true}, "share version 1"},
+	} {
+		if _, err := Migrate(context.Background(), tt.c, tt.migrations, tt.opts); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Migrate error %v, want one about %q", tt.name, err, tt.want)
+		}
+		if _, err := Rollback(context.Background(), tt.c, tt.migrations, 0, tt.opts); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Rollback error %v, want one about %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestMongoReleaseMigrations(t *testing.T) {
+	m := newMongoTestClient(t)
+	ctx := context.Background()
+	hasIndex := func() bool {
+		t.Helper()
+		specs, err := m.queryLogs.Indexes().ListSpecifications(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return slices.ContainsFunc(specs, func(s *mongo.IndexSpecification) bool { return s.Name == queryLogsTimestampIndex })
+	}
+
+	// The release's chain applies, and a second run finds nothing to do.
+	applied, err := Migrate(ctx, m, Migrations(), MigrateOptions{Owner: "replica-a"})
+	if err != nil || len(applied) != len(Migrations()) || !hasIndex() {
+		t.Fatalf("Migrate = %v, %v; index created %v", versions(applied), err, hasIndex())
+	}
+	if again, err := Migrate(ctx, m, Migrations(), MigrateOptions{Owner: "replica-b"}); err != nil || len(again) != 0 {
+		t.Errorf("re-run = %v, %v", versions(again), err)
+	}
+	// Every one of them reverts.
+	if reverted, err := Rollback(ctx, m, Migrations(), 0, MigrateOptions{Owner: "replica-a"}); err != nil || len(reverted) != len(Migrations()) || hasIndex() {
+		t.Errorf("Rollback = %v, %v; index left %v", versions(reverted), err, hasIndex())
+	}
+}
+
+func TestMemoryReleaseMigrations(t *testing.T) {
+	// The release's migrations only concern MongoDB, but are recorded on every backend.
+	c := NewMemoryClient()
+	ctx := context.Background()
+	for range 2 {
+		if _, err := Migrate(ctx, c, Migrations(), MigrateOptions{Owner: "replica-a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := recordedVersions(t, c); !slices.Equal(got, versions(Migrations())) {
+		t.Errorf("recorded %v", got)
+	}
+	if reverted, err := Rollback(ctx, c, Migrations(), 0, MigrateOptions{Owner: "replica-a"}); err != nil || len(reverted) != len(Migrations()) {
+		t.Errorf("Rollback = %v, %v", versions(reverted), err)
+	}
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// queryLogsTimestampIndex names the index of migration 1.
+const queryLogsTimestampIndex = "timestamp_1"
+
+// Migrations returns the migrations of this release, which the server applies at startup
+// before seeding (see Migrate). A migration, once released, is never changed or removed;
+// later changes are new migrations with higher versions.
+func Migrations() []Migration {
+	return []Migration{
+		{
+			// GetQueryStats and PurgeExpired select query logs by time, which scans the
+			// collection without an index.
+			Version: 1,
+			Name:    "query_logs_timestamp_index",
+			Up: func(ctx context.Context, c Client) error {
+				m, ok := c.(*MongoDBClient)
+				if !ok {
+					return nil
+				}
+				_, err := m.queryLogs.Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.D{{Key: "timestamp", Value: 1}},
+					Options: options.Index().SetName(queryLogsTimestampIndex),
+				})
+				return wrapErr("create query logs timestamp index", err)
+			},
+			Down: func(ctx context.Context, c Client) error {
+				m, ok := c.(*MongoDBClient)
+				if !ok {
+					return nil
+				}
+				_, err := m.queryLogs.Indexes().DropOne(ctx, queryLogsTimestampIndex)
+				return wrapErr("drop query logs timestamp index", err)
+			},
+		},
+	}
+}
@@ -1,14 +1,203 @@
 package db
 
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
 // Flight represents a flight document in MongoDB.
 // `bson:"_id,omitempty"` means the _id field is optional and will be generated by MongoDB if not provided.
-// Other `bson:"field_name"` tags map struct fields to MongoDB document fields.
+// Other `bson:"field_name"` tags map struct fields to MongoDB document fields;
+// the json tags are used when flights are sent to clients in structured SSE events.
 type Flight struct {
-	FlightNumber   string             `bson:"flight_number"`
-    Origin         string             `bson:"origin"`
-    Destination    string             `bson:"destination"`
-    DepartureTime  string             `bson:"departure_time"`  // Usa string para simplificar pruebas
-    ArrivalTime    string             `bson:"arrival_time"`
-    Price          float64            `bson:"price"`
-    AvailableSeats int                `bson:"available_seats"`
-}
\ No newline at end of file
+	FlightNumber   string  `bson:"flight_number" json:"flight_number"`
+	Origin         string  `bson:"origin" json:"origin"`
+	Destination    string  `bson:"destination" json:"destination"`
+	DepartureTime  string  `bson:"departure_time" json:"departure_time"` // Usa string para simplificar pruebas
+	ArrivalTime    string  `bson:"arrival_time" json:"arrival_time"`
+	Price          float64 `bson:"price" json:"price"`
+	AvailableSeats int     `bson:"available_seats" json:"available_seats"`
+
+	// OriginAirport and DestinationAirport are the IATA codes of the airports, for cities with
+	// more than one ("JFK" or "EWR" for New York); Origin and Destination stay the cities, so a
+	// search by city finds the flights of all its airports. Empty if unknown; stored even then,
+	// so an update without them clears them.
+	OriginAirport      string `bson:"origin_airport" json:"origin_airport,omitempty"`
+	DestinationAirport string `bson:"destination_airport" json:"destination_airport,omitempty"`
+
+	// DepartureLocal and ArrivalLocal are the times in the local time of the origin and the
+	// destination, as RFC 3339 with their offsets ("2025-08-20T11:00:00+09:00"), and the time
+	// zones their tz database names; DurationMinutes is how long the flight takes. They are
+	// not stored: the orchestrator fills them in for the FlightResults events it sends, and
+	// leaves the times empty where it doesn't know the zone.
+	DepartureLocal    string `bson:"-" json:"departure_local,omitempty"`
+	DepartureTimeZone string `bson:"-" json:"departure_time_zone,omitempty"`
+	ArrivalLocal      string `bson:"-" json:"arrival_local,omitempty"`
+	ArrivalTimeZone   string `bson:"-" json:"arrival_time_zone,omitempty"`
+	DurationMinutes   int    `bson:"-" json:"duration_minutes,omitempty"`
+
+	// Passengers and TotalPrice are the size of the party the question searched for and what
+	// the flight costs it, in the stored prices' currency; Price stays the price per person.
+	// Not stored either: the orchestrator fills them in for parties of more than one.
+	Passengers int     `bson:"-" json:"passengers,omitempty"`
+	TotalPrice float64 `bson:"-" json:"total_price,omitempty"`
+}
+
+// Validate checks that a flight has all required fields and sensible values.
+// Times must be RFC 3339 timestamps (e.g. "2025-08-10T09:00:00Z") with the arrival after the departure.
+func (f Flight) Validate() error {
+	var problems []string
+	if strings.TrimSpace(f.FlightNumber) == "" {
+		problems = append(problems, "flight_number is required")
+	}
+	if strings.TrimSpace(f.Origin) == "" {
+		problems = append(problems, "origin is required")
+	}
+	if strings.TrimSpace(f.Destination) == "" {
+		problems = append(problems, "destination is required")
+	}
+	if f.Origin != "" && strings.EqualFold(f.Origin, f.Destination) {
+		problems = append(problems, "origin and destination must differ")
+	}
+	problems = append(problems, airportProblems(f.OriginAirport, f.DestinationAirport)...)
+	departure, depErr := time.Parse(time.RFC3339, f.DepartureTime)
+	if depErr != nil {
+		problems = append(problems, "departure_time must be an RFC 3339 timestamp")
+	}
+	arrival, arrErr := time.Parse(time.RFC3339, f.ArrivalTime)
+	if arrErr != nil {
+		problems = append(problems, "arrival_time must be an RFC 3339 timestamp")
+	}
+	if depErr == nil && arrErr == nil && !arrival.After(departure) {
+		problems = append(problems, "arrival_time must be after departure_time")
+	}
+	if f.Price < 0 {
+		problems = append(problems, "price must not be negative")
+	}
+	if f.AvailableSeats < 0 {
+		problems = append(problems, "available_seats must not be negative")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// UpsertResult summarizes a bulk upsert: how many flights were newly inserted
+// and how many already existed (matched by flight number) and were updated in place.
+type UpsertResult struct {
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"`
+}
+
+// QueryLog is one audit record per chat request, stored in the "query_logs" collection
+// so product can see what users ask and whether we found flights for them.
+type QueryLog struct {
+	RequestID        string    `bson:"request_id,omitempty" json:"request_id,omitempty"` // The X-Request-ID of the request
+	Timestamp        time.Time `bson:"timestamp" json:"timestamp"`
+	SessionID        string    `bson:"session_id,omitempty" json:"session_id,omitempty"`
+	Message          string    `bson:"message" json:"message"`
+	DetectedLanguage string    `bson:"detected_language" json:"detected_language"`
+	Intent           string    `bson:"intent" json:"intent"` // "flight", "routes", "compare", "local_time", "preferences", "repeat" or "general"
+	Origin           string    `bson:"origin,omitempty" json:"origin,omitempty"`
+	Destination      string    `bson:"destination,omitempty" json:"destination,omitempty"`
+	MaxPrice         float64   `bson:"max_price,omitempty" json:"max_price,omitempty"`   // In the stored prices' currency
+	Currency         string    `bson:"currency,omitempty" json:"currency,omitempty"`     // Currency the user asked in, if not the stored one
+	Passengers       int       `bson:"passengers,omitempty" json:"passengers,omitempty"` // Party size the flights were searched for, if given
+	ResultCount      int       `bson:"result_count" json:"result_count"`
+	DurationMs       int64     `bson:"duration_ms" json:"duration_ms"`
+	Error            string    `bson:"error,omitempty" json:"error,omitempty"`
+	Truncated        bool      `bson:"truncated,omitempty" json:"truncated,omitempty"`           // The answer was cut at the output limit
+	Flags            []string  `bson:"flags,omitempty" json:"flags,omitempty"`                   // Feature flags that were on
+	LanguageRetry    string    `bson:"language_retry,omitempty" json:"language_retry,omitempty"` // "fixed" or "failed" if the answer came in another language
+
+	// OriginAirport and DestinationAirport are the IATA codes of the airports the message named
+	// by code ("from JFK"), for cities with more than one.
+	OriginAirport      string `bson:"origin_airport,omitempty" json:"origin_airport,omitempty"`
+	DestinationAirport string `bson:"destination_airport,omitempty" json:"destination_airport,omitempty"`
+
+	// Confidence is "high" when a flight question's route was read from "from" and "to", and
+	// "low" when its direction was guessed, as the QueryUnderstanding event reported it.
+	Confidence string `bson:"confidence,omitempty" json:"confidence,omitempty"`
+
+	// Preferences names the session preferences the request used to fill in what the message
+	// left out ("home_city", "currency", "max_budget", "language", "passengers"), or for the
+	// preferences intent, the ones it saved.
+	Preferences []string `bson:"preferences,omitempty" json:"preferences,omitempty"`
+
+	// Models names the model each LLM stage ran on, by stage: "llm1", "llm2", "aggregation".
+	Models map[string]string `bson:"models,omitempty" json:"models,omitempty"`
+
+	// PersonaOverrides are the system prompts the request gave in place of the persona's, by
+	// stage: "worker1", "worker2", "aggregator".
+	PersonaOverrides map[string]string `bson:"persona_overrides,omitempty" json:"persona_overrides,omitempty"`
+
+	// Condensed says how each worker answer that was too long for the aggregation prompt was
+	// condensed, by stage ("llm1", "llm2"): "extract" or "summary". Calls keeps the originals.
+	Condensed map[string]string `bson:"condensed,omitempty" json:"condensed,omitempty"`
+
+	// StagesMs is how long each pipeline stage took, as in the Done event's telemetry.
+	StagesMs map[string]int64 `bson:"stages_ms,omitempty" json:"stages_ms,omitempty"`
+
+	// Calls and Answer are the request's LLM calls, in the order they finished, and the answer
+	// it sent; only recorded with prompt storage on.
+	Calls  []LLMCall `bson:"calls,omitempty" json:"calls,omitempty"`
+	Answer string    `bson:"answer,omitempty" json:"answer,omitempty"`
+
+	// Grounding is how well a flight answer matched its flight records; only recorded with the
+	// grounding check on.
+	Grounding *Grounding `bson:"grounding,omitempty" json:"grounding,omitempty"`
+}
+
+// LLMCall is one LLM call of a request: the prompt the pipeline wrote, without the persona's
+// system prompt, and the response, or the error that replaced it.
+type LLMCall struct {
+	Stage      string `bson:"stage" json:"stage"` // "llm1", "llm2" or "aggregation"
+	Model      string `bson:"model,omitempty" json:"model,omitempty"`
+	Prompt     string `bson:"prompt" json:"prompt"`
+	Response   string `bson:"response" json:"response"`
+	Error      string `bson:"error,omitempty" json:"error,omitempty"`
+	DurationMs int64  `bson:"duration_ms" json:"duration_ms"`
+
+	// ProviderRequestID is the ID the provider gave the call (OpenAI's x-request-id), to quote
+	// to its support; with retries, the last attempt's.
+	ProviderRequestID string `bson:"provider_request_id,omitempty" json:"provider_request_id,omitempty"`
+}
+
+// Grounding is how well a flight answer's facts match the flight records it was given: each
+// price, time and flight number stated in the answer is a claim, grounded if some record has it.
+type Grounding struct {
+	Score      float64             `bson:"score" json:"score"`   // Grounded claims over claims; 1 without claims
+	Claims     int                 `bson:"claims" json:"claims"` // Claims found in the answer
+	Mismatches []GroundingMismatch `bson:"mismatches,omitempty" json:"mismatches,omitempty"`
+}
+
+// GroundingMismatch is a claim no flight record supports.
+type GroundingMismatch struct {
+	Kind  string `bson:"kind" json:"kind"`   // "price", "time" or "flight_number"
+	Claim string `bson:"claim" json:"claim"` // As written in the answer, e.g. "$99.00"
+}
+
+// IntentCount is the number of logged queries with a given intent.
+type IntentCount struct {
+	Intent string `bson:"_id" json:"intent"`
+	Count  int    `bson:"count" json:"count"`
+}
+
+// RouteCount is the number of logged flight queries for an origin/destination pair.
+// Either side may be empty when the user only named one city.
+type RouteCount struct {
+	Origin      string `bson:"origin" json:"origin"`
+	Destination string `bson:"destination" json:"destination"`
+	Count       int    `bson:"count" json:"count"`
+}
+
+// QueryStats aggregates the query log from a point in time onwards.
+type QueryStats struct {
+	Since     time.Time     `json:"since"`
+	Total     int           `json:"total"`
+	NoResults int           `json:"no_results"` // Flight queries that found nothing.
+	ByIntent  []IntentCount `json:"by_intent"`
+	TopRoutes []RouteCount  `json:"top_routes"`
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Preferences are the defaults a chat session has asked to be remembered, stored in the
+// "preferences" collection keyed by the session ID. They fill in what a flight question
+// leaves out (the origin, the currency prices are shown in and the price limit) and pick the
+// language of requests that don't set one. Empty fields are not set.
+type Preferences struct {
+	SessionID string  `bson:"_id" json:"session_id"`
+	Client    string  `bson:"client,omitempty" json:"-"`                        // Account that set them; only it can read and change them
+	HomeCity  string  `bson:"home_city,omitempty" json:"home_city,omitempty"`   // Origin of questions that name none
+	Currency  string  `bson:"currency,omitempty" json:"currency,omitempty"`     // ISO code prices are shown in, e.g. "EUR"
+	Language  string  `bson:"language,omitempty" json:"language,omitempty"`     // "en" or "es", unless the request sets one
+	MaxBudget float64 `bson:"max_budget,omitempty" json:"max_budget,omitempty"` // Price limit, in Currency or else the stored prices' currency
+
+	// ConversationLanguage is the language, "en" or "es", the session's last answer was in. It
+	// isn't a preference the user sets: the server keeps it so that a short follow-up ("sí",
+	// "ok") is answered in the conversation's language rather than in the one detected from it.
+	ConversationLanguage string `bson:"conversation_language,omitempty" json:"conversation_language,omitempty"`
+
+	// SuggestedRoute is the reverse of the route of the session's last flight question, when
+	// the answer asked whether it was meant instead. Kept by the server like
+	// ConversationLanguage: the next message resolves it with a "yes", or else drops it.
+	SuggestedRoute *SuggestedRoute `bson:"suggested_route,omitempty" json:"suggested_route,omitempty"`
+
+	// LastFlights are the numbers of the flights the session's last flight answer gave, so a
+	// follow-up can ask about them without naming them ("what time does it land local time?").
+	// Kept by the server like ConversationLanguage.
+	LastFlights []string `bson:"last_flights,omitempty" json:"last_flights,omitempty"`
+
+	// Passengers is the party size the session's flight questions last gave ("for 3 people"),
+	// used for those that don't give one. Kept by the server like ConversationLanguage.
+	Passengers int `bson:"passengers,omitempty" json:"passengers,omitempty"`
+
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// SuggestedRoute is a flight search the user was asked to confirm.
+type SuggestedRoute struct {
+	Origin      string  `bson:"origin" json:"origin"`
+	Destination string  `bson:"destination" json:"destination"`
+	MaxPrice    float64 `bson:"max_price,omitempty" json:"max_price,omitempty"` // In the stored prices' currency
+	Currency    string  `bson:"currency,omitempty" json:"currency,omitempty"`   // ISO code prices are shown in; empty for the stored prices' one
+
+	OriginAirport      string `bson:"origin_airport,omitempty" json:"origin_airport,omitempty"` // IATA codes, if the question named airports
+	DestinationAirport string `bson:"destination_airport,omitempty" json:"destination_airport,omitempty"`
+}
+
+// GetPreferences returns a session's preferences, or an ErrNotFound error if it has none.
+func (m *MongoDBClient) GetPreferences(ctx context.Context, sessionID string) (Preferences, error) {
+	var prefs Preferences
+	if err := m.preferences.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&prefs); err != nil {
+		return Preferences{}, wrapErr("get preferences of session "+sessionID, err)
+	}
+	return prefs, nil
+}
+
+// SavePreferences stores prefs, replacing the session's stored preferences if there are any.
+func (m *MongoDBClient) SavePreferences(ctx context.Context, prefs Preferences) error {
+	_, err := m.preferences.ReplaceOne(ctx, bson.M{"_id": prefs.SessionID}, prefs, options.Replace().SetUpsert(true))
+	return wrapErr("save preferences of session "+prefs.SessionID, err)
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// checkPreferences checks storing and replacing a session's preferences.
+func checkPreferences(t *testing.T, c Client) {
+	ctx := context.Background()
+	if _, err := c.GetPreferences(ctx, "session-1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetPreferences of a new session: %v, want ErrNotFound", err)
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	prefs := Preferences{SessionID: "session-1", Client: "key:aaaa", HomeCity: "Madrid", Currency: "USD", Language: "es", MaxBudget: 300, Passengers: 3,
+		LastFlights: []string{"FL101"}, SuggestedRoute: &SuggestedRoute{Origin: "Paris", Destination: "Madrid"}, UpdatedAt: now}
+	if err := c.SavePreferences(ctx, prefs); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetPreferences(ctx, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Client != "key:aaaa" || got.HomeCity != "Madrid" || got.Currency != "USD" || got.Language != "es" || got.MaxBudget != 300 || got.Passengers != 3 ||
+		!slices.Equal(got.LastFlights, []string{"FL101"}) || got.SuggestedRoute == nil || *got.SuggestedRoute != *prefs.SuggestedRoute || !got.UpdatedAt.Equal(now) {
+		t.Errorf("GetPreferences = %+v, want %+v", got, prefs)
+	}
+
+	// Saving replaces them whole.
+	if err := c.SavePreferences(ctx, Preferences{SessionID: "session-1", Client: "key:aaaa", HomeCity: "Rome", UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.GetPreferences(ctx, "session-1"); err != nil || got.HomeCity != "Rome" || got.Currency != "" || got.SuggestedRoute != nil {
+		t.Errorf("after replacing: %+v, %v", got, err)
+	}
+}
+
+func TestMemoryPreferences(t *testing.T) {
+	checkPreferences(t, NewMemoryClient())
+}
+
+func TestMongoPreferences(t *testing.T) {
+	checkPreferences(t, newMongoTestClient(t))
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FlightQuery holds the filters for QueryFlights. Zero values mean "no filter".
+// City names match case-insensitively as substrings, airport codes exactly; with only a
+// destination given, flights touching that city (or airport) at either end match (so "flights
+// to London" also finds returns).
+type FlightQuery struct {
+	Origin       string
+	Destination  string
+	MinPrice     float64
+	MaxPrice     float64
+	DepartAfter  time.Time // Inclusive
+	DepartBefore time.Time // Exclusive
+	MinSeats     int       // Seats that must be available, for a party; 0 doesn't filter
+
+	// OriginAirport and DestinationAirport narrow a city to one of its airports, by IATA code
+	// ("JFK"); they can also be given without the city.
+	OriginAirport      string
+	DestinationAirport string
+}
+
+// cacheKey identifies the query for CachedClient. Times are formatted explicitly so
+// the monotonic clock reading and location pointer don't leak into the key.
+func (q FlightQuery) cacheKey() string {
+	return fmt.Sprintf("%s|%s|%g|%g|%s|%s|%s|%s|%d", q.Origin, q.Destination, q.MinPrice, q.MaxPrice,
+		q.DepartAfter.UTC().Format(time.RFC3339Nano), q.DepartBefore.UTC().Format(time.RFC3339Nano),
+		strings.ToUpper(q.OriginAirport), strings.ToUpper(q.DestinationAirport), q.MinSeats)
+}
+
+func (q FlightQuery) hasDateFilter() bool {
+	return !q.DepartAfter.IsZero() || !q.DepartBefore.IsZero()
+}
+
+// hasOrigin reports whether q filters the origin, by city or airport.
+func (q FlightQuery) hasOrigin() bool {
+	return q.Origin != "" || q.OriginAirport != ""
+}
+
+// matchesRoute applies the origin, destination, price and seat filters to f.
+func (q FlightQuery) matchesRoute(f Flight) bool {
+	end := func(city, airport, cityTerm, airportTerm string) bool {
+		return strings.Contains(strings.ToLower(city), strings.ToLower(cityTerm)) &&
+			(airportTerm == "" || strings.EqualFold(airport, airportTerm))
+	}
+	if !end(f.Origin, f.OriginAirport, q.Origin, q.OriginAirport) {
+		return false
+	}
+	if q.Destination != "" || q.DestinationAirport != "" {
+		if !end(f.Destination, f.DestinationAirport, q.Destination, q.DestinationAirport) &&
+			(q.hasOrigin() || !end(f.Origin, f.OriginAirport, q.Destination, q.DestinationAirport)) {
+			return false
+		}
+	}
+	if q.MinPrice > 0 && f.Price < q.MinPrice {
+		return false
+	}
+	if q.MaxPrice > 0 && f.Price > q.MaxPrice {
+		return false
+	}
+	return q.MinSeats <= 0 || f.AvailableSeats >= q.MinSeats
+}
+
+// matches applies every filter in q to f.
+func (q FlightQuery) matches(f Flight) bool {
+	if !q.matchesRoute(f) {
+		return false
+	}
+	if !q.hasDateFilter() {
+		return true
+	}
+	departure, err := time.Parse(time.RFC3339, f.DepartureTime)
+	if err != nil {
+		return false
+	}
+	if !q.DepartAfter.IsZero() && departure.Before(q.DepartAfter) {
+		return false
+	}
+	return q.DepartBefore.IsZero() || departure.Before(q.DepartBefore)
+}
+
+// mongoFilter builds the MongoDB filter document equivalent to matches.
+// Departure times are stored as RFC 3339 UTC strings, which compare correctly as strings.
+func (q FlightQuery) mongoFilter() bson.M {
+	// end filters one end of the flight ("origin" or "destination") by city and airport.
+	end := func(field, city, airport string) bson.M {
+		filter := bson.M{}
+		if city != "" {
+			filter[field] = bson.M{"$regex": city, "$options": "i"} // Case-insensitive match
+		}
+		if airport != "" {
+			filter[field+"_airport"] = strings.ToUpper(airport) // Stored in capitals
+		}
+		return filter
+	}
+	filter := end("origin", q.Origin, q.OriginAirport)
+	if q.Destination != "" || q.DestinationAirport != "" {
+		if !q.hasOrigin() {
+			// If only destination provided, search where either origin or destination matches
+			filter["$or"] = []bson.M{
+				end("destination", q.Destination, q.DestinationAirport),
+				end("origin", q.Destination, q.DestinationAirport),
+			}
+		} else {
+			maps.Copy(filter, end("destination", q.Destination, q.DestinationAirport))
+		}
+	}
+	// Add price filters if the bounds are specified (> 0)
+	if q.MinPrice > 0 || q.MaxPrice > 0 {
+		price := bson.M{}
+		if q.MinPrice > 0 {
+			price["$gte"] = q.MinPrice
+		}
+		if q.MaxPrice > 0 {
+			price["$lte"] = q.MaxPrice
+		}
+		filter["price"] = price
+	}
+	if q.MinSeats > 0 {
+		filter["available_seats"] = bson.M{"$gte": q.MinSeats}
+	}
+	if q.hasDateFilter() {
+		departure := bson.M{}
+		if !q.DepartAfter.IsZero() {
+			departure["$gte"] = q.DepartAfter.UTC().Format(time.RFC3339)
+		}
+		if !q.DepartBefore.IsZero() {
+			departure["$lt"] = q.DepartBefore.UTC().Format(time.RFC3339)
+		}
+		filter["departure_time"] = departure
+	}
+	return filter
+}
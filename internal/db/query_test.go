@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// checkSeatQueries checks that c's queries with MinSeats only return flights with that many
+// seats available.
+func checkSeatQueries(t *testing.T, c Client) {
+	t.Helper()
+	ctx := context.Background()
+	flights := []Flight{
+		testFlight("IB101", "Madrid", "Paris", 90),
+		testFlight("IB102", "Madrid", "Paris", 120),
+		testFlight("IB103", "Madrid", "Paris", 150),
+	}
+	flights[0].AvailableSeats, flights[1].AvailableSeats, flights[2].AvailableSeats = 2, 3, 40
+	if err := c.InsertFlights(ctx, flights); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		q    FlightQuery
+		want []string
+	}{
+		{"no party", FlightQuery{Origin: "Madrid", Destination: "Paris"}, []string{"IB101", "IB102", "IB103"}},
+		{"one passenger", FlightQuery{Origin: "Madrid", Destination: "Paris", MinSeats: 1}, []string{"IB101", "IB102", "IB103"}},
+		{"exactly the seats left", FlightQuery{Origin: "Madrid", Destination: "Paris", MinSeats: 3}, []string{"IB102", "IB103"}},
+		{"with a price limit", FlightQuery{Origin: "Madrid", Destination: "Paris", MinSeats: 3, MaxPrice: 130}, []string{"IB102"}},
+		{"more than any has", FlightQuery{Origin: "Madrid", Destination: "Paris", MinSeats: 41}, nil},
+	} {
+		got, err := c.QueryFlights(ctx, tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var numbers []string
+		for _, f := range got {
+			numbers = append(numbers, f.FlightNumber)
+		}
+		slices.Sort(numbers)
+		if !slices.Equal(numbers, tt.want) {
+			t.Errorf("%s: flights %v, want %v", tt.name, numbers, tt.want)
+		}
+	}
+}
+
+func TestMemorySeatQueries(t *testing.T) {
+	checkSeatQueries(t, NewMemoryClient())
+}
+
+func TestMongoSeatQueries(t *testing.T) {
+	checkSeatQueries(t, newMongoTestClient(t))
+}
+
+func TestCachedSeatQueries(t *testing.T) {
+	checkSeatQueries(t, NewCachedClient(NewMemoryClient(), time.Minute))
+	// Queries for different parties aren't cached as one.
+	q := FlightQuery{Origin: "Madrid", Destination: "Paris"}
+	party := q
+	party.MinSeats = 3
+	if q.cacheKey() == party.cacheKey() {
+		t.Errorf("cache key %q ignores the seats", q.cacheKey())
+	}
+}
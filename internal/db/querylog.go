@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// topRoutesLimit caps how many routes GetQueryStats reports.
+const topRoutesLimit = 10
+
+// InsertQueryLog stores one audit record in the "query_logs" collection.
+func (m *MongoDBClient) InsertQueryLog(ctx context.Context, entry QueryLog) error {
+	if _, err := m.queryLogs.InsertOne(ctx, entry); err != nil {
+		return wrapErr("insert query log", err)
+	}
+	return nil
+}
+
+// ensureQueryLogIndexes creates the index GetQueryLog looks records up by. Creating an
+// existing index is a no-op, so it runs at every start.
+func ensureQueryLogIndexes(ctx context.Context, queryLogs *mongo.Collection) error {
+	_, err := queryLogs.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "request_id", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return wrapErr("create query logs request ID index", err)
+}
+
+// GetQueryLog returns the audit record of the request with requestID, or an ErrNotFound error
+// if it wasn't logged.
+func (m *MongoDBClient) GetQueryLog(ctx context.Context, requestID string) (QueryLog, error) {
+	var entry QueryLog
+	opts := options.FindOne().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	if err := m.queryLogs.FindOne(ctx, bson.M{"request_id": requestID}, opts).Decode(&entry); err != nil {
+		return QueryLog{}, wrapErr("get query log "+requestID, err)
+	}
+	return entry, nil
+}
+
+// GetQueryStats summarizes the query log from since onwards in a single aggregation.
+// $facet runs the total, zero-result, per-intent and top-route pipelines over the same matched documents.
+func (m *MongoDBClient) GetQueryStats(ctx context.Context, since time.Time) (QueryStats, error) {
+	flightOnly := bson.M{"$match": bson.M{"intent": "flight"}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"timestamp": bson.M{"$gte": since}}}},
+		{{Key: "$facet", Value: bson.M{
+			"total": bson.A{bson.M{"$count": "n"}},
+			"no_results": bson.A{
+				bson.M{"$match": bson.M{"intent": "flight", "result_count": 0}},
+				bson.M{"$count": "n"},
+			},
+			"by_intent": bson.A{
+				bson.M{"$group": bson.M{"_id": "$intent", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+			"top_routes": bson.A{
+				flightOnly,
+				bson.M{"$group": bson.M{
+					"_id":   bson.M{"origin": "$origin", "destination": "$destination"},
+					"count": bson.M{"$sum": 1},
+				}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id.origin", Value: 1}, {Key: "_id.destination", Value: 1}}},
+				bson.M{"$limit": topRoutesLimit},
+				bson.M{"$project": bson.M{"_id": 0, "origin": "$_id.origin", "destination": "$_id.destination", "count": 1}},
+			},
+		}}},
+	}
+
+	cur, err := m.queryLogs.Aggregate(ctx, pipeline)
+	if err != nil {
+		return QueryStats{}, wrapErr("aggregate query logs", err)
+	}
+	defer cur.Close(ctx)
+
+	// $facet always yields exactly one document; counts come back as one-element arrays (or empty ones).
+	var facets []struct {
+		Total     []struct{ N int } `bson:"total"`
+		NoResults []struct{ N int } `bson:"no_results"`
+		ByIntent  []IntentCount     `bson:"by_intent"`
+		TopRoutes []RouteCount      `bson:"top_routes"`
+	}
+	if err := cur.All(ctx, &facets); err != nil {
+		return QueryStats{}, wrapErr("decode query stats", err)
+	}
+
+	stats := QueryStats{Since: since, ByIntent: []IntentCount{}, TopRoutes: []RouteCount{}}
+	if len(facets) == 0 {
+		return stats, nil
+	}
+	f := facets[0]
+	if len(f.Total) > 0 {
+		stats.Total = f.Total[0].N
+	}
+	if len(f.NoResults) > 0 {
+		stats.NoResults = f.NoResults[0].N
+	}
+	if f.ByIntent != nil {
+		stats.ByIntent = f.ByIntent
+	}
+	if f.TopRoutes != nil {
+		stats.TopRoutes = f.TopRoutes
+	}
+	return stats, nil
+}
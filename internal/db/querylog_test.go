@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testQueryLogs inserts a day of audit records ending at now into c.
+func testQueryLogs(t *testing.T, c Client, now time.Time) {
+	t.Helper()
+	for _, entry := range []QueryLog{
+		{RequestID: "old", Timestamp: now.Add(-48 * time.Hour), Intent: "flight", Origin: "Tokyo", Destination: "Seoul", ResultCount: 0},
+		{RequestID: "r1", Timestamp: now.Add(-3 * time.Hour), Intent: "flight", Origin: "Madrid", Destination: "Paris", ResultCount: 2},
+		{RequestID: "r2", Timestamp: now.Add(-2 * time.Hour), Intent: "flight", Origin: "Madrid", Destination: "Paris", ResultCount: 0},
+		{RequestID: "r3", Timestamp: now.Add(-2 * time.Hour), Intent: "flight", Origin: "London", Destination: "Rome", ResultCount: 1},
+		{RequestID: "r4", Timestamp: now.Add(-time.Hour), Intent: "general", Message: "Hi"},
+		{RequestID: "r5", Timestamp: now.Add(-time.Hour), Intent: "general", Message: "Thanks"},
+		{RequestID: "r6", Timestamp: now.Add(-time.Minute), Intent: "routes", Origin: "Madrid"},
+		{RequestID: "r6", Timestamp: now, Intent: "flight", Origin: "Berlin", Destination: "Rome", ResultCount: 3}, // A retry
+	} {
+		if err := c.InsertQueryLog(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkQueryStats checks c's summary of the records testQueryLogs inserted.
+func checkQueryStats(t *testing.T, c Client) {
+	t.Helper()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	testQueryLogs(t, c, now)
+	since := now.Add(-24 * time.Hour)
+	stats, err := c.GetQueryStats(context.Background(), since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := QueryStats{
+		Since:     since,
+		Total:     7,
+		NoResults: 1,
+		ByIntent:  []IntentCount{{"flight", 4}, {"general", 2}, {"routes", 1}},
+		TopRoutes: []RouteCount{{"Madrid", "Paris", 2}, {"Berlin", "Rome", 1}, {"London", "Rome", 1}},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("stats = %+v\nwant %+v", stats, want)
+	}
+
+	// A window with nothing in it still has empty lists, for the JSON.
+	stats, err = c.GetQueryStats(context.Background(), now.Add(time.Hour))
+	if err != nil || stats.Total != 0 || stats.ByIntent == nil || stats.TopRoutes == nil {
+		t.Errorf("empty window = %+v, %v", stats, err)
+	}
+}
+
+// checkGetQueryLog checks that c finds the latest record of a request ID.
+func checkGetQueryLog(t *testing.T, c Client) {
+	t.Helper()
+	testQueryLogs(t, c, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	if entry, err := c.GetQueryLog(context.Background(), "r6"); err != nil || entry.Intent != "flight" {
+		t.Errorf("GetQueryLog(r6) = %+v, %v; want the retry's record", entry, err)
+	}
+	if _, err := c.GetQueryLog(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetQueryLog(missing) err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryQueryStats(t *testing.T) {
+	checkQueryStats(t, NewMemoryClient())
+}
+
+func TestMemoryGetQueryLog(t *testing.T) {
+	checkGetQueryLog(t, NewMemoryClient())
+}
+
+func TestMongoQueryStats(t *testing.T) {
+	checkQueryStats(t, newMongoTestClient(t))
+}
+
+func TestMongoGetQueryLog(t *testing.T) {
+	checkGetQueryLog(t, newMongoTestClient(t))
+}
+
+func TestMemoryQueryStatsTopRoutesLimit(t *testing.T) {
+	m := NewMemoryClient()
+	now := time.Now()
+	for i := range topRoutesLimit + 5 {
+		entry := QueryLog{Timestamp: now, Intent: "flight", Origin: "Madrid", Destination: string(rune('A' + i))}
+		if err := m.InsertQueryLog(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, _ := m.GetQueryStats(context.Background(), now.Add(-time.Minute))
+	if len(stats.TopRoutes) != topRoutesLimit || stats.TopRoutes[0].Destination != "A" {
+		t.Errorf("top routes = %+v, want the first %d in order", stats.TopRoutes, topRoutesLimit)
+	}
+}
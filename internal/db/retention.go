@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RecordCounts counts records holding users' text, per collection: the records of one session
+// (DeleteSessionData) or the expired ones (PurgeExpired).
+type RecordCounts struct {
+	Conversations   int64 `json:"conversations"`
+	QueryLogs       int64 `json:"query_logs"`
+	Jobs            int64 `json:"jobs"`
+	IdempotencyKeys int64 `json:"idempotency_keys"`
+	Preferences     int64 `json:"preferences"`
+	Streams         int64 `json:"streams"`
+}
+
+// Total is the records of every collection together.
+func (c RecordCounts) Total() int64 {
+	return c.Conversations + c.QueryLogs + c.Jobs + c.IdempotencyKeys + c.Preferences + c.Streams
+}
+
+// Retention is how long PurgeExpired keeps the records holding users' text that don't expire on
+// their own; 0 keeps them forever. Jobs, idempotency keys and streams have their own expiry.
+type Retention struct {
+	Conversations time.Duration // After the last turn
+	QueryLogs     time.Duration // After the request
+	Preferences   time.Duration // After the last change
+}
+
+// DeleteSessionData deletes every record tied to sessionID, in every collection that holds
+// any, and returns how many it deleted; with dryRun it only counts them. The collections are
+// cleared one at a time, so after an error the counts are the records deleted so far, and
+// calling it again finishes the job. Usage records are per client and day and hold no text,
+// so they are kept.
+func (m *MongoDBClient) DeleteSessionData(ctx context.Context, sessionID string, dryRun bool) (RecordCounts, error) {
+	var counts RecordCounts
+	bySession := bson.M{"session_id": sessionID}
+	for _, s := range []struct {
+		coll   *mongo.Collection
+		filter bson.M
+		count  *int64
+	}{
+		{m.conversations, bySession, &counts.Conversations},
+		{m.queryLogs, bySession, &counts.QueryLogs},
+		{m.jobs, bySession, &counts.Jobs},
+		{m.idempotencyKeys, bySession, &counts.IdempotencyKeys},
+		{m.preferences, bson.M{"_id": sessionID}, &counts.Preferences},
+		{m.streams, bySession, &counts.Streams},
+	} {
+		n, err := deleteOrCount(ctx, s.coll, s.filter, dryRun)
+		if err != nil {
+			return counts, wrapErr("delete "+s.coll.Name()+" of session "+sessionID, err)
+		}
+		*s.count = n
+	}
+	return counts, nil
+}
+
+// PurgeExpired deletes the conversations, query logs and preferences older than r allows at
+// now, and returns how many it deleted.
+func (m *MongoDBClient) PurgeExpired(ctx context.Context, r Retention, now time.Time) (RecordCounts, error) {
+	var counts RecordCounts
+	for _, p := range []struct {
+		coll  *mongo.Collection
+		field string
+		keep  time.Duration
+		count *int64
+	}{
+		{m.conversations, "updated_at", r.Conversations, &counts.Conversations},
+		{m.queryLogs, "timestamp", r.QueryLogs, &counts.QueryLogs},
+		{m.preferences, "updated_at", r.Preferences, &counts.Preferences},
+	} {
+		if p.keep <= 0 {
+			continue
+		}
+		n, err := deleteOrCount(ctx, p.coll, bson.M{p.field: bson.M{"$lt": now.Add(-p.keep)}}, false)
+		if err != nil {
+			return counts, wrapErr("purge expired "+p.coll.Name(), err)
+		}
+		*p.count = n
+	}
+	return counts, nil
+}
+
+// deleteOrCount deletes the documents of coll matching filter, or with dryRun counts them.
+func deleteOrCount(ctx context.Context, coll *mongo.Collection, filter bson.M, dryRun bool) (int64, error) {
+	if dryRun {
+		return coll.CountDocuments(ctx, filter)
+	}
+	res, err := coll.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
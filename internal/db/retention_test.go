@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// seedSession stores records of sessionID, for client, in every collection that holds
+// session data, and one usage record, which is per client.
+func seedSession(t *testing.T, c Client, sessionID, client string, now time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(c.AppendTurns(ctx, sessionID, client,
+		Turn{Role: RoleUser, Content: "Flights from Madrid to Paris", Timestamp: now},
+		Turn{Role: RoleAssistant, Content: "FL101 leaves at 09:00.", Timestamp: now}))
+	check(c.ShareConversation(ctx, sessionID, client, ConversationShare{TokenHash: "hash-" + sessionID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	check(c.InsertQueryLog(ctx, QueryLog{RequestID: "req-" + sessionID, SessionID: sessionID, Message: "Flights from Madrid to Paris", Timestamp: now}))
+	check(c.SaveJob(ctx, Job{ID: "job-" + sessionID, Client: client, SessionID: sessionID, Status: JobDone, Answer: "FL101.", CreatedAt: now, UpdatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	check(c.CreateIdempotencyKey(ctx, IdempotencyRecord{ID: "idem-" + sessionID, SessionID: sessionID, Status: IdempotencyDone,
+		Events: []StoredEvent{{Type: "Message", Data: "FL101."}}, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	check(c.SavePreferences(ctx, Preferences{SessionID: sessionID, Client: client, HomeCity: "Madrid", UpdatedAt: now}))
+	check(c.AppendStreamEvents(ctx, "stream-"+sessionID, sessionID, []StoredEvent{{Type: "Message", Data: "FL101.", Seq: 1}}, true, now.Add(time.Hour)))
+	check(c.IncrementUsage(ctx, UsageDelta{Client: client, Time: now, Requests: 1}))
+}
+
+// sessionRecords returns which of the records seedSession stored for sessionID are left.
+func sessionRecords(t *testing.T, c Client, sessionID string) []string {
+	t.Helper()
+	ctx := context.Background()
+	var left []string
+	for name, err := range map[string]error{
+		"conversation": func() error { _, err := c.GetConversation(ctx, sessionID); return err }(),
+		"share":        func() error { _, err := c.GetSharedConversation(ctx, "hash-"+sessionID, time.Now()); return err }(),
+		"query log":    func() error { _, err := c.GetQueryLog(ctx, "req-"+sessionID); return err }(),
+		"job":          func() error { _, err := c.GetJob(ctx, "job-"+sessionID); return err }(),
+		"idempotency":  func() error { _, err := c.GetIdempotencyKey(ctx, "idem-"+sessionID); return err }(),
+		"preferences":  func() error { _, err := c.GetPreferences(ctx, sessionID); return err }(),
+		"stream":       func() error { _, err := c.GetStream(ctx, "stream-"+sessionID); return err }(),
+	} {
+		switch {
+		case err == nil:
+			left = append(left, name)
+		case !errors.Is(err, ErrNotFound):
+			t.Fatalf("%s of %s: %v", name, sessionID, err)
+		}
+	}
+	return left
+}
+
+// checkDeleteSessionData checks that deleting a session's data leaves none of it, and only it.
+func checkDeleteSessionData(t *testing.T, c Client) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	seedSession(t, c, "session-gone", "key:aaaa", now)
+	seedSession(t, c, "session-kept", "key:aaaa", now)
+	want := RecordCounts{Conversations: 1, QueryLogs: 1, Jobs: 1, IdempotencyKeys: 1, Preferences: 1, Streams: 1}
+
+	// A dry run counts them and deletes nothing.
+	counts, err := c.DeleteSessionData(ctx, "session-gone", true)
+	if err != nil || counts != want {
+		t.Fatalf("dry run: %+v, %v, want %+v", counts, err, want)
+	}
+	if left := sessionRecords(t, c, "session-gone"); len(left) != 7 {
+		t.Fatalf("the dry run deleted records: %v left", left)
+	}
+
+	counts, err = c.DeleteSessionData(ctx, "session-gone", false)
+	if err != nil || counts != want || counts.Total() != 6 {
+		t.Fatalf("DeleteSessionData = %+v, %v, want %+v", counts, err, want)
+	}
+	if left := sessionRecords(t, c, "session-gone"); len(left) != 0 {
+		t.Errorf("records left after deletion: %v", left)
+	}
+	if left := sessionRecords(t, c, "session-kept"); len(left) != 7 {
+		t.Errorf("another session's records deleted: %v left", left)
+	}
+	// Usage is per client and day, without text, so it stays.
+	if usage, err := c.ListUsage(ctx, UsageQuery{Client: "key:aaaa"}); err != nil || len(usage) == 0 {
+		t.Errorf("usage %+v, %v", usage, err)
+	}
+
+	// Deleting again finds nothing.
+	if counts, err := c.DeleteSessionData(ctx, "session-gone", false); err != nil || counts.Total() != 0 {
+		t.Errorf("second deletion: %+v, %v", counts, err)
+	}
+}
+
+func TestMemoryDeleteSessionData(t *testing.T) {
+	checkDeleteSessionData(t, NewMemoryClient())
+}
+
+func TestMongoDeleteSessionData(t *testing.T) {
+	checkDeleteSessionData(t, newMongoTestClient(t))
+}
+
+// checkPurgeExpired checks that only records older than the retention are purged.
+func checkPurgeExpired(t *testing.T, c Client) {
+	ctx := context.Background()
+	seedSession(t, c, "session-old", "key:aaaa", time.Now().UTC())
+	time.Sleep(100 * time.Millisecond)
+	seedSession(t, c, "session-new", "key:aaaa", time.Now().UTC())
+	now := time.Now()
+
+	// Without a retention nothing is purged.
+	if counts, err := c.PurgeExpired(ctx, Retention{}, now); err != nil || counts.Total() != 0 {
+		t.Fatalf("PurgeExpired without retention = %+v, %v", counts, err)
+	}
+	keep := 50 * time.Millisecond
+	counts, err := c.PurgeExpired(ctx, Retention{Conversations: keep, QueryLogs: keep, Preferences: keep}, now)
+	if want := (RecordCounts{Conversations: 1, QueryLogs: 1, Preferences: 1}); err != nil || counts != want {
+		t.Fatalf("PurgeExpired = %+v, %v, want %+v", counts, err, want)
+	}
+	// Jobs, idempotency keys and streams expire on their own.
+	if left := sessionRecords(t, c, "session-old"); len(left) != 3 {
+		t.Errorf("old records left: %v, want the job, idempotency key and stream", left)
+	}
+	if left := sessionRecords(t, c, "session-new"); len(left) != 7 {
+		t.Errorf("new records purged: %v left", left)
+	}
+}
+
+func TestMemoryPurgeExpired(t *testing.T) {
+	checkPurgeExpired(t, NewMemoryClient())
+}
+
+func TestMongoPurgeExpired(t *testing.T) {
+	checkPurgeExpired(t, newMongoTestClient(t))
+}
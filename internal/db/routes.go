@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Route is a pair of cities with at least one flight or schedule between them, in that direction.
+type Route struct {
+	Origin      string `bson:"origin" json:"origin"`
+	Destination string `bson:"destination" json:"destination"`
+}
+
+// sortRoutes drops duplicate routes and orders the rest by origin, then destination.
+func sortRoutes(routes []Route) []Route {
+	seen := make(map[Route]bool, len(routes))
+	unique := routes[:0]
+	for _, r := range routes {
+		if r.Origin == "" || r.Destination == "" || seen[r] {
+			continue
+		}
+		seen[r] = true
+		unique = append(unique, r)
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		if unique[i].Origin != unique[j].Origin {
+			return unique[i].Origin < unique[j].Origin
+		}
+		return unique[i].Destination < unique[j].Destination
+	})
+	return unique
+}
+
+// Cities returns the cities routes touch at either end, sorted and without duplicates.
+func Cities(routes []Route) []string {
+	seen := make(map[string]bool)
+	var cities []string
+	for _, r := range routes {
+		for _, city := range []string{r.Origin, r.Destination} {
+			if !seen[city] {
+				seen[city] = true
+				cities = append(cities, city)
+			}
+		}
+	}
+	sort.Strings(cities)
+	return cities
+}
+
+// ListRoutes returns every route served by a stored flight or schedule, ordered by origin,
+// then destination.
+func (m *MongoDBClient) ListRoutes(ctx context.Context) ([]Route, error) {
+	pipeline := []bson.M{
+		{"$group": bson.M{"_id": bson.M{"origin": "$origin", "destination": "$destination"}}},
+		{"$replaceWith": "$_id"},
+	}
+	var routes []Route
+	for name, collection := range map[string]*mongo.Collection{"flights": m.flightReads, "schedules": m.scheduleReads} {
+		cur, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(m.maxQueryTime))
+		if err != nil {
+			return nil, wrapErr("list routes of "+name, err)
+		}
+		var found []Route
+		if err := cur.All(ctx, &found); err != nil {
+			return nil, wrapErr("decode routes of "+name, err)
+		}
+		routes = append(routes, found...)
+	}
+	return sortRoutes(routes), nil
+}
+
+// ListRoutes mirrors MongoDBClient.ListRoutes.
+func (m *MemoryClient) ListRoutes(ctx context.Context) ([]Route, error) {
+	if err := checkContext(ctx, "list routes"); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	routes := make([]Route, 0, len(m.flights)+len(m.schedules))
+	for _, f := range m.flights {
+		routes = append(routes, Route{Origin: f.Origin, Destination: f.Destination})
+	}
+	for _, s := range m.schedules {
+		routes = append(routes, Route{Origin: s.Origin, Destination: s.Destination})
+	}
+	return sortRoutes(routes), nil
+}
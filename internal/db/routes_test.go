@@ -0,0 +1,74 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"testing"
+)
+
+// checkRoutes checks a seeded backend's routes: each once, in order, from flights and schedules.
+func checkRoutes(t *testing.T, c Client) {
+	ctx := context.Background()
+	routes, err := c.ListRoutes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.IsSortedFunc(routes, func(a, b Route) int {
+		return cmp.Or(cmp.Compare(a.Origin, b.Origin), cmp.Compare(a.Destination, b.Destination))
+	}) {
+		t.Errorf("routes not sorted: %v", routes)
+	}
+	var fromMadrid []string
+	for i, r := range routes {
+		if i > 0 && routes[i-1] == r {
+			t.Errorf("route %v listed twice", r)
+		}
+		if r.Origin == "Madrid" {
+			fromMadrid = append(fromMadrid, r.Destination)
+		}
+	}
+	if want := []string{"Barcelona", "Paris", "Valencia"}; !slices.Equal(fromMadrid, want) {
+		t.Errorf("destinations from Madrid %v, want %v", fromMadrid, want)
+	}
+
+	// A route only a schedule serves is listed too.
+	if err := c.UpsertSchedule(ctx, FlightSchedule{FlightNumber: "FL900", Origin: "Madrid", Destination: "Lisbon", DaysOfWeek: []int{1},
+		DepartureTime: "07:00", DurationMinutes: 80, ValidFrom: "2025-01-01", ValidTo: "2025-12-31", Price: 80, AvailableSeats: 100}); err != nil {
+		t.Fatal(err)
+	}
+	routes, err = c.ListRoutes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(routes, Route{Origin: "Madrid", Destination: "Lisbon"}) {
+		t.Errorf("routes %v, want the scheduled one", routes)
+	}
+	if cities := Cities(routes); !slices.Contains(cities, "Lisbon") || !slices.IsSorted(cities) || len(slices.Compact(slices.Clone(cities))) != len(cities) {
+		t.Errorf("cities %v", cities)
+	}
+}
+
+func TestMemoryRoutes(t *testing.T) {
+	c := NewMemoryClient()
+	if err := c.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkRoutes(t, c)
+}
+
+func TestMongoRoutes(t *testing.T) {
+	c := newMongoTestClient(t)
+	if err := c.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkRoutes(t, c)
+}
+
+func TestSortRoutes(t *testing.T) {
+	got := sortRoutes([]Route{{"Rome", "Paris"}, {"Madrid", "Paris"}, {"", "Paris"}, {"Madrid", "Barcelona"}, {"Rome", "Paris"}})
+	want := []Route{{"Madrid", "Barcelona"}, {"Madrid", "Paris"}, {"Rome", "Paris"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("sortRoutes = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxExpansionDays bounds how far a schedule is expanded when a query only gives one end of the date range.
+const maxExpansionDays = 60
+
+// dateLayout is the format of schedule validity dates.
+const dateLayout = "2006-01-02"
+
+// FlightSchedule is a recurring flight ("Madrid → Paris every Mon/Wed/Fri at 09:00")
+// stored in the "schedules" collection. Schedules are keyed by flight number and are
+// expanded into dated Flight instances at query time by QueryFlights.
+type FlightSchedule struct {
+	FlightNumber    string  `bson:"flight_number" json:"flight_number"`
+	Origin          string  `bson:"origin" json:"origin"`
+	Destination     string  `bson:"destination" json:"destination"`
+	DaysOfWeek      []int   `bson:"days_of_week" json:"days_of_week"`         // 0 = Sunday … 6 = Saturday (time.Weekday)
+	DepartureTime   string  `bson:"departure_time" json:"departure_time"`     // "HH:MM", UTC
+	DurationMinutes int     `bson:"duration_minutes" json:"duration_minutes"` // Block time from departure to arrival
+	ValidFrom       string  `bson:"valid_from" json:"valid_from"`             // "YYYY-MM-DD", inclusive
+	ValidTo         string  `bson:"valid_to" json:"valid_to"`                 // "YYYY-MM-DD", inclusive
+	Price           float64 `bson:"price" json:"price"`
+	AvailableSeats  int     `bson:"available_seats" json:"available_seats"`
+
+	OriginAirport      string `bson:"origin_airport" json:"origin_airport,omitempty"` // IATA codes, as on Flight
+	DestinationAirport string `bson:"destination_airport" json:"destination_airport,omitempty"`
+}
+
+// Validate checks that a schedule can be expanded.
+func (s FlightSchedule) Validate() error {
+	var problems []string
+	if strings.TrimSpace(s.FlightNumber) == "" {
+		problems = append(problems, "flight_number is required")
+	}
+	if strings.TrimSpace(s.Origin) == "" || strings.TrimSpace(s.Destination) == "" {
+		problems = append(problems, "origin and destination are required")
+	}
+	problems = append(problems, airportProblems(s.OriginAirport, s.DestinationAirport)...)
+	if len(s.DaysOfWeek) == 0 {
+		problems = append(problems, "days_of_week must list at least one day")
+	}
+	for _, d := range s.DaysOfWeek {
+		if d < 0 || d > 6 {
+			problems = append(problems, fmt.Sprintf("day %d is out of range 0-6", d))
+		}
+	}
+	if _, err := time.Parse("15:04", s.DepartureTime); err != nil {
+		problems = append(problems, "departure_time must be HH:MM")
+	}
+	if s.DurationMinutes <= 0 {
+		problems = append(problems, "duration_minutes must be positive")
+	}
+	from, fromErr := time.Parse(dateLayout, s.ValidFrom)
+	to, toErr := time.Parse(dateLayout, s.ValidTo)
+	if fromErr != nil || toErr != nil {
+		problems = append(problems, "valid_from and valid_to must be YYYY-MM-DD dates")
+	} else if to.Before(from) {
+		problems = append(problems, "valid_to must not be before valid_from")
+	}
+	if s.Price < 0 || s.AvailableSeats < 0 {
+		problems = append(problems, "price and available_seats must not be negative")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Expand materializes the schedule's flights departing in [from, to).
+// Each instance keeps the schedule's flight number, as a real daily flight would.
+func (s FlightSchedule) Expand(from, to time.Time) []Flight {
+	validFrom, err1 := time.Parse(dateLayout, s.ValidFrom)
+	validTo, err2 := time.Parse(dateLayout, s.ValidTo)
+	clock, err3 := time.Parse("15:04", s.DepartureTime)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil // Invalid schedules are rejected on write; skip any legacy bad data.
+	}
+
+	days := make(map[time.Weekday]bool, len(s.DaysOfWeek))
+	for _, d := range s.DaysOfWeek {
+		days[time.Weekday(d)] = true
+	}
+
+	from, to = from.UTC(), to.UTC()
+	var flights []Flight
+	for day := from.Truncate(24 * time.Hour); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if day.Before(validFrom) || day.After(validTo) || !days[day.Weekday()] {
+			continue
+		}
+		departure := day.Add(time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute)
+		if departure.Before(from) || !departure.Before(to) {
+			continue
+		}
+		flights = append(flights, Flight{
+			FlightNumber:   s.FlightNumber,
+			Origin:         s.Origin,
+			Destination:    s.Destination,
+			DepartureTime:  departure.Format(time.RFC3339),
+			ArrivalTime:    departure.Add(time.Duration(s.DurationMinutes) * time.Minute).Format(time.RFC3339),
+			Price:          s.Price,
+			AvailableSeats: s.AvailableSeats,
+
+			OriginAirport:      s.OriginAirport,
+			DestinationAirport: s.DestinationAirport,
+		})
+	}
+	return flights
+}
+
+// expandSchedules returns the instances of all schedules matching q's route and price filters.
+// It only runs for queries with a date filter; an open end of the range is capped at maxExpansionDays.
+func expandSchedules(schedules []FlightSchedule, q FlightQuery) []Flight {
+	if !q.hasDateFilter() {
+		return nil
+	}
+	from, to := q.DepartAfter, q.DepartBefore
+	switch {
+	case from.IsZero():
+		from = to.AddDate(0, 0, -maxExpansionDays)
+	case to.IsZero():
+		to = from.AddDate(0, 0, maxExpansionDays)
+	}
+
+	var flights []Flight
+	for _, s := range schedules {
+		template := Flight{Origin: s.Origin, Destination: s.Destination, Price: s.Price, AvailableSeats: s.AvailableSeats, OriginAirport: s.OriginAirport, DestinationAirport: s.DestinationAirport}
+		if !q.matchesRoute(template) {
+			continue
+		}
+		flights = append(flights, s.Expand(from, to)...)
+	}
+	return flights
+}
+
+// sortByDeparture orders flights chronologically (RFC 3339 UTC strings sort lexically).
+func sortByDeparture(flights []Flight) {
+	sort.SliceStable(flights, func(i, j int) bool {
+		return flights[i].DepartureTime < flights[j].DepartureTime
+	})
+}
+
+// sampleSchedules returns the demo recurring flights seeded alongside sampleFlights.
+func sampleSchedules() []FlightSchedule {
+	return []FlightSchedule{
+		{
+			FlightNumber:    "FL201",
+			Origin:          "Madrid",
+			Destination:     "Paris",
+			DaysOfWeek:      []int{1, 3, 5}, // Mon, Wed, Fri
+			DepartureTime:   "09:00",
+			DurationMinutes: 120,
+			ValidFrom:       "2025-08-01",
+			ValidTo:         "2026-12-31",
+			Price:           125.0,
+			AvailableSeats:  60,
+		},
+		{
+			FlightNumber:    "FL202",
+			Origin:          "London",
+			Destination:     "Berlin",
+			DaysOfWeek:      []int{0, 1, 2, 3, 4, 5, 6}, // Daily
+			DepartureTime:   "07:30",
+			DurationMinutes: 120,
+			ValidFrom:       "2025-08-01",
+			ValidTo:         "2026-12-31",
+			Price:           150.0,
+			AvailableSeats:  90,
+		},
+	}
+}
+
+// UpsertSchedule creates or replaces the schedule with the same flight number.
+func (m *MongoDBClient) UpsertSchedule(ctx context.Context, schedule FlightSchedule) error {
+	filter := bson.M{"flight_number": schedule.FlightNumber}
+	_, err := m.schedules.ReplaceOne(ctx, filter, schedule, options.Replace().SetUpsert(true))
+	if err != nil {
+		return wrapErr("upsert schedule "+schedule.FlightNumber, err)
+	}
+	return nil
+}
+
+// GetSchedule returns the schedule for a flight number, or an ErrNotFound error if there is none.
+func (m *MongoDBClient) GetSchedule(ctx context.Context, flightNumber string) (FlightSchedule, error) {
+	var schedule FlightSchedule
+	err := m.schedules.FindOne(ctx, bson.M{"flight_number": flightNumber}).Decode(&schedule)
+	if err != nil {
+		return FlightSchedule{}, wrapErr("get schedule "+flightNumber, err)
+	}
+	return schedule, nil
+}
+
+// ListSchedules returns every stored schedule.
+func (m *MongoDBClient) ListSchedules(ctx context.Context) ([]FlightSchedule, error) {
+	return listSchedules(ctx, m.schedules)
+}
+
+// listSchedules returns every schedule in collection, the schedules with the read preference
+// the caller reads them with.
+func listSchedules(ctx context.Context, collection *mongo.Collection, opts ...*options.FindOptions) ([]FlightSchedule, error) {
+	cur, err := collection.Find(ctx, bson.M{}, opts...)
+	if err != nil {
+		return nil, wrapErr("list schedules", err)
+	}
+	var schedules []FlightSchedule
+	if err := cur.All(ctx, &schedules); err != nil {
+		return nil, wrapErr("decode schedules", err)
+	}
+	return schedules, nil
+}
+
+// DeleteSchedule removes the schedule for a flight number, returning an ErrNotFound error if there is none.
+func (m *MongoDBClient) DeleteSchedule(ctx context.Context, flightNumber string) error {
+	res, err := m.schedules.DeleteOne(ctx, bson.M{"flight_number": flightNumber})
+	if err != nil {
+		return wrapErr("delete schedule "+flightNumber, err)
+	}
+	if res.DeletedCount == 0 {
+		return wrapErr("delete schedule "+flightNumber, ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// monWedFri is a Madrid → Paris flight every Monday, Wednesday and Friday at 09:00 in March 2026.
+var monWedFri = FlightSchedule{
+	FlightNumber:    "FL201",
+	Origin:          "Madrid",
+	Destination:     "Paris",
+	DaysOfWeek:      []int{1, 3, 5},
+	DepartureTime:   "09:00",
+	DurationMinutes: 125,
+	ValidFrom:       "2026-03-01",
+	ValidTo:         "2026-03-31",
+	Price:           125,
+	AvailableSeats:  60,
+}
+
+// departures returns the departure times of flights.
+func departures(flights []Flight) []string {
+	var out []string
+	for _, f := range flights {
+		out = append(out, f.DepartureTime)
+	}
+	return out
+}
+
+func day(date string) time.Time {
+	t, _ := time.Parse(dateLayout, date)
+	return t
+}
+
+func TestExpandMonWedFriOverTwoWeeks(t *testing.T) {
+	// Monday 2 March to Monday 16 March, exclusive.
+	flights := monWedFri.Expand(day("2026-03-02"), day("2026-03-16"))
+	want := []string{
+		"2026-03-02T09:00:00Z", "2026-03-04T09:00:00Z", "2026-03-06T09:00:00Z",
+		"2026-03-09T09:00:00Z", "2026-03-11T09:00:00Z", "2026-03-13T09:00:00Z",
+	}
+	if got := departures(flights); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("departures = %v, want %v", got, want)
+	}
+	f := flights[0]
+	if f.FlightNumber != "FL201" || f.ArrivalTime != "2026-03-02T11:05:00Z" || f.Price != 125 || f.AvailableSeats != 60 {
+		t.Errorf("first instance = %+v", f)
+	}
+	if err := f.Validate(); err != nil {
+		t.Errorf("instance isn't a valid flight: %v", err)
+	}
+}
+
+func TestExpandBounds(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		from, to time.Time
+		want     []string
+	}{
+		{"before the validity window", day("2026-02-20"), day("2026-03-03"), []string{"2026-03-02T09:00:00Z"}},
+		{"after the validity window", day("2026-03-30"), day("2026-04-10"), []string{"2026-03-30T09:00:00Z"}},
+		{"range starting after the day's departure", day("2026-03-02").Add(10 * time.Hour), day("2026-03-05"), []string{"2026-03-04T09:00:00Z"}},
+		{"range ending at a departure", day("2026-03-02"), day("2026-03-04").Add(9 * time.Hour), []string{"2026-03-02T09:00:00Z"}},
+		{"no scheduled day", day("2026-03-07"), day("2026-03-09"), nil},
+		{"other time zone", time.Date(2026, 3, 2, 10, 0, 0, 0, time.FixedZone("CET", 3600)), day("2026-03-03"), []string{"2026-03-02T09:00:00Z"}},
+	} {
+		if got := departures(monWedFri.Expand(tt.from, tt.to)); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: departures = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		change func(*FlightSchedule)
+		want   string
+	}{
+		{"valid", func(*FlightSchedule) {}, ""},
+		{"no days", func(s *FlightSchedule) { s.DaysOfWeek = nil }, "days_of_week"},
+		{"day out of range", func(s *FlightSchedule) { s.DaysOfWeek = []int{7} }, "day 7"},
+		{"bad time", func(s *FlightSchedule) { s.DepartureTime = "9am" }, "HH:MM"},
+		{"no duration", func(s *FlightSchedule) { s.DurationMinutes = 0 }, "duration_minutes"},
+		{"window backwards", func(s *FlightSchedule) { s.ValidTo = "2026-02-01" }, "valid_to must not be before"},
+		{"bad date", func(s *FlightSchedule) { s.ValidFrom = "March" }, "YYYY-MM-DD"},
+		{"negative price", func(s *FlightSchedule) { s.Price = -1 }, "negative"},
+	} {
+		s := monWedFri
+		tt.change(&s)
+		err := s.Validate()
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// checkScheduleQueries checks that c's date-filtered queries include the schedule's instances.
+func checkScheduleQueries(t *testing.T, c Client) {
+	t.Helper()
+	ctx := context.Background()
+	if err := c.UpsertSchedule(ctx, monWedFri); err != nil {
+		t.Fatal(err)
+	}
+	dated := testFlight("IB101", "Madrid", "Paris", 90)
+	dated.DepartureTime, dated.ArrivalTime = "2026-03-05T07:00:00Z", "2026-03-05T09:00:00Z"
+	if err := c.InsertFlights(ctx, []Flight{dated, testFlight("LH400", "Berlin", "Rome", 80)}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		q    FlightQuery
+		want []string
+	}{
+		{"week", FlightQuery{Origin: "Madrid", Destination: "Paris", DepartAfter: day("2026-03-02"), DepartBefore: day("2026-03-09")},
+			[]string{"2026-03-02T09:00:00Z", "2026-03-04T09:00:00Z", "2026-03-05T07:00:00Z", "2026-03-06T09:00:00Z"}},
+		{"one day", FlightQuery{Origin: "Madrid", DepartAfter: day("2026-03-11"), DepartBefore: day("2026-03-12")},
+			[]string{"2026-03-11T09:00:00Z"}},
+		{"a day it doesn't fly", FlightQuery{Origin: "Madrid", DepartAfter: day("2026-03-10"), DepartBefore: day("2026-03-11")}, nil},
+		{"over the price limit", FlightQuery{Origin: "Madrid", MaxPrice: 100, DepartAfter: day("2026-03-02"), DepartBefore: day("2026-03-09")},
+			[]string{"2026-03-05T07:00:00Z"}},
+		{"other route", FlightQuery{Origin: "Berlin", DepartAfter: day("2026-03-01"), DepartBefore: day("2026-03-09")},
+			[]string{"2026-03-01T08:00:00Z"}},
+		{"no date filter", FlightQuery{Origin: "Madrid", Destination: "Paris"}, []string{"2026-03-05T07:00:00Z"}},
+		// The schedule has 60 seats a flight, the dated flight 10.
+		{"a party the schedule seats", FlightQuery{Origin: "Madrid", MinSeats: 60, DepartAfter: day("2026-03-02"), DepartBefore: day("2026-03-09")},
+			[]string{"2026-03-02T09:00:00Z", "2026-03-04T09:00:00Z", "2026-03-06T09:00:00Z"}},
+		{"a party over its seats", FlightQuery{Origin: "Madrid", MinSeats: 61, DepartAfter: day("2026-03-02"), DepartBefore: day("2026-03-09")}, nil},
+	} {
+		flights, err := c.QueryFlights(ctx, tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := departures(flights); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: departures = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// checkScheduleCRUD checks c's schedule writes and reads.
+func checkScheduleCRUD(t *testing.T, c Client) {
+	t.Helper()
+	ctx := context.Background()
+	if err := c.UpsertSchedule(ctx, monWedFri); err != nil {
+		t.Fatal(err)
+	}
+	changed := monWedFri
+	changed.Price = 99
+	if err := c.UpsertSchedule(ctx, changed); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.GetSchedule(ctx, "FL201"); err != nil || got.Price != 99 {
+		t.Errorf("GetSchedule = %+v, %v; want the replaced schedule", got, err)
+	}
+	if all, err := c.ListSchedules(ctx); err != nil || len(all) != 1 {
+		t.Errorf("ListSchedules = %+v, %v; want one schedule", all, err)
+	}
+	if err := c.DeleteSchedule(ctx, "FL201"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetSchedule(ctx, "FL201"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetSchedule after delete err = %v, want ErrNotFound", err)
+	}
+	if err := c.DeleteSchedule(ctx, "FL201"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second delete err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryScheduleQueries(t *testing.T) {
+	checkScheduleQueries(t, NewMemoryClient())
+}
+
+func TestMemoryScheduleCRUD(t *testing.T) {
+	checkScheduleCRUD(t, NewMemoryClient())
+}
+
+func TestMongoScheduleQueries(t *testing.T) {
+	checkScheduleQueries(t, newMongoTestClient(t))
+}
+
+func TestMongoScheduleCRUD(t *testing.T) {
+	checkScheduleCRUD(t, newMongoTestClient(t))
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SharedStream is the events of a request's stream as the replica running it stores them, so a
+// client that reconnects to another replica can be served from there. Streams are stored in
+// the "streams" collection and removed once ExpiresAt has passed.
+type SharedStream struct {
+	ID        string        `bson:"_id"`                  // The stream's ID
+	SessionID string        `bson:"session_id,omitempty"` // The request's session, so DeleteSessionData finds its events
+	Events    []StoredEvent `bson:"events"`
+	Done      bool          `bson:"done"` // The stream has finished; no events follow
+	ExpiresAt time.Time     `bson:"expires_at"`
+}
+
+// ensureStreamIndexes creates the TTL index that makes MongoDB delete expired streams.
+func ensureStreamIndexes(ctx context.Context, streams *mongo.Collection) error {
+	_, err := streams.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return wrapErr("create streams TTL index", err)
+}
+
+// AppendStreamEvents adds events to the end of the stored stream with the given ID, creating
+// it if there is none, and sets whether it is done and when it expires. sessionID is the
+// request's session, if it has one.
+func (m *MongoDBClient) AppendStreamEvents(ctx context.Context, id, sessionID string, events []StoredEvent, done bool, expiresAt time.Time) error {
+	if events == nil {
+		events = []StoredEvent{}
+	}
+	set := bson.M{"done": done, "expires_at": expiresAt}
+	if sessionID != "" {
+		set["session_id"] = sessionID
+	}
+	update := bson.M{
+		"$push": bson.M{"events": bson.M{"$each": events}},
+		"$set":  set,
+	}
+	_, err := m.streams.UpdateByID(ctx, id, update, options.Update().SetUpsert(true))
+	return wrapErr("append stream events", err)
+}
+
+// GetStream returns the stored stream with the given ID, or an ErrNotFound error if there is
+// none or it has expired.
+func (m *MongoDBClient) GetStream(ctx context.Context, id string) (SharedStream, error) {
+	var stream SharedStream
+	filter := bson.M{"_id": id, "expires_at": bson.M{"$gt": time.Now().UTC()}}
+	if err := m.streams.FindOne(ctx, filter).Decode(&stream); err != nil {
+		return SharedStream{}, wrapErr("get stream", err)
+	}
+	return stream, nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// checkStreams checks a backend's shared streams.
+func checkStreams(t *testing.T, c Client) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+	event := func(seq int64, data string) StoredEvent {
+		return StoredEvent{Type: "status", Data: data, Seq: seq, Timestamp: expiresAt.Add(-time.Hour)}
+	}
+
+	// Events are appended in order, in batches, until the stream is done.
+	if err := c.AppendStreamEvents(ctx, "stream-1", "session-1", []StoredEvent{event(1, "a"), event(2, "b")}, false, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.GetStream(ctx, "stream-1"); err != nil || got.ID != "stream-1" || got.SessionID != "session-1" || len(got.Events) != 2 || got.Done || !got.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("GetStream = %+v, %v", got, err)
+	}
+	if err := c.AppendStreamEvents(ctx, "stream-1", "session-1", []StoredEvent{event(3, "c")}, false, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AppendStreamEvents(ctx, "stream-1", "session-1", nil, true, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStream(ctx, "stream-1")
+	if err != nil || !got.Done || len(got.Events) != 3 {
+		t.Fatalf("GetStream once done = %+v, %v", got, err)
+	}
+	for i, e := range got.Events {
+		if e.Seq != int64(i+1) || e.Data != string(rune('a'+i)) {
+			t.Errorf("event %d = %+v", i, e)
+		}
+	}
+
+	// A stream that isn't there, or has expired, isn't found.
+	if _, err := c.GetStream(ctx, "no-such-stream"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetStream of an unknown stream = %v, want ErrNotFound", err)
+	}
+	if err := c.AppendStreamEvents(ctx, "stream-2", "", []StoredEvent{event(1, "a")}, true, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStream(ctx, "stream-2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetStream of an expired stream = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryStreams(t *testing.T) {
+	checkStreams(t, NewMemoryClient())
+}
+
+func TestMongoStreams(t *testing.T) {
+	checkStreams(t, newMongoTestClient(t))
+}
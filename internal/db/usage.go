@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UsageDayFormat is the layout of Usage.Day and of UsageQuery's bounds.
+const UsageDayFormat = "2006-01-02"
+
+// Usage is one client's LLM consumption on one day (UTC), stored in the "usage" collection.
+// There is one document per client and day, so a month's usage is at most 31 documents.
+type Usage struct {
+	Client           string    `bson:"client" json:"client"` // Account the usage is billed to, e.g. "key:3f2a…"
+	Day              string    `bson:"day" json:"day"`       // UsageDayFormat
+	Requests         int64     `bson:"requests" json:"requests"`
+	PromptTokens     int64     `bson:"prompt_tokens" json:"prompt_tokens"`
+	CompletionTokens int64     `bson:"completion_tokens" json:"completion_tokens"`
+	CostUSD          float64   `bson:"cost_usd" json:"cost_usd"` // Estimated from list prices
+	UpdatedAt        time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// TotalTokens is the prompt and completion tokens together, which is what quotas count.
+func (u Usage) TotalTokens() int64 {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// UsageDelta is what one request adds to its client's usage.
+type UsageDelta struct {
+	Client           string
+	Time             time.Time // Picks the day the usage is counted on
+	Requests         int64
+	PromptTokens     int64
+	CompletionTokens int64
+	CostUSD          float64
+}
+
+// UsageQuery selects daily usage records. Empty fields don't filter.
+type UsageQuery struct {
+	Client string
+	From   string // First day included (UsageDayFormat)
+	To     string // Last day included (UsageDayFormat)
+}
+
+// matches reports whether u is selected by q. Days compare correctly as strings.
+func (q UsageQuery) matches(u Usage) bool {
+	return (q.Client == "" || u.Client == q.Client) &&
+		(q.From == "" || u.Day >= q.From) &&
+		(q.To == "" || u.Day <= q.To)
+}
+
+// usageDocID keys a client's record of a day. Using it as _id makes the unique index that
+// keeps concurrent upserts from creating duplicates the one MongoDB always has.
+func usageDocID(client, day string) string {
+	return client + "/" + day
+}
+
+// IncrementUsage adds delta to its client's record for the day, creating the record if needed.
+// The update is a single $inc upsert, so concurrent increments are never lost.
+func (m *MongoDBClient) IncrementUsage(ctx context.Context, delta UsageDelta) error {
+	day := delta.Time.UTC().Format(UsageDayFormat)
+	update := bson.M{
+		"$inc": bson.M{
+			"requests":          delta.Requests,
+			"prompt_tokens":     delta.PromptTokens,
+			"completion_tokens": delta.CompletionTokens,
+			"cost_usd":          delta.CostUSD,
+		},
+		"$set":         bson.M{"updated_at": time.Now().UTC()},
+		"$setOnInsert": bson.M{"client": delta.Client, "day": day},
+	}
+	filter := bson.M{"_id": usageDocID(delta.Client, day)}
+	_, err := m.usage.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// Two first increments of the day raced to insert; the loser's retry updates the winner's document.
+		_, err = m.usage.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	}
+	if err != nil {
+		return wrapErr("increment usage of "+delta.Client, err)
+	}
+	return nil
+}
+
+// ListUsage returns the daily records selected by q, ordered by client and day.
+func (m *MongoDBClient) ListUsage(ctx context.Context, q UsageQuery) ([]Usage, error) {
+	filter := bson.M{}
+	if q.Client != "" {
+		filter["client"] = q.Client
+	}
+	days := bson.M{}
+	if q.From != "" {
+		days["$gte"] = q.From
+	}
+	if q.To != "" {
+		days["$lte"] = q.To
+	}
+	if len(days) > 0 {
+		filter["day"] = days
+	}
+	cur, err := m.usage.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "client", Value: 1}, {Key: "day", Value: 1}}))
+	if err != nil {
+		return nil, wrapErr("list usage", err)
+	}
+	usage := []Usage{}
+	if err := cur.All(ctx, &usage); err != nil {
+		return nil, wrapErr("decode usage", err)
+	}
+	return usage, nil
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// checkUsage increments two clients' usage concurrently across two days and lists it back.
+func checkUsage(t *testing.T, c Client) {
+	t.Helper()
+	ctx := context.Background()
+	day1 := time.Date(2026, 3, 1, 23, 30, 0, 0, time.UTC)
+	day2 := day1.Add(time.Hour) // The next day in UTC
+	var wg sync.WaitGroup
+	for range 20 {
+		for _, delta := range []UsageDelta{
+			{Client: "key:a", Time: day1, Requests: 1, PromptTokens: 10, CompletionTokens: 5, CostUSD: 0.5},
+			{Client: "key:a", Time: day2, Requests: 1, PromptTokens: 1, CompletionTokens: 1, CostUSD: 0.25},
+			{Client: "key:b", Time: day1, Requests: 1, PromptTokens: 2, CompletionTokens: 3, CostUSD: 0.125},
+		} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := c.IncrementUsage(ctx, delta); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	usage, err := c.ListUsage(ctx, UsageQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Usage{
+		{Client: "key:a", Day: "2026-03-01", Requests: 20, PromptTokens: 200, CompletionTokens: 100, CostUSD: 10},
+		{Client: "key:a", Day: "2026-03-02", Requests: 20, PromptTokens: 20, CompletionTokens: 20, CostUSD: 5},
+		{Client: "key:b", Day: "2026-03-01", Requests: 20, PromptTokens: 40, CompletionTokens: 60, CostUSD: 2.5},
+	}
+	if len(usage) != len(want) {
+		t.Fatalf("usage %+v, want %d records", usage, len(want))
+	}
+	for i, u := range usage {
+		u.UpdatedAt = time.Time{}
+		if u != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, u, want[i])
+		}
+	}
+	if usage[0].TotalTokens() != 300 {
+		t.Errorf("TotalTokens = %d", usage[0].TotalTokens())
+	}
+
+	for _, tt := range []struct {
+		q    UsageQuery
+		want int
+	}{
+		{UsageQuery{Client: "key:a"}, 2},
+		{UsageQuery{From: "2026-03-02"}, 1},
+		{UsageQuery{To: "2026-03-01"}, 2},
+		{UsageQuery{Client: "key:b", From: "2026-03-02"}, 0},
+	} {
+		if got, err := c.ListUsage(ctx, tt.q); err != nil || len(got) != tt.want {
+			t.Errorf("ListUsage(%+v) = %d records, %v; want %d", tt.q, len(got), err, tt.want)
+		}
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	checkUsage(t, NewMemoryClient())
+}
+
+func TestMongoUsage(t *testing.T) {
+	checkUsage(t, newMongoTestClient(t))
+}
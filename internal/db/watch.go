@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// pollInterval is how often backends without change streams check the flights collection for changes.
+const pollInterval = 15 * time.Second
+
+// FlightEvent describes a change to the flights collection.
+// Operation is the change-stream operation type ("insert", "update", "replace", "delete"),
+// or "refresh" when the change was detected by polling and the exact flight is unknown.
+type FlightEvent struct {
+	Operation    string
+	FlightNumber string // Empty for deletes and polling refreshes.
+}
+
+// Watcher is implemented by backends that can report changes to the flights collection.
+type Watcher interface {
+	// Watch calls onChange for every detected change until ctx is cancelled.
+	// It blocks, so callers normally run it in its own goroutine.
+	Watch(ctx context.Context, onChange func(FlightEvent)) error
+}
+
+// Watch follows the flights collection with a MongoDB change stream.
+// Change streams need a replica set; on a standalone server (the docker-compose default)
+// or if the stream breaks, it falls back to polling the document count.
+func (m *MongoDBClient) Watch(ctx context.Context, onChange func(FlightEvent)) error {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	stream, err := m.collection.Watch(ctx, mongo.Pipeline{}, opts)
+	if err != nil {
+		slog.InfoContext(ctx, "Change streams unavailable; polling flights instead", "error", err, "interval", pollInterval)
+		return pollFlights(ctx, pollInterval, m.flightsVersion, onChange)
+	}
+	defer stream.Close(context.Background())
+
+	slog.InfoContext(ctx, "Watching flights collection for changes")
+	for stream.Next(ctx) {
+		var change struct {
+			OperationType string  `bson:"operationType"`
+			FullDocument  *Flight `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&change); err != nil {
+			slog.WarnContext(ctx, "Error decoding flight change event", "error", err)
+			continue
+		}
+		event := FlightEvent{Operation: change.OperationType}
+		if change.FullDocument != nil {
+			event.FlightNumber = change.FullDocument.FlightNumber
+		}
+		onChange(event)
+	}
+	if ctx.Err() != nil {
+		return nil // Normal shutdown.
+	}
+	slog.WarnContext(ctx, "Flight change stream stopped; polling instead", "error", stream.Err())
+	return pollFlights(ctx, pollInterval, m.flightsVersion, onChange)
+}
+
+// flightsVersion uses the document count as a cheap change indicator for polling.
+// It misses in-place updates, which is acceptable for the fallback path; the search cache TTL covers those.
+func (m *MongoDBClient) flightsVersion(ctx context.Context) (int64, error) {
+	n, err := m.collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return 0, wrapErr("count flights", err)
+	}
+	return n, nil
+}
+
+// Watch polls the in-memory store's write counter, since there is no change feed to subscribe to.
+func (m *MemoryClient) Watch(ctx context.Context, onChange func(FlightEvent)) error {
+	return pollFlights(ctx, m.pollInterval, m.flightsVersion, onChange)
+}
+
+// flightsVersion returns a counter bumped on every flight write.
+func (m *MemoryClient) flightsVersion(ctx context.Context) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.version, nil
+}
+
+// pollFlights calls onChange with a "refresh" event whenever version reports a different value
+// than on the previous tick, every interval. It returns when ctx is cancelled.
+func pollFlights(ctx context.Context, interval time.Duration, version func(context.Context) (int64, error), onChange func(FlightEvent)) error {
+	last, err := version(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Error reading flights version", "error", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			current, err := version(ctx)
+			if err != nil {
+				slog.WarnContext(ctx, "Error reading flights version", "error", err)
+				continue
+			}
+			if current != last {
+				last = current
+				onChange(FlightEvent{Operation: "refresh"})
+			}
+		}
+	}
+}
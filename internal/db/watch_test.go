@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// watchAsync runs m.Watch in the background and returns a function that stops it and returns
+// Watch's error.
+func watchAsync(t *testing.T, m *MemoryClient, onChange func(FlightEvent)) (stop func() error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- m.Watch(ctx, onChange) }()
+	return func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("Watch didn't return once its context was cancelled")
+			return nil
+		}
+	}
+}
+
+func testFlight(number, origin, destination string, price float64) Flight {
+	return Flight{
+		FlightNumber: number, Origin: origin, Destination: destination,
+		DepartureTime: "2026-03-01T08:00:00Z", ArrivalTime: "2026-03-01T10:00:00Z",
+		Price: price, AvailableSeats: 10,
+	}
+}
+
+// waitWatching writes to m until the watcher reports a change, so it has read the version it
+// starts from, then drains changes.
+func waitWatching(t *testing.T, m *MemoryClient, changed <-chan FlightEvent) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		m.UpsertFlights(context.Background(), []Flight{testFlight("IB101", "Madrid", "Paris", 100)})
+		select {
+		case <-changed:
+			time.Sleep(4 * m.pollInterval) // A late report of an earlier write
+			for len(changed) > 0 {
+				<-changed
+			}
+			return
+		case <-time.After(4 * m.pollInterval):
+		}
+	}
+	t.Fatal("the watcher didn't report writes")
+}
+
+func TestMemoryWatchPolls(t *testing.T) {
+	m := NewMemoryClient()
+	m.pollInterval = 5 * time.Millisecond
+	events := make(chan FlightEvent, 16)
+	stop := watchAsync(t, m, func(ev FlightEvent) { events <- ev })
+	defer stop()
+	waitWatching(t, m, events)
+
+	// Nothing changes, nothing is reported.
+	select {
+	case ev := <-events:
+		t.Fatalf("got %+v with no change", ev)
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	if _, err := m.UpsertFlights(context.Background(), []Flight{testFlight("IB102", "Madrid", "Paris", 120)}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-events:
+		if ev != (FlightEvent{Operation: "refresh"}) {
+			t.Errorf("event = %+v, want a refresh", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the write wasn't reported")
+	}
+	// Once reported, a change isn't reported again.
+	select {
+	case ev := <-events:
+		t.Errorf("got %+v again", ev)
+	case <-time.After(30 * time.Millisecond):
+	}
+}
+
+func TestMemoryWatchStops(t *testing.T) {
+	m := NewMemoryClient()
+	m.pollInterval = time.Hour
+	stop := watchAsync(t, m, func(FlightEvent) {})
+	if err := stop(); err != nil {
+		t.Errorf("Watch = %v, want nil on shutdown", err)
+	}
+}
+
+func TestWatchInvalidatesCache(t *testing.T) {
+	m := NewMemoryClient()
+	m.pollInterval = 5 * time.Millisecond
+	cached := NewCachedClient(m, time.Hour)
+	events := make(chan FlightEvent, 16)
+	stop := watchAsync(t, m, func(ev FlightEvent) {
+		cached.Invalidate()
+		events <- ev
+	})
+	defer stop()
+	waitWatching(t, m, events)
+
+	search := func() int {
+		flights, err := cached.SearchFlights(context.Background(), "Madrid", "Paris", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(flights)
+	}
+	if n := search(); n != 1 {
+		t.Fatalf("%d flights, want 1", n)
+	}
+	// Another replica writes to the database, bypassing this cache.
+	m.UpsertFlights(context.Background(), []Flight{testFlight("IB102", "Madrid", "Paris", 120)})
+	if n := search(); n != 1 {
+		t.Fatalf("%d flights before the change was seen, want the cached 1", n)
+	}
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the change wasn't seen")
+	}
+	if n := search(); n != 2 {
+		t.Errorf("%d flights after the change was seen, want 2", n)
+	}
+}
+
+func TestPollFlightsSkipsFailedReads(t *testing.T) {
+	versions := []int64{1, 1, -1, 2, 2}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reads := 0
+	version := func(context.Context) (int64, error) {
+		if reads == len(versions)-1 {
+			cancel()
+		}
+		v := versions[min(reads, len(versions)-1)]
+		reads++
+		if v < 0 {
+			return 0, ErrUnavailable
+		}
+		return v, nil
+	}
+	var events []FlightEvent
+	if err := pollFlights(ctx, time.Millisecond, version, func(ev FlightEvent) { events = append(events, ev) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Errorf("events = %+v, want one refresh for the change from 1 to 2", events)
+	}
+}
@@ -0,0 +1,223 @@
+// Package flags holds the feature flags that roll pipeline behaviors out request by request:
+// to some API keys, some sessions or a percentage of clients, without a redeploy.
+//
+// Flags are defined in code with a default. The configuration can give a flag a rule, and a
+// rule stored in the database (the "flags" collection) overrides both; the server polls the
+// database, so a change takes effect on every replica without a restart. Each request is
+// evaluated once, when it starts, and keeps the resulting Set to the end.
+package flags
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the flags.
+const (
+	RoutePhrasing  = "route_phrasing"  // Have LLM 3 reword answers to route questions
+	GroundingCheck = "grounding_check" // Compare flight answers with their records
+	DBOnly         = "db_only"         // Answer from the flight records without LLM calls
+)
+
+// Definition is a flag the server knows.
+type Definition struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     bool   `json:"default"` // Whether it is on for everyone without a rule
+}
+
+// Definitions are the flags the server knows. Rules for other names are rejected by the
+// configuration and ignored in the database.
+var Definitions = []Definition{
+	{Name: RoutePhrasing, Description: "Have LLM 3 reword answers to route questions"},
+	{Name: GroundingCheck, Description: "Compare flight answers with their flight records"},
+	{Name: DBOnly, Description: "Answer from the flight records alone, without LLM calls"},
+}
+
+// Known reports whether name is a defined flag.
+func Known(name string) bool {
+	return slices.ContainsFunc(Definitions, func(d Definition) bool { return d.Name == name })
+}
+
+// Rule decides which requests a flag is on for. A flag is on if any field selects the request.
+type Rule struct {
+	Enabled  bool     `json:"enabled"`            // On for every request
+	Percent  float64  `json:"percent"`            // On for this share (0-100) of clients
+	Clients  []string `json:"clients,omitempty"`  // On for these clients, as Subject.Client
+	Sessions []string `json:"sessions,omitempty"` // On for these session IDs
+}
+
+// Validate checks the rule's fields.
+func (r Rule) Validate() error {
+	if r.Percent < 0 || r.Percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100, got %g", r.Percent)
+	}
+	return nil
+}
+
+// Subject is what a request is evaluated by.
+type Subject struct {
+	Client    string // The client's account, e.g. "key:1a2b3c4d5e6f7a8b" or "ip:203.0.113.7"
+	SessionID string
+}
+
+// on reports whether the rule selects s for the flag name.
+func (r Rule) on(name string, s Subject) bool {
+	switch {
+	case r.Enabled:
+		return true
+	case s.Client != "" && slices.Contains(r.Clients, s.Client):
+		return true
+	case s.SessionID != "" && slices.Contains(r.Sessions, s.SessionID):
+		return true
+	}
+	return r.Percent > 0 && float64(Bucket(name, s.Client)) < r.Percent*100
+}
+
+// Bucket places client in one of 10000 buckets for the flag name. The same client always
+// lands in the same bucket, so a client stays in a rollout as its percentage grows, and each
+// flag buckets clients differently, so the first few percent aren't the same clients for
+// every flag.
+func Bucket(name, client string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(client))
+	return int(h.Sum32() % 10000)
+}
+
+// Set is the flags that are on for one request, sorted by name. The zero value has every
+// flag off.
+type Set []string
+
+// On reports whether the named flag is on.
+func (s Set) On(name string) bool {
+	return slices.Contains(s, name)
+}
+
+// Where a flag's rule comes from.
+const (
+	SourceDefault  = "default"
+	SourceConfig   = "config"
+	SourceDatabase = "database"
+)
+
+// State is a flag's definition and the rule in effect.
+type State struct {
+	Definition
+	Rule   Rule   `json:"rule"`
+	Source string `json:"source"` // SourceDefault, SourceConfig or SourceDatabase
+}
+
+// Flags evaluates the flags for requests. The database's overrides can be replaced while it
+// is in use: it is safe for concurrent use.
+type Flags struct {
+	configured map[string]Rule
+	overrides  atomic.Pointer[map[string]Rule]
+}
+
+// New returns the flags with the rules of the configuration, by flag name. It fails on rules
+// for unknown flags and invalid rules.
+func New(configured map[string]Rule) (*Flags, error) {
+	for name, rule := range configured {
+		if !Known(name) {
+			return nil, fmt.Errorf("unknown flag %q", name)
+		}
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("flag %s: %w", name, err)
+		}
+	}
+	f := &Flags{configured: configured}
+	f.overrides.Store(&map[string]Rule{})
+	return f, nil
+}
+
+// rule returns the rule in effect for d and where it comes from.
+func (f *Flags) rule(d Definition) (Rule, string) {
+	if rule, ok := (*f.overrides.Load())[d.Name]; ok {
+		return rule, SourceDatabase
+	}
+	if rule, ok := f.configured[d.Name]; ok {
+		return rule, SourceConfig
+	}
+	return Rule{Enabled: d.Default}, SourceDefault
+}
+
+// Evaluate returns the flags that are on for s.
+func (f *Flags) Evaluate(s Subject) Set {
+	var set Set
+	for _, d := range Definitions {
+		if rule, _ := f.rule(d); rule.on(d.Name, s) {
+			set = append(set, d.Name)
+		}
+	}
+	sort.Strings(set)
+	return set
+}
+
+// States returns every flag with the rule in effect, in definition order.
+func (f *Flags) States() []State {
+	states := make([]State, len(Definitions))
+	for i, d := range Definitions {
+		rule, source := f.rule(d)
+		states[i] = State{Definition: d, Rule: rule, Source: source}
+	}
+	return states
+}
+
+// SetOverrides replaces the database's rules. Rules for unknown flags and invalid rules are
+// logged and left out, so one bad document doesn't hold back the others.
+func (f *Flags) SetOverrides(overrides map[string]Rule) {
+	valid := make(map[string]Rule, len(overrides))
+	for name, rule := range overrides {
+		if !Known(name) {
+			slog.Warn("Ignoring the override of an unknown feature flag", "flag", name)
+			continue
+		}
+		if err := rule.Validate(); err != nil {
+			slog.Warn("Ignoring an invalid feature flag override", "flag", name, "error", err)
+			continue
+		}
+		valid[name] = rule
+	}
+	f.overrides.Store(&valid)
+}
+
+// Loader reads the overrides, by flag name, from where they are stored.
+type Loader func(ctx context.Context) (map[string]Rule, error)
+
+// Refresh replaces the overrides with the ones load reads. If it fails, the overrides in use
+// are kept.
+func (f *Flags) Refresh(ctx context.Context, load Loader) error {
+	overrides, err := load(ctx)
+	if err != nil {
+		return err
+	}
+	f.SetOverrides(overrides)
+	return nil
+}
+
+// Poll refreshes the overrides every interval until ctx is done. Failures are logged; the
+// overrides in use are kept until a refresh succeeds.
+func (f *Flags) Poll(ctx context.Context, interval time.Duration, load Loader) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refreshCtx, cancel := context.WithTimeout(ctx, interval)
+			if err := f.Refresh(refreshCtx, load); err != nil {
+				slog.Warn("Failed to refresh feature flags; keeping the current ones", "error", err)
+			}
+			cancel()
+		}
+	}
+}
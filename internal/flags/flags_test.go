@@ -0,0 +1,181 @@
+package flags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	// The same client always lands in the same bucket, and flags bucket independently.
+	same := 0
+	for i := range 1000 {
+		client := fmt.Sprintf("key:%016x", i)
+		b := Bucket(RoutePhrasing, client)
+		if b < 0 || b >= 10000 || Bucket(RoutePhrasing, client) != b {
+			t.Fatalf("Bucket(%q) = %d, then %d", client, b, Bucket(RoutePhrasing, client))
+		}
+		if Bucket(GroundingCheck, client) == b {
+			same++
+		}
+	}
+	if same > 10 {
+		t.Errorf("%d of 1000 clients share their bucket across flags", same)
+	}
+	// Golden values, so a change to the hashing, which would move clients in and out of
+	// running rollouts, doesn't go unnoticed.
+	for _, tt := range []struct {
+		flag, client string
+		want         int
+	}{
+		{RoutePhrasing, "key:1a2b3c4d5e6f7a8b", 5676},
+		{RoutePhrasing, "ip:203.0.113.7", 927},
+		{GroundingCheck, "key:1a2b3c4d5e6f7a8b", 1436},
+	} {
+		if got := Bucket(tt.flag, tt.client); got != tt.want {
+			t.Errorf("Bucket(%s, %q) = %d, want %d", tt.flag, tt.client, got, tt.want)
+		}
+	}
+}
+
+func TestPercentRollout(t *testing.T) {
+	f, err := New(map[string]Rule{RoutePhrasing: {Percent: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const clients = 10000
+	var in []string
+	for i := range clients {
+		client := fmt.Sprintf("key:%016x", i)
+		if f.Evaluate(Subject{Client: client}).On(RoutePhrasing) {
+			in = append(in, client)
+		}
+	}
+	if n := len(in); n < clients*8/100 || n > clients*12/100 {
+		t.Errorf("10%% rollout selected %d of %d clients", n, clients)
+	}
+
+	// Growing the rollout keeps the clients already in it.
+	f.SetOverrides(map[string]Rule{RoutePhrasing: {Percent: 50}})
+	for _, client := range in {
+		if !f.Evaluate(Subject{Client: client}).On(RoutePhrasing) {
+			t.Fatalf("client %s left the rollout as it grew", client)
+		}
+	}
+	f.SetOverrides(map[string]Rule{RoutePhrasing: {Percent: 100}})
+	if !f.Evaluate(Subject{}).On(RoutePhrasing) {
+		t.Error("100% rollout left out a request without a client")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	f, err := New(map[string]Rule{
+		RoutePhrasing:  {Clients: []string{"key:aaaa"}},
+		GroundingCheck: {Sessions: []string{"session-1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		subject Subject
+		want    Set
+	}{
+		{Subject{}, nil},
+		{Subject{Client: "key:aaaa"}, Set{RoutePhrasing}},
+		{Subject{Client: "key:bbbb", SessionID: "session-1"}, Set{GroundingCheck}},
+		{Subject{Client: "key:aaaa", SessionID: "session-1"}, Set{GroundingCheck, RoutePhrasing}},
+	} {
+		if got := f.Evaluate(tt.subject); !slices.Equal(got, tt.want) {
+			t.Errorf("Evaluate(%+v) = %v, want %v", tt.subject, got, tt.want)
+		}
+	}
+
+	// A database override replaces the configured rule; unknown and invalid ones are ignored.
+	f.SetOverrides(map[string]Rule{
+		RoutePhrasing:  {},
+		DBOnly:         {Enabled: true},
+		"nonexistent":  {Enabled: true},
+		GroundingCheck: {Percent: 120},
+	})
+	if got := f.Evaluate(Subject{Client: "key:aaaa", SessionID: "session-1"}); !slices.Equal(got, Set{DBOnly, GroundingCheck}) {
+		t.Errorf("with overrides: %v", got)
+	}
+	sources := map[string]string{}
+	for _, s := range f.States() {
+		sources[s.Name] = s.Source
+	}
+	if want := map[string]string{RoutePhrasing: SourceDatabase, GroundingCheck: SourceConfig, DBOnly: SourceDatabase}; !maps.Equal(sources, want) {
+		t.Errorf("sources %v, want %v", sources, want)
+	}
+}
+
+func TestNew(t *testing.T) {
+	f, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range f.States() {
+		if s.Source != SourceDefault || s.Rule.Enabled != s.Default {
+			t.Errorf("state %+v without rules", s)
+		}
+	}
+	for _, configured := range []map[string]Rule{
+		{"nonexistent": {Enabled: true}},
+		{RoutePhrasing: {Percent: -1}},
+	} {
+		if _, err := New(configured); err == nil {
+			t.Errorf("New(%v) accepted", configured)
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	f, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored atomic.Pointer[map[string]Rule]
+	stored.Store(&map[string]Rule{})
+	var failing atomic.Bool
+	load := func(context.Context) (map[string]Rule, error) {
+		if failing.Load() {
+			return nil, errors.New("database down")
+		}
+		return *stored.Load(), nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.Poll(ctx, 10*time.Millisecond, load)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// A rule stored while the server runs is picked up at the next poll.
+	waitFor := func(want bool) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); f.Evaluate(Subject{}).On(DBOnly) != want; time.Sleep(5 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("db_only still %v", !want)
+			}
+		}
+	}
+	stored.Store(&map[string]Rule{DBOnly: {Enabled: true}})
+	waitFor(true)
+
+	// A failed poll keeps the rules in use.
+	failing.Store(true)
+	stored.Store(&map[string]Rule{})
+	time.Sleep(50 * time.Millisecond)
+	waitFor(true)
+	failing.Store(false)
+	waitFor(false)
+}
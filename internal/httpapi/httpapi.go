@@ -0,0 +1,201 @@
+// Package httpapi writes the server's error responses. Every endpoint outside an open event
+// stream rejects requests the same way, with the status code that fits and this body:
+//
+//	{"error": {"code": "method_not_allowed", "message": "Method Not Allowed", "request_id": "..."}}
+//
+// Clients branch on the code, one of the constants below; the message is for people and may
+// change. The request ID is the one in the X-Request-ID response header, for bug reports.
+// Clients that ask for text/plain and not JSON get the message alone, as http.Error sends it.
+package httpapi
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/logging"
+)
+
+// Error codes. Parameters of GET /api/flights and /api/admin/usage that fail to parse are
+// rejected with "invalid_" and the parameter's name, e.g. "invalid_origin" or "invalid_from";
+// failed flight writes the codes don't cover with the action and "_failed", e.g. "update_failed".
+const (
+	// The request itself: 400, 405, 413, 414 and 415.
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeQueryTooLong         = "query_too_long"
+	CodeUnreadableBody       = "unreadable_body"
+	CodeMalformedJSON        = "malformed_json"
+	CodeMalformedForm        = "malformed_form"
+	CodeEmptyMessage         = "empty_message"
+	CodeMessageTooLong       = "message_too_long"
+	CodeInvalidSessionID     = "invalid_session_id"
+	CodeInvalidLanguage      = "invalid_language"
+	CodeInvalidStream        = "invalid_stream"
+	CodeInvalidAggregate     = "invalid_aggregate"
+	CodeInvalidForce         = "invalid_force"
+	CodeInvalidFormat        = "invalid_format"
+	CodeInvalidLimit         = "invalid_limit"
+	CodeInvalidOffset        = "invalid_offset"
+	CodeInvalidSort          = "invalid_sort"
+	CodeInvalidPriceRange    = "invalid_price_range"
+	CodeInvalidDateRange     = "invalid_date_range"
+	CodeInvalidCurrency      = "invalid_currency"
+	CodeInvalidMaxBudget     = "invalid_max_budget"
+	CodeUnknownCity          = "unknown_city"
+	CodeEmptyTitle           = "empty_title"
+	CodeTitleTooLong         = "title_too_long"
+	CodeInvalidCallbackURL   = "invalid_callback_url"
+	CodeCallbacksDisabled    = "callbacks_disabled"
+	CodeInvalidIdempotency   = "invalid_idempotency_key"
+	CodeInvalidLastEventID   = "invalid_last_event_id"
+	CodeInvalidDryRun        = "invalid_dry_run"
+	CodeInvalidFlight        = "invalid_flight"
+	CodeInvalidUpsert        = "invalid_upsert"
+	CodeFlightNumberMismatch = "flight_number_mismatch"
+	CodeInvalidFlagRule      = "invalid_flag_rule"
+	CodeInvalidOverrides     = "invalid_persona_overrides"
+	CodeNotMultipart         = "not_multipart"
+	CodeMissingFile          = "missing_file"
+	CodeInvalidCSV           = "invalid_csv"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeUnsupportedCharset   = "unsupported_charset"
+
+	// Who is asking: 401 and 403.
+	CodeUnauthorized         = "unauthorized"
+	CodeCORSRejected         = "cors_rejected"
+	CodeDeveloperKeyRequired = "developer_key_required"
+	CodeClientBanned         = "client_banned"
+
+	// What the request refers to: 404, 409 and 422.
+	CodeSessionNotFound       = "session_not_found"
+	CodeStreamNotFound        = "stream_not_found"
+	CodeJobNotFound           = "job_not_found"
+	CodeRequestNotFound       = "request_not_found"
+	CodeFlightNotFound        = "flight_not_found"
+	CodeFlagNotFound          = "flag_not_found"
+	CodeBanNotFound           = "ban_not_found"
+	CodeShareNotFound         = "share_not_found"
+	CodeFlightExists          = "flight_exists"
+	CodeNotRunning            = "not_running"
+	CodeNothingToRegenerate   = "nothing_to_regenerate"
+	CodeGenerationInProgress  = "generation_in_progress"
+	CodeIdempotencyInProgress = "idempotency_in_progress"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeReloadRejected        = "reload_rejected"
+
+	// Limits: 402 and 429, with a Retry-After header.
+	CodeRateLimited    = "rate_limited"
+	CodeTooManyStreams = "too_many_streams"
+	CodeQuotaExceeded  = "quota_exceeded"
+
+	// The server: 500 and 503, often with a Retry-After header.
+	CodeInternal                = "internal_error"
+	CodeRequestTimeout          = "request_timeout"
+	CodeShuttingDown            = "shutting_down"
+	CodeServerBusy              = "server_busy"
+	CodeStreamingUnsupported    = "streaming_unsupported"
+	CodeSearchFailed            = "search_failed"
+	CodeConversationUnavailable = "conversation_unavailable"
+	CodePreferencesUnavailable  = "preferences_unavailable"
+	CodeRoutesUnavailable       = "routes_unavailable"
+	CodeUsageUnavailable        = "usage_unavailable"
+	CodeJobUnavailable          = "job_unavailable"
+	CodeRequestUnavailable      = "request_unavailable"
+	CodeIdempotencyUnavailable  = "idempotency_unavailable"
+	CodeImportFailed            = "import_failed"
+	CodeSaveFailed              = "save_failed"
+	CodeDeleteFailed            = "delete_failed"
+	CodeSeedFailed              = "seed_failed"
+)
+
+// Error is a rejected request.
+type Error struct {
+	Status     int
+	Code       string
+	Message    string
+	RetryAfter time.Duration // Sent as the Retry-After header when set
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// BadRequest returns a 400 with code and message.
+func BadRequest(code, message string) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: code, Message: message}
+}
+
+// MethodNotAllowed returns the 405 of a request whose method the endpoint doesn't serve.
+// The caller sets the Allow header.
+func MethodNotAllowed() *Error {
+	return &Error{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: "Method Not Allowed"}
+}
+
+// body is the JSON shape of an error response.
+type body struct {
+	Error struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"request_id,omitempty"`
+	} `json:"error"`
+}
+
+// Write sends err as the response to r: as JSON, or as its message alone for clients that
+// ask for text/plain.
+func Write(w http.ResponseWriter, r *http.Request, err *Error) {
+	if err.RetryAfter > 0 {
+		w.Header().Set("Retry-After", retryAfterSeconds(err.RetryAfter))
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if prefersText(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(err.Status)
+		w.Write([]byte(err.Message + "\n"))
+		return
+	}
+	var b body
+	b.Error.Code, b.Error.Message = err.Code, err.Message
+	b.Error.RequestID = logging.RequestID(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Status)
+	json.NewEncoder(w).Encode(b)
+}
+
+// retryAfterSeconds formats a Retry-After value, rounded up to whole seconds and at least 1.
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+// prefersText reports whether accept names text/plain with a higher quality than JSON.
+// Wildcards don't count, so browsers and clients that accept anything get JSON.
+func prefersText(accept string) bool {
+	text, jsonQ := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "text/plain":
+			text = max(text, q)
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return text > jsonQ
+}
@@ -0,0 +1,100 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/logging"
+)
+
+func TestWrite(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/api", nil)
+	r = r.WithContext(logging.WithRequestID(r.Context(), "req-1"))
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Length", "12")
+	Write(rec, r, &Error{Status: http.StatusTooManyRequests, Code: CodeRateLimited, Message: "Slow down", RetryAfter: 1500 * time.Millisecond})
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status %d", rec.Code)
+	}
+	for name, want := range map[string]string{
+		"Content-Type":           "application/json",
+		"Content-Length":         "",
+		"Retry-After":            "2",
+		"X-Content-Type-Options": "nosniff",
+	} {
+		if got := rec.Header().Get(name); got != want {
+			t.Errorf("%s: %q, want %q", name, got, want)
+		}
+	}
+	var got map[string]map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"code": CodeRateLimited, "message": "Slow down", "request_id": "req-1"}
+	if len(got) != 1 || len(got["error"]) != len(want) {
+		t.Fatalf("body %v", got)
+	}
+	for k, v := range want {
+		if got["error"][k] != v {
+			t.Errorf("%s: %q, want %q", k, got["error"][k], v)
+		}
+	}
+
+	// Without a request ID or a wait, neither is sent.
+	rec = httptest.NewRecorder()
+	Write(rec, httptest.NewRequest(http.MethodGet, "/api/flights", nil), BadRequest("invalid_origin", "origin is too long"))
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Retry-After") != "" {
+		t.Errorf("bad request: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if body := rec.Body.String(); body != `{"error":{"code":"invalid_origin","message":"origin is too long"}}`+"\n" {
+		t.Errorf("body %s", body)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPut, "/api", nil)
+	r.Header.Set("Accept", "text/plain")
+	rec := httptest.NewRecorder()
+	Write(rec, r, MethodNotAllowed())
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("%d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := rec.Body.String(); body != "Method Not Allowed\n" {
+		t.Errorf("body %q", body)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		100 * time.Millisecond:  "1",
+		time.Second:             "1",
+		1500 * time.Millisecond: "2",
+		time.Minute:             "60",
+	} {
+		if got := retryAfterSeconds(d); got != want {
+			t.Errorf("retryAfterSeconds(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestPrefersText(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                                   false,
+		"text/plain":                         true,
+		"text/plain; charset=utf-8":          true,
+		"*/*":                                false,
+		"text/*":                             false,
+		"text/html,*/*;q=0.8":                false,
+		"application/json, text/plain;q=0.5": false,
+		"text/plain, application/json;q=0.9": true,
+		"text/plain;q=bad":                   false,
+	} {
+		if got := prefersText(accept); got != want {
+			t.Errorf("prefersText(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
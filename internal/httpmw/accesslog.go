@@ -0,0 +1,89 @@
+package httpmw
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// accessRecorder captures the status and size of a response. Flush and Unwrap keep streaming
+// handlers working behind it.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *accessRecorder) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *accessRecorder) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *accessRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// AccessLog logs one line per request once the handler returns, with the method, route, path
+// (without credentials; see logging.RequestPath), status, response size and duration. The
+// status of an SSE response goes out with its headers, so for those the line also describes
+// the stream: time to the first event, events and bytes sent, the Done outcome and why the
+// stream ended. Put it inside the request ID and tracing
+// middleware so the line carries their IDs, and outside Recover so panics are logged as 500s.
+func AccessLog(route string) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ctx, stats := sse.WithStats(r.Context())
+			rec := &accessRecorder{ResponseWriter: w}
+			next(rec, r.WithContext(ctx))
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			attrs := []any{
+				"method", r.Method,
+				"route", route,
+				"path", logging.RequestPath(r),
+				"status", rec.status,
+				"bytes", rec.bytes,
+				"duration", time.Since(start),
+				"remote_addr", r.RemoteAddr,
+			}
+			if stats.Streamed {
+				var firstEvent time.Duration
+				if !stats.FirstEventAt.IsZero() {
+					firstEvent = stats.FirstEventAt.Sub(start)
+				}
+				attrs = append(attrs,
+					"time_to_first_event", firstEvent,
+					"events", stats.Events,
+					"stream_bytes", stats.Bytes,
+					"outcome", stats.Outcome,
+					"end_reason", stats.EndReason,
+				)
+			}
+			slog.InfoContext(r.Context(), "HTTP request", attrs...)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package httpmw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// syncBuffer is a buffer the server's goroutines can log to while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs installs a JSON default logger writing to a buffer for the rest of the test.
+func captureLogs(t *testing.T) *syncBuffer {
+	t.Helper()
+	prev := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	buf := &syncBuffer{}
+	if err := logging.Setup(buf, "info", "json"); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// accessLine waits for the access log line of a request and returns its fields.
+func accessLine(t *testing.T, logs *syncBuffer) map[string]any {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, line := range strings.Split(logs.String(), "\n") {
+			var record map[string]any
+			if json.Unmarshal([]byte(line), &record) == nil && record["msg"] == "HTTP request" {
+				return record
+			}
+		}
+	}
+	t.Fatalf("no access log line in:\n%s", logs)
+	return nil
+}
+
+// withID gives requests the request ID req-1, as the server's request ID middleware would.
+func withID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(logging.WithRequestID(r.Context(), "req-1")))
+	}
+}
+
+func TestAccessLogPlainResponse(t *testing.T) {
+	logs := captureLogs(t)
+	h := Chain(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"ok":true}`)
+	}, withID, AccessLog("/api/things/{id}"))
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/things/7?api_key=secret", nil))
+
+	line := accessLine(t, logs)
+	for key, want := range map[string]any{
+		"method": "POST", "route": "/api/things/{id}", "status": 201.0, "bytes": 11.0, "request_id": "req-1",
+	} {
+		if line[key] != want {
+			t.Errorf("%s = %v, want %v", key, line[key], want)
+		}
+	}
+	if strings.Contains(line["path"].(string), "secret") {
+		t.Errorf("path %v carries the credential", line["path"])
+	}
+	if _, ok := line["events"]; ok {
+		t.Errorf("stream fields on a plain response: %v", line)
+	}
+}
+
+func TestAccessLogCompletedStream(t *testing.T) {
+	logs := captureLogs(t)
+	stream := sse.NewRegistry(time.Minute).Create()
+	stream.Publish(sse.Started(stream.ID()))
+	stream.Publish(sse.MessageChunk("Hello", true))
+	stream.Publish(sse.Done(sse.DonePayload{Outcome: sse.OutcomeOK}))
+	stream.Close()
+	h := Chain(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond) // The orchestration's time before the first event
+		sse.NewHandler().ServeStream(w, r, stream, 0)
+	}, withID, AccessLog("/api"))
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/api", nil))
+
+	line := accessLine(t, logs)
+	if line["status"] != 200.0 || line["events"] != 3.0 || line["outcome"] != sse.OutcomeOK || line["end_reason"] != sse.EndDone || line["request_id"] != "req-1" {
+		t.Errorf("access line %v", line)
+	}
+	// The writer was wrapped without breaking Flusher: every byte went out as a stream.
+	if line["bytes"] != float64(rec.Body.Len()) || line["stream_bytes"].(float64) <= 0 || line["stream_bytes"].(float64) > line["bytes"].(float64) {
+		t.Errorf("bytes %v, stream bytes %v; the client read %d", line["bytes"], line["stream_bytes"], rec.Body.Len())
+	}
+	first, total := time.Duration(line["time_to_first_event"].(float64)), time.Duration(line["duration"].(float64))
+	if first < 20*time.Millisecond || first > total {
+		t.Errorf("time to first event %v of %v, want it after the handler's delay", first, total)
+	}
+}
+
+func TestAccessLogClientDisconnect(t *testing.T) {
+	logs := captureLogs(t)
+	stream := sse.NewRegistry(time.Minute).Create()
+	stream.Publish(sse.Started(stream.ID()))
+	defer stream.Close()
+	srv := httptest.NewServer(Chain(func(w http.ResponseWriter, r *http.Request) {
+		sse.NewHandler().ServeStream(w, r, stream, 0)
+	}, withID, AccessLog("/api")))
+	defer srv.Close()
+
+	// The client reads the first event and hangs up while the stream is still running.
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/api", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sse.NewReader(resp.Body).Next(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	resp.Body.Close()
+
+	line := accessLine(t, logs)
+	if line["end_reason"] != sse.EndClientDisconnected || line["events"] != 1.0 || line["outcome"] != "" || line["status"] != 200.0 {
+		t.Errorf("access line %v", line)
+	}
+}
@@ -0,0 +1,122 @@
+package httpmw
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// CORSConfig is the cross-origin policy applied by CORS.
+type CORSConfig struct {
+	// AllowedOrigins lists the origins browsers may call from: "*" for any, an exact origin
+	// ("https://app.example.com"), or a wildcard subdomain ("https://*.example.com", which
+	// matches any subdomain but not example.com itself). Empty allows no cross-origin calls.
+	AllowedOrigins []string
+	AllowedMethods []string      // Methods a preflight may approve
+	AllowedHeaders []string      // Request headers a preflight may approve
+	ExposedHeaders []string      // Response headers scripts may read
+	MaxAge         time.Duration // How long browsers may cache a preflight; zero omits the header
+}
+
+// ValidOrigin reports whether pattern is a usable AllowedOrigins entry.
+func ValidOrigin(pattern string) error {
+	if pattern == "*" {
+		return nil
+	}
+	u, err := url.Parse(strings.Replace(pattern, "://*.", "://wildcard.", 1))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("origin %q must be \"*\" or scheme://host[:port]", pattern)
+	}
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("origin %q must not have a path, query or credentials", pattern)
+	}
+	if strings.Contains(strings.TrimPrefix(pattern, u.Scheme+"://*."), "*") {
+		return fmt.Errorf("origin %q may only use a wildcard as its first subdomain label", pattern)
+	}
+	return nil
+}
+
+// originAllowed matches an Origin header against the configured patterns, ignoring case.
+func (c CORSConfig) originAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range c.AllowedOrigins {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		scheme, rest, ok := strings.Cut(pattern, "://*.")
+		if !ok {
+			continue
+		}
+		prefix, suffix := scheme+"://", "."+rest
+		sub, ok := strings.CutPrefix(origin, prefix)
+		if ok && strings.HasSuffix(sub, suffix) {
+			label := strings.TrimSuffix(sub, suffix)
+			if label != "" && !strings.ContainsAny(label, "/:@") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CORS applies cfg to cross-origin requests. Allowed origins get their origin echoed in
+// Access-Control-Allow-Origin; others get no CORS headers, so browsers keep the response from
+// the page. OPTIONS requests are answered here and never reach next: a preflight from an allowed
+// origin for an allowed method gets 204 with the policy, any other preflight 403.
+func CORS(cfg CORSConfig) Middleware {
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin") // Responses differ by origin, so caches must key on it.
+			origin := r.Header.Get("Origin")
+			allowed := origin != "" && cfg.originAllowed(origin)
+
+			if r.Method == http.MethodOptions {
+				requested := r.Header.Get("Access-Control-Request-Method")
+				if requested == "" {
+					// Not a preflight; just describe the endpoint.
+					w.Header().Set("Allow", methods)
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				if !allowed || !containsFold(cfg.AllowedMethods, requested) {
+					httpapi.Write(w, r, &httpapi.Error{Status: http.StatusForbidden, Code: httpapi.CodeCORSRejected, Message: "CORS preflight rejected"})
+					return
+				}
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", methods)
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+				if cfg.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if exposed != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposed)
+				}
+			}
+			next(w, r)
+		}
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package httpmw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+var testCORS = CORSConfig{
+	AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+	AllowedMethods: []string{"GET", "POST", "OPTIONS"},
+	AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key"},
+	ExposedHeaders: []string{"X-Request-ID"},
+	MaxAge:         10 * time.Minute,
+}
+
+// corsRequest sends a request from origin through CORS(testCORS) and reports whether it
+// reached the handler.
+func corsRequest(method, origin string, header map[string]string) (*httptest.ResponseRecorder, bool) {
+	reached := false
+	h := CORS(testCORS)(func(w http.ResponseWriter, r *http.Request) { reached = true })
+	r := httptest.NewRequest(method, "/api", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, r)
+	return rec, reached
+}
+
+func TestCORSAllowedOrigin(t *testing.T) {
+	for _, origin := range []string{"https://app.example.com", "HTTPS://APP.example.com", "https://eu.example.org"} {
+		rec, reached := corsRequest(http.MethodPost, origin, nil)
+		if !reached || rec.Header().Get("Access-Control-Allow-Origin") != origin {
+			t.Errorf("%s: reached %v, Allow-Origin %q", origin, reached, rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+		if rec.Header().Get("Access-Control-Expose-Headers") != "X-Request-ID" || rec.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: headers %v", origin, rec.Header())
+		}
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	for _, origin := range []string{
+		"",
+		"https://evil.example",
+		"http://app.example.com",       // Scheme differs
+		"https://app.example.com:8443", // Port differs
+		"https://example.org",          // A wildcard subdomain needs a subdomain
+		"https://a.b@x.example.org",
+	} {
+		// The request is still served; the browser just keeps the response from the page.
+		rec, reached := corsRequest(http.MethodGet, origin, nil)
+		if !reached || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%q: reached %v, Allow-Origin %q", origin, reached, rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	preflight := map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "authorization, content-type",
+	}
+	rec, reached := corsRequest(http.MethodOptions, "https://app.example.com", preflight)
+	if reached || rec.Code != http.StatusNoContent {
+		t.Fatalf("preflight: reached %v, status %d", reached, rec.Code)
+	}
+	h := rec.Header()
+	if h.Get("Access-Control-Allow-Origin") != "https://app.example.com" || !strings.Contains(h.Get("Access-Control-Allow-Methods"), "POST") ||
+		!strings.Contains(h.Get("Access-Control-Allow-Headers"), "Authorization") || h.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("preflight headers %v", h)
+	}
+
+	for _, tt := range []struct {
+		name, origin, method string
+	}{
+		{"disallowed origin", "https://evil.example", "POST"},
+		{"disallowed method", "https://app.example.com", "DELETE"},
+	} {
+		rec, reached := corsRequest(http.MethodOptions, tt.origin, map[string]string{"Access-Control-Request-Method": tt.method})
+		if reached || rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s: reached %v, status %d, headers %v", tt.name, reached, rec.Code, rec.Header())
+		}
+		if !strings.Contains(rec.Body.String(), httpapi.CodeCORSRejected) {
+			t.Errorf("%s: body %s", tt.name, rec.Body)
+		}
+	}
+
+	// A plain OPTIONS isn't a preflight; it describes the endpoint.
+	rec, _ = corsRequest(http.MethodOptions, "", nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Allow") != "GET, POST, OPTIONS" {
+		t.Errorf("OPTIONS: status %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestValidOrigin(t *testing.T) {
+	for _, pattern := range []string{"*", "https://app.example.com", "http://localhost:3000", "https://*.example.org"} {
+		if err := ValidOrigin(pattern); err != nil {
+			t.Errorf("%q: %v", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"app.example.com", "ftp://example.com", "https://example.com/app", "https://a.*.example.org", "https://user@example.com"} {
+		if ValidOrigin(pattern) == nil {
+			t.Errorf("%q accepted", pattern)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Package httpmw holds the HTTP middleware shared by the server's routes: access logging,
+// panic recovery, a deadline for the phase before a response starts, a request body limit
+// and CORS.
+//
+// Middleware are plain func(http.HandlerFunc) http.HandlerFunc values, composed with Chain.
+package httpmw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// Middleware wraps a handler with extra behavior.
+type Middleware func(http.HandlerFunc) http.HandlerFunc
+
+// Chain wraps h in mws; the first middleware is the outermost, so it sees the request first.
+func Chain(h http.HandlerFunc, mws ...Middleware) http.HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// ErrPreStreamTimeout is the cause of a request context cancelled by Timeout.
+var ErrPreStreamTimeout = errors.New("request timed out before the response started")
+
+// startWriter calls onStart once, before the first WriteHeader, Write or Flush reaches the
+// client, so middleware can tell whether a response has begun. Flush and Unwrap keep
+// streaming handlers working behind it.
+type startWriter struct {
+	http.ResponseWriter
+	onStart func()
+	started bool
+}
+
+func (w *startWriter) start() {
+	if !w.started {
+		w.started = true
+		if w.onStart != nil {
+			w.onStart()
+		}
+	}
+}
+
+func (w *startWriter) WriteHeader(code int) {
+	if code >= 200 { // 1xx informational responses don't commit the response.
+		w.start()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *startWriter) Write(b []byte) (int, error) {
+	w.start()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *startWriter) Flush() {
+	w.start()
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *startWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Recover turns a panic in next into a logged error with its stack. If no response has been
+// sent yet the client gets a 500; if an event stream is already open it gets an Error event
+// instead, so the stream ends with an explanation rather than silently. Any other partly
+// written response is aborted so the client can't mistake it for a complete one.
+func Recover(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &startWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p) // Deliberate abort; net/http handles it quietly.
+			}
+			LogPanic(r.Context(), p)
+			switch {
+			case !sw.started:
+				httpapi.Write(w, r, &httpapi.Error{Status: http.StatusInternalServerError, Code: httpapi.CodeInternal, Message: "Internal server error"})
+			case sse.IsStreamContentType(w.Header().Get("Content-Type")):
+				sse.WriteEvent(w, r, sse.Error("internal_error", i18n.T(i18n.Negotiate(r.Header.Get("Accept-Language")), "error.internal")))
+				http.NewResponseController(w).Flush()
+			default:
+				panic(http.ErrAbortHandler)
+			}
+		}()
+		next(sw, r)
+	}
+}
+
+// LogPanic logs a recovered panic value with the current goroutine's stack. Use it from
+// deferred recovers in goroutines the server starts, where Recover can't reach.
+func LogPanic(ctx context.Context, p any) {
+	slog.ErrorContext(ctx, "Panic recovered", "panic", fmt.Sprint(p), "stack", string(debug.Stack()))
+}
+
+// Timeout bounds the time before next starts its response. Until then the request context
+// carries a deadline (its cause is ErrPreStreamTimeout) and the body read deadline is set, so
+// slow uploads and stuck lookups give up; a handler that returns without responding because
+// of it is answered with 503. Once the response starts the deadline is lifted, so long SSE
+// streams are not cut off. A zero d disables the timeout.
+func Timeout(d time.Duration) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if d <= 0 {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithCancelCause(r.Context())
+			defer cancel(nil)
+			rc := http.NewResponseController(w)
+			rc.SetReadDeadline(time.Now().Add(d)) // Not every ResponseWriter supports deadlines (e.g. in tests).
+
+			// The timer and the first write race; whichever takes the lock first wins.
+			var mu sync.Mutex
+			started, timedOut := false, false
+			timer := time.AfterFunc(d, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				if !started {
+					timedOut = true
+					cancel(ErrPreStreamTimeout)
+				}
+			})
+			defer timer.Stop()
+
+			sw := &startWriter{ResponseWriter: w, onStart: func() {
+				mu.Lock()
+				defer mu.Unlock()
+				started = true
+				timer.Stop()
+				rc.SetReadDeadline(time.Time{})
+			}}
+			next(sw, r.WithContext(ctx))
+
+			mu.Lock()
+			defer mu.Unlock()
+			if timedOut && !started {
+				slog.WarnContext(r.Context(), "Request timed out before responding", "timeout", d)
+				httpapi.Write(w, r, &httpapi.Error{Status: http.StatusServiceUnavailable, Code: httpapi.CodeRequestTimeout, Message: "The request took too long to start; please retry"})
+			}
+		}
+	}
+}
+
+// MaxBytes limits request bodies to n bytes. Reads past the limit fail with *http.MaxBytesError,
+// which handlers should answer with 413, and the connection is closed after the response.
+func MaxBytes(n int64) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next(w, r)
+		}
+	}
+}
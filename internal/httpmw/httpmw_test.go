@@ -0,0 +1,227 @@
+package httpmw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// readFrames reads every event of an SSE body.
+func readFrames(t *testing.T, body io.Reader) []sse.Frame {
+	t.Helper()
+	reader := sse.NewReader(body)
+	var frames []sse.Frame
+	for {
+		frame, err := reader.Next()
+		if err == io.EOF {
+			return frames
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// errorCode returns the code of a JSON error response.
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("error response of type %q: %s", rec.Header().Get("Content-Type"), rec.Body)
+	}
+	var body struct {
+		Error struct{ Code string }
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Error.Code
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next(w, r)
+			}
+		}
+	}
+	h := Chain(func(http.ResponseWriter, *http.Request) { order = append(order, "handler") }, mw("outer"), mw("inner"))
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if strings.Join(order, " ") != "outer inner handler" {
+		t.Errorf("order %v", order)
+	}
+}
+
+func TestRecoverBeforeResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Recover(func(http.ResponseWriter, *http.Request) { panic("boom") })(rec, httptest.NewRequest("POST", "/api", nil))
+	if rec.Code != http.StatusInternalServerError || errorCode(t, rec) != httpapi.CodeInternal {
+		t.Errorf("status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRecoverMidStream(t *testing.T) {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api", nil)
+	r.Header.Set("Accept-Language", "es")
+	Recover(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		sse.WriteEvent(w, r, sse.Status("Thinking"))
+		w.(http.Flusher).Flush()
+		panic("boom")
+	})(rec, r)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d, want the stream's 200", rec.Code)
+	}
+	frames := readFrames(t, rec.Body)
+	if len(frames) != 2 || frames[0].Event != sse.TypeStatus || frames[1].Event != sse.TypeError {
+		t.Fatalf("events %+v, want the status and then an Error", frames)
+	}
+	if want := i18n.T(i18n.Negotiate("es"), "error.internal"); frames[1].Data != want {
+		t.Errorf("Error event %q, want %q", frames[1].Data, want)
+	}
+}
+
+func TestRecoverAbortsPartialResponse(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"partial body", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, `{"flights": [`)
+			panic("boom")
+		}},
+		{"deliberate abort", func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) }},
+	} {
+		func() {
+			defer func() {
+				if p := recover(); p != http.ErrAbortHandler {
+					t.Errorf("%s: panic %v, want http.ErrAbortHandler so the connection is dropped", tt.name, p)
+				}
+			}()
+			Recover(tt.handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}()
+	}
+}
+
+func TestTimeoutBeforeResponse(t *testing.T) {
+	var cause error
+	rec := httptest.NewRecorder()
+	Timeout(20*time.Millisecond)(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // A lookup stuck until the deadline
+		cause = context.Cause(r.Context())
+	})(rec, httptest.NewRequest("POST", "/api", nil))
+
+	if !errors.Is(cause, ErrPreStreamTimeout) {
+		t.Errorf("cause %v, want ErrPreStreamTimeout", cause)
+	}
+	if rec.Code != http.StatusServiceUnavailable || errorCode(t, rec) != httpapi.CodeRequestTimeout {
+		t.Errorf("status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTimeoutLiftedOnceStreaming(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var ctxErr error
+	Timeout(20*time.Millisecond)(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(80 * time.Millisecond) // A long stream, well past the timeout
+		ctxErr = r.Context().Err()
+		io.WriteString(w, "data: still here\n\n")
+	})(rec, httptest.NewRequest("POST", "/api", nil))
+
+	if ctxErr != nil || rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "still here") {
+		t.Errorf("context %v, status %d, body %q; want the stream left alone", ctxErr, rec.Code, rec.Body)
+	}
+}
+
+func TestTimeoutDisabled(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Timeout(0)(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Error("deadline set with the timeout disabled")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status %d", rec.Code)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	h := MaxBytes(10)(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			var tooLarge *http.MaxBytesError
+			if !errors.As(err, &tooLarge) || tooLarge.Limit != 10 {
+				t.Errorf("read error %v, want a *http.MaxBytesError", err)
+			}
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusRequestEntityTooLarge, Code: httpapi.CodeBodyTooLarge, Message: "too large"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	for _, tt := range []struct {
+		body   string
+		status int
+	}{
+		{strings.Repeat("a", 10), http.StatusNoContent},
+		{strings.Repeat("a", 11), http.StatusRequestEntityTooLarge},
+	} {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest("POST", "/api", strings.NewReader(tt.body)))
+		if rec.Code != tt.status {
+			t.Errorf("%d-byte body: status %d, want %d", len(tt.body), rec.Code, tt.status)
+		}
+	}
+}
+
+func TestStackAgainstRealServer(t *testing.T) {
+	// Behind a real server the pieces compose: a handler that panics once its deadline passes
+	// still gets a 500, and a mid-stream panic reaches the client as an event.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", Chain(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		panic("after the deadline")
+	}, Recover, Timeout(20*time.Millisecond)))
+	mux.HandleFunc("/stream", Chain(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		panic("mid-stream")
+	}, Recover, Timeout(time.Second)))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("/slow answered %d, want 500", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if frames := readFrames(t, resp.Body); len(frames) != 1 || frames[0].Event != sse.TypeError {
+		t.Errorf("/stream events %+v, want an Error", frames)
+	}
+}
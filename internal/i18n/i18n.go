@@ -0,0 +1,233 @@
+// Package i18n translates the user-visible texts the server writes itself: status events,
+// fallback answers, error messages and the chat bots' placeholders. (LLM prompts and answers
+// are not catalog texts; the prompts carry their own language.)
+//
+// Texts live in a message catalog keyed by language code ("en", "es") and message key. The
+// built-in catalog is embedded from locales/*.json; LoadDir overlays <code>.json files from a
+// directory, which can fix a translation or add a language without rebuilding. Texts are
+// fmt formats, so a translation must keep the English text's verbs in the same order.
+// Unknown languages and missing keys fall back to English.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Default is the language every other one falls back to. Its catalog defines the keys.
+const Default = "en"
+
+//go:embed locales/*.json
+var builtin embed.FS
+
+var (
+	mu       sync.RWMutex
+	catalogs = map[string]map[string]string{} // Language code -> key -> text
+)
+
+func init() {
+	if err := loadFS(builtin, "locales"); err != nil {
+		panic(err) // The embedded catalog is part of the binary; it must parse.
+	}
+}
+
+// LoadDir overlays the catalogs in dir: each <code>.json file holds an object of key -> text
+// for the language <code>, merged over the built-in texts of that language (or starting a new
+// language). Call it at startup, before texts are looked up.
+func LoadDir(dir string) error {
+	return loadFS(os.DirFS(dir), ".")
+}
+
+func loadFS(fsys fs.FS, dir string) error {
+	paths, err := fs.Glob(fsys, filepath.ToSlash(filepath.Join(dir, "*.json")))
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, path := range paths {
+		raw, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return fmt.Errorf("read catalog %s: %w", path, err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(raw, &messages); err != nil {
+			return fmt.Errorf("parse catalog %s: %w", path, err)
+		}
+		lang := Normalize(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if catalogs[lang] == nil {
+			catalogs[lang] = map[string]string{}
+		}
+		for key, text := range messages {
+			catalogs[lang][key] = text
+		}
+	}
+	return nil
+}
+
+// Normalize reduces a language tag to the catalog code: lowercase, without region or script
+// ("es-MX" and "ES_es" become "es").
+func Normalize(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	return lang
+}
+
+// T returns the text for key in lang, formatted with args. A language without the key falls
+// back to English; a key English doesn't have either is returned as it is, so a typo shows up
+// in the output instead of an empty string.
+func T(lang, key string, args ...any) string {
+	mu.RLock()
+	text, ok := catalogs[Normalize(lang)][key]
+	if !ok {
+		text, ok = catalogs[Default][key]
+	}
+	mu.RUnlock()
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// Languages returns the codes of the languages with a catalog, sorted.
+func Languages() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	slices.Sort(langs)
+	return langs
+}
+
+// Supported reports whether lang (in any form Normalize accepts) has a catalog.
+func Supported(lang string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := catalogs[Normalize(lang)]
+	return ok
+}
+
+// verbs matches fmt verbs (with flags, width and precision), but not the escaped "%%".
+var verbs = regexp.MustCompile(`%[-+# 0]*[0-9]*(\.[0-9]+)?[a-zA-Z]`)
+
+// Problems checks every catalog against the English one and describes what is wrong: keys a
+// language is missing (they fall back to English), keys English doesn't define (typos), and
+// translations whose fmt verbs differ from the English text's. The server logs them at startup.
+func Problems() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	var problems []string
+	reference := catalogs[Default]
+	for lang, messages := range catalogs {
+		if lang == Default {
+			continue
+		}
+		for key, english := range reference {
+			text, ok := messages[key]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("%s: missing %q", lang, key))
+			case !slices.Equal(verbs.FindAllString(text, -1), verbs.FindAllString(english, -1)):
+				problems = append(problems, fmt.Sprintf("%s: %q has different placeholders than English", lang, key))
+			}
+		}
+		for key := range messages {
+			if _, ok := reference[key]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown key %q", lang, key))
+			}
+		}
+	}
+	slices.Sort(problems)
+	return problems
+}
+
+// Negotiate picks the catalog language for an Accept-Language header: the first listed
+// language with a catalog, or English. Quality values are not weighed; clients list their
+// preferred language first.
+func Negotiate(acceptLanguage string) string {
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		if lang := Normalize(tag); lang != "" && Supported(lang) {
+			return lang
+		}
+	}
+	return Default
+}
+
+// spanishWords are the words whose presence marks a message as Spanish.
+var spanishWords = []string{"hola", "como", "estas", "que", "hay", "vuelos", "vuelo", "desde", "hacia", "menos", "bajo", "inferior", "cuanto", "cuesta", "precio", "costo", "duracion", "tiempo"}
+
+// Detect guesses the language of a user's message: "es" if it contains a common Spanish
+// word, otherwise "en".
+func Detect(message string) string {
+	lower := strings.ToLower(message)
+	for _, word := range spanishWords {
+		if strings.Contains(lower, word) {
+			return "es"
+		}
+	}
+	return Default
+}
+
+// ConfidentDetection is the DetectConfidence confidence from which a message's language is
+// trusted over the language its conversation has been in so far.
+const ConfidentDetection = 0.5
+
+// commonWords are frequent words of each language, counted by DetectConfidence. Words shared by
+// both ("a", "no", "me") are left out, since they tell nothing.
+var commonWords = map[string]string{
+	"the": "en", "an": "en", "to": "en", "from": "en", "of": "en", "and": "en", "in": "en", "on": "en",
+	"with": "en", "for": "en", "what": "en", "which": "en", "where": "en", "when": "en", "how": "en",
+	"is": "en", "are": "en", "do": "en", "does": "en", "can": "en", "i": "en", "you": "en", "my": "en",
+	"it": "en", "there": "en", "any": "en", "much": "en", "want": "en", "need": "en", "show": "en",
+	"find": "en", "flight": "en", "flights": "en", "cheap": "en", "cheapest": "en", "under": "en",
+	"please": "en", "thanks": "en", "thank": "en", "yes": "en",
+
+	"el": "es", "la": "es", "los": "es", "las": "es", "un": "es", "una": "es", "de": "es", "del": "es",
+	"al": "es", "en": "es", "y": "es", "con": "es", "por": "es", "para": "es", "que": "es", "qué": "es",
+	"como": "es", "cómo": "es", "dónde": "es", "cuándo": "es", "cuánto": "es", "cuanto": "es",
+	"es": "es", "son": "es", "hay": "es", "mi": "es", "quiero": "es", "necesito": "es", "hola": "es",
+	"vuelo": "es", "vuelos": "es", "desde": "es", "hacia": "es", "menos": "es", "barato": "es",
+	"precio": "es", "cuesta": "es", "gracias": "es", "sí": "es", "vale": "es",
+}
+
+// DetectConfidence guesses the language of a user's message and how sure the guess is, from 0
+// to 1: the share of its words that are common words of the language, less those of the other,
+// scaled down for messages of fewer than four words. Short replies such as "sí" or "ok" are
+// therefore never confident, while "what is the cheapest flight to Paris?" is. A message with
+// as many words of each language gets Detect's guess with no confidence.
+func DetectConfidence(message string) (lang string, confidence float64) {
+	words := strings.FieldsFunc(strings.ToLower(message), func(r rune) bool { return !unicode.IsLetter(r) })
+	counts := map[string]int{}
+	for _, word := range words {
+		if l, ok := commonWords[word]; ok {
+			counts[l]++
+		}
+	}
+	lead := counts["en"] - counts["es"]
+	switch {
+	case lead > 0:
+		lang = "en"
+	case lead < 0:
+		lang, lead = "es", -lead
+	default:
+		return Detect(message), 0
+	}
+	return lang, float64(lead) / float64(len(words)) * min(float64(len(words))/4, 1)
+}
@@ -0,0 +1,106 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCatalogsComplete(t *testing.T) {
+	if langs := Languages(); !slices.Contains(langs, "en") || !slices.Contains(langs, "es") {
+		t.Fatalf("languages %v, want en and es built in", langs)
+	}
+	// Every language has every key, with the English text's placeholders.
+	for _, problem := range Problems() {
+		t.Error(problem)
+	}
+}
+
+func TestT(t *testing.T) {
+	for _, tt := range []struct{ lang, key, want string }{
+		{"es", "message.no_flights", "No se encontraron vuelos para tu consulta."},
+		{"es-MX", "message.no_flights", "No se encontraron vuelos para tu consulta."},
+		{"en", "message.no_flights", "No flights found for your query."},
+		{"fr", "message.no_flights", "No flights found for your query."}, // Unknown languages fall back to English.
+		{"", "message.no_flights", "No flights found for your query."},
+		{"es", "no.such.key", "no.such.key"},
+	} {
+		if got := T(tt.lang, tt.key); got != tt.want {
+			t.Errorf("T(%q, %q) = %q, want %q", tt.lang, tt.key, got, tt.want)
+		}
+	}
+	if got, want := T("es", "status.queued", 3), "En cola (posición 3)"; got != want {
+		t.Errorf("formatted text %q, want %q", got, want)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "fr.json"), []byte(`{"message.no_flights": "Aucun vol trouvé.", "status.queued": "En attente (%d)"}`), 0o600)
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(catalogs, "fr")
+		mu.Unlock()
+	})
+	if err := LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := T("fr-FR", "message.no_flights"); got != "Aucun vol trouvé." {
+		t.Errorf("overlaid text %q", got)
+	}
+	if got := T("fr", "message.truncated"); got != T("en", "message.truncated") {
+		t.Errorf("missing key gave %q, want the English text", got)
+	}
+	if !Supported("fr") || Negotiate("de, fr;q=0.8") != "fr" {
+		t.Error("the loaded language isn't offered")
+	}
+	if problems := Problems(); !slices.Contains(problems, `fr: missing "message.truncated"`) {
+		t.Errorf("problems %v, want the keys French lacks", problems)
+	}
+
+	os.WriteFile(filepath.Join(dir, "es.json"), []byte(`{"message.no_flights": `), 0o600)
+	if err := LoadDir(dir); err == nil {
+		t.Error("a malformed catalog loaded")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                        "en",
+		"es-ES,es;q=0.9,en;q=0.8": "es",
+		"de-DE, es":               "es",
+		"de-DE, it":               "en",
+	} {
+		if got := Negotiate(header); got != want {
+			t.Errorf("Negotiate(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestDetectConfidence(t *testing.T) {
+	for _, tt := range []struct {
+		message   string
+		lang      string
+		confident bool
+	}{
+		{"What is the cheapest flight to Paris?", "en", true},
+		{"¿Cuál es el vuelo más barato desde Madrid a París?", "es", true},
+		{"Show me flights from Madrid to Paris please", "en", true},
+		{"sí", "es", false},
+		{"yes", "en", false},
+		{"gracias", "es", false},
+		{"ok, gracias", "es", false},
+	} {
+		lang, confidence := DetectConfidence(tt.message)
+		if lang != tt.lang || (confidence >= ConfidentDetection) != tt.confident || confidence < 0 || confidence > 1 {
+			t.Errorf("DetectConfidence(%q) = %q, %.2f, want %q, confident %v", tt.message, lang, confidence, tt.lang, tt.confident)
+		}
+	}
+	// Short messages without telling words get the plain guess, with no confidence.
+	for _, message := range []string{"ok", "Madrid", "París?"} {
+		if lang, confidence := DetectConfidence(message); lang != Detect(message) || confidence != 0 {
+			t.Errorf("DetectConfidence(%q) = %q, %.2f", message, lang, confidence)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package llmclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrBudgetExceeded is returned (wrapped in a *BudgetError) by calls that the request's token
+// budget can no longer pay for.
+var ErrBudgetExceeded = errors.New("token budget exceeded")
+
+// BudgetError is a call refused because too little of the request's token budget is left.
+type BudgetError struct {
+	Limit     int // The request's budget
+	Used      int // Tokens its earlier calls used
+	Needed    int // Tokens the refused call needs at least: its prompt plus the smallest useful completion
+	Remaining int // Tokens left
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("token budget exceeded: %d of %d tokens used, the next call needs at least %d but %d are left", e.Used, e.Limit, e.Needed, e.Remaining)
+}
+
+func (e *BudgetError) Unwrap() error {
+	return ErrBudgetExceeded
+}
+
+// Budget caps the tokens (prompt and completion) that one request's LLM calls may use
+// together. The budgeted client decorator (see WithTokenBudget) charges every call to the
+// budget in its context; callers read what is left to size each call's completion cap with
+// WithMaxTokens. A request's calls may run concurrently, so it is safe for concurrent use.
+type Budget struct {
+	limit int
+
+	mu   sync.Mutex
+	used int
+}
+
+// NewBudget returns a budget of limit tokens.
+func NewBudget(limit int) *Budget {
+	return &Budget{limit: limit}
+}
+
+// Limit returns the budget's size in tokens.
+func (b *Budget) Limit() int {
+	return b.limit
+}
+
+// Used returns the tokens charged so far.
+func (b *Budget) Used() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Remaining returns the tokens left, never less than zero.
+func (b *Budget) Remaining() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(0, b.limit-b.used)
+}
+
+// Check returns a *BudgetError if a call with a prompt of promptTokens and a completion of at
+// least minCompletion tokens no longer fits in the budget.
+func (b *Budget) Check(promptTokens, minCompletion int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	remaining := max(0, b.limit-b.used)
+	if needed := promptTokens + minCompletion; needed > remaining {
+		return &BudgetError{Limit: b.limit, Used: b.used, Needed: needed, Remaining: remaining}
+	}
+	return nil
+}
+
+func (b *Budget) charge(tokens int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used += tokens
+}
+
+type budgetKey struct{}
+
+// WithBudget returns a context whose LLM calls are charged to b.
+func WithBudget(ctx context.Context, b *Budget) context.Context {
+	return context.WithValue(ctx, budgetKey{}, b)
+}
+
+// BudgetFrom returns the budget of ctx, or nil if its calls are not budgeted.
+func BudgetFrom(ctx context.Context) *Budget {
+	b, _ := ctx.Value(budgetKey{}).(*Budget)
+	return b
+}
+
+type maxTokensKey struct{}
+
+// WithMaxTokens returns a context whose LLM calls ask the model for at most n completion
+// tokens (OpenAI's max_tokens). The LLM interface takes no request parameters, so the cap
+// travels with the call's context like its deadline. n <= 0 removes the cap.
+func WithMaxTokens(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, maxTokensKey{}, n)
+}
+
+// MaxTokens returns the completion cap set on ctx with WithMaxTokens, or 0 if there is none.
+func MaxTokens(ctx context.Context) int {
+	n, _ := ctx.Value(maxTokensKey{}).(int)
+	return max(0, n)
+}
+
+// EstimateTokens approximates how many tokens a model counts in text: about four characters
+// per token, the usual rule of thumb for English text. It sizes calls before they are made and
+// stands in for usage a provider doesn't report.
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// usageRecorder collects the usage a client reports for one call, so the budget and quota
+// decorators can charge it. See reportUsage.
+type usageRecorder struct {
+	mu       sync.Mutex
+	tokens   int
+	reported bool
+	outer    *usageRecorder // The recorder of an outer decorator of the same call, if any
+}
+
+type usageRecorderKey struct{}
+
+// withUsageRecorder returns a copy of ctx whose calls' usage is recorded in the returned
+// recorder, as well as in those of the decorators outside it.
+func withUsageRecorder(ctx context.Context) (context.Context, *usageRecorder) {
+	outer, _ := ctx.Value(usageRecorderKey{}).(*usageRecorder)
+	r := &usageRecorder{outer: outer}
+	return context.WithValue(ctx, usageRecorderKey{}, r), r
+}
+
+// reportUsage is how clients report a completion's usage: to their usage hook, if they have
+// one, and to the budget and quota decorators of the call, if there are any.
+func reportUsage(ctx context.Context, onUsage UsageFunc, model string, usage Usage) {
+	if onUsage != nil {
+		onUsage(ctx, model, usage)
+	}
+	r, _ := ctx.Value(usageRecorderKey{}).(*usageRecorder)
+	for ; r != nil; r = r.outer {
+		r.mu.Lock()
+		r.tokens += usage.TotalTokens
+		r.reported = true
+		r.mu.Unlock()
+	}
+}
+
+// budgetedClient charges every call to the budget in its context and keeps calls within it.
+type budgetedClient struct {
+	next LLMClient
+}
+
+// WithTokenBudget wraps client so calls whose context carries a Budget are charged to it.
+// A call is refused with a *BudgetError, before anything is sent, when its prompt leaves no
+// room for a completion; otherwise its completion is capped (WithMaxTokens) at what is left,
+// or at the caller's lower cap. Calls are charged the usage their client reports, or an
+// estimate (EstimateTokens) if it reports none. Calls without a budget pass straight through.
+func WithTokenBudget(client LLMClient) LLMClient {
+	return &budgetedClient{next: client}
+}
+
+// begin checks the call against the budget and returns the context to make it with, whose
+// completion cap leaves the prompt's share of the budget free.
+func (c *budgetedClient) begin(ctx context.Context, b *Budget, prompt string) (context.Context, *usageRecorder, error) {
+	promptTokens := EstimateTokens(prompt)
+	if err := b.Check(promptTokens, 1); err != nil {
+		return nil, nil, err
+	}
+	limit := b.Remaining() - promptTokens
+	if capped := MaxTokens(ctx); capped > 0 && capped < limit {
+		limit = capped
+	}
+	ctx, recorder := withUsageRecorder(WithMaxTokens(ctx, limit))
+	return ctx, recorder, nil
+}
+
+// end charges a finished call: the usage its client reported, or else an estimate from its
+// prompt and answer.
+func (c *budgetedClient) end(b *Budget, recorder *usageRecorder, prompt, answer string) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.reported {
+		b.charge(recorder.tokens)
+		return
+	}
+	b.charge(EstimateTokens(prompt) + EstimateTokens(answer))
+}
+
+func (c *budgetedClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	b := BudgetFrom(ctx)
+	if b == nil {
+		return c.next.ChatCompletion(ctx, prompt)
+	}
+	callCtx, recorder, err := c.begin(ctx, b, prompt)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.next.ChatCompletion(callCtx, prompt)
+	if err == nil {
+		c.end(b, recorder, prompt, resp)
+	}
+	return resp, err
+}
+
+// StreamChatCompletion charges the call once the stream has been read to its end.
+func (c *budgetedClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	b := BudgetFrom(ctx)
+	if b == nil {
+		return c.next.StreamChatCompletion(ctx, prompt)
+	}
+	callCtx, recorder, err := c.begin(ctx, b, prompt)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := c.next.StreamChatCompletion(callCtx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		var answer strings.Builder
+		defer func() { c.end(b, recorder, prompt, answer.String()) }()
+		for chunk := range stream {
+			answer.WriteString(chunk)
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
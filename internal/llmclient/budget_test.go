@@ -0,0 +1,150 @@
+package llmclient
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(100)
+	if err := b.Check(60, 40); err != nil {
+		t.Errorf("a call that just fits refused: %v", err)
+	}
+	b.charge(70)
+	var budgetErr *BudgetError
+	if err := b.Check(20, 20); !errors.As(err, &budgetErr) || !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Check = %v, want a BudgetError", err)
+	}
+	if *budgetErr != (BudgetError{Limit: 100, Used: 70, Needed: 40, Remaining: 30}) {
+		t.Errorf("error %+v", budgetErr)
+	}
+	b.charge(50)
+	if b.Remaining() != 0 || b.Used() != 120 || b.Limit() != 100 {
+		t.Errorf("remaining %d, used %d of %d", b.Remaining(), b.Used(), b.Limit())
+	}
+
+	// A request's calls charge it concurrently.
+	b = NewBudget(1000)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				b.charge(1)
+				b.Remaining()
+			}
+		}()
+	}
+	wg.Wait()
+	if b.Used() != 100 {
+		t.Errorf("used %d after 100 charges of 1", b.Used())
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	for text, want := range map[string]int{"": 0, "a": 1, "four": 1, "fives": 2, strings.Repeat("x", 400): 100} {
+		if got := EstimateTokens(text); got != want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", text, got, want)
+		}
+	}
+}
+
+// usageClient answers every call with answer, reports usage tokens if usage isn't zero, and
+// records each call's completion cap.
+type usageClient struct {
+	answer string
+	usage  int
+
+	mu    sync.Mutex
+	calls int
+	caps  []int
+}
+
+func (c *usageClient) call(ctx context.Context) {
+	c.mu.Lock()
+	c.calls++
+	c.caps = append(c.caps, MaxTokens(ctx))
+	c.mu.Unlock()
+	if c.usage > 0 {
+		reportUsage(ctx, nil, "test", Usage{TotalTokens: c.usage})
+	}
+}
+
+func (c *usageClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	c.call(ctx)
+	return c.answer, nil
+}
+
+func (c *usageClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	c.call(ctx)
+	chunks := make(chan string, 1)
+	chunks <- c.answer
+	close(chunks)
+	return chunks, nil
+}
+
+func TestTokenBudgetCapsAndRefuses(t *testing.T) {
+	next := &usageClient{answer: "ok", usage: 300}
+	client := WithTokenBudget(next)
+	b := NewBudget(1000)
+	ctx := WithBudget(context.Background(), b)
+	prompt := strings.Repeat("x", 400) // 100 tokens
+
+	// The first call may use what the prompt leaves; it is charged the usage reported.
+	if _, err := client.ChatCompletion(ctx, prompt); err != nil {
+		t.Fatal(err)
+	}
+	if b.Used() != 300 {
+		t.Errorf("used %d, want the 300 reported", b.Used())
+	}
+	// The second gets a smaller cap, and the caller's lower cap wins.
+	if _, err := client.ChatCompletion(WithMaxTokens(ctx, 50), prompt); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.StreamChatCompletion(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range stream {
+	}
+	if want := []int{900, 50, 400}; len(next.caps) != 3 || next.caps[0] != want[0] || next.caps[1] != want[1] || next.caps[2] != want[2] {
+		t.Errorf("caps %v, want %v", next.caps, want)
+	}
+	// The streamed call was charged too, so the 100 tokens left don't fit another prompt of 100:
+	// the next calls never reach the client.
+	_, err = client.ChatCompletion(ctx, prompt)
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || !errors.Is(err, ErrBudgetExceeded) || budgetErr.Remaining != 100 {
+		t.Errorf("call over the budget: %v", err)
+	}
+	if _, err := client.StreamChatCompletion(ctx, prompt); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("streamed call over the budget: %v", err)
+	}
+	if next.calls != 3 {
+		t.Errorf("%d calls reached the client, want 3", next.calls)
+	}
+}
+
+func TestTokenBudgetEstimatesUnreportedUsage(t *testing.T) {
+	next := &usageClient{answer: strings.Repeat("y", 200)} // 50 tokens, not reported
+	client := WithTokenBudget(next)
+	b := NewBudget(1000)
+	if _, err := client.ChatCompletion(WithBudget(context.Background(), b), strings.Repeat("x", 40)); err != nil {
+		t.Fatal(err)
+	}
+	if b.Used() != 60 {
+		t.Errorf("used %d, want the estimate of 10 prompt and 50 completion tokens", b.Used())
+	}
+
+	// Calls without a budget pass through uncapped.
+	if _, err := client.ChatCompletion(context.Background(), strings.Repeat("x", 40000)); err != nil {
+		t.Fatal(err)
+	}
+	if next.caps[1] != 0 {
+		t.Errorf("unbudgeted call capped at %d", next.caps[1])
+	}
+}
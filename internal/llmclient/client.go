@@ -1,13 +1,19 @@
 package llmclient
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // LLMClient defines the interface for interacting with a Large Language Model.
@@ -16,18 +22,37 @@ type LLMClient interface {
 	ChatCompletion(ctx context.Context, prompt string) (string, error)
 }
 
+// chatCompletionsURL is the OpenAI API's chat completions endpoint.
+const chatCompletionsURL = "https://api.openai.com/v1/chat/completions"
+
+// maxStreamLine is the longest line of a streamed completion read; OpenAI sends one short JSON
+// object per line.
+const maxStreamLine = 1 << 20
+
 // OpenAIClient implements the LLMClient interface for the OpenAI API.
 type OpenAIClient struct {
-	apiKey string
-	model  string
-	client *http.Client
+	apiKey   string
+	model    string
+	client   *http.Client
+	endpoint string         // chatCompletionsURL, or a test server's
+	onUsage  UsageFunc      // Optional; see OnUsage
+	sign     RequestSigner  // Optional; see WithRequestSigner
+	headers  RequestHeaders // Optional; see SetRequestHeaders
 }
 
 // OpenAI API request/response structures
 type ChatCompletionRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream,omitempty"`
+	Model     string    `json:"model"`
+	Messages  []Message `json:"messages"`
+	Stream    bool      `json:"stream,omitempty"`
+	MaxTokens int       `json:"max_tokens,omitempty"` // From the call's context; see WithMaxTokens
+
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// StreamOptions asks a streamed completion for its usage, sent in a last chunk without choices.
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type Message struct {
@@ -37,6 +62,14 @@ type Message struct {
 
 type ChatCompletionResponse struct {
 	Choices []Choice `json:"choices"`
+	Usage   Usage    `json:"usage"`
+}
+
+// Usage is the token accounting OpenAI returns with each completion.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 type Choice struct {
@@ -49,87 +82,220 @@ type StreamChoice struct {
 	Finish string  `json:"finish_reason,omitempty"`
 }
 
+// StreamResponse is one chunk of a streamed completion: a "data:" line of its event stream.
 type StreamResponse struct {
 	Choices []StreamChoice `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"` // In the last chunk, with StreamOptions.IncludeUsage
+	Error   *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"` // A failure after the stream started
 }
 
 // NewOpenAIClient creates a new instance of OpenAIClient.
 func NewOpenAIClient(model string) *OpenAIClient {
 	return &OpenAIClient{
-		apiKey: os.Getenv("OPENAI_API_KEY"),
-		model:  model,
-		client: &http.Client{},
+		apiKey:   os.Getenv("OPENAI_API_KEY"),
+		model:    model,
+		client:   &http.Client{},
+		endpoint: chatCompletionsURL,
 	}
 }
 
-// StreamChatCompletion sends a prompt to the LLM and returns a channel for streaming the response.
+// SetAPIKey replaces the key read from OPENAI_API_KEY, e.g. with one from the server's config file.
+// It must be set before the client is used.
+func (c *OpenAIClient) SetAPIKey(key string) {
+	c.apiKey = key
+}
+
+// UsageFunc receives the token usage of one successful completion. ctx is the context of the
+// call, so callers can attribute the usage to the request that made it.
+type UsageFunc func(ctx context.Context, model string, usage Usage)
+
+// OnUsage registers fn to be called with the token usage of every successful completion.
+// It must be set before the client is used.
+func (c *OpenAIClient) OnUsage(fn UsageFunc) {
+	c.onUsage = fn
+}
+
+// WithRequestSigner registers sign to be called with every request and its body before it is
+// sent, streamed completions included. It must be set before the client is used.
+func (c *OpenAIClient) WithRequestSigner(sign RequestSigner) {
+	c.sign = sign
+}
+
+// SetRequestHeaders registers headers to be called with every request before it is signed and
+// sent, streamed completions included. It must be set before the client is used.
+func (c *OpenAIClient) SetRequestHeaders(headers RequestHeaders) {
+	c.headers = headers
+}
+
+// Model returns the model name the client sends requests to.
+func (c *OpenAIClient) Model() string {
+	return c.model
+}
+
+// StreamChatCompletion sends a prompt to the LLM and returns a channel of the answer's text as
+// the model writes it. The request is built, signed and sent as ChatCompletion's is, with
+// "stream": true; an error response fails the call before the channel is returned. The channel
+// closes when the answer is complete, when ctx ends, which also stops the request, or when the
+// stream breaks off, in which case the error is kept for StreamError. The usage, which OpenAI
+// sends last, is reported as ChatCompletion's is.
 func (c *OpenAIClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
-	// Use the non-streaming version and return it as a stream
-	result, err := c.ChatCompletion(ctx, prompt)
+	resp, err := c.send(ctx, ChatCompletionRequest{
+		Model:         c.model,
+		Messages:      []Message{{Role: "user", Content: prompt}},
+		Stream:        true,
+		MaxTokens:     MaxTokens(ctx),
+		StreamOptions: &StreamOptions{IncludeUsage: true},
+	})
 	if err != nil {
 		return nil, err
 	}
+	chunks := make(chan string)
+	go func() {
+		defer close(chunks)
+		defer resp.Body.Close()
+		usage, err := readStream(ctx, resp.Body, chunks)
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			} else {
+				slog.WarnContext(ctx, "LLM stream broke off", "model", c.model, "error", err)
+			}
+			recordStreamError(ctx, err)
+		}
+		if usage != nil {
+			c.reportUsage(ctx, *usage)
+		}
+	}()
+	return chunks, nil
+}
 
-	outputChan := make(chan string, 1)
-	outputChan <- result
-	close(outputChan)
-
-	return outputChan, nil
+// readStream sends the text of a streamed completion's event stream to chunks until the stream
+// says it is done, and returns the usage it reported, if any. It fails if ctx ends, the stream
+// reports an error, or it ends without saying it is done.
+func readStream(ctx context.Context, body io.Reader, chunks chan<- string) (*Usage, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxStreamLine)
+	var usage *Usage
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue // Blank lines between events, comments and other fields
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return usage, nil
+		}
+		var chunk StreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return usage, fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return usage, fmt.Errorf("stream failed: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 || choice.Delta.Content == "" {
+				continue
+			}
+			select {
+			case chunks <- choice.Delta.Content:
+			case <-ctx.Done():
+				return usage, ctx.Err()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return usage, fmt.Errorf("failed to read stream: %w", err)
+	}
+	return usage, io.ErrUnexpectedEOF
 }
 
 // ChatCompletion sends a prompt to the LLM and waits for the complete response.
 func (c *OpenAIClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
-	if c.apiKey == "" {
-		return "", fmt.Errorf("OpenAI API key not set")
+	resp, err := c.send(ctx, ChatCompletionRequest{
+		Model:     c.model,
+		Messages:  []Message{{Role: "user", Content: prompt}},
+		MaxTokens: MaxTokens(ctx),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// Parse response
+	var chatResp ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if len(chatResp.Choices) == 0 {
+		return "", fmt.Errorf("no response choices returned")
 	}
+	c.reportUsage(ctx, chatResp.Usage)
+	return chatResp.Choices[0].Message.Content, nil
+}
 
-	// Create the request payload
-	requestBody := ChatCompletionRequest{
-		Model: c.model,
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
+// send makes the request for body, with the headers and signature every call gets, and returns
+// the response, whose body the caller closes, or an *APIError if it isn't a 200.
+func (c *OpenAIClient) send(ctx context.Context, body ChatCompletionRequest) (*http.Response, error) {
+	if c.apiKey == "" {
+		return nil, ErrNoAPIKey
 	}
 
-	jsonBody, err := json.Marshal(requestBody)
+	jsonBody, err := json.Marshal(body)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint, bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	if body.Stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	if c.headers != nil {
+		c.headers(ctx, req.Header)
+	}
+	if c.sign != nil {
+		if err := c.sign(req, jsonBody); err != nil {
+			return nil, fmt.Errorf("failed to sign request: %w", err)
+		}
+	}
 
 	// Make the request
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("OpenAI API error (status %d): %s", resp.StatusCode, string(body))
-	}
-
-	// Parse response
-	var chatResp ChatCompletionResponse
-	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	requestID := recordResponse(ctx, resp.Header)
+	if requestID != "" {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("llm.provider_request_id", requestID))
 	}
 
-	if len(chatResp.Choices) == 0 {
-		return "", fmt.Errorf("no response choices returned")
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body), RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")), RequestID: requestID}
 	}
+	return resp, nil
+}
 
-	return chatResp.Choices[0].Message.Content, nil
+// reportUsage reports the usage of a completion (see reportUsage) and annotates the caller's
+// span with it (see tracing.TraceLLM); the annotation is a no-op when tracing is off.
+func (c *OpenAIClient) reportUsage(ctx context.Context, usage Usage) {
+	reportUsage(ctx, c.onUsage, c.model, usage)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("gen_ai.usage.input_tokens", usage.PromptTokens),
+		attribute.Int("gen_ai.usage.output_tokens", usage.CompletionTokens),
+	)
 }
@@ -0,0 +1,251 @@
+package llmclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client of model "test-model" that sends its requests to handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *OpenAIClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c := NewOpenAIClient("test-model")
+	c.SetAPIKey("test-key")
+	c.endpoint = srv.URL
+	return c
+}
+
+// writeStream writes an OpenAI event stream of chunks, with a usage chunk and [DONE] if done.
+func writeStream(w http.ResponseWriter, chunks []string, done bool) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, chunk := range chunks {
+		data, _ := json.Marshal(StreamResponse{Choices: []StreamChoice{{Delta: Message{Content: chunk}}}})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		w.(http.Flusher).Flush()
+	}
+	if done {
+		fmt.Fprint(w, `data: {"choices":[],"usage":{"prompt_tokens":7,"completion_tokens":3,"total_tokens":10}}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}
+}
+
+// collect reads stream to its end.
+func collect(stream <-chan string) []string {
+	var chunks []string
+	for chunk := range stream {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func TestChatCompletion(t *testing.T) {
+	var got ChatCompletionRequest
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer test-key" {
+			t.Errorf("Authorization = %q", auth)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"Hello"}}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`)
+	})
+	var usage Usage
+	c.OnUsage(func(_ context.Context, model string, u Usage) { usage = u })
+
+	answer, err := c.ChatCompletion(WithMaxTokens(context.Background(), 50), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "Hello" {
+		t.Errorf("answer = %q, want Hello", answer)
+	}
+	if got.Stream || got.MaxTokens != 50 || got.Messages[0].Content != "Hi" {
+		t.Errorf("request = %+v", got)
+	}
+	if usage.TotalTokens != 6 {
+		t.Errorf("usage = %+v, want 6 tokens", usage)
+	}
+}
+
+func TestChatCompletionAPIError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "2")
+		w.Header().Set(ProviderRequestIDHeader, "req_1")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	})
+	ctx := WithResponseInfo(context.Background())
+	_, err := c.ChatCompletion(ctx, "Hi")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want an *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusTooManyRequests || apiErr.RetryAfter != 2*time.Second || apiErr.RequestID != "req_1" {
+		t.Errorf("err = %+v", apiErr)
+	}
+	if !Retryable(err) {
+		t.Error("a 429 is not retryable")
+	}
+	if id := ProviderRequestID(ctx); id != "req_1" {
+		t.Errorf("ProviderRequestID = %q, want req_1", id)
+	}
+}
+
+func TestChatCompletionNoAPIKey(t *testing.T) {
+	c := NewOpenAIClient("test-model")
+	c.SetAPIKey("")
+	if _, err := c.ChatCompletion(context.Background(), "Hi"); !errors.Is(err, ErrNoAPIKey) {
+		t.Errorf("ChatCompletion err = %v, want ErrNoAPIKey", err)
+	}
+	if _, err := c.StreamChatCompletion(context.Background(), "Hi"); !errors.Is(err, ErrNoAPIKey) {
+		t.Errorf("StreamChatCompletion err = %v, want ErrNoAPIKey", err)
+	}
+}
+
+func TestStreamChatCompletion(t *testing.T) {
+	var got ChatCompletionRequest
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if accept := r.Header.Get("Accept"); accept != "text/event-stream" {
+			t.Errorf("Accept = %q", accept)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		writeStream(w, []string{"Hel", "lo, ", "wörld"}, true)
+	})
+	var usage Usage
+	c.OnUsage(func(_ context.Context, _ string, u Usage) { usage = u })
+
+	ctx := WithResponseInfo(context.Background())
+	stream, err := c.StreamChatCompletion(ctx, "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := collect(stream)
+	if want := []string{"Hel", "lo, ", "wörld"}; strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Errorf("chunks = %q, want %q", chunks, want)
+	}
+	if !got.Stream || got.StreamOptions == nil || !got.StreamOptions.IncludeUsage {
+		t.Errorf("request = %+v, want a stream with its usage", got)
+	}
+	if usage.TotalTokens != 10 {
+		t.Errorf("usage = %+v, want 10 tokens", usage)
+	}
+	if err := StreamError(ctx); err != nil {
+		t.Errorf("StreamError = %v, want nil", err)
+	}
+}
+
+func TestStreamChatCompletionChunksArriveAsWritten(t *testing.T) {
+	release := make(chan struct{})
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, []string{"first"}, false)
+		<-release
+		writeStream(w, []string{"second"}, true)
+	})
+	stream, err := c.StreamChatCompletion(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case chunk := <-stream:
+		if chunk != "first" {
+			t.Errorf("first chunk = %q", chunk)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first chunk didn't arrive before the stream finished")
+	}
+	close(release)
+	if rest := collect(stream); len(rest) != 1 || rest[0] != "second" {
+		t.Errorf("rest = %q, want [second]", rest)
+	}
+}
+
+func TestStreamChatCompletionBreaksOff(t *testing.T) {
+	for name, body := range map[string]string{
+		"no [DONE]":    `data: {"choices":[{"delta":{"content":"partial"}}]}` + "\n\n",
+		"error event":  `data: {"choices":[{"delta":{"content":"partial"}}]}` + "\n\n" + `data: {"error":{"message":"overloaded"}}` + "\n\n",
+		"invalid JSON": `data: {"choices":[{"delta":{"content":"partial"}}]}` + "\n\n" + "data: {oops\n\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, body)
+			})
+			ctx := WithResponseInfo(context.Background())
+			stream, err := c.StreamChatCompletion(ctx, "Hi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chunks := collect(stream); len(chunks) != 1 || chunks[0] != "partial" {
+				t.Errorf("chunks = %q, want [partial]", chunks)
+			}
+			if err := StreamError(ctx); err == nil {
+				t.Error("StreamError = nil, want the break")
+			}
+		})
+	}
+}
+
+func TestStreamChatCompletionCancel(t *testing.T) {
+	stopped := make(chan struct{})
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		defer close(stopped)
+		for i := 0; ; i++ {
+			data, _ := json.Marshal(StreamResponse{Choices: []StreamChoice{{Delta: Message{Content: "word "}}}})
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	})
+	ctx, cancel := context.WithCancel(WithResponseInfo(context.Background()))
+	stream, err := c.StreamChatCompletion(ctx, "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		<-stream
+	}
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		collect(stream)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream didn't close after its context was cancelled")
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request wasn't stopped")
+	}
+	if err := StreamError(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("StreamError = %v, want context.Canceled", err)
+	}
+}
+
+func TestStreamChatCompletionAPIError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	})
+	stream, err := c.StreamChatCompletion(context.Background(), "Hi")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("err = %v, want a 502 *APIError", err)
+	}
+	if stream != nil {
+		t.Error("a failed call returned a stream")
+	}
+}
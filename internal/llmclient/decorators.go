@@ -0,0 +1,121 @@
+package llmclient
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Cris245/go-llm-chat/internal/ratelimit"
+)
+
+// retryingClient retries calls that fail with a Retryable error.
+type retryingClient struct {
+	next       LLMClient
+	maxRetries int
+	baseDelay  time.Duration
+}
+
+// WithRetry wraps client so failed calls are retried up to maxRetries times with exponential
+// backoff starting at baseDelay (with jitter), or after the provider's Retry-After when it sends
+// one. The number of retries is recorded on the caller's span as llm.retry_count.
+func WithRetry(client LLMClient, maxRetries int, baseDelay time.Duration) LLMClient {
+	if maxRetries <= 0 {
+		return client
+	}
+	return &retryingClient{next: client, maxRetries: maxRetries, baseDelay: baseDelay}
+}
+
+func (c *retryingClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	var resp string
+	err := c.do(ctx, func() (err error) {
+		resp, err = c.next.ChatCompletion(ctx, prompt)
+		return err
+	})
+	return resp, err
+}
+
+// StreamChatCompletion retries until the stream is available; a stream that fails midway is not restarted.
+func (c *retryingClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	var stream <-chan string
+	err := c.do(ctx, func() (err error) {
+		stream, err = c.next.StreamChatCompletion(ctx, prompt)
+		return err
+	})
+	return stream, err
+}
+
+func (c *retryingClient) do(ctx context.Context, call func() error) error {
+	retries := 0
+	defer func() {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("llm.retry_count", retries))
+	}()
+	for {
+		err := call()
+		if err == nil || retries == c.maxRetries || !Retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		delay := c.baseDelay << retries
+		delay += rand.N(delay/2 + 1) // Jitter keeps parallel callers from retrying in lockstep.
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+		retries++
+		slog.WarnContext(ctx, "Retrying LLM call", "attempt", retries+1, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// rateLimitedClient waits for the shared limiter before every call.
+type rateLimitedClient struct {
+	next    LLMClient
+	limiter *ratelimit.Limiter
+	key     string
+}
+
+// WithRateLimit wraps client so each call first waits for limiter's budget under key. Share one
+// limiter and key between the slots that use the same provider account, since its quota is shared.
+// A nil limiter leaves client unchanged.
+func WithRateLimit(client LLMClient, limiter *ratelimit.Limiter, key string) LLMClient {
+	if limiter == nil {
+		return client
+	}
+	return &rateLimitedClient{next: client, limiter: limiter, key: key}
+}
+
+func (c *rateLimitedClient) wait(ctx context.Context) error {
+	for {
+		ok, wait := c.limiter.Allow(c.key)
+		if ok {
+			return nil
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *rateLimitedClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	if err := c.wait(ctx); err != nil {
+		return "", err
+	}
+	return c.next.ChatCompletion(ctx, prompt)
+}
+
+func (c *rateLimitedClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.next.StreamChatCompletion(ctx, prompt)
+}
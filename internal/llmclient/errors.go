@@ -0,0 +1,53 @@
+package llmclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrNoAPIKey is returned by calls on a client that has no API key.
+var ErrNoAPIKey = errors.New("OpenAI API key not set")
+
+// APIError is a non-200 response from the provider's API.
+type APIError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // From the Retry-After header; zero if absent
+	RequestID  string        // The provider's ID of the request (see ProviderRequestIDHeader); "" if absent
+}
+
+func (e *APIError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("OpenAI API error (status %d, request %s): %s", e.StatusCode, e.RequestID, e.Body)
+	}
+	return fmt.Sprintf("OpenAI API error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// Retryable reports whether err is worth retrying: rate limiting (429), server errors (5xx)
+// and network failures. Cancellation, timeouts of the caller's context, and client errors
+// such as a bad request or a missing key are final.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds; HTTP dates are ignored.
+func parseRetryAfter(raw string) time.Duration {
+	secs, err := strconv.Atoi(raw)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
@@ -0,0 +1,60 @@
+package llmclient
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Providers accepted by New.
+const (
+	ProviderOpenAI = "openai"
+	ProviderMock   = "mock" // Canned answers after an artificial latency; for benchmarks and load tests
+)
+
+// Providers lists the providers New can construct, for validation and error messages.
+var Providers = []string{ProviderOpenAI, ProviderMock}
+
+// ProviderConfig describes one client to construct.
+type ProviderConfig struct {
+	Provider string
+	Model    string
+	APIKey   string
+	OnUsage  UsageFunc      // Optional; called with the token usage of every completion
+	Signer   RequestSigner  // Optional; signs every request to the provider's API
+	Headers  RequestHeaders // Optional; adds headers to every request to the provider's API
+
+	MockLatency time.Duration // The mock provider's artificial latency
+}
+
+// New constructs the client implementation for cfg.Provider. Decorators (retries, rate limiting,
+// metrics, tracing) are applied by the caller so every provider gets the same ones.
+func New(cfg ProviderConfig) (LLMClient, error) {
+	switch cfg.Provider {
+	case ProviderOpenAI:
+		client := NewOpenAIClient(cfg.Model)
+		client.SetAPIKey(cfg.APIKey)
+		if cfg.OnUsage != nil {
+			client.OnUsage(cfg.OnUsage)
+		}
+		if cfg.Signer != nil {
+			client.WithRequestSigner(cfg.Signer)
+		}
+		if cfg.Headers != nil {
+			client.SetRequestHeaders(cfg.Headers)
+		}
+		return client, nil
+	case ProviderMock:
+		client := NewMockClient(cfg.MockLatency)
+		client.Model = cfg.Model
+		client.OnUsage = cfg.OnUsage
+		return client, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q (supported: %v)", cfg.Provider, Providers)
+	}
+}
+
+// KnownProvider reports whether New can construct provider.
+func KnownProvider(provider string) bool {
+	return slices.Contains(Providers, provider)
+}
@@ -0,0 +1,146 @@
+package llmclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/ratelimit"
+)
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		provider, model string
+	}{
+		{ProviderOpenAI, "gpt-4o-mini"},
+		{ProviderOpenAI, "gpt-4o"},
+		{ProviderMock, "mock-small"},
+		{ProviderMock, "mock-large"},
+	} {
+		client, err := New(ProviderConfig{Provider: tt.provider, Model: tt.model, APIKey: "sk-test", MockLatency: time.Millisecond})
+		if err != nil {
+			t.Fatalf("%s/%s: %v", tt.provider, tt.model, err)
+		}
+		switch c := client.(type) {
+		case *OpenAIClient:
+			if tt.provider != ProviderOpenAI || c.model != tt.model || c.apiKey != "sk-test" {
+				t.Errorf("%s/%s: OpenAI client of model %q", tt.provider, tt.model, c.model)
+			}
+		case *MockClient:
+			if tt.provider != ProviderMock || c.Model != tt.model || c.Latency != time.Millisecond {
+				t.Errorf("%s/%s: mock of model %q, latency %v", tt.provider, tt.model, c.Model, c.Latency)
+			}
+		default:
+			t.Errorf("%s/%s: client %T", tt.provider, tt.model, client)
+		}
+	}
+
+	for _, provider := range []string{"", "anthropic", "OpenAI"} {
+		_, err := New(ProviderConfig{Provider: provider, Model: "m"})
+		if err == nil || !strings.Contains(err.Error(), "supported: [openai mock]") {
+			t.Errorf("provider %q: error %v, want one listing the supported providers", provider, err)
+		}
+		if KnownProvider(provider) {
+			t.Errorf("KnownProvider(%q)", provider)
+		}
+	}
+}
+
+func TestNewMockReportsUsage(t *testing.T) {
+	var models []string
+	client, _ := New(ProviderConfig{Provider: ProviderMock, Model: "mock-large", OnUsage: func(_ context.Context, model string, _ Usage) {
+		models = append(models, model)
+	}})
+	if _, err := client.ChatCompletion(context.Background(), "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 1 || models[0] != "mock-large" {
+		t.Errorf("usage reported for %v", models)
+	}
+}
+
+// scriptedClient fails with errs in turn, then answers "ok".
+type scriptedClient struct {
+	errs  []error
+	calls int
+}
+
+func (c *scriptedClient) ChatCompletion(context.Context, string) (string, error) {
+	c.calls++
+	if c.calls <= len(c.errs) {
+		return "", c.errs[c.calls-1]
+	}
+	return "ok", nil
+}
+
+func (c *scriptedClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	resp, err := c.ChatCompletion(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	stream := make(chan string, 1)
+	stream <- resp
+	close(stream)
+	return stream, nil
+}
+
+func TestWithRetry(t *testing.T) {
+	unavailable := &APIError{StatusCode: http.StatusServiceUnavailable}
+	for _, tt := range []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"transient failures", []error{unavailable, &APIError{StatusCode: http.StatusTooManyRequests}}, 3, nil},
+		{"out of retries", []error{unavailable, unavailable, unavailable}, 3, unavailable},
+		{"bad request is final", []error{&APIError{StatusCode: http.StatusBadRequest}}, 1, nil},
+		{"missing key is final", []error{ErrNoAPIKey}, 1, ErrNoAPIKey},
+	} {
+		next := &scriptedClient{errs: tt.errs}
+		_, err := WithRetry(next, 2, time.Millisecond).ChatCompletion(context.Background(), "hi")
+		if next.calls != tt.wantCalls {
+			t.Errorf("%s: %d calls, want %d", tt.name, next.calls, tt.wantCalls)
+		}
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if len(tt.errs) < tt.wantCalls && err != nil {
+			t.Errorf("%s: error %v after the failures ran out", tt.name, err)
+		}
+	}
+
+	next := &scriptedClient{}
+	if WithRetry(next, 0, time.Millisecond) != LLMClient(next) {
+		t.Error("WithRetry with no retries wrapped the client")
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	// Two slots on one provider share a budget of one call now and then one per 20ms.
+	limiter := ratelimit.New(ratelimit.Config{RPS: 50, Burst: 1})
+	a := WithRateLimit(&scriptedClient{}, limiter, ProviderOpenAI)
+	b := WithRateLimit(&scriptedClient{}, limiter, ProviderOpenAI)
+	start := time.Now()
+	for _, client := range []LLMClient{a, b, a} {
+		if _, err := client.ChatCompletion(context.Background(), "hi"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("three calls took %v, want them spread by the shared limit", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.ChatCompletion(ctx, "hi"); !errors.Is(err, context.Canceled) {
+		t.Errorf("waiting with a cancelled context: %v", err)
+	}
+	next := &scriptedClient{}
+	if WithRateLimit(next, nil, ProviderOpenAI) != LLMClient(next) {
+		t.Error("WithRateLimit without a limiter wrapped the client")
+	}
+}
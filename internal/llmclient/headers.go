@@ -0,0 +1,81 @@
+package llmclient
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// ProviderRequestIDHeader is the response header in which OpenAI returns the ID it gave a
+// request, which its support asks for when debugging one.
+const ProviderRequestIDHeader = "X-Request-Id"
+
+// RequestHeaders is called with the context of each call and the headers of its request to
+// the provider, before the request is signed and sent, to add headers such as the caller's
+// request ID or trace context.
+type RequestHeaders func(ctx context.Context, header http.Header)
+
+// ResponseInfo collects what the provider said about the calls made with a context; see
+// WithResponseInfo. It is safe for concurrent use.
+type ResponseInfo struct {
+	mu        sync.Mutex
+	requestID string
+	streamErr error
+}
+
+type responseInfoKey struct{}
+
+// WithResponseInfo returns a copy of ctx that collects what the provider says about the calls
+// made with it, for ProviderRequestID. Give each call its own, or they overwrite each other.
+func WithResponseInfo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, responseInfoKey{}, &ResponseInfo{})
+}
+
+// ProviderRequestID returns the request ID the provider returned for the last call made with
+// ctx (see ProviderRequestIDHeader), failed calls included, or "" if ctx doesn't come from
+// WithResponseInfo or the provider returned none. With retries, it is the last attempt's.
+func ProviderRequestID(ctx context.Context) string {
+	info, _ := ctx.Value(responseInfoKey{}).(*ResponseInfo)
+	if info == nil {
+		return ""
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	return info.requestID
+}
+
+// StreamError returns the error that the last streamed completion made with ctx broke off
+// with, or nil if it hasn't broken off, or if ctx doesn't come from WithResponseInfo. A stream
+// can't fail once it has started, only end early; callers that must tell an early end from a
+// complete answer read it once the stream's channel has closed.
+func StreamError(ctx context.Context) error {
+	info, _ := ctx.Value(responseInfoKey{}).(*ResponseInfo)
+	if info == nil {
+		return nil
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	return info.streamErr
+}
+
+// recordStreamError keeps the error a stream broke off with in ctx's ResponseInfo, if it has
+// one.
+func recordStreamError(ctx context.Context, err error) {
+	if info, _ := ctx.Value(responseInfoKey{}).(*ResponseInfo); info != nil {
+		info.mu.Lock()
+		info.streamErr = err
+		info.mu.Unlock()
+	}
+}
+
+// recordResponse keeps the request ID of a provider response in ctx's ResponseInfo, if it has
+// one, and returns it.
+func recordResponse(ctx context.Context, header http.Header) string {
+	id := header.Get(ProviderRequestIDHeader)
+	if info, _ := ctx.Value(responseInfoKey{}).(*ResponseInfo); info != nil {
+		info.mu.Lock()
+		info.requestID = id
+		info.mu.Unlock()
+	}
+	return id
+}
@@ -0,0 +1,120 @@
+package llmclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type callIDKey struct{}
+
+func TestRequestHeaders(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []string // The X-Call-ID of each request, as the provider got it
+	)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		seen = append(seen, r.Header.Get("X-Call-ID"))
+		mu.Unlock()
+		w.Header().Set(ProviderRequestIDHeader, "req_"+r.Header.Get("X-Call-ID"))
+		if strings.Contains(string(body), `"stream":true`) {
+			writeStream(w, []string{"Hello"}, true)
+			return
+		}
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"Hello"}}]}`)
+	})
+	c.SetRequestHeaders(func(ctx context.Context, header http.Header) {
+		if id, _ := ctx.Value(callIDKey{}).(string); id != "" {
+			header.Set("X-Call-ID", id)
+		}
+	})
+	// The headers are added before the request is signed, so the signature can cover them.
+	var signedWith []string
+	c.WithRequestSigner(func(r *http.Request, body []byte) error {
+		signedWith = append(signedWith, r.Header.Get("X-Call-ID"))
+		return nil
+	})
+
+	buffered := WithResponseInfo(context.WithValue(context.Background(), callIDKey{}, "buffered"))
+	if _, err := c.ChatCompletion(buffered, "Hi"); err != nil {
+		t.Fatal(err)
+	}
+	streamed := WithResponseInfo(context.WithValue(context.Background(), callIDKey{}, "streamed"))
+	stream, err := c.StreamChatCompletion(streamed, "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect(stream)
+
+	if strings.Join(seen, " ") != "buffered streamed" || strings.Join(signedWith, " ") != "buffered streamed" {
+		t.Errorf("provider saw %q, signer saw %q", seen, signedWith)
+	}
+	// Each call's context has the provider's ID of its own request.
+	if id := ProviderRequestID(buffered); id != "req_buffered" {
+		t.Errorf("buffered call's provider request ID %q", id)
+	}
+	if id := ProviderRequestID(streamed); id != "req_streamed" {
+		t.Errorf("streamed call's provider request ID %q", id)
+	}
+}
+
+func TestProviderRequestID(t *testing.T) {
+	var id string // The provider request ID the server returns; none if empty
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if id != "" {
+			w.Header().Set(ProviderRequestIDHeader, id)
+		}
+		if id == "req_failed" {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"Hello"}}]}`)
+	})
+
+	// A failed streamed call keeps it too, and its error names it.
+	id = "req_failed"
+	ctx := WithResponseInfo(context.Background())
+	_, err := c.StreamChatCompletion(ctx, "Hi")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RequestID != "req_failed" || !strings.Contains(err.Error(), "request req_failed") {
+		t.Errorf("err = %v", err)
+	}
+	if got := ProviderRequestID(ctx); got != "req_failed" {
+		t.Errorf("failed streamed call's provider request ID %q", got)
+	}
+
+	// A later call with the same context replaces it; one without an ID clears it.
+	id = "req_2"
+	if _, err := c.ChatCompletion(ctx, "Hi"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ProviderRequestID(ctx); got != "req_2" {
+		t.Errorf("provider request ID %q after a second call", got)
+	}
+	id = ""
+	if _, err := c.ChatCompletion(ctx, "Hi"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ProviderRequestID(ctx); got != "" {
+		t.Errorf("provider request ID %q after a response without one", got)
+	}
+
+	// Without WithResponseInfo there is nowhere to keep it.
+	id = "req_3"
+	plain := context.Background()
+	if _, err := c.ChatCompletion(plain, "Hi"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ProviderRequestID(plain); got != "" {
+		t.Errorf("provider request ID %q without WithResponseInfo", got)
+	}
+	if err := (&APIError{StatusCode: 500, Body: "oops"}).Error(); strings.Contains(err, "request") {
+		t.Errorf("error without a request ID: %q", err)
+	}
+}
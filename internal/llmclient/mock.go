@@ -0,0 +1,106 @@
+package llmclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// mockAnswer is the canned text MockClient answers with when Response is empty. It is long
+// enough to stream as a few dozen chunks.
+const mockAnswer = "This is a mock answer from the benchmark LLM. It stands in for a real provider so the " +
+	"pipeline can be measured without network calls, API keys or token costs. The orchestration, " +
+	"database lookups, event streaming and aggregation all run as they do in production; only the " +
+	"model's reply is canned."
+
+// MockClient answers every prompt with canned text after an artificial latency, with no
+// network access. It serves benchmarks and load tests (provider "mock"), where the numbers
+// should reflect the server rather than a provider.
+type MockClient struct {
+	Latency    time.Duration // Before the answer, or before the first streamed chunk
+	ChunkDelay time.Duration // Between streamed chunks
+	Response   string        // The answer; a fixed paragraph if empty
+	Model      string        // Reported with the usage
+	OnUsage    UsageFunc     // Optional; called with an estimated usage after every answer
+}
+
+// NewMockClient returns a mock that answers after latency and streams a chunk per word,
+// spreading another latency over the chunks.
+func NewMockClient(latency time.Duration) *MockClient {
+	return &MockClient{Latency: latency, ChunkDelay: latency / time.Duration(len(strings.Fields(mockAnswer)))}
+}
+
+// ChatCompletion waits for the latency and returns the answer.
+func (m *MockClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	if err := sleep(ctx, m.Latency); err != nil {
+		return "", err
+	}
+	answer := m.answer(ctx, prompt)
+	m.reportUsage(ctx, prompt, answer)
+	return answer, nil
+}
+
+// StreamChatCompletion sends the answer a word at a time: the first chunk after the latency,
+// the others ChunkDelay apart. The channel closes after the last chunk or when ctx ends.
+func (m *MockClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	chunks := make(chan string)
+	go func() {
+		defer close(chunks)
+		if sleep(ctx, m.Latency) != nil {
+			return
+		}
+		answer := m.answer(ctx, prompt)
+		defer m.reportUsage(ctx, prompt, answer)
+		for i, word := range strings.SplitAfter(answer, " ") {
+			if i > 0 && sleep(ctx, m.ChunkDelay) != nil {
+				return
+			}
+			select {
+			case chunks <- word:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return chunks, nil
+}
+
+// answer returns the canned answer, cut short like a real model's at the call's completion
+// cap (see WithMaxTokens), counting about four characters per token.
+func (m *MockClient) answer(ctx context.Context, prompt string) string {
+	answer := m.Response
+	if answer == "" {
+		answer = fmt.Sprintf("%s (Prompt: %d characters.)", mockAnswer, len(prompt))
+	}
+	if limit := MaxTokens(ctx) * 4; limit > 0 && len(answer) > limit {
+		for !utf8.RuneStart(answer[limit]) {
+			limit--
+		}
+		answer = answer[:limit]
+	}
+	return answer
+}
+
+// reportUsage reports an estimate of the tokens a real model would have counted (see
+// EstimateTokens).
+func (m *MockClient) reportUsage(ctx context.Context, prompt, answer string) {
+	prompted, completed := EstimateTokens(prompt), EstimateTokens(answer)
+	reportUsage(ctx, m.OnUsage, m.Model, Usage{PromptTokens: prompted, CompletionTokens: completed, TotalTokens: prompted + completed})
+}
+
+// sleep waits for d, returning ctx's error if it ends first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,27 @@
+package llmclient
+
+// Price is what a model charges, in US dollars per million tokens.
+type Price struct {
+	Prompt     float64 `yaml:"prompt" json:"prompt"`
+	Completion float64 `yaml:"completion" json:"completion"`
+}
+
+// DefaultPrices are OpenAI's list prices for the models this server is usually run with.
+// They go stale; the server's usage.prices setting overrides and extends them.
+var DefaultPrices = map[string]Price{
+	"gpt-4o-mini":   {Prompt: 0.15, Completion: 0.60},
+	"gpt-4o":        {Prompt: 2.50, Completion: 10.00},
+	"gpt-4.1-mini":  {Prompt: 0.40, Completion: 1.60},
+	"gpt-4.1":       {Prompt: 2.00, Completion: 8.00},
+	"gpt-3.5-turbo": {Prompt: 0.50, Completion: 1.50},
+}
+
+// EstimateCost returns the cost in US dollars of usage on model at prices, and false when
+// prices has no entry for the model.
+func EstimateCost(prices map[string]Price, model string, usage Usage) (float64, bool) {
+	price, ok := prices[model]
+	if !ok {
+		return 0, false
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6, true
+}
@@ -0,0 +1,269 @@
+package llmclient
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quotaWindow is the span over which a Quota counts tokens and requests, as providers do.
+const quotaWindow = time.Minute
+
+// quotaCompletionTokens is the completion a call without a cap (see WithMaxTokens) is assumed
+// to write until its usage is known.
+const quotaCompletionTokens = 512
+
+// Priority orders the calls waiting for a Quota: higher ones go first.
+type Priority int
+
+const (
+	PriorityBackground  Priority = -1 // Work no one waits for, such as conversation titles
+	PriorityInteractive Priority = 0  // The default: calls a request's answer waits for
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose LLM calls wait for their Quota with priority p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFrom returns the priority set on ctx with WithPriority, or PriorityInteractive.
+func PriorityFrom(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// Quota keeps the calls to one provider account within its tokens and requests per minute, so
+// slots that share the account queue for it rather than each running into 429s. A call is
+// counted from when it starts, at an estimate of its tokens (its prompt and its completion cap)
+// that is corrected to the usage its client reports once it has finished. A call that doesn't
+// fit waits until enough of the last minute's calls have aged out; waiting calls start in
+// order of priority, then of arrival, and a call larger than the whole minute's tokens starts
+// once no others are counted. Create it with NewQuota; it is safe for concurrent use.
+type Quota struct {
+	tpm, rpm int
+	now      func() time.Time                     // Replaced in tests
+	after    func(time.Duration) <-chan time.Time // Replaced in tests
+
+	mu      sync.Mutex
+	calls   []*quotaCall   // Started in the last window, oldest first
+	waiting []*quotaWaiter // In the order they start
+	arrived uint64         // Waiters so far, to order those of equal priority
+	changed chan struct{}  // Closed, and replaced, when waiters may be able to start
+}
+
+// quotaCall is one call counted against a Quota.
+type quotaCall struct {
+	start  time.Time
+	tokens int
+}
+
+// quotaWaiter is one call waiting to start.
+type quotaWaiter struct {
+	priority Priority
+	arrival  uint64
+}
+
+// NewQuota returns a Quota of tpm tokens and rpm requests per minute; 0 leaves either
+// unlimited. It returns nil, which WithQuota ignores, if both are 0.
+func NewQuota(tpm, rpm int) *Quota {
+	if tpm <= 0 && rpm <= 0 {
+		return nil
+	}
+	return &Quota{tpm: tpm, rpm: rpm, now: time.Now, after: time.After, changed: make(chan struct{})}
+}
+
+// acquire waits until a call of tokens may start under the quota, then counts it, and returns
+// how long it waited. It fails only if ctx is done first.
+func (q *Quota) acquire(ctx context.Context, tokens int) (*quotaCall, time.Duration, error) {
+	q.mu.Lock()
+	w := &quotaWaiter{priority: PriorityFrom(ctx), arrival: q.arrived}
+	q.arrived++
+	at, _ := slices.BinarySearchFunc(q.waiting, w, func(a, b *quotaWaiter) int {
+		return cmp.Or(cmp.Compare(b.priority, a.priority), cmp.Compare(a.arrival, b.arrival))
+	})
+	q.waiting = slices.Insert(q.waiting, at, w)
+	arrived, queued := q.now(), false
+	for {
+		now := q.now()
+		q.prune(now)
+		var timer <-chan time.Time // Only the first waiter times its start; the others wait for it
+		if q.waiting[0] == w {
+			wait := q.wait(now, tokens)
+			if wait <= 0 {
+				q.waiting = q.waiting[1:]
+				call := &quotaCall{start: now, tokens: tokens}
+				q.calls = append(q.calls, call)
+				q.notify()
+				q.mu.Unlock()
+				if !queued {
+					return call, 0, nil
+				}
+				return call, now.Sub(arrived), nil
+			}
+			timer = q.after(wait)
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		queued = true
+		select {
+		case <-changed:
+		case <-timer:
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.waiting = slices.DeleteFunc(q.waiting, func(o *quotaWaiter) bool { return o == w })
+			q.notify()
+			q.mu.Unlock()
+			return nil, 0, ctx.Err()
+		}
+		q.mu.Lock()
+	}
+}
+
+// wait returns how long from now a call of tokens has to wait to fit in both limits, or 0 if
+// it fits now. q.mu must be held.
+func (q *Quota) wait(now time.Time, tokens int) time.Duration {
+	until := now
+	if q.rpm > 0 && len(q.calls) >= q.rpm {
+		until = q.calls[len(q.calls)-q.rpm].start.Add(quotaWindow)
+	}
+	if q.tpm > 0 {
+		used := 0
+		for _, call := range q.calls {
+			used += call.tokens
+		}
+		// Age the oldest calls out until the call fits, or until none are left.
+		for _, call := range q.calls {
+			if used+tokens <= q.tpm || used == 0 {
+				break
+			}
+			used -= call.tokens
+			if expiry := call.start.Add(quotaWindow); expiry.After(until) {
+				until = expiry
+			}
+		}
+	}
+	return until.Sub(now)
+}
+
+// prune forgets the calls that started more than a window ago. q.mu must be held.
+func (q *Quota) prune(now time.Time) {
+	i := 0
+	for i < len(q.calls) && !now.Before(q.calls[i].start.Add(quotaWindow)) {
+		i++
+	}
+	q.calls = q.calls[i:]
+}
+
+// correct replaces the estimate counted for call with the tokens it used.
+func (q *Quota) correct(call *quotaCall, tokens int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	call.tokens = tokens
+	q.notify()
+}
+
+// notify wakes the waiters to check whether they can start. q.mu must be held.
+func (q *Quota) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// quotaClient waits for a Quota before every call.
+type quotaClient struct {
+	next  LLMClient
+	quota *Quota
+}
+
+// WithQuota wraps client so each call first waits for quota, and is counted against it at
+// the usage its client reports. Share one quota between the clients of the same provider
+// account. A nil quota leaves client unchanged.
+func WithQuota(client LLMClient, quota *Quota) LLMClient {
+	if quota == nil {
+		return client
+	}
+	return &quotaClient{next: client, quota: quota}
+}
+
+// begin waits for the quota to let a call with prompt start, and returns the context to make
+// it with, which records its usage.
+func (c *quotaClient) begin(ctx context.Context, prompt string) (context.Context, *quotaCall, *usageRecorder, error) {
+	completion := MaxTokens(ctx)
+	if completion == 0 {
+		completion = quotaCompletionTokens
+	}
+	call, waited, err := c.quota.acquire(ctx, EstimateTokens(prompt)+completion)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if waited > 0 {
+		slog.DebugContext(ctx, "Waited for the provider's quota", "wait", waited, "priority", PriorityFrom(ctx))
+	}
+	ctx, recorder := withUsageRecorder(ctx)
+	return ctx, call, recorder, nil
+}
+
+// end counts the usage the call's client reported in place of its estimate, or else, if
+// estimate is positive, that.
+func (c *quotaClient) end(call *quotaCall, recorder *usageRecorder, estimate int) {
+	recorder.mu.Lock()
+	tokens, reported := recorder.tokens, recorder.reported
+	recorder.mu.Unlock()
+	switch {
+	case reported:
+		c.quota.correct(call, tokens)
+	case estimate > 0:
+		c.quota.correct(call, estimate)
+	}
+}
+
+// ChatCompletion counts a call that reported no usage at an estimate from its prompt and
+// answer, and a failed one at its first estimate.
+func (c *quotaClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	ctx, call, recorder, err := c.begin(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.next.ChatCompletion(ctx, prompt)
+	estimate := 0
+	if err == nil {
+		estimate = EstimateTokens(prompt) + EstimateTokens(resp)
+	}
+	c.end(call, recorder, estimate)
+	return resp, err
+}
+
+// StreamChatCompletion counts the call once its stream has ended, at the usage reported by
+// then or else at an estimate from its prompt and the text streamed; a call that fails to
+// start keeps its first estimate.
+func (c *quotaClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	callCtx, call, recorder, err := c.begin(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := c.next.StreamChatCompletion(callCtx, prompt)
+	if err != nil {
+		c.end(call, recorder, 0)
+		return nil, err
+	}
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		var answer strings.Builder
+		defer func() { c.end(call, recorder, EstimateTokens(prompt)+EstimateTokens(answer.String())) }()
+		for chunk := range stream {
+			answer.WriteString(chunk)
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
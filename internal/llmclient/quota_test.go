@@ -0,0 +1,259 @@
+package llmclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that moves only when told to, for a Quota's now and after.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := fakeTimer{at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	return t.ch
+}
+
+// Advance moves the clock on by d and fires the timers that are then due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = pending
+}
+
+// Timers returns how many timers are set and haven't fired.
+func (c *fakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// newTestQuota returns a quota of tpm tokens and rpm requests per minute on a fake clock.
+func newTestQuota(tpm, rpm int) (*Quota, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	q := NewQuota(tpm, rpm)
+	q.now, q.after = clock.Now, clock.After
+	return q, clock
+}
+
+// acquired is the outcome of a call to acquire made in the background.
+type acquired struct {
+	waited time.Duration
+	err    error
+}
+
+// acquireAsync calls q.acquire in the background, and returns once the call is queued.
+func acquireAsync(t *testing.T, ctx context.Context, q *Quota, tokens int) <-chan acquired {
+	t.Helper()
+	q.mu.Lock()
+	queued := len(q.waiting)
+	q.mu.Unlock()
+	done := make(chan acquired, 1)
+	go func() {
+		_, waited, err := q.acquire(ctx, tokens)
+		done <- acquired{waited, err}
+	}()
+	waitUntil(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.waiting) > queued
+	})
+	return done
+}
+
+// waitUntil polls cond until it holds, failing the test after a few seconds.
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
+
+// mustAcquire acquires tokens from q, which must not have to wait.
+func mustAcquire(t *testing.T, q *Quota, tokens int) *quotaCall {
+	t.Helper()
+	call, waited, err := q.acquire(context.Background(), tokens)
+	if err != nil || waited != 0 {
+		t.Fatalf("acquire(%d) waited %v, err %v; want it to start at once", tokens, waited, err)
+	}
+	return call
+}
+
+// expectWaiting checks that the call behind done hasn't started.
+func expectWaiting(t *testing.T, done <-chan acquired) {
+	t.Helper()
+	select {
+	case got := <-done:
+		t.Fatalf("the call started early, after %v (err %v)", got.waited, got.err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// expectStarted checks that the call behind done has started after waiting want.
+func expectStarted(t *testing.T, done <-chan acquired, want time.Duration) {
+	t.Helper()
+	select {
+	case got := <-done:
+		if got.err != nil || got.waited != want {
+			t.Errorf("the call waited %v, err %v; want %v", got.waited, got.err, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call didn't start")
+	}
+}
+
+func TestQuotaRequestWindow(t *testing.T) {
+	q, clock := newTestQuota(0, 2)
+	mustAcquire(t, q, 100)
+	clock.Advance(10 * time.Second)
+	mustAcquire(t, q, 100)
+
+	// The third call in the minute waits for the first to age out.
+	done := acquireAsync(t, context.Background(), q, 100)
+	clock.Advance(49 * time.Second)
+	expectWaiting(t, done)
+	clock.Advance(time.Second)
+	expectStarted(t, done, 50*time.Second)
+}
+
+func TestQuotaTokenWindow(t *testing.T) {
+	q, clock := newTestQuota(1000, 0)
+	mustAcquire(t, q, 600)
+	clock.Advance(10 * time.Second)
+	mustAcquire(t, q, 300)
+
+	// 400 more only fit once the 600 have aged out, at the minute.
+	done := acquireAsync(t, context.Background(), q, 400)
+	clock.Advance(49 * time.Second)
+	expectWaiting(t, done)
+	clock.Advance(time.Second)
+	expectStarted(t, done, 50*time.Second)
+
+	// Those 400 and the 300 still count: 400 more wait for the 300 to age out.
+	done = acquireAsync(t, context.Background(), q, 400)
+	clock.Advance(9 * time.Second)
+	expectWaiting(t, done)
+	clock.Advance(time.Second)
+	expectStarted(t, done, 10*time.Second)
+}
+
+func TestQuotaCallLargerThanWindow(t *testing.T) {
+	q, clock := newTestQuota(1000, 0)
+	mustAcquire(t, q, 5000) // Alone, it starts at once
+	done := acquireAsync(t, context.Background(), q, 5000)
+	clock.Advance(59 * time.Second)
+	expectWaiting(t, done)
+	clock.Advance(time.Second)
+	expectStarted(t, done, time.Minute)
+}
+
+func TestQuotaCorrectedUsageLetsWaitersStart(t *testing.T) {
+	q, _ := newTestQuota(1000, 0)
+	call := mustAcquire(t, q, 900) // An estimate; the call used far less
+	done := acquireAsync(t, context.Background(), q, 500)
+	expectWaiting(t, done)
+	q.correct(call, 100)
+	expectStarted(t, done, 0)
+}
+
+func TestQuotaPriority(t *testing.T) {
+	q, clock := newTestQuota(0, 1)
+	mustAcquire(t, q, 100)
+	background := acquireAsync(t, WithPriority(context.Background(), PriorityBackground), q, 100)
+	interactive := acquireAsync(t, context.Background(), q, 100)
+	later := acquireAsync(t, context.Background(), q, 100)
+
+	// The interactive calls go first, in the order they came, though the background one came first.
+	clock.Advance(time.Minute)
+	expectStarted(t, interactive, time.Minute)
+	waitUntil(t, func() bool { return clock.Timers() == 1 }) // later times its start
+	expectWaiting(t, later)
+	clock.Advance(time.Minute)
+	expectStarted(t, later, 2*time.Minute)
+	waitUntil(t, func() bool { return clock.Timers() == 1 })
+	expectWaiting(t, background)
+	clock.Advance(time.Minute)
+	expectStarted(t, background, 3*time.Minute)
+}
+
+func TestQuotaCancelWhileQueued(t *testing.T) {
+	q, clock := newTestQuota(0, 1)
+	mustAcquire(t, q, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	first := acquireAsync(t, ctx, q, 100)
+	second := acquireAsync(t, context.Background(), q, 100)
+
+	cancel()
+	select {
+	case got := <-first:
+		if !errors.Is(got.err, context.Canceled) {
+			t.Errorf("cancelled call err = %v, want context.Canceled", got.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cancelled call kept waiting")
+	}
+	// The first call's timer is left set; the second's is set once it leads the queue.
+	waitUntil(t, func() bool { return clock.Timers() == 2 })
+
+	// The call behind it takes its turn.
+	clock.Advance(time.Minute)
+	expectStarted(t, second, time.Minute)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.calls) != 1 {
+		t.Errorf("%d calls counted, want the one that started after the first aged out", len(q.calls))
+	}
+}
+
+func TestQuotaClientCountsStreamAtEnd(t *testing.T) {
+	q, _ := newTestQuota(100_000, 0)
+	client := WithQuota(&MockClient{Response: "one two three four"}, q)
+	ctx := WithMaxTokens(context.Background(), 1000)
+	stream, err := client.StreamChatCompletion(ctx, "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := func() int {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.calls[0].tokens
+	}
+	if got, want := tokens(), EstimateTokens("prompt")+1000; got != want {
+		t.Errorf("stream counted at %d tokens while open, want the estimate %d", got, want)
+	}
+	collect(stream)
+	if got, want := tokens(), EstimateTokens("prompt")+EstimateTokens("one two three four"); got != want {
+		t.Errorf("stream counted at %d tokens once read, want its usage %d", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package llmclient
+
+// Routes a Router can have clients for: the worker calls of a flight or a general question,
+// named after the request's intent, the aggregation calls of every request, and the summaries
+// of worker answers too long to aggregate.
+const (
+	RouteFlight       = "flight"
+	RouteGeneral      = "general"
+	RouteAggregation  = "aggregation"
+	RouteCondensation = "condensation"
+)
+
+// Routes lists the route names, for validation and error messages.
+var Routes = []string{RouteFlight, RouteGeneral, RouteAggregation, RouteCondensation}
+
+// Routed is a route's client, with the model it calls.
+type Routed struct {
+	Client LLMClient
+	Model  string
+}
+
+// Router chooses the client of a call by what the call is for, so cheap models can answer the
+// simple calls and stronger ones the rest. Calls without a route keep the pipeline's fixed
+// slots. Routes are added before the router is used; it is then safe for concurrent use.
+type Router struct {
+	routes map[string]Routed
+}
+
+// NewRouter returns a router without routes.
+func NewRouter() *Router {
+	return &Router{routes: make(map[string]Routed)}
+}
+
+// Route has the calls of route made by client, which calls model. A later route of the same
+// name replaces the earlier one.
+func (r *Router) Route(route, model string, client LLMClient) {
+	r.routes[route] = Routed{Client: client, Model: model}
+}
+
+// Lookup returns the client of route, or ok false if the router has none. A nil router has
+// none.
+func (r *Router) Lookup(route string) (routed Routed, ok bool) {
+	if r == nil {
+		return Routed{}, false
+	}
+	routed, ok = r.routes[route]
+	return routed, ok
+}
+
+// Models returns the model of each route, for logs.
+func (r *Router) Models() map[string]string {
+	models := make(map[string]string, len(r.routes))
+	for route, routed := range r.routes {
+		models[route] = routed.Model
+	}
+	return models
+}
@@ -0,0 +1,33 @@
+package llmclient
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	var none *Router
+	if _, ok := none.Lookup(RouteFlight); ok {
+		t.Error("a nil router has a route")
+	}
+
+	r := NewRouter()
+	cheap, strong := NewMockClient(0), NewMockClient(0)
+	r.Route(RouteFlight, "cheap-model", cheap)
+	r.Route(RouteAggregation, "cheap-model", cheap)
+	r.Route(RouteAggregation, "strong-model", strong)
+
+	if routed, ok := r.Lookup(RouteFlight); !ok || routed.Client != cheap || routed.Model != "cheap-model" {
+		t.Errorf("flight: %+v, %v", routed, ok)
+	}
+	// A later route replaces the earlier one.
+	if routed, ok := r.Lookup(RouteAggregation); !ok || routed.Client != strong || routed.Model != "strong-model" {
+		t.Errorf("aggregation: %+v, %v", routed, ok)
+	}
+	if _, ok := r.Lookup(RouteGeneral); ok {
+		t.Error("general routed without a route")
+	}
+	if got, want := r.Models(), map[string]string{RouteFlight: "cheap-model", RouteAggregation: "strong-model"}; !maps.Equal(got, want) {
+		t.Errorf("Models() = %v, want %v", got, want)
+	}
+}
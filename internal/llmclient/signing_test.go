@@ -0,0 +1,133 @@
+package llmclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignature(t *testing.T) {
+	// echo -n '1700000000.{"model":"m"}' | openssl dgst -sha256 -hmac secret
+	const want = "5c4b271017f4a96f91b0bb65d49d8a667d2f1468a98e65e2499f30cac2b70548"
+	if got := Signature([]byte("secret"), "1700000000", []byte(`{"model":"m"}`)); got != want {
+		t.Errorf("Signature = %s, want %s", got, want)
+	}
+}
+
+func TestHMACSigner(t *testing.T) {
+	body := []byte(`{"model":"m"}`)
+	req, _ := http.NewRequest(http.MethodPost, "http://gateway", nil)
+	before := time.Now().Unix()
+	if err := HMACSigner([]byte("secret"), "X-Sig", "X-Sig-Time")(req, body); err != nil {
+		t.Fatal(err)
+	}
+	ts := req.Header.Get("X-Sig-Time")
+	if sec, err := strconv.ParseInt(ts, 10, 64); err != nil || sec < before || sec > time.Now().Unix() {
+		t.Errorf("timestamp %q, want the current Unix time", ts)
+	}
+	if got, want := req.Header.Get("X-Sig"), Signature([]byte("secret"), ts, body); got != want {
+		t.Errorf("signature %q, want %q", got, want)
+	}
+}
+
+func TestHMACSignerWithoutKey(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "http://gateway", nil)
+	if err := HMACSigner(nil, DefaultSignatureHeader, DefaultTimestampHeader)(req, []byte("{}")); err == nil {
+		t.Error("signed without a key")
+	}
+}
+
+// signedRequest is what the test gateway saw of one request.
+type signedRequest struct {
+	signature, timestamp string
+	body                 []byte
+}
+
+func TestSignedBufferedAndStreamedRequests(t *testing.T) {
+	key := []byte("secret")
+	var (
+		mu   sync.Mutex
+		seen []signedRequest
+	)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		seen = append(seen, signedRequest{r.Header.Get(DefaultSignatureHeader), r.Header.Get(DefaultTimestampHeader), body})
+		mu.Unlock()
+		if strings.Contains(string(body), `"stream":true`) {
+			writeStream(w, []string{"Hello"}, true)
+			return
+		}
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"Hello"}}]}`)
+	})
+	c.WithRequestSigner(HMACSigner(key, DefaultSignatureHeader, DefaultTimestampHeader))
+
+	if _, err := c.ChatCompletion(context.Background(), "Hi"); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := c.StreamChatCompletion(context.Background(), "Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect(stream)
+
+	if len(seen) != 2 {
+		t.Fatalf("gateway saw %d requests, want 2", len(seen))
+	}
+	for i, path := range []string{"buffered", "streamed"} {
+		r := seen[i]
+		if r.timestamp == "" || r.signature != Signature(key, r.timestamp, r.body) {
+			t.Errorf("%s request signed %q at %q, want the HMAC of the body it sent", path, r.signature, r.timestamp)
+		}
+	}
+}
+
+func TestSignerErrorFailsCall(t *testing.T) {
+	key := []byte("super-secret-signing-key")
+	var sent atomic.Bool
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sent.Store(true)
+	})
+	refusal := errors.New("gateway refused the signing request")
+	c.WithRequestSigner(func(req *http.Request, body []byte) error {
+		req.Header.Set(DefaultSignatureHeader, Signature(key, "0", body)) // Half signed
+		return refusal
+	})
+
+	_, err := c.ChatCompletion(context.Background(), "Hi")
+	if !errors.Is(err, refusal) {
+		t.Fatalf("ChatCompletion err = %v, want the signer's", err)
+	}
+	_, streamErr := c.StreamChatCompletion(context.Background(), "Hi")
+	if !errors.Is(streamErr, refusal) {
+		t.Fatalf("StreamChatCompletion err = %v, want the signer's", streamErr)
+	}
+	if sent.Load() {
+		t.Error("a request that couldn't be signed was sent")
+	}
+	for _, err := range []error{err, streamErr} {
+		for _, secret := range []string{string(key), "test-key"} {
+			if strings.Contains(err.Error(), secret) {
+				t.Errorf("err %q leaks %q", err, secret)
+			}
+		}
+	}
+}
+
+func TestHMACSignerErrorDoesNotLeakKey(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a request that couldn't be signed was sent")
+	})
+	c.WithRequestSigner(HMACSigner(nil, DefaultSignatureHeader, DefaultTimestampHeader))
+	_, err := c.ChatCompletion(context.Background(), "Hi")
+	if err == nil || strings.Contains(err.Error(), "test-key") {
+		t.Errorf("err = %v, want a failure that doesn't name the API key", err)
+	}
+}
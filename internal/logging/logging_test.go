@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// capture installs a default logger writing to a buffer for the rest of the test.
+func capture(t *testing.T, level, format string) *bytes.Buffer {
+	t.Helper()
+	prev := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	var buf bytes.Buffer
+	if err := Setup(&buf, level, format); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestSetupJSONWithRequestID(t *testing.T) {
+	buf := capture(t, "debug", "json")
+	ctx := WithRequestID(context.Background(), "req-1")
+	slog.DebugContext(ctx, "LLM call", "slot", "llm1")
+	slog.InfoContext(context.Background(), "Started")
+	log.Print("from the log package")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("%d lines, want 3:\n%s", len(lines), buf)
+	}
+	var records [3]map[string]any
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &records[i]); err != nil {
+			t.Fatalf("line %d isn't JSON: %s", i, line)
+		}
+	}
+	if records[0]["request_id"] != "req-1" || records[0]["level"] != "DEBUG" || records[0]["slot"] != "llm1" {
+		t.Errorf("record %v, want the request ID and the attributes", records[0])
+	}
+	if _, ok := records[1]["request_id"]; ok {
+		t.Errorf("record %v has a request ID without one in its context", records[1])
+	}
+	if records[2]["msg"] != "from the log package" {
+		t.Errorf("record %v, want the log package routed through slog", records[2])
+	}
+}
+
+func TestSetupLevelAndFormat(t *testing.T) {
+	buf := capture(t, "WARN", "")
+	logger := slog.Default().With("component", "db")
+	logger.InfoContext(context.Background(), "dropped")
+	logger.WarnContext(WithRequestID(context.Background(), "req-2"), "kept")
+	out := buf.String()
+	if strings.Contains(out, "dropped") || !strings.Contains(out, "msg=kept") ||
+		!strings.Contains(out, "component=db") || !strings.Contains(out, "request_id=req-2") {
+		t.Errorf("text output %q, want only the warning, with its attributes and request ID", out)
+	}
+
+	for _, tt := range []struct{ level, format string }{{"verbose", "text"}, {"info", "xml"}} {
+		if err := Setup(&bytes.Buffer{}, tt.level, tt.format); err == nil {
+			t.Errorf("Setup(%q, %q) accepted", tt.level, tt.format)
+		}
+	}
+}
+
+func TestRequestIDContext(t *testing.T) {
+	if id := RequestID(context.Background()); id != "" {
+		t.Errorf("RequestID of a bare context = %q", id)
+	}
+	if id := RequestID(WithRequestID(context.Background(), "abc")); id != "abc" {
+		t.Errorf("RequestID = %q, want abc", id)
+	}
+	a, b := NewRequestID(), NewRequestID()
+	if len(a) != 32 || a == b || !ValidRequestID(a) {
+		t.Errorf("NewRequestID gave %q and %q", a, b)
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	for _, tt := range []struct {
+		id   string
+		want bool
+	}{
+		{"abc-123_x.y:z", true},
+		{strings.Repeat("a", maxRequestIDLen), true},
+		{"", false},
+		{strings.Repeat("a", maxRequestIDLen+1), false},
+		{"a b", false},
+		{"a\nb", false},
+		{"a\"b", false},
+		{"é", false},
+	} {
+		if got := ValidRequestID(tt.id); got != tt.want {
+			t.Errorf("ValidRequestID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestRequestPath(t *testing.T) {
+	var got string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/share/{token}", func(w http.ResponseWriter, r *http.Request) { got = RequestPath(r) })
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) { got = RequestPath(r) })
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/share/s3cret", nil))
+	if got != "/share/[redacted]" {
+		t.Errorf("share path logged as %q", got)
+	}
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api", nil))
+	if got != "/api" {
+		t.Errorf("path logged as %q", got)
+	}
+}
@@ -0,0 +1,280 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+)
+
+// instrumentedDB records the latency and errors of the wrapped client's data operations
+// and logs each one (at debug level, or warn when it fails) with the request's ID.
+// Connection management and seeding pass straight through via the embedded Client.
+type instrumentedDB struct {
+	db.Client
+}
+
+// InstrumentDB wraps client so its operations are measured. Wrap the raw backend (below any
+// cache) so the durations reflect real database round trips. The wrapper does not implement
+// optional interfaces such as db.Watcher; check for those on client itself.
+func InstrumentDB(client db.Client) db.Client {
+	return &instrumentedDB{Client: client}
+}
+
+// observe records one operation; use it as `defer observe(ctx, "op", time.Now(), &err)`.
+func observe(ctx context.Context, operation string, start time.Time, err *error) {
+	elapsed := time.Since(start)
+	DBDuration.WithLabelValues(operation).Observe(elapsed.Seconds())
+	if *err != nil {
+		Errors.WithLabelValues("db", dbErrorType(*err)).Inc()
+		slog.WarnContext(ctx, "Database operation failed", "operation", operation, "duration", elapsed, "error", *err)
+		return
+	}
+	slog.DebugContext(ctx, "Database operation", "operation", operation, "duration", elapsed)
+}
+
+// dbErrorType classifies a db error by its kind for the errors metric.
+func dbErrorType(err error) string {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, db.ErrConflict):
+		return "conflict"
+	case errors.Is(err, db.ErrUnavailable):
+		return "unavailable"
+	default:
+		return "error"
+	}
+}
+
+func (c *instrumentedDB) InsertFlights(ctx context.Context, flights []db.Flight) (err error) {
+	defer observe(ctx, "insert_flights", time.Now(), &err)
+	return c.Client.InsertFlights(ctx, flights)
+}
+
+func (c *instrumentedDB) UpsertFlights(ctx context.Context, flights []db.Flight) (_ db.UpsertResult, err error) {
+	defer observe(ctx, "upsert_flights", time.Now(), &err)
+	return c.Client.UpsertFlights(ctx, flights)
+}
+
+func (c *instrumentedDB) CreateFlight(ctx context.Context, flight db.Flight) (err error) {
+	defer observe(ctx, "create_flight", time.Now(), &err)
+	return c.Client.CreateFlight(ctx, flight)
+}
+
+func (c *instrumentedDB) UpdateFlight(ctx context.Context, flight db.Flight) (err error) {
+	defer observe(ctx, "update_flight", time.Now(), &err)
+	return c.Client.UpdateFlight(ctx, flight)
+}
+
+func (c *instrumentedDB) DeleteFlight(ctx context.Context, flightNumber string) (err error) {
+	defer observe(ctx, "delete_flight", time.Now(), &err)
+	return c.Client.DeleteFlight(ctx, flightNumber)
+}
+
+func (c *instrumentedDB) GetFlight(ctx context.Context, flightNumber string) (_ db.Flight, err error) {
+	defer observe(ctx, "get_flight", time.Now(), &err)
+	return c.Client.GetFlight(ctx, flightNumber)
+}
+
+// SearchFlights is routed through QueryFlights so both are measured as "query_flights".
+func (c *instrumentedDB) SearchFlights(ctx context.Context, origin, destination string, maxPrice float64) ([]db.Flight, error) {
+	return c.QueryFlights(ctx, db.FlightQuery{Origin: origin, Destination: destination, MaxPrice: maxPrice})
+}
+
+func (c *instrumentedDB) QueryFlights(ctx context.Context, q db.FlightQuery) (_ []db.Flight, err error) {
+	defer observe(ctx, "query_flights", time.Now(), &err)
+	return c.Client.QueryFlights(ctx, q)
+}
+
+func (c *instrumentedDB) ListRoutes(ctx context.Context) (_ []db.Route, err error) {
+	defer observe(ctx, "list_routes", time.Now(), &err)
+	return c.Client.ListRoutes(ctx)
+}
+
+func (c *instrumentedDB) ListAirports(ctx context.Context) (_ []db.Airport, err error) {
+	defer observe(ctx, "list_airports", time.Now(), &err)
+	return c.Client.ListAirports(ctx)
+}
+
+func (c *instrumentedDB) UpsertSchedule(ctx context.Context, schedule db.FlightSchedule) (err error) {
+	defer observe(ctx, "upsert_schedule", time.Now(), &err)
+	return c.Client.UpsertSchedule(ctx, schedule)
+}
+
+func (c *instrumentedDB) GetSchedule(ctx context.Context, flightNumber string) (_ db.FlightSchedule, err error) {
+	defer observe(ctx, "get_schedule", time.Now(), &err)
+	return c.Client.GetSchedule(ctx, flightNumber)
+}
+
+func (c *instrumentedDB) ListSchedules(ctx context.Context) (_ []db.FlightSchedule, err error) {
+	defer observe(ctx, "list_schedules", time.Now(), &err)
+	return c.Client.ListSchedules(ctx)
+}
+
+func (c *instrumentedDB) DeleteSchedule(ctx context.Context, flightNumber string) (err error) {
+	defer observe(ctx, "delete_schedule", time.Now(), &err)
+	return c.Client.DeleteSchedule(ctx, flightNumber)
+}
+
+func (c *instrumentedDB) InsertQueryLog(ctx context.Context, entry db.QueryLog) (err error) {
+	defer observe(ctx, "insert_query_log", time.Now(), &err)
+	return c.Client.InsertQueryLog(ctx, entry)
+}
+
+func (c *instrumentedDB) GetQueryLog(ctx context.Context, requestID string) (_ db.QueryLog, err error) {
+	defer observe(ctx, "get_query_log", time.Now(), &err)
+	return c.Client.GetQueryLog(ctx, requestID)
+}
+
+func (c *instrumentedDB) GetQueryStats(ctx context.Context, since time.Time) (_ db.QueryStats, err error) {
+	defer observe(ctx, "get_query_stats", time.Now(), &err)
+	return c.Client.GetQueryStats(ctx, since)
+}
+
+func (c *instrumentedDB) AppendTurns(ctx context.Context, sessionID, client string, turns ...db.Turn) (err error) {
+	defer observe(ctx, "append_turns", time.Now(), &err)
+	return c.Client.AppendTurns(ctx, sessionID, client, turns...)
+}
+
+func (c *instrumentedDB) GetConversation(ctx context.Context, sessionID string) (_ db.Conversation, err error) {
+	defer observe(ctx, "get_conversation", time.Now(), &err)
+	return c.Client.GetConversation(ctx, sessionID)
+}
+
+func (c *instrumentedDB) SetConversationTitle(ctx context.Context, sessionID, client, title string, overwrite bool) (err error) {
+	defer observe(ctx, "set_conversation_title", time.Now(), &err)
+	return c.Client.SetConversationTitle(ctx, sessionID, client, title, overwrite)
+}
+
+func (c *instrumentedDB) ListConversations(ctx context.Context, client string, limit int) (_ []db.ConversationSummary, err error) {
+	defer observe(ctx, "list_conversations", time.Now(), &err)
+	return c.Client.ListConversations(ctx, client, limit)
+}
+
+func (c *instrumentedDB) ShareConversation(ctx context.Context, sessionID, client string, share db.ConversationShare) (err error) {
+	defer observe(ctx, "share_conversation", time.Now(), &err)
+	return c.Client.ShareConversation(ctx, sessionID, client, share)
+}
+
+func (c *instrumentedDB) UnshareConversation(ctx context.Context, sessionID, client string) (err error) {
+	defer observe(ctx, "unshare_conversation", time.Now(), &err)
+	return c.Client.UnshareConversation(ctx, sessionID, client)
+}
+
+func (c *instrumentedDB) GetSharedConversation(ctx context.Context, tokenHash string, now time.Time) (_ db.Conversation, err error) {
+	defer observe(ctx, "get_shared_conversation", time.Now(), &err)
+	return c.Client.GetSharedConversation(ctx, tokenHash, now)
+}
+
+func (c *instrumentedDB) IncrementUsage(ctx context.Context, delta db.UsageDelta) (err error) {
+	defer observe(ctx, "increment_usage", time.Now(), &err)
+	return c.Client.IncrementUsage(ctx, delta)
+}
+
+func (c *instrumentedDB) ListUsage(ctx context.Context, q db.UsageQuery) (_ []db.Usage, err error) {
+	defer observe(ctx, "list_usage", time.Now(), &err)
+	return c.Client.ListUsage(ctx, q)
+}
+
+func (c *instrumentedDB) SaveJob(ctx context.Context, job db.Job) (err error) {
+	defer observe(ctx, "save_job", time.Now(), &err)
+	return c.Client.SaveJob(ctx, job)
+}
+
+func (c *instrumentedDB) GetJob(ctx context.Context, id string) (_ db.Job, err error) {
+	defer observe(ctx, "get_job", time.Now(), &err)
+	return c.Client.GetJob(ctx, id)
+}
+
+func (c *instrumentedDB) CreateIdempotencyKey(ctx context.Context, record db.IdempotencyRecord) (err error) {
+	defer observe(ctx, "create_idempotency_key", time.Now(), &err)
+	return c.Client.CreateIdempotencyKey(ctx, record)
+}
+
+func (c *instrumentedDB) GetIdempotencyKey(ctx context.Context, id string) (_ db.IdempotencyRecord, err error) {
+	defer observe(ctx, "get_idempotency_key", time.Now(), &err)
+	return c.Client.GetIdempotencyKey(ctx, id)
+}
+
+func (c *instrumentedDB) SaveIdempotencyKey(ctx context.Context, record db.IdempotencyRecord) (err error) {
+	defer observe(ctx, "save_idempotency_key", time.Now(), &err)
+	return c.Client.SaveIdempotencyKey(ctx, record)
+}
+
+func (c *instrumentedDB) DeleteIdempotencyKey(ctx context.Context, id string) (err error) {
+	defer observe(ctx, "delete_idempotency_key", time.Now(), &err)
+	return c.Client.DeleteIdempotencyKey(ctx, id)
+}
+
+func (c *instrumentedDB) AppendStreamEvents(ctx context.Context, id, sessionID string, events []db.StoredEvent, done bool, expiresAt time.Time) (err error) {
+	defer observe(ctx, "append_stream_events", time.Now(), &err)
+	return c.Client.AppendStreamEvents(ctx, id, sessionID, events, done, expiresAt)
+}
+
+func (c *instrumentedDB) GetStream(ctx context.Context, id string) (_ db.SharedStream, err error) {
+	defer observe(ctx, "get_stream", time.Now(), &err)
+	return c.Client.GetStream(ctx, id)
+}
+
+func (c *instrumentedDB) ListFlags(ctx context.Context) (_ []db.Flag, err error) {
+	defer observe(ctx, "list_flags", time.Now(), &err)
+	return c.Client.ListFlags(ctx)
+}
+
+func (c *instrumentedDB) SaveFlag(ctx context.Context, flag db.Flag) (err error) {
+	defer observe(ctx, "save_flag", time.Now(), &err)
+	return c.Client.SaveFlag(ctx, flag)
+}
+
+func (c *instrumentedDB) DeleteFlag(ctx context.Context, name string) (err error) {
+	defer observe(ctx, "delete_flag", time.Now(), &err)
+	return c.Client.DeleteFlag(ctx, name)
+}
+
+func (c *instrumentedDB) GetPreferences(ctx context.Context, sessionID string) (_ db.Preferences, err error) {
+	defer observe(ctx, "get_preferences", time.Now(), &err)
+	return c.Client.GetPreferences(ctx, sessionID)
+}
+
+func (c *instrumentedDB) SavePreferences(ctx context.Context, prefs db.Preferences) (err error) {
+	defer observe(ctx, "save_preferences", time.Now(), &err)
+	return c.Client.SavePreferences(ctx, prefs)
+}
+
+func (c *instrumentedDB) DeleteSessionData(ctx context.Context, sessionID string, dryRun bool) (_ db.RecordCounts, err error) {
+	defer observe(ctx, "delete_session_data", time.Now(), &err)
+	return c.Client.DeleteSessionData(ctx, sessionID, dryRun)
+}
+
+func (c *instrumentedDB) PurgeExpired(ctx context.Context, r db.Retention, now time.Time) (_ db.RecordCounts, err error) {
+	defer observe(ctx, "purge_expired", time.Now(), &err)
+	return c.Client.PurgeExpired(ctx, r, now)
+}
+
+func (c *instrumentedDB) RecordRejection(ctx context.Context, rejection db.Rejection, since time.Time) (_ int, err error) {
+	defer observe(ctx, "record_rejection", time.Now(), &err)
+	return c.Client.RecordRejection(ctx, rejection, since)
+}
+
+func (c *instrumentedDB) SaveBan(ctx context.Context, ban db.Ban) (err error) {
+	defer observe(ctx, "save_ban", time.Now(), &err)
+	return c.Client.SaveBan(ctx, ban)
+}
+
+func (c *instrumentedDB) GetBan(ctx context.Context, client string, now time.Time) (_ db.Ban, err error) {
+	defer observe(ctx, "get_ban", time.Now(), &err)
+	return c.Client.GetBan(ctx, client, now)
+}
+
+func (c *instrumentedDB) ListBans(ctx context.Context, activeAt time.Time) (_ []db.Ban, err error) {
+	defer observe(ctx, "list_bans", time.Now(), &err)
+	return c.Client.ListBans(ctx, activeAt)
+}
+
+func (c *instrumentedDB) LiftBan(ctx context.Context, client, by string, at time.Time) (_ db.Ban, err error) {
+	defer observe(ctx, "lift_ban", time.Now(), &err)
+	return c.Client.LiftBan(ctx, client, by, at)
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+)
+
+// instrumentedLLM records latency and errors of every call to the wrapped client,
+// and logs each call (at debug level, or warn when it fails) with the request's ID.
+type instrumentedLLM struct {
+	next        llmclient.LLMClient
+	slot, model string
+}
+
+// InstrumentLLM wraps client so its calls are measured under the given pipeline slot
+// ("llm1", "llm2", "llm3") and model labels.
+func InstrumentLLM(client llmclient.LLMClient, slot, model string) llmclient.LLMClient {
+	return &instrumentedLLM{next: client, slot: slot, model: model}
+}
+
+func (c *instrumentedLLM) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	start := time.Now()
+	resp, err := c.next.ChatCompletion(ctx, prompt)
+	c.observe(ctx, "chat", start, err)
+	return resp, err
+}
+
+// StreamChatCompletion measures the time until the stream is available, not until it is drained.
+func (c *instrumentedLLM) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	start := time.Now()
+	stream, err := c.next.StreamChatCompletion(ctx, prompt)
+	c.observe(ctx, "stream", start, err)
+	return stream, err
+}
+
+func (c *instrumentedLLM) observe(ctx context.Context, method string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	LLMDuration.WithLabelValues(c.slot, c.model, method).Observe(elapsed.Seconds())
+	if err != nil {
+		Errors.WithLabelValues("llm", llmErrorType(err)).Inc()
+		slog.WarnContext(ctx, "LLM call failed", "slot", c.slot, "model", c.model, "method", method, "duration", elapsed, "error", err)
+		return
+	}
+	slog.DebugContext(ctx, "LLM call", "slot", c.slot, "model", c.model, "method", method, "duration", elapsed)
+}
+
+// llmErrorType classifies an LLM error for the errors metric.
+func llmErrorType(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	default:
+		return "error"
+	}
+}
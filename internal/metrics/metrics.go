@@ -0,0 +1,230 @@
+// Package metrics defines the server's Prometheus metrics and the decorators that record them.
+//
+// Metric names and label sets are part of the operational interface (dashboards and alerts
+// depend on them), so treat renames as breaking changes. Every series is prefixed "chat_".
+//
+//	chat_http_requests_total{route,method,code}          HTTP requests by route and status code
+//	chat_sse_streams_in_flight                           SSE connections currently open
+//	chat_sse_events_total{event_type}                    Events published to streams
+//	chat_sse_stream_duration_seconds{end_reason}         How long SSE connections stayed open, by why they ended
+//	chat_sse_stream_events                               Events written per SSE connection
+//	chat_requests_total{intent,outcome}                  Orchestrated requests by intent and Done outcome
+//	chat_request_duration_seconds{intent}                End-to-end orchestration time
+//	chat_llm_request_duration_seconds{slot,model,method} Latency of each LLM call
+//	chat_llm_tokens_total{model,kind}                    Tokens used; kind is "prompt" or "completion"
+//	chat_db_operation_duration_seconds{operation}        Latency of each database call
+//	chat_search_cache_hits_total / _misses_total         Flight search cache lookups
+//	chat_search_cache_stale_total                        Misses answered with an expired entry while the database was slow
+//	chat_errors_total{component,type}                    Errors; component is "llm" or "db"
+//	chat_rate_limited_total{reason}                      Requests rejected by rate limits, quotas or bans; reason is "rate", "streams", "quota", "capacity" or "banned"
+//	chat_rate_limit_queued_total                         Requests that waited for a stream slot
+//	chat_orchestrations_running                          Orchestrations holding one of the server's MAX_CONCURRENT_CHATS slots
+//	chat_orchestrations_queued                           Requests waiting for one of those slots
+//	chat_callback_deliveries_total{event,result}         Job callbacks; result is "delivered" or "failed"
+//	chat_grounding_score                                 Share of a flight answer's facts found in its records
+//	chat_grounding_mismatches_total{kind}                Facts stated in answers but missing from their records
+//	chat_build_info{version,commit,go_version}           Always 1; identifies the running build
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+)
+
+// Registry holds every metric in this package plus the Go runtime and process collectors.
+// A dedicated registry (rather than the global default) keeps /metrics limited to what we define.
+var Registry = prometheus.NewRegistry()
+
+// llmBuckets cover LLM calls, which take from a fraction of a second to about a minute.
+var llmBuckets = []float64{0.25, 0.5, 1, 2, 4, 8, 15, 30, 60}
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	SSEStreamsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_sse_streams_in_flight",
+		Help: "SSE connections currently being served.",
+	})
+
+	SSEEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_sse_events_total",
+		Help: "Events published to SSE streams by event type.",
+	}, []string{"event_type"})
+
+	SSEStreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chat_sse_stream_duration_seconds",
+		Help:    "How long SSE connections stayed open, by end reason (done, client_disconnected, shutdown, too_far_behind, write_failed, flush_failed).",
+		Buckets: []float64{0.5, 1, 2, 5, 10, 30, 60, 120, 300},
+	}, []string{"end_reason"})
+
+	SSEStreamEvents = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_sse_stream_events",
+		Help:    "Events written per SSE connection.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_requests_total",
+		Help: "Orchestrated chat requests by intent and outcome.",
+	}, []string{"intent", "outcome"})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chat_request_duration_seconds",
+		Help:    "End-to-end orchestration time of chat requests.",
+		Buckets: llmBuckets,
+	}, []string{"intent"})
+
+	LLMDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chat_llm_request_duration_seconds",
+		Help:    "Latency of LLM calls by pipeline slot, model and method.",
+		Buckets: llmBuckets,
+	}, []string{"slot", "model", "method"})
+
+	LLMTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_llm_tokens_total",
+		Help: "LLM tokens used by model and kind (prompt or completion).",
+	}, []string{"model", "kind"})
+
+	DBDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chat_db_operation_duration_seconds",
+		Help:    "Latency of database operations.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_errors_total",
+		Help: "Errors by component and type.",
+	}, []string{"component", "type"})
+
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_rate_limited_total",
+		Help: "Requests rejected by per-client rate limits, quotas and bans, or by the server's concurrency limit.",
+	}, []string{"reason"})
+
+	RateLimitQueued = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_rate_limit_queued_total",
+		Help: "Requests that waited in a per-client queue for a stream slot.",
+	})
+
+	CallbackDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_callback_deliveries_total",
+		Help: "Callbacks of asynchronous requests by event and result, after retries.",
+	}, []string{"event", "result"})
+
+	GroundingScore = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_grounding_score",
+		Help:    "Share of the prices, times and flight numbers in flight answers found in their flight records.",
+		Buckets: []float64{0.5, 0.75, 0.9, 0.99, 1},
+	})
+
+	GroundingMismatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_grounding_mismatches_total",
+		Help: "Facts stated in flight answers but missing from their flight records, by kind.",
+	}, []string{"kind"})
+
+	LanguageRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_language_retries_total",
+		Help: "Aggregated answers asked for again because they were in another language than the request's, by result (fixed, failed).",
+	}, []string{"result"})
+
+	CondensedAnswers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_condensed_answers_total",
+		Help: "Worker answers condensed before aggregation because they were too long, by method (extract, summary).",
+	}, []string{"method"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, SSEStreamsInFlight, SSEEvents, SSEStreamDuration, SSEStreamEvents, Requests, RequestDuration,
+		LLMDuration, LLMTokens, DBDuration, Errors, RateLimited, RateLimitQueued,
+		CallbackDeliveries, GroundingScore, GroundingMismatches, LanguageRetries,
+		CondensedAnswers,
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// RegisterBuildInfo exports the running build as the labels of chat_build_info.
+func RegisterBuildInfo(version, commit, goVersion string) {
+	buildInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "chat_build_info",
+		Help: "Build of the running server; the value is always 1.",
+	}, []string{"version", "commit", "go_version"})
+	buildInfo.WithLabelValues(version, commit, goVersion).Set(1)
+	Registry.MustRegister(buildInfo)
+}
+
+// RegisterCache exports a search cache's counters. stats is read at scrape time.
+func RegisterCache(stats func() db.CacheStats) {
+	Registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "chat_search_cache_hits_total",
+			Help: "Flight searches served from the cache.",
+		}, func() float64 { return float64(stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "chat_search_cache_misses_total",
+			Help: "Flight searches that missed the cache.",
+		}, func() float64 { return float64(stats().Misses) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "chat_search_cache_stale_total",
+			Help: "Flight searches that missed the cache and were answered with an expired entry because the database was slow.",
+		}, func() float64 { return float64(stats().Stale) }),
+	)
+}
+
+// RegisterChatLoad exports the server's concurrency limit: the orchestrations running under
+// it and the requests queued for it. load is read at scrape time.
+func RegisterChatLoad(load func() (running, queued int)) {
+	Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "chat_orchestrations_running",
+			Help: "Orchestrations holding one of the server's concurrency slots.",
+		}, func() float64 { running, _ := load(); return float64(running) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "chat_orchestrations_queued",
+			Help: "Requests waiting for one of the server's concurrency slots.",
+		}, func() float64 { _, queued := load(); return float64(queued) }),
+	)
+}
+
+// RecordRequest records one orchestrated request; it is meant to be used as an orchestrator telemetry hook.
+func RecordRequest(intent, outcome string, durationMs int64) {
+	Requests.WithLabelValues(intent, outcome).Inc()
+	RequestDuration.WithLabelValues(intent).Observe(float64(durationMs) / 1000)
+}
+
+// RecordStreamClosed records one SSE connection once its write loop has ended; it is meant to
+// be used as the sse.Handler's OnClose hook.
+func RecordStreamClosed(endReason string, duration time.Duration, events int) {
+	SSEStreamDuration.WithLabelValues(endReason).Observe(duration.Seconds())
+	SSEStreamEvents.Observe(float64(events))
+}
+
+// RecordGrounding records the grounding check of one flight answer: its score, and the kind of
+// each claim that didn't match.
+func RecordGrounding(score float64, mismatchKinds []string) {
+	GroundingScore.Observe(score)
+	for _, kind := range mismatchKinds {
+		GroundingMismatches.WithLabelValues(kind).Inc()
+	}
+}
+
+// RecordTokens adds one completion's token usage.
+func RecordTokens(model string, promptTokens, completionTokens int) {
+	LLMTokens.WithLabelValues(model, "prompt").Add(float64(promptTokens))
+	LLMTokens.WithLabelValues(model, "completion").Add(float64(completionTokens))
+}
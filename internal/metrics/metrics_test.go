@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+)
+
+// scrape returns what /metrics serves now.
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/metrics answered %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+// series returns the value of the series name with the given labels ("key=value") in a
+// scrape, and whether it is there.
+func series(scraped, name string, labels ...string) (string, bool) {
+	for _, line := range strings.Split(scraped, "\n") {
+		series, value, ok := strings.Cut(line, " ")
+		if !ok || (series != name && !strings.HasPrefix(series, name+"{")) {
+			continue
+		}
+		matched := true
+		for _, label := range labels {
+			key, want, _ := strings.Cut(label, "=")
+			if !strings.Contains(series, key+`="`+want+`"`) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// checkSeries fails the test unless the scrape has the series name with labels.
+func checkSeries(t *testing.T, scraped, name string, labels ...string) {
+	t.Helper()
+	if _, ok := series(scraped, name, labels...); !ok {
+		t.Errorf("no %s%v in the scrape", name, labels)
+	}
+}
+
+// failingLLM fails every call with err.
+type failingLLM struct{ err error }
+
+func (c failingLLM) ChatCompletion(context.Context, string) (string, error) { return "", c.err }
+
+func (c failingLLM) StreamChatCompletion(context.Context, string) (<-chan string, error) {
+	return nil, c.err
+}
+
+func TestInstrumentLLM(t *testing.T) {
+	ctx := context.Background()
+	ok := InstrumentLLM(&llmclient.MockClient{Response: "hi"}, "llm1", "test-model")
+	if resp, err := ok.ChatCompletion(ctx, "hello"); err != nil || resp != "hi" {
+		t.Fatalf("ChatCompletion = %q, %v", resp, err)
+	}
+	stream, err := ok.StreamChatCompletion(ctx, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range stream {
+	}
+	InstrumentLLM(failingLLM{context.DeadlineExceeded}, "llm2", "test-model").ChatCompletion(ctx, "hello")
+	InstrumentLLM(failingLLM{errors.New("bad request")}, "llm3", "test-model").ChatCompletion(ctx, "hello")
+
+	scraped := scrape(t)
+	checkSeries(t, scraped, "chat_llm_request_duration_seconds_count", "slot=llm1", "model=test-model", "method=chat")
+	checkSeries(t, scraped, "chat_llm_request_duration_seconds_count", "slot=llm1", "model=test-model", "method=stream")
+	checkSeries(t, scraped, "chat_llm_request_duration_seconds_count", "slot=llm2", "method=chat")
+	checkSeries(t, scraped, "chat_errors_total", "component=llm", "type=timeout")
+	checkSeries(t, scraped, "chat_errors_total", "component=llm", "type=error")
+}
+
+func TestInstrumentDB(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryClient()
+	instrumented := InstrumentDB(store)
+	if err := instrumented.InsertFlights(ctx, []db.Flight{{FlightNumber: "IB101", Origin: "Madrid", Destination: "Paris",
+		DepartureTime: "2026-03-01T08:00:00Z", ArrivalTime: "2026-03-01T10:00:00Z", Price: 100, AvailableSeats: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := instrumented.QueryFlights(ctx, db.FlightQuery{Origin: "Madrid"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := instrumented.GetFlight(ctx, "XX999"); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("GetFlight(missing) err = %v", err)
+	}
+
+	scraped := scrape(t)
+	checkSeries(t, scraped, "chat_db_operation_duration_seconds_count", "operation=insert_flights")
+	checkSeries(t, scraped, "chat_db_operation_duration_seconds_count", "operation=query_flights")
+	checkSeries(t, scraped, "chat_errors_total", "component=db", "type=not_found")
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	handler := InstrumentHandler("/api/test/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "missing" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "ok") // No explicit WriteHeader: 200
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/test/{id}", handler)
+	for _, path := range []string{"/api/test/1", "/api/test/2", "/api/test/missing"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", path, nil))
+	}
+
+	scraped := scrape(t)
+	if value, _ := series(scraped, "chat_http_requests_total", "route=/api/test/{id}", "method=POST", "code=200"); value != "2" {
+		t.Errorf("200 responses = %q, want 2 under the route pattern", value)
+	}
+	checkSeries(t, scraped, "chat_http_requests_total", "route=/api/test/{id}", "code=404")
+	if strings.Contains(scraped, `route="/api/test/1"`) {
+		t.Error("the raw path is a label value")
+	}
+}
+
+func TestRecordRequest(t *testing.T) {
+	RecordRequest("flight", "ok", 1500)
+	RecordTokens("test-model", 120, 30)
+	scraped := scrape(t)
+	checkSeries(t, scraped, "chat_requests_total", "intent=flight", "outcome=ok")
+	checkSeries(t, scraped, "chat_request_duration_seconds_bucket", "intent=flight", "le=2")
+	if value, _ := series(scraped, "chat_llm_tokens_total", "model=test-model", "kind=completion"); value != "30" {
+		t.Errorf("completion tokens = %q, want 30", value)
+	}
+	checkSeries(t, scraped, "go_goroutines")
+}
+
+func TestRecordStreamClosed(t *testing.T) {
+	RecordStreamClosed("client_disconnected", 3*time.Second, 12)
+	RecordStreamClosed("client_disconnected", 40*time.Second, 3)
+	scraped := scrape(t)
+	if value, _ := series(scraped, "chat_sse_stream_duration_seconds_count", "end_reason=client_disconnected"); value != "2" {
+		t.Errorf("client_disconnected streams = %q, want 2", value)
+	}
+	if value, _ := series(scraped, "chat_sse_stream_duration_seconds_bucket", "end_reason=client_disconnected", "le=5"); value != "1" {
+		t.Errorf("streams under 5s = %q, want 1", value)
+	}
+	if value, _ := series(scraped, "chat_sse_stream_events_bucket", "le=4"); value != "1" {
+		t.Errorf("streams of at most 4 events = %q, want 1", value)
+	}
+}
+
+func TestRegisterBuildInfo(t *testing.T) {
+	RegisterBuildInfo("v1.2.0", "abc1234", "go1.23.6")
+	if value, _ := series(scrape(t), "chat_build_info", "version=v1.2.0", "commit=abc1234", "go_version=go1.23.6"); value != "1" {
+		t.Errorf("chat_build_info = %q, want 1 with the build's labels", value)
+	}
+}
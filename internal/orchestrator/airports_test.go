@@ -0,0 +1,59 @@
+package orchestrator
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestAirportSearch(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		for _, tt := range []struct {
+			message          string
+			want             []string
+			origin, airports string // The telemetry's origin and airports, "origin>destination"
+		}{
+			// A city searches every airport it has; an airport code only that one.
+			{"Flights from New York", []string{"FL108", "FL120"}, "New York", ">"},
+			{"Flights from JFK", []string{"FL120"}, "New York", "JFK>"},
+			{"vuelos desde nueva york a londres", []string{"FL108"}, "New York", ">"},
+			{"Flights from LHR to New York", []string{"FL107"}, "London", "LHR>"},
+			{"Flights from LGW to New York", nil, "London", "LGW>"},
+			{"Flights from London to LGW", nil, "London", ">LGW"},
+			{"Flights from EWR to LGW", []string{"FL108"}, "New York", "EWR>LGW"},
+		} {
+			o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+			events := process(t, o.Orchestrator, tt.message, Options{}, stream)
+			if got := flightNumbers(events); !slices.Equal(got, tt.want) {
+				t.Errorf("stream %v: %q found %v, want %v", stream, tt.message, got, tt.want)
+			}
+			telemetry := telemetryOf(t, events)
+			if got := telemetry.OriginAirport + ">" + telemetry.DestinationAirport; telemetry.Origin != tt.origin || got != tt.airports {
+				t.Errorf("stream %v: %q understood from %s, airports %s", stream, tt.message, telemetry.Origin, got)
+			}
+			understood := ofType(events, sse.TypeQueryUnderstanding)
+			if len(understood) != 1 || understood[0].Payload.(sse.QueryUnderstandingPayload).OriginAirport != telemetry.OriginAirport {
+				t.Errorf("stream %v: %q: understanding %+v", stream, tt.message, understood)
+			}
+		}
+	}
+}
+
+func TestAirportNamed(t *testing.T) {
+	// The flights given to the LLMs name the airport next to the city.
+	o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+	process(t, o.Orchestrator, "Flights from New York", Options{}, true)
+	prompt := o.llm1.Prompts()[0]
+	for _, want := range []string{"FL120: New York (JFK) -> Tokyo (HND)", "FL108: New York (EWR) -> London (LGW)"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("LLM 1 prompt doesn't contain %q:\n%s", want, prompt)
+		}
+	}
+	// A city whose flights don't say the airport is named alone.
+	process(t, o.Orchestrator, "Flights from Madrid to Paris", Options{}, true)
+	if prompt := o.llm1.Prompts()[1]; !strings.Contains(prompt, "FL101: Madrid -> Paris,") {
+		t.Errorf("LLM 1 prompt:\n%s", prompt)
+	}
+}
@@ -0,0 +1,133 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+const (
+	// languageSample is how much of a streamed answer is held back to check its language.
+	languageSample = 200
+
+	// answerLanguageConfidence is the i18n.DetectConfidence confidence from which an answer is
+	// taken to be in the language detected. It is lower than for user messages: answers are
+	// long, and a fifth of a text's words being common words of a language is typical.
+	answerLanguageConfidence = 0.1
+)
+
+// Outcomes of the language check, as recorded in db.QueryLog.LanguageRetry.
+const (
+	languageRetryFixed  = "fixed"  // The retry answered in the request's language
+	languageRetryFailed = "failed" // The retry failed or was in another language too; the first answer was sent
+)
+
+// languageOnly is the instruction added to the aggregation prompt of a retry, by language.
+var languageOnly = map[string]string{
+	LanguageEnglish: "IMPORTANT: Your previous answer was not in English. Respond only in English.",
+	LanguageSpanish: "IMPORTANTE: Tu respuesta anterior no estaba en español. Responde únicamente en español.",
+}
+
+// EnableLanguageCheck checks the language of LLM 3's answers: an aggregated answer that is
+// confidently in another language than the request's (see i18n.DetectConfidence) is asked for
+// once more, without streaming, with an instruction to answer only in the request's language.
+// The retry's answer is sent if it is in the right language, the first one otherwise; either
+// way the request's telemetry says so (Telemetry.LanguageRetry). A streamed answer is checked
+// on its first 200 characters, which are held back until then. It must be called before the
+// orchestrator serves requests.
+func (o *Orchestrator) EnableLanguageCheck() {
+	o.languageCheck = true
+}
+
+// offLanguage returns the language answer is in, and whether that is confidently another
+// language than the request's.
+func offLanguage(entry *db.QueryLog, answer string) (language string, off bool) {
+	code, confidence := i18n.DetectConfidence(answer)
+	if code == languageCodes[entry.DetectedLanguage] || confidence < answerLanguageConfidence {
+		return entry.DetectedLanguage, false
+	}
+	for name, c := range languageCodes {
+		if c == code {
+			language = name
+		}
+	}
+	return language, true
+}
+
+// retryInLanguage asks LLM 3 again with prompt, now told to answer only in the request's
+// language, after its answer came back in got. It returns the new answer, or ok false if the
+// call failed or was in another language again, and records which in entry.
+func (o *Orchestrator) retryInLanguage(ctx context.Context, entry *db.QueryLog, lang, prompt, got string, timings *stageTimings, eventChan chan<- sse.Event) (answer string, ok bool) {
+	slog.WarnContext(ctx, "Answer is in another language than the request's; asking again", "want", entry.DetectedLanguage, "got", got)
+	eventChan <- sse.Status(i18n.T(lang, "status.llm3.language_retry"))
+	prompt += "\n\n" + languageOnly[entry.DetectedLanguage]
+	start := time.Now()
+	callCtx := llmclient.WithResponseInfo(ctx)
+	answer, err := o.llm3Client.ChatCompletion(callCtx, prompt)
+	timings.called(callCtx, stageAggregation, o.models[stageAggregation], prompt, answer, err, start)
+	if err == nil {
+		var off bool
+		if got, off = offLanguage(entry, answer); !off {
+			entry.LanguageRetry = languageRetryFixed
+			return answer, true
+		}
+		err = errWrongLanguage(got)
+	}
+	entry.LanguageRetry = languageRetryFailed
+	slog.WarnContext(ctx, "Asking again in the request's language failed; sending the first answer", "error", err)
+	return "", false
+}
+
+// errWrongLanguage is the failure of a retry that was in another language again.
+type errWrongLanguage string
+
+func (e errWrongLanguage) Error() string {
+	return "answered in " + string(e) + " again"
+}
+
+// checkStreamLanguage holds back the start of LLM 3's streamed answer to check its language
+// (see EnableLanguageCheck). If the start is in the request's language, or a retry isn't, it
+// returns the whole stream to forward, start included. Otherwise it calls stop, so the streamed
+// call ends, and returns the retry's answer to send instead with ok true.
+func (o *Orchestrator) checkStreamLanguage(ctx context.Context, entry *db.QueryLog, lang, prompt string, streamChan <-chan string, stop context.CancelFunc, timings *stageTimings, eventChan chan<- sse.Event) (rest <-chan string, answer string, ok bool) {
+	var head strings.Builder
+	for head.Len() < languageSample {
+		chunk, open := <-streamChan
+		if !open {
+			break
+		}
+		head.WriteString(chunk)
+	}
+	if got, off := offLanguage(entry, head.String()); off {
+		if answer, ok := o.retryInLanguage(ctx, entry, lang, prompt, got, timings, eventChan); ok {
+			stop()
+			go func() {
+				for range streamChan { // Let the stream's producer finish
+				}
+			}()
+			return nil, answer, true
+		}
+	}
+	return prepend(head.String(), streamChan), "", false
+}
+
+// prepend returns a stream of head, if it isn't empty, followed by the chunks of rest.
+func prepend(head string, rest <-chan string) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		if head != "" {
+			out <- head
+		}
+		for chunk := range rest {
+			out <- chunk
+		}
+	}()
+	return out
+}
@@ -0,0 +1,126 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+const (
+	englishAnswer = "The cheapest flight from Madrid to Paris is FL103, which leaves in the morning and costs less than the others. You can book it on the website of the airline, and it is the one I would take."
+	spanishAnswer = "El vuelo más barato de Madrid a París es el FL103, que sale por la mañana y cuesta menos que los demás. Puedes reservarlo en la web de la aerolínea y es el que yo tomaría."
+)
+
+// retryClient answers first, and retry to prompts that ask for another language, or fails
+// them with retryErr.
+type retryClient struct {
+	first, retry string
+	retryErr     error
+}
+
+func (c retryClient) answer(prompt string) (string, error) {
+	if strings.Contains(prompt, languageOnly[LanguageEnglish]) || strings.Contains(prompt, languageOnly[LanguageSpanish]) {
+		return c.retry, c.retryErr
+	}
+	return c.first, nil
+}
+
+func (c retryClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	answer, err := c.answer(prompt)
+	if err != nil {
+		return "", err
+	}
+	return (&llmclient.MockClient{Response: answer}).ChatCompletion(ctx, prompt)
+}
+
+func (c retryClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	answer, err := c.answer(prompt)
+	if err != nil {
+		return nil, err
+	}
+	return (&llmclient.MockClient{Response: answer}).StreamChatCompletion(ctx, prompt)
+}
+
+// newLanguageOrchestrator returns an orchestrator with the language check on, whose LLM 3 is
+// aggregator, recording its prompts.
+func newLanguageOrchestrator(t *testing.T, aggregator retryClient) *testOrchestrator {
+	t.Helper()
+	o := newTestOrchestrator(t, "FL103 sale por la mañana.", "FL103 es el más barato.", "")
+	o.llm3 = &recordingClient{next: aggregator}
+	o.llm3Client = o.llm3
+	o.EnableLanguageCheck()
+	return o
+}
+
+func TestLanguageRetry(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		// LLM 3 answers a Spanish question in English, and in Spanish when asked again.
+		o := newLanguageOrchestrator(t, retryClient{first: englishAnswer, retry: spanishAnswer})
+		events := process(t, o.Orchestrator, "Vuelos de Madrid a París", Options{}, stream)
+		if answer := answerOf(events); answer != spanishAnswer {
+			t.Errorf("stream %v: answer %q", stream, answer)
+		}
+		if got := telemetryOf(t, events).LanguageRetry; got != languageRetryFixed {
+			t.Errorf("stream %v: telemetry language retry %q", stream, got)
+		}
+		prompts := o.llm3.Prompts()
+		if len(prompts) != 2 || !strings.HasSuffix(prompts[1], "\n\n"+languageOnly[LanguageSpanish]) || !strings.HasPrefix(prompts[1], prompts[0]) {
+			t.Errorf("stream %v: LLM 3 prompts %q", stream, prompts)
+		}
+		var retried bool
+		for _, ev := range ofType(events, sse.TypeStatus) {
+			retried = retried || ev.Data == i18n.T("es", "status.llm3.language_retry")
+		}
+		if !retried {
+			t.Errorf("stream %v: no retry status", stream)
+		}
+	}
+}
+
+func TestLanguageRetryFails(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		for name, aggregator := range map[string]retryClient{
+			"wrong again": {first: englishAnswer, retry: englishAnswer},
+			"error":       {first: englishAnswer, retryErr: errors.New("provider down")},
+		} {
+			// The first answer is sent after all, whole.
+			o := newLanguageOrchestrator(t, aggregator)
+			events := process(t, o.Orchestrator, "Vuelos de Madrid a París", Options{}, stream)
+			if answer := answerOf(events); answer != englishAnswer {
+				t.Errorf("stream %v, %s: answer %q", stream, name, answer)
+			}
+			if got := telemetryOf(t, events).LanguageRetry; got != languageRetryFailed || len(o.llm3.Prompts()) != 2 {
+				t.Errorf("stream %v, %s: language retry %q after %d calls", stream, name, got, len(o.llm3.Prompts()))
+			}
+		}
+	}
+}
+
+func TestLanguageCheckPasses(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		// Answers in the request's language, or too short to tell, aren't asked for again.
+		for _, tt := range []struct{ message, answer string }{
+			{"Vuelos de Madrid a París", spanishAnswer},
+			{"Flights from Madrid to Paris", englishAnswer},
+			{"Vuelos de Madrid a París", "FL103."},
+		} {
+			o := newLanguageOrchestrator(t, retryClient{first: tt.answer, retry: "unexpected"})
+			events := process(t, o.Orchestrator, tt.message, Options{}, stream)
+			if answer := answerOf(events); answer != tt.answer || len(o.llm3.Prompts()) != 1 || telemetryOf(t, events).LanguageRetry != "" {
+				t.Errorf("stream %v, %q: answer %q after %d calls", stream, tt.message, answer, len(o.llm3.Prompts()))
+			}
+		}
+	}
+
+	// Off, the wrong language is sent as it came.
+	o := newLanguageOrchestrator(t, retryClient{first: englishAnswer, retry: spanishAnswer})
+	o.languageCheck = false
+	if answer := answerOf(process(t, o.Orchestrator, "Vuelos de Madrid a París", Options{}, false)); answer != englishAnswer || len(o.llm3.Prompts()) != 1 {
+		t.Errorf("check off: answer %q", answer)
+	}
+}
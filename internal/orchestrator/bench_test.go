@@ -0,0 +1,65 @@
+package orchestrator
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// newBenchOrchestrator returns the pipeline on instant mock LLMs and the seeded memory backend,
+// so the benchmarks measure our own code. Logs are discarded until the benchmark ends: at
+// benchmark rates they would measure the logger.
+func newBenchOrchestrator(b *testing.B) *Orchestrator {
+	b.Helper()
+	prev := slog.Default()
+	b.Cleanup(func() { slog.SetDefault(prev) })
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	store := db.NewMemoryClient()
+	if err := store.SeedFlights(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	o := NewOrchestrator(llmclient.NewMockClient(0), llmclient.NewMockClient(0), llmclient.NewMockClient(0), store)
+	o.HideTelemetry()
+	return o
+}
+
+// benchmarkProcess runs a flight search and a general question, which take different paths,
+// through the buffered or the streaming pipeline.
+func benchmarkProcess(b *testing.B, stream bool) {
+	o := newBenchOrchestrator(b)
+	for _, bc := range []struct{ name, message string }{
+		{"flight", "Show me flights from Madrid to Paris"},
+		{"general", "What is the capital of France?"},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				// Drained as sent, like a client would, so the figures exclude a buffer of events.
+				events := make(chan sse.Event)
+				drained := make(chan struct{})
+				go func() {
+					defer close(drained)
+					for range events {
+					}
+				}()
+				if stream {
+					o.ProcessMessageStream(context.Background(), bc.message, Options{}, events)
+				} else {
+					o.ProcessMessage(context.Background(), bc.message, Options{}, events)
+				}
+				close(events)
+				<-drained
+			}
+		})
+	}
+}
+
+func BenchmarkProcessMessage(b *testing.B) { benchmarkProcess(b, false) }
+
+func BenchmarkProcessMessageStream(b *testing.B) { benchmarkProcess(b, true) }
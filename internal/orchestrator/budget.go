@@ -0,0 +1,94 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// TokenBudget limits the tokens one request's LLM calls may use together, so a prompt that
+// asks for an enormous answer can't run up an enormous bill. See SetTokenBudget.
+type TokenBudget struct {
+	MaxTokens            int // Prompt and completion tokens per request, across its three calls; 0 means unlimited
+	MinWorkerTokens      int // The smallest completion cap a worker call is worth making with
+	MinAggregationTokens int // The smallest completion cap the aggregation call is worth making with
+}
+
+// aggregationPromptTokens approximates the aggregation prompt without the worker answers: the
+// instructions, the data-only notice and the fences.
+const aggregationPromptTokens = 300
+
+// SetTokenBudget gives every request a budget of tokens. The worker calls are capped so that,
+// after them, the aggregation call still fits; the aggregation call gets what is left. When a
+// call's cap would fall below its minimum the request stops with a token_budget_exceeded Error
+// event instead of making the call. The LLM clients must be wrapped with
+// llmclient.WithTokenBudget, which charges each call's usage to the budget. It must be called
+// before the orchestrator serves requests.
+func (o *Orchestrator) SetTokenBudget(budget TokenBudget) {
+	o.tokenBudget = budget
+}
+
+// startBudget returns ctx carrying a fresh budget for one request, if budgets are on.
+func (o *Orchestrator) startBudget(ctx context.Context) context.Context {
+	if o.tokenBudget.MaxTokens <= 0 {
+		return ctx
+	}
+	return llmclient.WithBudget(ctx, llmclient.NewBudget(o.tokenBudget.MaxTokens))
+}
+
+// budgetWorkers returns the context to make the worker calls with, whose completion cap shares
+// what is left of the budget between them and, unless aggregation is skipped, the aggregation
+// call. ok is false when the request has been stopped because the caps would be too small.
+func (o *Orchestrator) budgetWorkers(ctx context.Context, entry *db.QueryLog, lang string, opts Options, prompt1, prompt2 string, failure *error, eventChan chan<- sse.Event) (context.Context, bool) {
+	b := llmclient.BudgetFrom(ctx)
+	if b == nil {
+		return ctx, true
+	}
+	remaining := b.Remaining()
+	overhead := llmclient.EstimateTokens(o.systemPrompts[stageLLM1]+prompt1) + llmclient.EstimateTokens(o.systemPrompts[stageLLM2]+prompt2)
+	shares := 2 // One completion per worker
+	if !opts.SkipAggregation {
+		// Both answers are pasted into the aggregation prompt, so each worker token is paid
+		// for twice, and the aggregation call needs room for its own answer.
+		overhead += aggregationPromptTokens + o.tokenBudget.MinAggregationTokens
+		shares = 4
+	}
+	limit := (remaining - overhead) / shares
+	minimum := max(1, o.tokenBudget.MinWorkerTokens)
+	if limit < minimum {
+		o.budgetExceeded(ctx, entry, lang, &llmclient.BudgetError{Limit: b.Limit(), Used: b.Used(), Needed: overhead + shares*minimum, Remaining: remaining}, failure, eventChan)
+		return nil, false
+	}
+	slog.DebugContext(ctx, "Capped worker calls", "max_tokens", limit, "used", b.Used(), "budget", b.Limit())
+	return llmclient.WithMaxTokens(ctx, limit), true
+}
+
+// budgetAggregation returns the context to make the aggregation call with, whose completion
+// cap is what is left of the budget after its prompt. ok is false when the request has been
+// stopped because that is less than the minimum.
+func (o *Orchestrator) budgetAggregation(ctx context.Context, entry *db.QueryLog, lang, prompt string, failure *error, eventChan chan<- sse.Event) (context.Context, bool) {
+	b := llmclient.BudgetFrom(ctx)
+	if b == nil {
+		return ctx, true
+	}
+	promptTokens := llmclient.EstimateTokens(o.systemPrompts[stageAggregation] + prompt)
+	if err := b.Check(promptTokens, max(1, o.tokenBudget.MinAggregationTokens)); err != nil {
+		o.budgetExceeded(ctx, entry, lang, err, failure, eventChan)
+		return nil, false
+	}
+	limit := b.Remaining() - promptTokens
+	slog.DebugContext(ctx, "Capped aggregation call", "max_tokens", limit, "used", b.Used(), "budget", b.Limit())
+	return llmclient.WithMaxTokens(ctx, limit), true
+}
+
+// budgetExceeded stops a request whose next call doesn't fit in its budget.
+func (o *Orchestrator) budgetExceeded(ctx context.Context, entry *db.QueryLog, lang string, err error, failure *error, eventChan chan<- sse.Event) {
+	slog.WarnContext(ctx, "Token budget exceeded; stopping the request", "error", err)
+	entry.Error = err.Error()
+	*failure = err
+	eventChan <- sse.Error("token_budget_exceeded", i18n.T(lang, "error.token_budget", o.tokenBudget.MaxTokens))
+}
@@ -0,0 +1,134 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// capRecordingClient passes calls on to next and records their completion caps.
+type capRecordingClient struct {
+	next llmclient.LLMClient
+
+	mu   sync.Mutex
+	caps []int
+}
+
+func (c *capRecordingClient) record(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.caps = append(c.caps, llmclient.MaxTokens(ctx))
+}
+
+// Caps returns the completion caps of the calls made so far.
+func (c *capRecordingClient) Caps() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.caps...)
+}
+
+func (c *capRecordingClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	c.record(ctx)
+	return c.next.ChatCompletion(ctx, prompt)
+}
+
+func (c *capRecordingClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	c.record(ctx)
+	return c.next.StreamChatCompletion(ctx, prompt)
+}
+
+// verboseClient answers with answer whatever the call's cap, and reports no usage.
+type verboseClient struct{ answer string }
+
+func (c verboseClient) ChatCompletion(context.Context, string) (string, error) {
+	return c.answer, nil
+}
+
+func (c verboseClient) StreamChatCompletion(context.Context, string) (<-chan string, error) {
+	chunks := make(chan string, 1)
+	chunks <- c.answer
+	close(chunks)
+	return chunks, nil
+}
+
+// newBudgetedOrchestrator returns an orchestrator over the seeded memory backend whose budgeted
+// LLMs record their caps.
+func newBudgetedOrchestrator(t *testing.T, budget TokenBudget, llm1, llm2, llm3 llmclient.LLMClient) (*Orchestrator, [3]*capRecordingClient) {
+	t.Helper()
+	store := db.NewMemoryClient()
+	if err := store.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	recorders := [3]*capRecordingClient{{next: llm1}, {next: llm2}, {next: llm3}}
+	o := NewOrchestrator(llmclient.WithTokenBudget(recorders[0]), llmclient.WithTokenBudget(recorders[1]), llmclient.WithTokenBudget(recorders[2]), store)
+	o.SetTokenBudget(budget)
+	return o, recorders
+}
+
+func TestTokenBudgetCapsAggregation(t *testing.T) {
+	budget := TokenBudget{MaxTokens: 4000, MinWorkerTokens: 32, MinAggregationTokens: 64}
+	// The mocks would answer at length; the caps cut them short and they report what they used.
+	long := strings.Repeat("word ", 4000)
+	mock := func() llmclient.LLMClient { return &llmclient.MockClient{Response: long} }
+	o, recorders := newBudgetedOrchestrator(t, budget, mock(), mock(), mock())
+
+	for _, stream := range []bool{false, true} {
+		events := process(t, o, "What is the capital of France?", Options{Language: "en"}, stream)
+		if errs := ofType(events, sse.TypeError); len(errs) != 0 {
+			t.Fatalf("stream %v: errors %v", stream, errs)
+		}
+		workerCap := recorders[0].Caps()[0]
+		if workerCap <= 0 || workerCap >= budget.MaxTokens/4 || recorders[1].Caps()[0] != workerCap {
+			t.Errorf("stream %v: worker caps %v and %v", stream, recorders[0].Caps(), recorders[1].Caps())
+		}
+		// The aggregation call gets what the workers' usage and its prompt left: less than the
+		// workers had, but at least its minimum.
+		aggregationCaps := recorders[2].Caps()
+		aggregationCap := aggregationCaps[len(aggregationCaps)-1]
+		if aggregationCap < budget.MinAggregationTokens || aggregationCap >= budget.MaxTokens-2*workerCap {
+			t.Errorf("stream %v: aggregation cap %d, worker cap %d", stream, aggregationCap, workerCap)
+		}
+		if answer := answerOf(events); answer == "" || len(answer) > 4*aggregationCap {
+			t.Errorf("stream %v: answer of %d characters with a cap of %d tokens", stream, len(answer), aggregationCap)
+		}
+		recorders[0].caps, recorders[1].caps = nil, nil
+	}
+}
+
+func TestTokenBudgetExceeded(t *testing.T) {
+	budget := TokenBudget{MaxTokens: 4000, MinWorkerTokens: 32, MinAggregationTokens: 64}
+	// The workers ignore their caps and answer with about 2000 tokens each, so nothing is left
+	// for the aggregation call.
+	verbose := verboseClient{answer: strings.Repeat("word ", 1600)}
+	for _, stream := range []bool{false, true} {
+		o, recorders := newBudgetedOrchestrator(t, budget, verbose, verbose, &llmclient.MockClient{})
+		events := process(t, o, "What is the capital of France?", Options{Language: "en"}, stream)
+		errs := ofType(events, sse.TypeError)
+		if len(errs) != 1 || errs[0].Payload.(sse.ErrorPayload).Code != "token_budget_exceeded" || !strings.Contains(errs[0].Data, "4000") {
+			t.Errorf("stream %v: Error events %v, want one token_budget_exceeded", stream, errs)
+		}
+		if caps := recorders[2].Caps(); len(caps) != 0 {
+			t.Errorf("stream %v: aggregation called with caps %v", stream, caps)
+		}
+		if done := ofType(events, sse.TypeDone); len(done) != 1 || done[0].Payload.(sse.DonePayload).Outcome != sse.OutcomeError {
+			t.Errorf("stream %v: Done events %v, want one with an error", stream, done)
+		}
+	}
+
+	// A budget too small for the workers' prompts stops the request before any call.
+	o, recorders := newBudgetedOrchestrator(t, TokenBudget{MaxTokens: 200, MinWorkerTokens: 32, MinAggregationTokens: 64}, verbose, verbose, verbose)
+	events := process(t, o, "What is the capital of France?", Options{Language: "en"}, false)
+	if errs := ofType(events, sse.TypeError); len(errs) != 1 || errs[0].Payload.(sse.ErrorPayload).Code != "token_budget_exceeded" {
+		t.Errorf("Error events %v, want one token_budget_exceeded", errs)
+	}
+	for i, r := range recorders {
+		if caps := r.Caps(); len(caps) != 0 {
+			t.Errorf("LLM %d called with caps %v", i+1, caps)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package orchestrator
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+)
+
+// Cities are recognized in questions by the names the database gives them and by their
+// aliases: other names, such as Spanish ones ("Londres") or airport codes ("JFK"). The names
+// come from the routes of the stored flights and schedules, so a city added through the admin
+// API is understood once the index is refreshed, without a release. The IATA codes of the
+// airports the flights use name the airport itself: "from JFK" searches that airport's flights,
+// "from New York" those of every New York airport.
+
+// defaultCityAliases are other names of cities, keyed as normalizeCity leaves them. Aliases of
+// cities the database doesn't serve are ignored.
+var defaultCityAliases = map[string]string{
+	"londres":    "London",
+	"sevilla":    "Seville",
+	"roma":       "Rome",
+	"nueva york": "New York",
+	"nyc":        "New York",
+	"jfk":        "New York",
+	"lax":        "Los Angeles",
+	"tokio":      "Tokyo",
+}
+
+// CityIndex knows the cities the database serves and every name they go by. It is loaded from
+// the database on first use; Refresh, Invalidate and Run keep it current. It is safe for
+// concurrent use.
+type CityIndex struct {
+	store   db.Client
+	aliases atomic.Pointer[map[string]string] // Normalized alias -> city: the defaults and the configured ones
+
+	mu    sync.RWMutex
+	names *cityNames // nil until loaded
+	wake  chan struct{}
+}
+
+// cityNames is one load of the index.
+type cityNames struct {
+	cities   []string
+	byName   map[string]string // Normalized name or alias -> city
+	airports map[string]string // Normalized airport code -> the code, for the names that are one
+	names    []string          // The keys of byName, longest first, so "new york" is found before "york"
+}
+
+// place is a city a question names, and the airport, if it was named by the airport's code.
+type place struct {
+	city    string
+	airport string // IATA code
+}
+
+// NewCityIndex returns the index of the cities in store. aliases adds to, or overrides, the
+// built-in aliases; it maps names to cities as the database writes them ("Lisboa": "Lisbon").
+func NewCityIndex(store db.Client, aliases map[string]string) *CityIndex {
+	c := &CityIndex{store: store, wake: make(chan struct{}, 1)}
+	c.SetAliases(aliases)
+	return c
+}
+
+// SetAliases replaces the configured aliases, as NewCityIndex takes them. They are used from
+// the next Refresh; the built-in aliases stay.
+func (c *CityIndex) SetAliases(aliases map[string]string) {
+	all := maps.Clone(defaultCityAliases)
+	for alias, city := range aliases {
+		all[normalizeCity(alias)] = city
+	}
+	c.aliases.Store(&all)
+}
+
+// SetCities sets the index the cities in questions are looked up in, in place of the one
+// NewOrchestrator builds with the built-in aliases. It must be called before the orchestrator
+// serves requests.
+func (o *Orchestrator) SetCities(index *CityIndex) {
+	o.cities = index
+}
+
+// LoadCityAliases reads a YAML (or JSON) file mapping aliases to the cities they name, e.g.
+// "Lisboa: Lisbon".
+func LoadCityAliases(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var aliases map[string]string
+	if err := yaml.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("parse city aliases %s: %w", path, err)
+	}
+	for alias, city := range aliases {
+		if strings.TrimSpace(alias) == "" || strings.TrimSpace(city) == "" {
+			return nil, fmt.Errorf("city aliases %s: %q: alias and city must not be empty", path, alias)
+		}
+	}
+	return aliases, nil
+}
+
+// Refresh reloads the cities and airports from the database.
+func (c *CityIndex) Refresh(ctx context.Context) error {
+	routes, err := c.store.ListRoutes(ctx)
+	if err != nil {
+		return err
+	}
+	airports, err := c.store.ListAirports(ctx)
+	if err != nil {
+		return err
+	}
+	n := &cityNames{cities: db.Cities(routes), byName: make(map[string]string), airports: make(map[string]string)}
+	for _, city := range n.cities {
+		n.byName[normalizeCity(city)] = city
+	}
+	for _, a := range airports {
+		code := normalizeCity(a.Code)
+		if _, taken := n.byName[code]; !taken {
+			n.byName[code] = a.City
+			n.airports[code] = a.Code
+		}
+	}
+	for alias, city := range *c.aliases.Load() {
+		if served := n.byName[normalizeCity(city)]; served != "" {
+			if _, taken := n.byName[alias]; !taken {
+				n.byName[alias] = served
+			}
+		}
+	}
+	n.names = slices.SortedFunc(maps.Keys(n.byName), func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b))
+	})
+
+	c.mu.Lock()
+	c.names = n
+	c.mu.Unlock()
+	return nil
+}
+
+// Invalidate has Run refresh the index now, e.g. because flights changed. It doesn't wait.
+func (c *CityIndex) Invalidate() {
+	select {
+	case c.wake <- struct{}{}:
+	default: // A refresh is already due.
+	}
+}
+
+// Run refreshes the index every interval (never if it is 0), and whenever Invalidate is
+// called, until ctx is cancelled. A failed refresh keeps the cities already loaded.
+func (c *CityIndex) Run(ctx context.Context, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-c.wake:
+		}
+		refreshCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		if err := c.Refresh(refreshCtx); err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "Failed to refresh the cities; keeping the ones loaded", "error", err)
+		}
+		cancel()
+	}
+}
+
+// current returns the index, loading it first if it hasn't been. If that fails, no city is
+// known for this request, and loading is tried again on the next one.
+func (c *CityIndex) current(ctx context.Context) *cityNames {
+	c.mu.RLock()
+	n := c.names
+	c.mu.RUnlock()
+	if n != nil {
+		return n
+	}
+	if err := c.Refresh(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to load the cities; no city is recognized", "error", err)
+		return &cityNames{byName: map[string]string{}}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.names
+}
+
+// resolve returns the city in cities that name, or one of its aliases, refers to, or "" if
+// there is none.
+func (c *CityIndex) resolve(name string, cities []string) string {
+	name = normalizeCity(name)
+	if alias, ok := (*c.aliases.Load())[name]; ok {
+		name = normalizeCity(alias)
+	}
+	for _, city := range cities {
+		if normalizeCity(city) == name {
+			return city
+		}
+	}
+	return ""
+}
+
+// mentions reports whether the folded message (see foldCity) names a city.
+func (n *cityNames) mentions(folded string) bool {
+	for _, name := range n.names {
+		if containsWords(folded, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractRoute reads the route of a flight question from the folded message (see foldCity):
+// the city after "from" or "desde" is the origin, the one after "to", "a" or "hacia" the
+// destination. Without a marked destination, the first other city named is taken for it
+// ("... londres?"). A city named by an airport code comes with that airport.
+func (n *cityNames) extractRoute(folded string) (origin, destination place) {
+	for _, name := range n.names {
+		p := place{city: n.byName[name], airport: n.airports[name]}
+		if origin.city == "" && markedWith(folded, name, originMarkers) {
+			origin = p
+		}
+		if destination.city == "" && markedWith(folded, name, destinationMarkers) {
+			destination = p
+		}
+	}
+	if destination.city == "" {
+		for _, name := range n.names {
+			if city := n.byName[name]; city != origin.city && containsWords(folded, name) {
+				return origin, place{city: city, airport: n.airports[name]}
+			}
+		}
+	}
+	return origin, destination
+}
+
+// markedWith reports whether text has name right after one of markers.
+func markedWith(text, name string, markers []string) bool {
+	for _, marker := range markers {
+		if containsWords(text, marker+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsWords reports whether text has phrase as whole words: not inside a longer word,
+// as "la" is in "vuela".
+func containsWords(text, phrase string) bool {
+	wordChar := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	first, _ := utf8.DecodeRuneInString(phrase)
+	last, _ := utf8.DecodeLastRuneInString(phrase)
+	for from := 0; ; {
+		i := strings.Index(text[from:], phrase)
+		if i < 0 {
+			return false
+		}
+		start, end := from+i, from+i+len(phrase)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !(wordChar(first) && wordChar(before)) && !(wordChar(last) && wordChar(after)) {
+			return true
+		}
+		from = start + 1
+	}
+}
+
+// accents folds the accented letters of Spanish city names ("París", "Berlín").
+var accents = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u")
+
+// foldCity lowercases text and drops its accents, as normalizeCity does to city names, so
+// they can be looked for in it.
+func foldCity(text string) string {
+	return accents.Replace(strings.ToLower(text))
+}
+
+// normalizeCity lowercases a city name, drops its accents and any leading article, and
+// collapses its spaces.
+func normalizeCity(name string) string {
+	return strings.TrimPrefix(strings.Join(strings.Fields(foldCity(name)), " "), "the ")
+}
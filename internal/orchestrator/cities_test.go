@@ -0,0 +1,155 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+)
+
+// lisbonFlight is a flight to a city the seed data doesn't have.
+var lisbonFlight = db.Flight{FlightNumber: "FL900", Origin: "Madrid", Destination: "Lisbon",
+	DepartureTime: "2025-08-20T08:00:00Z", ArrivalTime: "2025-08-20T09:15:00Z", Price: 80, AvailableSeats: 40}
+
+func TestCitiesFromDatabase(t *testing.T) {
+	ctx := context.Background()
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+		index := NewCityIndex(o.db, map[string]string{"Lisboa": "Lisbon"})
+		o.SetCities(index)
+		process(t, o.Orchestrator, "Vuelos de Madrid a París", Options{}, stream) // Loads the index
+		if err := o.db.CreateFlight(ctx, lisbonFlight); err != nil {
+			t.Fatal(err)
+		}
+
+		// Until the index is refreshed, Lisbon isn't known: no destination, every flight.
+		if got := flightNumbers(process(t, o.Orchestrator, "Vuelos a Lisboa", Options{}, stream)); len(got) < 2 {
+			t.Errorf("stream %v: before the refresh, flights %v", stream, got)
+		}
+		if err := index.Refresh(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for _, message := range []string{"Vuelos a Lisboa", "Flights from Madrid to Lisbon", "vuelos desde madrid a lisboa"} {
+			if got := flightNumbers(process(t, o.Orchestrator, message, Options{}, stream)); !slices.Equal(got, []string{"FL900"}) {
+				t.Errorf("stream %v: %q found %v, want FL900", stream, message, got)
+			}
+		}
+	}
+}
+
+func TestCityIndexRun(t *testing.T) {
+	store := db.NewMemoryClient()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := store.SeedFlights(ctx); err != nil {
+		t.Fatal(err)
+	}
+	index := NewCityIndex(store, nil)
+	if index.current(ctx).mentions("flights to lisbon") {
+		t.Fatal("Lisbon known before its flights")
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		index.Run(ctx, 0)
+	}()
+
+	// A change to the flights, reported with Invalidate, reloads the index.
+	if err := store.CreateFlight(ctx, lisbonFlight); err != nil {
+		t.Fatal(err)
+	}
+	index.Invalidate()
+	for deadline := time.Now().Add(2 * time.Second); !index.current(ctx).mentions("flights to lisbon"); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Lisbon not known after Invalidate")
+		}
+	}
+	cancel()
+	<-done
+}
+
+func TestExtractRoute(t *testing.T) {
+	o := newTestOrchestrator(t, "", "", "")
+	cities := o.cities.current(context.Background())
+	for message, want := range map[string][2]place{
+		"flights from madrid to paris":        {{city: "Madrid"}, {city: "Paris"}},
+		"vuelos a parís desde madrid":         {{city: "Madrid"}, {city: "Paris"}},
+		"vuelos desde roma a parís":           {{city: "Rome"}, {city: "Paris"}},
+		"flights from jfk to london":          {{city: "New York", airport: "JFK"}, {city: "London"}},
+		"¿hay vuelos desde nueva york a lgw?": {{city: "New York"}, {city: "London", airport: "LGW"}},
+		"from madrid... barcelona?":           {{city: "Madrid"}, {city: "Barcelona"}},
+		"vuelos a londres":                    {{}, {city: "London"}},
+		"where does la vuela go":              {{}, {}},
+	} {
+		origin, destination := cities.extractRoute(foldCity(message))
+		if origin != want[0] || destination != want[1] {
+			t.Errorf("extractRoute(%q) = %+v, %+v, want %+v, %+v", message, origin, destination, want[0], want[1])
+		}
+	}
+}
+
+func TestResolveCity(t *testing.T) {
+	index := NewCityIndex(nil, map[string]string{"Lisboa": "Lisbon", "Londres": "London Town"})
+	cities := []string{"London", "London Town", "Lisbon", "Paris"}
+	for name, want := range map[string]string{
+		"Lisboa":     "Lisbon",
+		"  PARÍS ":   "Paris",
+		"londres":    "London Town", // The configured alias overrides the built-in one
+		"the london": "London",
+		"Atlantis":   "",
+		"roma":       "", // An alias of a city not in cities
+	} {
+		if got := index.resolve(name, cities); got != want {
+			t.Errorf("resolve(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestContainsWords(t *testing.T) {
+	for _, tt := range []struct {
+		text, phrase string
+		want         bool
+	}{
+		{"vuelos a la paz", "la paz", true},
+		{"vuela hoy", "la", false},
+		{"la", "la", true},
+		{"vuela a la playa", "la", true},
+		{"to rome.", "rome", true},
+		{"jfk-lhr", "jfk", true},
+		{"romero", "rome", false},
+	} {
+		if got := containsWords(tt.text, tt.phrase); got != tt.want {
+			t.Errorf("containsWords(%q, %q) = %v", tt.text, tt.phrase, got)
+		}
+	}
+}
+
+func TestLoadCityAliases(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	aliases, err := LoadCityAliases(write("aliases.yaml", "Lisboa: Lisbon\n\"Ciudad de México\": Mexico City\n"))
+	if err != nil || len(aliases) != 2 || aliases["Lisboa"] != "Lisbon" || aliases["Ciudad de México"] != "Mexico City" {
+		t.Errorf("LoadCityAliases = %v, %v", aliases, err)
+	}
+	for name, content := range map[string]string{
+		"list.yaml":  "- Lisboa\n",
+		"empty.yaml": "Lisboa: \"\"\n",
+	} {
+		if _, err := LoadCityAliases(write(name, content)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if _, err := LoadCityAliases(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("missing file: no error")
+	}
+}
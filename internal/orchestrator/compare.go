@@ -0,0 +1,281 @@
+package orchestrator
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// Questions that compare flights ("is FL101 or FL102 cheaper?", "Madrid to Paris or Madrid to
+// Rome: which is faster?") are answered from the flight records, with the differences worked
+// out here: the workers would be given the flights to do the arithmetic themselves, and get it
+// wrong now and then.
+
+// Aspects of flights a comparison can ask about.
+const (
+	aspectPrice     = "price"
+	aspectDuration  = "duration"
+	aspectDeparture = "departure"
+)
+
+// aspects are the aspects in the order a comparison states them.
+var aspects = []string{aspectPrice, aspectDuration, aspectDeparture}
+
+// aspectPatterns recognize the aspects a lowercased comparison asks about.
+var aspectPatterns = map[string]*regexp.Regexp{
+	aspectPrice:     regexp.MustCompile(`\b(?:cheap\w*|expensive|pric\w*|costs?|barat\w*|car[oa]s?|precios?|cuestan?)\b`),
+	aspectDuration:  regexp.MustCompile(`\b(?:faster|fastest|quick\w*|shorter|shortest|longer|longest|duration|how long|r[aá]pid\w*|cort[oa]s?|larg[oa]s?|dura|duraci[oó]n|tarda)\b`),
+	aspectDeparture: regexp.MustCompile(`\b(?:earl\w*|later|latest|first|sooner|leaves?|departs?|departure|antes|temprano|primero|sale|salida|tarde)\b`),
+}
+
+// comparisonCue shows that a lowercased message naming several flights or routes compares
+// them, when it doesn't ask about an aspect.
+var comparisonCue = regexp.MustCompile(`\b(?:compare|comparison|vs\.?|versus|difference|or|which|better|compar\w*|diferencias?|cu[aá]l|mejor|o)\b`)
+
+// comparedFlight matches flight numbers in any case ("FL101", "fl101").
+var comparedFlight = regexp.MustCompile(`(?i)\b[a-z]{2}\d{2,4}\b`)
+
+// comparedRoutes matches two routes joined by "or", "vs" or "and" in a lowercased message:
+// "madrid to paris or madrid to rome", "de madrid a parís o de madrid a roma".
+var comparedRoutes = func() *regexp.Regexp {
+	const city = `(nueva york|new york|los angeles|\pL[\pL'-]*)`
+	const to = `\s+(?:to|a|->|→)\s+`
+	const from = `(?:from\s+|de\s+|desde\s+)?`
+	return regexp.MustCompile(from + city + to + city + `\s*,?\s+(?:or|vs\.?|versus|and|o|y)\s+` + from + city + to + city + `\b`)
+}()
+
+// comparison is a question that compares flights, named by number, or routes, compared by
+// their cheapest flights.
+type comparison struct {
+	numbers []string    // Flight numbers, in capitals, as the question names them
+	routes  [][2]string // Origin and destination, as written
+	aspects []string    // The aspects asked about; none asks for the whole picture
+}
+
+// detectComparison reports whether message compares two or more flights or two routes.
+func detectComparison(message string) (comparison, bool) {
+	lower := strings.ToLower(message)
+	var c comparison
+	for _, aspect := range aspects {
+		if aspectPatterns[aspect].MatchString(lower) {
+			c.aspects = append(c.aspects, aspect)
+		}
+	}
+	if len(c.aspects) == 0 && !comparisonCue.MatchString(lower) {
+		return comparison{}, false
+	}
+	for _, number := range comparedFlight.FindAllString(message, -1) {
+		if number = strings.ToUpper(number); !slices.Contains(c.numbers, number) {
+			c.numbers = append(c.numbers, number)
+		}
+	}
+	if len(c.numbers) >= 2 {
+		return c, true
+	}
+	c.numbers = nil
+	if m := comparedRoutes.FindStringSubmatch(lower); m != nil {
+		c.routes = [][2]string{{m[1], m[2]}, {m[3], m[4]}}
+		return c, true
+	}
+	return comparison{}, false
+}
+
+// compared is one side of a comparison: a flight, or the cheapest flight of a route.
+type compared struct {
+	label  string
+	flight db.Flight
+}
+
+// answerComparison answers a comparison from the flight records. The flights found are sent as
+// a FlightResults event before the answer. A question about prices, durations or departures is
+// answered as written here; any other is answered by LLM 3 from the facts written here, or
+// with them as written if the call fails (see phrase). stream says whether LLM 3's answer is
+// streamed.
+func (o *Orchestrator) answerComparison(ctx context.Context, entry *db.QueryLog, userMessage string, c comparison, lang string, stream bool, timings *stageTimings, failure *error, eventChan chan<- sse.Event) {
+	start := time.Now()
+	sides, missing, err := o.lookupCompared(ctx, lang, c)
+	timings.since(stageSearch, start)
+	if err != nil {
+		entry.Error = err.Error()
+		*failure = err
+		slog.WarnContext(ctx, "Flight lookup for a comparison failed", "error", err)
+		eventChan <- sse.Error("search_unavailable", i18n.T(lang, "error.search_unavailable"))
+		return
+	}
+	entry.ResultCount = len(sides)
+	if len(sides) > 0 {
+		flights := make([]db.Flight, len(sides))
+		for i, side := range sides {
+			flights[i] = side.flight
+		}
+		// Structured results for JSON clients; plain clients just see the count.
+		eventChan <- sse.FlightResults(o.withLocalTimes(flights))
+	}
+
+	written := o.comparisonAnswer(ctx, entry, lang, sides, missing, c.aspects)
+	if len(sides) < 2 || len(c.aspects) > 0 {
+		eventChan <- sse.MessageChunk(written, true)
+		return
+	}
+	language := entry.DetectedLanguage
+	prompt := dataOnlyNotice(language)
+	if language == LanguageSpanish {
+		prompt += "Responde a la pregunta del usuario con la siguiente comparación de vuelos, de forma breve y amable. Usa solo los datos que da: no calcules otros números ni añadas, quites o cambies ningún vuelo, precio, hora o duración. Si dice que falta algún vuelo, dilo. Responde en español.\n\n"
+	} else {
+		prompt += "Answer the user's question from the following comparison of flights, briefly and in a friendly tone. Use only the facts it gives: do not work out other numbers, and do not add, drop or change any flight, price, time or duration. If it says a flight is missing, say so.\n\n"
+	}
+	prompt += "Question: " + userMessage + "\n" + fence("COMPARISON", sanitizeUntrusted(ctx, "comparison", written))
+	o.phrase(ctx, entry, lang, entry.Intent, prompt, written, stream, timings, eventChan)
+}
+
+// lookupCompared fetches the sides of c: each flight by number, or the cheapest flight of each
+// route. It returns the sides found, in the question's order, and a sentence for each one that
+// wasn't. err is set only when the database couldn't be asked.
+func (o *Orchestrator) lookupCompared(ctx context.Context, lang string, c comparison) (sides []compared, missing []string, err error) {
+	for _, number := range c.numbers {
+		flight, err := o.dbClient.GetFlight(ctx, number)
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			missing = append(missing, i18n.T(lang, "message.compare.no_flight", number))
+		case err != nil:
+			return nil, nil, err
+		default:
+			sides = append(sides, compared{label: flight.FlightNumber, flight: flight})
+		}
+	}
+	if len(c.routes) == 0 {
+		return sides, missing, nil
+	}
+
+	routes, err := o.dbClient.ListRoutes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	cities := db.Cities(routes)
+	for _, route := range c.routes {
+		origin, destination := o.cities.resolve(route[0], cities), o.cities.resolve(route[1], cities)
+		var flights []db.Flight
+		if origin != "" && destination != "" {
+			if flights, err = o.dbClient.SearchFlights(ctx, origin, destination, 0); err != nil {
+				return nil, nil, err
+			}
+		}
+		if len(flights) == 0 {
+			missing = append(missing, i18n.T(lang, "message.compare.no_route", cmp.Or(origin, route[0]), cmp.Or(destination, route[1])))
+			continue
+		}
+		cheapest := slices.MinFunc(flights, func(a, b db.Flight) int { return cmp.Compare(a.Price, b.Price) })
+		label := fmt.Sprintf("%s → %s (%s)", origin, destination, cheapest.FlightNumber)
+		sides = append(sides, compared{label: label, flight: cheapest})
+	}
+	return sides, missing, nil
+}
+
+// comparisonAnswer writes the answer to a comparison of sides on the aspects asked about, or on
+// every aspect, after describing each side, if none was. Sides that weren't found are named
+// first, in missing. With fewer than two sides there is nothing to compare: the answer says so
+// and describes the side there is.
+func (o *Orchestrator) comparisonAnswer(ctx context.Context, entry *db.QueryLog, lang string, sides []compared, missing []string, asked []string) string {
+	sentences := slices.Clone(missing)
+	if len(sides) < 2 || len(asked) == 0 {
+		for _, side := range sides {
+			f := side.flight
+			sentences = append(sentences, i18n.T(lang, "message.compare.flight", f.FlightNumber, db.PlaceName(f.Origin, f.OriginAirport), db.PlaceName(f.Destination, f.DestinationAirport),
+				o.departureText(f), formatDuration(lang, flightDuration(f)), o.displayPrice(ctx, f.Price, entry.Currency, lang)))
+		}
+	}
+	if len(sides) < 2 {
+		return strings.Join(append(sentences, i18n.T(lang, "message.compare.too_few")), " ")
+	}
+	if len(asked) == 0 {
+		asked = aspects
+	}
+	for _, aspect := range asked {
+		sentences = append(sentences, o.compareOn(ctx, entry, lang, aspect, sides)...)
+	}
+	return strings.Join(sentences, " ")
+}
+
+// compareOn states how sides compare on aspect: which is best (the cheapest, the shortest or
+// the first to leave), and how far behind it each other side is.
+func (o *Orchestrator) compareOn(ctx context.Context, entry *db.QueryLog, lang, aspect string, sides []compared) []string {
+	sorted := slices.Clone(sides)
+	var value func(compared) float64
+	switch aspect {
+	case aspectPrice:
+		value = func(s compared) float64 { return s.flight.Price }
+	case aspectDuration:
+		value = func(s compared) float64 { return float64(flightDuration(s.flight)) }
+	default:
+		value = func(s compared) float64 { return float64(departure(s.flight).Unix()) }
+	}
+	slices.SortStableFunc(sorted, func(a, b compared) int { return cmp.Compare(value(a), value(b)) })
+
+	best := sorted[0]
+	var sentences []string
+	switch aspect {
+	case aspectPrice:
+		price := func(amount float64) string { return o.displayPrice(ctx, amount, entry.Currency, lang) }
+		sentences = append(sentences, i18n.T(lang, "message.compare.price.lowest", best.label, price(best.flight.Price)))
+		for _, s := range sorted[1:] {
+			if diff := s.flight.Price - best.flight.Price; diff > 0 {
+				sentences = append(sentences, i18n.T(lang, "message.compare.price.more", s.label, price(diff), price(s.flight.Price)))
+			} else {
+				sentences = append(sentences, i18n.T(lang, "message.compare.price.same", s.label, price(s.flight.Price)))
+			}
+		}
+	case aspectDuration:
+		shortest := flightDuration(best.flight)
+		sentences = append(sentences, i18n.T(lang, "message.compare.duration.shortest", best.label, formatDuration(lang, shortest)))
+		for _, s := range sorted[1:] {
+			d := flightDuration(s.flight)
+			if diff := d - shortest; diff > 0 {
+				sentences = append(sentences, i18n.T(lang, "message.compare.duration.longer", s.label, formatDuration(lang, diff), formatDuration(lang, d)))
+			} else {
+				sentences = append(sentences, i18n.T(lang, "message.compare.duration.same", s.label, formatDuration(lang, d)))
+			}
+		}
+	default:
+		first := departure(best.flight)
+		sentences = append(sentences, i18n.T(lang, "message.compare.departure.first", best.label, o.departureText(best.flight)))
+		for _, s := range sorted[1:] {
+			if diff := departure(s.flight).Sub(first); diff > 0 {
+				sentences = append(sentences, i18n.T(lang, "message.compare.departure.later", s.label, formatDuration(lang, diff), o.departureText(s.flight)))
+			} else {
+				sentences = append(sentences, i18n.T(lang, "message.compare.departure.same", s.label, o.departureText(s.flight)))
+			}
+		}
+	}
+	return sentences
+}
+
+// departure returns when f leaves, or the zero time if its record doesn't say.
+func departure(f db.Flight) time.Time {
+	t, _ := time.Parse(time.RFC3339, f.DepartureTime)
+	return t
+}
+
+// flightDuration returns how long f takes, or 0 if its record doesn't say.
+func flightDuration(f db.Flight) time.Duration {
+	arrival, err := time.Parse(time.RFC3339, f.ArrivalTime)
+	if err != nil || departure(f).IsZero() {
+		return 0
+	}
+	return arrival.Sub(departure(f))
+}
+
+// formatDuration writes d in hours and minutes for readers of lang: "2h 05m".
+func formatDuration(lang string, d time.Duration) string {
+	minutes := int(d.Round(time.Minute) / time.Minute)
+	return i18n.T(lang, "duration.hours_minutes", minutes/60, minutes%60)
+}
@@ -0,0 +1,151 @@
+package orchestrator
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestDetectComparison(t *testing.T) {
+	for message, want := range map[string]comparison{
+		"Is FL101 or FL102 cheaper?":                                {numbers: []string{"FL101", "FL102"}, aspects: []string{aspectPrice}},
+		"compare fl101, fl103 and FL104":                            {numbers: []string{"FL101", "FL103", "FL104"}},
+		"FL101 vs FL102":                                            {numbers: []string{"FL101", "FL102"}},
+		"Which leaves first, FL102 or FL101?":                       {numbers: []string{"FL102", "FL101"}, aspects: []string{aspectDeparture}},
+		"¿Cuál es más barato y más rápido, FL101 o FL110?":          {numbers: []string{"FL101", "FL110"}, aspects: []string{aspectPrice, aspectDuration}},
+		"Madrid to Paris or Madrid to Barcelona: which is cheaper?": {routes: [][2]string{{"madrid", "paris"}, {"madrid", "barcelona"}}, aspects: []string{aspectPrice}},
+		"¿Qué es mejor, de Madrid a París o de Madrid a Valencia?":  {routes: [][2]string{{"madrid", "parís"}, {"madrid", "valencia"}}},
+	} {
+		got, ok := detectComparison(message)
+		if !ok || !slices.Equal(got.numbers, want.numbers) || !slices.Equal(got.routes, want.routes) || !slices.Equal(got.aspects, want.aspects) {
+			t.Errorf("detectComparison(%q) = %+v, %v, want %+v", message, got, ok, want)
+		}
+	}
+	// One flight or route, or several without asking to compare them, is no comparison.
+	for _, message := range []string{
+		"Is FL101 cheap?",
+		"Show me flights from Madrid to Paris",
+		"Which flights from Madrid to Paris are cheapest?",
+		"Book FL101 and FL102",
+	} {
+		if got, ok := detectComparison(message); ok {
+			t.Errorf("detectComparison(%q) = %+v, want no comparison", message, got)
+		}
+	}
+}
+
+func TestComparison(t *testing.T) {
+	for _, tt := range []struct {
+		message, answer string
+		flights         []string
+	}{
+		// The differences are worked out from the seeded flights: FL101 $120, 2h, 09:00;
+		// FL102 $150, 2h, 15:00; FL110 $200, 2h 30m.
+		{"Is FL101 or FL102 cheaper?", "FL101 is the cheapest, at $120.00. FL102 costs $30.00 more, at $150.00.", []string{"FL101", "FL102"}},
+		{"Is FL102 or FL101 cheaper?", "FL101 is the cheapest, at $120.00. FL102 costs $30.00 more, at $150.00.", []string{"FL101", "FL102"}},
+		{"Which is faster, FL110 or FL101?", "FL101 is the shortest, at 2h 00m. FL110 takes 0h 30m longer, at 2h 30m.", []string{"FL101", "FL110"}},
+		{"Which is quicker, FL101 or FL102?", "FL101 is the shortest, at 2h 00m. FL102 takes as long, 2h 00m.", []string{"FL101", "FL102"}},
+		{"Which is cheaper, Madrid to Paris or Madrid to Barcelona?",
+			"Madrid → Barcelona (FL105) is the cheapest, at $90.00. Madrid → Paris (FL103) costs $20.00 more, at $110.00.", []string{"FL103", "FL105"}},
+		{"¿Qué vuelo es más barato, el FL101 o el FL102?", "FL101 es el más barato, por 120,00 $. FL102 cuesta 30,00 $ más, 150,00 $.", []string{"FL101", "FL102"}},
+	} {
+		for _, stream := range []bool{false, true} {
+			o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+			events := process(t, o.Orchestrator, tt.message, Options{}, stream)
+
+			// A question about an aspect is answered as written, without calling the LLMs.
+			if answer := answerOf(events); answer != tt.answer {
+				t.Errorf("%q (stream %v): answer %q, want %q", tt.message, stream, answer, tt.answer)
+			}
+			if got := flightNumbers(events); !slices.Equal(got, tt.flights) {
+				t.Errorf("%q (stream %v): flights %v, want %v", tt.message, stream, got, tt.flights)
+			}
+			if n := len(o.llm1.Prompts()) + len(o.llm2.Prompts()) + len(o.llm3.Prompts()); n != 0 {
+				t.Errorf("%q (stream %v): %d LLM calls", tt.message, stream, n)
+			}
+			if intent := telemetryOf(t, events).Intent; intent != "compare" {
+				t.Errorf("%q (stream %v): intent %q", tt.message, stream, intent)
+			}
+		}
+	}
+}
+
+func TestComparisonDeparture(t *testing.T) {
+	o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+	answer := answerOf(process(t, o.Orchestrator, "Which leaves first, FL102 or FL101?", Options{}, false))
+	want := "FL101 departs first, at " + o.departureText(seededFlight(t, o, "FL101")) + ". FL102 departs 6h 00m later, at " + o.departureText(seededFlight(t, o, "FL102")) + "."
+	if answer != want {
+		t.Errorf("answer %q, want %q", answer, want)
+	}
+}
+
+// seededFlight returns the seeded flight number.
+func seededFlight(t *testing.T, o *testOrchestrator, number string) db.Flight {
+	t.Helper()
+	f, err := o.db.GetFlight(context.Background(), number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestComparisonMissingFlights(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+
+		// Unknown flights are named, and the ones found still compared.
+		events := process(t, o.Orchestrator, "Is FL101, FL999 or FL102 cheaper?", Options{}, stream)
+		if want := "We have no flight FL999. FL101 is the cheapest, at $120.00. FL102 costs $30.00 more, at $150.00."; answerOf(events) != want {
+			t.Errorf("stream %v: answer %q, want %q", stream, answerOf(events), want)
+		}
+
+		// With one left there is nothing to compare: it is described.
+		events = process(t, o.Orchestrator, "Is FL101 or FL999 cheaper?", Options{}, stream)
+		f := seededFlight(t, o, "FL101")
+		want := "We have no flight FL999. FL101: Madrid → Paris, departing " + o.departureText(f) + ", takes 2h 00m, costs $120.00. I need at least two flights we have to compare them."
+		if answerOf(events) != want {
+			t.Errorf("stream %v: answer %q, want %q", stream, answerOf(events), want)
+		}
+		events = process(t, o.Orchestrator, "Is FL998 or FL999 cheaper?", Options{}, stream)
+		if want := "We have no flight FL998. We have no flight FL999. I need at least two flights we have to compare them."; answerOf(events) != want || len(ofType(events, sse.TypeFlightResults)) != 0 {
+			t.Errorf("stream %v: answer %q, want %q", stream, answerOf(events), want)
+		}
+		events = process(t, o.Orchestrator, "Which is cheaper, Madrid to Paris or Madrid to Atlantis?", Options{}, stream)
+		if answer := answerOf(events); !strings.HasPrefix(answer, "We have no flights from Madrid to ") || !strings.Contains(answer, ". FL103: Madrid → Paris, departing ") {
+			t.Errorf("stream %v: answer %q", stream, answer)
+		}
+	}
+}
+
+func TestComparisonPhrased(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		// Without an aspect, LLM 3 answers from every fact, worked out here.
+		o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "FL103 is cheaper and leaves later.")
+		events := process(t, o.Orchestrator, "Compare FL101 and FL103", Options{}, stream)
+		if answer := answerOf(events); answer != "FL103 is cheaper and leaves later." {
+			t.Errorf("stream %v: answer %q", stream, answer)
+		}
+		prompts := o.llm3.Prompts()
+		if len(prompts) != 1 || len(o.llm1.Prompts())+len(o.llm2.Prompts()) != 0 {
+			t.Fatalf("stream %v: LLM 3 prompts %q", stream, prompts)
+		}
+		for _, fact := range []string{"Question: Compare FL101 and FL103", "FL103 is the cheapest, at $110.00.", "FL101 costs $10.00 more, at $120.00.",
+			"FL101 is the shortest, at 2h 00m. FL103 takes as long, 2h 00m.", "FL103 departs 25h 00m later"} {
+			if !strings.Contains(prompts[0], fact) {
+				t.Errorf("stream %v: prompt without %q: %q", stream, fact, prompts[0])
+			}
+		}
+
+		// When LLM 3 fails, the facts are the answer.
+		o = newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+		o.llm3.next = failingClient{}
+		answer := answerOf(process(t, o.Orchestrator, "Compare FL101 and FL103", Options{}, stream))
+		if !strings.HasPrefix(answer, "FL101: Madrid → Paris") || !strings.Contains(answer, "FL103 is the cheapest, at $110.00. FL101 costs $10.00 more, at $120.00.") {
+			t.Errorf("stream %v: answer after LLM 3 failed %q", stream, answer)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+)
+
+// stageCondensation names the LLM calls that summarize worker answers, in the query log.
+const stageCondensation = "condensation"
+
+// How a worker answer was condensed, as recorded in db.QueryLog.Condensed.
+const (
+	condensedExtract = "extract" // Its highest-ranked sentences were kept
+	condensedSummary = "summary" // An LLM summarized it
+)
+
+// Condensation shortens worker answers that are too long before they are pasted into the
+// aggregation prompt, so one verbose worker doesn't double the aggregation call's latency and
+// cost. See SetCondensation.
+type Condensation struct {
+	MaxTokens int  // Worker answers estimated at more tokens are condensed to this many; 0 never condenses
+	Summarize bool // Summarize with an LLM call, falling back to extraction, rather than only extract
+}
+
+// SetCondensation condenses the worker answers estimated at more than c.MaxTokens tokens (see
+// llmclient.EstimateTokens) before aggregation. By default their sentences are ranked, those
+// with flight numbers, prices and times and those sharing the answer's main words first, and
+// the best that fit are kept in their original order. With c.Summarize an LLM is asked for a
+// summary instead: the llmclient.RouteCondensation model if the router has one (see
+// SetRouter), LLM 1's otherwise; a failed or still too long summary is replaced by extraction.
+// The aggregation prompt says which answers were condensed. The query log keeps the original
+// answers with the worker calls, and records how each was condensed. It must be called before
+// the orchestrator serves requests.
+func (o *Orchestrator) SetCondensation(c Condensation) {
+	o.condensation = c
+}
+
+// condensedNotes tells the aggregator that a worker answer was condensed, by language.
+var condensedNotes = map[string]string{
+	LanguageEnglish: "Note: the %s above was condensed from a longer answer. Use the facts it keeps; do not mention that it was shortened.",
+	LanguageSpanish: "Nota: la %s anterior se resumió a partir de una respuesta más larga. Usa los datos que conserva; no menciones que se acortó.",
+}
+
+// condensedLabels names the worker answers in condensedNotes, as the aggregation prompts do.
+var condensedLabels = map[string][2]string{
+	LanguageEnglish: {"LLM1 Response", "LLM2 Response"},
+	LanguageSpanish: {"Respuesta de LLM1", "Respuesta de LLM2"},
+}
+
+// condenseAnswers returns the worker answers to paste into the aggregation prompt: each one as
+// it is, or condensed if it is too long, which entry records by stage.
+func (o *Orchestrator) condenseAnswers(ctx context.Context, entry *db.QueryLog, timings *stageTimings, llm1Resp, llm2Resp string) (string, string) {
+	limit := o.condensation.MaxTokens
+	if limit <= 0 {
+		return llm1Resp, llm2Resp
+	}
+	answers := [2]string{llm1Resp, llm2Resp}
+	methods := [2]string{}
+	var g errgroup.Group
+	for i, stage := range []string{stageLLM1, stageLLM2} {
+		tokens := llmclient.EstimateTokens(answers[i])
+		if tokens <= limit {
+			continue
+		}
+		g.Go(func() error {
+			answers[i], methods[i] = o.condense(ctx, timings, answers[i], limit)
+			slog.InfoContext(ctx, "Worker answer condensed for aggregation", "stage", stage, "method", methods[i],
+				"tokens", tokens, "condensed_tokens", llmclient.EstimateTokens(answers[i]), "max_tokens", limit)
+			return nil
+		})
+	}
+	_ = g.Wait()
+	for i, stage := range []string{stageLLM1, stageLLM2} {
+		if methods[i] != "" {
+			if entry.Condensed == nil {
+				entry.Condensed = make(map[string]string)
+			}
+			entry.Condensed[stage] = methods[i]
+		}
+	}
+	return answers[0], answers[1]
+}
+
+// condense shortens answer to at most maxTokens, returning it and how it was done.
+func (o *Orchestrator) condense(ctx context.Context, timings *stageTimings, answer string, maxTokens int) (string, string) {
+	if o.condensation.Summarize {
+		if summary, ok := o.summarize(ctx, timings, answer, maxTokens); ok {
+			return summary, condensedSummary
+		}
+	}
+	return extractSentences(answer, maxTokens), condensedExtract
+}
+
+// summarize asks an LLM to condense answer to at most maxTokens. ok is false if the call failed
+// or its summary is still too long.
+func (o *Orchestrator) summarize(ctx context.Context, timings *stageTimings, answer string, maxTokens int) (summary string, ok bool) {
+	client, model := o.llm1Client, o.models[stageLLM1]
+	if routed, found := o.router.Lookup(llmclient.RouteCondensation); found {
+		client, model = routed.Client, routed.Model
+	}
+	// Words run at about three tokens for every four, so this leaves the summary some room.
+	prompt := dataOnlyNotice(LanguageEnglish) + fmt.Sprintf(`Condense the following answer to at most %d words. Keep every flight number, city, price, time and duration exactly as written, keep its language, and leave out repetition and commentary. Reply with the condensed answer only.
+%s`, maxTokens/2, fence("ANSWER", sanitizeUntrusted(ctx, "condensed_answer", answer)))
+	start := time.Now()
+	callCtx := llmclient.WithResponseInfo(llmclient.WithMaxTokens(ctx, maxTokens))
+	summary, err := client.ChatCompletion(callCtx, prompt)
+	timings.called(callCtx, stageCondensation, model, prompt, summary, err, start)
+	switch {
+	case err != nil:
+		slog.WarnContext(ctx, "Summarizing a worker answer failed; extracting sentences instead", "error", err)
+		return "", false
+	case strings.TrimSpace(summary) == "" || llmclient.EstimateTokens(summary) > maxTokens:
+		slog.WarnContext(ctx, "Summary of a worker answer is empty or too long; extracting sentences instead", "tokens", llmclient.EstimateTokens(summary))
+		return "", false
+	}
+	return strings.TrimSpace(summary), true
+}
+
+// condensedNote is the paragraph added to an aggregation prompt in language when entry records
+// condensed worker answers, or "" when it records none.
+func condensedNote(language string, entry *db.QueryLog) string {
+	note, ok := condensedNotes[language]
+	if !ok {
+		language, note = LanguageEnglish, condensedNotes[LanguageEnglish]
+	}
+	var b strings.Builder
+	for i, stage := range []string{stageLLM1, stageLLM2} {
+		if entry.Condensed[stage] != "" {
+			b.WriteString("\n\n" + fmt.Sprintf(note, condensedLabels[language][i]))
+		}
+	}
+	return b.String()
+}
+
+var (
+	// sentenceEnd ends a sentence: a full stop, question or exclamation mark followed by a space,
+	// so the points of prices ("$120.50") and times ("10.30") don't.
+	sentenceEnd = regexp.MustCompile(`[.!?…]+\s+`)
+	// keyFact is a flight number, an amount of money, a time or a duration: what the aggregated
+	// answer can't do without.
+	keyFact = regexp.MustCompile(`(?i)\b[A-Z]{2}\d{2,4}\b|[$€£¥]\s?\d|\d(?:[.,]\d+)?\s?(?:€|\$|\b(?:usd|eur|gbp)\b)|\b\d{1,2}:\d{2}\b|\b\d+\s?(?:h|hrs?|hours?|horas?|min|minutes?|minutos?)\b`)
+	// rankedWord is a word counted in ranking sentences by the answer's main words.
+	rankedWord = regexp.MustCompile(`\p{L}{4,}`)
+)
+
+// sentence is one unit of text extractSentences keeps or leaves out.
+type sentence struct {
+	text      string
+	startLine bool // It starts a line of the original text
+	score     float64
+}
+
+// extractSentences shortens text to at most maxTokens (as llmclient.EstimateTokens counts
+// them) by keeping its highest-ranked sentences, in their original order. Each line is split
+// into sentences; list items are usually one each. A sentence ranks higher for each key fact
+// it has (see keyFact), for sharing the text's frequent words, and for opening the text; a
+// repeated sentence is kept once. If not even the best sentence fits, it is cut at a word
+// boundary.
+func extractSentences(text string, maxTokens int) string {
+	maxBytes := 4 * maxTokens // EstimateTokens is (bytes+3)/4
+	if len(text) <= maxBytes {
+		return text
+	}
+	var sentences []sentence
+	for _, line := range strings.Split(text, "\n") {
+		start := true
+		for _, s := range splitSentences(line) {
+			sentences = append(sentences, sentence{text: s, startLine: start})
+			start = false
+		}
+	}
+	if len(sentences) == 0 {
+		return ""
+	}
+
+	frequency := map[string]int{}
+	top := 1
+	for _, s := range sentences {
+		for _, word := range rankedWord.FindAllString(strings.ToLower(s.text), -1) {
+			frequency[word]++
+			top = max(top, frequency[word])
+		}
+	}
+	for i := range sentences {
+		s := &sentences[i]
+		words := rankedWord.FindAllString(strings.ToLower(s.text), -1)
+		if len(words) > 0 {
+			total := 0
+			for _, word := range words {
+				total += frequency[word]
+			}
+			s.score = float64(total) / float64(len(words)) / float64(top)
+		}
+		s.score += 2 * float64(len(keyFact.FindAllStringIndex(s.text, -1)))
+		if i == 0 {
+			s.score++
+		}
+	}
+
+	order := make([]int, len(sentences))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return sentences[order[a]].score > sentences[order[b]].score })
+	keep := make([]bool, len(sentences))
+	seen := map[string]bool{} // Repeated sentences are kept once
+	used, kept := 0, 0
+	for _, i := range order {
+		size := len(sentences[i].text)
+		if kept > 0 {
+			size++ // The space or line break before it
+		}
+		if used+size <= maxBytes && !seen[sentences[i].text] {
+			keep[i], used, kept = true, used+size, kept+1
+			seen[sentences[i].text] = true
+		}
+	}
+	if kept == 0 {
+		return cutAtWord(sentences[order[0]].text, maxBytes)
+	}
+
+	var b strings.Builder
+	for i, s := range sentences {
+		if !keep[i] {
+			continue
+		}
+		if b.Len() > 0 {
+			if s.startLine {
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(s.text)
+	}
+	return b.String()
+}
+
+// splitSentences splits one line of text into its sentences, trimmed, leaving out empty ones.
+func splitSentences(line string) []string {
+	var sentences []string
+	start := 0
+	for _, end := range sentenceEnd.FindAllStringIndex(line, -1) {
+		if s := strings.TrimSpace(line[start:end[1]]); s != "" {
+			sentences = append(sentences, s)
+		}
+		start = end[1]
+	}
+	if s := strings.TrimSpace(line[start:]); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+// cutAtWord returns the longest start of s of at most maxBytes that ends at a word boundary,
+// or at a character boundary if it has none.
+func cutAtWord(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	if space := strings.LastIndexByte(s[:cut], ' '); space > 0 {
+		cut = space
+	}
+	return strings.TrimSpace(s[:cut])
+}
@@ -0,0 +1,190 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// essay is a worker answer of about 2,000 words: a few sentences with the facts the answer
+// needs, lost among paragraphs of commentary.
+func essay() string {
+	var b strings.Builder
+	b.WriteString("Here is everything about flying from Madrid to Paris.\n")
+	filler := "Travelling between the two capitals has a long and interesting history that many travellers find fascinating to read about in detail. "
+	for i := range 6 {
+		for range 12 {
+			b.WriteString(filler)
+		}
+		b.WriteString("\n")
+		switch i {
+		case 1:
+			b.WriteString("FL101 leaves Madrid at 08:00 and costs €120.\n")
+		case 3:
+			b.WriteString("FL103 leaves Madrid at 14:30 and costs €110, a flight of 2h.\n")
+		}
+	}
+	return b.String()
+}
+
+// section returns the fenced text labelled label in prompt.
+func section(t *testing.T, prompt, label string) string {
+	t.Helper()
+	_, rest, ok := strings.Cut(prompt, "<<<BEGIN "+label+">>>\n")
+	text, _, closed := strings.Cut(rest, "\n<<<END "+label+">>>")
+	if !ok || !closed {
+		t.Fatalf("prompt has no %s:\n%s", label, prompt)
+	}
+	return text
+}
+
+func TestExtractSentences(t *testing.T) {
+	long := essay()
+	got := extractSentences(long, 100)
+	if llmclient.EstimateTokens(got) > 100 {
+		t.Errorf("extracted %d tokens, want at most 100:\n%s", llmclient.EstimateTokens(got), got)
+	}
+	for _, fact := range []string{"FL101 leaves Madrid at 08:00 and costs €120.", "FL103 leaves Madrid at 14:30 and costs €110, a flight of 2h."} {
+		if !strings.Contains(got, fact) {
+			t.Errorf("extract lost %q:\n%s", fact, got)
+		}
+	}
+	// The kept sentences are in their original order, each once.
+	if strings.Index(got, "FL101") > strings.Index(got, "FL103") || strings.Count(got, "history") > 1 {
+		t.Errorf("extract:\n%s", got)
+	}
+
+	for _, tt := range []struct {
+		text      string
+		maxTokens int
+		want      string
+	}{
+		{"Short enough.", 10, "Short enough."},
+		{"", 10, ""},
+		// One sentence too long is cut at a word.
+		{"FL101 leaves Madrid at 08:00 every single day of the week", 5, "FL101 leaves Madrid"},
+		// Prices and times don't end sentences; lines start new ones.
+		{"Filler words here and there again. FL101 costs $120.50 at 10.30 today.\nMore filler words again here.", 10, "FL101 costs $120.50 at 10.30 today."},
+		{"Días de sol y playa para todos. FL102 sale a las 19:15 y cuesta 89,50 €. Más texto aquí.", 12, "FL102 sale a las 19:15 y cuesta 89,50 €."},
+	} {
+		if got := extractSentences(tt.text, tt.maxTokens); got != tt.want {
+			t.Errorf("extractSentences(%q, %d) = %q, want %q", tt.text, tt.maxTokens, got, tt.want)
+		}
+	}
+}
+
+func TestCutAtWord(t *testing.T) {
+	for _, tt := range []struct {
+		text     string
+		maxBytes int
+		want     string
+	}{
+		{"short", 10, "short"},
+		{"two words", 6, "two"},
+		{"unbroken", 4, "unbr"},
+		{"año", 2, "a"}, // Not in the middle of a character
+	} {
+		if got := cutAtWord(tt.text, tt.maxBytes); got != tt.want {
+			t.Errorf("cutAtWord(%q, %d) = %q, want %q", tt.text, tt.maxBytes, got, tt.want)
+		}
+	}
+}
+
+func TestCondensedAggregation(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "FL101 and FL103 fly to Paris.", essay(), "FL101 and FL103.")
+		o.SetCondensation(Condensation{MaxTokens: 150})
+		o.EnableQueryLog(nil)
+		o.StorePrompts()
+		requestID := fmt.Sprintf("req-condensed-%v", stream)
+		events := make(chan sse.Event, 1024)
+		ctx := logging.WithRequestID(context.Background(), requestID)
+		if stream {
+			o.ProcessMessageStream(ctx, "Flights from Madrid to Paris", Options{}, events)
+		} else {
+			o.ProcessMessage(ctx, "Flights from Madrid to Paris", Options{}, events)
+		}
+		sent := drain(events)
+
+		// The long answer is condensed within the threshold, keeping its facts; the short one isn't.
+		prompt := o.llm3.Prompts()[0]
+		condensed := section(t, prompt, "LLM2 RESPONSE")
+		if tokens := llmclient.EstimateTokens(condensed); tokens > 150 {
+			t.Errorf("stream %v: LLM2 answer in the aggregation prompt has %d tokens", stream, tokens)
+		}
+		for _, fact := range []string{"FL101", "€120", "08:00", "FL103", "€110", "14:30"} {
+			if !strings.Contains(condensed, fact) {
+				t.Errorf("stream %v: condensed answer lost %q:\n%s", stream, fact, condensed)
+			}
+		}
+		if section(t, prompt, "LLM1 RESPONSE") != "FL101 and FL103 fly to Paris." {
+			t.Errorf("stream %v: the short answer changed", stream)
+		}
+		if !strings.Contains(prompt, "the LLM2 Response above was condensed") || strings.Contains(prompt, "the LLM1 Response above") {
+			t.Errorf("stream %v: prompt's condensation notes:\n%s", stream, prompt)
+		}
+
+		// The telemetry says how, and the query log keeps the original.
+		if got := telemetryOf(t, sent).Condensed; len(got) != 1 || got[stageLLM2] != condensedExtract {
+			t.Errorf("stream %v: telemetry condensed %v", stream, got)
+		}
+		entry := queryLogOf(t, o, requestID)
+		for _, call := range entry.Calls {
+			if call.Stage == stageLLM2 && call.Response != essay() {
+				t.Errorf("stream %v: query log keeps LLM2's answer as %d bytes, want the original", stream, len(call.Response))
+			}
+		}
+		if entry.Condensed[stageLLM2] != condensedExtract {
+			t.Errorf("stream %v: query log condensed %v", stream, entry.Condensed)
+		}
+	}
+}
+
+func TestCondensationOff(t *testing.T) {
+	o := newTestOrchestrator(t, "FL101.", essay(), "FL101.")
+	events := process(t, o.Orchestrator, "Flights from Madrid to Paris", Options{}, false)
+	if got := section(t, o.llm3.Prompts()[0], "LLM2 RESPONSE"); got != strings.TrimRight(essay(), "\n") {
+		t.Errorf("LLM2 answer condensed with condensation off: %d bytes", len(got))
+	}
+	if got := telemetryOf(t, events).Condensed; got != nil {
+		t.Errorf("telemetry condensed %v", got)
+	}
+}
+
+func TestCondensedSummary(t *testing.T) {
+	summary := "FL101 leaves at 08:00 for €120; FL103 at 14:30 for €110."
+	for _, tt := range []struct {
+		name       string
+		summarizer llmclient.LLMClient
+		want       string
+	}{
+		{"summary", &llmclient.MockClient{Response: summary}, condensedSummary},
+		{"failed summary", failingClient{}, condensedExtract},
+		{"summary too long", verboseClient{answer: essay()}, condensedExtract},
+	} {
+		o := newTestOrchestrator(t, "FL101 and FL103.", essay(), "FL101 and FL103.")
+		o.SetCondensation(Condensation{MaxTokens: 150, Summarize: true})
+		summarizer := &recordingClient{next: tt.summarizer}
+		router := llmclient.NewRouter()
+		router.Route(llmclient.RouteCondensation, "small-model", summarizer)
+		o.SetRouter(router)
+		events := process(t, o.Orchestrator, "Flights from Madrid to Paris", Options{}, true)
+
+		prompts := summarizer.Prompts()
+		if len(prompts) != 1 || !strings.Contains(prompts[0], "FL103 leaves Madrid at 14:30") || !strings.Contains(prompts[0], "at most 75 words") {
+			t.Errorf("%s: summarizer prompts %q", tt.name, prompts)
+		}
+		if got := telemetryOf(t, events).Condensed[stageLLM2]; got != tt.want {
+			t.Errorf("%s: condensed by %q, want %q", tt.name, got, tt.want)
+		}
+		condensed := section(t, o.llm3.Prompts()[0], "LLM2 RESPONSE")
+		if tt.want == condensedSummary && condensed != summary || llmclient.EstimateTokens(condensed) > 150 {
+			t.Errorf("%s: aggregation prompt has %q", tt.name, condensed)
+		}
+	}
+}
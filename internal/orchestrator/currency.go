@@ -0,0 +1,73 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/Cris245/go-llm-chat/internal/currency"
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// SetCurrency replaces the converter for flight prices, which by default takes them to be in
+// US dollars and uses currency.DefaultRates. A flight question that names a currency ("under
+// 100 pounds", "menos de 200 €") has its price limit converted into the converter's base
+// currency before the search, and its prices shown in the currency asked for, with the stored
+// price after them in parentheses. It must be called before the orchestrator serves requests.
+func (o *Orchestrator) SetCurrency(converter *currency.Converter) {
+	o.currency = converter
+}
+
+// applyCurrency reads the currency of the flight question in entry from userMessage. A limit
+// in another currency is converted into the base currency, where the search compares it with
+// the stored prices, and the currency is recorded in entry.Currency for displayPrice. A
+// currency without a rate can't be honoured either way: the question is answered in the base
+// currency without a limit, rather than with a limit in the wrong currency, and the user is
+// told so. A question that names no currency is answered in the session's preferred one, if
+// prefs has one.
+func (o *Orchestrator) applyCurrency(ctx context.Context, entry *db.QueryLog, userMessage, lang string, prefs *db.Preferences, eventChan chan<- sse.Event) {
+	code, ok := currency.Detect(userMessage)
+	preferred := !ok && prefs != nil && prefs.Currency != ""
+	if preferred {
+		code, ok = prefs.Currency, true
+	}
+	if !ok || code == o.currency.Base() {
+		return
+	}
+	// Checked with a unit amount even without a limit, so prices aren't promised in a
+	// currency that can't be shown either.
+	amount := max(entry.MaxPrice, 1)
+	converted, err := o.currency.Convert(ctx, amount, code, o.currency.Base())
+	if err != nil {
+		if !errors.Is(err, currency.ErrUnknownCurrency) {
+			slog.WarnContext(ctx, "Currency conversion failed", "currency", code, "error", err)
+		}
+		entry.MaxPrice = 0
+		eventChan <- sse.Status(i18n.T(lang, "status.currency_unknown", code, o.currency.Base()))
+		return
+	}
+	entry.Currency = code
+	if preferred {
+		entry.Preferences = append(entry.Preferences, preferenceCurrency)
+	}
+	if entry.MaxPrice > 0 {
+		entry.MaxPrice = converted
+	}
+}
+
+// displayPrice formats price, in the base currency, for a reader of lang. In another display
+// currency it is converted and rounded to that currency's units, with the stored price in
+// parentheses: "£94.80 ($120.00)".
+func (o *Orchestrator) displayPrice(ctx context.Context, price float64, display, lang string) string {
+	base := currency.Format(price, o.currency.Base(), lang)
+	if display == "" {
+		return base
+	}
+	converted, err := o.currency.Convert(ctx, price, o.currency.Base(), display)
+	if err != nil {
+		return base
+	}
+	return currency.Format(converted, display, lang) + " (" + base + ")"
+}
@@ -0,0 +1,66 @@
+package orchestrator
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestCurrencyConversion(t *testing.T) {
+	for _, tt := range []struct {
+		name, message string
+		currency      string
+		limit         float64 // In dollars
+		flights       []string
+		price         string // The first flight's price as shown to the workers
+	}{
+		{"pounds", "Flights from Madrid to Paris under 110 pounds", "GBP", 110 / 0.79,
+			[]string{"FL101", "FL103", "FL104"}, "£94.80 ($120.00)"},
+		{"euros in Spanish", "Vuelos desde Madrid a París por menos de 120 euros", "EUR", 120 / 0.92,
+			[]string{"FL101", "FL103", "FL104"}, "110,40 € (120,00 $)"},
+		{"dollars", "Flights from Madrid to Paris under $110", "", 110,
+			[]string{"FL103"}, "$110.00"},
+		{"unknown currency", "Flights from Madrid to Paris under 110 INR", "", 0,
+			[]string{"FL101", "FL102", "FL103", "FL104"}, "$120.00"},
+	} {
+		o := newTestOrchestrator(t, "The flights.", "Their prices.", "The answer.")
+		o.EnableQueryLog(nil)
+		ctx := logging.WithRequestID(context.Background(), "req-"+tt.name)
+		events := make(chan sse.Event, 1024)
+		o.ProcessMessage(ctx, tt.message, Options{}, events)
+		got := drain(events)
+
+		entry := queryLogOf(t, o, "req-"+tt.name)
+		if entry.Currency != tt.currency || math.Abs(entry.MaxPrice-tt.limit) > 1e-9 {
+			t.Errorf("%s: currency %q and limit %v, want %q and %v", tt.name, entry.Currency, entry.MaxPrice, tt.currency, tt.limit)
+		}
+		// The limit, converted into dollars, filters the stored prices.
+		var found []string
+		for _, ev := range ofType(got, sse.TypeFlightResults) {
+			for _, f := range ev.Payload.([]db.Flight) {
+				found = append(found, f.FlightNumber)
+			}
+		}
+		slices.Sort(found)
+		if !slices.Equal(found, tt.flights) {
+			t.Errorf("%s: flights %v, want %v", tt.name, found, tt.flights)
+		}
+		if prompts := o.llm1.Prompts(); len(prompts) != 1 || !strings.Contains(prompts[0], "price "+tt.price+"\n") {
+			t.Errorf("%s: worker prompt %q, want prices shown as %s", tt.name, prompts, tt.price)
+		}
+
+		unknown := false
+		for _, ev := range ofType(got, sse.TypeStatus) {
+			unknown = unknown || strings.Contains(ev.Data, "INR")
+		}
+		if want := tt.name == "unknown currency"; unknown != want {
+			t.Errorf("%s: told the currency is unknown: %v, want %v", tt.name, unknown, want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// EnableDBOnly answers every request from the database alone, without a single LLM call:
+// flight questions get their search results as written here (see answerFromRecords), the
+// answers to route questions and comparisons are sent as written, and other questions are told
+// that only flight questions can be answered. Requests that turn on the flags.DBOnly flag are
+// answered the same way. It must be called before the orchestrator serves requests.
+func (o *Orchestrator) EnableDBOnly() {
+	o.dbOnly = true
+}
+
+// answerFromRecords answers a flight question with the flights found, one line each, written
+// here rather than by the LLMs; stream sends the answer in chunks.
+func (o *Orchestrator) answerFromRecords(ctx context.Context, entry *db.QueryLog, lang string, flights []db.Flight, stream bool, eventChan chan<- sse.Event) {
+	lines := make([]string, 0, len(flights)+1)
+	if len(flights) == 1 {
+		lines = append(lines, i18n.T(lang, "message.db_only.flights.one"))
+	} else {
+		lines = append(lines, i18n.T(lang, "message.db_only.flights", len(flights)))
+	}
+	for _, f := range flights {
+		lines = append(lines, "- "+i18n.T(lang, "message.db_only.flight", f.FlightNumber,
+			db.PlaceName(f.Origin, f.OriginAirport), db.PlaceName(f.Destination, f.DestinationAirport),
+			o.departureText(f), o.arrivalText(f), formatDuration(lang, flightDuration(f)),
+			o.priceText(ctx, entry, lang, f.Price), f.AvailableSeats))
+	}
+	answer := strings.Join(lines, "\n")
+	if stream {
+		o.streamAnswer(ctx, entry, lang, answer, eventChan)
+		return
+	}
+	o.sendAnswer(ctx, entry, lang, answer, eventChan)
+}
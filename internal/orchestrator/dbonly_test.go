@@ -0,0 +1,72 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/flags"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestDBOnly(t *testing.T) {
+	store := db.NewMemoryClient()
+	if err := store.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// No LLM clients at all: a call would panic.
+	o := NewOrchestrator(nil, nil, nil, store)
+	o.EnableDBOnly()
+	if err := o.cities.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, stream := range []bool{false, true} {
+		for _, tt := range []struct {
+			message, lang string
+			want          []string // In the answer
+		}{
+			{"Flights from Tokyo to Los Angeles", "en", []string{
+				"I found 1 flight:\n- FL118: Tokyo (HND) → Los Angeles (LAX), departs 2025-08-20 11:00 JST / 02:00 UTC, arrives 2025-08-20 05:00 PDT / 12:00 UTC, takes 10h 00m,",
+				"250 seats left",
+			}},
+			{"Flights from Madrid to Paris", "en", []string{"I found 4 flights:\n- FL101: Madrid → Paris,", "- FL104: Madrid → Paris,"}},
+			{"vuelos de Madrid a París", "es", []string{"FL101", "FL104"}},
+			{"Compare FL101 and FL102", "en", []string{"FL101", "FL102"}},
+			{"What is the capital of France?", "en", []string{i18n.T("en", "message.db_only.general")}},
+			{"Hola, ¿qué tal?", "es", []string{i18n.T("es", "message.db_only.general")}},
+		} {
+			events := process(t, o, tt.message, Options{}, stream)
+			answer := answerOf(events)
+			for _, want := range tt.want {
+				if !strings.Contains(answer, want) {
+					t.Errorf("stream %v: %q answered %q, want %q in it", stream, tt.message, answer, want)
+				}
+			}
+			if done := events[len(events)-1]; done.Type != sse.TypeDone || done.Payload.(sse.DonePayload).Outcome != sse.OutcomeOK {
+				t.Errorf("stream %v: %q ended with %+v", stream, tt.message, done)
+			}
+		}
+	}
+	// The flights are sent as the structured event too.
+	events := process(t, o, "Flights from Madrid to Paris", Options{}, true)
+	if got := flightNumbers(events); len(got) != 4 {
+		t.Errorf("flight results %v", got)
+	}
+}
+
+func TestDBOnlyFlag(t *testing.T) {
+	o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+	events := process(t, o.Orchestrator, "Flights from Madrid to Paris", Options{Flags: flags.Set{flags.DBOnly}}, true)
+	if answer := answerOf(events); !strings.HasPrefix(answer, "I found 4 flights:") {
+		t.Errorf("answer with the flag %q", answer)
+	}
+	if len(o.llm1.Prompts())+len(o.llm2.Prompts())+len(o.llm3.Prompts()) != 0 {
+		t.Error("LLMs called with the db_only flag")
+	}
+	// Without it, the LLMs answer.
+	if answer := answerOf(process(t, o.Orchestrator, "Flights from Madrid to Paris", Options{}, true)); answer != "LLM 3." {
+		t.Errorf("answer without the flag %q", answer)
+	}
+}
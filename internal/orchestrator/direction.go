@@ -0,0 +1,115 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// The words a flight question marks its origin and destination with, as the city extraction
+// reads them.
+var (
+	originMarkers      = []string{"from ", "desde "}
+	destinationMarkers = []string{"to ", " a ", "hacia "}
+)
+
+// confirmationPattern matches a lowercased message that only says yes: "yes", "sí, por favor",
+// "ok!".
+var confirmationPattern = regexp.MustCompile(`^(?:yes|yeah|yep|sure|ok|okay|correct|right|exactly|sí|si|vale|claro|exacto|correcto)(?:[\s\pP]+(?:please|thanks|por favor|gracias))?[\s\pP]*$`)
+
+// directionConfident reports whether the folded message (see foldCity) marks the direction of
+// the route it was read as unambiguously: the origin comes after "from" or "desde" and the
+// destination after "to", "a" or "hacia", and neither the other way round. A city the
+// extraction only guessed, such as a destination named without a preposition, leaves it
+// unsure; one the message doesn't name (empty) doesn't. names are the names the extraction
+// knew the cities by, mapped to the cities.
+func directionConfident(folded, origin, destination string, names map[string]string) bool {
+	marked := func(city string, markers []string) bool {
+		for name, canon := range names {
+			if canon == city && markedWith(folded, name, markers) {
+				return true
+			}
+		}
+		return false
+	}
+	return (origin == "" || marked(origin, originMarkers) && !marked(origin, destinationMarkers)) &&
+		(destination == "" || marked(destination, destinationMarkers) && !marked(destination, originMarkers))
+}
+
+// suggestReverse offers the reverse of the route of the flight question in entry when the
+// question's direction looks wrong: the search found nothing (found is the number of flights
+// it found) or the direction was unsure, and the reverse route has more flights. The
+// suggestion is saved in the session's preferences, prefs, for the next message to confirm
+// (see takeSuggestedRoute), and the answer asks about it. It reports whether it answered; it
+// doesn't without a session to remember the suggestion in, or when the reverse search fails.
+func (o *Orchestrator) suggestReverse(ctx context.Context, entry *db.QueryLog, lang string, found int, confident bool, prefs *db.Preferences, eventChan chan<- sse.Event) bool {
+	if prefs == nil || entry.Origin == "" || entry.Destination == "" || (found > 0 && confident) {
+		return false
+	}
+	q := flightQuery(entry)
+	q.Origin, q.Destination, q.OriginAirport, q.DestinationAirport = q.Destination, q.Origin, q.DestinationAirport, q.OriginAirport
+	reverse, err := o.dbClient.QueryFlights(ctx, q)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to search the reverse route", "error", err)
+		return false
+	}
+	if len(reverse) <= found {
+		return false
+	}
+	updated := *prefs
+	updated.SuggestedRoute = &db.SuggestedRoute{
+		Origin: entry.Destination, Destination: entry.Origin, MaxPrice: entry.MaxPrice, Currency: entry.Currency,
+		OriginAirport: entry.DestinationAirport, DestinationAirport: entry.OriginAirport,
+	}
+	updated.UpdatedAt = time.Now().UTC()
+	if err := o.dbClient.SavePreferences(ctx, updated); err != nil {
+		// A "yes" couldn't be resolved, so the question is answered as asked.
+		slog.WarnContext(ctx, "Failed to save the suggested route", "session_id", prefs.SessionID, "error", err)
+		return false
+	}
+	slog.InfoContext(ctx, "Reverse route suggested", "session_id", prefs.SessionID,
+		"origin", entry.Origin, "destination", entry.Destination, "found", found, "reverse", len(reverse), "confident", confident)
+	from, to := db.PlaceName(entry.Destination, entry.DestinationAirport), db.PlaceName(entry.Origin, entry.OriginAirport)
+	question := i18n.T(lang, "message.route_reversed", from, to, len(reverse))
+	if len(reverse) == 1 {
+		question = i18n.T(lang, "message.route_reversed.one", from, to)
+	}
+	o.sendAnswer(ctx, entry, lang, question, eventChan)
+	return true
+}
+
+// takeSuggestion makes the flight question in entry the search of suggested: its route,
+// airports, price limit and currency.
+func takeSuggestion(entry *db.QueryLog, suggested *db.SuggestedRoute) {
+	entry.Origin, entry.Destination, entry.MaxPrice, entry.Currency = suggested.Origin, suggested.Destination, suggested.MaxPrice, suggested.Currency
+	entry.OriginAirport, entry.DestinationAirport = suggested.OriginAirport, suggested.DestinationAirport
+}
+
+// takeSuggestedRoute resolves the route the session's last answer suggested, if any: it
+// returns the route when the lowercased message confirms it, and nil otherwise. Either way the
+// suggestion is dropped from the session's preferences, so it is only offered to the message
+// right after it; the preferences the rest of the request uses are returned. A failure to save
+// is logged and otherwise ignored.
+func (o *Orchestrator) takeSuggestedRoute(ctx context.Context, prefs *db.Preferences, lower string) (*db.SuggestedRoute, *db.Preferences) {
+	if prefs == nil || prefs.SuggestedRoute == nil {
+		return nil, prefs
+	}
+	suggested := prefs.SuggestedRoute
+	updated := *prefs
+	updated.SuggestedRoute = nil
+	updated.UpdatedAt = time.Now().UTC()
+	if err := o.dbClient.SavePreferences(ctx, updated); err != nil {
+		slog.WarnContext(ctx, "Failed to drop the suggested route", "session_id", prefs.SessionID, "error", err)
+	}
+	if !confirmationPattern.MatchString(strings.TrimSpace(lower)) {
+		return nil, &updated
+	}
+	slog.InfoContext(ctx, "Suggested route confirmed", "session_id", prefs.SessionID, "origin", suggested.Origin, "destination", suggested.Destination)
+	return suggested, &updated
+}
@@ -0,0 +1,149 @@
+package orchestrator
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// flightNumbers returns the numbers of the flights the FlightResults events gave.
+func flightNumbers(events []sse.Event) []string {
+	var numbers []string
+	for _, ev := range ofType(events, sse.TypeFlightResults) {
+		for _, f := range ev.Payload.([]db.Flight) {
+			numbers = append(numbers, f.FlightNumber)
+		}
+	}
+	slices.Sort(numbers)
+	return numbers
+}
+
+func TestDirectionConfident(t *testing.T) {
+	names := map[string]string{"madrid": "Madrid", "paris": "Paris", "parís": "Paris", "roma": "Rome"}
+	for _, tt := range []struct {
+		folded, origin, destination string
+		want                        bool
+	}{
+		{"flights from madrid to paris", "Madrid", "Paris", true},
+		{"flights to madrid from paris", "Paris", "Madrid", true},
+		{"vuelos a madrid desde parís", "Paris", "Madrid", true},
+		{"vuelos hacia roma", "", "Rome", true},
+		{"flights from paris madrid", "Paris", "Madrid", false},
+		{"flights paris madrid", "Paris", "Madrid", false},
+		// Read the other way round from what the prepositions say.
+		{"flights from madrid to paris", "Paris", "Madrid", false},
+	} {
+		if got := directionConfident(tt.folded, tt.origin, tt.destination, names); got != tt.want {
+			t.Errorf("directionConfident(%q, %s → %s) = %v, want %v", tt.folded, tt.origin, tt.destination, got, tt.want)
+		}
+	}
+}
+
+func TestConfirmationPattern(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"yes":            true,
+		"yes please":     true,
+		"ok!":            true,
+		"sí, por favor":  true,
+		"vale, gracias":  true,
+		"claro.":         true,
+		"no":             false,
+		"yes, to rome":   false,
+		"yesterday":      false,
+		"show me others": false,
+	} {
+		if got := confirmationPattern.MatchString(msg); got != want {
+			t.Errorf("%q: %v, want %v", msg, got, want)
+		}
+	}
+}
+
+func TestReversedRoute(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		for _, msg := range []string{"Flights from Paris to Madrid", "Show me flights to Madrid from Paris"} {
+			o := newTestOrchestrator(t, "FL103 is cheapest.", "FL101 is earliest.", "Take FL103.")
+			opts := Options{SessionID: "session-rev", Preferences: &db.Preferences{SessionID: "session-rev"}}
+
+			// Nothing flies that way, and the reverse has four flights: the answer asks about them.
+			events := process(t, o.Orchestrator, msg, opts, stream)
+			want := `Did you mean Madrid → Paris? I found 4 flights that way. Reply "yes" to see them.`
+			if answer := answerOf(events); answer != want {
+				t.Errorf("stream %v, %q: answer %q, want %q", stream, msg, answer, want)
+			}
+			if len(o.llm1.Prompts())+len(o.llm2.Prompts())+len(o.llm3.Prompts()) != 0 || len(ofType(events, sse.TypeFlightResults)) != 0 {
+				t.Errorf("stream %v, %q: answered before the confirmation", stream, msg)
+			}
+			saved := savedPreferences(t, o, "session-rev")
+			if r := saved.SuggestedRoute; r == nil || r.Origin != "Madrid" || r.Destination != "Paris" {
+				t.Fatalf("stream %v, %q: suggested %+v", stream, msg, r)
+			}
+
+			// "Yes" searches the suggested route, and uses up the suggestion.
+			events = process(t, o.Orchestrator, "Yes", Options{SessionID: "session-rev", Preferences: saved}, stream)
+			if numbers := flightNumbers(events); !slices.Equal(numbers, []string{"FL101", "FL102", "FL103", "FL104"}) {
+				t.Errorf("stream %v, %q: yes found %v", stream, msg, numbers)
+			}
+			if answer := answerOf(events); answer != "Take FL103." {
+				t.Errorf("stream %v, %q: yes answered %q", stream, msg, answer)
+			}
+			if saved := savedPreferences(t, o, "session-rev"); saved.SuggestedRoute != nil {
+				t.Errorf("stream %v, %q: suggestion kept after the yes: %+v", stream, msg, saved.SuggestedRoute)
+			}
+		}
+	}
+}
+
+func TestReversedRouteKeepsLimits(t *testing.T) {
+	o := newTestOrchestrator(t, "FL103.", "FL101.", "FL103 is cheapest.")
+	opts := Options{SessionID: "session-rev", Preferences: &db.Preferences{SessionID: "session-rev"}}
+	events := process(t, o.Orchestrator, "Flights from Paris to Madrid under $125", opts, false)
+	if answer := answerOf(events); answer != `Did you mean Madrid → Paris? I found 2 flights that way. Reply "yes" to see them.` {
+		t.Errorf("answer %q", answer)
+	}
+	events = process(t, o.Orchestrator, "sí", Options{SessionID: "session-rev", Preferences: savedPreferences(t, o, "session-rev")}, false)
+	if numbers := flightNumbers(events); !slices.Equal(numbers, []string{"FL101", "FL103"}) {
+		t.Errorf("yes found %v, want the two under $125", numbers)
+	}
+}
+
+func TestReversedRouteUnsure(t *testing.T) {
+	o := newTestOrchestrator(t, "FL109.", "FL109.", "FL109 it is.")
+	extra := []db.Flight{
+		{FlightNumber: "FL901", Origin: "Paris", Destination: "Rome", DepartureTime: "2026-03-01T08:00:00Z", ArrivalTime: "2026-03-01T10:00:00Z", Price: 110, AvailableSeats: 9},
+		{FlightNumber: "FL902", Origin: "Paris", Destination: "Rome", DepartureTime: "2026-03-01T18:00:00Z", ArrivalTime: "2026-03-01T20:00:00Z", Price: 130, AvailableSeats: 9},
+	}
+	if err := o.db.InsertFlights(context.Background(), extra); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{SessionID: "session-rev", Preferences: &db.Preferences{SessionID: "session-rev"}}
+
+	// Rome → Paris has one flight, but "Paris" has no preposition and the reverse has two.
+	events := process(t, o.Orchestrator, "Flights from Rome Paris", opts, false)
+	if answer := answerOf(events); answer != `Did you mean Paris → Rome? I found 2 flights that way. Reply "yes" to see them.` {
+		t.Errorf("unsure: answer %q", answer)
+	}
+
+	// Any other message drops the suggestion and is answered as asked.
+	events = process(t, o.Orchestrator, "Flights from Rome to Paris", Options{SessionID: "session-rev", Preferences: savedPreferences(t, o, "session-rev")}, false)
+	if numbers := flightNumbers(events); !slices.Equal(numbers, []string{"FL109"}) {
+		t.Errorf("clear direction found %v", numbers)
+	}
+	if saved := savedPreferences(t, o, "session-rev"); saved.SuggestedRoute != nil {
+		t.Errorf("suggestion kept: %+v", saved.SuggestedRoute)
+	}
+	events = process(t, o.Orchestrator, "yes", Options{SessionID: "session-rev", Preferences: savedPreferences(t, o, "session-rev")}, false)
+	if len(ofType(events, sse.TypeFlightResults)) != 0 {
+		t.Errorf("a late yes searched %v", flightNumbers(events))
+	}
+}
+
+func TestReversedRouteWithoutSession(t *testing.T) {
+	o := newTestOrchestrator(t, "A.", "B.", "C.")
+	events := process(t, o.Orchestrator, "Flights from Paris to Madrid", Options{}, false)
+	if answer := answerOf(events); answer != "No flights found for your query." {
+		t.Errorf("answer %q", answer)
+	}
+}
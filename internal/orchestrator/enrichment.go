@@ -0,0 +1,126 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+	"github.com/Cris245/go-llm-chat/internal/weather"
+)
+
+// stageWeather is the weather lookup, as named in Telemetry.StagesMs. It runs alongside the
+// workers, so it only adds to a request's duration when it is the slower of the two.
+const stageWeather = "weather"
+
+// WeatherEnrichment adds the forecast at the destination to flight answers. See SetWeather.
+type WeatherEnrichment struct {
+	Provider weather.Provider
+	Always   bool          // Enrich every flight answer with a destination, not only weather questions
+	Timeout  time.Duration // Bounds the lookup; 0 leaves it to the request's deadline
+}
+
+// SetWeather turns on weather enrichment. A flight question with a destination that also asks
+// about the weather (or any such question, with Always) gets the forecast for the destination
+// on the day of arrival: it is sent as an Enrichment event and given to the aggregation prompt.
+// The lookup runs while the workers answer; if it fails, the answer is given without it. It
+// must be called before the orchestrator serves requests.
+func (o *Orchestrator) SetWeather(enrichment WeatherEnrichment) {
+	o.weather = enrichment
+}
+
+// weatherQuestion matches messages that ask about the weather, in English or Spanish. Words are
+// delimited by non-letters rather than \b, which doesn't treat accented letters as letters.
+var weatherQuestion = regexp.MustCompile(`(?i)(?:^|\PL)(weather|forecast|temperatures?|rain(s|y|ing)?|sunny|snow(s|ing)?|cold|warm|hot|umbrella|clima|tiempo (hace|hará|habrá)|temperaturas?|lluvia|llover|llueve|lloverá|pronóstico|soleado|nieve|frío|calor|paraguas)(?:\PL|$)`)
+
+// weatherLookup is a forecast being fetched while the workers run.
+type weatherLookup struct {
+	done     chan struct{}
+	forecast weather.Forecast
+	err      error
+}
+
+// lookupWeather starts fetching the forecast for the destination of the flight question in
+// entry on the earliest arrival among flights, if weather enrichment is on and wanted. It
+// returns nil when there is nothing to look up.
+func (o *Orchestrator) lookupWeather(ctx context.Context, entry *db.QueryLog, userMessage string, flights []db.Flight, timings *stageTimings) *weatherLookup {
+	if o.weather.Provider == nil || entry.Destination == "" {
+		return nil
+	}
+	if !o.weather.Always && !weatherQuestion.MatchString(userMessage) {
+		return nil
+	}
+	arrival, ok := earliestArrival(flights, entry.Destination)
+	if !ok {
+		return nil
+	}
+
+	lookup := &weatherLookup{done: make(chan struct{})}
+	go func() {
+		defer close(lookup.done)
+		lookupCtx := ctx
+		if o.weather.Timeout > 0 {
+			var cancel context.CancelFunc
+			lookupCtx, cancel = context.WithTimeout(ctx, o.weather.Timeout)
+			defer cancel()
+		}
+		start := time.Now()
+		lookup.forecast, lookup.err = o.weather.Provider.Forecast(lookupCtx, entry.Destination, arrival)
+		timings.since(stageWeather, start)
+	}()
+	return lookup
+}
+
+// earliestArrival returns the first arrival time among the flights to destination.
+func earliestArrival(flights []db.Flight, destination string) (time.Time, bool) {
+	var earliest time.Time
+	for _, f := range flights {
+		if f.Destination != destination {
+			continue
+		}
+		arrival, err := time.Parse(time.RFC3339, f.ArrivalTime)
+		if err != nil {
+			continue
+		}
+		if earliest.IsZero() || arrival.Before(earliest) {
+			earliest = arrival
+		}
+	}
+	return earliest, !earliest.IsZero()
+}
+
+// wait waits for the lookup and sends its forecast as an Enrichment event. ok is false when
+// there is no forecast: there was no lookup, or it failed, which is logged and otherwise
+// ignored since the flights are the answer.
+func (l *weatherLookup) wait(ctx context.Context, eventChan chan<- sse.Event) (forecast weather.Forecast, ok bool) {
+	if l == nil {
+		return weather.Forecast{}, false
+	}
+	<-l.done
+	if l.err != nil {
+		if ctx.Err() == nil {
+			slog.WarnContext(ctx, "Weather lookup failed; answering without it", "error", l.err)
+		}
+		return weather.Forecast{}, false
+	}
+	eventChan <- sse.Enrichment("weather", l.forecast.Summary(), l.forecast)
+	return l.forecast, true
+}
+
+// weatherInstructions ask the aggregator to mention the forecast that follows them.
+var weatherInstructions = map[string]string{
+	LanguageEnglish: "\n\nThe forecast at the destination on the day of arrival is below. Add a short note about it at the end of your answer, using only these facts:\n",
+	LanguageSpanish: "\n\nA continuación está el pronóstico del tiempo en el destino el día de llegada. Añade una breve nota sobre él al final de tu respuesta, usando solo estos datos:\n",
+}
+
+// weatherSection is the part of an aggregation prompt that carries forecast, in language. The
+// forecast comes from an outside service, so it is fenced like the flight data.
+func weatherSection(ctx context.Context, language string, forecast weather.Forecast) string {
+	instructions, ok := weatherInstructions[language]
+	if !ok {
+		instructions = weatherInstructions[LanguageEnglish]
+	}
+	return instructions + fence("WEATHER", sanitizeUntrusted(ctx, "weather", forecast.Summary()))
+}
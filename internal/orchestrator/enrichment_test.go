@@ -0,0 +1,110 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/sse"
+	"github.com/Cris245/go-llm-chat/internal/weather"
+)
+
+// fakeWeather answers every lookup with forecast, or err, and records what was asked.
+type fakeWeather struct {
+	forecast weather.Forecast
+	err      error
+
+	mu      sync.Mutex
+	lookups []string // "city date"
+}
+
+func (f *fakeWeather) Forecast(ctx context.Context, city string, date time.Time) (weather.Forecast, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lookups = append(f.lookups, city+" "+date.Format(time.DateOnly))
+	return f.forecast, f.err
+}
+
+func (f *fakeWeather) Lookups() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.lookups...)
+}
+
+var parisForecast = weather.Forecast{City: "Paris", Date: "2025-08-10", Conditions: "light rain", TempMinC: 14, TempMaxC: 21, PrecipitationChance: 60}
+
+func TestWeatherEnrichment(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		message  string
+		always   bool
+		enriched bool
+	}{
+		{"weather question", "Flights from Madrid to Paris, and what's the weather like when I land?", false, true},
+		{"Spanish weather question", "Vuelos de Madrid a París, ¿va a hacer frío?", false, true},
+		{"flight question", "Show me flights from Madrid to Paris", false, false},
+		{"flight question, always on", "Show me flights from Madrid to Paris", true, true},
+		{"no destination", "Flights from Madrid, will it rain?", false, false},
+		{"general question", "Is it cold in the mountains in winter?", true, false},
+	} {
+		for _, stream := range []bool{false, true} {
+			o := newTestOrchestrator(t, "FL101 and FL102.", "Two hours each.", "FL101 leaves at 09:00.")
+			provider := &fakeWeather{forecast: parisForecast}
+			o.SetWeather(WeatherEnrichment{Provider: provider, Always: tt.always})
+			events := process(t, o.Orchestrator, tt.message, Options{}, stream)
+
+			enrichments := ofType(events, sse.TypeEnrichment)
+			aggregation := o.llm3.Prompts()
+			if !tt.enriched {
+				if len(enrichments) != 0 || len(provider.Lookups()) != 0 {
+					t.Errorf("%s (stream %v): enrichments %v after lookups %v, want none", tt.name, stream, enrichments, provider.Lookups())
+				}
+				if len(aggregation) == 1 && strings.Contains(aggregation[0], "WEATHER") {
+					t.Errorf("%s (stream %v): forecast in the aggregation prompt", tt.name, stream)
+				}
+				continue
+			}
+			if lookups := provider.Lookups(); len(lookups) != 1 || lookups[0] != "Paris 2025-08-10" {
+				t.Errorf("%s (stream %v): lookups %v, want Paris on the earliest arrival", tt.name, stream, lookups)
+			}
+			if len(enrichments) != 1 {
+				t.Fatalf("%s (stream %v): enrichments %v, want one", tt.name, stream, enrichments)
+			}
+			payload := enrichments[0].Payload.(sse.EnrichmentPayload)
+			if payload.Kind != "weather" || payload.Data != parisForecast || enrichments[0].Data != parisForecast.Summary() {
+				t.Errorf("%s (stream %v): enrichment %+v", tt.name, stream, enrichments[0])
+			}
+			if len(aggregation) != 1 || !strings.Contains(aggregation[0], parisForecast.Summary()) {
+				t.Errorf("%s (stream %v): aggregation prompts %q, want the forecast in it", tt.name, stream, aggregation)
+			}
+			if answerOf(events) != "FL101 leaves at 09:00." {
+				t.Errorf("%s (stream %v): answer %q", tt.name, stream, answerOf(events))
+			}
+		}
+	}
+}
+
+func TestWeatherFailureKeepsAnswer(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "FL101 and FL102.", "Two hours each.", "FL101 leaves at 09:00.")
+		provider := &fakeWeather{err: errors.New("open-meteo: status 503")}
+		o.SetWeather(WeatherEnrichment{Provider: provider, Always: true})
+		events := process(t, o.Orchestrator, "Show me flights from Madrid to Paris", Options{}, stream)
+
+		if len(provider.Lookups()) != 1 {
+			t.Errorf("stream %v: lookups %v, want one", stream, provider.Lookups())
+		}
+		if enrichments, errs := ofType(events, sse.TypeEnrichment), ofType(events, sse.TypeError); len(enrichments) != 0 || len(errs) != 0 {
+			t.Errorf("stream %v: enrichments %v and errors %v, want neither", stream, enrichments, errs)
+		}
+		if answerOf(events) != "FL101 leaves at 09:00." || strings.Contains(o.llm3.Prompts()[0], "WEATHER") {
+			t.Errorf("stream %v: answer %q", stream, answerOf(events))
+		}
+		if done := ofType(events, sse.TypeDone); len(done) != 1 || done[0].Payload.(sse.DonePayload).Outcome != sse.OutcomeOK {
+			t.Errorf("stream %v: Done events %v", stream, done)
+		}
+	}
+}
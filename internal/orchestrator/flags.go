@@ -0,0 +1,19 @@
+package orchestrator
+
+import "github.com/Cris245/go-llm-chat/internal/flags"
+
+// withFlags returns the orchestrator to serve a request with: o itself, or a copy with the
+// behaviors the request's feature flags turn on. Behaviors on server-wide stay on.
+func (o *Orchestrator) withFlags(set flags.Set) *Orchestrator {
+	phrasing := set.On(flags.RoutePhrasing) && !o.routePhrasing
+	grounding := set.On(flags.GroundingCheck) && !o.groundingCheck
+	dbOnly := set.On(flags.DBOnly) && !o.dbOnly
+	if !phrasing && !grounding && !dbOnly {
+		return o
+	}
+	req := *o
+	req.routePhrasing = req.routePhrasing || phrasing
+	req.groundingCheck = req.groundingCheck || grounding
+	req.dbOnly = req.dbOnly || dbOnly
+	return &req
+}
@@ -0,0 +1,53 @@
+package orchestrator
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/flags"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestFlagsTurnOnBehaviors(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "FL101.", "Two hours.", "Madrid connects you to three cities!")
+
+		// Route phrasing is off server-wide, but the request's flag turns it on.
+		events := process(t, o.Orchestrator, "Where can I fly from Madrid?", Options{Flags: flags.Set{flags.RoutePhrasing}}, stream)
+		if answerOf(events) != "Madrid connects you to three cities!" || len(o.llm3.Prompts()) != 1 {
+			t.Errorf("stream %v: answer %q with the route_phrasing flag", stream, answerOf(events))
+		}
+		if got := telemetryOf(t, events).Flags; !slices.Equal(got, []string{flags.RoutePhrasing}) {
+			t.Errorf("stream %v: telemetry flags %v", stream, got)
+		}
+
+		// It stays off for the next request.
+		events = process(t, o.Orchestrator, "Where can I fly from Madrid?", Options{}, stream)
+		if answerOf(events) != "From Madrid we fly to Barcelona, Paris and Valencia." || len(o.llm3.Prompts()) != 1 {
+			t.Errorf("stream %v: answer %q without flags", stream, answerOf(events))
+		}
+		if got := telemetryOf(t, events).Flags; got != nil {
+			t.Errorf("stream %v: telemetry flags %v without flags", stream, got)
+		}
+
+		// The db_only flag answers a flight question without LLM calls.
+		events = process(t, o.Orchestrator, "Show me flights from Madrid to Paris", Options{Flags: flags.Set{flags.DBOnly}}, stream)
+		if len(ofType(events, sse.TypeFlightResults)) != 1 || len(o.llm1.Prompts())+len(o.llm2.Prompts()) != 0 || len(o.llm3.Prompts()) != 1 {
+			t.Errorf("stream %v: db_only made LLM calls: %d, %d and %d", stream, len(o.llm1.Prompts()), len(o.llm2.Prompts()), len(o.llm3.Prompts()))
+		}
+	}
+}
+
+func TestFlagsInQueryLog(t *testing.T) {
+	o := newTestOrchestrator(t, "", "", "")
+	o.EnableQueryLog(nil)
+	ctx := logging.WithRequestID(context.Background(), "flags-1")
+	events := make(chan sse.Event, 1024)
+	o.ProcessMessage(ctx, "What is the capital of France?", Options{Flags: flags.Set{flags.GroundingCheck}}, events)
+	drain(events)
+	if entry := queryLogOf(t, o, "flags-1"); !slices.Equal(entry.Flags, []string{flags.GroundingCheck}) {
+		t.Errorf("logged flags %v", entry.Flags)
+	}
+}
@@ -0,0 +1,259 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+)
+
+// FlightFormat rewrites the lines of a flight answer that present one flight into a canonical
+// line written from the flight's record. See SetFlightFormat.
+type FlightFormat struct {
+	Enabled  bool
+	Template string // text/template over a FlightLine; "" uses the default of the answer's language
+}
+
+// FlightLine is what a flight line template is executed with: one flight, as its line shows it.
+type FlightLine struct {
+	Number      string
+	Origin      string // City, with the airport's code where the record has one: "New York (JFK)"
+	Destination string
+	Date        string // Local date of departure, "2006-01-02"
+	Departure   string // Local time at the origin, "15:04", or "15:04 UTC" where its zone isn't known
+	Arrival     string // Local time at the destination, as Departure
+	Duration    string // "2h 05m"
+	Price       string // In the request's display currency, as other answers show it
+	Total       string // The price for the question's party, as Price; "" for one passenger
+	Seats       int
+}
+
+// flightLineTemplates are the default flight line templates, by language code.
+var flightLineTemplates = map[string]string{
+	"en": "- {{.Number}} — {{.Origin}} → {{.Destination}}, {{.Date}}, dep {{.Departure}}, arr {{.Arrival}}, {{.Duration}}, {{.Price}}{{with .Total}} ({{.}} total){{end}}, {{.Seats}} seats",
+	"es": "- {{.Number}} — {{.Origin}} → {{.Destination}}, {{.Date}}, sale {{.Departure}}, llega {{.Arrival}}, {{.Duration}}, {{.Price}}{{with .Total}} ({{.}} en total){{end}}, {{.Seats}} plazas",
+}
+
+// SetFlightFormat rewrites, when f.Enabled, every line of LLM 3's answer to a flight question
+// that presents exactly one of the flights found, named by its flight number, into the line
+// f.Template writes for it, so clients and readers get the same layout whatever the model
+// chose. A line presents a flight if it is a list item naming it, or if it starts with its
+// number, after any "**" or "Flight"; prose, tables and lines naming several flights are left
+// as they are, and so is the indentation of a rewritten line. A streamed answer is rewritten
+// line by line, each sent once it is complete. It fails if f.Template doesn't parse or
+// execute. It must be called before the orchestrator serves requests.
+func (o *Orchestrator) SetFlightFormat(f FlightFormat) error {
+	if !f.Enabled {
+		o.flightLines = nil
+		return nil
+	}
+	sources := flightLineTemplates
+	if f.Template != "" {
+		sources = map[string]string{"": f.Template}
+	}
+	templates := make(map[string]*template.Template, len(sources))
+	for lang, source := range sources {
+		t, err := template.New("flight_line").Parse(source)
+		if err == nil {
+			err = t.Execute(io.Discard, FlightLine{})
+		}
+		if err != nil {
+			return fmt.Errorf("flight line template: %w", err)
+		}
+		templates[lang] = t
+	}
+	o.flightLines = templates
+	return nil
+}
+
+var (
+	// listItemStart is the marker of a bulleted or numbered list item.
+	listItemStart = regexp.MustCompile(`^\s*(?:[-*•]|\d{1,2}[.)])\s+`)
+	// flightLineStart is a line that starts with a flight number, after any list marker, "**"
+	// or "Flight".
+	flightLineStart = regexp.MustCompile(`^\s*(?:(?:[-*•]|\d{1,2}[.)])\s+)?(?:\*\*)?(?:(?i:flight|vuelo)\s+)?[A-Z]{2}\d{2,4}\b`)
+)
+
+// flightLineTemplate returns the flight line template for an answer in lang, or nil if flight
+// lines aren't rewritten.
+func (o *Orchestrator) flightLineTemplate(lang string) *template.Template {
+	if o.flightLines == nil {
+		return nil
+	}
+	for _, key := range []string{lang, "", "en"} {
+		if t := o.flightLines[key]; t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+// flightLineWriter rewrites the flight lines of one answer.
+type flightLineWriter struct {
+	o        *Orchestrator
+	ctx      context.Context
+	entry    *db.QueryLog
+	lang     string
+	template *template.Template
+	flights  map[string]db.Flight // By flight number
+	rewrote  int
+}
+
+// newFlightLineWriter returns the writer of an answer about flights in lang, or nil if its
+// lines are left as they are.
+func (o *Orchestrator) newFlightLineWriter(ctx context.Context, entry *db.QueryLog, lang string, flights []db.Flight) *flightLineWriter {
+	t := o.flightLineTemplate(lang)
+	if t == nil || len(flights) == 0 {
+		return nil
+	}
+	byNumber := make(map[string]db.Flight, len(flights))
+	for _, f := range flights {
+		byNumber[f.FlightNumber] = f
+	}
+	return &flightLineWriter{o: o, ctx: ctx, entry: entry, lang: lang, template: t, flights: byNumber}
+}
+
+// line rewrites one line of the answer, with or without its line break, if it presents a
+// single flight; other lines are returned as they are.
+func (w *flightLineWriter) line(line string) string {
+	text := strings.TrimRight(line, "\r\n")
+	ending := line[len(text):]
+	var f db.Flight
+	found := false
+	for _, number := range flightNumber.FindAllString(text, -1) {
+		known, ok := w.flights[number]
+		if !ok || found && known.FlightNumber != f.FlightNumber {
+			return line // An unknown flight, or a second one
+		}
+		f, found = known, true
+	}
+	if !found || !listItemStart.MatchString(text) && !flightLineStart.MatchString(text) {
+		return line
+	}
+	var b strings.Builder
+	b.WriteString(text[:len(text)-len(strings.TrimLeft(text, " \t"))])
+	if err := w.template.Execute(&b, w.o.flightLine(w.ctx, w.entry, w.lang, f)); err != nil {
+		slog.WarnContext(w.ctx, "Failed to write flight line; left as it was", "flight_number", f.FlightNumber, "error", err)
+		return line
+	}
+	w.rewrote++
+	return b.String() + ending
+}
+
+// done logs how many lines were rewritten.
+func (w *flightLineWriter) done() {
+	if w.rewrote > 0 {
+		slog.DebugContext(w.ctx, "Flight lines rewritten", "lines", w.rewrote)
+	}
+}
+
+// formatFlightLines rewrites the flight lines of a complete answer about flights (see
+// SetFlightFormat).
+func (o *Orchestrator) formatFlightLines(ctx context.Context, entry *db.QueryLog, lang string, flights []db.Flight, answer string) string {
+	w := o.newFlightLineWriter(ctx, entry, lang, flights)
+	if w == nil {
+		return answer
+	}
+	lines := strings.SplitAfter(answer, "\n")
+	for i, line := range lines {
+		lines[i] = w.line(line)
+	}
+	w.done()
+	return strings.Join(lines, "")
+}
+
+// maxFlightLine bounds the line formatFlightStream holds back: a longer one isn't a flight's,
+// and is passed on as it comes, so the output limit still applies to it.
+const maxFlightLine = 4 << 10
+
+// formatFlightStream is formatFlightLines for a streamed answer: chunks are held until their
+// line is complete, and each line is sent, rewritten, once it is. A line longer than
+// maxFlightLine is sent as it is, as it comes.
+func (o *Orchestrator) formatFlightStream(ctx context.Context, entry *db.QueryLog, lang string, flights []db.Flight, streamChan <-chan string) <-chan string {
+	w := o.newFlightLineWriter(ctx, entry, lang, flights)
+	if w == nil {
+		return streamChan
+	}
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		var pending strings.Builder // The line being written
+		long := false               // The line being written is over maxFlightLine, and passed on
+		for chunk := range streamChan {
+			for chunk != "" {
+				part := chunk
+				end := strings.IndexByte(chunk, '\n') + 1 // 0 if the line goes on past the chunk
+				if end > 0 {
+					part = chunk[:end]
+				}
+				chunk = chunk[len(part):]
+				switch {
+				case long:
+					lines <- part
+				case pending.Len()+len(part) > maxFlightLine:
+					lines <- pending.String() + part
+					pending.Reset()
+					long = true
+				default:
+					pending.WriteString(part)
+					if end > 0 {
+						lines <- w.line(pending.String())
+						pending.Reset()
+					}
+				}
+				if end > 0 {
+					long = false
+				}
+			}
+		}
+		if pending.Len() > 0 {
+			lines <- w.line(pending.String())
+		}
+		w.done()
+	}()
+	return lines
+}
+
+// flightLine returns what a flight line template shows of f, for an answer in lang.
+func (o *Orchestrator) flightLine(ctx context.Context, entry *db.QueryLog, lang string, f db.Flight) FlightLine {
+	date, departure := o.clockText(f.DepartureTime, f.Origin, f.OriginAirport)
+	_, arrival := o.clockText(f.ArrivalTime, f.Destination, f.DestinationAirport)
+	var total string
+	if entry.Passengers > 1 {
+		total = o.displayPrice(ctx, partyPrice(f.Price, entry.Passengers), entry.Currency, lang)
+	}
+	return FlightLine{
+		Number:      f.FlightNumber,
+		Origin:      db.PlaceName(f.Origin, f.OriginAirport),
+		Destination: db.PlaceName(f.Destination, f.DestinationAirport),
+		Date:        date,
+		Departure:   departure,
+		Arrival:     arrival,
+		Duration:    formatDuration(lang, flightDuration(f)),
+		Price:       o.displayPrice(ctx, f.Price, entry.Currency, lang),
+		Total:       total,
+		Seats:       f.AvailableSeats,
+	}
+}
+
+// clockText returns the local date and time of at, an RFC 3339 time at city or airport, or
+// the UTC ones followed by " UTC" if its zone isn't known; a time that doesn't parse is
+// returned as the time, as it is.
+func (o *Orchestrator) clockText(at, city, airport string) (date, clock string) {
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return "", at
+	}
+	if loc, ok := o.timeZones.Location(city, airport); ok {
+		t = t.In(loc)
+		return t.Format(time.DateOnly), t.Format("15:04")
+	}
+	t = t.UTC()
+	return t.Format(time.DateOnly), t.Format("15:04 UTC")
+}
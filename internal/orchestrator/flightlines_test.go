@@ -0,0 +1,198 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+)
+
+// The canonical lines of the seeded Madrid–Paris flights, as the default templates write them.
+const (
+	fl101Line   = "- FL101 — Madrid → Paris, 2025-08-10, dep 11:00, arr 13:00, 2h 00m, $120.00, 50 seats"
+	fl104Line   = "- FL104 — Madrid → Paris, 2025-08-11, dep 20:00, arr 22:00, 2h 00m, $130.00, 40 seats"
+	fl101LineES = "- FL101 — Madrid → Paris, 2025-08-10, sale 11:00, llega 13:00, 2 h 00 min, 120,00 $, 50 plazas"
+)
+
+func TestFlightLines(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		for _, tt := range []struct {
+			name, message, answer, want string
+		}{
+			{
+				"bullets",
+				"Flights from Madrid to Paris",
+				"Here are your options:\n- FL101 leaves at 9am, $99\n- FL104: evening, 130 USD\nEnjoy your trip!",
+				"Here are your options:\n" + fl101Line + "\n" + fl104Line + "\nEnjoy your trip!",
+			},
+			{
+				"numbered and bold",
+				"Flights from Madrid to Paris",
+				"1. **FL101** Madrid-Paris €120\n2) Flight FL104, 8pm",
+				fl101Line + "\n" + fl104Line,
+			},
+			{
+				"starting with the number",
+				"Flights from Madrid to Paris",
+				"FL101 is the cheapest.\n\n**Flight FL104** — late departure",
+				fl101Line + "\n\n" + fl104Line,
+			},
+			{
+				"indented, with CRLF",
+				"Flights from Madrid to Paris",
+				"Options:\r\n  * FL101 at 11\r\nThat's all.",
+				"Options:\r\n  " + fl101Line + "\r\nThat's all.",
+			},
+			{
+				"prose, tables and several flights",
+				"Flights from Madrid to Paris",
+				"I'd pick FL101 over FL104.\n| FL101 | $120 |\n- FL101 or FL104, both fine\nAsk me about FL999.",
+				"I'd pick FL101 over FL104.\n| FL101 | $120 |\n- FL101 or FL104, both fine\nAsk me about FL999.",
+			},
+			{
+				"a flight not found",
+				"Flights from Madrid to Paris",
+				"- FL108 to London\n- FL101 to Paris",
+				"- FL108 to London\n" + fl101Line,
+			},
+			{
+				"Spanish",
+				"vuelos de Madrid a París",
+				"Opciones:\n- Vuelo FL101, 120 €\nBuen viaje.",
+				"Opciones:\n" + fl101LineES + "\nBuen viaje.",
+			},
+			{
+				"a party",
+				"Flights from Madrid to Paris for 3 people",
+				"- FL101, cheap",
+				"- FL101 — Madrid → Paris, 2025-08-10, dep 11:00, arr 13:00, 2h 00m, $120.00 ($360.00 total), 50 seats",
+			},
+			{
+				"airports named",
+				"Flights from New York",
+				"- FL108\n- FL120",
+				"- FL108 — New York (EWR) → London (LGW), 2025-08-14, dep 06:00, arr 19:00, 8h 00m, $540.00, 110 seats\n" +
+					"- FL120 — New York (JFK) → Tokyo (HND), 2025-08-22, dep 00:00, arr 03:00, 14h 00m, $950.00, 200 seats",
+			},
+			{
+				"a general question",
+				"What is the capital of France?",
+				"- FL101 is a flight.",
+				"- FL101 is a flight.",
+			},
+		} {
+			o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", tt.answer)
+			if err := o.SetFlightFormat(FlightFormat{Enabled: true}); err != nil {
+				t.Fatal(err)
+			}
+			if got := answerOf(process(t, o.Orchestrator, tt.message, Options{}, stream)); got != tt.want {
+				t.Errorf("stream %v: %s: answer\n%q\nwant\n%q", stream, tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestFlightLinesFromRecords(t *testing.T) {
+	// Whatever the answer says of a flight, its line says what its record does.
+	o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "- FL102 costs $1 and has 999 seats")
+	if err := o.SetFlightFormat(FlightFormat{Enabled: true, Template: "{{.Number}} {{.Origin}}>{{.Destination}} {{.Price}} {{.Seats}}"}); err != nil {
+		t.Fatal(err)
+	}
+	f := seededFlight(t, o, "FL102")
+	f.Price, f.AvailableSeats = 175, 3
+	if err := o.db.UpdateFlight(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	for _, stream := range []bool{false, true} {
+		if got := answerOf(process(t, o.Orchestrator, "Flights from Madrid to Paris", Options{}, stream)); got != "FL102 Madrid>Paris $175.00 3" {
+			t.Errorf("stream %v: answer %q", stream, got)
+		}
+	}
+}
+
+func TestFlightLinesOff(t *testing.T) {
+	answer := "- FL101 leaves at 9am, $99"
+	o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", answer)
+	for _, stream := range []bool{false, true} {
+		if got := answerOf(process(t, o.Orchestrator, "Flights from Madrid to Paris", Options{}, stream)); got != answer {
+			t.Errorf("stream %v: by default, answer %q", stream, got)
+		}
+	}
+	if err := o.SetFlightFormat(FlightFormat{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.SetFlightFormat(FlightFormat{Enabled: false, Template: "{{.Number}}"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := answerOf(process(t, o.Orchestrator, "Flights from Madrid to Paris", Options{}, true)); got != answer {
+		t.Errorf("once disabled, answer %q", got)
+	}
+}
+
+func TestSetFlightFormat(t *testing.T) {
+	o := newTestOrchestrator(t, "", "", "")
+	for template, wantErr := range map[string]string{
+		"{{.Number":                 "flight line template",
+		"{{.Gate}}":                 "flight line template",
+		"{{.Number}} at {{.Price}}": "",
+		"":                          "",
+	} {
+		err := o.SetFlightFormat(FlightFormat{Enabled: true, Template: template})
+		if wantErr == "" && err != nil || wantErr != "" && (err == nil || !strings.Contains(err.Error(), wantErr)) {
+			t.Errorf("SetFlightFormat(%q) = %v", template, err)
+		}
+	}
+	// A template of the operator's own is used in every language.
+	if err := o.SetFlightFormat(FlightFormat{Enabled: true, Template: "{{.Number}}"}); err != nil {
+		t.Fatal(err)
+	}
+	if o.flightLineTemplate("es") == nil || o.flightLineTemplate("es") != o.flightLineTemplate("en") {
+		t.Errorf("a custom template isn't used for every language")
+	}
+}
+
+func TestFlightStreamLines(t *testing.T) {
+	o := newTestOrchestrator(t, "", "", "")
+	if err := o.SetFlightFormat(FlightFormat{Enabled: true, Template: "{{.Number}} {{.Price}}"}); err != nil {
+		t.Fatal(err)
+	}
+	flights := []db.Flight{seededFlight(t, o, "FL101"), seededFlight(t, o, "FL104")}
+	long := strings.Repeat("x", maxFlightLine)
+	for _, tt := range []struct {
+		name   string
+		chunks []string
+		want   []string // The chunks sent
+	}{
+		{"split mid number", []string{"Hi\n- FL1", "01 at $", "9\n- FL104", " too"}, []string{"Hi\n", "FL101 $120.00\n", "FL104 $130.00"}},
+		{"several lines in a chunk", []string{"- FL101\n- FL104\nbye\n"}, []string{"FL101 $120.00\n", "FL104 $130.00\n", "bye\n"}},
+		{"no line break", []string{"just ", "prose"}, []string{"just prose"}},
+		// A line too long to be a flight's is passed on as it comes, and the next one held again.
+		{"a long line", []string{long, "- FL101", " more\n- FL104\n"}, []string{long + "- FL101", " more\n", "FL104 $130.00\n"}},
+	} {
+		chunks := make(chan string, len(tt.chunks))
+		for _, c := range tt.chunks {
+			chunks <- c
+		}
+		close(chunks)
+		var got []string
+		for c := range o.formatFlightStream(context.Background(), &db.QueryLog{}, "en", flights, chunks) {
+			got = append(got, c)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: sent %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Without flights, or with the feature off, the stream is passed on as it is.
+	chunks := make(chan string)
+	if o.formatFlightStream(context.Background(), &db.QueryLog{}, "en", nil, chunks) != (<-chan string)(chunks) {
+		t.Errorf("an answer without flights isn't passed on as it is")
+	}
+	if err := o.SetFlightFormat(FlightFormat{}); err != nil {
+		t.Fatal(err)
+	}
+	if o.formatFlightStream(context.Background(), &db.QueryLog{}, "en", flights, chunks) != (<-chan string)(chunks) {
+		t.Errorf("with flight lines off, the answer isn't passed on as it is")
+	}
+}
@@ -0,0 +1,246 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/currency"
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// maxMismatches bounds the mismatches kept in a grounding report, so a badly wrong answer
+// doesn't bloat its query log record.
+const maxMismatches = 10
+
+// EnableGroundingCheck turns on the grounding check of flight answers: once a flight request
+// has ended, the prices, times and flight numbers its answer states are compared with the
+// flight records it was given. The result (see db.Grounding) is added to the request's query
+// log record and to the Telemetry passed to hooks. The check runs after the Done event, so it
+// adds no latency, but the Done event's own telemetry doesn't carry it; the query log record
+// and the hooks are also delayed until it ends. It must be called before the orchestrator
+// serves requests. Without it, the grounding_check feature flag turns it on request by
+// request (see Options.Flags).
+func (o *Orchestrator) EnableGroundingCheck() {
+	o.groundingCheck = true
+}
+
+// answerTranscript sits between the pipeline and the request's event channel when the
+// grounding check or prompt storage is on, keeping the answer text and the flights found as
+// they go by.
+type answerTranscript struct {
+	events chan sse.Event
+	done   chan struct{}
+
+	answer  strings.Builder
+	flights []db.Flight
+}
+
+// watchAnswer returns the channel the pipeline should send to: eventChan itself when neither
+// the grounding check nor prompt storage is on, or the input of a transcript forwarding to
+// eventChan.
+func (o *Orchestrator) watchAnswer(eventChan chan<- sse.Event) (chan<- sse.Event, *answerTranscript) {
+	if !o.groundingCheck && !(o.storePrompts && o.queryLogEnabled) {
+		return eventChan, nil
+	}
+	t := &answerTranscript{events: make(chan sse.Event), done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		for event := range t.events {
+			switch event.Type {
+			case sse.TypeMessage:
+				t.answer.WriteString(event.Data)
+			case sse.TypeFlightResults:
+				t.flights, _ = event.Payload.([]db.Flight)
+			}
+			eventChan <- event
+		}
+	}()
+	return t.events, t
+}
+
+// close waits for the transcript to forward the events sent to it. The Done event must have
+// been sent: nothing may be sent after it.
+func (t *answerTranscript) close() {
+	close(t.events)
+	<-t.done
+}
+
+// reportGrounded checks the answer in transcript if the grounding check is on, then records
+// the request's query, with the answer if prompts are stored, and calls the telemetry hooks
+// with the result. It is run in the background by finish.
+func (o *Orchestrator) reportGrounded(ctx context.Context, entry *db.QueryLog, telemetry Telemetry, outcome string, transcript *answerTranscript) {
+	if o.storePrompts {
+		entry.Answer = transcript.answer.String()
+	}
+	if o.groundingCheck && entry.Intent == "flight" && outcome == sse.OutcomeOK && len(transcript.flights) > 0 {
+		report := o.checkGrounding(ctx, transcript.answer.String(), transcript.flights, entry.Currency)
+		entry.Grounding, telemetry.Grounding = &report, &report
+		if len(report.Mismatches) > 0 {
+			slog.WarnContext(ctx, "Answer states facts missing from its flight records",
+				"score", report.Score, "claims", report.Claims, "mismatches", report.Mismatches)
+		}
+	}
+	o.recordQuery(ctx, entry)
+	for _, hook := range o.telemetryHooks {
+		hook(telemetry, outcome)
+	}
+}
+
+// Claims the grounding check looks for in answers.
+var (
+	// Prices with a symbol or currency before or after the amount: "$120", "£94.80",
+	// "110,40 €", "120 dollars", "USD 120".
+	priceBefore = regexp.MustCompile(`(?i)([$€£¥]|\b(?:usd|eur|gbp|jpy)\b)\s?(\d[\d.,]*\d|\d)`)
+	priceAfter  = regexp.MustCompile(`(?i)\b(\d[\d.,]*\d|\d)\s?([$€£¥]|\b(?:usd|eur|gbp|jpy|dollars?|d[oó]lares|euros?|pounds?|libras?)\b)`)
+	// Clock times: "09:00", "9:00 am", "17:30 h", also inside timestamps ("2025-08-10T09:00:00Z")
+	// but not their seconds. A trailing "hours" ("1:30 hours") marks a duration, which isn't
+	// checked.
+	clockTime = regexp.MustCompile(`(?i)(?:^|[^\w:]|\dT)([01]?\d|2[0-3]):([0-5]\d)\b(\s?[ap]\.?m\b\.?|\s?(?:hrs|hours?|horas?)\b)?`)
+	// Flight numbers: two capital letters and digits, as the records use.
+	flightNumber = regexp.MustCompile(`\b[A-Z]{2}\d{2,4}\b`)
+)
+
+// CitedFlightNumbers returns the flight numbers answer states, in order of first mention, as
+// the grounding check finds them.
+func CitedFlightNumbers(answer string) []string {
+	var cited []string
+	seen := make(map[string]bool)
+	for _, number := range flightNumber.FindAllString(answer, -1) {
+		if !seen[number] {
+			seen[number] = true
+			cited = append(cited, number)
+		}
+	}
+	return cited
+}
+
+// checkGrounding compares the prices, times and flight numbers stated in answer with flights.
+// Prices count in the base currency and in display, the currency the answer was asked in, and
+// times in UTC and in the local time of the airports. A party's total price (see
+// db.Flight.TotalPrice) counts as one of the flight's prices.
+// The check is deliberately literal: sums or averages the answer works out are reported as
+// mismatches too.
+func (o *Orchestrator) checkGrounding(ctx context.Context, answer string, flights []db.Flight, display string) db.Grounding {
+	prices := make(map[string][]float64) // Per currency
+	addPrice := func(code string, price float64) {
+		prices[code] = append(prices[code], currency.Round(price, code))
+	}
+	times := make(map[string]bool)   // "15:04"
+	numbers := make(map[string]bool) // Flight numbers
+	for _, f := range flights {
+		for _, price := range []float64{f.Price, f.TotalPrice} {
+			if price == 0 {
+				continue
+			}
+			addPrice(o.currency.Base(), price)
+			if display != "" {
+				if converted, err := o.currency.Convert(ctx, price, o.currency.Base(), display); err == nil {
+					addPrice(display, converted)
+				}
+			}
+		}
+		for _, at := range []string{f.DepartureTime, f.ArrivalTime, f.DepartureLocal, f.ArrivalLocal} {
+			if t, err := time.Parse(time.RFC3339, at); err == nil {
+				times[t.Format("15:04")] = true
+			}
+		}
+		numbers[f.FlightNumber] = true
+	}
+
+	var report db.Grounding
+	grounded := 0
+	claim := func(kind, text string, ok bool) {
+		report.Claims++
+		if ok {
+			grounded++
+		} else if len(report.Mismatches) < maxMismatches {
+			report.Mismatches = append(report.Mismatches, db.GroundingMismatch{Kind: kind, Claim: text})
+		}
+	}
+
+	// A price can match both patterns ("$120 USD"); each amount is claimed once.
+	claimed := make(map[int]bool) // Start of the amount in answer
+	checkPrice := func(whole []int, amount, unit string, amountStart int) {
+		if claimed[amountStart] {
+			return
+		}
+		claimed[amountStart] = true
+		code, _ := currency.Detect(unit)
+		value, decimals, ok := parseAmount(amount)
+		if !ok {
+			return
+		}
+		claim("price", answer[whole[0]:whole[1]], priceMatches(prices[code], value, decimals))
+	}
+	for _, m := range priceBefore.FindAllStringSubmatchIndex(answer, -1) {
+		checkPrice(m, answer[m[4]:m[5]], answer[m[2]:m[3]], m[4])
+	}
+	for _, m := range priceAfter.FindAllStringSubmatchIndex(answer, -1) {
+		checkPrice(m, answer[m[2]:m[3]], answer[m[4]:m[5]], m[2])
+	}
+
+	for _, m := range clockTime.FindAllStringSubmatchIndex(answer, -1) {
+		var suffix string
+		if m[6] >= 0 {
+			suffix = strings.ToLower(strings.TrimSpace(answer[m[6]:m[7]]))
+		}
+		if suffix != "" && !strings.HasPrefix(suffix, "a") && !strings.HasPrefix(suffix, "p") {
+			continue // A duration
+		}
+		hour, _ := strconv.Atoi(answer[m[2]:m[3]])
+		switch {
+		case strings.HasPrefix(suffix, "p") && hour < 12:
+			hour += 12
+		case strings.HasPrefix(suffix, "a") && hour == 12:
+			hour = 0
+		}
+		claim("time", strings.TrimSpace(answer[m[2]:m[1]]), times[fmt.Sprintf("%02d:%s", hour, answer[m[4]:m[5]])])
+	}
+
+	for _, number := range flightNumber.FindAllString(answer, -1) {
+		claim("flight_number", number, numbers[number])
+	}
+
+	report.Score = 1
+	if report.Claims > 0 {
+		report.Score = math.Round(float64(grounded)/float64(report.Claims)*100) / 100
+	}
+	return report
+}
+
+// parseAmount reads an amount written with either decimal separator ("1,234.50", "1.234,50",
+// "94.80", "110,40"), returning how many decimals it was written with. A lone separator
+// followed by three digits is taken as a thousands separator.
+func parseAmount(text string) (value float64, decimals int, ok bool) {
+	text = strings.Trim(text, ".,")
+	lastDot, lastComma := strings.LastIndex(text, "."), strings.LastIndex(text, ",")
+	point := max(lastDot, lastComma)
+	if point >= 0 && (lastDot < 0 || lastComma < 0) && len(text)-point-1 == 3 {
+		point = -1 // "1,234" or "1.234"
+	}
+	whole, fraction := text, ""
+	if point >= 0 {
+		whole, fraction = text[:point], text[point+1:]
+	}
+	whole = strings.NewReplacer(".", "", ",", "").Replace(whole)
+	value, err := strconv.ParseFloat(whole+"."+fraction+"0", 64)
+	return value, len(fraction), err == nil
+}
+
+// priceMatches reports whether value, written with decimals decimals, is one of prices. A
+// whole amount may round a price ("$120" for 119.99); one with decimals must match exactly.
+func priceMatches(prices []float64, value float64, decimals int) bool {
+	for _, price := range prices {
+		if decimals == 0 && math.Abs(price-value) < 0.5 || math.Abs(price-value) < 0.005 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package orchestrator
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// groundingFlights are the records the answers below are checked against.
+var groundingFlights = []db.Flight{
+	{FlightNumber: "FL101", Origin: "Madrid", Destination: "Paris", DepartureTime: "2025-08-10T09:00:00Z", ArrivalTime: "2025-08-10T11:00:00Z", Price: 120},
+	{FlightNumber: "FL102", Origin: "Madrid", Destination: "Paris", DepartureTime: "2025-08-10T15:00:00Z", ArrivalTime: "2025-08-10T17:30:00Z", Price: 1234.5},
+}
+
+func TestCheckGrounding(t *testing.T) {
+	o := newTestOrchestrator(t, "", "", "")
+	for _, tt := range []struct {
+		name, answer, display string
+		claims                int
+		mismatches            []string
+	}{
+		{"grounded", "FL101 leaves at 09:00 and lands at 11:00 for $120.00; FL102 leaves at 3:00 pm for $1,234.50.", "", 7, nil},
+		{"rounded price", "FL101 costs about 120 dollars.", "", 2, nil},
+		{"Spanish price", "El FL102 sale a las 15:00 h y cuesta 1.234,50 $.", "", 3, nil},
+		{"display currency", "FL101 costs £94.80 ($120.00).", "GBP", 3, nil},
+		{"durations aren't times", "FL101 takes 2:00 hours.", "", 1, nil},
+		{"wrong facts", "FL101 leaves at 07:15 for $99.00, and FL999 lands at 11:00.", "", 5, []string{"07:15", "$99.00", "FL999"}},
+		{"decimals must match", "FL101 costs $120.50.", "", 2, []string{"$120.50"}},
+		{"no claims", "There are two flights.", "", 0, nil},
+	} {
+		report := o.checkGrounding(context.Background(), tt.answer, groundingFlights, tt.display)
+		var mismatches []string
+		for _, m := range report.Mismatches {
+			mismatches = append(mismatches, m.Claim)
+		}
+		slices.Sort(mismatches)
+		slices.Sort(tt.mismatches)
+		if report.Claims != tt.claims || !slices.Equal(mismatches, tt.mismatches) {
+			t.Errorf("%s: %d claims with mismatches %q, want %d with %q", tt.name, report.Claims, mismatches, tt.claims, tt.mismatches)
+		}
+		if want := 1.0; report.Claims > 0 {
+			want = float64(report.Claims-len(tt.mismatches)) / float64(report.Claims)
+			if d := report.Score - want; d > 0.005 || d < -0.005 {
+				t.Errorf("%s: score %v, want %v", tt.name, report.Score, want)
+			}
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	for text, want := range map[string]struct {
+		value    float64
+		decimals int
+	}{
+		"120":      {120, 0},
+		"94.80":    {94.8, 2},
+		"110,40":   {110.4, 2},
+		"1,234.50": {1234.5, 2},
+		"1.234,50": {1234.5, 2},
+		"1,234":    {1234, 0},
+		"1.234":    {1234, 0},
+		"120.":     {120, 0},
+	} {
+		value, decimals, ok := parseAmount(text)
+		if !ok || value != want.value || decimals != want.decimals {
+			t.Errorf("parseAmount(%q) = %v, %d, %v, want %v, %d", text, value, decimals, ok, want.value, want.decimals)
+		}
+	}
+}
+
+func TestCitedFlightNumbers(t *testing.T) {
+	got := CitedFlightNumbers("FL103 is cheaper than FL101; take FL103. (Flight fl999 isn't one.)")
+	if !slices.Equal(got, []string{"FL103", "FL101"}) {
+		t.Errorf("cited %v", got)
+	}
+}
+
+func TestGroundingMismatchRecorded(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		// The aggregator gets the price and the time of FL101 wrong.
+		o := newTestOrchestrator(t, "FL101 and FL102.", "Two hours each.", "FL101 leaves at 07:15 and costs $99.00.")
+		o.EnableQueryLog(nil)
+		o.EnableGroundingCheck()
+		hooked := make(chan Telemetry, 1)
+		o.AddTelemetryHook(func(t Telemetry, _ string) { hooked <- t })
+		ctx := logging.WithRequestID(context.Background(), "req-grounding")
+		events := make(chan sse.Event, 1024)
+		if stream {
+			o.ProcessMessageStream(ctx, "Show me flights from Madrid to Paris", Options{}, events)
+		} else {
+			o.ProcessMessage(ctx, "Show me flights from Madrid to Paris", Options{}, events)
+		}
+
+		// The Done event isn't held up for the check, so it doesn't carry it.
+		done := ofType(drain(events), sse.TypeDone)
+		if len(done) != 1 || done[0].Payload.(sse.DonePayload).Telemetry != nil && done[0].Payload.(sse.DonePayload).Telemetry.(Telemetry).Grounding != nil {
+			t.Fatalf("stream %v: Done events %+v", stream, done)
+		}
+		entry := queryLogOf(t, o, "req-grounding")
+		if g := entry.Grounding; g == nil || g.Claims != 3 || g.Score != 0.33 || len(g.Mismatches) != 2 {
+			t.Fatalf("stream %v: grounding %+v", stream, entry.Grounding)
+		}
+		if m := entry.Grounding.Mismatches; m[0] != (db.GroundingMismatch{Kind: "price", Claim: "$99.00"}) || m[1] != (db.GroundingMismatch{Kind: "time", Claim: "07:15"}) {
+			t.Errorf("stream %v: mismatches %+v", stream, m)
+		}
+		select {
+		case telemetry := <-hooked:
+			if telemetry.Grounding == nil || telemetry.Grounding.Score != 0.33 {
+				t.Errorf("stream %v: hook got grounding %+v", stream, telemetry.Grounding)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("stream %v: hook not called", stream)
+		}
+	}
+}
+
+func TestGroundingCheckOffByDefault(t *testing.T) {
+	o := newTestOrchestrator(t, "FL101 and FL102.", "Two hours each.", "FL101 leaves at 07:15 and costs $99.00.")
+	o.EnableQueryLog(nil)
+	ctx := logging.WithRequestID(context.Background(), "req-unchecked")
+	events := make(chan sse.Event, 1024)
+	o.ProcessMessage(ctx, "Show me flights from Madrid to Paris", Options{}, events)
+	if entry := queryLogOf(t, o, "req-unchecked"); entry.Grounding != nil {
+		t.Errorf("grounding %+v without the check", entry.Grounding)
+	}
+}
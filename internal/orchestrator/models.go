@@ -0,0 +1,55 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+)
+
+// SetModels names the models of the three slots, so that requests record which model made each
+// of their LLM calls (Telemetry.Models). It must be called before the orchestrator serves
+// requests.
+func (o *Orchestrator) SetModels(llm1, llm2, llm3 string) {
+	o.models = map[string]string{stageLLM1: llm1, stageLLM2: llm2, stageAggregation: llm3}
+}
+
+// SetRouter has the calls router has a route for made by its clients rather than the slots':
+// once a request's intent is known, LLM 1 and LLM 2 are replaced by the route of the intent
+// (llmclient.RouteFlight or RouteGeneral), and LLM 3, which also rewords route answers, by
+// llmclient.RouteAggregation. Calls without a route keep their slot. It must be called before
+// the orchestrator serves requests; nil routes nothing.
+func (o *Orchestrator) SetRouter(router *llmclient.Router) {
+	o.router = router
+}
+
+// routeModels returns the orchestrator to make the LLM calls of a request of intent with: o
+// itself, or a copy with the router's clients for it, given the same system prompt and
+// variation hint as the slots' (see forRequest).
+func (o *Orchestrator) routeModels(ctx context.Context, intent string) *Orchestrator {
+	workers, routedWorkers := o.router.Lookup(intent)
+	aggregation, routedAggregation := o.router.Lookup(llmclient.RouteAggregation)
+	if !routedWorkers && !routedAggregation {
+		return o
+	}
+	wrap := o.wrapClient
+	if wrap == nil {
+		wrap = func(_ string, client llmclient.LLMClient) llmclient.LLMClient { return client }
+	}
+	req := *o
+	req.models = maps.Clone(o.models)
+	if req.models == nil {
+		req.models = make(map[string]string)
+	}
+	if routedWorkers {
+		req.llm1Client, req.llm2Client = wrap(stageLLM1, workers.Client), wrap(stageLLM2, workers.Client)
+		req.models[stageLLM1], req.models[stageLLM2] = workers.Model, workers.Model
+	}
+	if routedAggregation {
+		req.llm3Client = wrap(stageAggregation, aggregation.Client)
+		req.models[stageAggregation] = aggregation.Model
+	}
+	slog.InfoContext(ctx, "Models routed", "intent", intent, "models", req.models)
+	return &req
+}
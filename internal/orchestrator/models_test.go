@@ -0,0 +1,74 @@
+package orchestrator
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+)
+
+func TestModelRouting(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "FL103 is cheapest.", "FL101 is earliest.", "Take FL103.")
+		o.SetModels("slot-1", "slot-2", "slot-3")
+		flight, general, aggregation := mockAnswering("FL101 leaves first."), mockAnswering("Paris."), mockAnswering("Take FL101.")
+		router := llmclient.NewRouter()
+		router.Route(llmclient.RouteFlight, "cheap-model", flight)
+		router.Route(llmclient.RouteGeneral, "strong-model", general)
+		router.Route(llmclient.RouteAggregation, "strong-model", aggregation)
+		o.SetRouter(router)
+
+		// Flight questions go to the flight route, general ones to the general route, and
+		// both are aggregated by the aggregation route; the slots aren't called.
+		events := process(t, o.Orchestrator, "Show me flights from Madrid to Paris", Options{}, stream)
+		if len(flight.Prompts()) != 2 || len(general.Prompts()) != 0 || len(aggregation.Prompts()) != 1 {
+			t.Errorf("stream %v, flight: %d flight, %d general, %d aggregation calls", stream, len(flight.Prompts()), len(general.Prompts()), len(aggregation.Prompts()))
+		}
+		want := map[string]string{stageLLM1: "cheap-model", stageLLM2: "cheap-model", stageAggregation: "strong-model"}
+		if got := telemetryOf(t, events).Models; !maps.Equal(got, want) {
+			t.Errorf("stream %v, flight: models %v, want %v", stream, got, want)
+		}
+		if answer := answerOf(events); answer != "Take FL101." {
+			t.Errorf("stream %v, flight: answer %q", stream, answer)
+		}
+
+		events = process(t, o.Orchestrator, "What is the capital of France?", Options{}, stream)
+		if len(general.Prompts()) != 2 || len(flight.Prompts()) != 2 || len(aggregation.Prompts()) != 2 {
+			t.Errorf("stream %v, general: %d flight, %d general, %d aggregation calls", stream, len(flight.Prompts()), len(general.Prompts()), len(aggregation.Prompts()))
+		}
+		want = map[string]string{stageLLM1: "strong-model", stageLLM2: "strong-model", stageAggregation: "strong-model"}
+		if got := telemetryOf(t, events).Models; !maps.Equal(got, want) {
+			t.Errorf("stream %v, general: models %v, want %v", stream, got, want)
+		}
+		if n := len(o.llm1.Prompts()) + len(o.llm2.Prompts()) + len(o.llm3.Prompts()); n != 0 {
+			t.Errorf("stream %v: %d calls to the slots", stream, n)
+		}
+	}
+}
+
+func TestModelRoutingPartial(t *testing.T) {
+	o := newTestOrchestrator(t, "FL103 is cheapest.", "FL101 is earliest.", "Take FL103.")
+	o.SetModels("slot-1", "slot-2", "slot-3")
+	cheap := mockAnswering("FL101 leaves first.")
+	router := llmclient.NewRouter()
+	router.Route(llmclient.RouteFlight, "cheap-model", cheap)
+	o.SetRouter(router)
+
+	// Calls without a route keep their slots.
+	events := process(t, o.Orchestrator, "What is the capital of France?", Options{}, false)
+	want := map[string]string{stageLLM1: "slot-1", stageLLM2: "slot-2", stageAggregation: "slot-3"}
+	if got := telemetryOf(t, events).Models; !maps.Equal(got, want) || len(o.llm1.Prompts()) != 1 || len(cheap.Prompts()) != 0 {
+		t.Errorf("general: models %v, %d slot calls, %d routed", got, len(o.llm1.Prompts()), len(cheap.Prompts()))
+	}
+	events = process(t, o.Orchestrator, "Show me flights from Madrid to Paris", Options{}, false)
+	want = map[string]string{stageLLM1: "cheap-model", stageLLM2: "cheap-model", stageAggregation: "slot-3"}
+	if got := telemetryOf(t, events).Models; !maps.Equal(got, want) || len(o.llm3.Prompts()) != 2 {
+		t.Errorf("flight: models %v, %d aggregation calls", got, len(o.llm3.Prompts()))
+	}
+
+	// Without SetModels or a router, telemetry names no models.
+	plain := newTestOrchestrator(t, "A.", "B.", "C.")
+	if got := telemetryOf(t, process(t, plain.Orchestrator, "What is the capital of France?", Options{}, false)).Models; got != nil {
+		t.Errorf("models %v without SetModels", got)
+	}
+}
@@ -0,0 +1,145 @@
+package orchestrator
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Numbers in questions are read with the detected language's separators: English writes
+// "1,500.50" and Spanish "1.500,50". A separator that appears once, followed by three digits,
+// is ambiguous ("1.500", "1,500"); it is read as the language's thousands separator if it is
+// one, and as its decimal separator otherwise, so "1.500" is 1500 in Spanish and 1.5 in
+// English. Either separator followed by one or two digits is a decimal one ("99.50", "99,50"),
+// and one that appears more than once separates thousands ("1.500.000"). Groups of three
+// digits may also be separated by spaces ("1 500"), and a number may be followed by "mil",
+// "thousand" or "k" ("1,5 mil", "2k"). Small numbers can be written as words in either
+// language ("mil euros", "two hundred and fifty").
+
+var (
+	// leadingDigits is a number written with digits at the start of a text.
+	leadingDigits = regexp.MustCompile(`^\d+(?:[.,]\d+)*(?:[ \x{00a0}\x{202f}]\d{3}\b)*`)
+	// thousandsSuffix multiplies the number it follows by a thousand.
+	thousandsSuffix = regexp.MustCompile(`^\s?(?:mil|thousand|k)\b`)
+	// currencyPrefix is a currency written before an amount: "$500", "eur 500", "€ 1.500".
+	currencyPrefix = regexp.MustCompile(`^(?:[$€£¥]|(?:usd|eur|gbp|jpy)\b)\s?`)
+	// wordSeparator splits number words: "two hundred", "twenty-five".
+	wordSeparator = regexp.MustCompile(`[\s-]+`)
+)
+
+// thousandsSeparators is the thousands separator of each language that writes it as a point,
+// and its decimal separator as a comma; the others write "1,500.50".
+var thousandsSeparators = map[string]byte{"es": '.'}
+
+// parseNumber reads text, a number written with digits and separators ("1,500", "1.500,50",
+// "1 500"), as a reader of lang would.
+func parseNumber(text, lang string) (float64, bool) {
+	thousands, ok := thousandsSeparators[lang]
+	if !ok {
+		thousands = ','
+	}
+	text = strings.NewReplacer(" ", "", "\u00a0", "", "\u202f", "").Replace(text)
+	dots, commas := strings.Count(text, "."), strings.Count(text, ",")
+	point := -1 // The decimal separator's index
+	switch {
+	case dots > 0 && commas > 0:
+		point = max(strings.LastIndexByte(text, '.'), strings.LastIndexByte(text, ','))
+	case dots == 1 || commas == 1:
+		sep := byte('.') // The only separator
+		if commas == 1 {
+			sep = ','
+		}
+		if point = strings.IndexByte(text, sep); len(text)-point-1 == 3 && sep == thousands {
+			point = -1
+		}
+	}
+	whole, fraction := text, "0"
+	if point >= 0 {
+		whole, fraction = text[:point], text[point+1:]
+	}
+	whole = strings.NewReplacer(".", "", ",", "").Replace(whole)
+	value, err := strconv.ParseFloat(whole+"."+fraction, 64)
+	return value, err == nil
+}
+
+// numberWords are the values of the number words read in questions, in English and Spanish.
+// hundred and thousand multiply what comes before them; see wordsNumber.
+var numberWords = map[string]int{
+	"one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8,
+	"nine": 9, "ten": 10, "eleven": 11, "twelve": 12, "thirteen": 13, "fourteen": 14,
+	"fifteen": 15, "sixteen": 16, "seventeen": 17, "eighteen": 18, "nineteen": 19, "twenty": 20,
+	"thirty": 30, "forty": 40, "fifty": 50, "sixty": 60, "seventy": 70, "eighty": 80, "ninety": 90,
+	"hundred": 100, "thousand": 1000,
+
+	"un": 1, "uno": 1, "una": 1, "dos": 2, "tres": 3, "cuatro": 4, "cinco": 5, "seis": 6,
+	"siete": 7, "ocho": 8, "nueve": 9, "diez": 10, "once": 11, "doce": 12, "trece": 13,
+	"catorce": 14, "quince": 15, "dieciseis": 16, "dieciséis": 16, "diecisiete": 17,
+	"dieciocho": 18, "diecinueve": 19, "veinte": 20, "veintiuno": 21, "veintidos": 22,
+	"veintidós": 22, "veintitres": 23, "veintitrés": 23, "veinticuatro": 24, "veinticinco": 25,
+	"veintiseis": 26, "veintiséis": 26, "veintisiete": 27, "veintiocho": 28, "veintinueve": 29,
+	"treinta": 30, "cuarenta": 40, "cincuenta": 50, "sesenta": 60, "setenta": 70, "ochenta": 80,
+	"noventa": 90, "cien": 100, "ciento": 100, "doscientos": 200, "doscientas": 200,
+	"trescientos": 300, "trescientas": 300, "cuatrocientos": 400, "cuatrocientas": 400,
+	"quinientos": 500, "quinientas": 500, "seiscientos": 600, "seiscientas": 600,
+	"setecientos": 700, "setecientas": 700, "ochocientos": 800, "ochocientas": 800,
+	"novecientos": 900, "novecientas": 900, "mil": 1000,
+}
+
+// wordsNumber reads the number words at the start of text ("mil euros", "a thousand",
+// "treinta y cinco"), returning 0 if it starts with none. An article alone ("un día", "a
+// week") is not a number.
+func wordsNumber(text string) float64 {
+	words := wordSeparator.Split(strings.TrimSpace(text), -1)
+	total, current, counted := 0, 0, false
+	for i, word := range words {
+		word = strings.TrimRight(word, ".,;:!?")
+		value, ok := numberWords[word]
+		switch {
+		case word == "a" && i == 0 && i+1 < len(words) && (words[i+1] == "hundred" || words[i+1] == "thousand"):
+			current = 1
+			continue
+		case (word == "and" || word == "y") && counted && i+1 < len(words):
+			if _, next := numberWords[words[i+1]]; next {
+				continue
+			}
+		}
+		if !ok {
+			break
+		}
+		switch {
+		case word == "hundred":
+			current = max(current, 1) * 100
+		case value == 1000:
+			total += max(current, 1) * 1000
+			current = 0
+		default:
+			current += value
+		}
+		if word != "un" && word != "una" {
+			counted = true
+		}
+	}
+	if !counted {
+		return 0
+	}
+	return float64(total + current)
+}
+
+// numberAt reads the number at the start of text, written with digits or words, after a
+// currency written before it, as a reader of lang would. It returns 0 if text doesn't start
+// with one.
+func numberAt(text, lang string) float64 {
+	text = currencyPrefix.ReplaceAllString(text, "")
+	digits := leadingDigits.FindString(text)
+	if digits == "" {
+		return wordsNumber(text)
+	}
+	value, ok := parseNumber(digits, lang)
+	if !ok {
+		return 0
+	}
+	if thousandsSuffix.MatchString(text[len(digits):]) {
+		value *= 1000
+	}
+	return value
+}
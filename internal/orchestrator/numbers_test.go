@@ -0,0 +1,156 @@
+package orchestrator
+
+import (
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestParseNumber(t *testing.T) {
+	for _, tt := range []struct {
+		text, lang string
+		want       float64
+	}{
+		{"500", "en", 500},
+		{"1,500", "en", 1500},
+		{"1,500.50", "en", 1500.5},
+		{"99.50", "en", 99.5},
+		{"99.5", "en", 99.5},
+		{"1.500", "en", 1.5}, // A point followed by three digits is a decimal one in English
+		{"1,500,000", "en", 1500000},
+		{"1 500", "en", 1500},
+		{"1\u00a0500", "en", 1500},
+		{"1 500.25", "en", 1500.25},
+		{"99,50", "en", 99.5}, // A comma followed by two digits can only be decimal
+		{"1.500,50", "en", 1500.5},
+
+		{"500", "es", 500},
+		{"1.500", "es", 1500},
+		{"1.500,50", "es", 1500.5},
+		{"99,50", "es", 99.5},
+		{"99,5", "es", 99.5},
+		{"1,500", "es", 1.5}, // A comma followed by three digits is a decimal one in Spanish
+		{"1.500.000", "es", 1500000},
+		{"1 500", "es", 1500},
+		{"1 500,25", "es", 1500.25},
+		{"99.50", "es", 99.5}, // A point followed by two digits can only be decimal
+		{"1,500.50", "es", 1500.5},
+		{"2.000", "es", 2000},
+
+		{"1.500", "fr", 1.5}, // Languages without a separator of their own read as English
+	} {
+		if got, ok := parseNumber(tt.text, tt.lang); !ok || got != tt.want {
+			t.Errorf("parseNumber(%q, %s) = %v, %v; want %v", tt.text, tt.lang, got, ok, tt.want)
+		}
+	}
+}
+
+func TestWordsNumber(t *testing.T) {
+	for text, want := range map[string]float64{
+		"mil euros":                   1000,
+		"a thousand dollars":          1000,
+		"a hundred":                   100,
+		"two hundred and fifty":       250,
+		"twenty-five":                 25,
+		"three thousand five hundred": 3500,
+		"treinta y cinco":             35,
+		"doscientos cincuenta euros":  250,
+		"dos mil quinientos":          2500,
+		"ciento veinte":               120,
+		"quinientas libras":           500,
+		"veintidós":                   22,
+		"un día":                      0, // An article alone is no number
+		"a week":                      0,
+		"una semana":                  0,
+		"cheap flights":               0,
+		"":                            0,
+		"five, please":                5,
+		"one thousand and one":        1001,
+		"mil doscientos y pico":       1200,
+	} {
+		if got := wordsNumber(text); got != want {
+			t.Errorf("wordsNumber(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestMaxPriceIn(t *testing.T) {
+	for _, tt := range []struct {
+		question, lang string
+		want           float64
+	}{
+		{"flights to paris under 500", "en", 500},
+		{"flights to paris under 1,500", "en", 1500},
+		{"flights to paris under $1,500", "en", 1500},
+		{"flights to paris under 1,500 usd", "en", 1500},
+		{"flights to paris under 99.50", "en", 99.5},
+		{"flights to paris below £99.50", "en", 99.5},
+		{"flights to paris less than € 1,250.75", "en", 1250.75},
+		{"flights to paris under 2k", "en", 2000},
+		{"flights to paris under 1.5 thousand", "en", 1500},
+		{"flights to paris under a thousand dollars", "en", 1000},
+		{"flights to paris under two hundred and fifty", "en", 250},
+		{"flights to paris under usd 300", "en", 300},
+		{"flights to paris under 1.500", "en", 1.5},
+		{"cheap flights to paris", "en", 0},
+		{"flights to paris under the weather", "en", 0},
+
+		{"vuelos a parís por menos de 500", "es", 500},
+		{"vuelos a parís por menos de 1.500 €", "es", 1500},
+		{"vuelos a parís por menos de 1.500,50 €", "es", 1500.5},
+		{"vuelos a parís por menos de 99,50 euros", "es", 99.5},
+		{"vuelos a parís por menos de €99,50", "es", 99.5},
+		{"vuelos a parís por menos de mil euros", "es", 1000},
+		{"vuelos a parís por menos de 1,5 mil euros", "es", 1500},
+		{"vuelos a parís por menos de dos mil", "es", 2000},
+		{"vuelos a parís inferior a 300 eur", "es", 300},
+		{"vuelos a parís bajo 1 500 €", "es", 1500},
+		{"vuelos a parís por menos de doscientos cincuenta euros", "es", 250},
+		{"vuelos a parís por menos de 1,500", "es", 1.5},
+		{"vuelos baratos a parís", "es", 0},
+		{"vuelos a parís por menos de un día", "es", 0},
+	} {
+		if got := maxPriceIn(tt.question, tt.lang); got != tt.want {
+			t.Errorf("maxPriceIn(%q, %s) = %v, want %v", tt.question, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestBudgetIn(t *testing.T) {
+	for _, tt := range []struct {
+		message, lang string
+		want          float64
+	}{
+		{"remember my budget is $300", "en", 300},
+		{"remember my budget is 1,500", "en", 1500},
+		{"remember my budget is a thousand dollars", "en", 1000},
+		{"remember i travel under 250", "en", 250},
+		{"recuerda que mi presupuesto es de 1.500 €", "es", 1500},
+		{"recuerda que mi presupuesto es de mil euros", "es", 1000},
+		{"recuerda que mi presupuesto es de 99,50", "es", 99.5},
+		{"recuerda que mi presupuesto es ajustado", "es", 0},
+	} {
+		if got := budgetIn(tt.message, tt.lang); got != tt.want {
+			t.Errorf("budgetIn(%q, %s) = %v, want %v", tt.message, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestPriceLimitUnderstood(t *testing.T) {
+	// The question's language decides how "1.500" reads.
+	for _, tt := range []struct {
+		message string
+		want    float64
+	}{
+		{"Vuelos de Madrid a París por menos de 1.500 €", 1500},
+		{"Vuelos de Madrid a París por menos de mil euros", 1000},
+		{"Flights from Madrid to Paris under €1,500", 1500},
+		{"Flights from Madrid to Paris under €115.50", 115.5},
+	} {
+		o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+		understood := ofType(process(t, o.Orchestrator, tt.message, Options{}, true), sse.TypeQueryUnderstanding)
+		if len(understood) != 1 || understood[0].Payload.(sse.QueryUnderstandingPayload).MaxPrice != tt.want {
+			t.Errorf("%q understood as %+v, want a limit of %v", tt.message, understood, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package orchestrator
+
+import (
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/flags"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+)
+
+// Languages the prompts are available in, as used by Options.Language and detectLanguage.
+const (
+	LanguageEnglish = "English"
+	LanguageSpanish = "Spanish"
+)
+
+// languageCodes maps the prompt languages to their i18n catalog codes.
+var languageCodes = map[string]string{
+	LanguageEnglish: "en",
+	LanguageSpanish: "es",
+}
+
+// LanguageCode returns the i18n catalog code of the language a request is answered in: the
+// override in opts, the session's preferred one, or the one detected from message, unless the
+// message is too short to tell and the session's conversation is in another. Callers use it
+// for the texts they write about a request themselves, such as queue positions, so they match
+// the pipeline's.
+func LanguageCode(opts Options, message string) string {
+	language, _ := requestLanguage(opts, message)
+	if code, ok := languageCodes[language]; ok {
+		return code
+	}
+	return i18n.Default
+}
+
+// requestLanguage returns the language a request is answered in, and whether it is the
+// session's preferred one. The language the session's conversation has been in holds unless
+// the message is confidently in another (see i18n.ConfidentDetection).
+func requestLanguage(opts Options, message string) (language string, preferred bool) {
+	if opts.Language != "" {
+		return opts.Language, false
+	}
+	if opts.Preferences != nil {
+		if language := languageOf(opts.Preferences.Language); language != "" {
+			return language, true
+		}
+		if language := languageOf(opts.Preferences.ConversationLanguage); language != "" {
+			code, confidence := i18n.DetectConfidence(message)
+			if confidence < i18n.ConfidentDetection || code == opts.Preferences.ConversationLanguage {
+				return language, false
+			}
+			if detected := languageOf(code); detected != "" {
+				return detected, false
+			}
+		}
+	}
+	return detectLanguage(message), false
+}
+
+// languageOf returns the prompt language of an i18n catalog code, or "" if it has none.
+func languageOf(code string) string {
+	for language, c := range languageCodes {
+		if c == code {
+			return language
+		}
+	}
+	return ""
+}
+
+// Options are the per-request settings a client can pass along with its message.
+// The zero value gives the default behaviour.
+type Options struct {
+	SessionID       string // Client-chosen conversation identifier, recorded in the query log
+	Language        string // LanguageEnglish or LanguageSpanish; empty means detect from the message
+	SkipAggregation bool   // Return the two worker answers without the LLM 3 aggregation step
+	Regenerate      bool   // The message was answered before; ask the LLMs for a different answer
+
+	// Flags are the feature flags that are on for the request (see package flags). A flag turns
+	// its behavior on for this request even when it is off server-wide.
+	Flags flags.Set
+
+	// PersonaOverrides are system prompts for some of the request's stages (PersonaWorker1,
+	// PersonaWorker2, PersonaAggregator) in place of the persona's (see SetPersona), so
+	// instructions can be tried out without a deployment. They are recorded in the query log
+	// and named in the telemetry. Callers must check them with ValidatePersonaOverrides.
+	PersonaOverrides map[string]string
+
+	// Preferences are the session's remembered defaults, to fill in what a flight question
+	// leaves out and the language when Language is empty, and to save what a "remember
+	// that ..." message asks for. Client must be set to the caller's account. Nil means the
+	// request can't have any, e.g. because it has no session ID.
+	Preferences *db.Preferences
+
+	// Repeat is the session's answer to the same message, sent a moment ago, for a client
+	// that sent it twice: it is sent again, with a note saying so, instead of asking the LLMs.
+	// The request's intent is "repeat". Nil answers the message as usual.
+	Repeat *db.Turn
+
+	// OnIntent, if set, is called with the detected intent ("flight", "routes", "compare",
+	// "local_time", "preferences", "repeat" or "general") as soon as intent detection finishes, so
+	// callers can show what a running request is doing.
+	OnIntent func(intent string)
+}
@@ -2,31 +2,41 @@ package orchestrator
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"regexp"
-	"strconv"
+	"runtime/debug"
 	"strings"
-	"sync"
+	"text/template"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Cris245/go-llm-chat/internal/currency"
 	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
 	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/persona"
 	"github.com/Cris245/go-llm-chat/internal/sse"
+	"github.com/Cris245/go-llm-chat/internal/timezone"
+	"github.com/Cris245/go-llm-chat/internal/tracing"
 )
 
 // detectLanguage determines if the message is in Spanish or English
 func detectLanguage(message string) string {
-	lower := strings.ToLower(message)
-
-	// Spanish indicators
-	spanishWords := []string{"hola", "como", "estas", "que", "hay", "vuelos", "vuelo", "desde", "hacia", "menos", "bajo", "inferior", "cuanto", "cuesta", "precio", "costo", "duracion", "tiempo"}
-
-	for _, word := range spanishWords {
-		if strings.Contains(lower, word) {
-			return "Spanish"
-		}
+	if i18n.Detect(message) == "es" {
+		return LanguageSpanish
 	}
+	return LanguageEnglish
+}
 
-	return "English"
+// combineAnswers joins the two worker answers under their labels, for when there is no
+// aggregated answer. kind is "flights" or "general", the path that produced them.
+func combineAnswers(lang, kind, llm1Resp, llm2Resp string) string {
+	return i18n.T(lang, "label."+kind+".llm1") + "\n" + llm1Resp + "\n\n" + i18n.T(lang, "label."+kind+".llm2") + "\n" + llm2Resp
 }
 
 // Orchestrator coordinates interactions with the LLMs and the database.
@@ -35,6 +45,43 @@ type Orchestrator struct {
 	llm2Client llmclient.LLMClient // Client for the second LLM
 	llm3Client llmclient.LLMClient // Client for the third LLM
 	dbClient   db.Client           // Client for database operations (new field)
+
+	queryLogEnabled bool       // Whether each request is recorded in the query audit log
+	storePrompts    bool       // Record the LLM calls and answer in the query log; see StorePrompts
+	redactQuery     RedactFunc // Optional hook applied to the user's message before it is logged
+
+	telemetryHooks []TelemetryHook // Called with every request's summary; see AddTelemetryHook
+	hideTelemetry  bool            // Leave the summary out of Done events; see HideTelemetry
+	replica        string          // Names the server in the telemetry; see SetReplica
+
+	tokenBudget TokenBudget         // Per-request token limit; see SetTokenBudget
+	weather     WeatherEnrichment   // Forecasts for flight answers; see SetWeather
+	currency    *currency.Converter // Converts price limits and shown prices; see SetCurrency
+	cities      *CityIndex          // The cities questions can name; see SetCities
+	timeZones   *timezone.Table     // Time zones of the cities and airports; see SetTimeZones
+
+	groundingCheck bool // Compare flight answers with their records; see EnableGroundingCheck
+	routePhrasing  bool // Have LLM 3 reword answers to route questions; see EnableRoutePhrasing
+	languageCheck  bool // Ask LLM 3 again for answers in the wrong language; see EnableLanguageCheck
+	dbOnly         bool // Answer from the database without LLM calls; see EnableDBOnly
+
+	workerProgress time.Duration // How often streamed worker calls report progress; see SetWorkerProgress
+	condensation   Condensation  // Shortening of long worker answers before aggregation; see SetCondensation
+
+	flightLines map[string]*template.Template // Canonical flight lines by language; see SetFlightFormat
+
+	outputLimit    OutputLimit   // Longest answer sent; see SetOutputLimit
+	coalesceWindow time.Duration // How long streamed chunks are merged; see SetChunkCoalescing
+	coalesceBytes  int           // How much merged text is sent at once
+
+	persona       *persona.Persona  // Deployment system prompt; see SetPersona
+	systemPrompts map[string]string // Each stage's system prompt for the request; set by forRequest
+
+	models map[string]string // Model of each LLM stage; see SetModels and SetRouter
+	router *llmclient.Router // Clients for some calls in place of the slots'; see SetRouter
+
+	// wrapClient is how forRequest wrapped each stage's client, for the routed ones.
+	wrapClient func(stage string, client llmclient.LLMClient) llmclient.LLMClient
 }
 
 // NewOrchestrator creates a new instance of Orchestrator.
@@ -45,153 +92,306 @@ func NewOrchestrator(llm1, llm2, llm3 llmclient.LLMClient, dbClient db.Client) *
 		llm2Client: llm2,
 		llm3Client: llm3,
 		dbClient:   dbClient, // Assign the database client
+		currency:   currency.NewConverter(currency.USD, currency.DefaultRates),
+		cities:     NewCityIndex(dbClient, nil),
+		timeZones:  defaultTimeZones,
+
+		coalesceWindow: sse.DefaultCoalesceWindow,
+		coalesceBytes:  sse.DefaultCoalesceBytes,
 	}
 }
 
-// ProcessMessage orchestrates the calls to the LLMs and sends SSE events.
-// It takes the user's message and a channel to send SSE events back to the client.
-func (o *Orchestrator) ProcessMessage(ctx context.Context, userMessage string, eventChan chan<- sse.Event) {
-	// Detect if the question is about flights
-	lowerMsg := strings.ToLower(userMessage)
-	if strings.Contains(lowerMsg, "vuelo") || strings.Contains(lowerMsg, "vuelos") || strings.Contains(lowerMsg, "flight") || strings.Contains(lowerMsg, "flights") {
-		// Map of synonyms (lowercase) to their canonical DB names
-		synonyms := map[string]string{
-			"madrid":      "Madrid",
-			"paris":       "Paris",
-			"parís":       "Paris",
-			"barcelona":   "Barcelona",
-			"london":      "London",
-			"londres":     "London",
-			"new york":    "New York",
-			"roma":        "Rome",
-			"rome":        "Rome",
-			"los angeles": "Los Angeles",
-			"berlin":      "Berlin",
-			"tokyo":       "Tokyo",
-			"seville":     "Seville",
-			"sevilla":     "Seville",
-			"valencia":    "Valencia",
-		}
+// RedactFunc rewrites a user message before it is stored in the query log (e.g. to mask personal data).
+type RedactFunc func(message string) string
 
-		var origin, destination string
-		var maxPrice float64
+// EnableQueryLog turns on the per-request query audit log.
+// redact may be nil, in which case messages are stored verbatim; it also rewrites the prompts,
+// responses and answers stored with StorePrompts.
+func (o *Orchestrator) EnableQueryLog(redact RedactFunc) {
+	o.queryLogEnabled = true
+	o.redactQuery = redact
+}
 
-		lower := strings.ToLower(userMessage)
-		for syn, canon := range synonyms {
-			if origin == "" && (strings.Contains(lower, "from "+syn) || strings.Contains(lower, "desde "+syn)) {
-				origin = canon
-			}
-			if destination == "" && (strings.Contains(lower, "to "+syn) || strings.Contains(lower, " a "+syn) || strings.Contains(lower, "hacia "+syn)) {
-				destination = canon
-			}
+// StorePrompts adds each request's LLM calls, with their prompts and responses, and its answer
+// to its query log record (db.QueryLog.Calls and Answer), so a bad answer can be traced back
+// through the pipeline. It has no effect without the query log. It must be called before the
+// orchestrator serves requests.
+func (o *Orchestrator) StorePrompts() {
+	o.storePrompts = true
+}
+
+// newQueryLog starts the audit record for a request. Paths fill in the remaining fields as they go.
+// The language recorded here (the caller's override, the session's preferred one, or the
+// detected one) is the one the prompts use.
+func newQueryLog(userMessage string, opts Options) *db.QueryLog {
+	language, preferred := requestLanguage(opts, userMessage)
+	entry := &db.QueryLog{
+		Timestamp:        time.Now(),
+		SessionID:        opts.SessionID,
+		Message:          userMessage,
+		DetectedLanguage: language,
+		Intent:           "general",
+		Flags:            opts.Flags,
+		PersonaOverrides: opts.PersonaOverrides,
+	}
+	if preferred {
+		entry.Preferences = append(entry.Preferences, preferenceLanguage)
+	}
+	return entry
+}
+
+// ErrCancelled is the cancellation cause that marks a request as stopped on purpose by the
+// client: cancel the context passed to ProcessMessage with it (context.WithCancelCause) and the
+// stream ends with a Done event whose outcome is "cancelled" rather than "error".
+var ErrCancelled = errors.New("request cancelled")
+
+// finish ends a request. It runs deferred from ProcessMessage/ProcessMessageStream so that,
+// whether the pipeline returned normally, failed or panicked, the query is recorded and
+// exactly one Done event is sent as the last event of the stream.
+// failure points at the pipeline's error, if any; a panic or an expired context also count as errors,
+// except that a context cancelled with ErrCancelled is reported as cancelled whatever failed because of it.
+// timings holds the stages the request went through, for its telemetry. transcript is the
+// request's answer when the grounding check is on, in which case the query is recorded and the
+// hooks are called once the check is done, in the background.
+func (o *Orchestrator) finish(ctx context.Context, entry *db.QueryLog, timings *stageTimings, failure *error, transcript *answerTranscript, eventChan chan<- sse.Event) {
+	if p := recover(); p != nil {
+		slog.ErrorContext(ctx, "Orchestration panicked", "panic", p, "stack", string(debug.Stack()))
+		entry.Error = fmt.Sprintf("panic: %v", p)
+		*failure = errors.New("internal error")
+	}
+	if *failure == nil && ctx.Err() != nil {
+		entry.Error = context.Cause(ctx).Error()
+		*failure = ctx.Err()
+	}
+	entry.RequestID = logging.RequestID(ctx)
+	entry.DurationMs = time.Since(entry.Timestamp).Milliseconds()
+	entry.Models = timings.modelSnapshot()
+	entry.StagesMs = timings.snapshot()
+	if o.storePrompts {
+		entry.Calls = timings.callSnapshot()
+	}
+	if transcript == nil {
+		o.recordQuery(ctx, entry)
+	}
+
+	telemetry := telemetryFrom(entry)
+	telemetry.RequestID = entry.RequestID
+	telemetry.Replica = o.replica
+	telemetry.StagesMs = entry.StagesMs
+	if b := llmclient.BudgetFrom(ctx); b != nil {
+		telemetry.TokensUsed = b.Used()
+	}
+	done := sse.DonePayload{Outcome: sse.OutcomeOK, DurationMs: entry.DurationMs}
+	if !o.hideTelemetry {
+		done.Telemetry = telemetry
+	}
+	switch {
+	case errors.Is(context.Cause(ctx), ErrCancelled):
+		done.Outcome = sse.OutcomeCancelled
+		slog.InfoContext(ctx, "Request cancelled by the client", "duration_ms", entry.DurationMs)
+	case *failure != nil:
+		done.Outcome, done.Error = sse.OutcomeError, (*failure).Error()
+	}
+	eventChan <- sse.Done(done)
+	if transcript != nil {
+		transcript.close()
+		go o.reportGrounded(context.WithoutCancel(ctx), entry, telemetry, done.Outcome, transcript)
+		return
+	}
+	for _, hook := range o.telemetryHooks {
+		hook(telemetry, done.Outcome)
+	}
+}
+
+// endIntentSpan records what intent detection concluded, ends its span and tells opts.OnIntent.
+func endIntentSpan(span trace.Span, entry *db.QueryLog, opts Options) {
+	if opts.OnIntent != nil {
+		opts.OnIntent(entry.Intent)
+	}
+	span.SetAttributes(
+		attribute.String("intent", entry.Intent),
+		attribute.String("language", entry.DetectedLanguage),
+		attribute.String("flight.origin", entry.Origin),
+		attribute.String("flight.destination", entry.Destination),
+	)
+	span.End()
+}
+
+// recordQuery writes the audit record once a request finishes, if the query log is enabled.
+// The write runs in the background on a context detached from the request, so a client
+// that has already disconnected is still logged and the stream isn't held open by the insert.
+func (o *Orchestrator) recordQuery(ctx context.Context, entry *db.QueryLog) {
+	if !o.queryLogEnabled {
+		return
+	}
+	if o.redactQuery != nil {
+		entry.Message = o.redactQuery(entry.Message)
+		entry.Answer = o.redactQuery(entry.Answer)
+		for i := range entry.Calls {
+			entry.Calls[i].Prompt = o.redactQuery(entry.Calls[i].Prompt)
+			entry.Calls[i].Response = o.redactQuery(entry.Calls[i].Response)
+		}
+	}
+	logCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	go func() {
+		defer cancel()
+		if err := o.dbClient.InsertQueryLog(logCtx, *entry); err != nil {
+			slog.ErrorContext(logCtx, "Failed to write query log", "error", err)
 		}
+	}()
+}
 
-		// If destination still hasn't been found, attempt single-city detection ("... a londres?", "... londres?")
-		if destination == "" {
-			for syn, canon := range synonyms {
-				if strings.Contains(lower, syn) && canon != origin {
-					destination = canon
-					break
-				}
-			}
+// pricePhrase introduces a price limit in a question (e.g. "under 500", "menos de 1.500 €",
+// "below £99.50"). The currency is read separately; see applyCurrency.
+var pricePhrase = regexp.MustCompile(`\b(?:under|less than|below|menos de|bajo|inferior a)\s+`)
+
+// maxPriceIn returns the price limit in the lowercased question, written as a reader of lang
+// would (see parseNumber), or 0 if it sets none.
+func maxPriceIn(lower, lang string) float64 {
+	for _, m := range pricePhrase.FindAllStringIndex(lower, -1) {
+		if price := numberAt(lower[m[1]:], lang); price > 0 {
+			return price
 		}
+	}
+	return 0
+}
 
-		// Extract price constraints (e.g., "under 500", "less than 300", "below 1000")
-		pricePatterns := []string{
-			"under (\\d+)",
-			"less than (\\d+)",
-			"below (\\d+)",
-			"under \\$(\\d+)",
-			"less than \\$(\\d+)",
-			"below \\$(\\d+)",
-			"menos de (\\d+)",
-			"bajo (\\d+)",
-			"inferior a (\\d+)",
-			"menos de \\$(\\d+)",
-			"bajo \\$(\\d+)",
-			"inferior a \\$(\\d+)",
+// ProcessMessage orchestrates the calls to the LLMs and sends SSE events.
+// It takes the user's message and a channel to send SSE events back to the client.
+func (o *Orchestrator) ProcessMessage(ctx context.Context, userMessage string, opts Options, eventChan chan<- sse.Event) {
+	entry := newQueryLog(userMessage, opts)
+	timings := newStageTimings()
+	ctx = o.startBudget(ctx) // Before finish is deferred, so it can report the tokens used
+	var failure error
+	o = o.withFlags(opts.Flags)
+	eventChan, transcript := o.watchAnswer(eventChan) // For the grounding check and stored answers
+	defer o.finish(ctx, entry, timings, &failure, transcript, eventChan)
+	o = o.forRequest(opts, entry.DetectedLanguage)
+	lang := languageCodes[entry.DetectedLanguage] // For the texts we write ourselves
+	opts.Preferences = o.followLanguage(ctx, opts.Preferences, lang, eventChan)
+	if opts.Repeat != nil {
+		entry.Intent = "repeat"
+		if opts.OnIntent != nil {
+			opts.OnIntent(entry.Intent)
 		}
+		o.repeatAnswer(ctx, entry, lang, *opts.Repeat, false, eventChan)
+		return
+	}
+	// A "yes" to the reverse route the last answer suggested searches that route.
+	var suggested *db.SuggestedRoute
+	suggested, opts.Preferences = o.takeSuggestedRoute(ctx, opts.Preferences, strings.ToLower(userMessage))
 
-		for _, pattern := range pricePatterns {
-			if matches := regexp.MustCompile(pattern).FindStringSubmatch(lower); len(matches) > 1 {
-				if price, err := strconv.ParseFloat(matches[1], 64); err == nil {
-					maxPrice = price
-					break
-				}
-			}
+	// Detect if the question is about flights
+	intentStart := time.Now()
+	_, intentSpan := tracing.Start(ctx, "orchestrator.detect_intent")
+	lowerMsg := strings.ToLower(userMessage)
+	if isRememberRequest(lowerMsg) {
+		entry.Intent = "preferences"
+		endIntentSpan(intentSpan, entry, opts)
+		timings.since(stageIntent, intentStart)
+		o.rememberPreferences(ctx, entry, userMessage, lang, opts.Preferences, &failure, eventChan)
+		return
+	}
+	if question, ok := detectLocalTimeQuestion(lowerMsg); ok {
+		entry.Intent = "local_time"
+		endIntentSpan(intentSpan, entry, opts)
+		timings.since(stageIntent, intentStart)
+		o.answerLocalTimes(ctx, entry, question, lang, opts.Preferences, timings, &failure, eventChan)
+		return
+	}
+	if question, ok := detectRouteQuestion(lowerMsg); ok {
+		entry.Intent = "routes"
+		endIntentSpan(intentSpan, entry, opts)
+		timings.since(stageIntent, intentStart)
+		o = o.routeModels(ctx, entry.Intent)
+		o.answerRoutes(ctx, entry, question, lang, false, timings, &failure, eventChan)
+		return
+	}
+	if c, ok := detectComparison(userMessage); ok {
+		entry.Intent = "compare"
+		o.applyCurrency(ctx, entry, userMessage, lang, opts.Preferences, eventChan)
+		endIntentSpan(intentSpan, entry, opts)
+		timings.since(stageIntent, intentStart)
+		o = o.routeModels(ctx, entry.Intent)
+		o.answerComparison(ctx, entry, userMessage, c, lang, false, timings, &failure, eventChan)
+		return
+	}
+	if suggested != nil || strings.Contains(lowerMsg, "vuelo") || strings.Contains(lowerMsg, "vuelos") || strings.Contains(lowerMsg, "flight") || strings.Contains(lowerMsg, "flights") {
+		lower := strings.ToLower(userMessage)
+		folded := foldCity(lower)
+		cities := o.cities.current(ctx)
+		origin, destination := cities.extractRoute(folded)
+
+		// Extract price constraints (e.g., "under 500", "less than 300", "below 1000")
+		maxPrice := maxPriceIn(lower, lang)
+
+		entry.Intent, entry.Origin, entry.Destination, entry.MaxPrice = "flight", origin.city, destination.city, maxPrice
+		entry.OriginAirport, entry.DestinationAirport = origin.airport, destination.airport
+		opts.Preferences = o.applyPassengers(ctx, entry, userMessage, opts.Preferences)
+		confident := directionConfident(folded, origin.city, destination.city, cities.byName)
+		if suggested != nil {
+			// Searched as it was offered, with its price limit and currency.
+			takeSuggestion(entry, suggested)
+			confident = true
+		} else {
+			o.applyCurrency(ctx, entry, userMessage, lang, opts.Preferences, eventChan)
+			o.applyPreferences(ctx, entry, userMessage, lang, opts.Preferences, eventChan)
 		}
+		endIntentSpan(intentSpan, entry, opts)
+		timings.since(stageIntent, intentStart)
 
 		// If both origin and destination are empty, search without filters (all flights).
-		flights, err := o.dbClient.SearchFlights(ctx, origin, destination, maxPrice)
-		if err != nil || len(flights) == 0 {
-			eventChan <- sse.Event{Type: "Message", Data: "No flights found for your query."}
-			return
-		}
-		flightsInfo := ""
-		for _, f := range flights {
-			flightsInfo += fmt.Sprintf("Flight %s: %s -> %s, departure %s, arrival %s, price $%.2f\n",
-				f.FlightNumber, f.Origin, f.Destination, f.DepartureTime, f.ArrivalTime, f.Price)
-		}
+		// The question's understanding, its models and the worker instructions are ready by
+		// the time the database answers.
+		search := o.startSearch(ctx, entry)
+		o.sendUnderstanding(ctx, entry, lang, confident, eventChan)
+		o = o.routeModels(ctx, entry.Intent)
 
 		// Detect language and create language-specific prompts
-		language := detectLanguage(userMessage)
-		var promptLLM1, promptLLM2 string
+		language := entry.DetectedLanguage
+		var instructionsLLM1, instructionsLLM2 string
 
 		if language == "Spanish" {
-			promptLLM1 = "Lista los vuelos disponibles de los siguientes datos. Solo lista los vuelos, no proporciones información adicional. Responde en español.\n" + flightsInfo
-			promptLLM2 = "Para cada vuelo en los siguientes datos, di cuánto tiempo toma y cuánto cuesta. Responde en español.\n" + flightsInfo
+			instructionsLLM1 = dataOnlyNotice(language) + "Lista los vuelos disponibles de los siguientes datos. Solo lista los vuelos, no proporciones información adicional. Responde en español.\n"
+			instructionsLLM2 = dataOnlyNotice(language) + "Para cada vuelo en los siguientes datos, di cuánto tiempo toma y cuánto cuesta. Responde en español.\n"
 		} else {
-			promptLLM1 = "List the available flights from the following data. Only list the flights, do not provide extra information.\n" + flightsInfo
-			promptLLM2 = "For each flight in the following data, say how long the flight takes and how much it costs.\n" + flightsInfo
+			instructionsLLM1 = dataOnlyNotice(language) + "List the available flights from the following data. Only list the flights, do not provide extra information.\n"
+			instructionsLLM2 = dataOnlyNotice(language) + "For each flight in the following data, say how long the flight takes and how much it costs.\n"
 		}
 
-		// Channels to collect responses
-		llm1RespChan := make(chan string, 1)
-		llm2RespChan := make(chan string, 1)
-		var wg sync.WaitGroup
-		wg.Add(2)
-
-		// LLM1 goroutine
-		go func() {
-			defer wg.Done()
-			eventChan <- sse.Event{Type: "Status", Data: "Invoking LLM 1 (list available flights only)"}
-			resp, err := o.llm1Client.ChatCompletion(ctx, promptLLM1)
-			if err != nil {
-				llm1RespChan <- "[LLM1 Error] " + err.Error()
-			} else {
-				llm1RespChan <- resp
-			}
-			eventChan <- sse.Event{Type: "Status", Data: "Got response from LLM 1"}
-		}()
-
-		// LLM2 goroutine
-		go func() {
-			defer wg.Done()
-			eventChan <- sse.Event{Type: "Status", Data: "Invoking LLM 2 (calculate duration and cost for each flight)"}
-			resp, err := o.llm2Client.ChatCompletion(ctx, promptLLM2)
-			if err != nil {
-				llm2RespChan <- "[LLM2 Error] " + err.Error()
-			} else {
-				llm2RespChan <- resp
-			}
-			eventChan <- sse.Event{Type: "Status", Data: "Got response from LLM 2"}
-		}()
-
-		// Wait for both LLMs
-		wg.Wait()
-		close(llm1RespChan)
-		close(llm2RespChan)
-
-		// Collect responses
-		llm1Resp := <-llm1RespChan
-		llm2Resp := <-llm2RespChan
+		flights, flightsInfo, ok := o.searchFlights(ctx, entry, lang, confident, opts.Preferences, search, timings, &failure, eventChan)
+		if !ok {
+			return
+		}
+		if o.dbOnly {
+			o.answerFromRecords(ctx, entry, lang, flights, false, eventChan)
+			return
+		}
+		// The forecast is fetched while the workers answer, for the aggregation prompt.
+		weatherAtArrival := o.lookupWeather(ctx, entry, userMessage, flights, timings)
+		promptLLM1 := instructionsLLM1 + flightsInfo
+		promptLLM2 := instructionsLLM2 + flightsInfo + partyNote(language, entry.Passengers)
+
+		// Both workers run concurrently; aggregation starts once both have answered or failed.
+		workerCtx, ok := o.budgetWorkers(ctx, entry, lang, opts, promptLLM1, promptLLM2, &failure, eventChan)
+		if !ok {
+			return
+		}
+		llm1, llm2 := o.runWorkers(workerCtx, lang, "_flights", promptLLM1, promptLLM2, timings, eventChan)
+		forecast, hasForecast := weatherAtArrival.wait(ctx, eventChan)
+		llm1Resp, llm2Resp, ok := o.workerAnswers(ctx, entry, lang, "flights", opts, llm1, llm2, eventChan)
+		if !ok {
+			return
+		}
 
 		// Now use LLM3 to aggregate the responses
-		eventChan <- sse.Event{Type: "Status", Data: "Invoking LLM 3 (aggregation)"}
+		eventChan <- sse.Status(i18n.T(lang, "status.llm3.invoke"))
 
-		var aggregationPrompt string
+		condensed1, condensed2 := o.condenseAnswers(ctx, entry, timings, llm1Resp, llm2Resp)
+		fenced1, fenced2 := fencedAnswers(ctx, condensed1, condensed2)
+		aggregationPrompt := dataOnlyNotice(language)
 		if language == "Spanish" {
-			aggregationPrompt = fmt.Sprintf(`Eres un agregador inteligente. Combina estas dos respuestas sobre vuelos en una respuesta coherente y bien formateada:
+			aggregationPrompt += fmt.Sprintf(`Eres un agregador inteligente. Combina estas dos respuestas sobre vuelos en una respuesta coherente y bien formateada:
 
 Respuesta de LLM1 (lista de vuelos):
 %s
@@ -206,9 +406,9 @@ Por favor crea una respuesta unificada que:
 4. Elimine cualquier redundancia entre las dos respuestas
 5. Mantenga toda la información importante de ambas respuestas
 6. Use formato simple como "Vuelo FL101:" en lugar de "**Vuelo FL101:**"
-7. Responde completamente en español`, llm1Resp, llm2Resp)
+7. Responde completamente en español`, fenced1, fenced2)
 		} else {
-			aggregationPrompt = fmt.Sprintf(`You are an intelligent aggregator. Combine these two responses about flights into one coherent, well-formatted answer:
+			aggregationPrompt += fmt.Sprintf(`You are an intelligent aggregator. Combine these two responses about flights into one coherent, well-formatted answer:
 
 LLM1 Response (flight list):
 %s
@@ -222,23 +422,26 @@ Please create a unified response that:
 3. Uses clean formatting without excessive markdown (avoid ** for emphasis)
 4. Removes any redundancy between the two responses
 5. Maintains all the important information from both responses
-6. Uses simple formatting like "Flight FL101:" instead of "**Flight FL101:**"`, llm1Resp, llm2Resp)
+6. Uses simple formatting like "Flight FL101:" instead of "**Flight FL101:**"`, fenced1, fenced2)
 		}
-
-		llm3Resp, err := o.llm3Client.ChatCompletion(ctx, aggregationPrompt)
-		if err != nil {
-			eventChan <- sse.Event{Type: "Status", Data: "LLM3 aggregation failed"}
-			// Fallback to combined response
-			combined := "LLM1 (flights list):\n" + llm1Resp + "\n\nLLM2 (duration and cost):\n" + llm2Resp
-			eventChan <- sse.Event{Type: "Message", Data: combined}
-		} else {
-			eventChan <- sse.Event{Type: "Status", Data: "Got response from LLM 3"}
-			eventChan <- sse.Event{Type: "Message", Data: llm3Resp}
+		aggregationPrompt += condensedNote(language, entry) + partyNote(language, entry.Passengers)
+		if hasForecast {
+			aggregationPrompt += weatherSection(ctx, language, forecast)
 		}
+
+		o.aggregate(ctx, entry, lang, "flights", flights, aggregationPrompt, llm1Resp, llm2Resp, timings, &failure, eventChan)
+		return
+	}
+	endIntentSpan(intentSpan, entry, opts)
+	timings.since(stageIntent, intentStart)
+	if o.dbOnly {
+		o.sendAnswer(ctx, entry, lang, i18n.T(lang, "message.db_only.general"), eventChan)
 		return
 	}
+	o = o.routeModels(ctx, entry.Intent)
+
 	// Detect language and prepare language-specific prompts
-	language := detectLanguage(userMessage)
+	language := entry.DetectedLanguage
 	var promptLLM1, promptLLM2 string
 
 	if language == "Spanish" {
@@ -249,208 +452,151 @@ Please create a unified response that:
 		promptLLM2 = "Please answer the following question in a friendly, verbose, and opinionated way, providing more information and your thoughts: " + userMessage
 	}
 
-	// Channels to collect responses
-	llm1RespChan := make(chan string, 1)
-	llm2RespChan := make(chan string, 1)
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	// LLM1 goroutine
-	go func() {
-		defer wg.Done()
-		eventChan <- sse.Event{Type: "Status", Data: "Invoking LLM 1"}
-		resp, err := o.llm1Client.ChatCompletion(ctx, promptLLM1)
-		if err != nil {
-			llm1RespChan <- "[LLM1 Error] " + err.Error()
-		} else {
-			llm1RespChan <- resp
-		}
-		eventChan <- sse.Event{Type: "Status", Data: "Got response from LLM 1"}
-	}()
-
-	// LLM2 goroutine
-	go func() {
-		defer wg.Done()
-		eventChan <- sse.Event{Type: "Status", Data: "Invoking LLM 2"}
-		resp, err := o.llm2Client.ChatCompletion(ctx, promptLLM2)
-		if err != nil {
-			llm2RespChan <- "[LLM2 Error] " + err.Error()
-		} else {
-			llm2RespChan <- resp
-		}
-		eventChan <- sse.Event{Type: "Status", Data: "Got response from LLM 2"}
-	}()
-
-	// Wait for both LLMs
-	wg.Wait()
-	close(llm1RespChan)
-	close(llm2RespChan)
-
-	// Collect responses
-	llm1Resp := <-llm1RespChan
-	llm2Resp := <-llm2RespChan
-
-	// Use LLM3 to aggregate the two different style responses
-	eventChan <- sse.Event{Type: "Status", Data: "Invoking LLM 3 (aggregation)"}
-
-	var aggregationPrompt string
-	if language == "Spanish" {
-		aggregationPrompt = fmt.Sprintf(`Eres un agregador inteligente. Combina estas dos respuestas a la misma pregunta en una respuesta coherente y bien equilibrada:
-
-Respuesta de LLM1 (formal y concisa):
-%s
-
-Respuesta de LLM2 (amigable y verbosa):
-%s
-
-Al inicio de tu respuesta, menciona brevemente que LLM1 es corto/formal/conciso y LLM2 es amigable/verboso/con opiniones.
-
-Por favor crea una respuesta unificada que:
-1. Combine lo mejor de ambos estilos
-2. Esté bien formateada y sea fácil de leer
-3. Elimine redundancia manteniendo toda la información importante
-4. Mantenga un tono equilibrado entre formal y amigable
-5. Responda completamente en español`, llm1Resp, llm2Resp)
-	} else {
-		aggregationPrompt = fmt.Sprintf(`You are an intelligent aggregator. Combine these two responses to the same question into one coherent, well-balanced answer:
-
-LLM1 Response (formal and concise):
-%s
-
-LLM2 Response (friendly and verbose):
-%s
-
-At the top of your answer, briefly state that LLM1 is short/formal/concise and LLM2 is friendly/verbose/opinionated.
-
-Please create a unified response that:
-1. Combines the best of both styles
-2. Is well-formatted and easy to read
-3. Removes redundancy while keeping all important information
-4. Maintains a balanced tone between formal and friendly`, llm1Resp, llm2Resp)
+	// Both workers run concurrently; aggregation starts once both have answered or failed.
+	workerCtx, ok := o.budgetWorkers(ctx, entry, lang, opts, promptLLM1, promptLLM2, &failure, eventChan)
+	if !ok {
+		return
 	}
-
-	llm3Resp, err := o.llm3Client.ChatCompletion(ctx, aggregationPrompt)
-	if err != nil {
-		eventChan <- sse.Event{Type: "Status", Data: "LLM3 aggregation failed"}
-		// Fallback to combined response
-		combined := "LLM1 (short, formal, concise):\n" + llm1Resp + "\n\nLLM2 (friendly, verbose, opinionated):\n" + llm2Resp
-		eventChan <- sse.Event{Type: "Message", Data: combined}
-	} else {
-		eventChan <- sse.Event{Type: "Status", Data: "Got response from LLM 3"}
-		eventChan <- sse.Event{Type: "Message", Data: llm3Resp}
+	llm1, llm2 := o.runWorkers(workerCtx, lang, "", promptLLM1, promptLLM2, timings, eventChan)
+	llm1Resp, llm2Resp, ok := o.workerAnswers(ctx, entry, lang, "general", opts, llm1, llm2, eventChan)
+	if !ok {
+		return
 	}
+
+	// Use LLM3 to aggregate the two different style responses
+	eventChan <- sse.Status(i18n.T(lang, "status.llm3.invoke"))
+	condensed1, condensed2 := o.condenseAnswers(ctx, entry, timings, llm1Resp, llm2Resp)
+	prompt := generalAggregationPrompt(ctx, language, condensed1, condensed2) + condensedNote(language, entry)
+	o.aggregate(ctx, entry, lang, "general", nil, prompt, llm1Resp, llm2Resp, timings, &failure, eventChan)
 }
 
 // ProcessMessageStream orchestrates the calls to the LLMs and streams the final response.
 // This version uses streaming for the final LLM3 response to provide real-time updates.
-func (o *Orchestrator) ProcessMessageStream(ctx context.Context, userMessage string, eventChan chan<- sse.Event) {
+func (o *Orchestrator) ProcessMessageStream(ctx context.Context, userMessage string, opts Options, eventChan chan<- sse.Event) {
+	entry := newQueryLog(userMessage, opts)
+	timings := newStageTimings()
+	ctx = o.startBudget(ctx) // Before finish is deferred, so it can report the tokens used
+	var failure error
+	o = o.withFlags(opts.Flags)
+	eventChan, transcript := o.watchAnswer(eventChan) // For the grounding check and stored answers
+	defer o.finish(ctx, entry, timings, &failure, transcript, eventChan)
+	o = o.forRequest(opts, entry.DetectedLanguage)
+	lang := languageCodes[entry.DetectedLanguage] // For the texts we write ourselves
+	opts.Preferences = o.followLanguage(ctx, opts.Preferences, lang, eventChan)
+	if opts.Repeat != nil {
+		entry.Intent = "repeat"
+		if opts.OnIntent != nil {
+			opts.OnIntent(entry.Intent)
+		}
+		o.repeatAnswer(ctx, entry, lang, *opts.Repeat, true, eventChan)
+		return
+	}
+	// A "yes" to the reverse route the last answer suggested searches that route.
+	var suggested *db.SuggestedRoute
+	suggested, opts.Preferences = o.takeSuggestedRoute(ctx, opts.Preferences, strings.ToLower(userMessage))
+
 	// Detect if the question is about flights
+	intentStart := time.Now()
+	_, intentSpan := tracing.Start(ctx, "orchestrator.detect_intent")
 	lower := strings.ToLower(userMessage)
+	folded := foldCity(lower)
+	cities := o.cities.current(ctx)
 	isFlightQuery := strings.Contains(lower, "vuelo") || strings.Contains(lower, "flight") ||
-		strings.Contains(lower, "fly") || strings.Contains(lower, "airplane") ||
-		strings.Contains(lower, "madrid") || strings.Contains(lower, "paris") ||
-		strings.Contains(lower, "london") || strings.Contains(lower, "londres") ||
-		strings.Contains(lower, "barcelona") || strings.Contains(lower, "valencia") ||
-		strings.Contains(lower, "seville") || strings.Contains(lower, "sevilla") ||
-		strings.Contains(lower, "tokyo") || strings.Contains(lower, "new york") ||
-		strings.Contains(lower, "los angeles") || strings.Contains(lower, "berlin") ||
-		strings.Contains(lower, "rome") || strings.Contains(lower, "roma")
-
-	if isFlightQuery {
-		// Map of synonyms (lowercase) to their canonical DB names
-		synonyms := map[string]string{
-			"madrid": "Madrid", "paris": "Paris", "london": "London", "londres": "London",
-			"barcelona": "Barcelona", "valencia": "Valencia", "seville": "Seville", "sevilla": "Seville",
-			"tokyo": "Tokyo", "new york": "New York", "nyc": "New York", "jfk": "New York",
-			"los angeles": "Los Angeles", "la": "Los Angeles", "lax": "Los Angeles",
-			"berlin": "Berlin", "rome": "Rome", "roma": "Rome",
-		}
+		strings.Contains(lower, "fly") || strings.Contains(lower, "airplane") || cities.mentions(folded)
 
-		// Extract origin and destination from the query
-		origin := ""
-		destination := ""
-
-		// Look for origin-destination patterns
-		for syn, canon := range synonyms {
-			if strings.Contains(lower, "from "+syn) || strings.Contains(lower, "desde "+syn) {
-				origin = canon
-			}
-			if strings.Contains(lower, "to "+syn) || strings.Contains(lower, " a "+syn) || strings.Contains(lower, "hacia "+syn) {
-				destination = canon
-			}
-		}
+	if isRememberRequest(lower) {
+		entry.Intent = "preferences"
+		endIntentSpan(intentSpan, entry, opts)
+		timings.since(stageIntent, intentStart)
+		o.rememberPreferences(ctx, entry, userMessage, lang, opts.Preferences, &failure, eventChan)
+		return
+	}
+	if question, ok := detectLocalTimeQuestion(lower); ok {
+		entry.Intent = "local_time"
+		endIntentSpan(intentSpan, entry, opts)
+		timings.since(stageIntent, intentStart)
+		o.answerLocalTimes(ctx, entry, question, lang, opts.Preferences, timings, &failure, eventChan)
+		return
+	}
+	if question, ok := detectRouteQuestion(lower); ok {
+		entry.Intent = "routes"
+		endIntentSpan(intentSpan, entry, opts)
+		timings.since(stageIntent, intentStart)
+		o = o.routeModels(ctx, entry.Intent)
+		o.answerRoutes(ctx, entry, question, lang, true, timings, &failure, eventChan)
+		return
+	}
+	if c, ok := detectComparison(userMessage); ok {
+		entry.Intent = "compare"
+		o.applyCurrency(ctx, entry, userMessage, lang, opts.Preferences, eventChan)
+		endIntentSpan(intentSpan, entry, opts)
+		timings.since(stageIntent, intentStart)
+		o = o.routeModels(ctx, entry.Intent)
+		o.answerComparison(ctx, entry, userMessage, c, lang, true, timings, &failure, eventChan)
+		return
+	}
 
-		// If destination still hasn't been found, attempt single-city detection ("... a londres?", "... londres?")
-		if destination == "" {
-			for syn, canon := range synonyms {
-				if strings.Contains(lower, syn) && canon != origin {
-					destination = canon
-					break
-				}
-			}
+	if suggested != nil || isFlightQuery {
+		// Extract origin and destination from the query
+		origin, destination := cities.extractRoute(folded)
+
+		entry.Intent, entry.Origin, entry.Destination, entry.MaxPrice = "flight", origin.city, destination.city, maxPriceIn(lower, lang)
+		entry.OriginAirport, entry.DestinationAirport = origin.airport, destination.airport
+		opts.Preferences = o.applyPassengers(ctx, entry, userMessage, opts.Preferences)
+		confident := directionConfident(folded, origin.city, destination.city, cities.byName)
+		if suggested != nil {
+			// Searched as it was offered, with its price limit and currency.
+			takeSuggestion(entry, suggested)
+			confident = true
+		} else {
+			o.applyCurrency(ctx, entry, userMessage, lang, opts.Preferences, eventChan)
+			o.applyPreferences(ctx, entry, userMessage, lang, opts.Preferences, eventChan)
 		}
+		endIntentSpan(intentSpan, entry, opts)
+		timings.since(stageIntent, intentStart)
 
 		// If both origin and destination are empty, search without filters (all flights).
-		flights, err := o.dbClient.SearchFlights(ctx, origin, destination, 0)
-		if err != nil || len(flights) == 0 {
-			eventChan <- sse.Event{Type: "Message", Data: "No flights found for your query."}
-			return
-		}
-		flightsInfo := ""
-		for _, f := range flights {
-			flightsInfo += fmt.Sprintf("Flight %s: %s -> %s, departure %s, arrival %s, price $%.2f\n",
-				f.FlightNumber, f.Origin, f.Destination, f.DepartureTime, f.ArrivalTime, f.Price)
-		}
+		// The question's understanding, its models and the worker instructions are ready by
+		// the time the database answers.
+		search := o.startSearch(ctx, entry)
+		o.sendUnderstanding(ctx, entry, lang, confident, eventChan)
+		o = o.routeModels(ctx, entry.Intent)
 		// LLM1: List the available flights
-		promptLLM1 := "List the available flights from the following data. Only list the flights, do not provide extra information.\n" + flightsInfo
+		instructionsLLM1 := dataOnlyNotice(LanguageEnglish) + "List the available flights from the following data. Only list the flights, do not provide extra information.\n"
 		// LLM2: For each flight, say how long it takes and how much it costs
-		promptLLM2 := "For each flight in the following data, say how long the flight takes and how much it costs.\n" + flightsInfo
-
-		// Channels to collect responses
-		llm1RespChan := make(chan string, 1)
-		llm2RespChan := make(chan string, 1)
-		var wg sync.WaitGroup
-		wg.Add(2)
-
-		// LLM1 goroutine
-		go func() {
-			defer wg.Done()
-			eventChan <- sse.Event{Type: "Status", Data: "Invoking LLM 1"}
-			resp, err := o.llm1Client.ChatCompletion(ctx, promptLLM1)
-			if err != nil {
-				llm1RespChan <- "[LLM1 Error] " + err.Error()
-			} else {
-				llm1RespChan <- resp
-			}
-			eventChan <- sse.Event{Type: "Status", Data: "Got response from LLM 1"}
-		}()
-
-		// LLM2 goroutine
-		go func() {
-			defer wg.Done()
-			eventChan <- sse.Event{Type: "Status", Data: "Invoking LLM 2"}
-			resp, err := o.llm2Client.ChatCompletion(ctx, promptLLM2)
-			if err != nil {
-				llm2RespChan <- "[LLM2 Error] " + err.Error()
-			} else {
-				llm2RespChan <- resp
-			}
-			eventChan <- sse.Event{Type: "Status", Data: "Got response from LLM 2"}
-		}()
-
-		// Wait for both LLMs
-		wg.Wait()
-		close(llm1RespChan)
-		close(llm2RespChan)
+		instructionsLLM2 := dataOnlyNotice(LanguageEnglish) + "For each flight in the following data, say how long the flight takes and how much it costs.\n"
 
-		// Collect responses
-		llm1Resp := <-llm1RespChan
-		llm2Resp := <-llm2RespChan
+		flights, flightsInfo, ok := o.searchFlights(ctx, entry, lang, confident, opts.Preferences, search, timings, &failure, eventChan)
+		if !ok {
+			return
+		}
+		if o.dbOnly {
+			o.answerFromRecords(ctx, entry, lang, flights, true, eventChan)
+			return
+		}
+		// The forecast is fetched while the workers answer, for the aggregation prompt.
+		weatherAtArrival := o.lookupWeather(ctx, entry, userMessage, flights, timings)
+		promptLLM1 := instructionsLLM1 + flightsInfo
+		promptLLM2 := instructionsLLM2 + flightsInfo + partyNote(LanguageEnglish, entry.Passengers)
+
+		// Both workers run concurrently; aggregation starts once both have answered or failed.
+		workerCtx, ok := o.budgetWorkers(ctx, entry, lang, opts, promptLLM1, promptLLM2, &failure, eventChan)
+		if !ok {
+			return
+		}
+		llm1, llm2 := o.runWorkers(workerCtx, lang, "_flights", promptLLM1, promptLLM2, timings, eventChan)
+		forecast, hasForecast := weatherAtArrival.wait(ctx, eventChan)
+		llm1Resp, llm2Resp, ok := o.workerAnswers(ctx, entry, lang, "flights", opts, llm1, llm2, eventChan)
+		if !ok {
+			return
+		}
 
 		// Now use LLM3 to aggregate the responses with streaming
-		eventChan <- sse.Event{Type: "Status", Data: "Invoking LLM 3 (aggregation)"}
+		eventChan <- sse.Status(i18n.T(lang, "status.llm3.invoke"))
 
-		aggregationPrompt := fmt.Sprintf(`You are an intelligent aggregator. Combine these two responses about flights into one coherent, well-formatted answer:
+		condensed1, condensed2 := o.condenseAnswers(ctx, entry, timings, llm1Resp, llm2Resp)
+		fenced1, fenced2 := fencedAnswers(ctx, condensed1, condensed2)
+		aggregationPrompt := dataOnlyNotice(LanguageEnglish) + fmt.Sprintf(`You are an intelligent aggregator. Combine these two responses about flights into one coherent, well-formatted answer:
 
 LLM1 Response (flight list):
 %s
@@ -463,26 +609,25 @@ Please create a unified response that:
 2. Includes duration and cost for each flight
 3. Is well-formatted and easy to read
 4. Removes any redundancy between the two responses
-5. Maintains all the important information from both responses`, llm1Resp, llm2Resp)
-
-		// Use streaming for the final response
-		streamChan, err := o.llm3Client.StreamChatCompletion(ctx, aggregationPrompt)
-		if err != nil {
-			eventChan <- sse.Event{Type: "Status", Data: "LLM3 aggregation failed"}
-			// Fallback to combined response
-			combined := "LLM1 (flights list):\n" + llm1Resp + "\n\nLLM2 (duration and cost):\n" + llm2Resp
-			eventChan <- sse.Event{Type: "Message", Data: combined}
-		} else {
-			eventChan <- sse.Event{Type: "Status", Data: "Got response from LLM 3"}
-			// Stream the final response
-			for chunk := range streamChan {
-				eventChan <- sse.Event{Type: "Message", Data: chunk}
-			}
+5. Maintains all the important information from both responses`, fenced1, fenced2)
+		aggregationPrompt += condensedNote(LanguageEnglish, entry) + partyNote(LanguageEnglish, entry.Passengers)
+		if hasForecast {
+			aggregationPrompt += weatherSection(ctx, LanguageEnglish, forecast)
 		}
+
+		o.aggregateStream(ctx, entry, lang, "flights", flights, aggregationPrompt, llm1Resp, llm2Resp, timings, &failure, eventChan)
+		return
+	}
+	endIntentSpan(intentSpan, entry, opts)
+	timings.since(stageIntent, intentStart)
+	if o.dbOnly {
+		o.sendAnswer(ctx, entry, lang, i18n.T(lang, "message.db_only.general"), eventChan)
 		return
 	}
+	o = o.routeModels(ctx, entry.Intent)
+
 	// Detect language and prepare language-specific prompts
-	language := detectLanguage(userMessage)
+	language := entry.DetectedLanguage
 	var promptLLM1, promptLLM2 string
 
 	if language == "Spanish" {
@@ -493,53 +638,172 @@ Please create a unified response that:
 		promptLLM2 = "Please answer the following question in a friendly, verbose, and opinionated way, providing more information and your thoughts: " + userMessage
 	}
 
-	// Channels to collect responses
-	llm1RespChan := make(chan string, 1)
-	llm2RespChan := make(chan string, 1)
-	var wg sync.WaitGroup
-	wg.Add(2)
+	// Both workers run concurrently; aggregation starts once both have answered or failed.
+	workerCtx, ok := o.budgetWorkers(ctx, entry, lang, opts, promptLLM1, promptLLM2, &failure, eventChan)
+	if !ok {
+		return
+	}
+	llm1, llm2 := o.runWorkers(workerCtx, lang, "", promptLLM1, promptLLM2, timings, eventChan)
+	llm1Resp, llm2Resp, ok := o.workerAnswers(ctx, entry, lang, "general", opts, llm1, llm2, eventChan)
+	if !ok {
+		return
+	}
 
-	// LLM1 goroutine
-	go func() {
-		defer wg.Done()
-		eventChan <- sse.Event{Type: "Status", Data: "Invoking LLM 1"}
-		resp, err := o.llm1Client.ChatCompletion(ctx, promptLLM1)
-		if err != nil {
-			llm1RespChan <- "[LLM1 Error] " + err.Error()
-		} else {
-			llm1RespChan <- resp
-		}
-		eventChan <- sse.Event{Type: "Status", Data: "Got response from LLM 1"}
-	}()
+	// Use LLM3 to aggregate the two different style responses with streaming
+	eventChan <- sse.Status(i18n.T(lang, "status.llm3.invoke"))
+	condensed1, condensed2 := o.condenseAnswers(ctx, entry, timings, llm1Resp, llm2Resp)
+	prompt := generalAggregationPrompt(ctx, language, condensed1, condensed2) + condensedNote(language, entry)
+	o.aggregateStream(ctx, entry, lang, "general", nil, prompt, llm1Resp, llm2Resp, timings, &failure, eventChan)
+}
 
-	// LLM2 goroutine
-	go func() {
-		defer wg.Done()
-		eventChan <- sse.Event{Type: "Status", Data: "Invoking LLM 2"}
-		resp, err := o.llm2Client.ChatCompletion(ctx, promptLLM2)
-		if err != nil {
-			llm2RespChan <- "[LLM2 Error] " + err.Error()
-		} else {
-			llm2RespChan <- resp
-		}
-		eventChan <- sse.Event{Type: "Status", Data: "Got response from LLM 2"}
-	}()
+// searchFlights waits for search, the search for the flight question in entry (see
+// startSearch), and sends its results. It
+// returns the flights, also formatted and fenced for the worker prompts, or ok false when the
+// request has been answered already: the search failed, found nothing, or looks like it was
+// for the reverse route, which the answer asks about instead (see suggestReverse; confident
+// is whether the question's direction was clear, and prefs the session's preferences).
+func (o *Orchestrator) searchFlights(ctx context.Context, entry *db.QueryLog, lang string, confident bool, prefs *db.Preferences, search *flightSearch, timings *stageTimings, failure *error, eventChan chan<- sse.Event) (flights []db.Flight, flightsInfo string, ok bool) {
+	<-search.done
+	timings.since(stageSearch, search.start)
+	flights, err := search.flights, search.err
+	entry.ResultCount = len(flights)
+	if age, stale := search.staleness.Stale(); stale && err == nil {
+		// The database was slow and the cache answered with results that had expired.
+		eventChan <- sse.Status(i18n.T(lang, "status.stale_flights", age.Round(time.Second)))
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		*failure = err
+	}
+	if errors.Is(err, db.ErrUnavailable) {
+		slog.WarnContext(ctx, "Flight search unavailable", "error", err)
+		eventChan <- sse.Error("search_unavailable", i18n.T(lang, "error.search_unavailable"))
+		return nil, "", false
+	}
+	if err == nil && o.suggestReverse(ctx, entry, lang, len(flights), confident, prefs, eventChan) {
+		return nil, "", false
+	}
+	if err != nil || len(flights) == 0 {
+		eventChan <- sse.MessageChunk(i18n.T(lang, "message.no_flights"), true)
+		return nil, "", false
+	}
+	// Structured results for JSON clients; plain clients just see the count.
+	eventChan <- sse.FlightResults(withParty(o.withLocalTimes(flights), entry.Passengers))
+	o.rememberFlights(ctx, prefs, flights)
+	// Records are untrusted: each one is kept to its own line so the scrubbing drops the
+	// whole record if it carries an instruction, and the list is fenced as data.
+	oneLine := func(field string) string { return strings.Join(strings.Fields(field), " ") }
+	var b strings.Builder
+	for _, f := range flights {
+		fmt.Fprintf(&b, "Flight %s: %s -> %s, departure %s, arrival %s, duration %s, price %s\n",
+			oneLine(f.FlightNumber), oneLine(db.PlaceName(f.Origin, f.OriginAirport)), oneLine(db.PlaceName(f.Destination, f.DestinationAirport)),
+			oneLine(o.departureText(f)), oneLine(o.arrivalText(f)), formatDuration(lang, flightDuration(f)), o.priceText(ctx, entry, lang, f.Price))
+	}
+	return flights, fence("FLIGHT DATA", sanitizeUntrusted(ctx, "flight_data", b.String())), true
+}
 
-	// Wait for both LLMs
-	wg.Wait()
-	close(llm1RespChan)
-	close(llm2RespChan)
+// flightQuery is the search for the flight question in entry: its cities, narrowed to the
+// airports it named, under its price limit, with seats for its party.
+func flightQuery(entry *db.QueryLog) db.FlightQuery {
+	return db.FlightQuery{
+		Origin:             entry.Origin,
+		Destination:        entry.Destination,
+		OriginAirport:      entry.OriginAirport,
+		DestinationAirport: entry.DestinationAirport,
+		MaxPrice:           entry.MaxPrice,
+		MinSeats:           entry.Passengers,
+	}
+}
+
+// workerAnswers decides what to do with the worker results before aggregation. It returns
+// both answers for LLM 3, a failed worker's error text in place of its answer, or ok false
+// when the request has been answered already: it was cancelled, or aggregation is off (the
+// answers are sent side by side).
+func (o *Orchestrator) workerAnswers(ctx context.Context, entry *db.QueryLog, lang, kind string, opts Options, llm1, llm2 workerResult, eventChan chan<- sse.Event) (llm1Resp, llm2Resp string, ok bool) {
+	// A cancelled or expired request has nothing worth aggregating or showing.
+	if ctx.Err() != nil {
+		return "", "", false
+	}
+	// Without aggregation the worker answers are returned side by side.
+	if opts.SkipAggregation {
+		o.sendAnswer(ctx, entry, lang, combineAnswers(lang, kind, llm1.text(lang, "LLM1"), llm2.text(lang, "LLM2")), eventChan)
+		return "", "", false
+	}
+	return llm1.text(lang, "LLM1"), llm2.text(lang, "LLM2"), true
+}
 
-	// Collect responses
-	llm1Resp := <-llm1RespChan
-	llm2Resp := <-llm2RespChan
+// aggregate asks LLM 3 to combine the worker answers and sends its answer, its flight lines
+// rewritten from flights (see SetFlightFormat), or the combined worker answers if the call
+// fails. A request whose budget can't pay for the call is stopped instead (see
+// budgetAggregation).
+func (o *Orchestrator) aggregate(ctx context.Context, entry *db.QueryLog, lang, kind string, flights []db.Flight, prompt, llm1Resp, llm2Resp string, timings *stageTimings, failure *error, eventChan chan<- sse.Event) {
+	ctx, ok := o.budgetAggregation(ctx, entry, lang, prompt, failure, eventChan)
+	if !ok {
+		return
+	}
+	start := time.Now()
+	callCtx := llmclient.WithResponseInfo(ctx)
+	llm3Resp, err := o.llm3Client.ChatCompletion(callCtx, prompt)
+	timings.since(stageAggregation, start)
+	timings.ranOn(stageAggregation, o.models[stageAggregation])
+	timings.called(callCtx, stageAggregation, o.models[stageAggregation], prompt, llm3Resp, err, start)
+	if err != nil {
+		entry.Error = "aggregation: " + err.Error()
+		eventChan <- sse.Status(i18n.T(lang, "status.llm3.failed"))
+		// Fallback to combined response
+		o.sendAnswer(ctx, entry, lang, combineAnswers(lang, kind, llm1Resp, llm2Resp), eventChan)
+		return
+	}
+	eventChan <- sse.Status(i18n.T(lang, "status.llm3.done"))
+	if o.languageCheck {
+		if got, off := offLanguage(entry, llm3Resp); off {
+			if retried, ok := o.retryInLanguage(ctx, entry, lang, prompt, got, timings, eventChan); ok {
+				llm3Resp = retried
+			}
+		}
+	}
+	o.sendAnswer(ctx, entry, lang, o.formatFlightLines(ctx, entry, lang, flights, llm3Resp), eventChan)
+}
 
-	// Use LLM3 to aggregate the two different style responses with streaming
-	eventChan <- sse.Event{Type: "Status", Data: "Invoking LLM 3 (aggregation)"}
+// aggregateStream is aggregate with LLM 3's answer streamed as it is written. The aggregation
+// stage lasts until the last chunk has been sent.
+func (o *Orchestrator) aggregateStream(ctx context.Context, entry *db.QueryLog, lang, kind string, flights []db.Flight, prompt, llm1Resp, llm2Resp string, timings *stageTimings, failure *error, eventChan chan<- sse.Event) {
+	ctx, ok := o.budgetAggregation(ctx, entry, lang, prompt, failure, eventChan)
+	if !ok {
+		return
+	}
+	start := time.Now()
+	defer timings.since(stageAggregation, start)
+	timings.ranOn(stageAggregation, o.models[stageAggregation])
+	streamCtx, stop := context.WithCancel(llmclient.WithResponseInfo(ctx)) // Stopped early if the answer runs too long
+	defer stop()
+	streamChan, err := o.llm3Client.StreamChatCompletion(streamCtx, prompt)
+	if err != nil {
+		timings.called(streamCtx, stageAggregation, o.models[stageAggregation], prompt, "", err, start)
+		entry.Error = "aggregation: " + err.Error()
+		eventChan <- sse.Status(i18n.T(lang, "status.llm3.failed"))
+		// Fallback to combined response, streamed in chunks all the same
+		o.streamAnswer(ctx, entry, lang, combineAnswers(lang, kind, llm1Resp, llm2Resp), eventChan)
+		return
+	}
+	eventChan <- sse.Status(i18n.T(lang, "status.llm3.done"))
+	// Stream the final response
+	streamChan = timings.calledStream(streamCtx, stageAggregation, o.models[stageAggregation], prompt, start, streamChan)
+	if o.languageCheck {
+		var answer string
+		if streamChan, answer, ok = o.checkStreamLanguage(ctx, entry, lang, prompt, streamChan, stop, timings, eventChan); ok {
+			o.streamAnswer(ctx, entry, lang, o.formatFlightLines(ctx, entry, lang, flights, answer), eventChan)
+			return
+		}
+	}
+	o.forwardChunks(ctx, entry, lang, o.formatFlightStream(ctx, entry, lang, flights, streamChan), stop, eventChan)
+}
 
-	var aggregationPrompt string
+// generalAggregationPrompt asks LLM 3 to combine the two answers to a general question.
+func generalAggregationPrompt(ctx context.Context, language, llm1Resp, llm2Resp string) string {
+	fenced1, fenced2 := fencedAnswers(ctx, llm1Resp, llm2Resp)
 	if language == "Spanish" {
-		aggregationPrompt = fmt.Sprintf(`Eres un agregador inteligente. Combina estas dos respuestas a la misma pregunta en una respuesta coherente y bien equilibrada:
+		return dataOnlyNotice(language) + fmt.Sprintf(`Eres un agregador inteligente. Combina estas dos respuestas a la misma pregunta en una respuesta coherente y bien equilibrada:
 
 Respuesta de LLM1 (formal y concisa):
 %s
@@ -554,9 +818,9 @@ Por favor crea una respuesta unificada que:
 2. Esté bien formateada y sea fácil de leer
 3. Elimine redundancia manteniendo toda la información importante
 4. Mantenga un tono equilibrado entre formal y amigable
-5. Responda completamente en español`, llm1Resp, llm2Resp)
-	} else {
-		aggregationPrompt = fmt.Sprintf(`You are an intelligent aggregator. Combine these two responses to the same question into one coherent, well-balanced answer:
+5. Responda completamente en español`, fenced1, fenced2)
+	}
+	return dataOnlyNotice(language) + fmt.Sprintf(`You are an intelligent aggregator. Combine these two responses to the same question into one coherent, well-balanced answer:
 
 LLM1 Response (formal and concise):
 %s
@@ -570,21 +834,5 @@ Please create a unified response that:
 1. Combines the best of both styles
 2. Is well-formatted and easy to read
 3. Removes redundancy while keeping all important information
-4. Maintains a balanced tone between formal and friendly`, llm1Resp, llm2Resp)
-	}
-
-	// Use streaming for the final response
-	streamChan, err := o.llm3Client.StreamChatCompletion(ctx, aggregationPrompt)
-	if err != nil {
-		eventChan <- sse.Event{Type: "Status", Data: "LLM3 aggregation failed"}
-		// Fallback to combined response
-		combined := "LLM1 (formal):\n" + llm1Resp + "\n\nLLM2 (friendly):\n" + llm2Resp
-		eventChan <- sse.Event{Type: "Message", Data: combined}
-	} else {
-		eventChan <- sse.Event{Type: "Status", Data: "Got response from LLM 3"}
-		// Stream the final response
-		for chunk := range streamChan {
-			eventChan <- sse.Event{Type: "Message", Data: chunk}
-		}
-	}
+4. Maintains a balanced tone between formal and friendly`, fenced1, fenced2)
 }
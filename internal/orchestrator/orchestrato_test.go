@@ -0,0 +1,509 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+	"github.com/Cris245/go-llm-chat/internal/tracing"
+	"github.com/Cris245/go-llm-chat/internal/version"
+)
+
+// recordingClient passes calls on to next and records their prompts.
+type recordingClient struct {
+	next llmclient.LLMClient
+
+	mu      sync.Mutex
+	prompts []string
+}
+
+func (c *recordingClient) record(prompt string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prompts = append(c.prompts, prompt)
+}
+
+// Prompts returns the prompts of the calls made so far.
+func (c *recordingClient) Prompts() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.prompts...)
+}
+
+func (c *recordingClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	c.record(prompt)
+	return c.next.ChatCompletion(ctx, prompt)
+}
+
+func (c *recordingClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	c.record(prompt)
+	return c.next.StreamChatCompletion(ctx, prompt)
+}
+
+// mockAnswering returns a mock client that answers answer at once, recording its prompts.
+func mockAnswering(answer string) *recordingClient {
+	return &recordingClient{next: &llmclient.MockClient{Response: answer}}
+}
+
+// testOrchestrator is an orchestrator over the seeded memory backend, with mock LLMs that
+// record their prompts.
+type testOrchestrator struct {
+	*Orchestrator
+	db               *db.MemoryClient
+	llm1, llm2, llm3 *recordingClient
+}
+
+// newTestOrchestrator returns an orchestrator over a memory backend seeded with the sample
+// flights, whose LLMs answer with the given texts.
+func newTestOrchestrator(t testing.TB, answer1, answer2, answer3 string) *testOrchestrator {
+	t.Helper()
+	store := db.NewMemoryClient()
+	if err := store.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	o := &testOrchestrator{db: store, llm1: mockAnswering(answer1), llm2: mockAnswering(answer2), llm3: mockAnswering(answer3)}
+	o.Orchestrator = NewOrchestrator(o.llm1, o.llm2, o.llm3, store)
+	if err := o.cities.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// process runs one request and returns its events.
+func process(t testing.TB, o *Orchestrator, message string, opts Options, stream bool) []sse.Event {
+	t.Helper()
+	events := make(chan sse.Event, 1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if stream {
+		o.ProcessMessageStream(ctx, message, opts, events)
+	} else {
+		o.ProcessMessage(ctx, message, opts, events)
+	}
+	close(events)
+	var out []sse.Event
+	for ev := range events {
+		out = append(out, ev)
+	}
+	return out
+}
+
+// ofType returns the events of type typ.
+func ofType(events []sse.Event, typ string) []sse.Event {
+	var out []sse.Event
+	for _, ev := range events {
+		if ev.Type == typ {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// answerOf returns the text of the Message events, joined.
+func answerOf(events []sse.Event) string {
+	var b strings.Builder
+	for _, ev := range ofType(events, sse.TypeMessage) {
+		b.WriteString(ev.Data)
+	}
+	return b.String()
+}
+
+// telemetryOf returns the Done event's telemetry.
+func telemetryOf(t testing.TB, events []sse.Event) Telemetry {
+	t.Helper()
+	done := ofType(events, sse.TypeDone)
+	if len(done) != 1 {
+		t.Fatalf("%d Done events, want 1", len(done))
+	}
+	payload, ok := done[0].Payload.(sse.DonePayload)
+	if !ok {
+		t.Fatalf("Done payload is %T", done[0].Payload)
+	}
+	telemetry, ok := payload.Telemetry.(Telemetry)
+	if !ok {
+		t.Fatalf("Done telemetry is %T", payload.Telemetry)
+	}
+	return telemetry
+}
+
+func TestProcessMessageFlightQuestion(t *testing.T) {
+	o := newTestOrchestrator(t, "FL101 leaves at 08:00.", "FL101 takes 2h.", "FL101 is the one.")
+	events := process(t, o.Orchestrator, "Show me flights from Madrid to Paris", Options{}, false)
+
+	if len(ofType(events, sse.TypeFlightResults)) != 1 {
+		t.Errorf("no FlightResults event in %v", events)
+	}
+	if got := answerOf(events); got != "FL101 is the one." {
+		t.Errorf("answer = %q", got)
+	}
+	if events[len(events)-1].Type != sse.TypeDone {
+		t.Errorf("last event is %s, want Done", events[len(events)-1].Type)
+	}
+	if telemetry := telemetryOf(t, events); telemetry.Intent != "flight" || telemetry.Version != version.Version {
+		t.Errorf("intent = %q, version %q; want flight and the build's version", telemetry.Intent, telemetry.Version)
+	}
+}
+
+func TestProcessMessageGeneralQuestion(t *testing.T) {
+	o := newTestOrchestrator(t, "Paris.", "The capital is Paris.", "Paris is the capital of France.")
+	events := process(t, o.Orchestrator, "What is the capital of France?", Options{}, true)
+
+	if len(ofType(events, sse.TypeFlightResults)) != 0 {
+		t.Error("a general question searched flights")
+	}
+	if got := answerOf(events); got != "Paris is the capital of France." {
+		t.Errorf("answer = %q", got)
+	}
+	if len(o.llm1.Prompts()) != 1 || len(o.llm2.Prompts()) != 1 || len(o.llm3.Prompts()) != 1 {
+		t.Errorf("calls = %d, %d, %d, want one each", len(o.llm1.Prompts()), len(o.llm2.Prompts()), len(o.llm3.Prompts()))
+	}
+}
+
+func TestSpanishStatuses(t *testing.T) {
+	o := newTestOrchestrator(t, "FL101 sale a las 08:00.", "FL101 dura 2h.", "FL101 es el mejor.")
+	events := process(t, o.Orchestrator, "¿Hay vuelos desde Madrid hacia París?", Options{}, false)
+
+	var statuses []string
+	for _, ev := range ofType(events, sse.TypeStatus) {
+		statuses = append(statuses, ev.Data)
+	}
+	for _, key := range []string{"status.llm1.invoke_flights", "status.llm2.invoke_flights", "status.llm3.invoke", "status.llm3.done"} {
+		if !slices.Contains(statuses, i18n.T("es", key)) {
+			t.Errorf("no %q among the statuses %q", i18n.T("es", key), statuses)
+		}
+		if slices.Contains(statuses, i18n.T("en", key)) {
+			t.Errorf("English %q among the statuses of a Spanish question", i18n.T("en", key))
+		}
+	}
+}
+
+// queryLogOf waits for the audit record of the request requestID to be written, in the background.
+func queryLogOf(t *testing.T, o *testOrchestrator, requestID string) db.QueryLog {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		entry, err := o.db.GetQueryLog(context.Background(), requestID)
+		if err == nil {
+			return entry
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("request %s wasn't logged: %v", requestID, err)
+		}
+	}
+}
+
+func TestQueryLogRecordsRequest(t *testing.T) {
+	o := newTestOrchestrator(t, "FL101 leaves at 08:00.", "FL101 takes 2h.", "FL101 is the one.")
+	o.EnableQueryLog(func(message string) string {
+		return strings.ReplaceAll(message, "ana@example.com", "[email]")
+	})
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	events := make(chan sse.Event, 1024)
+	o.ProcessMessage(ctx, "Flights from Madrid to Paris under 500, mail ana@example.com", Options{SessionID: "s1"}, events)
+
+	entry := queryLogOf(t, o, "req-1")
+	flights := ofType(drain(events), sse.TypeFlightResults)
+	if entry.Intent != "flight" || entry.Origin != "Madrid" || entry.Destination != "Paris" || entry.MaxPrice != 500 ||
+		entry.SessionID != "s1" || entry.DetectedLanguage != "English" || len(flights) != 1 {
+		t.Errorf("record = %+v", entry)
+	}
+	if n := len(flights[0].Payload.([]db.Flight)); entry.ResultCount != n {
+		t.Errorf("result count = %d, want the %d flights found", entry.ResultCount, n)
+	}
+	if entry.Message != "Flights from Madrid to Paris under 500, mail [email]" {
+		t.Errorf("message logged as %q, want it redacted", entry.Message)
+	}
+
+	stats, err := o.db.GetQueryStats(context.Background(), time.Now().Add(-time.Minute))
+	if err != nil || stats.Total != 1 || len(stats.TopRoutes) != 1 || stats.TopRoutes[0] != (db.RouteCount{Origin: "Madrid", Destination: "Paris", Count: 1}) {
+		t.Errorf("stats = %+v, %v", stats, err)
+	}
+}
+
+func TestQueryLogOffByDefault(t *testing.T) {
+	o := newTestOrchestrator(t, "Paris.", "The capital is Paris.", "Paris.")
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	events := make(chan sse.Event, 1024)
+	o.ProcessMessage(ctx, "What is the capital of France?", Options{}, events)
+	time.Sleep(20 * time.Millisecond) // Long enough for a write in the background
+	if stats, _ := o.db.GetQueryStats(context.Background(), time.Time{}); stats.Total != 0 {
+		t.Errorf("%d requests logged with the query log off", stats.Total)
+	}
+}
+
+func TestQueryLogStoresPrompts(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "FL101 leaves at 08:00.", "FL101 takes 2h.", "FL101 is the one, ana@example.com.")
+		o.SetModels("model-1", "model-2", "model-3")
+		o.EnableQueryLog(func(message string) string {
+			return strings.ReplaceAll(message, "ana@example.com", "[email]")
+		})
+		o.StorePrompts()
+		requestID := fmt.Sprintf("req-prompts-%v", stream)
+		ctx := logging.WithRequestID(context.Background(), requestID)
+		events := make(chan sse.Event, 1024)
+		if stream {
+			o.ProcessMessageStream(ctx, "Flights from Madrid to Paris, mail ana@example.com", Options{}, events)
+		} else {
+			o.ProcessMessage(ctx, "Flights from Madrid to Paris, mail ana@example.com", Options{}, events)
+		}
+		drain(events)
+
+		// Each call is recorded as the LLM got it and answered, redacted like the message.
+		entry := queryLogOf(t, o, requestID)
+		prompts := map[string]string{stageLLM1: o.llm1.Prompts()[0], stageLLM2: o.llm2.Prompts()[0], stageAggregation: o.llm3.Prompts()[0]}
+		responses := map[string]string{stageLLM1: "FL101 leaves at 08:00.", stageLLM2: "FL101 takes 2h.", stageAggregation: "FL101 is the one, [email]."}
+		models := map[string]string{stageLLM1: "model-1", stageLLM2: "model-2", stageAggregation: "model-3"}
+		if len(entry.Calls) != 3 || entry.Calls[2].Stage != stageAggregation {
+			t.Fatalf("stream %v: calls %+v", stream, entry.Calls)
+		}
+		for _, call := range entry.Calls {
+			want := strings.ReplaceAll(prompts[call.Stage], "ana@example.com", "[email]")
+			if call.Prompt != want || call.Response != responses[call.Stage] || call.Model != models[call.Stage] || call.Error != "" {
+				t.Errorf("stream %v: %s call %+v", stream, call.Stage, call)
+			}
+		}
+		if entry.Answer != "FL101 is the one, [email]." || entry.RequestID != requestID || entry.StagesMs == nil {
+			t.Errorf("stream %v: answer %q, request %q, stages %v", stream, entry.Answer, entry.RequestID, entry.StagesMs)
+		}
+	}
+
+	// Without StorePrompts only the summary is logged.
+	o := newTestOrchestrator(t, "Paris.", "The capital is Paris.", "Paris.")
+	o.EnableQueryLog(nil)
+	ctx := logging.WithRequestID(context.Background(), "req-no-prompts")
+	events := make(chan sse.Event, 1024)
+	o.ProcessMessage(ctx, "What is the capital of France?", Options{}, events)
+	if entry := queryLogOf(t, o, "req-no-prompts"); entry.Calls != nil || entry.Answer != "" || entry.Intent != "general" {
+		t.Errorf("record without prompt storage %+v", entry)
+	}
+}
+
+// drain returns the events sent on events so far.
+func drain(events chan sse.Event) []sse.Event {
+	var out []sse.Event
+	for {
+		select {
+		case ev := <-events:
+			out = append(out, ev)
+		default:
+			return out
+		}
+	}
+}
+
+// failingSearchStore is the seeded memory backend whose flight queries fail with err.
+type failingSearchStore struct {
+	db.Client
+	err error
+}
+
+func (s failingSearchStore) QueryFlights(ctx context.Context, q db.FlightQuery) ([]db.Flight, error) {
+	return nil, s.err
+}
+
+func TestSearchFailureDegrades(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		err      error
+		wantCode string // Of the Error event; empty for the no flights answer
+	}{
+		{"unavailable", &db.OpError{Op: "search flights", Kind: db.ErrUnavailable, Err: context.DeadlineExceeded}, "search_unavailable"},
+		{"other failure", &db.OpError{Op: "search flights", Err: errors.New("bad query")}, ""},
+	} {
+		for _, stream := range []bool{false, true} {
+			base := newTestOrchestrator(t, "FL101.", "2h.", "FL101, 2h.")
+			o := NewOrchestrator(base.llm1, base.llm2, base.llm3, failingSearchStore{base.db, tt.err})
+			if err := o.cities.Refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			events := process(t, o, "Show me flights from Madrid to Paris", Options{}, stream)
+
+			errs := ofType(events, sse.TypeError)
+			switch {
+			case tt.wantCode != "" && (len(errs) != 1 || errs[0].Payload.(sse.ErrorPayload).Code != tt.wantCode):
+				t.Errorf("%s (stream %v): Error events %v, want one %s", tt.name, stream, errs, tt.wantCode)
+			case tt.wantCode == "" && answerOf(events) != i18n.T("en", "message.no_flights"):
+				t.Errorf("%s (stream %v): answer %q, want the no flights message", tt.name, stream, answerOf(events))
+			}
+			if len(base.llm3.Prompts()) != 0 {
+				t.Errorf("%s (stream %v): LLM3 was asked without flight data", tt.name, stream)
+			}
+			if done := events[len(events)-1].Payload.(sse.DonePayload); done.Outcome != sse.OutcomeError {
+				t.Errorf("%s (stream %v): outcome %q, want error", tt.name, stream, done.Outcome)
+			}
+		}
+	}
+}
+
+// panickingClient is an LLM whose every call panics.
+type panickingClient struct{}
+
+func (panickingClient) ChatCompletion(context.Context, string) (string, error) {
+	panic("mock LLM bug")
+}
+
+func (panickingClient) StreamChatCompletion(context.Context, string) (<-chan string, error) {
+	panic("mock LLM bug")
+}
+
+func TestDoneAlwaysLast(t *testing.T) {
+	const flightQuestion, generalQuestion = "Show me flights from Madrid to Paris", "What is the capital of France?"
+	searchFails := func(o *testOrchestrator) *Orchestrator {
+		failing := NewOrchestrator(o.llm1, o.llm2, o.llm3, failingSearchStore{o.db, errors.New("bad query")})
+		if err := failing.cities.Refresh(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return failing
+	}
+	for _, tt := range []struct {
+		name    string
+		setup   func(o *testOrchestrator) *Orchestrator
+		message string
+		outcome string
+	}{
+		{"flight answer", nil, flightQuestion, sse.OutcomeOK},
+		{"general answer", nil, generalQuestion, sse.OutcomeOK},
+		{"aggregation fails, answered from the workers", func(o *testOrchestrator) *Orchestrator {
+			o.llm3.next = failingClient{}
+			return o.Orchestrator
+		}, generalQuestion, sse.OutcomeOK},
+		{"search fails", searchFails, flightQuestion, sse.OutcomeError},
+		{"aggregation panics", func(o *testOrchestrator) *Orchestrator {
+			o.llm3.next = panickingClient{}
+			return o.Orchestrator
+		}, generalQuestion, sse.OutcomeError},
+	} {
+		for _, stream := range []bool{false, true} {
+			base := newTestOrchestrator(t, "FL101.", "2h.", "FL101, 2h.")
+			o := base.Orchestrator
+			if tt.setup != nil {
+				o = tt.setup(base)
+			}
+			events := process(t, o, tt.message, Options{}, stream)
+
+			if len(ofType(events, sse.TypeDone)) != 1 || events[len(events)-1].Type != sse.TypeDone {
+				t.Errorf("%s (stream %v): events %v, want exactly one Done, last", tt.name, stream, events)
+				continue
+			}
+			done := events[len(events)-1].Payload.(sse.DonePayload)
+			if done.Outcome != tt.outcome || (tt.outcome == sse.OutcomeError) != (done.Error != "") {
+				t.Errorf("%s (stream %v): Done = %+v, want outcome %s", tt.name, stream, done, tt.outcome)
+			}
+			if _, ok := done.Telemetry.(Telemetry); !ok {
+				t.Errorf("%s (stream %v): Done telemetry is %T", tt.name, stream, done.Telemetry)
+			}
+		}
+	}
+}
+
+func TestDoneOnExpiredContext(t *testing.T) {
+	o := newTestOrchestrator(t, "FL101.", "2h.", "FL101, 2h.")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	events := make(chan sse.Event, 1024)
+	o.ProcessMessage(ctx, "What is the capital of France?", Options{}, events)
+	got := drain(events)
+	if len(got) == 0 || got[len(got)-1].Type != sse.TypeDone || got[len(got)-1].Payload.(sse.DonePayload).Outcome != sse.OutcomeError {
+		t.Errorf("events %v, want a Done with outcome error last", got)
+	}
+}
+
+func TestSpanTree(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	// The pipeline as the server wires it: traced clients, and the request's events streamed
+	// by the SSE handler inside the HTTP server span.
+	store := db.NewMemoryClient()
+	if err := store.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	llm := func(slot string) llmclient.LLMClient {
+		return tracing.TraceLLM(&llmclient.MockClient{Response: "answer"}, slot, "test-model")
+	}
+	o := NewOrchestrator(llm("llm1"), llm("llm2"), llm("llm3"), tracing.TraceDB(store))
+	if err := o.cities.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	streams := sse.NewRegistry(time.Minute)
+	h := sse.NewHandler()
+	srv := httptest.NewServer(tracing.Middleware("/api", func(w http.ResponseWriter, r *http.Request) {
+		stream := streams.Create()
+		events := make(chan sse.Event)
+		go stream.Pipe(events)
+		go func() {
+			defer close(events)
+			o.ProcessMessage(r.Context(), "Flights from Madrid to Paris", Options{}, events)
+		}()
+		h.ServeStream(w, r, stream, 0)
+	}))
+	defer srv.Close()
+
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// The server span ends just after the response does.
+	var root sdktrace.ReadOnlySpan
+	var children []string
+	for deadline := time.Now().Add(5 * time.Second); root == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, span := range recorder.Ended() {
+			if span.SpanContext().TraceID().String() != traceID {
+				continue // The cities' refresh, before the request
+			}
+			if span.Name() == "POST /api" {
+				root = span
+			}
+		}
+	}
+	if root == nil {
+		t.Fatal("no server span in the caller's trace")
+	}
+	if root.Parent().SpanID().String() != parentID {
+		t.Errorf("server span's parent %s, want the caller's %s", root.Parent().SpanID(), parentID)
+	}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != traceID || span == root {
+			continue
+		}
+		if span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("span %s isn't a child of the server span", span.Name())
+		}
+		children = append(children, span.Name())
+	}
+	slices.Sort(children)
+	want := []string{"db.query_flights", "llm.chat", "llm.chat", "llm.chat", "orchestrator.detect_intent", "sse.write_loop"}
+	if !slices.Equal(children, want) {
+		t.Errorf("spans under the server span %v, want %v", children, want)
+	}
+}
@@ -0,0 +1,195 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// OutputLimit caps the length of an answer, so a model that goes on and on can't fill the
+// stream, and the memory that keeps it for replay, with an endless reply. See SetOutputLimit.
+type OutputLimit struct {
+	MaxChars  int // Characters per answer; 0 means unlimited
+	MaxTokens int // Tokens per answer, counted as llmclient.EstimateTokens does; 0 means unlimited
+}
+
+// SetOutputLimit caps every answer at limit. A streamed answer that reaches it is cut there:
+// the rest of the stream isn't read, the LLM call is cancelled, and a notice that the answer
+// was cut short ends it, before the request finishes as usual. A buffered answer is cut the
+// same way. It must be called before the orchestrator serves requests.
+func (o *Orchestrator) SetOutputLimit(limit OutputLimit) {
+	o.outputLimit = limit
+}
+
+// SetChunkCoalescing merges the chunks of a streamed answer into fewer Message events: chunks
+// are held for up to window, or until maxBytes have accumulated, and sent as one event. A
+// model streams a token or so per chunk, and each event is kept for replay until the stream
+// expires. A zero window sends every chunk as it comes. It must be called before the
+// orchestrator serves requests.
+func (o *Orchestrator) SetChunkCoalescing(window time.Duration, maxBytes int) {
+	o.coalesceWindow, o.coalesceBytes = window, maxBytes
+}
+
+// answerCap measures an answer against an OutputLimit as it is written.
+type answerCap struct {
+	limit        OutputLimit
+	chars, bytes int // Taken so far
+}
+
+// take returns the part of text that still fits, and whether text went over the limit.
+func (c *answerCap) take(text string) (string, bool) {
+	// Tokens are estimated at four bytes each, so the token limit is a byte limit.
+	maxBytes := c.limit.MaxTokens * 4
+	if (c.limit.MaxChars <= 0 || c.chars+len(text) <= c.limit.MaxChars) && (maxBytes <= 0 || c.bytes+len(text) <= maxBytes) {
+		c.chars += utf8.RuneCountInString(text) // Fits whole: there are no more characters than bytes
+		c.bytes += len(text)
+		return text, false
+	}
+	for i, r := range text {
+		size := utf8.RuneLen(r)
+		if c.limit.MaxChars > 0 && c.chars+1 > c.limit.MaxChars || maxBytes > 0 && c.bytes+size > maxBytes {
+			return text[:i], true
+		}
+		c.chars++
+		c.bytes += size
+	}
+	return text, false
+}
+
+// truncated records that the answer in entry was cut short and returns the notice that ends it.
+func truncated(ctx context.Context, entry *db.QueryLog, lang string, c *answerCap) string {
+	entry.Truncated = true
+	slog.WarnContext(ctx, "Answer reached the output limit; cut short",
+		"chars", c.chars, "max_chars", c.limit.MaxChars, "max_tokens", c.limit.MaxTokens)
+	return "\n\n" + i18n.T(lang, "message.truncated")
+}
+
+// sendAnswer sends a complete answer as one final Message event, cut to the output limit.
+func (o *Orchestrator) sendAnswer(ctx context.Context, entry *db.QueryLog, lang, answer string, eventChan chan<- sse.Event) {
+	c := answerCap{limit: o.outputLimit}
+	if fits, over := c.take(answer); over {
+		answer = fits + truncated(ctx, entry, lang, &c)
+	}
+	eventChan <- sse.MessageChunk(answer, true)
+}
+
+// streamAnswer is sendAnswer for a streamed request, for answers written whole rather than
+// streamed, such as the combined worker answers when LLM 3 fails: the answer, cut to the
+// output limit, is sent as Message events of up to the coalescing size (see splitAnswer), the
+// last of them final, so the client gets it as it would a streamed one.
+func (o *Orchestrator) streamAnswer(ctx context.Context, entry *db.QueryLog, lang, answer string, eventChan chan<- sse.Event) {
+	c := answerCap{limit: o.outputLimit}
+	if fits, over := c.take(answer); over {
+		answer = fits + truncated(ctx, entry, lang, &c)
+	}
+	size := o.coalesceBytes
+	if size <= 0 {
+		size = sse.DefaultCoalesceBytes
+	}
+	chunks := splitAnswer(answer, size)
+	for i, chunk := range chunks {
+		eventChan <- sse.MessageChunk(chunk, i == len(chunks)-1)
+	}
+}
+
+// splitAnswer splits text, in order, into chunks of at most size bytes, never inside a
+// character. A chunk ends after the last sentence, or else the last word, that ends in its
+// second half, and is cut where it fills up only when there is neither. It returns one chunk,
+// possibly empty, for a text that fits.
+func splitAnswer(text string, size int) []string {
+	var chunks []string
+	for len(text) > size {
+		cut := size
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if cut == 0 { // A character longer than size
+			_, cut = utf8.DecodeRuneInString(text)
+		}
+		if at := answerBreak(text[:cut]); at > cut/2 {
+			cut = at
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	if text == "" && len(chunks) > 0 { // The last cut took a character longer than size
+		return chunks
+	}
+	return append(chunks, text)
+}
+
+// answerBreak returns where text is best split: after its last sentence end, or else after its
+// last space; 0 if it has neither.
+func answerBreak(text string) int {
+	at := 0
+	for _, end := range []string{". ", "! ", "? ", "\n"} {
+		if i := strings.LastIndex(text, end); i >= 0 {
+			at = max(at, i+len(end))
+		}
+	}
+	if at > len(text)/2 {
+		return at
+	}
+	return strings.LastIndexByte(text, ' ') + 1
+}
+
+// forwardChunks sends a streamed answer as Message events, merging chunks as set with
+// SetChunkCoalescing. It holds the last batch back so it can be marked final without sending
+// an extra empty event. If the answer reaches the output limit, it stops reading streamChan,
+// calls stop to cancel the LLM call and ends the answer with the truncation notice.
+func (o *Orchestrator) forwardChunks(ctx context.Context, entry *db.QueryLog, lang string, streamChan <-chan string, stop context.CancelFunc, eventChan chan<- sse.Event) {
+	c := answerCap{limit: o.outputLimit}
+	var held, pending strings.Builder // The batch held back, and the one being merged
+	started := false
+	var flushAt <-chan time.Time // Fires when the oldest pending chunk has waited the window
+	next := func() {
+		if held.Len() > 0 {
+			eventChan <- sse.MessageChunk(held.String(), false)
+		}
+		held.Reset()
+		held.WriteString(pending.String())
+		pending.Reset()
+		flushAt = nil
+	}
+	for {
+		select {
+		case chunk, ok := <-streamChan:
+			if !ok {
+				if started {
+					eventChan <- sse.MessageChunk(held.String()+pending.String(), true)
+				}
+				return
+			}
+			started = true
+			fits, over := c.take(chunk)
+			pending.WriteString(fits)
+			if over {
+				stop()
+				go func() {
+					for range streamChan { // Let a producer that doesn't watch its context finish
+					}
+				}()
+				notice := truncated(ctx, entry, lang, &c)
+				if text := held.String() + pending.String(); text != "" {
+					eventChan <- sse.MessageChunk(text, false)
+				}
+				eventChan <- sse.MessageChunk(notice, true)
+				return
+			}
+			switch {
+			case o.coalesceWindow <= 0 || pending.Len() >= o.coalesceBytes:
+				next()
+			case flushAt == nil:
+				flushAt = time.After(o.coalesceWindow)
+			}
+		case <-flushAt:
+			next()
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// endlessClient streams chunk over and over, up to total characters, until its context ends.
+type endlessClient struct {
+	chunk string
+	total int
+
+	sent      atomic.Int64  // Characters sent so far
+	cancelled chan struct{} // Closed once the stream saw its context end
+}
+
+func newEndlessClient(chunk string, total int) *endlessClient {
+	return &endlessClient{chunk: chunk, total: total, cancelled: make(chan struct{})}
+}
+
+func (c *endlessClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	return "", errors.New("endlessClient only streams")
+}
+
+func (c *endlessClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for int(c.sent.Load()) < c.total {
+			select {
+			case out <- c.chunk:
+				c.sent.Add(int64(len(c.chunk)))
+			case <-ctx.Done():
+				close(c.cancelled)
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// checkCut checks that the request's answer was cut at maxChars and that client was
+// cancelled long before it wrote all it had.
+func checkCut(t *testing.T, events []sse.Event, client *endlessClient, maxChars int) {
+	t.Helper()
+	notice := "\n\n" + i18n.T("en", "message.truncated")
+	answer := answerOf(events)
+	if !strings.HasSuffix(answer, notice) {
+		t.Fatalf("answer ends %q, want the truncation notice", answer[max(0, len(answer)-80):])
+	}
+	if n := utf8.RuneCountInString(strings.TrimSuffix(answer, notice)); n != maxChars {
+		t.Errorf("answer has %d characters before the notice, want %d", n, maxChars)
+	}
+	if !telemetryOf(t, events).Truncated {
+		t.Error("telemetry doesn't say the answer was truncated")
+	}
+	select {
+	case <-client.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream's producer wasn't cancelled")
+	}
+	// The stream is read only a chunk or two past the limit.
+	if sent := int(client.sent.Load()); sent > 4*maxChars+8<<10 {
+		t.Errorf("the producer wrote %d of %d characters, want it stopped near %d", sent, client.total, maxChars)
+	}
+	messages := ofType(events, sse.TypeMessage)
+	if len(messages) > 20 {
+		t.Errorf("%d Message events, want the chunks coalesced", len(messages))
+	}
+	if last, _ := messages[len(messages)-1].Payload.(sse.MessagePayload); !last.Final {
+		t.Error("the last Message event isn't final")
+	}
+	if events[len(events)-1].Type != sse.TypeDone {
+		t.Errorf("last event is %s, want Done", events[len(events)-1].Type)
+	}
+}
+
+func TestStreamedAnswerCutAtOutputLimit(t *testing.T) {
+	o := newTestOrchestrator(t, "Paris.", "The capital is Paris.", "")
+	client := newEndlessClient(strings.Repeat("word ", 20), 1_000_000)
+	o.llm3.next = client
+	o.SetOutputLimit(OutputLimit{MaxChars: 2000})
+	o.SetChunkCoalescing(5*time.Millisecond, 1024)
+
+	events := process(t, o.Orchestrator, "What is the capital of France?", Options{}, true)
+	checkCut(t, events, client, 2000)
+}
+
+func TestStreamedFlightAnswerCutAtOutputLimit(t *testing.T) {
+	// One line that never ends, which the flight line rewriting mustn't hold back whole.
+	o := newTestOrchestrator(t, "FL101 leaves at 08:00.", "FL101 takes 2h.", "")
+	client := newEndlessClient(strings.Repeat("x", 100), 1_000_000)
+	o.llm3.next = client
+	if err := o.SetFlightFormat(FlightFormat{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	o.SetOutputLimit(OutputLimit{MaxChars: 2000})
+	o.SetChunkCoalescing(5*time.Millisecond, 1024)
+
+	events := process(t, o.Orchestrator, "Show me flights from Madrid to Paris", Options{}, true)
+	if len(ofType(events, sse.TypeFlightResults)) != 1 {
+		t.Fatal("no FlightResults event: the flight path wasn't taken")
+	}
+	checkCut(t, events, client, 2000)
+}
+
+func TestStreamedAnswerUnderOutputLimit(t *testing.T) {
+	o := newTestOrchestrator(t, "Paris.", "The capital is Paris.", "")
+	client := newEndlessClient("word ", 500)
+	o.llm3.next = client
+	o.SetOutputLimit(OutputLimit{MaxChars: 2000})
+
+	events := process(t, o.Orchestrator, "What is the capital of France?", Options{}, true)
+	if answer := answerOf(events); answer != strings.Repeat("word ", 100) {
+		t.Errorf("answer = %q, want the whole stream", answer)
+	}
+	if telemetryOf(t, events).Truncated {
+		t.Error("an answer under the limit was truncated")
+	}
+}
+
+func TestAnswerCapTake(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit OutputLimit
+		texts []string
+		fits  string
+		over  bool
+	}{
+		{"unlimited", OutputLimit{}, []string{"abc", "def"}, "abcdef", false},
+		{"exactly at the limit", OutputLimit{MaxChars: 6}, []string{"abc", "def"}, "abcdef", false},
+		{"characters", OutputLimit{MaxChars: 4}, []string{"abc", "def"}, "abcd", true},
+		{"multibyte characters", OutputLimit{MaxChars: 3}, []string{"ñañ", "añ"}, "ñañ", true},
+		{"tokens as four bytes", OutputLimit{MaxTokens: 1}, []string{"ab", "cdef"}, "abcd", true},
+		{"never inside a character", OutputLimit{MaxTokens: 1}, []string{"abc", "ñ"}, "abc", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := answerCap{limit: tt.limit}
+			var got strings.Builder
+			over := false
+			for _, text := range tt.texts {
+				fits, o := c.take(text)
+				got.WriteString(fits)
+				if over = o; over {
+					break
+				}
+			}
+			if got.String() != tt.fits || over != tt.over {
+				t.Errorf("took %q, over %v; want %q, %v", got.String(), over, tt.fits, tt.over)
+			}
+		})
+	}
+}
+
+func TestSplitAnswer(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		size int
+		want []string
+	}{
+		{"Hello world.", 100, []string{"Hello world."}},
+		{"", 10, []string{""}},
+		// After a sentence, or else a word, that ends in the second half of the chunk.
+		{"One two. Three four five.", 12, []string{"One two. ", "Three four ", "five."}},
+		{"A verylongword here", 12, []string{"A verylongwo", "rd here"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		// Never inside a character, even one longer than the size.
+		{"ñññññ", 3, []string{"ñ", "ñ", "ñ", "ñ", "ñ"}},
+		{"日本", 2, []string{"日", "本"}},
+	} {
+		if got := splitAnswer(tt.text, tt.size); !slices.Equal(got, tt.want) {
+			t.Errorf("splitAnswer(%q, %d) = %q, want %q", tt.text, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestSplitAnswerReassembles(t *testing.T) {
+	pieces := []string{"a", "ñ", "日", "🛫", " ", ". ", "\n", "Múnich", "vuelo"}
+	var b strings.Builder
+	for i := range 500 {
+		b.WriteString(pieces[(i*7+i/3)%len(pieces)])
+	}
+	text := b.String()
+	for size := 1; size <= 40; size++ {
+		chunks := splitAnswer(text, size)
+		for _, chunk := range chunks {
+			if !utf8.ValidString(chunk) || chunk == "" || len(chunk) > max(size, utf8.UTFMax) {
+				t.Fatalf("size %d: chunk %q", size, chunk)
+			}
+		}
+		if got := strings.Join(chunks, ""); got != text {
+			t.Fatalf("size %d: reassembled %q, want %q", size, got, text)
+		}
+	}
+}
+
+func TestAggregationFallbackStreamed(t *testing.T) {
+	answer1, answer2 := strings.Repeat("Vuelo a Múnich, 日本 y más. ", 20), strings.Repeat("Über 🛫 sí. ", 30)
+	o := newTestOrchestrator(t, answer1, answer2, "")
+	o.llm3.next = failingClient{}
+	o.SetChunkCoalescing(0, 64)
+	events := process(t, o.Orchestrator, "What is the capital of France?", Options{}, true)
+
+	// The combined answer comes in order, as Message events of up to the coalescing size, the
+	// last of them final, and then Done.
+	messages := ofType(events, sse.TypeMessage)
+	if len(messages) < 10 {
+		t.Fatalf("%d Message events, want the answer in chunks", len(messages))
+	}
+	for i, ev := range messages {
+		if !utf8.ValidString(ev.Data) || len(ev.Data) > 64 || ev.Payload.(sse.MessagePayload).Final != (i == len(messages)-1) {
+			t.Errorf("chunk %d: %q, %+v", i, ev.Data, ev.Payload)
+		}
+	}
+	if got, want := answerOf(events), combineAnswers("en", "general", answer1, answer2); got != want {
+		t.Errorf("answer %q, want %q", got, want)
+	}
+	if events[len(events)-1].Type != sse.TypeDone {
+		t.Errorf("last event %s, want Done", events[len(events)-1].Type)
+	}
+
+	// Without streaming, it is one event.
+	events = process(t, o.Orchestrator, "What is the capital of France?", Options{}, false)
+	if messages := ofType(events, sse.TypeMessage); len(messages) != 1 {
+		t.Errorf("%d Message events without streaming, want 1", len(messages))
+	}
+}
+
+func TestStreamAnswerCutAtOutputLimit(t *testing.T) {
+	o := newTestOrchestrator(t, strings.Repeat("ñ", 300), "b", "")
+	o.llm3.next = failingClient{}
+	o.SetChunkCoalescing(0, 32)
+	o.SetOutputLimit(OutputLimit{MaxChars: 100})
+	events := process(t, o.Orchestrator, "What is the capital of France?", Options{}, true)
+	answer := answerOf(events)
+	if !strings.HasSuffix(answer, i18n.T("en", "message.truncated")) || !telemetryOf(t, events).Truncated {
+		t.Errorf("answer %q not cut", answer)
+	}
+	for _, ev := range ofType(events, sse.TypeMessage) {
+		if !utf8.ValidString(ev.Data) || len(ev.Data) > 32 {
+			t.Errorf("chunk %q", ev.Data)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+)
+
+// maxPassengers is the largest party size read from a question; larger numbers before
+// "seats" or "people" are more likely something else.
+const maxPassengers = 50
+
+// preferencePassengers names the session's party size in QueryLog.Preferences when a question
+// that gave none used it.
+const preferencePassengers = "passengers"
+
+var (
+	// partyNoun follows the size of a party: "3 people", "dos pasajeros", "four of us".
+	partyNoun = regexp.MustCompile(`\b(?:people|persons?|passengers?|adults?|travell?ers?|tickets?|seats?|of us|personas?|pasajeros?|adultos?|viajeros?|billetes?|plazas?|asientos?)\b`)
+	// partyPhrase precedes the size of a party: "party of 4", "somos tres".
+	partyPhrase = regexp.MustCompile(`\b(?:party of|group of|we are|we're|grupo de|somos)\s+`)
+	// partyNumber is the number before a partyNoun: digits, or number words ("twenty-two",
+	// "treinta y dos").
+	partyNumber = regexp.MustCompile(`\b(?:\d+|\p{L}+(?:(?:\s+y\s+|-)\p{L}+)?)\s*$`)
+)
+
+// passengersIn returns the party size the lowercased question gives ("for 3 people", "para
+// dos personas", "we are four"), or 0 if it gives none.
+func passengersIn(lower string) int {
+	for _, m := range partyNoun.FindAllStringIndex(lower, -1) {
+		if n := partySize(partyNumber.FindString(lower[:m[0]])); n > 0 {
+			return n
+		}
+	}
+	for _, m := range partyPhrase.FindAllStringIndex(lower, -1) {
+		if n := partySize(strings.Fields(lower[m[1]:] + " ")[0]); n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// partySize reads a party size written with digits or number words, or returns 0 if text is
+// neither or the size is out of range.
+func partySize(text string) int {
+	text = strings.TrimSpace(text)
+	n, err := strconv.Atoi(text)
+	if err != nil {
+		n = int(wordsNumber(text))
+	}
+	if n < 1 || n > maxPassengers {
+		return 0
+	}
+	return n
+}
+
+// applyPassengers sets the party size of the flight question in entry: the one userMessage
+// gives, or else the one the session's questions last gave. A party size given is saved as
+// the session's; it returns the preferences the rest of the request uses, updated if so. A
+// failure to save is logged and otherwise ignored.
+func (o *Orchestrator) applyPassengers(ctx context.Context, entry *db.QueryLog, userMessage string, prefs *db.Preferences) *db.Preferences {
+	entry.Passengers = passengersIn(strings.ToLower(userMessage))
+	if prefs == nil {
+		return prefs
+	}
+	if entry.Passengers == 0 {
+		if prefs.Passengers > 1 {
+			entry.Passengers = prefs.Passengers
+			entry.Preferences = append(entry.Preferences, preferencePassengers)
+		}
+		return prefs
+	}
+	if entry.Passengers == prefs.Passengers {
+		return prefs
+	}
+	updated := *prefs
+	updated.Passengers = entry.Passengers
+	updated.UpdatedAt = time.Now().UTC()
+	if err := o.dbClient.SavePreferences(ctx, updated); err != nil {
+		slog.WarnContext(ctx, "Failed to save the party size", "session_id", prefs.SessionID, "error", err)
+	}
+	return &updated
+}
+
+// withParty returns a copy of flights with the party size and its total price filled in, for
+// a party of more than one.
+func withParty(flights []db.Flight, passengers int) []db.Flight {
+	if passengers <= 1 {
+		return flights
+	}
+	party := make([]db.Flight, len(flights))
+	for i, f := range flights {
+		f.Passengers, f.TotalPrice = passengers, partyPrice(f.Price, passengers)
+		party[i] = f
+	}
+	return party
+}
+
+// partyPrice is what passengers pay together at price each, to the cent.
+func partyPrice(price float64, passengers int) float64 {
+	return float64(int64(price*100+0.5)*int64(passengers)) / 100
+}
+
+// priceText writes price, per person, for the answer to the question in entry; for a party of
+// more than one it adds the party's total: "$120.00 per person, $360.00 for 3 passengers".
+func (o *Orchestrator) priceText(ctx context.Context, entry *db.QueryLog, lang string, price float64) string {
+	each := o.displayPrice(ctx, price, entry.Currency, lang)
+	if entry.Passengers <= 1 {
+		return each
+	}
+	total := o.displayPrice(ctx, partyPrice(price, entry.Passengers), entry.Currency, lang)
+	return i18n.T(lang, "price.party", each, total, entry.Passengers)
+}
+
+// partyNote is the paragraph added to the flight prompts in language for a party of more than
+// one, or "" otherwise.
+func partyNote(language string, passengers int) string {
+	if passengers <= 1 {
+		return ""
+	}
+	if language == LanguageSpanish {
+		return fmt.Sprintf("\n\nLa búsqueda es para %d pasajeros: da el precio por persona y el total para el grupo, tal como aparecen en los datos.", passengers)
+	}
+	return fmt.Sprintf("\n\nThe search is for %d passengers: give both the price per person and the total for the party, as the data states them.", passengers)
+}
@@ -0,0 +1,190 @@
+package orchestrator
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestPassengersIn(t *testing.T) {
+	for message, want := range map[string]int{
+		// Digits and number words, before what the party is.
+		"Flights to Paris for 3 people":    3,
+		"flights for two passengers":       2,
+		"3 tickets to Paris":               3,
+		"one traveler to Rome":             1,
+		"four of us flying to Paris":       4,
+		"twenty-two travellers":            22,
+		"We're 2 adults":                   2,
+		"Flights at 9 for 2 people":        2,
+		"vuelos a París para dos personas": 2,
+		"vuelos para veintidós pasajeros":  22,
+		"treinta y dos personas":           32,
+		"grupo de 8 viajeros":              8,
+		"2 asientos a Roma":                2,
+		// Or after a phrase that introduces it.
+		"party of 4 to Rome": 4,
+		"group of 6":         6,
+		"we are five":        5,
+		"somos tres":         3,
+		// Numbers that aren't a party's, and sizes out of range, aren't read.
+		"Flights under 300 euros":        0,
+		"Flights to Paris for 60 people": 0,
+		"for zero people":                0,
+		"Are there seats on FL101?":      0,
+		"We are going to Paris":          0,
+		"Flights from Madrid to Paris":   0,
+	} {
+		if got := passengersIn(strings.ToLower(message)); got != want {
+			t.Errorf("passengersIn(%q) = %d, want %d", message, got, want)
+		}
+	}
+}
+
+func TestPartyPrice(t *testing.T) {
+	for _, tt := range []struct {
+		price      float64
+		passengers int
+		want       float64
+	}{
+		{120, 3, 360},
+		{19.99, 3, 59.97},
+		{0.1, 3, 0.3},
+		{120.1, 3, 360.3},
+		{33.33, 7, 233.31},
+		{550, 1, 550},
+	} {
+		if got := partyPrice(tt.price, tt.passengers); got != tt.want {
+			t.Errorf("partyPrice(%g, %d) = %g, want %g", tt.price, tt.passengers, got, tt.want)
+		}
+	}
+}
+
+func TestWithParty(t *testing.T) {
+	flights := []db.Flight{{FlightNumber: "FL101", Price: 19.99}, {FlightNumber: "FL102", Price: 150}}
+	party := withParty(flights, 3)
+	if party[0].Passengers != 3 || party[0].TotalPrice != 59.97 || party[0].Price != 19.99 || party[1].TotalPrice != 450 {
+		t.Errorf("withParty = %+v", party)
+	}
+	if flights[0].TotalPrice != 0 {
+		t.Errorf("withParty changed the flights it was given")
+	}
+	if got := withParty(flights, 1); got[0].Passengers != 0 || got[0].TotalPrice != 0 {
+		t.Errorf("withParty of one = %+v", got)
+	}
+}
+
+// partyOf returns the flight numbers, party sizes and totals of the FlightResults events.
+func partyOf(events []sse.Event) (numbers []string, passengers []int, totals []float64) {
+	for _, ev := range ofType(events, sse.TypeFlightResults) {
+		for _, f := range ev.Payload.([]db.Flight) {
+			numbers = append(numbers, f.FlightNumber)
+			passengers = append(passengers, f.Passengers)
+			totals = append(totals, f.TotalPrice)
+		}
+	}
+	return numbers, passengers, totals
+}
+
+func TestPartySearch(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		// Of the Madrid–Paris flights, FL103 has 20 seats left, too few for 25.
+		o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+		events := process(t, o.Orchestrator, "Flights from Madrid to Paris for 25 people", Options{}, stream)
+		numbers, passengers, totals := partyOf(events)
+		if !slices.Equal(numbers, []string{"FL101", "FL104", "FL102"}) && !slices.Equal(numbers, []string{"FL101", "FL102", "FL104"}) {
+			t.Errorf("stream %v: flights %v, want those with 25 seats", stream, numbers)
+		}
+		for i, number := range numbers {
+			f := seededFlight(t, o, number)
+			if passengers[i] != 25 || totals[i] != f.Price*25 {
+				t.Errorf("stream %v: %s for %d passengers totals %g, at %g each", stream, number, passengers[i], totals[i], f.Price)
+			}
+		}
+		if telemetry := telemetryOf(t, events); telemetry.Passengers != 25 || telemetry.ResultCount != 3 {
+			t.Errorf("stream %v: telemetry %+v", stream, telemetry)
+		}
+		understood := ofType(events, sse.TypeQueryUnderstanding)
+		if len(understood) != 1 || understood[0].Payload.(sse.QueryUnderstandingPayload).Passengers != 25 {
+			t.Errorf("stream %v: understanding %+v", stream, understood)
+		}
+
+		// The prompts give both prices, worked out here, and ask for both.
+		for name, prompt := range map[string]string{"LLM 1": o.llm1.Prompts()[0], "LLM 2": o.llm2.Prompts()[0]} {
+			if !strings.Contains(prompt, "price $120.00 per person, $3,000.00 for 25 passengers") {
+				t.Errorf("stream %v: %s prompt:\n%s", stream, name, prompt)
+			}
+		}
+		for name, prompt := range map[string]string{"LLM 2": o.llm2.Prompts()[0], "LLM 3": o.llm3.Prompts()[0]} {
+			if !strings.Contains(prompt, "The search is for 25 passengers") {
+				t.Errorf("stream %v: %s prompt has no party note:\n%s", stream, name, prompt)
+			}
+		}
+
+		// Without a party, nothing is filtered and no totals are given.
+		events = process(t, o.Orchestrator, "Flights from Madrid to Paris", Options{}, stream)
+		numbers, passengers, totals = partyOf(events)
+		if len(numbers) != 4 || slices.Max(passengers) != 0 || slices.Max(totals) != 0 {
+			t.Errorf("stream %v: without a party, flights %v, %v, %v", stream, numbers, passengers, totals)
+		}
+		if prompt := o.llm2.Prompts()[1]; strings.Contains(prompt, "per person") || strings.Contains(prompt, "passengers") {
+			t.Errorf("stream %v: LLM 2 prompt without a party:\n%s", stream, prompt)
+		}
+	}
+}
+
+func TestPartySearchSpanish(t *testing.T) {
+	o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+	events := process(t, o.Orchestrator, "vuelos desde Madrid a París para treinta y cinco personas", Options{}, false)
+	if numbers, _, totals := partyOf(events); !slices.Equal(numbers, []string{"FL101", "FL104"}) && !slices.Equal(numbers, []string{"FL104", "FL101"}) || !slices.Contains(totals, 4200) {
+		t.Errorf("flights %v, totals %v", numbers, totals)
+	}
+	if prompt := o.llm2.Prompts()[0]; !strings.Contains(prompt, "120,00 $ por persona, 4.200,00 $ para 35 pasajeros") || !strings.Contains(prompt, "La búsqueda es para 35 pasajeros") {
+		t.Errorf("LLM 2 prompt:\n%s", prompt)
+	}
+}
+
+func TestPartyRemembered(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+		prefs := &db.Preferences{SessionID: "session-party", Client: "key:aaaa"}
+
+		// A party size given is saved as the session's.
+		events := process(t, o.Orchestrator, "Flights from Madrid to Paris for 3 people", Options{SessionID: "session-party", Preferences: prefs}, stream)
+		saved := savedPreferences(t, o, "session-party")
+		if saved.Passengers != 3 || saved.Client != "key:aaaa" {
+			t.Fatalf("stream %v: saved %+v", stream, saved)
+		}
+		if slices.Contains(telemetryOf(t, events).Preferences, preferencePassengers) {
+			t.Errorf("stream %v: a party given counted as a saved preference", stream)
+		}
+
+		// A later question that gives none is for the same party, and says so.
+		events = process(t, o.Orchestrator, "Flights from Madrid to Paris", Options{SessionID: "session-party", Preferences: saved}, stream)
+		if _, passengers, _ := partyOf(events); len(passengers) == 0 || passengers[0] != 3 {
+			t.Errorf("stream %v: later question for %v passengers", stream, passengers)
+		}
+		telemetry := telemetryOf(t, events)
+		if telemetry.Passengers != 3 || !slices.Contains(telemetry.Preferences, preferencePassengers) {
+			t.Errorf("stream %v: telemetry %+v", stream, telemetry)
+		}
+		if answer := answerOf(events); !strings.HasPrefix(answer, "Using your saved preferences: for 3 passengers.\n\n") {
+			t.Errorf("stream %v: answer %q", stream, answer)
+		}
+
+		// A new size replaces it.
+		process(t, o.Orchestrator, "Flights from Madrid to Paris, we are five", Options{SessionID: "session-party", Preferences: saved}, stream)
+		if saved := savedPreferences(t, o, "session-party"); saved.Passengers != 5 {
+			t.Errorf("stream %v: after a new size, saved %+v", stream, saved)
+		}
+
+		// Without a session, nothing is remembered.
+		events = process(t, o.Orchestrator, "Flights from Madrid to Paris", Options{}, stream)
+		if telemetryOf(t, events).Passengers != 0 {
+			t.Errorf("stream %v: a question without a session took a party size", stream)
+		}
+	}
+}
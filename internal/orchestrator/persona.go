@@ -0,0 +1,69 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/persona"
+)
+
+// The stages a request can give its own system prompt for (Options.PersonaOverrides).
+const (
+	PersonaWorker1    = "worker1"
+	PersonaWorker2    = "worker2"
+	PersonaAggregator = "aggregator"
+)
+
+// MaxPersonaOverrideLen is the longest system prompt, in characters, a request can give a stage.
+const MaxPersonaOverrideLen = 4000
+
+// overrideStages maps the stages of Options.PersonaOverrides to the pipeline's.
+var overrideStages = map[string]string{
+	PersonaWorker1:    stageLLM1,
+	PersonaWorker2:    stageLLM2,
+	PersonaAggregator: stageAggregation,
+}
+
+// ValidatePersonaOverrides checks the system prompts a request gives its stages: every stage
+// must be one of PersonaWorker1, PersonaWorker2 and PersonaAggregator, and every prompt
+// non-empty and at most MaxPersonaOverrideLen characters.
+func ValidatePersonaOverrides(overrides map[string]string) error {
+	for _, stage := range slices.Sorted(maps.Keys(overrides)) {
+		if _, ok := overrideStages[stage]; !ok {
+			return fmt.Errorf("unknown stage %q; use %s, %s or %s", stage, PersonaWorker1, PersonaWorker2, PersonaAggregator)
+		}
+		if prompt := overrides[stage]; strings.TrimSpace(prompt) == "" {
+			return fmt.Errorf("the prompt for %s is empty", stage)
+		} else if utf8.RuneCountInString(prompt) > MaxPersonaOverrideLen {
+			return fmt.Errorf("the prompt for %s is longer than %d characters", stage, MaxPersonaOverrideLen)
+		}
+	}
+	return nil
+}
+
+// SetPersona sets the deployment's system prompt, given to every worker and aggregation call
+// in both pipelines in the request's language. The LLM clients take a single prompt, so it is
+// prepended to the task's prompt rather than sent as a system message. It must be called
+// before the orchestrator serves requests; the persona itself may be reloaded at any time.
+func (o *Orchestrator) SetPersona(p *persona.Persona) {
+	o.persona = p
+}
+
+// systemPromptClient puts a system prompt ahead of every prompt.
+type systemPromptClient struct {
+	next   llmclient.LLMClient
+	prompt string
+}
+
+func (c systemPromptClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	return c.next.ChatCompletion(ctx, c.prompt+"\n\n"+prompt)
+}
+
+func (c systemPromptClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	return c.next.StreamChatCompletion(ctx, c.prompt+"\n\n"+prompt)
+}
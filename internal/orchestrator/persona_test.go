@@ -0,0 +1,118 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/persona"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestPersonaInEveryCall(t *testing.T) {
+	p, err := persona.New(persona.Config{
+		BotName: "FlightBuddy",
+		Company: "Acme Travel",
+		Prompt:  "You are {{.BotName}} for {{.Company}}. Never discuss competitors.",
+		Prompts: map[string]string{"es": "Eres {{.BotName}} de {{.Company}}."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ message, language, want string }{
+		{"Show me flights from Madrid to Paris", "", "You are FlightBuddy for Acme Travel. Never discuss competitors.\n\n"},
+		{"What is the capital of France?", "", "You are FlightBuddy for Acme Travel. Never discuss competitors.\n\n"},
+		{"Muéstrame vuelos desde Madrid a París", "", "Eres FlightBuddy de Acme Travel.\n\n"},
+		{"¿Cuál es la capital de Francia?", LanguageSpanish, "Eres FlightBuddy de Acme Travel.\n\n"},
+	} {
+		for _, stream := range []bool{false, true} {
+			o := newTestOrchestrator(t, "FL101 and FL102.", "Two hours each.", "FL101 leaves at 09:00.")
+			o.SetPersona(p)
+			process(t, o.Orchestrator, tt.message, Options{Language: tt.language}, stream)
+
+			for i, llm := range []*recordingClient{o.llm1, o.llm2, o.llm3} {
+				prompts := llm.Prompts()
+				if len(prompts) == 0 {
+					t.Errorf("%q (stream %v): LLM %d not called", tt.message, stream, i+1)
+				}
+				for _, prompt := range prompts {
+					if !strings.HasPrefix(prompt, tt.want) || strings.Count(prompt, "FlightBuddy") != 1 {
+						t.Errorf("%q (stream %v): LLM %d prompt %q, want it to start with the persona once", tt.message, stream, i+1, prompt)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestPersonaOverrides(t *testing.T) {
+	p, err := persona.New(persona.Config{Prompt: "You are FlightBuddy."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newTestOrchestrator(t, "Paris.", "The capital is Paris.", "Paris.")
+	o.SetPersona(p)
+	process(t, o.Orchestrator, "What is the capital of France?", Options{PersonaOverrides: map[string]string{PersonaAggregator: "Answer in one word."}}, false)
+	if prompts := o.llm1.Prompts(); len(prompts) != 1 || !strings.HasPrefix(prompts[0], "You are FlightBuddy.\n\n") {
+		t.Errorf("worker prompt %q, want the persona's", prompts)
+	}
+	if prompts := o.llm3.Prompts(); len(prompts) != 1 || !strings.HasPrefix(prompts[0], "Answer in one word.\n\n") || strings.Contains(prompts[0], "FlightBuddy") {
+		t.Errorf("aggregation prompt %q, want the override instead of the persona", prompts)
+	}
+
+	if err := ValidatePersonaOverrides(map[string]string{PersonaWorker1: "Be brief."}); err != nil {
+		t.Error(err)
+	}
+	for _, overrides := range []map[string]string{
+		{"llm4": "Be brief."},
+		{PersonaWorker2: "  "},
+		{PersonaAggregator: strings.Repeat("x", MaxPersonaOverrideLen+1)},
+	} {
+		if err := ValidatePersonaOverrides(overrides); err == nil {
+			t.Errorf("overrides %v accepted", overrides)
+		}
+	}
+}
+
+func TestPersonaOverridesRecorded(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		// Without a persona, only the overridden stage gets a system prompt.
+		o := newTestOrchestrator(t, "FL101 and FL102.", "Two hours each.", "FL101 leaves at 09:00.")
+		o.EnableQueryLog(nil)
+		requestID := fmt.Sprintf("req-overrides-%v", stream)
+		ctx := logging.WithRequestID(context.Background(), requestID)
+		overrides := map[string]string{PersonaWorker1: "List flight numbers only.", PersonaAggregator: "Be terse."}
+		events := make(chan sse.Event, 1024)
+		if stream {
+			o.ProcessMessageStream(ctx, "Show me flights from Madrid to Paris", Options{PersonaOverrides: overrides}, events)
+		} else {
+			o.ProcessMessage(ctx, "Show me flights from Madrid to Paris", Options{PersonaOverrides: overrides}, events)
+		}
+		for llm, want := range map[*recordingClient]string{o.llm1: "List flight numbers only.\n\n", o.llm3: "Be terse.\n\n"} {
+			if prompts := llm.Prompts(); len(prompts) != 1 || !strings.HasPrefix(prompts[0], want) {
+				t.Errorf("stream %v: prompts %q, want them to start with %q", stream, prompts, want)
+			}
+		}
+		if prompts := o.llm2.Prompts(); len(prompts) != 1 || strings.Contains(prompts[0], "List flight numbers only.") || strings.Contains(prompts[0], "Be terse.") {
+			t.Errorf("stream %v: LLM 2 prompts %q, want no system prompt", stream, prompts)
+		}
+
+		// The telemetry names the stages; the query log keeps the prompts.
+		if got := telemetryOf(t, drain(events)).PersonaOverrides; !slices.Equal(got, []string{PersonaAggregator, PersonaWorker1}) {
+			t.Errorf("stream %v: telemetry overrides %v", stream, got)
+		}
+		if entry := queryLogOf(t, o, requestID); !maps.Equal(entry.PersonaOverrides, overrides) {
+			t.Errorf("stream %v: logged overrides %v", stream, entry.PersonaOverrides)
+		}
+	}
+
+	// Requests without overrides name none.
+	o := newTestOrchestrator(t, "Paris.", "The capital is Paris.", "Paris.")
+	if got := telemetryOf(t, process(t, o.Orchestrator, "What is the capital of France?", Options{}, false)).PersonaOverrides; got != nil {
+		t.Errorf("telemetry overrides without any %v", got)
+	}
+}
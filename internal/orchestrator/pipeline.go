@@ -0,0 +1,242 @@
+package orchestrator
+
+import (
+	"context"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// Pipeline stages, as named in Telemetry.StagesMs. The workers stage spans both worker calls,
+// which run concurrently, so it is the slower of llm1 and llm2 rather than their sum. The
+// search stage lasts until the pipeline has the results, which is the longer of the query and
+// the work done while it runs (see startSearch).
+const (
+	stageIntent      = "intent"
+	stageSearch      = "search"
+	stageLLM1        = "llm1"
+	stageLLM2        = "llm2"
+	stageWorkers     = "workers"
+	stageAggregation = "aggregation"
+)
+
+// stageTimings records how long each pipeline stage of one request took, the model of each
+// LLM stage and the LLM calls made. Worker stages are recorded from their own goroutines, so it
+// is safe for concurrent use.
+type stageTimings struct {
+	mu     sync.Mutex
+	ms     map[string]int64
+	models map[string]string
+	calls  []db.LLMCall
+}
+
+func newStageTimings() *stageTimings {
+	return &stageTimings{ms: make(map[string]int64), models: make(map[string]string)}
+}
+
+// ranOn records model as the model of stage, unless it is unknown ("").
+func (t *stageTimings) ranOn(stage, model string) {
+	if model == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.models[stage] = model
+}
+
+// modelSnapshot returns a copy of the recorded models, or nil if there are none.
+func (t *stageTimings) modelSnapshot() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.models) == 0 {
+		return nil
+	}
+	return maps.Clone(t.models)
+}
+
+// called records an LLM call of stage, started at start, with the prompt it was given and its
+// response or error.
+func (t *stageTimings) called(ctx context.Context, stage, model, prompt, response string, err error, start time.Time) {
+	call := db.LLMCall{Stage: stage, Model: model, Prompt: prompt, Response: response, DurationMs: time.Since(start).Milliseconds(),
+		ProviderRequestID: llmclient.ProviderRequestID(ctx)}
+	if err != nil {
+		call.Error = err.Error()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls = append(t.calls, call)
+}
+
+// calledStream passes on the chunks of a streamed LLM call of stage, and records the call
+// (see called) once the stream has ended, with the error it broke off with if it did (see
+// llmclient.StreamError), before the returned channel is closed. The caller must read the
+// returned channel until it is closed.
+func (t *stageTimings) calledStream(ctx context.Context, stage, model, prompt string, start time.Time, stream <-chan string) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		var response strings.Builder
+		for chunk := range stream {
+			response.WriteString(chunk)
+			out <- chunk
+		}
+		t.called(ctx, stage, model, prompt, response.String(), llmclient.StreamError(ctx), start)
+	}()
+	return out
+}
+
+// callSnapshot returns a copy of the recorded LLM calls, or nil if there are none.
+func (t *stageTimings) callSnapshot() []db.LLMCall {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.calls) == 0 {
+		return nil
+	}
+	return append([]db.LLMCall(nil), t.calls...)
+}
+
+// since records the time from start to now as stage's duration.
+func (t *stageTimings) since(stage string, start time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ms[stage] = time.Since(start).Milliseconds()
+}
+
+// snapshot returns a copy of the recorded durations, or nil if there are none.
+func (t *stageTimings) snapshot() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.ms) == 0 {
+		return nil
+	}
+	out := make(map[string]int64, len(t.ms))
+	for stage, ms := range t.ms {
+		out[stage] = ms
+	}
+	return out
+}
+
+// flightSearch is a flight query running in the background; see startSearch.
+type flightSearch struct {
+	start     time.Time
+	done      chan struct{} // Closed once flights and err are set
+	flights   []db.Flight
+	err       error
+	staleness *db.StaleReport
+}
+
+// startSearch starts the search for the flight question in entry, whose query must be
+// complete, and returns at once, so the pipeline can send the question's understanding, route
+// its models and write the worker instructions while the database answers. searchFlights
+// waits for the results.
+func (o *Orchestrator) startSearch(ctx context.Context, entry *db.QueryLog) *flightSearch {
+	search := &flightSearch{start: time.Now(), done: make(chan struct{})}
+	var searchCtx context.Context
+	searchCtx, search.staleness = db.WithStaleReport(ctx)
+	query := flightQuery(entry) // Read now: entry is written to while the query runs
+	go func() {
+		defer close(search.done)
+		search.flights, search.err = o.dbClient.QueryFlights(searchCtx, query)
+	}()
+	return search
+}
+
+// workerResult is one worker's answer, or the error that replaced it.
+type workerResult struct {
+	answer string
+	err    error
+}
+
+// text is what the result contributes to a combined answer: the answer, or the error
+// formatted for the user under the worker's label (e.g. "LLM1").
+func (r workerResult) text(lang, label string) string {
+	if r.err != nil {
+		return i18n.T(lang, "error.llm", label, r.err)
+	}
+	return r.answer
+}
+
+// runWorkers calls LLM 1 and LLM 2 concurrently with their prompts, announcing each call with a
+// Status event, and returns once both have answered or failed. invokeSuffix selects the
+// "invoke" status texts ("" or "_flights"). A worker's failure doesn't cancel the other: one
+// answer is still worth showing.
+func (o *Orchestrator) runWorkers(ctx context.Context, lang, invokeSuffix, prompt1, prompt2 string, timings *stageTimings, eventChan chan<- sse.Event) (llm1, llm2 workerResult) {
+	start := time.Now()
+	call := func(stage string, client llmclient.LLMClient, prompt string, result *workerResult) func() error {
+		return func() error {
+			eventChan <- sse.Status(i18n.T(lang, "status."+stage+".invoke"+invokeSuffix))
+			callStart := time.Now()
+			callCtx := llmclient.WithResponseInfo(ctx)
+			if o.workerProgress > 0 {
+				result.answer, result.err = o.streamWorker(callCtx, lang, stage, client, prompt, eventChan)
+			} else {
+				result.answer, result.err = client.ChatCompletion(callCtx, prompt)
+			}
+			timings.since(stage, callStart)
+			timings.ranOn(stage, o.models[stage])
+			timings.called(callCtx, stage, o.models[stage], prompt, result.answer, result.err, callStart)
+			eventChan <- sse.Status(i18n.T(lang, "status."+stage+".done"))
+			return nil // Failures are results here: the other answer is still aggregated.
+		}
+	}
+	var g errgroup.Group
+	g.Go(call(stageLLM1, o.llm1Client, prompt1, &llm1))
+	g.Go(call(stageLLM2, o.llm2Client, prompt2, &llm2))
+	_ = g.Wait()
+	timings.since(stageWorkers, start)
+	return llm1, llm2
+}
+
+// SetWorkerProgress streams the calls of LLM 1 and LLM 2 and reports how far along each one is
+// every interval, with a Status event such as "LLM 1: 240 tokens generated…", so clients can
+// show progress while the workers write. Their text isn't sent: aggregation still starts once
+// both answers are complete. Tokens are estimated from the text received (see
+// llmclient.EstimateTokens), and a worker that has written nothing new since its last report
+// isn't reported again. A zero interval calls the workers without streaming. It must be called
+// before the orchestrator serves requests.
+func (o *Orchestrator) SetWorkerProgress(interval time.Duration) {
+	o.workerProgress = interval
+}
+
+// streamWorker is a worker call made with a streamed completion: it collects the answer from
+// the chunks, sending the progress of stage ("llm1" or "llm2") every o.workerProgress. A stream
+// that breaks off (see llmclient.StreamError) fails the call as an error response would, and
+// so does the end of ctx; ctx must come from llmclient.WithResponseInfo.
+func (o *Orchestrator) streamWorker(ctx context.Context, lang, stage string, client llmclient.LLMClient, prompt string, eventChan chan<- sse.Event) (string, error) {
+	stream, err := client.StreamChatCompletion(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	ticker := time.NewTicker(o.workerProgress)
+	defer ticker.Stop()
+	var answer strings.Builder
+	reported := 0
+	for {
+		select {
+		case chunk, ok := <-stream:
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return "", err
+				}
+				if err := llmclient.StreamError(ctx); err != nil {
+					return "", err
+				}
+				return answer.String(), nil
+			}
+			answer.WriteString(chunk)
+		case <-ticker.C:
+			if tokens := llmclient.EstimateTokens(answer.String()); tokens > reported {
+				reported = tokens
+				eventChan <- sse.Status(i18n.T(lang, "status."+stage+".progress", tokens))
+			}
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/currency"
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// progressEvents returns the worker progress Status events of stage among events.
+func progressEvents(events []sse.Event, stage string) []sse.Event {
+	prefix := strings.SplitN(i18n.T("en", "status."+stage+".progress", 0), "0", 2)[0]
+	var out []sse.Event
+	for _, ev := range ofType(events, sse.TypeStatus) {
+		if strings.HasPrefix(ev.Data, prefix) {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+func TestStreamWorkerProgressCadence(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	client := &llmclient.MockClient{Response: strings.Repeat("word ", 20), ChunkDelay: 10 * time.Millisecond}
+	o := NewOrchestrator(nil, nil, nil, nil)
+	o.SetWorkerProgress(interval)
+
+	events := make(chan sse.Event, 100)
+	var at []time.Time
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range events {
+			at = append(at, time.Now())
+		}
+	}()
+	start := time.Now()
+	answer, err := o.streamWorker(llmclient.WithResponseInfo(context.Background()), "en", stageLLM1, client, "prompt", events)
+	elapsed := time.Since(start)
+	close(events)
+	<-done
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != client.Response {
+		t.Errorf("answer = %q, want the whole stream", answer)
+	}
+	// 20 chunks 10ms apart take about 200ms: a report every 50ms gives about four.
+	if want := int(elapsed / interval); len(at) < want-2 || len(at) > want {
+		t.Errorf("%d progress events in %v, want about %d", len(at), elapsed, want)
+	}
+	for i := 1; i < len(at); i++ {
+		if gap := at[i].Sub(at[i-1]); gap < interval/2 {
+			t.Errorf("progress events %d and %d %v apart, want about %v", i-1, i, gap, interval)
+		}
+	}
+}
+
+func TestStreamWorkerReportsOnlyNewText(t *testing.T) {
+	// The first chunk comes at once, and nothing else for several intervals.
+	client := &llmclient.MockClient{Response: "only words", ChunkDelay: 120 * time.Millisecond}
+	o := NewOrchestrator(nil, nil, nil, nil)
+	o.SetWorkerProgress(20 * time.Millisecond)
+
+	events := make(chan sse.Event, 100)
+	if _, err := o.streamWorker(llmclient.WithResponseInfo(context.Background()), "en", stageLLM2, client, "prompt", events); err != nil {
+		t.Fatal(err)
+	}
+	close(events)
+	var texts []string
+	for ev := range events {
+		texts = append(texts, ev.Data)
+	}
+	// One report for the first word, and at most one more for the second.
+	if len(texts) == 0 || len(texts) > 2 {
+		t.Errorf("progress events %q, want one per change", texts)
+	}
+}
+
+func TestStreamWorkerCancelled(t *testing.T) {
+	client := &llmclient.MockClient{Response: strings.Repeat("word ", 100), ChunkDelay: 10 * time.Millisecond}
+	o := NewOrchestrator(nil, nil, nil, nil)
+	o.SetWorkerProgress(time.Second)
+
+	ctx, cancel := context.WithTimeout(llmclient.WithResponseInfo(context.Background()), 30*time.Millisecond)
+	defer cancel()
+	if _, err := o.streamWorker(ctx, "en", stageLLM1, client, "prompt", make(chan sse.Event, 10)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's", err)
+	}
+}
+
+func TestWorkerProgressEventsInRequest(t *testing.T) {
+	o := newTestOrchestrator(t, "", "", "The answer.")
+	o.llm1.next = &llmclient.MockClient{Response: strings.Repeat("flight ", 15), ChunkDelay: 10 * time.Millisecond}
+	o.llm2.next = &llmclient.MockClient{Response: strings.Repeat("price ", 15), ChunkDelay: 10 * time.Millisecond}
+	o.SetWorkerProgress(40 * time.Millisecond)
+
+	events := process(t, o.Orchestrator, "What is the capital of France?", Options{}, false)
+	for _, stage := range []string{stageLLM1, stageLLM2} {
+		if n := len(progressEvents(events, stage)); n < 2 {
+			t.Errorf("%s sent %d progress events, want several", stage, n)
+		}
+	}
+	if got := answerOf(events); got != "The answer." {
+		t.Errorf("answer = %q", got)
+	}
+}
+
+func TestNoWorkerProgressWithoutInterval(t *testing.T) {
+	o := newTestOrchestrator(t, "", "", "The answer.")
+	o.llm1.next = &llmclient.MockClient{Response: strings.Repeat("flight ", 15), ChunkDelay: 5 * time.Millisecond}
+	events := process(t, o.Orchestrator, "What is the capital of France?", Options{}, false)
+	if n := len(progressEvents(events, stageLLM1)); n != 0 {
+		t.Errorf("%d progress events with progress off", n)
+	}
+}
+
+// slowStore is a backend whose flight search takes latency, and with waitFor set doesn't
+// answer before waitFor is closed.
+type slowStore struct {
+	db.Client
+	latency time.Duration
+	waitFor <-chan struct{}
+}
+
+func (s *slowStore) QueryFlights(ctx context.Context, q db.FlightQuery) ([]db.Flight, error) {
+	if s.waitFor != nil {
+		select {
+		case <-s.waitFor:
+		case <-time.After(2 * time.Second):
+			return nil, errors.New("nothing ran while the search did")
+		}
+	}
+	time.Sleep(s.latency)
+	return s.Client.QueryFlights(ctx, q)
+}
+
+// slowRates are the default exchange rates, each lookup taking latency. second is closed by
+// the second lookup.
+type slowRates struct {
+	latency time.Duration
+	calls   atomic.Int32
+	second  chan struct{}
+}
+
+func newSlowRates(latency time.Duration) *slowRates {
+	return &slowRates{latency: latency, second: make(chan struct{})}
+}
+
+func (r *slowRates) Rate(ctx context.Context, from, to string) (float64, error) {
+	time.Sleep(r.latency)
+	if r.calls.Add(1) == 2 {
+		close(r.second)
+	}
+	return currency.DefaultRates.Rate(ctx, from, to)
+}
+
+// newSlowOrchestrator returns an orchestrator over store, seeded with the sample flights,
+// converting prices with rates.
+func newSlowOrchestrator(tb testing.TB, store *slowStore, rates currency.RateProvider, llm1, llm2, llm3 llmclient.LLMClient) *Orchestrator {
+	tb.Helper()
+	memory := db.NewMemoryClient()
+	if err := memory.SeedFlights(context.Background()); err != nil {
+		tb.Fatal(err)
+	}
+	store.Client = memory
+	o := NewOrchestrator(llm1, llm2, llm3, store)
+	o.SetCurrency(currency.NewConverter(currency.USD, rates))
+	if err := o.cities.Refresh(context.Background()); err != nil {
+		tb.Fatal(err)
+	}
+	return o
+}
+
+// eurQuestion is a flight question with a price limit in euros: its limit is converted to
+// dollars for the search, and back for the understanding sent while the search runs.
+const eurQuestion = "Show me flights from Madrid to Paris under 500 EUR"
+
+func TestSearchOverlapsUnderstanding(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		rates := newSlowRates(0)
+		// The search answers only once the understanding has converted the limit back.
+		store := &slowStore{waitFor: rates.second}
+		o := newSlowOrchestrator(t, store, rates, mockAnswering("FL101."), mockAnswering("2h."), mockAnswering("FL101, 2h."))
+
+		events := process(t, o, eurQuestion, Options{}, stream)
+		understood := ofType(events, sse.TypeQueryUnderstanding)
+		if len(understood) != 1 || len(ofType(events, sse.TypeFlightResults)) != 1 {
+			t.Fatalf("stream %v: events %v, want the understanding and the flights", stream, events)
+		}
+		if understood[0].Payload.(sse.QueryUnderstandingPayload).Currency != "EUR" {
+			t.Errorf("stream %v: understanding %+v, want the limit in EUR", stream, understood[0].Payload)
+		}
+	}
+}
+
+func TestWorkerFailureStillAggregated(t *testing.T) {
+	o := newTestOrchestrator(t, "", "The capital is Paris.", "Paris.")
+	o.llm1.next = failingClient{}
+	events := process(t, o.Orchestrator, "What is the capital of France?", Options{}, false)
+
+	if got := answerOf(events); got != "Paris." {
+		t.Errorf("answer = %q, want LLM 3's", got)
+	}
+	prompts := o.llm3.Prompts()
+	if len(prompts) != 1 || !strings.Contains(prompts[0], errUnavailable.Error()) || !strings.Contains(prompts[0], "The capital is Paris.") {
+		t.Errorf("aggregation prompts %q, want LLM 1's error and LLM 2's answer", prompts)
+	}
+}
+
+// errUnavailable is what failingClient answers.
+var errUnavailable = errors.New("mock LLM unavailable")
+
+// failingClient is an LLM that fails every call at once.
+type failingClient struct{}
+
+func (failingClient) ChatCompletion(context.Context, string) (string, error) {
+	return "", errUnavailable
+}
+
+func (failingClient) StreamChatCompletion(context.Context, string) (<-chan string, error) {
+	return nil, errUnavailable
+}
+
+// BenchmarkPipeline runs requests whose every LLM call, exchange rate lookup and flight search
+// takes the same latency, and reports the request's time against the sum of those latencies
+// (critical/serial): the stages that overlap (the workers, and the search with the
+// understanding's currency conversion) keep it under 1.
+func BenchmarkPipeline(b *testing.B) {
+	const latency = 5 * time.Millisecond
+	for _, bc := range []struct {
+		name    string
+		message string
+		stream  bool
+		waits   int // Searches and LLM calls per request; rate lookups are counted
+	}{
+		{"flight/buffered", eurQuestion, false, 4},
+		{"flight/stream", eurQuestion, true, 4},
+		{"general/buffered", "What is the capital of France?", false, 3},
+		{"general/stream", "What is the capital of France?", true, 3},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			llm := func() llmclient.LLMClient {
+				return &llmclient.MockClient{Response: "FL101 takes 2h.", Latency: latency}
+			}
+			rates := newSlowRates(latency)
+			o := newSlowOrchestrator(b, &slowStore{latency: latency}, rates, llm(), llm(), llm())
+			o.HideTelemetry()
+			b.ResetTimer()
+			for range b.N {
+				process(b, o, bc.message, Options{}, bc.stream)
+			}
+			waits := float64(bc.waits) + float64(rates.calls.Load())/float64(b.N)
+			perOp := b.Elapsed() / time.Duration(b.N)
+			b.ReportMetric(float64(perOp)/(waits*float64(latency)), "critical/serial")
+		})
+	}
+}
+
+// fakeProvider answers the OpenAI API's requests in place of the network, for the rest of the
+// test: each response has the X-Request-Id "req_" plus the request's model, and the models in
+// failing get a 500.
+func fakeProvider(t *testing.T, failing ...string) {
+	prev := http.DefaultTransport
+	http.DefaultTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var req llmclient.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{llmclient.ProviderRequestIDHeader: {"req_" + req.Model}}, Request: r}
+		switch {
+		case slices.Contains(failing, req.Model):
+			resp.StatusCode, resp.Body = http.StatusInternalServerError, io.NopCloser(strings.NewReader("overloaded"))
+		case req.Stream:
+			resp.Body = io.NopCloser(strings.NewReader(`data: {"choices":[{"delta":{"content":"FL101."}}]}` + "\n\ndata: [DONE]\n\n"))
+		default:
+			resp.Body = io.NopCloser(strings.NewReader(`{"choices":[{"message":{"role":"assistant","content":"FL101."}}]}`))
+		}
+		return resp, nil
+	})
+	t.Cleanup(func() { http.DefaultTransport = prev })
+}
+
+// roundTripFunc is an http.RoundTripper that answers with a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestProviderRequestIDsLogged(t *testing.T) {
+	fakeProvider(t, "model-3")
+	for _, stream := range []bool{false, true} {
+		store := db.NewMemoryClient()
+		if err := store.SeedFlights(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		clients := make([]llmclient.LLMClient, 3)
+		for i := range clients {
+			c := llmclient.NewOpenAIClient(fmt.Sprintf("model-%d", i+1))
+			c.SetAPIKey("test-key")
+			clients[i] = c
+		}
+		o := NewOrchestrator(clients[0], clients[1], clients[2], store)
+		if err := o.cities.Refresh(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		o.SetModels("model-1", "model-2", "model-3")
+		o.EnableQueryLog(nil)
+		o.StorePrompts()
+		requestID := fmt.Sprintf("req-provider-%v", stream)
+		events := make(chan sse.Event, 1024)
+		ctx := logging.WithRequestID(context.Background(), requestID)
+		if stream {
+			o.ProcessMessageStream(ctx, "Flights from Madrid to Paris", Options{}, events)
+		} else {
+			o.ProcessMessage(ctx, "Flights from Madrid to Paris", Options{}, events)
+		}
+		drain(events)
+
+		// Each call records the provider's ID of its own request, the failed one too, whose
+		// error names it.
+		entry := queryLogOf(t, &testOrchestrator{Orchestrator: o, db: store}, requestID)
+		want := map[string]string{stageLLM1: "req_model-1", stageLLM2: "req_model-2", stageAggregation: "req_model-3"}
+		if len(entry.Calls) != 3 {
+			t.Fatalf("stream %v: calls %+v", stream, entry.Calls)
+		}
+		for _, call := range entry.Calls {
+			if call.ProviderRequestID != want[call.Stage] {
+				t.Errorf("stream %v: %s call has provider request ID %q, want %q", stream, call.Stage, call.ProviderRequestID, want[call.Stage])
+			}
+		}
+		if aggregation := entry.Calls[2]; !strings.Contains(aggregation.Error, "request req_model-3") {
+			t.Errorf("stream %v: failed call's error %q doesn't name the provider's request", stream, aggregation.Error)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package orchestrator
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/currency"
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// Names of the session preferences, as recorded in QueryLog.Preferences and
+// Telemetry.Preferences. They match the JSON fields of db.Preferences.
+const (
+	preferenceHomeCity = "home_city"
+	preferenceCurrency = "currency"
+	preferenceBudget   = "max_budget"
+	preferenceLanguage = "language"
+)
+
+// rememberPattern matches a lowercased message asking to remember a preference: "remember
+// that I always fly from Madrid", "please remember my budget is 300", "recuerda que vuelo
+// desde Sevilla".
+var rememberPattern = regexp.MustCompile(`^(?:please,? |por favor,? )?(?:remember|recuerda)\b`)
+
+// homeCityPattern finds the city a "remember" message says the user flies from. The capture
+// runs to the end of the words, so the city is taken as its longest prefix that is a city.
+var homeCityPattern = regexp.MustCompile(`(?:from|desde|out of|live in|vivo en|based in|salgo de|home city is) (\pL[\pL ]*)`)
+
+// budgetPattern finds where a "remember" message gives a budget ("my budget is $300", "mi
+// presupuesto es de mil euros"); the amount is the first number within budgetReach bytes
+// after it. maxPriceIn finds the ones given as a limit ("under 300").
+var budgetPattern = regexp.MustCompile(`(?:budget|presupuesto)`)
+
+const budgetReach = 20
+
+// preferredLanguages map the language names a "remember" message may use to catalog codes.
+var preferredLanguages = []struct {
+	pattern *regexp.Regexp
+	code    string
+}{
+	{regexp.MustCompile(`\bspanish\b|\bcastellano\b|español|espanol`), "es"},
+	{regexp.MustCompile(`\benglish\b|inglés|\bingles\b`), "en"},
+}
+
+// isRememberRequest reports whether the lowercased message asks to remember preferences.
+func isRememberRequest(lower string) bool {
+	return rememberPattern.MatchString(strings.TrimSpace(lower))
+}
+
+// rememberPreferences answers a message asking to remember preferences: it saves the ones it
+// recognizes in the session's preferences, prefs, and confirms them in a written answer,
+// without calling the LLMs. A request without preferences (nil prefs) can't save any.
+func (o *Orchestrator) rememberPreferences(ctx context.Context, entry *db.QueryLog, userMessage, lang string, prefs *db.Preferences, failure *error, eventChan chan<- sse.Event) {
+	entry.Preferences = nil // Only the ones saved are recorded, not the language used
+	if prefs == nil {
+		o.sendAnswer(ctx, entry, lang, i18n.T(lang, "message.preferences.no_session"), eventChan)
+		return
+	}
+	lower := strings.ToLower(userMessage)
+	updated := *prefs
+	var saved, described []string
+	if city := o.homeCityIn(ctx, lower); city != "" {
+		updated.HomeCity = city
+		saved = append(saved, preferenceHomeCity)
+		described = append(described, i18n.T(lang, "preference.home_city", city))
+	}
+	if code, ok := currency.Detect(userMessage); ok {
+		// Checked with a unit amount, so a currency that can't be shown isn't remembered.
+		if _, err := o.currency.Convert(ctx, 1, o.currency.Base(), code); err != nil {
+			eventChan <- sse.Status(i18n.T(lang, "status.preference_currency_unknown", code))
+		} else {
+			updated.Currency = code
+			saved = append(saved, preferenceCurrency)
+			described = append(described, i18n.T(lang, "preference.currency", code))
+		}
+	}
+	for _, l := range preferredLanguages {
+		if l.pattern.MatchString(lower) {
+			updated.Language = l.code
+			saved = append(saved, preferenceLanguage)
+			described = append(described, i18n.T(lang, "preference.language."+l.code))
+			break
+		}
+	}
+	budget := budgetIn(lower, lang)
+	if budget == 0 && updated.MaxBudget > 0 && updated.Currency != prefs.Currency {
+		// The budget is kept in the preferred currency, so it follows a change of currency.
+		from, to := cmp.Or(prefs.Currency, o.currency.Base()), cmp.Or(updated.Currency, o.currency.Base())
+		if converted, err := o.currency.Convert(ctx, updated.MaxBudget, from, to); err == nil {
+			updated.MaxBudget = currency.Round(converted, to)
+		}
+	}
+	if budget > 0 {
+		updated.MaxBudget = budget
+		saved = append(saved, preferenceBudget)
+		described = append(described, i18n.T(lang, "preference.max_budget", currency.Format(budget, cmp.Or(updated.Currency, o.currency.Base()), lang)))
+	}
+	if len(saved) == 0 {
+		o.sendAnswer(ctx, entry, lang, i18n.T(lang, "message.preferences.unrecognized"), eventChan)
+		return
+	}
+
+	updated.UpdatedAt = time.Now().UTC()
+	if err := o.dbClient.SavePreferences(ctx, updated); err != nil {
+		slog.ErrorContext(ctx, "Failed to save preferences", "session_id", updated.SessionID, "error", err)
+		entry.Error = err.Error()
+		*failure = err
+		eventChan <- sse.Error("preferences_unavailable", i18n.T(lang, "error.preferences_unsaved"))
+		return
+	}
+	entry.Preferences = saved
+	slog.InfoContext(ctx, "Preferences saved", "session_id", updated.SessionID, "preferences", saved)
+	o.sendAnswer(ctx, entry, lang, i18n.T(lang, "message.preferences.saved", joinList(lang, described)), eventChan)
+}
+
+// homeCityIn returns the city the lowercased message says the user flies from, as the routes
+// name it, or "" if it names none the routes serve.
+func (o *Orchestrator) homeCityIn(ctx context.Context, lower string) string {
+	matches := homeCityPattern.FindAllStringSubmatch(lower, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+	routes, err := o.dbClient.ListRoutes(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list routes; not remembering a home city", "error", err)
+		return ""
+	}
+	cities := db.Cities(routes)
+	for _, m := range matches {
+		words := strings.Fields(m[1])
+		for n := min(len(words), 3); n > 0; n-- {
+			if city := o.cities.resolve(strings.Join(words[:n], " "), cities); city != "" {
+				return city
+			}
+		}
+	}
+	return ""
+}
+
+// budgetIn returns the budget in the lowercased message, written as a reader of lang would
+// (see parseNumber), or 0 if it gives none.
+func budgetIn(lower, lang string) float64 {
+	if m := budgetPattern.FindStringIndex(lower); m != nil {
+		rest := lower[m[1]:]
+		for i := 0; i <= min(len(rest), budgetReach); i++ {
+			if i > 0 && isWordByte(rest[i-1]) {
+				continue // Numbers start words: "is", "es de" and "$" come before them
+			}
+			if budget := numberAt(rest[i:], lang); budget > 0 {
+				return budget
+			}
+		}
+	}
+	return maxPriceIn(lower, lang)
+}
+
+// isWordByte reports whether b is an ASCII letter or digit.
+func isWordByte(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+}
+
+// applyPreferences fills in the origin and price limit of the flight question in entry from
+// the session's preferences, prefs, where userMessage names none, then starts the answer with
+// a note of the preferences used. The origin isn't filled in when it is the destination.
+// applyCurrency must have run: it fills in the currency, and the budget is converted from it.
+func (o *Orchestrator) applyPreferences(ctx context.Context, entry *db.QueryLog, userMessage, lang string, prefs *db.Preferences, eventChan chan<- sse.Event) {
+	if prefs != nil {
+		if entry.Origin == "" && prefs.HomeCity != "" && prefs.HomeCity != entry.Destination {
+			entry.Origin = prefs.HomeCity
+			entry.Preferences = append(entry.Preferences, preferenceHomeCity)
+		}
+		if prefs.MaxBudget > 0 && maxPriceIn(strings.ToLower(userMessage), lang) == 0 {
+			from := cmp.Or(prefs.Currency, o.currency.Base())
+			if limit, err := o.currency.Convert(ctx, prefs.MaxBudget, from, o.currency.Base()); err != nil {
+				slog.WarnContext(ctx, "Failed to convert the preferred budget; searching without it", "currency", from, "error", err)
+			} else {
+				entry.MaxPrice = limit
+				entry.Preferences = append(entry.Preferences, preferenceBudget)
+			}
+		}
+	}
+	if len(entry.Preferences) == 0 {
+		return
+	}
+	// The note names no amounts, so the grounding check doesn't take the budget for a price.
+	described := make([]string, len(entry.Preferences))
+	for i, name := range entry.Preferences {
+		switch name {
+		case preferenceHomeCity:
+			described[i] = i18n.T(lang, "preference.home_city", entry.Origin)
+		case preferenceCurrency:
+			described[i] = i18n.T(lang, "preference.currency", entry.Currency)
+		case preferenceBudget:
+			described[i] = i18n.T(lang, "preference.max_budget.used")
+		case preferenceLanguage:
+			described[i] = i18n.T(lang, "preference.language."+lang)
+		case preferencePassengers:
+			described[i] = i18n.T(lang, "preference.passengers", entry.Passengers)
+		}
+	}
+	eventChan <- sse.MessageChunk(i18n.T(lang, "message.preferences.applied", joinList(lang, described))+"\n\n", false)
+}
+
+// followLanguage records lang as the language of the session's conversation, so that its next
+// messages are answered in it unless they are confidently in another (see requestLanguage),
+// and announces a change with a Status event in the new language. It returns the preferences
+// the rest of the request uses: prefs, updated if the language changed. A failure to save is
+// logged and otherwise ignored, since the answer doesn't depend on it.
+func (o *Orchestrator) followLanguage(ctx context.Context, prefs *db.Preferences, lang string, eventChan chan<- sse.Event) *db.Preferences {
+	if prefs == nil || prefs.ConversationLanguage == lang {
+		return prefs
+	}
+	if prefs.ConversationLanguage != "" {
+		slog.InfoContext(ctx, "Conversation language changed", "session_id", prefs.SessionID, "from", prefs.ConversationLanguage, "to", lang)
+		eventChan <- sse.Status(i18n.T(lang, "status.language_changed"))
+	}
+	updated := *prefs
+	updated.ConversationLanguage = lang
+	updated.UpdatedAt = time.Now().UTC()
+	if err := o.dbClient.SavePreferences(ctx, updated); err != nil {
+		slog.WarnContext(ctx, "Failed to save the conversation language", "session_id", prefs.SessionID, "error", err)
+	}
+	return &updated
+}
@@ -0,0 +1,172 @@
+package orchestrator
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// savedPreferences returns the preferences stored for sessionID.
+func savedPreferences(t *testing.T, o *testOrchestrator, sessionID string) *db.Preferences {
+	t.Helper()
+	prefs, err := o.db.GetPreferences(context.Background(), sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &prefs
+}
+
+// flightOrigins returns the origins of the flights the FlightResults events gave.
+func flightOrigins(events []sse.Event) []string {
+	var origins []string
+	for _, ev := range ofType(events, sse.TypeFlightResults) {
+		for _, f := range ev.Payload.([]db.Flight) {
+			origins = append(origins, f.Origin)
+		}
+	}
+	return slices.Compact(origins)
+}
+
+func TestHomeCityApplied(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "FL103 is cheapest.", "FL101 is earliest.", "Take FL103.")
+		prefs := &db.Preferences{SessionID: "session-home", Client: "key:aaaa"}
+
+		// The preference is recognized and saved without asking the LLMs.
+		events := process(t, o.Orchestrator, "Remember that I always fly from Madrid", Options{SessionID: "session-home", Preferences: prefs}, stream)
+		if answer := answerOf(events); answer != "Got it. From now on in this conversation I'll use: departing from Madrid." {
+			t.Errorf("stream %v: answer %q", stream, answer)
+		}
+		if len(o.llm1.Prompts())+len(o.llm2.Prompts())+len(o.llm3.Prompts()) != 0 {
+			t.Errorf("stream %v: LLMs called to remember", stream)
+		}
+		saved := savedPreferences(t, o, "session-home")
+		if saved.HomeCity != "Madrid" || saved.Client != "key:aaaa" {
+			t.Fatalf("stream %v: saved %+v", stream, saved)
+		}
+
+		// A question without an origin searches from it, and says so.
+		events = process(t, o.Orchestrator, "Show me flights to Paris", Options{SessionID: "session-home", Preferences: saved}, stream)
+		if origins := flightOrigins(events); !slices.Equal(origins, []string{"Madrid"}) {
+			t.Errorf("stream %v: flights from %v, want Madrid", stream, origins)
+		}
+		if answer := answerOf(events); !strings.HasPrefix(answer, "Using your saved preferences: departing from Madrid.\n\n") {
+			t.Errorf("stream %v: answer %q", stream, answer)
+		}
+		if got := telemetryOf(t, events).Preferences; !slices.Contains(got, "home_city") {
+			t.Errorf("stream %v: telemetry preferences %v", stream, got)
+		}
+
+		// A question with an origin keeps it.
+		events = process(t, o.Orchestrator, "Show me flights from Rome to Paris", Options{SessionID: "session-home", Preferences: saved}, stream)
+		if origins := flightOrigins(events); !slices.Equal(origins, []string{"Rome"}) || slices.Contains(telemetryOf(t, events).Preferences, "home_city") {
+			t.Errorf("stream %v: flights from %v with an origin given", stream, origins)
+		}
+	}
+}
+
+func TestBudgetApplied(t *testing.T) {
+	o := newTestOrchestrator(t, "Cheap flights.", "Cheap flights.", "Cheap flights.")
+	prefs := &db.Preferences{SessionID: "session-budget", HomeCity: "Madrid"}
+	events := process(t, o.Orchestrator, "Please remember my budget is 125", Options{SessionID: "session-budget", Preferences: prefs}, false)
+	saved := savedPreferences(t, o, "session-budget")
+	if saved.MaxBudget != 125 || saved.HomeCity != "Madrid" {
+		t.Fatalf("saved %+v after %q", saved, answerOf(events))
+	}
+
+	events = process(t, o.Orchestrator, "Flights to Paris", Options{SessionID: "session-budget", Preferences: saved}, false)
+	var prices []float64
+	for _, ev := range ofType(events, sse.TypeFlightResults) {
+		for _, f := range ev.Payload.([]db.Flight) {
+			prices = append(prices, f.Price)
+		}
+	}
+	slices.Sort(prices)
+	if !slices.Equal(prices, []float64{110, 120}) {
+		t.Errorf("prices %v, want the flights within 125", prices)
+	}
+	if answer := answerOf(events); !strings.HasPrefix(answer, "Using your saved preferences: departing from Madrid and within your budget.") {
+		t.Errorf("answer %q", answer)
+	}
+}
+
+func TestRememberPreferences(t *testing.T) {
+	for _, tt := range []struct {
+		message string
+		want    db.Preferences
+	}{
+		{"Recuerda que vuelo desde Barcelona", db.Preferences{HomeCity: "Barcelona"}},
+		{"remember to answer in Spanish", db.Preferences{Language: "es"}},
+		{"Remember I'm based in Valencia and my budget is 200", db.Preferences{HomeCity: "Valencia", MaxBudget: 200}},
+	} {
+		o := newTestOrchestrator(t, "", "", "")
+		process(t, o.Orchestrator, tt.message, Options{SessionID: "session-1", Preferences: &db.Preferences{SessionID: "session-1"}}, false)
+		saved := savedPreferences(t, o, "session-1")
+		if saved.HomeCity != tt.want.HomeCity || saved.Language != tt.want.Language || saved.MaxBudget != tt.want.MaxBudget {
+			t.Errorf("%q: saved %+v, want %+v", tt.message, saved, tt.want)
+		}
+	}
+
+	// Nothing recognizable, or no session to remember it in.
+	o := newTestOrchestrator(t, "", "", "")
+	events := process(t, o.Orchestrator, "Remember the alamo", Options{SessionID: "session-2", Preferences: &db.Preferences{SessionID: "session-2"}}, false)
+	if answer := answerOf(events); !strings.HasPrefix(answer, "I couldn't tell what to remember.") {
+		t.Errorf("unrecognized: %q", answer)
+	}
+	events = process(t, o.Orchestrator, "Remember that I always fly from Madrid", Options{}, false)
+	if answer := answerOf(events); !strings.HasPrefix(answer, "I can only remember preferences within a conversation.") {
+		t.Errorf("without a session: %q", answer)
+	}
+	// Only the conversation's language is kept.
+	if saved := savedPreferences(t, o, "session-2"); saved.HomeCity != "" || saved.Currency != "" || saved.Language != "" || saved.MaxBudget != 0 {
+		t.Errorf("unrecognized preferences saved: %+v", saved)
+	}
+}
+
+func TestConversationLanguage(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "Respuesta.", "Respuesta.", "Respuesta.")
+		turn := func(message string) []sse.Event {
+			t.Helper()
+			prefs := &db.Preferences{SessionID: "session-lang"}
+			if saved, err := o.db.GetPreferences(context.Background(), "session-lang"); err == nil {
+				prefs = &saved
+			}
+			return process(t, o.Orchestrator, message, Options{SessionID: "session-lang", Preferences: prefs}, stream)
+		}
+
+		// Three Spanish turns, the last a one-word follow-up, stay Spanish without a change.
+		for _, message := range []string{"¿Cuál es el vuelo más barato desde Madrid a París?", "¿Y cuánto cuesta el vuelo a Barcelona?", "ok"} {
+			events := turn(message)
+			if got := telemetryOf(t, events).Language; got != LanguageSpanish {
+				t.Errorf("stream %v: %q answered in %s", stream, message, got)
+			}
+			if statuses := statusTexts(events); strings.Contains(statuses, "Continuing") || strings.Contains(statuses, "Continúo") {
+				t.Errorf("stream %v: %q changed the language: %q", stream, message, statuses)
+			}
+		}
+		if saved := savedPreferences(t, o, "session-lang"); saved.ConversationLanguage != "es" {
+			t.Fatalf("stream %v: conversation language %q", stream, saved.ConversationLanguage)
+		}
+
+		// A full English sentence switches, and says so in English.
+		events := turn("What is the capital of France and how far is it from Madrid?")
+		if got := telemetryOf(t, events).Language; got != LanguageEnglish {
+			t.Errorf("stream %v: English sentence answered in %s", stream, got)
+		}
+		if statuses := statusTexts(events); !strings.Contains(statuses, "Continuing the conversation in English.") {
+			t.Errorf("stream %v: statuses %q", stream, statuses)
+		}
+		if saved := savedPreferences(t, o, "session-lang"); saved.ConversationLanguage != "en" {
+			t.Errorf("stream %v: conversation language %q after switching", stream, saved.ConversationLanguage)
+		}
+		// And a short follow-up now stays English.
+		if got := telemetryOf(t, turn("sí")).Language; got != LanguageEnglish {
+			t.Errorf("stream %v: follow-up answered in %s", stream, got)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package orchestrator
+
+import (
+	"context"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// repeatAnswer answers a message the session sent a moment ago (see Options.Repeat) with the
+// answer it got then, without calling the LLMs: a Status note says so, and the flights shown
+// then and the answer follow as they did, the answer in chunks if stream is set.
+func (o *Orchestrator) repeatAnswer(ctx context.Context, entry *db.QueryLog, lang string, previous db.Turn, stream bool, eventChan chan<- sse.Event) {
+	eventChan <- sse.Status(i18n.T(lang, "status.repeat"))
+	entry.ResultCount = len(previous.Flights)
+	if len(previous.Flights) > 0 {
+		eventChan <- sse.FlightResults(o.withLocalTimes(previous.Flights))
+	}
+	if stream {
+		o.streamAnswer(ctx, entry, lang, previous.Content, eventChan)
+		return
+	}
+	o.sendAnswer(ctx, entry, lang, previous.Content, eventChan)
+}
@@ -0,0 +1,49 @@
+package orchestrator
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestRepeatAnswer(t *testing.T) {
+	previous := &db.Turn{
+		Role:      db.RoleAssistant,
+		Content:   strings.Repeat("FL118 leaves Tokyo at 11:00 local time. ", 10),
+		Flights:   []db.Flight{{FlightNumber: "FL118", Origin: "Tokyo", Destination: "Los Angeles", OriginAirport: "HND", DepartureTime: "2025-08-20T02:00:00Z", ArrivalTime: "2025-08-20T12:00:00Z"}},
+		Timestamp: time.Now(),
+	}
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+		o.SetChunkCoalescing(0, 64)
+		var intent string
+		events := process(t, o.Orchestrator, "Flights from Tokyo?", Options{Repeat: previous, OnIntent: func(i string) { intent = i }}, stream)
+
+		// The previous answer and flights come back, with a note, and no LLM is asked.
+		if len(o.llm1.Prompts())+len(o.llm2.Prompts())+len(o.llm3.Prompts()) != 0 {
+			t.Errorf("stream %v: LLMs called for a repeated message", stream)
+		}
+		if answer := answerOf(events); answer != previous.Content {
+			t.Errorf("stream %v: answer %q", stream, answer)
+		}
+		statuses := ofType(events, sse.TypeStatus)
+		if len(statuses) == 0 || statuses[len(statuses)-1].Data != i18n.T("en", "status.repeat") {
+			t.Errorf("stream %v: statuses %v", stream, statuses)
+		}
+		results := ofType(events, sse.TypeFlightResults)
+		if len(results) != 1 || results[0].Payload.([]db.Flight)[0].DepartureLocal != "2025-08-20T11:00:00+09:00" {
+			t.Errorf("stream %v: flight results %+v", stream, results)
+		}
+		if telemetry := telemetryOf(t, events); telemetry.Intent != "repeat" || intent != "repeat" {
+			t.Errorf("stream %v: intent %q, reported %q", stream, telemetry.Intent, intent)
+		}
+		// A streamed request gets it in chunks.
+		if messages := len(ofType(events, sse.TypeMessage)); (messages > 1) != stream {
+			t.Errorf("stream %v: %d Message events", stream, messages)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// Questions about where we fly ("where can I fly from Madrid?", "what routes do you have?")
+// are answered from the routes in the database rather than by the LLMs, which would make up
+// destinations: the workers only ever see the flights a search returned, not the network.
+
+// Kinds of route question.
+const (
+	routesFrom = "from" // The destinations from a city
+	routesTo   = "to"   // The origins of flights to a city
+	routesAll  = "all"  // Every route
+)
+
+// routeQuestion is a question about routes: its kind and, unless it asks for every route, the
+// city it names as written.
+type routeQuestion struct {
+	kind string
+	city string
+}
+
+// routeQuestionPatterns recognize route questions in lowercased messages, in the order they are
+// tried. In patterns, CITY captures a city that ends the question.
+var routeQuestionPatterns = func() []struct {
+	kind    string
+	pattern *regexp.Regexp
+} {
+	const city = `(\pL[\pL .'-]*?)\s*[?.!]*$`
+	patterns := []struct{ kind, pattern string }{
+		{routesFrom, `\b(?:destinations?|routes?)\s+(?:do you (?:have|fly)\s+|are there\s+)?(?:from|out of)\s+CITY`},
+		{routesFrom, `\bwhere\b.*\b(?:fly|go|travel)\b(?:\s+to)?\s+(?:from|out of)\s+CITY`},
+		{routesFrom, `\b(?:what|which)\s+(?:cities|places|destinations)\b.*\b(?:from|out of)\s+CITY`},
+		{routesFrom, `\b(?:destinos|rutas)\s+(?:hay\s+|tienen\s+|tenéis\s+)?desde\s+CITY`},
+		{routesFrom, `d[oó]nde\b.*\b(?:volar|vuela|vuelan|volamos|ir|viajar)\b\s+desde\s+CITY`},
+		{routesFrom, `qu[eé]\s+(?:ciudades|destinos|sitios)\b.*\bdesde\s+CITY`},
+
+		{routesTo, `\borigins?\s+(?:to|for)\s+CITY`},
+		{routesTo, `\bfrom where\b.*\b(?:fly|go|travel)\s+to\s+CITY`},
+		{routesTo, `\b(?:what|which)\s+(?:cities|places)\b.*\b(?:fly|flights?)\s+to\s+CITY`},
+		{routesTo, `\bwhere do (?:the\s+)?flights\s+to\s+(\pL[\pL .'-]*?)\s+(?:come|leave|depart)\s+from\s*[?.!]*$`},
+		{routesTo, `\bor[ií]genes\s+(?:a|hacia|para)\s+CITY`},
+		{routesTo, `desde\s+d[oó]nde\b.*\b(?:a|hacia|hasta)\s+CITY`},
+		{routesTo, `qu[eé]\s+ciudades\b.*\b(?:a|hacia)\s+CITY`},
+
+		{routesAll, `\b(?:what|which)\s+routes\b`},
+		{routesAll, `\b(?:what|which)\s+(?:destinations|cities)\s+do you\s+(?:fly|serve|cover|have)\b`},
+		{routesAll, `\bwhere do you fly(?:\s+to)?\s*[?.!]*$`},
+		{routesAll, `\b(?:all|list)\s+(?:of\s+)?(?:your|the)\s+(?:routes|destinations)\b`},
+		{routesAll, `qu[eé]\s+rutas\b`},
+		{routesAll, `qu[eé]\s+destinos\s+(?:tienen|tenéis|hay|cubren|ofrecen)\b`},
+		{routesAll, `d[oó]nde\s+vuelan\s*[?.!]*$`},
+		{routesAll, `\b(?:todas|lista)\s+(?:de\s+)?(?:las|tus|sus)\s+rutas\b`},
+	}
+	compiled := make([]struct {
+		kind    string
+		pattern *regexp.Regexp
+	}, len(patterns))
+	for i, p := range patterns {
+		compiled[i].kind = p.kind
+		compiled[i].pattern = regexp.MustCompile(strings.ReplaceAll(p.pattern, "CITY", city))
+	}
+	return compiled
+}()
+
+// notCityWords show that what a pattern took for a city goes on with more of the question, as
+// in "where can I fly from Madrid to Paris?" or "... from Madrid tomorrow?".
+var notCityWords = map[string]bool{
+	"to": true, "a": true, "hacia": true, "hasta": true, "for": true, "para": true,
+	"on": true, "in": true, "en": true, "with": true, "con": true,
+	"under": true, "below": true, "less": true, "menos": true, "bajo": true,
+	"today": true, "tomorrow": true, "next": true, "hoy": true, "mañana": true, "próximo": true, "proximo": true,
+}
+
+// detectRouteQuestion reports whether the lowercased message asks about routes and nothing
+// more: a question that also names a destination, a date or a price limit is a flight search.
+func detectRouteQuestion(lower string) (routeQuestion, bool) {
+	lower = strings.TrimSpace(lower)
+	for _, p := range routeQuestionPatterns {
+		m := p.pattern.FindStringSubmatch(lower)
+		if m == nil {
+			continue
+		}
+		q := routeQuestion{kind: p.kind}
+		if len(m) > 1 {
+			q.city = strings.TrimSpace(m[1])
+			for _, word := range strings.Fields(q.city) {
+				if notCityWords[word] {
+					return routeQuestion{}, false
+				}
+			}
+		}
+		return q, true
+	}
+	return routeQuestion{}, false
+}
+
+// joinList joins items as a sentence does: "Paris, Barcelona and Valencia".
+func joinList(lang string, items []string) string {
+	if len(items) <= 1 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " " + i18n.T(lang, "list.and") + " " + items[len(items)-1]
+}
+
+// routeAnswer answers q from routes. It returns the answer, the routes it is drawn from and the
+// city q names, resolved against the cities routes serve ("" if q names none, or one they don't).
+func (o *Orchestrator) routeAnswer(lang string, q routeQuestion, routes []db.Route) (answer string, matched []db.Route, city string) {
+	if len(routes) == 0 {
+		return i18n.T(lang, "message.routes.empty"), nil, ""
+	}
+	if q.kind == routesAll {
+		lines := make([]string, len(routes))
+		for i, r := range routes {
+			lines[i] = fmt.Sprintf("• %s → %s", r.Origin, r.Destination)
+		}
+		return i18n.T(lang, "message.routes.all") + "\n" + strings.Join(lines, "\n"), routes, ""
+	}
+
+	cities := db.Cities(routes)
+	city = o.cities.resolve(q.city, cities)
+	if city == "" {
+		return i18n.T(lang, "message.routes.unknown_city", joinList(lang, cities)), nil, ""
+	}
+	var others []string // The other end of each route
+	for _, r := range routes {
+		switch {
+		case q.kind == routesFrom && r.Origin == city:
+			matched, others = append(matched, r), append(others, r.Destination)
+		case q.kind == routesTo && r.Destination == city:
+			matched, others = append(matched, r), append(others, r.Origin)
+		}
+	}
+	switch {
+	case q.kind == routesFrom && len(matched) == 0:
+		return i18n.T(lang, "message.routes.none_from", city), nil, city
+	case q.kind == routesFrom:
+		return i18n.T(lang, "message.routes.from", city, joinList(lang, others)), matched, city
+	case len(matched) == 0:
+		return i18n.T(lang, "message.routes.none_to", city), nil, city
+	default:
+		return i18n.T(lang, "message.routes.to", city, joinList(lang, others)), matched, city
+	}
+}
+
+// EnableRoutePhrasing has LLM 3 reword the answers to route questions, which are otherwise
+// sent as written. The model is given the written answer as data and told to keep its cities;
+// if the call fails, or the request's token budget can't pay for it, the written answer is
+// sent. It must be called before the orchestrator serves requests. Without it, the
+// route_phrasing feature flag turns it on request by request (see Options.Flags).
+func (o *Orchestrator) EnableRoutePhrasing() {
+	o.routePhrasing = true
+}
+
+// answerRoutes answers a route question from the routes in the database, sending the routes
+// it is drawn from as a Routes event before the answer. stream says whether a reworded answer
+// (see EnableRoutePhrasing) is streamed.
+func (o *Orchestrator) answerRoutes(ctx context.Context, entry *db.QueryLog, q routeQuestion, lang string, stream bool, timings *stageTimings, failure *error, eventChan chan<- sse.Event) {
+	start := time.Now()
+	routes, err := o.dbClient.ListRoutes(ctx)
+	timings.since(stageSearch, start)
+	if err != nil {
+		entry.Error = err.Error()
+		*failure = err
+		if errors.Is(err, db.ErrUnavailable) {
+			slog.WarnContext(ctx, "Route listing unavailable", "error", err)
+			eventChan <- sse.Error("search_unavailable", i18n.T(lang, "error.search_unavailable"))
+			return
+		}
+	}
+
+	answer, matched, city := o.routeAnswer(lang, q, routes)
+	entry.ResultCount = len(matched)
+	switch q.kind {
+	case routesFrom:
+		entry.Origin = city
+	case routesTo:
+		entry.Destination = city
+	}
+	if len(matched) == 0 {
+		eventChan <- sse.MessageChunk(answer, true)
+		return
+	}
+	// Structured results for JSON clients; plain clients just see the count.
+	eventChan <- sse.Routes(matched)
+	if !o.routePhrasing {
+		eventChan <- sse.MessageChunk(answer, true)
+		return
+	}
+	o.phraseRoutes(ctx, entry, lang, answer, stream, timings, eventChan)
+}
+
+// phraseRoutes has LLM 3 reword answer and sends the result, or answer itself if that fails.
+func (o *Orchestrator) phraseRoutes(ctx context.Context, entry *db.QueryLog, lang, answer string, stream bool, timings *stageTimings, eventChan chan<- sse.Event) {
+	language := entry.DetectedLanguage
+	prompt := dataOnlyNotice(language)
+	if language == LanguageSpanish {
+		prompt += "Reescribe la siguiente respuesta para que suene natural, de forma breve y amable. Usa solo las ciudades que nombra: no añadas, quites ni cambies ninguna. Responde en español.\n\n"
+	} else {
+		prompt += "Reword the following answer so it reads naturally, briefly and in a friendly tone. Use only the cities it names: do not add, drop or change any.\n\n"
+	}
+	prompt += fence("ROUTE ANSWER", sanitizeUntrusted(ctx, "route_answer", answer))
+	o.phrase(ctx, entry, lang, "routes", prompt, answer, stream, timings, eventChan)
+}
+
+// phrase has LLM 3 answer prompt, which rewords the answer the server wrote for a request of
+// intent, and sends the result, or written itself if the call fails, the request's token
+// budget can't pay for it, or LLM calls are off (see EnableDBOnly).
+func (o *Orchestrator) phrase(ctx context.Context, entry *db.QueryLog, lang, intent, prompt, written string, stream bool, timings *stageTimings, eventChan chan<- sse.Event) {
+	if o.dbOnly {
+		eventChan <- sse.MessageChunk(written, true)
+		return
+	}
+	if b := llmclient.BudgetFrom(ctx); b != nil {
+		promptTokens := llmclient.EstimateTokens(o.systemPrompts[stageAggregation] + prompt)
+		if err := b.Check(promptTokens, max(1, o.tokenBudget.MinAggregationTokens)); err != nil {
+			slog.InfoContext(ctx, "No token budget left to reword the written answer; sending it as written", "intent", intent, "error", err)
+			eventChan <- sse.MessageChunk(written, true)
+			return
+		}
+		ctx = llmclient.WithMaxTokens(ctx, b.Remaining()-promptTokens)
+	}
+
+	start := time.Now()
+	defer timings.since(stageAggregation, start)
+	timings.ranOn(stageAggregation, o.models[stageAggregation])
+	if stream {
+		streamCtx, stop := context.WithCancel(llmclient.WithResponseInfo(ctx))
+		defer stop()
+		streamChan, err := o.llm3Client.StreamChatCompletion(streamCtx, prompt)
+		if err == nil {
+			streamChan = timings.calledStream(streamCtx, stageAggregation, o.models[stageAggregation], prompt, start, streamChan)
+			o.forwardChunks(ctx, entry, lang, streamChan, stop, eventChan)
+			return
+		}
+		timings.called(streamCtx, stageAggregation, o.models[stageAggregation], prompt, "", err, start)
+		slog.WarnContext(ctx, "Rewording the written answer failed; sending it as written", "intent", intent, "error", err)
+	} else {
+		callCtx := llmclient.WithResponseInfo(ctx)
+		phrased, err := o.llm3Client.ChatCompletion(callCtx, prompt)
+		timings.called(callCtx, stageAggregation, o.models[stageAggregation], prompt, phrased, err, start)
+		if err == nil {
+			o.sendAnswer(ctx, entry, lang, phrased, eventChan)
+			return
+		}
+		slog.WarnContext(ctx, "Rewording the written answer failed; sending it as written", "intent", intent, "error", err)
+	}
+	eventChan <- sse.MessageChunk(written, true)
+}
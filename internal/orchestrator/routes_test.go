@@ -0,0 +1,125 @@
+package orchestrator
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestDetectRouteQuestion(t *testing.T) {
+	for message, want := range map[string]routeQuestion{
+		"where can i fly from madrid?":               {routesFrom, "madrid"},
+		"what destinations from new york?":           {routesFrom, "new york"},
+		"¿a dónde puedo volar desde madrid?":         {routesFrom, "madrid"},
+		"qué destinos hay desde barcelona":           {routesFrom, "barcelona"},
+		"where do flights to paris come from?":       {routesTo, "paris"},
+		"¿desde dónde se puede volar a parís?":       {routesTo, "parís"},
+		"what routes do you have?":                   {routesAll, ""},
+		"¿qué rutas tienen?":                         {routesAll, ""},
+		"where do you fly?":                          {routesAll, ""},
+		"list all of your destinations, please":      {routesAll, ""},
+		"which cities do you fly to from london?":    {routesFrom, "london"},
+		"what cities have flights to barcelona?":     {routesTo, "barcelona"},
+		"origins to valencia":                        {routesTo, "valencia"},
+		"which destinations do you serve in europe?": {routesAll, ""},
+	} {
+		if got, ok := detectRouteQuestion(message); !ok || got != want {
+			t.Errorf("detectRouteQuestion(%q) = %+v, %v, want %+v", message, got, ok, want)
+		}
+	}
+	// A question that goes on past the city is a flight search.
+	for _, message := range []string{
+		"where can i fly from madrid to paris?",
+		"where can i fly from madrid tomorrow?",
+		"vuelos desde madrid a parís",
+		"show me flights from madrid to paris",
+	} {
+		if got, ok := detectRouteQuestion(message); ok {
+			t.Errorf("detectRouteQuestion(%q) = %+v, want a flight search", message, got)
+		}
+	}
+}
+
+// routesOf returns the routes of the Routes events.
+func routesOf(events []sse.Event) []db.Route {
+	var routes []db.Route
+	for _, ev := range ofType(events, sse.TypeRoutes) {
+		routes = append(routes, ev.Payload.([]db.Route)...)
+	}
+	return routes
+}
+
+func TestRouteAnswers(t *testing.T) {
+	for _, tt := range []struct {
+		message, answer string
+		others          []string // The other end of the routes sent
+	}{
+		{"Where can I fly from Madrid?", "From Madrid we fly to Barcelona, Paris and Valencia.", []string{"Barcelona", "Paris", "Valencia"}},
+		{"¿A dónde puedo volar desde Madrid?", "Desde Madrid volamos a Barcelona, Paris y Valencia.", []string{"Barcelona", "Paris", "Valencia"}},
+		{"Where do flights to Valencia come from?", "We fly to Valencia from Madrid.", []string{"Madrid"}},
+		{"Where can I fly from Atlantis?", "We don't fly to or from that city.", nil},
+	} {
+		for _, stream := range []bool{false, true} {
+			o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+			events := process(t, o.Orchestrator, tt.message, Options{}, stream)
+
+			// The answer comes from the database, not the LLMs.
+			if !strings.HasPrefix(answerOf(events), tt.answer) {
+				t.Errorf("%q (stream %v): answer %q, want %q", tt.message, stream, answerOf(events), tt.answer)
+			}
+			var others []string
+			for _, r := range routesOf(events) {
+				if r.Origin == "Madrid" && strings.Contains(tt.message, "Madrid") {
+					others = append(others, r.Destination)
+				} else {
+					others = append(others, r.Origin)
+				}
+			}
+			slices.Sort(others)
+			if !slices.Equal(others, tt.others) {
+				t.Errorf("%q (stream %v): routes %v, want %v", tt.message, stream, routesOf(events), tt.others)
+			}
+			if calls := len(o.llm1.Prompts()) + len(o.llm2.Prompts()) + len(o.llm3.Prompts()); calls != 0 {
+				t.Errorf("%q (stream %v): %d LLM calls", tt.message, stream, calls)
+			}
+		}
+	}
+}
+
+func TestAllRoutes(t *testing.T) {
+	o := newTestOrchestrator(t, "", "", "")
+	events := process(t, o.Orchestrator, "What routes do you have?", Options{}, false)
+	routes := routesOf(events)
+	answer := answerOf(events)
+	if len(routes) == 0 || !strings.HasPrefix(answer, "These are the routes we fly:\n") || strings.Count(answer, "\n") != len(routes) {
+		t.Fatalf("answer %q with routes %v", answer, routes)
+	}
+	for _, r := range routes {
+		if !strings.Contains(answer, "• "+r.Origin+" → "+r.Destination) {
+			t.Errorf("route %v missing from %q", r, answer)
+		}
+	}
+}
+
+func TestRoutePhrasing(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "", "", "Madrid connects you to Barcelona, Paris and Valencia!")
+		o.EnableRoutePhrasing()
+		events := process(t, o.Orchestrator, "Where can I fly from Madrid?", Options{}, stream)
+
+		// LLM 3 only rewords the written answer, given to it as data.
+		prompts := o.llm3.Prompts()
+		if len(prompts) != 1 || !strings.Contains(prompts[0], "<<<BEGIN ROUTE ANSWER>>>\nFrom Madrid we fly to Barcelona, Paris and Valencia.\n<<<END ROUTE ANSWER>>>") {
+			t.Errorf("stream %v: prompts %q", stream, prompts)
+		}
+		if answerOf(events) != "Madrid connects you to Barcelona, Paris and Valencia!" || len(routesOf(events)) != 3 {
+			t.Errorf("stream %v: answer %q, routes %v", stream, answerOf(events), routesOf(events))
+		}
+		if len(o.llm1.Prompts())+len(o.llm2.Prompts()) != 0 {
+			t.Errorf("stream %v: workers called", stream)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// slowDB is a database whose flight queries take delay.
+type slowDB struct {
+	db.Client
+	delay atomic.Int64 // A time.Duration
+}
+
+func (c *slowDB) QueryFlights(ctx context.Context, q db.FlightQuery) ([]db.Flight, error) {
+	select {
+	case <-time.After(time.Duration(c.delay.Load())):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return c.Client.QueryFlights(ctx, q)
+}
+
+func TestStaleFlights(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "FL101.", "Two hours.", "FL101 it is.")
+		slow := &slowDB{Client: o.db}
+		cached := db.NewCachedClient(slow, 20*time.Millisecond)
+		cached.ServeStale(50*time.Millisecond, time.Minute)
+		o.dbClient = cached
+
+		events := process(t, o.Orchestrator, "Show me flights from Madrid to Paris", Options{}, stream)
+		if strings.Contains(statusTexts(events), "recent results") {
+			t.Fatalf("stream %v: stale status on a fresh search", stream)
+		}
+
+		// The cached search has expired and the database is slow: the answer goes on with it.
+		time.Sleep(30 * time.Millisecond)
+		slow.delay.Store(int64(time.Second))
+		start := time.Now()
+		events = process(t, o.Orchestrator, "Show me flights from Madrid to Paris", Options{}, stream)
+		if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+			t.Errorf("stream %v: the answer took %s", stream, elapsed)
+		}
+		if !strings.Contains(statusTexts(events), "The flight database is slow, so these are recent results from") {
+			t.Errorf("stream %v: statuses %q", stream, statusTexts(events))
+		}
+		if results := ofType(events, sse.TypeFlightResults); len(results) != 1 || answerOf(events) == "" {
+			t.Errorf("stream %v: %d flight results, answer %q", stream, len(results), answerOf(events))
+		}
+		if cached.Stats().Stale != 1 {
+			t.Errorf("stream %v: stats %+v", stream, cached.Stats())
+		}
+	}
+}
+
+// statusTexts returns the Status events' texts, one per line.
+func statusTexts(events []sse.Event) string {
+	var texts []string
+	for _, ev := range ofType(events, sse.TypeStatus) {
+		texts = append(texts, ev.Data)
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,122 @@
+package orchestrator
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/version"
+)
+
+// Telemetry summarizes how a request was served. It is sent to the client inside the Done event
+// so bug reports and dashboards can see what the pipeline did without access to server logs.
+type Telemetry struct {
+	RequestID   string  `json:"request_id,omitempty"` // Matches the X-Request-ID response header and server log lines
+	Intent      string  `json:"intent"`               // "flight", "routes", "compare", "local_time", "preferences", "repeat" or "general"
+	Language    string  `json:"language"`             // Detected language of the user's message
+	Origin      string  `json:"origin,omitempty"`
+	Destination string  `json:"destination,omitempty"`
+	MaxPrice    float64 `json:"max_price,omitempty"`  // Converted into the stored prices' currency
+	Currency    string  `json:"currency,omitempty"`   // Currency prices were shown in, if not the stored one
+	Passengers  int     `json:"passengers,omitempty"` // Party size the flights were searched for
+	ResultCount int     `json:"result_count"`         // Flights the search returned, or routes for the routes intent
+	DurationMs  int64   `json:"duration_ms"`
+	Version     string  `json:"version"`           // Server build, so client bug reports say which one answered
+	Replica     string  `json:"replica,omitempty"` // The server that answered, among replicas; see SetReplica
+
+	// OriginAirport and DestinationAirport are the IATA codes of the airports the message named
+	// by code, which the search was narrowed to.
+	OriginAirport      string `json:"origin_airport,omitempty"`
+	DestinationAirport string `json:"destination_airport,omitempty"`
+
+	// StagesMs is how long each pipeline stage took: intent, search (flight and routes intents),
+	// llm1, llm2, workers (both worker calls, which run concurrently), weather (the forecast
+	// lookup, alongside the workers; see SetWeather) and aggregation. Stages the request didn't
+	// reach are absent.
+	StagesMs map[string]int64 `json:"stages_ms,omitempty"`
+
+	// Models names the model each LLM stage the request reached ran on: llm1, llm2 and
+	// aggregation (see SetModels and SetRouter).
+	Models map[string]string `json:"models,omitempty"`
+
+	// TokensUsed is what the request's LLM calls used, as charged to its token budget. It is
+	// only counted when a budget is set (see SetTokenBudget).
+	TokensUsed int `json:"tokens_used,omitempty"`
+
+	// Truncated is set when the answer was cut short at the output limit (see SetOutputLimit).
+	Truncated bool `json:"truncated,omitempty"`
+
+	// LanguageRetry is set when LLM 3 answered in another language than the request's and was
+	// asked again (see EnableLanguageCheck): "fixed" if the retry's answer was sent, "failed" if
+	// the first answer was.
+	LanguageRetry string `json:"language_retry,omitempty"`
+
+	// Condensed says how each worker answer that was too long for the aggregation prompt was
+	// condensed, by stage (llm1, llm2): "extract" or "summary" (see SetCondensation).
+	Condensed map[string]string `json:"condensed,omitempty"`
+
+	// Flags are the feature flags that were on for the request (see Options.Flags).
+	Flags []string `json:"flags,omitempty"`
+
+	// PersonaOverrides names the stages the request gave its own system prompt for (see
+	// Options.PersonaOverrides); the prompts are in the query log.
+	PersonaOverrides []string `json:"persona_overrides,omitempty"`
+
+	// Preferences names the session preferences the request used (see Options.Preferences), or
+	// for a message asking to remember some, the ones it saved.
+	Preferences []string `json:"preferences,omitempty"`
+
+	// Grounding is how well a flight answer matched its flight records. It is only given to
+	// hooks, with the grounding check on (see EnableGroundingCheck): the check runs after the
+	// Done event.
+	Grounding *db.Grounding `json:"grounding,omitempty"`
+}
+
+// telemetryFrom builds the client-facing summary from the request's audit record.
+func telemetryFrom(entry *db.QueryLog) Telemetry {
+	return Telemetry{
+		Intent:      entry.Intent,
+		Language:    entry.DetectedLanguage,
+		Origin:      entry.Origin,
+		Destination: entry.Destination,
+		MaxPrice:    entry.MaxPrice,
+		Currency:    entry.Currency,
+		Passengers:  entry.Passengers,
+		ResultCount: entry.ResultCount,
+		DurationMs:  entry.DurationMs,
+		Truncated:   entry.Truncated,
+		Flags:       entry.Flags,
+		Preferences: entry.Preferences,
+		Models:      entry.Models,
+		Version:     version.Version,
+
+		LanguageRetry:    entry.LanguageRetry,
+		Condensed:        entry.Condensed,
+		PersonaOverrides: slices.Sorted(maps.Keys(entry.PersonaOverrides)),
+
+		OriginAirport:      entry.OriginAirport,
+		DestinationAirport: entry.DestinationAirport,
+	}
+}
+
+// TelemetryHook is called once per request, after the Done event, with the request's summary
+// and its outcome (sse.OutcomeOK or sse.OutcomeError). Hooks feed metrics and similar sinks;
+// they run on the request's goroutine, so they must be quick.
+type TelemetryHook func(t Telemetry, outcome string)
+
+// AddTelemetryHook registers a hook. It must be called before the orchestrator serves requests.
+func (o *Orchestrator) AddTelemetryHook(hook TelemetryHook) {
+	o.telemetryHooks = append(o.telemetryHooks, hook)
+}
+
+// HideTelemetry leaves the summary out of Done events, e.g. for deployments that don't want to
+// expose pipeline details to clients. Hooks still receive it.
+func (o *Orchestrator) HideTelemetry() {
+	o.hideTelemetry = true
+}
+
+// SetReplica names the server in the telemetry of every request, so an answer can be traced to
+// the replica that gave it. It must be called before the orchestrator serves requests.
+func (o *Orchestrator) SetReplica(id string) {
+	o.replica = id
+}
@@ -0,0 +1,210 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+	"github.com/Cris245/go-llm-chat/internal/timezone"
+)
+
+// Flight times are stored in UTC. They are shown in the local time of the airports too,
+// "2025-08-20 11:00 JST / 02:00 UTC", to the LLMs and in the answers written here, and
+// FlightResults events carry both. Durations are worked out from the UTC times, so they hold
+// across time zones and changes to daylight saving time.
+
+// defaultTimeZones is the table of timezone.Default.
+var defaultTimeZones = func() *timezone.Table {
+	zones, err := timezone.New(nil)
+	if err != nil {
+		panic(err) // The built-in zones and the tz database are part of the binary; they must load.
+	}
+	return zones
+}()
+
+// SetTimeZones replaces the time zones of the cities and airports, which by default are
+// timezone.Default. It must be called before the orchestrator serves requests.
+func (o *Orchestrator) SetTimeZones(zones *timezone.Table) {
+	o.timeZones = zones
+}
+
+// withLocalTimes returns flights with their times in the local time of their airports and
+// their durations filled in, as FlightResults events carry them. A time whose zone isn't known
+// is left in UTC only.
+func (o *Orchestrator) withLocalTimes(flights []db.Flight) []db.Flight {
+	local := make([]db.Flight, len(flights))
+	for i, f := range flights {
+		if loc, ok := o.timeZones.Location(f.Origin, f.OriginAirport); ok {
+			if t, err := time.Parse(time.RFC3339, f.DepartureTime); err == nil {
+				f.DepartureLocal, f.DepartureTimeZone = t.In(loc).Format(time.RFC3339), loc.String()
+			}
+		}
+		if loc, ok := o.timeZones.Location(f.Destination, f.DestinationAirport); ok {
+			if t, err := time.Parse(time.RFC3339, f.ArrivalTime); err == nil {
+				f.ArrivalLocal, f.ArrivalTimeZone = t.In(loc).Format(time.RFC3339), loc.String()
+			}
+		}
+		f.DurationMinutes = int(flightDuration(f).Round(time.Minute) / time.Minute)
+		local[i] = f
+	}
+	return local
+}
+
+// departureText and arrivalText write when f leaves and arrives, in local time and UTC (see
+// timezone.Format), or as the record gives it if it doesn't parse.
+func (o *Orchestrator) departureText(f db.Flight) string {
+	return o.timeText(f.DepartureTime, f.Origin, f.OriginAirport)
+}
+
+func (o *Orchestrator) arrivalText(f db.Flight) string {
+	return o.timeText(f.ArrivalTime, f.Destination, f.DestinationAirport)
+}
+
+func (o *Orchestrator) timeText(at, city, airport string) string {
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return at
+	}
+	loc, _ := o.timeZones.Location(city, airport)
+	return timezone.Format(t, loc)
+}
+
+// maxRememberedFlights bounds the flights kept as the session's last ones.
+const maxRememberedFlights = 10
+
+// rememberFlights records the numbers of flights, up to maxRememberedFlights, as those of the
+// session's last answer, for follow-ups that don't name them (see answerLocalTimes). A failure
+// to save is logged and otherwise ignored.
+func (o *Orchestrator) rememberFlights(ctx context.Context, prefs *db.Preferences, flights []db.Flight) {
+	if prefs == nil {
+		return
+	}
+	numbers := make([]string, 0, min(len(flights), maxRememberedFlights))
+	for _, f := range flights[:cap(numbers)] {
+		numbers = append(numbers, f.FlightNumber)
+	}
+	if slices.Equal(numbers, prefs.LastFlights) {
+		return
+	}
+	updated := *prefs
+	updated.LastFlights = numbers
+	updated.UpdatedAt = time.Now().UTC()
+	if err := o.dbClient.SavePreferences(ctx, updated); err != nil {
+		slog.WarnContext(ctx, "Failed to save the last flights", "session_id", prefs.SessionID, "error", err)
+	}
+}
+
+// localTimeQuestion is a question about when flights leave or arrive in local time: "what
+// time does FL118 land local time?", or "... does it ..." about the session's last flights.
+type localTimeQuestion struct {
+	numbers   []string // Flight numbers, in capitals; none asks about the last flights
+	departure bool     // Asks when they leave
+	arrival   bool     // Asks when they arrive
+}
+
+// Patterns of lowercased local time questions: they must ask about local time, and when
+// something happens, and may say whether the departure or the arrival.
+var (
+	localTimeCue = regexp.MustCompile(`\blocal(?:ly)?\b`)
+	timeAskCue   = regexp.MustCompile(`\bwhat time\b|\bwhen\b|\ba qu[eé] hora\b|\bcu[aá]ndo\b`)
+	arrivalCue   = regexp.MustCompile(`\b(?:land\w*|arriv\w*|gets? in|touch(?:es)? down|lleg\w*|aterriz\w*)\b`)
+	departureCue = regexp.MustCompile(`\b(?:depart\w*|leav\w*|takes? off|sale|salen|salida|despeg\w*|parte)\b`)
+)
+
+// detectLocalTimeQuestion reports whether the lowercased message asks when flights leave or
+// arrive in local time.
+func detectLocalTimeQuestion(lower string) (localTimeQuestion, bool) {
+	if !localTimeCue.MatchString(lower) {
+		return localTimeQuestion{}, false
+	}
+	q := localTimeQuestion{departure: departureCue.MatchString(lower), arrival: arrivalCue.MatchString(lower)}
+	if !q.departure && !q.arrival {
+		if !timeAskCue.MatchString(lower) {
+			return localTimeQuestion{}, false
+		}
+		q.departure, q.arrival = true, true
+	}
+	for _, number := range comparedFlight.FindAllString(lower, -1) {
+		if number = strings.ToUpper(number); !slices.Contains(q.numbers, number) {
+			q.numbers = append(q.numbers, number)
+		}
+	}
+	return q, true
+}
+
+// answerLocalTimes answers a local time question from the flight records, with the times
+// converted here: the flights it names, or else the session's last ones (prefs.LastFlights).
+// The flights are sent as a FlightResults event before the answer, and remembered as the
+// session's last ones.
+func (o *Orchestrator) answerLocalTimes(ctx context.Context, entry *db.QueryLog, q localTimeQuestion, lang string, prefs *db.Preferences, timings *stageTimings, failure *error, eventChan chan<- sse.Event) {
+	numbers := q.numbers
+	if len(numbers) == 0 && prefs != nil {
+		numbers = prefs.LastFlights
+	}
+	if len(numbers) == 0 {
+		o.sendAnswer(ctx, entry, lang, i18n.T(lang, "message.local_time.which"), eventChan)
+		return
+	}
+
+	start := time.Now()
+	var flights []db.Flight
+	var sentences []string
+	for _, number := range numbers {
+		flight, err := o.dbClient.GetFlight(ctx, number)
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			sentences = append(sentences, i18n.T(lang, "message.compare.no_flight", number))
+		case err != nil:
+			timings.since(stageSearch, start)
+			entry.Error = err.Error()
+			*failure = err
+			slog.WarnContext(ctx, "Flight lookup for local times failed", "error", err)
+			eventChan <- sse.Error("search_unavailable", i18n.T(lang, "error.search_unavailable"))
+			return
+		default:
+			flights = append(flights, flight)
+		}
+	}
+	timings.since(stageSearch, start)
+	entry.ResultCount = len(flights)
+	if len(flights) > 0 {
+		eventChan <- sse.FlightResults(o.withLocalTimes(flights))
+		o.rememberFlights(ctx, prefs, flights)
+	}
+
+	for _, f := range flights {
+		if q.departure {
+			sentences = append(sentences, o.localTimeSentence(lang, "departure", f.FlightNumber, f.DepartureTime, f.Origin, f.OriginAirport))
+		}
+		if q.arrival {
+			sentences = append(sentences, o.localTimeSentence(lang, "arrival", f.FlightNumber, f.ArrivalTime, f.Destination, f.DestinationAirport))
+		}
+	}
+	o.sendAnswer(ctx, entry, lang, strings.Join(sentences, " "), eventChan)
+}
+
+// localTimeSentence states when flight number leaves (which is "departure") or arrives (which
+// is "arrival") at the airport of city, in its local time and in UTC, or in UTC alone if its
+// time zone isn't known. Flight times are checked when flights are stored, so at parses.
+func (o *Orchestrator) localTimeSentence(lang, which, number, at, city, airport string) string {
+	place := db.PlaceName(city, airport)
+	t, _ := time.Parse(time.RFC3339, at)
+	utc := t.UTC()
+	loc, ok := o.timeZones.Location(city, airport)
+	if !ok {
+		return i18n.T(lang, "message.local_time."+which+".unknown", number, place, utc.Format(time.DateOnly), utc.Format("15:04 UTC"))
+	}
+	local := t.In(loc)
+	inUTC := utc.Format("15:04 UTC")
+	if utc.Format(time.DateOnly) != local.Format(time.DateOnly) {
+		inUTC = utc.Format("2006-01-02 15:04 UTC")
+	}
+	return i18n.T(lang, "message.local_time."+which, number, place, local.Format(time.DateOnly), local.Format("15:04"), local.Format("MST"), inUTC)
+}
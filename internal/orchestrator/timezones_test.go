@@ -0,0 +1,124 @@
+package orchestrator
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestWithLocalTimes(t *testing.T) {
+	o := newTestOrchestrator(t, "", "", "")
+	flights := []db.Flight{
+		seededFlight(t, o, "FL118"),
+		seededFlight(t, o, "FL119"),
+		// Madrid moves to summer time an hour into this flight: two hours by the clocks of
+		// the UTC times, three by the local ones.
+		{FlightNumber: "FL900", Origin: "Madrid", Destination: "Paris", DepartureTime: "2025-03-30T00:30:00Z", ArrivalTime: "2025-03-30T02:30:00Z"},
+		{FlightNumber: "FL901", Origin: "Atlantis", Destination: "Tokyo", DepartureTime: "2025-08-20T02:00:00Z", ArrivalTime: "2025-08-20T05:00:00Z"},
+	}
+	for i, want := range []db.Flight{
+		{DepartureLocal: "2025-08-20T11:00:00+09:00", DepartureTimeZone: "Asia/Tokyo", ArrivalLocal: "2025-08-20T05:00:00-07:00", ArrivalTimeZone: "America/Los_Angeles", DurationMinutes: 600},
+		{DepartureLocal: "2025-08-20T20:00:00-07:00", DepartureTimeZone: "America/Los_Angeles", ArrivalLocal: "2025-08-21T22:00:00+09:00", ArrivalTimeZone: "Asia/Tokyo", DurationMinutes: 600},
+		{DepartureLocal: "2025-03-30T01:30:00+01:00", DepartureTimeZone: "Europe/Madrid", ArrivalLocal: "2025-03-30T04:30:00+02:00", ArrivalTimeZone: "Europe/Paris", DurationMinutes: 120},
+		// A zone that isn't known is left out, but the duration is still worked out.
+		{ArrivalLocal: "2025-08-20T14:00:00+09:00", ArrivalTimeZone: "Asia/Tokyo", DurationMinutes: 180},
+	} {
+		got := o.withLocalTimes(flights)[i]
+		if got.DepartureLocal != want.DepartureLocal || got.DepartureTimeZone != want.DepartureTimeZone ||
+			got.ArrivalLocal != want.ArrivalLocal || got.ArrivalTimeZone != want.ArrivalTimeZone || got.DurationMinutes != want.DurationMinutes {
+			t.Errorf("%s: local times %+v", flights[i].FlightNumber, got)
+		}
+		if got.DepartureTime != flights[i].DepartureTime || got.ArrivalTime != flights[i].ArrivalTime {
+			t.Errorf("%s: UTC times changed to %s, %s", flights[i].FlightNumber, got.DepartureTime, got.ArrivalTime)
+		}
+	}
+	if flights[0].DepartureLocal != "" {
+		t.Errorf("withLocalTimes changed the flights it was given")
+	}
+}
+
+func TestDetectLocalTimeQuestion(t *testing.T) {
+	for _, tt := range []struct {
+		message            string
+		ok                 bool
+		numbers            []string
+		departure, arrival bool
+	}{
+		{"What time does FL118 land local time?", true, []string{"FL118"}, false, true},
+		{"when does fl119 depart, local time?", true, []string{"FL119"}, true, false},
+		{"What time does it land local time?", true, nil, false, true},
+		{"When are FL118 and FL119 local time?", true, []string{"FL118", "FL119"}, true, true},
+		{"¿A qué hora aterriza el FL118 en hora local?", true, []string{"FL118"}, false, true},
+		{"¿Cuándo sale, hora local?", true, nil, true, false},
+		// Without asking about local time, or when, it isn't one.
+		{"What time does FL118 land?", false, nil, false, false},
+		{"Tell me about local food in Tokyo", false, nil, false, false},
+	} {
+		q, ok := detectLocalTimeQuestion(strings.ToLower(tt.message))
+		if ok != tt.ok || !slices.Equal(q.numbers, tt.numbers) || q.departure != tt.departure || q.arrival != tt.arrival {
+			t.Errorf("%q = %+v, %v", tt.message, q, ok)
+		}
+	}
+}
+
+func TestLocalTimeAnswer(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+		events := process(t, o.Orchestrator, "What time does FL118 land local time?", Options{}, stream)
+		if answer := answerOf(events); answer != "FL118 lands in Los Angeles (LAX) on 2025-08-20 at 05:00 local time (PDT), 12:00 UTC." {
+			t.Errorf("stream %v: answer %q", stream, answer)
+		}
+		if len(o.llm1.Prompts())+len(o.llm2.Prompts())+len(o.llm3.Prompts()) != 0 {
+			t.Errorf("stream %v: LLMs called for a local time", stream)
+		}
+		results := ofType(events, sse.TypeFlightResults)
+		if len(results) != 1 || results[0].Payload.([]db.Flight)[0].ArrivalLocal != "2025-08-20T05:00:00-07:00" {
+			t.Errorf("stream %v: flight results %+v", stream, results)
+		}
+
+		// The departure of FL119 is on the day before, local time, so UTC gets its date.
+		events = process(t, o.Orchestrator, "When does FL119 depart local time?", Options{}, stream)
+		if answer := answerOf(events); answer != "FL119 departs Los Angeles (LAX) on 2025-08-20 at 20:00 local time (PDT), 2025-08-21 03:00 UTC." {
+			t.Errorf("stream %v: answer %q", stream, answer)
+		}
+		events = process(t, o.Orchestrator, "What time does FL999 land local time?", Options{}, stream)
+		if answer := answerOf(events); !strings.Contains(answer, "FL999") || len(ofType(events, sse.TypeFlightResults)) != 0 {
+			t.Errorf("stream %v: answer about a missing flight %q", stream, answer)
+		}
+	}
+}
+
+func TestLocalTimeFollowUp(t *testing.T) {
+	o := newTestOrchestrator(t, "FL118 it is.", "FL118 it is.", "FL118 it is.")
+	prefs := &db.Preferences{SessionID: "session-local"}
+
+	// Without flights asked about before, the answer asks which.
+	events := process(t, o.Orchestrator, "What time does it land local time?", Options{SessionID: "session-local", Preferences: prefs}, true)
+	if answer := answerOf(events); !strings.HasPrefix(answer, "Which flight do you mean?") {
+		t.Errorf("answer without last flights %q", answer)
+	}
+
+	// The flights a search found are remembered, and the follow-up is about them.
+	process(t, o.Orchestrator, "Flights from Tokyo to Los Angeles", Options{SessionID: "session-local", Preferences: prefs}, true)
+	saved := savedPreferences(t, o, "session-local")
+	if !slices.Equal(saved.LastFlights, []string{"FL118"}) {
+		t.Fatalf("last flights %v", saved.LastFlights)
+	}
+	events = process(t, o.Orchestrator, "What time does it leave, local time?", Options{SessionID: "session-local", Preferences: saved}, true)
+	if answer := answerOf(events); answer != "FL118 departs Tokyo (HND) on 2025-08-20 at 11:00 local time (JST), 02:00 UTC." {
+		t.Errorf("follow-up answer %q", answer)
+	}
+}
+
+func TestLocalTimesInPrompt(t *testing.T) {
+	// The LLMs are given the times in local time and UTC, and the duration.
+	o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+	process(t, o.Orchestrator, "Flights from Tokyo to Los Angeles", Options{}, true)
+	want := "Flight FL118: Tokyo (HND) -> Los Angeles (LAX), departure 2025-08-20 11:00 JST / 02:00 UTC, arrival 2025-08-20 05:00 PDT / 12:00 UTC, duration 10h 00m,"
+	if prompt := o.llm1.Prompts()[0]; !strings.Contains(prompt, want) {
+		t.Errorf("LLM 1 prompt doesn't contain %q:\n%s", want, prompt)
+	}
+}
@@ -0,0 +1,46 @@
+package orchestrator
+
+import (
+	"context"
+
+	"github.com/Cris245/go-llm-chat/internal/currency"
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// sendUnderstanding tells the client what was read from the flight question in entry, once
+// currency and preferences have filled it in, so the user can see a misreading before the
+// answer: a QueryUnderstanding event built from entry, as the query log records it. confident
+// is whether the route's direction was clear (see directionConfident); it is recorded in
+// entry.Confidence. The price limit is shown in the currency the answer uses.
+func (o *Orchestrator) sendUnderstanding(ctx context.Context, entry *db.QueryLog, lang string, confident bool, eventChan chan<- sse.Event) {
+	entry.Confidence = sse.ConfidenceHigh
+	if !confident {
+		entry.Confidence = sse.ConfidenceLow
+	}
+	shown, maxPrice := o.currency.Base(), entry.MaxPrice
+	if entry.Currency != "" {
+		shown = entry.Currency
+		if maxPrice > 0 {
+			// applyCurrency converted the limit from this currency, so it converts back.
+			converted, err := o.currency.Convert(ctx, maxPrice, o.currency.Base(), shown)
+			if err == nil {
+				maxPrice = currency.Round(converted, shown)
+			} else {
+				shown, maxPrice = o.currency.Base(), entry.MaxPrice
+			}
+		}
+	}
+	eventChan <- sse.QueryUnderstanding(sse.QueryUnderstandingPayload{
+		Intent:             entry.Intent,
+		Origin:             entry.Origin,
+		Destination:        entry.Destination,
+		OriginAirport:      entry.OriginAirport,
+		DestinationAirport: entry.DestinationAirport,
+		MaxPrice:           maxPrice,
+		Currency:           shown,
+		Passengers:         entry.Passengers,
+		Language:           lang,
+		Confidence:         entry.Confidence,
+	})
+}
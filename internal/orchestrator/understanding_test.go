@@ -0,0 +1,72 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Cris245/go-llm-chat/internal/logging"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+func TestQueryUnderstanding(t *testing.T) {
+	for _, tt := range []struct {
+		message string
+		want    sse.QueryUnderstandingPayload
+	}{
+		{"Flights from Madrid to Paris under 200", sse.QueryUnderstandingPayload{
+			Intent: "flight", Origin: "Madrid", Destination: "Paris", MaxPrice: 200, Currency: "USD", Language: "en", Confidence: sse.ConfidenceHigh}},
+		{"Flights from Madrid to Paris under 200 EUR", sse.QueryUnderstandingPayload{
+			Intent: "flight", Origin: "Madrid", Destination: "Paris", MaxPrice: 200, Currency: "EUR", Language: "en", Confidence: sse.ConfidenceHigh}},
+		{"vuelos desde Madrid a París por menos de 150 euros", sse.QueryUnderstandingPayload{
+			Intent: "flight", Origin: "Madrid", Destination: "Paris", MaxPrice: 150, Currency: "EUR", Language: "es", Confidence: sse.ConfidenceHigh}},
+		{"Flights from JFK to Tokyo for 3 people", sse.QueryUnderstandingPayload{
+			Intent: "flight", Origin: "New York", Destination: "Tokyo", OriginAirport: "JFK", Currency: "USD", Passengers: 3, Language: "en", Confidence: sse.ConfidenceHigh}},
+		// Partly understood: a destination only, an origin only, or cities without a direction.
+		{"Flights to Paris", sse.QueryUnderstandingPayload{
+			Intent: "flight", Destination: "Paris", Currency: "USD", Language: "en", Confidence: sse.ConfidenceHigh}},
+		{"vuelos desde Barcelona", sse.QueryUnderstandingPayload{
+			Intent: "flight", Origin: "Barcelona", Currency: "USD", Language: "es", Confidence: sse.ConfidenceHigh}},
+		{"Flights from Rome Paris", sse.QueryUnderstandingPayload{
+			Intent: "flight", Origin: "Rome", Destination: "Paris", Currency: "USD", Language: "en", Confidence: sse.ConfidenceLow}},
+		{"vuelos baratos", sse.QueryUnderstandingPayload{
+			Intent: "flight", Currency: "USD", Language: "es", Confidence: sse.ConfidenceHigh}},
+	} {
+		for _, stream := range []bool{false, true} {
+			o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+			o.EnableQueryLog(nil)
+			ctx := logging.WithRequestID(context.Background(), "req-understood")
+			events := make(chan sse.Event, 1024)
+			if stream {
+				o.ProcessMessageStream(ctx, tt.message, Options{}, events)
+			} else {
+				o.ProcessMessage(ctx, tt.message, Options{}, events)
+			}
+			got := drain(events)
+
+			// One event, before the flights are searched, with what was read.
+			understood := ofType(got, sse.TypeQueryUnderstanding)
+			if len(understood) != 1 || understood[0].Payload.(sse.QueryUnderstandingPayload) != tt.want {
+				t.Errorf("stream %v: %q understood as %+v, want %+v", stream, tt.message, understood, tt.want)
+				continue
+			}
+			for _, ev := range got {
+				if ev.Type == sse.TypeFlightResults || ev.Type == sse.TypeMessage {
+					t.Errorf("stream %v: %q: %s before QueryUnderstanding", stream, tt.message, ev.Type)
+				}
+				if ev.Type == sse.TypeQueryUnderstanding {
+					break
+				}
+			}
+			// The query log records the same.
+			if entry := queryLogOf(t, o, "req-understood"); entry.Confidence != tt.want.Confidence || entry.Origin != tt.want.Origin || entry.Destination != tt.want.Destination {
+				t.Errorf("stream %v: %q logged as %+v", stream, tt.message, entry)
+			}
+		}
+	}
+
+	// Other questions have none.
+	o := newTestOrchestrator(t, "LLM 1.", "LLM 2.", "LLM 3.")
+	if understood := ofType(process(t, o.Orchestrator, "What is the capital of France?", Options{}, true), sse.TypeQueryUnderstanding); len(understood) != 0 {
+		t.Errorf("general question understood as %+v", understood)
+	}
+}
@@ -0,0 +1,67 @@
+package orchestrator
+
+import (
+	"context"
+
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+)
+
+// variationHints ask for a different answer when a request regenerates an earlier one
+// (Options.Regenerate). The LLM interface takes no sampling parameters, so the variation is
+// requested in the prompt rather than through a higher temperature.
+var variationHints = map[string]string{
+	LanguageEnglish: "\n\nThe user asked for a different answer to this question. Answer again with alternative phrasing and structure, keeping every fact accurate.",
+	LanguageSpanish: "\n\nEl usuario ha pedido una respuesta diferente a esta pregunta. Responde de nuevo con otra redacción y estructura, manteniendo todos los datos exactos.",
+}
+
+// variedClient appends a variation hint to every prompt.
+type variedClient struct {
+	next llmclient.LLMClient
+	hint string
+}
+
+func (c variedClient) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	return c.next.ChatCompletion(ctx, prompt+c.hint)
+}
+
+func (c variedClient) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	return c.next.StreamChatCompletion(ctx, prompt+c.hint)
+}
+
+// forRequest returns the orchestrator to run one request with: o itself, or a copy whose LLM
+// clients give the persona's system prompt in language (see SetPersona), or the request's own
+// for the stages it overrides (Options.PersonaOverrides), and, for a regeneration, ask for a
+// different answer.
+func (o *Orchestrator) forRequest(opts Options, language string) *Orchestrator {
+	var systemPrompt string
+	if o.persona != nil {
+		systemPrompt = o.persona.Prompt(languageCodes[language])
+	}
+	if systemPrompt == "" && len(opts.PersonaOverrides) == 0 && !opts.Regenerate {
+		return o
+	}
+	prompts := map[string]string{stageLLM1: systemPrompt, stageLLM2: systemPrompt, stageAggregation: systemPrompt}
+	for stage, prompt := range opts.PersonaOverrides {
+		prompts[overrideStages[stage]] = prompt
+	}
+	hint, ok := variationHints[language]
+	if !ok {
+		hint = variationHints[LanguageEnglish]
+	}
+	wrap := func(stage string, client llmclient.LLMClient) llmclient.LLMClient {
+		if opts.Regenerate {
+			client = variedClient{next: client, hint: hint}
+		}
+		if prompt := prompts[stage]; prompt != "" {
+			client = systemPromptClient{next: client, prompt: prompt}
+		}
+		return client
+	}
+	req := *o
+	req.systemPrompts = prompts
+	req.wrapClient = wrap
+	req.llm1Client = wrap(stageLLM1, o.llm1Client)
+	req.llm2Client = wrap(stageLLM2, o.llm2Client)
+	req.llm3Client = wrap(stageAggregation, o.llm3Client)
+	return &req
+}
@@ -0,0 +1,173 @@
+// Package persona holds the deployment's system prompt: the instructions that brand the
+// assistant ("You are FlightBuddy for Acme Travel") and set its rules, given to every LLM call
+// of the pipelines ahead of the task's own prompt.
+//
+// The prompt has a default and optional per-language variants, and is a text/template with
+// the bot's name and company as variables. It can be set in the configuration or kept in a
+// file, which Reload reads again so it can be changed without a restart.
+package persona
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultKey is the key of the default prompt in a prompt file.
+const DefaultKey = "default"
+
+// Config describes the persona. Prompts given inline come first; the file's, if any, replace
+// them language by language.
+type Config struct {
+	BotName string
+	Company string
+
+	Prompt  string            // Default prompt template
+	Prompts map[string]string // Per-language templates, by language code (e.g. "es")
+
+	// File is a YAML file mapping language codes, and DefaultKey, to prompt templates.
+	File string
+}
+
+// Vars are the variables prompt templates can use, e.g. "You are {{.BotName}} for {{.Company}}".
+type Vars struct {
+	BotName string
+	Company string
+}
+
+// prompts are rendered prompts.
+type prompts struct {
+	fallback   string
+	byLanguage map[string]string
+}
+
+// Persona renders and serves the system prompt. It is safe for concurrent use.
+type Persona struct {
+	cfg     Config
+	current atomic.Pointer[prompts]
+}
+
+// New renders the prompts cfg describes, reading its file if set. It fails if the file can't
+// be read or a template doesn't render.
+func New(cfg Config) (*Persona, error) {
+	p := &Persona{cfg: cfg}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload renders the prompts again, reading the file anew. If it fails, the prompts in use
+// are kept.
+func (p *Persona) Reload() error {
+	staged, err := p.Stage()
+	if err != nil {
+		return err
+	}
+	staged.Commit()
+	return nil
+}
+
+// Staged is a reload of the prompts that rendered but isn't in use yet; see Stage.
+type Staged struct {
+	p    *Persona
+	next *prompts
+}
+
+// Stage renders the prompts again, reading the file anew, without putting them in use, so
+// they can be swapped in together with other reloaded settings. It fails, naming the prompt,
+// if the file can't be read or a template doesn't render.
+func (p *Persona) Stage() (*Staged, error) {
+	templates := map[string]string{DefaultKey: p.cfg.Prompt}
+	for language, prompt := range p.cfg.Prompts {
+		templates[language] = prompt
+	}
+	if p.cfg.File != "" {
+		data, err := os.ReadFile(p.cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("read persona file: %w", err)
+		}
+		var fromFile map[string]string
+		if err := yaml.Unmarshal(data, &fromFile); err != nil {
+			return nil, fmt.Errorf("parse persona file %s: %w", p.cfg.File, err)
+		}
+		for language, prompt := range fromFile {
+			templates[language] = prompt
+		}
+	}
+
+	vars := Vars{BotName: p.cfg.BotName, Company: p.cfg.Company}
+	rendered := &prompts{byLanguage: make(map[string]string, len(templates))}
+	for language, text := range templates {
+		prompt, err := render(language, text, vars)
+		if err != nil {
+			return nil, err
+		}
+		if language == DefaultKey {
+			rendered.fallback = prompt
+		} else {
+			rendered.byLanguage[language] = prompt
+		}
+	}
+	return &Staged{p: p, next: rendered}, nil
+}
+
+// Changed returns the languages, and DefaultKey, whose prompts differ from the ones in use,
+// sorted.
+func (s *Staged) Changed() []string {
+	current := s.p.current.Load()
+	if current == nil {
+		current = &prompts{}
+	}
+	var changed []string
+	if current.fallback != s.next.fallback {
+		changed = append(changed, DefaultKey)
+	}
+	for language, prompt := range s.next.byLanguage {
+		if old, ok := current.byLanguage[language]; !ok || old != prompt {
+			changed = append(changed, language)
+		}
+	}
+	for language := range current.byLanguage {
+		if _, ok := s.next.byLanguage[language]; !ok {
+			changed = append(changed, language)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// Commit puts the staged prompts in use. Requests already running keep the prompts they
+// started with.
+func (s *Staged) Commit() {
+	s.p.current.Store(s.next)
+}
+
+// render executes one prompt template.
+func render(language, text string, vars Vars) (string, error) {
+	tmpl, err := template.New(language).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("persona prompt %q: %w", language, err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("persona prompt %q: %w", language, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// Prompt returns the system prompt for language (a code such as "es"): its variant, or the
+// default. It is empty when neither is set.
+func (p *Persona) Prompt(language string) string {
+	current := p.current.Load()
+	if prompt, ok := current.byLanguage[language]; ok {
+		return prompt
+	}
+	return current.fallback
+}
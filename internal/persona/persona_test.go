@@ -0,0 +1,97 @@
+package persona
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPrompt(t *testing.T) {
+	p, err := New(Config{
+		BotName: "FlightBuddy",
+		Company: "Acme Travel",
+		Prompt:  "  You are {{.BotName}} for {{.Company}}. Never discuss competitors.\n",
+		Prompts: map[string]string{"es": "Eres {{.BotName}} de {{.Company}}."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for language, want := range map[string]string{
+		"en": "You are FlightBuddy for Acme Travel. Never discuss competitors.",
+		"fr": "You are FlightBuddy for Acme Travel. Never discuss competitors.",
+		"es": "Eres FlightBuddy de Acme Travel.",
+	} {
+		if got := p.Prompt(language); got != want {
+			t.Errorf("Prompt(%q) = %q, want %q", language, got, want)
+		}
+	}
+
+	empty, err := New(Config{})
+	if err != nil || empty.Prompt("en") != "" {
+		t.Errorf("empty persona: %q, %v", empty.Prompt("en"), err)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	for _, cfg := range []Config{
+		{Prompt: "You are {{.BotName"},
+		{Prompt: "You are {{.Name}}."},
+		{Prompts: map[string]string{"es": "Eres {{.Nombre}}."}},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v) accepted a bad template", cfg)
+		}
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "persona.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("default: You are {{.BotName}}.\nes: Eres {{.BotName}}.\n")
+	p, err := New(Config{BotName: "FlightBuddy", Prompt: "Inline.", Prompts: map[string]string{"de": "Inline auf Deutsch."}, File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file's prompts replace the inline ones language by language.
+	if p.Prompt("en") != "You are FlightBuddy." || p.Prompt("es") != "Eres FlightBuddy." || p.Prompt("de") != "Inline auf Deutsch." {
+		t.Errorf("prompts %q, %q and %q", p.Prompt("en"), p.Prompt("es"), p.Prompt("de"))
+	}
+
+	// A staged reload reports what changed and applies only once committed.
+	write("default: You are {{.BotName}}, at your service.\nes: Eres {{.BotName}}.\nfr: Vous êtes {{.BotName}}.\n")
+	staged, err := p.Stage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed := staged.Changed(); !slices.Equal(changed, []string{DefaultKey, "fr"}) {
+		t.Errorf("changed %v", changed)
+	}
+	if p.Prompt("fr") != "You are FlightBuddy." {
+		t.Errorf("staged prompt in use: %q", p.Prompt("fr"))
+	}
+	staged.Commit()
+	if p.Prompt("fr") != "Vous êtes FlightBuddy." || p.Prompt("en") != "You are FlightBuddy, at your service." {
+		t.Errorf("after commit: %q and %q", p.Prompt("fr"), p.Prompt("en"))
+	}
+
+	// A reload that fails keeps the prompts in use.
+	for _, content := range []string{"default: [unclosed", "default: You are {{.Nobody}}."} {
+		write(content)
+		if err := p.Reload(); err == nil {
+			t.Errorf("reload of %q succeeded", content)
+		}
+		if p.Prompt("en") != "You are FlightBuddy, at your service." {
+			t.Errorf("prompt after a failed reload: %q", p.Prompt("en"))
+		}
+	}
+	os.Remove(file)
+	if err := p.Reload(); err == nil {
+		t.Error("reload of a missing file succeeded")
+	}
+}
@@ -0,0 +1,189 @@
+// Package pii masks personal data in user messages — card numbers, IBANs, email addresses and
+// phone numbers — so it reaches neither the LLM providers nor the stored records. Each match is
+// replaced with a typed placeholder such as "[CARD]". The patterns are checked beyond their
+// shape where the data allows it (the Luhn check of card numbers, the checksum of IBANs), and
+// guarded against what flight questions normally hold: flight numbers ("FL101"), prices
+// ("$550.00"), dates and times are left alone.
+package pii
+
+import (
+	"math/big"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Kinds of personal data, as returned by Mask.
+const (
+	KindCard  = "card"
+	KindIBAN  = "iban"
+	KindEmail = "email"
+	KindPhone = "phone"
+)
+
+// detector finds one kind of personal data.
+type detector struct {
+	kind    string
+	pattern *regexp.Regexp
+	valid   func(match string) bool // Beyond the pattern; nil accepts every match
+}
+
+// detectors run in order, each on the text the ones before it left: IBANs before cards, since
+// an IBAN's digits can pass the Luhn check, and cards before phones, whose pattern also matches
+// long digit runs.
+var detectors = []detector{
+	{KindEmail, regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`), nil},
+	// Grouped IBANs must be in capitals, so the pattern can't run into the next word.
+	{KindIBAN, regexp.MustCompile(`[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?|(?i:[a-z]{2}\d{2}[a-z0-9]{11,30})`), validIBAN},
+	{KindCard, regexp.MustCompile(`\d(?:[ -]?\d){12,18}`), validCard},
+	{KindPhone, regexp.MustCompile(`\+?(?:\(\+?\d{1,4}\)[ .-]?)?\d[\d .-]{6,18}\d`), validPhone},
+}
+
+// Mask replaces the personal data in text with placeholders of its kind ("[CARD]", "[IBAN]",
+// "[EMAIL]", "[PHONE]"). It returns the masked text and the kinds it found, in the order of
+// the detectors, or nil if there were none.
+func Mask(text string) (masked string, kinds []string) {
+	masked = text
+	for _, d := range detectors {
+		var found bool
+		masked, found = d.replace(masked)
+		if found {
+			kinds = append(kinds, d.kind)
+		}
+	}
+	return masked, kinds
+}
+
+// replace masks the matches of d in text that stand alone, i.e. aren't part of a longer word
+// or number such as a flight number, and are valid.
+func (d detector) replace(text string) (string, bool) {
+	var b strings.Builder
+	last, found := 0, false
+	for _, loc := range d.pattern.FindAllStringIndex(text, -1) {
+		start, end := loc[0], loc[1]
+		if !standsAlone(text, start, end) || (d.valid != nil && !d.valid(text[start:end])) {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString("[" + strings.ToUpper(d.kind) + "]")
+		last, found = end, true
+	}
+	if !found {
+		return text, false
+	}
+	b.WriteString(text[last:])
+	return b.String(), true
+}
+
+// standsAlone reports whether text[start:end] isn't joined to a letter or digit on either side.
+func standsAlone(text string, start, end int) bool {
+	wordChar := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	if start > 0 {
+		if r := lastRune(text[:start]); wordChar(r) {
+			return false
+		}
+	}
+	if end < len(text) {
+		if r := []rune(text[end:])[0]; wordChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func lastRune(s string) rune {
+	r := []rune(s)
+	return r[len(r)-1]
+}
+
+// digits returns the digits of s.
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// validCard reports whether the digits of s, 13 to 19 of them, pass the Luhn check.
+func validCard(s string) bool {
+	d := digits(s)
+	if len(d) < 13 || len(d) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range len(d) {
+		n := int(d[len(d)-1-i] - '0')
+		if i%2 == 1 {
+			if n *= 2; n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+	}
+	return sum%10 == 0
+}
+
+// validIBAN reports whether s, without its spaces, is 15 to 34 characters long and passes the
+// ISO 13616 checksum (mod 97).
+func validIBAN(s string) bool {
+	iban := strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	var numeric strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		switch {
+		case r >= '0' && r <= '9':
+			numeric.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			numeric.WriteString(big.NewInt(int64(r - 'A' + 10)).String())
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(numeric.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// datePattern matches the start of an ISO date, which the phone pattern would otherwise take.
+var datePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}`)
+
+// decimalPattern matches an amount with decimals, such as a price without its currency.
+var decimalPattern = regexp.MustCompile(`^\d[\d ]*\.\d{1,2}$`)
+
+// validPhone reports whether s has the 9 to 15 digits of a phone number (E.164 allows 15),
+// and isn't a date or an amount. A number without a country code ("+34", "(+44)") must also
+// be grouped the way phone numbers are.
+func validPhone(s string) bool {
+	n := len(digits(s))
+	if n < 9 || n > 15 || datePattern.MatchString(s) || decimalPattern.MatchString(s) {
+		return false
+	}
+	return strings.HasPrefix(s, "+") || strings.HasPrefix(s, "(") || phoneGrouping(s)
+}
+
+// phoneGrouping reports whether the digit groups of s look like a national phone number: one
+// unbroken run ("612345678"), or up to five groups of 2 to 4 digits ("612 345 678",
+// "91 123 45 67", "06 12 34 56 78"). More than three groups of 3 digits are a list of
+// numbers or an amount ("100 200 300 400 500"), not a phone.
+func phoneGrouping(s string) bool {
+	groups := strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == '.' || r == '-' })
+	if len(groups) == 1 {
+		return true
+	}
+	if len(groups) > 5 {
+		return false
+	}
+	threes := 0
+	for _, g := range groups {
+		if len(g) < 2 || len(g) > 4 {
+			return false
+		}
+		if len(g) == 3 {
+			threes++
+		}
+	}
+	return threes < 4
+}
@@ -0,0 +1,91 @@
+package pii
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMask(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+		kinds    []string
+	}{
+		// Each kind, in the shapes people type them.
+		{"book it, my card is 4111 1111 1111 1111", "book it, my card is [CARD]", []string{KindCard}},
+		{"card 4111-1111-1111-1111 please", "card [CARD] please", []string{KindCard}},
+		{"4111111111111111", "[CARD]", []string{KindCard}},
+		{"pay to ES91 2100 0418 4502 0005 1332", "pay to [IBAN]", []string{KindIBAN}},
+		{"iban gb82west12345698765432.", "iban [IBAN].", []string{KindIBAN}},
+		{"write to ana.garcia+trips@example.co.uk", "write to [EMAIL]", []string{KindEmail}},
+		{"call me on +34 612 345 678", "call me on [PHONE]", []string{KindPhone}},
+		{"mi móvil es 612345678", "mi móvil es [PHONE]", []string{KindPhone}},
+		{"(+44) 20 7946 0958 after six", "[PHONE] after six", []string{KindPhone}},
+		{"llámame al 91 123 45 67", "llámame al [PHONE]", []string{KindPhone}},
+		{"appelez le 06 12 34 56 78", "appelez le [PHONE]", []string{KindPhone}},
+		{"+1 100 200 300 400", "[PHONE]", []string{KindPhone}},
+		{"ana@example.com, 4111 1111 1111 1111, +34 612 345 678",
+			"[EMAIL], [CARD], [PHONE]", []string{KindEmail, KindCard, KindPhone}},
+	} {
+		got, kinds := Mask(tt.in)
+		if got != tt.want || !slices.Equal(kinds, tt.kinds) {
+			t.Errorf("Mask(%q) = %q, %v, want %q, %v", tt.in, got, kinds, tt.want, tt.kinds)
+		}
+	}
+}
+
+func TestMaskLeavesFlightQuestions(t *testing.T) {
+	for _, in := range []string{
+		"Is FL101 or FL102 cheaper?",
+		"Show me flights from Madrid to Paris under $550.00",
+		"Vuelos de Madrid a París por menos de 1 250,00 €",
+		"Flights on 2026-03-01 at 09:30",
+		"Departing 2026-03-01T08:00:00Z",
+		"Booking reference ABC123, seat 14C",
+		"I paid 1234.56 for 2 tickets",
+		// The shape without the checks: a 16-digit number failing Luhn, an IBAN failing mod 97.
+		"order 4111 1111 1111 1112",
+		"ES91 2100 0418 4502 0005 1333",
+		// Too few digits for a phone.
+		"gate 12 34 56",
+		// Digits grouped the way phone numbers aren't.
+		"100 200 300 400 500",
+		"budget 1 250 000 000",
+		"codes 12.34.56.78.90.12",
+		// Part of a longer word or number.
+		"ref XX4111111111111111",
+	} {
+		if got, kinds := Mask(in); got != in || kinds != nil {
+			t.Errorf("Mask(%q) = %q, %v", in, got, kinds)
+		}
+	}
+}
+
+func TestValidCard(t *testing.T) {
+	for s, want := range map[string]bool{
+		"4111111111111111":    true,
+		"5500 0000 0000 0004": true,
+		"378282246310005":     true, // 15 digits
+		"4111111111111112":    false,
+		"411111111111":        false, // 12 digits
+		"4111111111111111111": false, // 19 digits failing Luhn
+	} {
+		if got := validCard(s); got != want {
+			t.Errorf("validCard(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestValidIBAN(t *testing.T) {
+	for s, want := range map[string]bool{
+		"ES9121000418450200051332":     true,
+		"GB82 WEST 1234 5698 7654 32":  true,
+		"de89370400440532013000":       true,
+		"ES9121000418450200051333":     false,
+		"ES91 2100":                    false, // Too short
+		"ES91-2100-0418-4502-0005-133": false,
+	} {
+		if got := validIBAN(s); got != want {
+			t.Errorf("validIBAN(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Package ratelimit limits how much of the LLM pipeline a single client can use:
+// a token bucket bounds its request rate and a per-client cap bounds its concurrent streams.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// idleTTL is how long an idle client's state is kept; idle clients have a full bucket
+// and no streams, so forgetting them changes nothing.
+const idleTTL = 10 * time.Minute
+
+// ErrTooManyStreams is returned by Acquire when the client is at its stream cap and
+// queueing is disabled or its queue is full.
+var ErrTooManyStreams = errors.New("too many concurrent streams")
+
+// Config sets the limits applied to each client. Zero values disable the corresponding limit.
+type Config struct {
+	RPS           float64 // Sustained requests per second
+	Burst         int     // Requests allowed at once on top of the sustained rate
+	MaxConcurrent int     // Streams a client may have running at the same time
+	Queue         bool    // Wait for a free stream slot instead of rejecting
+	MaxQueue      int     // Requests a client may have waiting when Queue is set
+}
+
+// Limiter tracks the limits of every client. It is safe for concurrent use.
+type Limiter struct {
+	cfg Config
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+// client is the state of one API key or IP address.
+type client struct {
+	tokens   float64
+	last     time.Time // Last bucket refill
+	active   int       // Streams holding a slot
+	waiters  []chan struct{}
+	lastSeen time.Time
+}
+
+// New creates a Limiter with the given limits.
+func New(cfg Config) *Limiter {
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	return &Limiter{cfg: cfg, clients: make(map[string]*client), lastSweep: time.Now()}
+}
+
+// get returns the state for key, creating it with a full bucket; the caller must hold l.mu.
+func (l *Limiter) get(key string, now time.Time) *client {
+	if now.Sub(l.lastSweep) > idleTTL {
+		l.sweep(now)
+	}
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{tokens: float64(l.cfg.Burst), last: now}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+	return c
+}
+
+// sweep forgets clients that have been idle for idleTTL; the caller must hold l.mu.
+func (l *Limiter) sweep(now time.Time) {
+	for key, c := range l.clients {
+		if c.active == 0 && len(c.waiters) == 0 && now.Sub(c.lastSeen) > idleTTL {
+			delete(l.clients, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Allow takes one request from key's token bucket. When the bucket is empty it returns
+// false and how long until a request would be allowed.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.cfg.RPS <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	c := l.get(key, now)
+	c.tokens = math.Min(float64(l.cfg.Burst), c.tokens+now.Sub(c.last).Seconds()*l.cfg.RPS)
+	c.last = now
+	if c.tokens < 1 {
+		wait := time.Duration((1 - c.tokens) / l.cfg.RPS * float64(time.Second))
+		return false, wait
+	}
+	c.tokens--
+	return true, 0
+}
+
+// TryAcquire takes a stream slot for key without waiting. It returns ErrTooManyStreams
+// when the client is at its cap and queueing is off or its queue is full, and otherwise
+// reports whether a slot was taken (false means the caller should Acquire and wait).
+func (l *Limiter) TryAcquire(key string) (bool, error) {
+	if l.cfg.MaxConcurrent <= 0 {
+		return true, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.get(key, time.Now())
+	if c.active < l.cfg.MaxConcurrent && len(c.waiters) == 0 {
+		c.active++
+		return true, nil
+	}
+	if !l.cfg.Queue || len(c.waiters) >= l.cfg.MaxQueue {
+		return false, ErrTooManyStreams
+	}
+	return false, nil
+}
+
+// Acquire waits for a stream slot for key. onQueued, if not nil, is called with the
+// request's 1-based queue position whenever it has to wait and each time it moves up.
+// It returns ErrTooManyStreams under the same conditions as TryAcquire, or ctx's error
+// if ctx ends first. Every successful Acquire must be paired with a Release.
+func (l *Limiter) Acquire(ctx context.Context, key string, onQueued func(position int)) error {
+	if l.cfg.MaxConcurrent <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	c := l.get(key, time.Now())
+	if c.active < l.cfg.MaxConcurrent && len(c.waiters) == 0 {
+		c.active++
+		l.mu.Unlock()
+		return nil
+	}
+	if !l.cfg.Queue || len(c.waiters) >= l.cfg.MaxQueue {
+		l.mu.Unlock()
+		return ErrTooManyStreams
+	}
+	// Each waiter's channel receives a value whenever it moves up; it is closed once the
+	// waiter has been handed a slot.
+	ready := make(chan struct{}, 1)
+	c.waiters = append(c.waiters, ready)
+	position := len(c.waiters)
+	l.mu.Unlock()
+
+	for {
+		if onQueued != nil {
+			onQueued(position)
+		}
+		select {
+		case _, open := <-ready:
+			if !open {
+				return nil // Release handed us its slot.
+			}
+			l.mu.Lock()
+			position = l.position(c, ready)
+			l.mu.Unlock()
+			if position == 0 {
+				return nil // Handed a slot; the close raced with a pending notification.
+			}
+		case <-ctx.Done():
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.position(c, ready) == 0 {
+				// The slot was handed over just as ctx ended; give it back.
+				l.release(c)
+				return ctx.Err()
+			}
+			l.removeWaiter(c, ready)
+			return ctx.Err()
+		}
+	}
+}
+
+// Release frees a slot taken by TryAcquire or Acquire, handing it to the next waiter if any.
+func (l *Limiter) Release(key string) {
+	if l.cfg.MaxConcurrent <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.clients[key]; ok {
+		l.release(c)
+	}
+}
+
+// Load returns how many of key's streams hold a slot and how many are waiting for one.
+func (l *Limiter) Load(key string) (active, queued int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.clients[key]; ok {
+		return c.active, len(c.waiters)
+	}
+	return 0, 0
+}
+
+// release frees one of c's slots; the caller must hold l.mu.
+func (l *Limiter) release(c *client) {
+	if len(c.waiters) == 0 {
+		c.active--
+		return
+	}
+	// The slot passes straight to the first waiter, so active stays the same.
+	next := c.waiters[0]
+	c.waiters = c.waiters[1:]
+	close(next)
+	l.notifyWaiters(c)
+}
+
+// removeWaiter drops a waiter that gave up; the caller must hold l.mu.
+func (l *Limiter) removeWaiter(c *client, ready chan struct{}) {
+	for i, w := range c.waiters {
+		if w == ready {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			l.notifyWaiters(c)
+			return
+		}
+	}
+}
+
+// notifyWaiters tells every waiter its position changed; the caller must hold l.mu.
+func (l *Limiter) notifyWaiters(c *client) {
+	for _, w := range c.waiters {
+		select {
+		case w <- struct{}{}:
+		default: // A notification is already pending.
+		}
+	}
+}
+
+// position returns ready's 1-based position in c's queue, or 0 if it is no longer queued;
+// the caller must hold l.mu.
+func (l *Limiter) position(c *client, ready chan struct{}) int {
+	for i, w := range c.waiters {
+		if w == ready {
+			return i + 1
+		}
+	}
+	return 0
+}
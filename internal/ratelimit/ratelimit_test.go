@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAllowBurstThenRate(t *testing.T) {
+	l := New(Config{RPS: 20, Burst: 3})
+	for i := range 3 {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait <= 0 || wait > 50*time.Millisecond {
+		t.Fatalf("Allow past the burst = %v, %v; want a refusal with a wait of at most 1/RPS", ok, wait)
+	}
+	// Other clients have buckets of their own.
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("another client was limited")
+	}
+	time.Sleep(wait + 5*time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("refused after waiting the hinted time")
+	}
+	if ok, _ := New(Config{}).Allow("a"); !ok {
+		t.Error("refused with no rate limit")
+	}
+}
+
+func TestTryAcquireCap(t *testing.T) {
+	l := New(Config{MaxConcurrent: 2})
+	for i := range 2 {
+		if ok, err := l.TryAcquire("a"); !ok || err != nil {
+			t.Fatalf("slot %d = %v, %v", i+1, ok, err)
+		}
+	}
+	if _, err := l.TryAcquire("a"); !errors.Is(err, ErrTooManyStreams) {
+		t.Errorf("third slot err = %v, want ErrTooManyStreams", err)
+	}
+	if ok, _ := l.TryAcquire("b"); !ok {
+		t.Error("another client was capped")
+	}
+	l.Release("a")
+	if ok, err := l.TryAcquire("a"); !ok || err != nil {
+		t.Errorf("slot after a release = %v, %v", ok, err)
+	}
+	if active, queued := l.Load("a"); active != 2 || queued != 0 {
+		t.Errorf("Load = %d, %d; want 2, 0", active, queued)
+	}
+}
+
+// queuedRequest is a request waiting in Acquire, with the queue positions it was told.
+type queuedRequest struct {
+	positions chan int
+	done      chan error
+	cancel    context.CancelFunc
+}
+
+func acquireAsync(l *Limiter, key string) *queuedRequest {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &queuedRequest{positions: make(chan int, 16), done: make(chan error, 1), cancel: cancel}
+	go func() { q.done <- l.Acquire(ctx, key, func(position int) { q.positions <- position }) }()
+	return q
+}
+
+// expectPosition waits for q to be told position.
+func (q *queuedRequest) expectPosition(t *testing.T, position int) {
+	t.Helper()
+	select {
+	case got := <-q.positions:
+		if got != position {
+			t.Fatalf("queue position %d, want %d", got, position)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no queue position; want %d", position)
+	}
+}
+
+// expectDone waits for q's Acquire to return err.
+func (q *queuedRequest) expectDone(t *testing.T, err error) {
+	t.Helper()
+	select {
+	case got := <-q.done:
+		if !errors.Is(got, err) {
+			t.Fatalf("Acquire = %v, want %v", got, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Acquire didn't return")
+	}
+}
+
+func TestAcquireQueuePositions(t *testing.T) {
+	l := New(Config{MaxConcurrent: 1, Queue: true, MaxQueue: 3})
+	if err := l.Acquire(context.Background(), "a", nil); err != nil {
+		t.Fatal(err)
+	}
+	first := acquireAsync(l, "a")
+	first.expectPosition(t, 1)
+	second := acquireAsync(l, "a")
+	second.expectPosition(t, 2)
+	third := acquireAsync(l, "a")
+	third.expectPosition(t, 3)
+	if _, err := l.TryAcquire("a"); !errors.Is(err, ErrTooManyStreams) {
+		t.Errorf("TryAcquire with the queue full err = %v", err)
+	}
+	if err := l.Acquire(context.Background(), "a", nil); !errors.Is(err, ErrTooManyStreams) {
+		t.Errorf("Acquire with the queue full err = %v", err)
+	}
+
+	// The second gives up: the third moves up.
+	second.cancel()
+	second.expectDone(t, context.Canceled)
+	third.expectPosition(t, 2)
+
+	// A release hands the slot to the head of the queue, and the rest move up.
+	l.Release("a")
+	first.expectDone(t, nil)
+	third.expectPosition(t, 1)
+	if active, queued := l.Load("a"); active != 1 || queued != 1 {
+		t.Errorf("Load = %d, %d; want 1, 1", active, queued)
+	}
+	l.Release("a")
+	third.expectDone(t, nil)
+	l.Release("a")
+	if active, queued := l.Load("a"); active != 0 || queued != 0 {
+		t.Errorf("Load after every release = %d, %d", active, queued)
+	}
+}
+
+func TestAcquireWithoutQueue(t *testing.T) {
+	l := New(Config{MaxConcurrent: 1})
+	if err := l.Acquire(context.Background(), "a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Acquire(context.Background(), "a", func(int) { t.Error("queued with queueing off") }); !errors.Is(err, ErrTooManyStreams) {
+		t.Errorf("Acquire over the cap err = %v", err)
+	}
+}
+
+func TestHammerNeverExceedsCap(t *testing.T) {
+	const maxConcurrent = 3
+	l := New(Config{MaxConcurrent: maxConcurrent, Queue: true, MaxQueue: 100})
+	var running, peak, served atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.Acquire(context.Background(), "a", nil); err != nil {
+				t.Error(err)
+				return
+			}
+			n := running.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+			served.Add(1)
+			l.Release("a")
+		}()
+	}
+	wg.Wait()
+	if served.Load() != 50 || peak.Load() > maxConcurrent {
+		t.Errorf("served %d, peak %d running; want 50 served, at most %d at once", served.Load(), peak.Load(), maxConcurrent)
+	}
+	if active, queued := l.Load("a"); active != 0 || queued != 0 {
+		t.Errorf("Load after the hammering = %d, %d", active, queued)
+	}
+}
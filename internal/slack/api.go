@@ -0,0 +1,106 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAPIURL is the base URL of the Slack Web API.
+const DefaultAPIURL = "https://slack.com/api"
+
+// APIError is a failed Web API call: Slack answers most failures with HTTP 200 and
+// {"ok":false,"error":"<code>"}, and rate limiting with 429 and a Retry-After header.
+type APIError struct {
+	Method     string        // e.g. "chat.postMessage"
+	Code       string        // Slack's error code, e.g. "channel_not_found" or "ratelimited"
+	RetryAfter time.Duration // Set when Slack rate limited the call
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("slack %s: %s", e.Method, e.Code)
+}
+
+// Client calls the Slack Web API with a bot token.
+type Client struct {
+	Token      string
+	BaseURL    string // DefaultAPIURL if empty; tests point it at a fake server
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for the API at baseURL (DefaultAPIURL if empty) using token.
+func NewClient(token, baseURL string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultAPIURL
+	}
+	return &Client{Token: token, BaseURL: strings.TrimSuffix(baseURL, "/"), HTTPClient: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// PostMessage posts text to channel, as a reply in the thread threadTS if it is set,
+// and returns the new message's timestamp (its ID).
+func (c *Client) PostMessage(ctx context.Context, channel, threadTS, text string) (string, error) {
+	var resp struct {
+		TS string `json:"ts"`
+	}
+	err := c.call(ctx, "chat.postMessage", map[string]string{"channel": channel, "thread_ts": threadTS, "text": text}, &resp)
+	return resp.TS, err
+}
+
+// UpdateMessage replaces the text of the message ts in channel.
+func (c *Client) UpdateMessage(ctx context.Context, channel, ts, text string) error {
+	return c.call(ctx, "chat.update", map[string]string{"channel": channel, "ts": ts, "text": text}, nil)
+}
+
+// call POSTs params as JSON to the API method and decodes the response into out (if not nil).
+func (c *Client) call(ctx context.Context, method string, params map[string]string, out any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("encode %s request: %w", method, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("slack %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &APIError{Method: method, Code: "ratelimited", RetryAfter: time.Duration(seconds) * time.Second}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &APIError{Method: method, Code: "http_" + strconv.Itoa(resp.StatusCode)}
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read %s response: %w", method, err)
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return fmt.Errorf("decode %s response: %w", method, err)
+	}
+	if !status.OK {
+		return &APIError{Method: method, Code: status.Error}
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return fmt.Errorf("decode %s response: %w", method, err)
+		}
+	}
+	return nil
+}
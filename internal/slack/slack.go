@@ -0,0 +1,270 @@
+// Package slack answers questions asked in Slack. Handler receives the app's Events API
+// callbacks (POST /integrations/slack): it completes the url_verification handshake, and for
+// app_mention events and direct messages it starts the question through the chat pipeline and
+// posts the answer in the message's thread, edited in place as it streams in.
+//
+// Each Slack thread is one conversation: the session ID is derived from the channel and the
+// thread's root timestamp, so follow-up questions in a thread see the earlier answers.
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/chatbot"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+)
+
+// dedupTTL is how long delivered event IDs are remembered. Slack redelivers an event it
+// thinks failed up to three times over about five minutes.
+const dedupTTL = 10 * time.Minute
+
+// Handler serves the Events API endpoint. Create it with NewHandler.
+type Handler struct {
+	SigningSecret string
+	API           *Client
+	Chat          chatbot.ChatFunc
+	Reply         chatbot.Options
+
+	now func() time.Time // Replaced in tests
+
+	mu   sync.Mutex
+	seen map[string]time.Time // Event IDs already accepted, with when
+	wg   sync.WaitGroup       // Replies being written
+}
+
+// NewHandler returns a handler that verifies requests with signingSecret, starts questions
+// through chat and posts the answers with api.
+func NewHandler(signingSecret string, api *Client, chat chatbot.ChatFunc) *Handler {
+	return &Handler{
+		SigningSecret: signingSecret,
+		API:           api,
+		Chat:          chat,
+		now:           time.Now,
+		seen:          make(map[string]time.Time),
+	}
+}
+
+// envelope is the outer JSON of an Events API request.
+type envelope struct {
+	Type      string `json:"type"`      // "url_verification" or "event_callback"
+	Challenge string `json:"challenge"` // Set for url_verification
+	TeamID    string `json:"team_id"`
+	EventID   string `json:"event_id"`
+	Event     event  `json:"event"`
+}
+
+// event is the part of an app_mention or message event the handler uses.
+type event struct {
+	Type        string `json:"type"`
+	Subtype     string `json:"subtype"` // Edits, joins, bot posts...; plain user messages have none
+	User        string `json:"user"`
+	BotID       string `json:"bot_id"`
+	Text        string `json:"text"`
+	Channel     string `json:"channel"`
+	ChannelType string `json:"channel_type"` // "im" for direct messages
+	TS          string `json:"ts"`
+	ThreadTS    string `json:"thread_ts"` // Set when the message is in a thread
+}
+
+// ServeHTTP verifies the request's signature and handles the callback. Events are acknowledged
+// at once and answered in the background, since Slack expects a response within three seconds.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body) // Bounded by the route's httpmw.MaxBytes.
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+	if err := VerifySignature(h.SigningSecret, r.Header, body, h.now()); err != nil {
+		slog.WarnContext(r.Context(), "Rejected Slack request", "error", err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var env envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		http.Error(w, "Malformed event", http.StatusBadRequest)
+		return
+	}
+	switch env.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"challenge": env.Challenge})
+		return
+	case "event_callback":
+	default:
+		w.WriteHeader(http.StatusOK) // Nothing to do, but a 200 stops Slack from redelivering it.
+		return
+	}
+
+	ev := env.Event
+	if !answerable(ev) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	// Slack redelivers events it didn't see acknowledged in time (X-Slack-Retry-Num says which
+	// attempt this is). An event that was already accepted is acknowledged again and dropped.
+	if !h.firstDelivery(env.EventID) {
+		slog.InfoContext(r.Context(), "Ignoring redelivered Slack event", "event_id", env.EventID,
+			"retry_num", r.Header.Get("X-Slack-Retry-Num"), "retry_reason", r.Header.Get("X-Slack-Retry-Reason"))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+
+	threadTS := ev.ThreadTS
+	if threadTS == "" {
+		threadTS = ev.TS // A new thread starts at the message itself.
+	}
+	q := question{
+		user:      "slack:" + env.TeamID + ":" + ev.User,
+		sessionID: "slack:" + ev.Channel + ":" + threadTS,
+		channel:   ev.Channel,
+		threadTS:  threadTS,
+		text:      cleanText(ev.Text),
+	}
+	slog.InfoContext(r.Context(), "Slack question", "event_id", env.EventID, "event_type", ev.Type, "channel", ev.Channel, "session_id", q.sessionID)
+
+	// The answer outlives the request; WithoutCancel keeps its values (the request ID) for the logs.
+	ctx := context.WithoutCancel(r.Context())
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		h.answer(ctx, q)
+	}()
+}
+
+// answerable reports whether ev is a question for the bot: a mention of it, or a direct message.
+// Bot messages (including the bot's own answers) and message subtypes such as edits are ignored.
+// Channel messages come as app_mention events; their plain message events are skipped so a
+// mention isn't answered twice.
+func answerable(ev event) bool {
+	if ev.BotID != "" || ev.Subtype != "" || ev.User == "" || ev.Channel == "" || ev.TS == "" {
+		return false
+	}
+	switch ev.Type {
+	case "app_mention":
+		return true
+	case "message":
+		return ev.ChannelType == "im"
+	}
+	return false
+}
+
+// firstDelivery records eventID and reports whether it was new. Events without an ID are
+// always new. Expired IDs are dropped as new ones arrive.
+func (h *Handler) firstDelivery(eventID string) bool {
+	if eventID == "" {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	for id, at := range h.seen {
+		if now.Sub(at) > dedupTTL {
+			delete(h.seen, id)
+		}
+	}
+	if _, ok := h.seen[eventID]; ok {
+		return false
+	}
+	h.seen[eventID] = now
+	return true
+}
+
+// Wait blocks until the replies being written have finished or ctx is done, and reports
+// whether they all finished. Shutdown calls it after the orchestrations have drained.
+func (h *Handler) Wait(ctx context.Context) bool {
+	finished := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// question is an accepted Slack message.
+type question struct {
+	user      string // Client key for rate limiting
+	sessionID string
+	channel   string
+	threadTS  string
+	text      string
+}
+
+// answer runs q through the chat pipeline and posts the answer in its thread.
+func (h *Handler) answer(ctx context.Context, q question) {
+	m := &threadMessenger{api: h.API, channel: q.channel, threadTS: q.threadTS}
+	stream, err := h.Chat(ctx, q.user, q.sessionID, q.text)
+	if err != nil {
+		if _, postErr := m.Post(ctx, "⚠️ "+err.Error()); postErr != nil {
+			slog.ErrorContext(ctx, "Posting Slack error reply failed", "session_id", q.sessionID, "error", postErr)
+		}
+		return
+	}
+	opts := h.Reply
+	opts.Language = i18n.Detect(q.text) // The pipeline answers in the question's language.
+	if err := chatbot.Reply(ctx, stream, m, opts); err != nil {
+		slog.ErrorContext(ctx, "Posting Slack answer failed", "stream", stream.ID(), "session_id", q.sessionID, "error", err)
+	}
+}
+
+// threadMessenger posts and edits the messages of one answer in a Slack thread.
+type threadMessenger struct {
+	api      *Client
+	channel  string
+	threadTS string
+}
+
+func (m *threadMessenger) Post(ctx context.Context, text string) (string, error) {
+	return m.api.PostMessage(ctx, m.channel, m.threadTS, toMrkdwn(text))
+}
+
+func (m *threadMessenger) Edit(ctx context.Context, ts, text string) error {
+	err := m.api.UpdateMessage(ctx, m.channel, ts, toMrkdwn(text))
+	// Waiting out a short rate limit once keeps the final text from being lost.
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 && apiErr.RetryAfter <= 5*time.Second {
+		select {
+		case <-time.After(apiErr.RetryAfter):
+		case <-ctx.Done():
+			return err
+		}
+		err = m.api.UpdateMessage(ctx, m.channel, ts, toMrkdwn(text))
+	}
+	return err
+}
+
+// leadingMentions matches the user mentions (e.g. "<@U024BE7LH>") that start a message addressed to the bot.
+var leadingMentions = regexp.MustCompile(`^(\s*<@[A-Z0-9]+(\|[^>]*)?>)+\s*`)
+
+// cleanText turns a Slack message into the plain question: the leading mention of the bot is
+// removed and Slack's escaping of &, < and > is undone.
+func cleanText(text string) string {
+	text = leadingMentions.ReplaceAllString(text, "")
+	return strings.TrimSpace(html.UnescapeString(text))
+}
+
+// boldMarkdown matches Markdown bold, which Slack's mrkdwn writes with single asterisks.
+var boldMarkdown = regexp.MustCompile(`\*\*(.+?)\*\*`)
+
+// toMrkdwn converts an answer to Slack's mrkdwn: &, < and > are escaped as Slack requires,
+// and Markdown **bold** becomes *bold*.
+func toMrkdwn(text string) string {
+	text = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+	return boldMarkdown.ReplaceAllString(text, "*$1*")
+}
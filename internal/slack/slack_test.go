@@ -0,0 +1,313 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+var testNow = time.Unix(1_700_000_000, 0)
+
+// Recorded Events API payloads, trimmed to the fields Slack always sends.
+const (
+	urlVerification = `{"token":"Jhj5dZrVaK7ZwHHjRyZWjbDl","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P","type":"url_verification"}`
+
+	appMention = `{"token":"XXYYZZ","team_id":"T061EG9R6","api_app_id":"A0MDYCDME","event":{"type":"app_mention","user":"U061F7AUR","text":"<@U0LAN0Z89> What is the capital of France?","ts":"1515449522.000016","channel":"C0LAN2Q65","event_ts":"1515449522000016"},"type":"event_callback","event_id":"Ev0LAN670R","event_time":1515449522}`
+
+	threadReply = `{"token":"XXYYZZ","team_id":"T061EG9R6","api_app_id":"A0MDYCDME","event":{"type":"app_mention","user":"U061F7AUR","text":"<@U0LAN0Z89> And of Spain?","ts":"1515449580.000020","thread_ts":"1515449522.000016","channel":"C0LAN2Q65","event_ts":"1515449580000020"},"type":"event_callback","event_id":"Ev0LAN671S","event_time":1515449580}`
+
+	directMessage = `{"token":"XXYYZZ","team_id":"T061EG9R6","api_app_id":"A0MDYCDME","event":{"type":"message","user":"U061F7AUR","text":"Flights from Madrid to Paris","ts":"1515449600.000030","channel":"D0PNCRP9N","channel_type":"im","event_ts":"1515449600000030"},"type":"event_callback","event_id":"Ev0LAN672T","event_time":1515449600}`
+
+	channelMessage = `{"token":"XXYYZZ","team_id":"T061EG9R6","api_app_id":"A0MDYCDME","event":{"type":"message","user":"U061F7AUR","text":"<@U0LAN0Z89> What is the capital of France?","ts":"1515449522.000016","channel":"C0LAN2Q65","channel_type":"channel","event_ts":"1515449522000016"},"type":"event_callback","event_id":"Ev0LAN673U","event_time":1515449522}`
+
+	botMessage = `{"token":"XXYYZZ","team_id":"T061EG9R6","api_app_id":"A0MDYCDME","event":{"type":"message","bot_id":"B0LAN0Z89","text":"The capital is Paris.","ts":"1515449523.000017","channel":"D0PNCRP9N","channel_type":"im","event_ts":"1515449523000017"},"type":"event_callback","event_id":"Ev0LAN674V","event_time":1515449523}`
+
+	messageChanged = `{"token":"XXYYZZ","team_id":"T061EG9R6","api_app_id":"A0MDYCDME","event":{"type":"message","subtype":"message_changed","message":{"type":"message","user":"U061F7AUR","text":"Flights from Madrid to Rome","ts":"1515449600.000030"},"ts":"1515449610.000031","channel":"D0PNCRP9N","channel_type":"im","event_ts":"1515449610000031"},"type":"event_callback","event_id":"Ev0LAN675W","event_time":1515449610}`
+)
+
+// apiCall is one Web API call the fake Slack API received.
+type apiCall struct {
+	method string
+	auth   string
+	params map[string]string
+}
+
+// fakeAPI is a Slack Web API that records the calls it receives and answers each with a new
+// message timestamp.
+type fakeAPI struct {
+	mu    sync.Mutex
+	calls []apiCall
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var params map[string]string
+	json.NewDecoder(r.Body).Decode(&params)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	method := r.URL.Path[1:]
+	f.calls = append(f.calls, apiCall{method, r.Header.Get("Authorization"), params})
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "ts": "1515449600." + strconv.Itoa(len(f.calls))})
+}
+
+func (f *fakeAPI) Calls() []apiCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]apiCall(nil), f.calls...)
+}
+
+// asked is a question the handler started through the chat pipeline.
+type asked struct {
+	user, sessionID, text string
+}
+
+// testHandler is a Handler talking to a fake Slack API, on a clock stopped at now, whose
+// pipeline answers every question with answer.
+type testHandler struct {
+	*Handler
+	api *fakeAPI
+	now time.Time
+
+	mu    sync.Mutex
+	asked []asked
+}
+
+func newTestHandler(t *testing.T, answer string) *testHandler {
+	t.Helper()
+	api := &fakeAPI{}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	th := &testHandler{api: api, now: testNow}
+	streams := sse.NewRegistry(time.Minute)
+	th.Handler = NewHandler("shh", NewClient("xoxb-test", srv.URL), func(ctx context.Context, user, sessionID, text string) (*sse.Stream, error) {
+		th.mu.Lock()
+		th.asked = append(th.asked, asked{user, sessionID, text})
+		th.mu.Unlock()
+		stream := streams.Create()
+		stream.Publish(sse.Status("Searching"))
+		stream.Publish(sse.MessageChunk(answer, true))
+		stream.Publish(sse.Done(sse.DonePayload{Outcome: sse.OutcomeOK}))
+		stream.Close()
+		return stream, nil
+	})
+	th.Handler.now = func() time.Time { return th.now }
+	return th
+}
+
+// send POSTs body to the handler, signed at the handler's now, with extra headers, and waits
+// for the replies it starts to be written.
+func (th *testHandler) send(t *testing.T, body string, extra http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/integrations/slack", bytes.NewReader([]byte(body)))
+	for name, values := range sign("shh", th.now, []byte(body)) {
+		req.Header[name] = values
+	}
+	for name, values := range extra {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	th.ServeHTTP(rec, req)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !th.Wait(ctx) {
+		t.Fatal("the reply wasn't written")
+	}
+	return rec
+}
+
+func (th *testHandler) Asked() []asked {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	return append([]asked(nil), th.asked...)
+}
+
+func TestURLVerification(t *testing.T) {
+	th := newTestHandler(t, "")
+	rec := th.send(t, urlVerification, nil)
+	var got struct{ Challenge string }
+	json.NewDecoder(rec.Body).Decode(&got)
+	if rec.Code != http.StatusOK || got.Challenge != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("status %d, challenge %q; want 200 and the challenge echoed", rec.Code, got.Challenge)
+	}
+}
+
+func TestAppMentionAnsweredInThread(t *testing.T) {
+	th := newTestHandler(t, "The capital is **Paris** & not <Lyon>.")
+	if rec := th.send(t, appMention, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+
+	want := asked{"slack:T061EG9R6:U061F7AUR", "slack:C0LAN2Q65:1515449522.000016", "What is the capital of France?"}
+	if got := th.Asked(); len(got) != 1 || got[0] != want {
+		t.Fatalf("asked %+v, want %+v", got, want)
+	}
+	// A placeholder in the message's thread, then edited to the answer in mrkdwn.
+	calls := th.api.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("API calls %+v, want a post and an edit", calls)
+	}
+	post, edit := calls[0], calls[1]
+	if post.method != "chat.postMessage" || post.auth != "Bearer xoxb-test" || post.params["channel"] != "C0LAN2Q65" ||
+		post.params["thread_ts"] != "1515449522.000016" || post.params["text"] != "⏳ Thinking…" {
+		t.Errorf("post = %+v", post)
+	}
+	if edit.method != "chat.update" || edit.params["ts"] != "1515449600.1" ||
+		edit.params["text"] != "The capital is *Paris* &amp; not &lt;Lyon&gt;." {
+		t.Errorf("edit = %+v", edit)
+	}
+}
+
+func TestThreadIsOneSession(t *testing.T) {
+	th := newTestHandler(t, "Madrid.")
+	th.send(t, appMention, nil)
+	th.send(t, threadReply, nil)
+	got := th.Asked()
+	if len(got) != 2 || got[1].sessionID != got[0].sessionID || got[1].text != "And of Spain?" {
+		t.Errorf("asked %+v, want the reply in the first question's session", got)
+	}
+	if post := th.api.Calls()[2]; post.params["thread_ts"] != "1515449522.000016" {
+		t.Errorf("the reply's answer posted in thread %q", post.params["thread_ts"])
+	}
+}
+
+func TestDirectMessageAnswered(t *testing.T) {
+	th := newTestHandler(t, "FL101 leaves at 08:00.")
+	th.send(t, directMessage, nil)
+	if got := th.Asked(); len(got) != 1 || got[0].sessionID != "slack:D0PNCRP9N:1515449600.000030" {
+		t.Errorf("asked %+v, want the direct message in its own session", got)
+	}
+}
+
+func TestIgnoredEvents(t *testing.T) {
+	for name, body := range map[string]string{
+		"channel message": channelMessage, // Answered as its app_mention
+		"bot message":     botMessage,
+		"edit":            messageChanged,
+	} {
+		th := newTestHandler(t, "")
+		if rec := th.send(t, body, nil); rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200 so Slack doesn't redeliver it", name, rec.Code)
+		}
+		if len(th.Asked()) != 0 || len(th.api.Calls()) != 0 {
+			t.Errorf("%s was answered", name)
+		}
+	}
+}
+
+func TestRedeliveryIgnored(t *testing.T) {
+	th := newTestHandler(t, "Paris.")
+	th.send(t, appMention, nil)
+	retry := http.Header{"X-Slack-Retry-Num": {"1"}, "X-Slack-Retry-Reason": {"http_timeout"}}
+	th.now = th.now.Add(time.Minute)
+	if rec := th.send(t, appMention, retry); rec.Code != http.StatusOK {
+		t.Errorf("redelivery status %d, want 200", rec.Code)
+	}
+	if n := len(th.Asked()); n != 1 {
+		t.Errorf("asked %d times, want the redelivery dropped", n)
+	}
+
+	// Event IDs are forgotten after dedupTTL.
+	th.now = th.now.Add(dedupTTL)
+	th.send(t, appMention, nil)
+	if n := len(th.Asked()); n != 2 {
+		t.Errorf("asked %d times, want the event answered again once its ID expired", n)
+	}
+}
+
+func TestUnverifiedRequestsRejected(t *testing.T) {
+	th := newTestHandler(t, "")
+	for _, tt := range []struct {
+		name   string
+		header http.Header
+	}{
+		{"unsigned", http.Header{"X-Slack-Signature": {""}}},
+		{"other secret", sign("other", testNow, []byte(appMention))},
+		{"stale", sign("shh", testNow.Add(-maxClockSkew-time.Second), []byte(appMention))},
+	} {
+		if rec := th.send(t, appMention, tt.header); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", tt.name, rec.Code)
+		}
+	}
+	if len(th.Asked()) != 0 {
+		t.Error("an unverified request was answered")
+	}
+}
+
+func TestChatErrorPosted(t *testing.T) {
+	th := newTestHandler(t, "")
+	th.Chat = func(context.Context, string, string, string) (*sse.Stream, error) {
+		return nil, errors.New("Too many requests, please slow down")
+	}
+	th.send(t, appMention, nil)
+	calls := th.api.Calls()
+	if len(calls) != 1 || calls[0].params["text"] != "⚠️ Too many requests, please slow down" {
+		t.Errorf("API calls %+v, want the error posted in the thread", calls)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chat.postMessage":
+			w.Write([]byte(`{"ok":false,"error":"channel_not_found"}`))
+		case "/chat.update":
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+	c := NewClient("xoxb-test", srv.URL+"/")
+
+	for _, tt := range []struct {
+		call func() error
+		want APIError
+	}{
+		{func() error { _, err := c.PostMessage(context.Background(), "C1", "", "hi"); return err },
+			APIError{Method: "chat.postMessage", Code: "channel_not_found"}},
+		{func() error { return c.UpdateMessage(context.Background(), "C1", "1.2", "hi") },
+			APIError{Method: "chat.update", Code: "ratelimited", RetryAfter: 3 * time.Second}},
+		{func() error { return c.call(context.Background(), "auth.test", nil, nil) },
+			APIError{Method: "auth.test", Code: "http_502"}},
+	} {
+		var got *APIError
+		if err := tt.call(); !errors.As(err, &got) || *got != tt.want {
+			t.Errorf("err = %v, want %+v", err, tt.want)
+		}
+	}
+}
+
+func TestCleanText(t *testing.T) {
+	for in, want := range map[string]string{
+		"<@U0LAN0Z89> What is the capital of France?":         "What is the capital of France?",
+		"<@U0LAN0Z89|bot> <@U0LAN0Z89>  Hi":                   "Hi",
+		"Ask <@U061F7AUR> about it":                           "Ask <@U061F7AUR> about it",
+		"<@U0LAN0Z89> Flights &lt;200€ from A &amp; B &gt; C": "Flights <200€ from A & B > C",
+	} {
+		if got := cleanText(in); got != want {
+			t.Errorf("cleanText(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestToMrkdwn(t *testing.T) {
+	for in, want := range map[string]string{
+		"**FL101** leaves at 08:00": "*FL101* leaves at 08:00",
+		"1 < 2 & 3 > 2":             "1 &lt; 2 &amp; 3 &gt; 2",
+		"plain":                     "plain",
+	} {
+		if got := toMrkdwn(in); got != want {
+			t.Errorf("toMrkdwn(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
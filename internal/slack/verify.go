@@ -0,0 +1,48 @@
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxClockSkew bounds the age of a signed request, so a captured request can't be replayed later.
+const maxClockSkew = 5 * time.Minute
+
+// Signature verification failures.
+var (
+	ErrMissingSignature = errors.New("missing Slack signature headers")
+	ErrStaleRequest     = errors.New("Slack request timestamp is too old")
+	ErrBadSignature     = errors.New("Slack signature does not match")
+)
+
+// VerifySignature checks that body was sent by Slack: the X-Slack-Signature header must be
+// "v0=" followed by the hex HMAC-SHA256, keyed with the app's signing secret, of
+// "v0:<X-Slack-Request-Timestamp>:<body>", and the timestamp must be within five minutes of now.
+func VerifySignature(secret string, header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	signature := header.Get("X-Slack-Signature")
+	if timestamp == "" || signature == "" {
+		return ErrMissingSignature
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrMissingSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > maxClockSkew || age < -maxClockSkew {
+		return ErrStaleRequest
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// sign returns the headers Slack sends with body, signed with secret at timestamp.
+func sign(secret string, timestamp time.Time, body []byte) http.Header {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	h := make(http.Header)
+	h.Set("X-Slack-Request-Timestamp", ts)
+	h.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return h
+}
+
+func TestVerifySignatureSlackExample(t *testing.T) {
+	// The example in Slack's "Verifying requests from Slack" guide.
+	body := []byte("token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c")
+	header := make(http.Header)
+	header.Set("X-Slack-Request-Timestamp", "1531420618")
+	header.Set("X-Slack-Signature", "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503")
+	if err := VerifySignature("8f742231b10e8888abcd99yyyzzz85a5", header, body, time.Unix(1531420618, 0)); err != nil {
+		t.Errorf("VerifySignature = %v, want nil", err)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"type":"event_callback"}`)
+	valid := sign("shh", testNow, body)
+	badTime := valid.Clone()
+	badTime.Set("X-Slack-Request-Timestamp", "yesterday")
+	for _, tt := range []struct {
+		name   string
+		secret string
+		header http.Header
+		body   string
+		now    time.Time
+		want   error
+	}{
+		{"valid", "shh", valid, string(body), testNow, nil},
+		{"four minutes old", "shh", valid, string(body), testNow.Add(4 * time.Minute), nil},
+		{"tampered body", "shh", valid, `{"type":"url_verification"}`, testNow, ErrBadSignature},
+		{"other secret", "other", valid, string(body), testNow, ErrBadSignature},
+		{"stale", "shh", valid, string(body), testNow.Add(maxClockSkew + time.Second), ErrStaleRequest},
+		{"from the future", "shh", valid, string(body), testNow.Add(-maxClockSkew - time.Second), ErrStaleRequest},
+		{"unsigned", "shh", http.Header{}, string(body), testNow, ErrMissingSignature},
+		{"malformed timestamp", "shh", badTime, string(body), testNow, ErrMissingSignature},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifySignature(tt.secret, tt.header, []byte(tt.body), tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package sse
+
+import "time"
+
+// Defaults for Handler's flush coalescing of Message chunks.
+const (
+	DefaultCoalesceWindow = 50 * time.Millisecond
+	DefaultCoalesceBytes  = 512
+)
+
+// coalescer decides when a connection flushes. Streamed answers arrive as many tiny Message
+// chunks; flushing each one costs a syscall and makes some proxies stutter, so Message
+// writes are held for up to window or maxBytes. Any other event type flushes immediately,
+// and since events are always written in order, holding back a flush never reorders them.
+type coalescer struct {
+	window   time.Duration // Zero disables coalescing
+	maxBytes int
+
+	pending int       // Bytes written since the last flush
+	since   time.Time // When the oldest unflushed byte was written
+}
+
+// wrote records n bytes written for an event of type eventType and reports whether
+// the connection should be flushed now.
+func (c *coalescer) wrote(eventType string, n int) bool {
+	if c.pending == 0 {
+		c.since = time.Now()
+	}
+	c.pending += n
+	return eventType != TypeMessage || c.window <= 0 || c.pending >= c.maxBytes || time.Since(c.since) >= c.window
+}
+
+// flushed resets the pending state after a flush.
+func (c *coalescer) flushed() {
+	c.pending = 0
+}
+
+// deadline returns a channel that fires when pending writes must be flushed,
+// or nil (blocking forever in a select) when nothing is pending.
+func (c *coalescer) deadline() (<-chan time.Time, func() bool) {
+	if c.pending == 0 {
+		return nil, func() bool { return false }
+	}
+	t := time.NewTimer(c.window - time.Since(c.since))
+	return t.C, t.Stop
+}
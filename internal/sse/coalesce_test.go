@@ -0,0 +1,206 @@
+package sse
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalescerDecisions(t *testing.T) {
+	c := &coalescer{window: time.Hour, maxBytes: 100}
+	if timeout, _ := c.deadline(); timeout != nil {
+		t.Error("a deadline with nothing pending")
+	}
+	if c.wrote(TypeMessage, 40) || c.wrote(TypeMessage, 40) {
+		t.Error("flushed Message chunks under the window and size limits")
+	}
+	if timeout, stop := c.deadline(); timeout == nil {
+		t.Error("no deadline with chunks pending")
+	} else {
+		stop()
+	}
+	if !c.wrote(TypeMessage, 40) {
+		t.Error("didn't flush past the size limit")
+	}
+	c.flushed()
+	for _, typ := range []string{TypeStatus, TypeError, TypeDone, TypeFlightResults} {
+		if !c.wrote(typ, 1) {
+			t.Errorf("held back a %s event", typ)
+		}
+		c.flushed()
+	}
+
+	c = &coalescer{window: time.Millisecond, maxBytes: 100}
+	c.wrote(TypeMessage, 1)
+	time.Sleep(2 * time.Millisecond)
+	if !c.wrote(TypeMessage, 1) {
+		t.Error("didn't flush a chunk older than the window")
+	}
+	if !(&coalescer{}).wrote(TypeMessage, 1) {
+		t.Error("held back a chunk with coalescing off")
+	}
+}
+
+// flushWriter is a ResponseWriter that records what each flush sent.
+type flushWriter struct {
+	header http.Header
+
+	mu      sync.Mutex
+	pending strings.Builder
+	written int
+	flushes []string
+}
+
+func newFlushWriter() *flushWriter {
+	return &flushWriter{header: http.Header{}}
+}
+
+func (w *flushWriter) Header() http.Header { return w.header }
+func (w *flushWriter) WriteHeader(int)     {}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written += len(p)
+	return w.pending.Write(p)
+}
+
+func (w *flushWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending.Len() > 0 {
+		w.flushes = append(w.flushes, w.pending.String())
+		w.pending.Reset()
+	}
+}
+
+// state returns the bytes written and what each flush sent so far.
+func (w *flushWriter) state() (int, []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written, append([]string(nil), w.flushes...)
+}
+
+// publishAndWait publishes event and waits until the handler has written it.
+func publishAndWait(t *testing.T, w *flushWriter, stream *Stream, event Event) {
+	t.Helper()
+	before, _ := w.state()
+	stream.Publish(event)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if written, _ := w.state(); written > before {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s event wasn't written", event.Type)
+		}
+	}
+}
+
+// flushedData returns the data of the events in a flush.
+func flushedData(t *testing.T, flush string) []string {
+	t.Helper()
+	var data []string
+	for _, frame := range readFrames(t, strings.NewReader(flush)) {
+		data = append(data, frame.Data)
+	}
+	return data
+}
+
+func TestCoalescingKeepsOrderAndFlushesAtEnd(t *testing.T) {
+	w, stream := newFlushWriter(), newStream("s1")
+	h := &Handler{CoalesceWindow: time.Hour, CoalesceBytes: 1 << 20}
+	done := serveAsync(h, w, stream)
+
+	publishAndWait(t, w, stream, MessageChunk("a", false))
+	publishAndWait(t, w, stream, MessageChunk("b", false))
+	if _, flushes := w.state(); len(flushes) != 0 {
+		t.Errorf("flushed %q before the window or size limit", flushes)
+	}
+	// A Status event goes out at once, together with the chunks written before it.
+	publishAndWait(t, w, stream, Status("checking"))
+	publishAndWait(t, w, stream, MessageChunk("c", true))
+	stream.Publish(MessageChunk("d", true))
+	stream.Close()
+	waitStats(t, done)
+
+	_, flushes := w.state()
+	if len(flushes) != 2 {
+		t.Fatalf("%d flushes %q, want one at the Status and one at the end", len(flushes), flushes)
+	}
+	if got := strings.Join(flushedData(t, flushes[0]), " "); got != "a b checking" {
+		t.Errorf("first flush sent %q, want the chunks then the status", got)
+	}
+	if got := strings.Join(flushedData(t, flushes[1]), " "); got != "c d" {
+		t.Errorf("final flush sent %q, want the pending chunks", got)
+	}
+}
+
+func TestCoalescingFlushesAfterWindow(t *testing.T) {
+	w, stream := newFlushWriter(), newStream("s1")
+	done := serveAsync(&Handler{CoalesceWindow: 10 * time.Millisecond, CoalesceBytes: 1 << 20}, w, stream)
+	defer func() {
+		stream.Close()
+		waitStats(t, done)
+	}()
+	publishAndWait(t, w, stream, MessageChunk("a", false))
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if _, flushes := w.state(); len(flushes) == 1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("a lone chunk wasn't flushed once the window passed")
+		}
+	}
+}
+
+func TestCoalescingFlushesAtSizeLimit(t *testing.T) {
+	w, stream := newFlushWriter(), newStream("s1")
+	done := serveAsync(&Handler{CoalesceWindow: time.Hour, CoalesceBytes: 100}, w, stream)
+	for range 5 {
+		publishAndWait(t, w, stream, MessageChunk(strings.Repeat("x", 30), false))
+	}
+	_, flushes := w.state()
+	stream.Close()
+	waitStats(t, done)
+	if len(flushes) != 2 {
+		t.Errorf("%d flushes after five 30-byte chunks (framed as ~60 bytes each), want 2", len(flushes))
+	}
+}
+
+// BenchmarkFlushes streams an answer of many tiny chunks, as token streaming does, and reports
+// how many flushes it took with coalescing off and at the defaults.
+func BenchmarkFlushes(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		window time.Duration
+	}{{"uncoalesced", 0}, {"coalesced", DefaultCoalesceWindow}} {
+		b.Run(bc.name, func(b *testing.B) {
+			flushes := 0
+			for range b.N {
+				w, stream := newFlushWriter(), newStream("s1")
+				done := make(chan struct{})
+				h := &Handler{CoalesceWindow: bc.window, CoalesceBytes: DefaultCoalesceBytes}
+				go func() {
+					h.ServeStream(w, httptest.NewRequest("GET", "/", nil), stream, 0)
+					close(done)
+				}()
+				for i := range 200 {
+					stream.Publish(MessageChunk(fmt.Sprintf("%02d", i%100), i == 199))
+					if i%5 == 0 {
+						time.Sleep(time.Millisecond) // Tokens arrive over time
+					}
+				}
+				stream.Publish(Done(DonePayload{Outcome: OutcomeOK}))
+				stream.Close()
+				<-done
+				_, flushed := w.state()
+				flushes += len(flushed)
+			}
+			b.ReportMetric(float64(flushes)/float64(b.N), "flushes/op")
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package sse
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NDJSONContentType is the media type of newline-delimited JSON streams.
+const NDJSONContentType = "application/x-ndjson"
+
+// Encoder frames events for one transport. Handler.ServeStream does the replay, buffering,
+// flushing and shutdown handling the same way whatever the encoder, and producers (the
+// orchestrator) only ever publish Events to a Stream, so adding a transport means adding an
+// Encoder and nothing else.
+type Encoder interface {
+	// ContentType is the response's Content-Type.
+	ContentType() string
+	// Start writes whatever opens a stream, given the client's reconnect delay.
+	Start(w io.Writer, retry time.Duration) error
+	// Encode writes one event of the stream streamID and returns the bytes written.
+	Encode(w io.Writer, streamID string, event Event) (int, error)
+	// Reconnect writes the advisory sent before the server closes the stream on purpose.
+	Reconnect(w io.Writer, streamID string, advisory Event, retry time.Duration) error
+	// Coalesce reports whether Message chunks may be batched into one flush.
+	Coalesce() bool
+}
+
+// SSEEncoder writes Server-Sent Events, with event data in the given Format.
+type SSEEncoder struct {
+	Format Format
+}
+
+func (SSEEncoder) ContentType() string { return "text/event-stream" }
+
+// Start sends the "retry:" field so EventSource clients wait retry before reconnecting.
+// A zero retry sends nothing.
+func (SSEEncoder) Start(w io.Writer, retry time.Duration) error {
+	if retry <= 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "retry: %d\n\n", retry.Milliseconds())
+	return err
+}
+
+func (e SSEEncoder) Encode(w io.Writer, streamID string, event Event) (int, error) {
+	return writeEvent(w, streamID, event, e.Format)
+}
+
+// Reconnect updates the client's retry interval to match the advisory before sending it.
+func (e SSEEncoder) Reconnect(w io.Writer, streamID string, advisory Event, retry time.Duration) error {
+	if _, err := fmt.Fprintf(w, "retry: %d\n", retry.Milliseconds()); err != nil {
+		return err
+	}
+	_, err := writeEvent(w, streamID, advisory, e.Format)
+	return err
+}
+
+func (SSEEncoder) Coalesce() bool { return true }
+
+// NDJSONEncoder writes one JSON envelope per line, the same envelope as FormatJSON:
+//
+//	{"v":1,"type":"Message","data":{"text":"...","final":false},"ts":"...","seq":3}
+//
+// Every line is flushed as soon as it is written, so consumers can act on each one.
+type NDJSONEncoder struct{}
+
+func (NDJSONEncoder) ContentType() string { return NDJSONContentType }
+
+// Start writes nothing: NDJSON has no reconnect hint; the Reconnect advisory carries one.
+func (NDJSONEncoder) Start(io.Writer, time.Duration) error { return nil }
+
+// Encode writes the event's envelope as one line. JSON never contains a raw newline, so
+// the framing can't be broken by the data.
+func (NDJSONEncoder) Encode(w io.Writer, _ string, event Event) (int, error) {
+	return io.WriteString(w, eventData(event, FormatJSON)+"\n")
+}
+
+func (e NDJSONEncoder) Reconnect(w io.Writer, streamID string, advisory Event, _ time.Duration) error {
+	_, err := e.Encode(w, streamID, advisory)
+	return err
+}
+
+func (NDJSONEncoder) Coalesce() bool { return false }
+
+// NegotiateEncoder picks the transport for a request: NDJSON when the client asks for
+// application/x-ndjson (in Accept, or with ?format=ndjson) ahead of text/event-stream, and SSE
+// otherwise, with the data format chosen by NegotiateFormat.
+func NegotiateEncoder(r *http.Request) Encoder {
+	if strings.EqualFold(r.URL.Query().Get("format"), "ndjson") {
+		return NDJSONEncoder{}
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			switch mediaType {
+			case NDJSONContentType:
+				return NDJSONEncoder{}
+			case "text/event-stream":
+				return SSEEncoder{Format: NegotiateFormat(r)}
+			}
+		}
+	}
+	return SSEEncoder{Format: NegotiateFormat(r)}
+}
+
+// IsStreamContentType reports whether contentType is one the stream encoders produce, i.e.
+// whether a response with it has become an event stream.
+func IsStreamContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/event-stream" || mediaType == NDJSONContentType
+}
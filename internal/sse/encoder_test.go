@@ -0,0 +1,172 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNegotiateEncoder(t *testing.T) {
+	for _, tt := range []struct {
+		target, accept string
+		want           Encoder
+	}{
+		{"/", "", SSEEncoder{Format: FormatText}},
+		{"/", "text/event-stream", SSEEncoder{Format: FormatText}},
+		{"/", "*/*", SSEEncoder{Format: FormatText}},
+		{"/", "application/x-ndjson", NDJSONEncoder{}},
+		{"/", "application/x-ndjson; charset=utf-8", NDJSONEncoder{}},
+		{"/", "application/x-ndjson, text/event-stream", NDJSONEncoder{}},
+		{"/", "text/event-stream, application/x-ndjson", SSEEncoder{Format: FormatText}},
+		{"/", "text/event-stream, application/json", SSEEncoder{Format: FormatJSON}},
+		{"/?format=ndjson", "text/event-stream", NDJSONEncoder{}},
+	} {
+		r := httptest.NewRequest("POST", tt.target, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := NegotiateEncoder(r); got != tt.want {
+			t.Errorf("%s with Accept %q: %#v, want %#v", tt.target, tt.accept, got, tt.want)
+		}
+	}
+}
+
+// ndjsonLine is one line of an NDJSON stream.
+type ndjsonLine struct {
+	V    int             `json:"v"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	TS   time.Time       `json:"ts"`
+	Seq  int64           `json:"seq"`
+}
+
+// serveAccepting serves stream to a request with the given Accept header.
+func serveAccepting(accept string, stream *Stream) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/api", nil)
+	r.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	NewHandler().ServeStream(w, r, stream, 0)
+	return w
+}
+
+func TestNDJSONFraming(t *testing.T) {
+	stream := closedStream(Started("s1"), MessageChunk("line one\nline two", false), MessageChunk("\r\n", true),
+		Error("search_unavailable", "Flight search is unavailable"), Done(DonePayload{Outcome: OutcomeError}))
+	w := serveAccepting(NDJSONContentType, stream)
+	if ct := w.Header().Get("Content-Type"); ct != NDJSONContentType {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if strings.Contains(w.Body.String(), "retry:") || strings.Contains(w.Body.String(), "data:") {
+		t.Errorf("SSE framing in an NDJSON stream:\n%s", w.Body)
+	}
+
+	// One JSON object per line, in order, whatever newlines the data holds.
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	wantTypes := []string{TypeStarted, TypeMessage, TypeMessage, TypeError, TypeDone}
+	if len(lines) != len(wantTypes) {
+		t.Fatalf("%d lines, want %d:\n%s", len(lines), len(wantTypes), w.Body)
+	}
+	parsed := make([]ndjsonLine, len(lines))
+	for i, raw := range lines {
+		if err := json.Unmarshal([]byte(raw), &parsed[i]); err != nil {
+			t.Fatalf("line %d %q: %v", i, raw, err)
+		}
+		if line := parsed[i]; line.V != 1 || line.Type != wantTypes[i] || line.Seq != int64(i+1) || line.TS.IsZero() {
+			t.Errorf("line %d = %+v", i, line)
+		}
+	}
+	var message MessagePayload
+	if err := json.Unmarshal(parsed[1].Data, &message); err != nil || message.Text != "line one\nline two" {
+		t.Errorf("first message %+v, %v", message, err)
+	}
+	var done DonePayload
+	if err := json.Unmarshal(parsed[4].Data, &done); err != nil || done.Outcome != OutcomeError {
+		t.Errorf("Done line %s", lines[4])
+	}
+}
+
+func TestSSEFramingUnchanged(t *testing.T) {
+	stream := closedStream(Started("s1"), MessageChunk("Hi", true), Done(DonePayload{Outcome: OutcomeOK}))
+	for _, accept := range []string{"text/event-stream", ""} {
+		w := serveAccepting(accept, stream)
+		if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Accept %q: Content-Type = %q", accept, ct)
+		}
+		frames := readFrames(t, w.Body)
+		if len(frames) != 3 || frames[1].Event != TypeMessage || frames[1].Data != "Hi" || frames[2].ID != EventID("s1", 3) {
+			t.Errorf("Accept %q: frames %+v", accept, frames)
+		}
+	}
+}
+
+// flushRecorder records how much of the body had been written at each flush.
+type flushRecorder struct {
+	header  http.Header
+	mu      sync.Mutex
+	body    bytes.Buffer
+	flushes []int
+}
+
+func (w *flushRecorder) Header() http.Header { return w.header }
+func (w *flushRecorder) WriteHeader(int)     {}
+
+func (w *flushRecorder) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.body.Write(p)
+}
+
+func (w *flushRecorder) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flushes = append(w.flushes, w.body.Len())
+}
+
+// flushed returns what had been flushed so far.
+func (w *flushRecorder) flushed() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.flushes) == 0 {
+		return ""
+	}
+	return w.body.String()[:w.flushes[len(w.flushes)-1]]
+}
+
+func TestNDJSONFlushesEachLine(t *testing.T) {
+	stream := newStream("s1")
+	w := &flushRecorder{header: http.Header{}}
+	r := httptest.NewRequest("POST", "/api", nil)
+	r.Header.Set("Accept", NDJSONContentType)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		NewHandler().ServeStream(w, r, stream, 0)
+	}()
+
+	// Message chunks aren't batched: each reaches the client on its own as it is published.
+	for i, word := range []string{"One ", "two ", "three"} {
+		stream.Publish(MessageChunk(word, i == 2))
+		deadline := time.Now().Add(time.Second)
+		for strings.Count(w.flushed(), "\n") != i+1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("chunk %d not flushed; flushed so far: %q", i, w.flushed())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	stream.Close()
+	<-served
+
+	scanner := bufio.NewScanner(strings.NewReader(w.flushed()))
+	for scanner.Scan() {
+		if !json.Valid(scanner.Bytes()) {
+			t.Errorf("line %q isn't JSON", scanner.Text())
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package sse
+
+import (
+	"fmt"
+	"time"
+)
+
+// Event represents a generic Server-Sent Event (SSE).
+// It has a Type (one of the Type* constants) and Data (the plain-text content).
+// Payload optionally carries a structured value (flight results, errors) that JSON clients
+// receive as the envelope's "data" instead of the text; Data remains the plain-format fallback.
+// Seq and Timestamp are assigned by Stream.Publish; Seq becomes part of the SSE id.
+// Build events with the constructors below rather than by hand so every code path
+// speaks the same vocabulary.
+type Event struct {
+	Type      string
+	Data      string
+	Payload   any
+	Seq       int64
+	Timestamp time.Time
+}
+
+// Event types. Clients may rely on these names and on the payloads documented on each constructor.
+const (
+	TypeStarted            = "Started"            // First event of a new request; names its stream
+	TypeStatus             = "Status"             // Progress of the pipeline; may be dropped for slow clients
+	TypeQueryUnderstanding = "QueryUnderstanding" // What was read from a flight question, before the search
+	TypeMessage            = "Message"            // (Part of) the answer text
+	TypeFlightResults      = "FlightResults"      // The flights a search returned
+	TypeRoutes             = "Routes"             // The routes that answer a question about where we fly
+	TypeEnrichment         = "Enrichment"         // Facts from an outside source that go with the answer
+	TypeError              = "Error"              // The request could not be served
+	TypeDone               = "Done"               // Always the last event of a stream
+	TypeReconnect          = "Reconnect"          // The server is closing the connection on purpose
+)
+
+// StartedPayload is the structured Payload of the "Started" event. StreamID is the ID to pass
+// to POST /api/cancel/{id} or GET /api/stream/{id}; it is also sent as the X-Stream-ID header.
+type StartedPayload struct {
+	StreamID string `json:"stream_id"`
+}
+
+// MessagePayload is the structured Payload of "Message" events.
+// Final is set on the last chunk of the answer.
+type MessagePayload struct {
+	Text  string `json:"text"`
+	Final bool   `json:"final"`
+}
+
+// ErrorPayload is the structured Payload of "Error" events.
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// EnrichmentPayload is the structured Payload of "Enrichment" events. Kind names the source
+// (e.g. "weather") and decides the shape of Data; Summary is the facts in one line, for display.
+type EnrichmentPayload struct {
+	Kind    string `json:"kind"`
+	Summary string `json:"summary"`
+	Data    any    `json:"data"`
+}
+
+// Confidence levels of a QueryUnderstandingPayload.
+const (
+	ConfidenceHigh = "high"
+	ConfidenceLow  = "low" // The route's direction was guessed; origin and destination may be swapped
+)
+
+// QueryUnderstandingPayload is the structured Payload of the "QueryUnderstanding" event: the
+// flight question as it is searched and logged, so a client can show it ("Madrid → Paris,
+// under €200") and the user can correct a misreading. Fields the question didn't set are
+// empty. MaxPrice is in Currency, the currency the prices are shown in.
+type QueryUnderstandingPayload struct {
+	Intent             string  `json:"intent"`
+	Origin             string  `json:"origin,omitempty"`
+	Destination        string  `json:"destination,omitempty"`
+	OriginAirport      string  `json:"origin_airport,omitempty"`
+	DestinationAirport string  `json:"destination_airport,omitempty"`
+	MaxPrice           float64 `json:"max_price,omitempty"`
+	Currency           string  `json:"currency"`
+	Passengers         int     `json:"passengers,omitempty"` // Party size the flights need seats for
+	Language           string  `json:"language"`             // Code of the answer's language, e.g. "es"
+	Confidence         string  `json:"confidence"`           // ConfidenceHigh or ConfidenceLow
+}
+
+// Outcomes reported by the Done event.
+const (
+	OutcomeOK        = "ok"
+	OutcomeError     = "error"
+	OutcomeCancelled = "cancelled" // Stopped on request (POST /api/cancel/{id})
+)
+
+// DonePayload is the structured Payload of the "Done" event that terminates every stream.
+// A client that receives it knows the answer is complete and should not reconnect.
+type DonePayload struct {
+	Outcome    string `json:"outcome"` // OutcomeOK, OutcomeError or OutcomeCancelled
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Telemetry  any    `json:"telemetry,omitempty"`
+}
+
+// ReconnectPayload is the structured Payload of the "Reconnect" advisory sent when the server
+// closes a connection on purpose. Clients should wait RetryAfterMs before reconnecting
+// with Last-Event-ID.
+type ReconnectPayload struct {
+	Reason       string `json:"reason"`
+	RetryAfterMs int64  `json:"retry_after_ms"`
+}
+
+// Started opens the events of a new request. Data is the stream ID; the JSON "data" is a StartedPayload.
+func Started(streamID string) Event {
+	return Event{Type: TypeStarted, Data: streamID, Payload: StartedPayload{StreamID: streamID}}
+}
+
+// Status reports pipeline progress. Data and the JSON "data" are the message string.
+func Status(msg string) Event {
+	return Event{Type: TypeStatus, Data: msg}
+}
+
+// QueryUnderstanding reports what was read from a flight question. Data is a one-line
+// summary, e.g. "flight: Madrid → Paris, under 200 EUR"; the JSON "data" is the payload.
+func QueryUnderstanding(understood QueryUnderstandingPayload) Event {
+	summary := understood.Intent + ":"
+	switch {
+	case understood.Origin != "" && understood.Destination != "":
+		summary += " " + understood.Origin + " → " + understood.Destination
+	case understood.Origin != "":
+		summary += " from " + understood.Origin
+	case understood.Destination != "":
+		summary += " to " + understood.Destination
+	default:
+		summary += " any route"
+	}
+	if understood.MaxPrice > 0 {
+		summary += fmt.Sprintf(", under %g %s", understood.MaxPrice, understood.Currency)
+	}
+	if understood.Passengers > 1 {
+		summary += fmt.Sprintf(", %d passengers", understood.Passengers)
+	}
+	return Event{Type: TypeQueryUnderstanding, Data: summary, Payload: understood}
+}
+
+// MessageChunk carries answer text. A complete answer is one chunk with final set;
+// a streamed answer is several chunks, the last of which has final set.
+// Data is the text; the JSON "data" is a MessagePayload.
+func MessageChunk(text string, final bool) Event {
+	return Event{Type: TypeMessage, Data: text, Payload: MessagePayload{Text: text, Final: final}}
+}
+
+// Error reports that the request failed. code is a stable machine-readable identifier
+// (e.g. "search_unavailable") and msg is shown to the user.
+// Data is msg; the JSON "data" is an ErrorPayload.
+func Error(code, msg string) Event {
+	return Event{Type: TypeError, Data: msg, Payload: ErrorPayload{Code: code, Message: msg}}
+}
+
+// FlightResults reports the flights a search returned.
+// Data is a count summary; the JSON "data" is the flights array.
+func FlightResults[T any](flights []T) Event {
+	return Event{Type: TypeFlightResults, Data: fmt.Sprintf("Found %d flights", len(flights)), Payload: flights}
+}
+
+// Routes reports the routes that answer a question about where flights go, such as the
+// destinations from a city. Data is a count summary; the JSON "data" is the routes array.
+func Routes[T any](routes []T) Event {
+	return Event{Type: TypeRoutes, Data: fmt.Sprintf("Found %d routes", len(routes)), Payload: routes}
+}
+
+// Done terminates a stream. Data is the outcome; the JSON "data" is the DonePayload.
+func Done(done DonePayload) Event {
+	return Event{Type: TypeDone, Data: done.Outcome, Payload: done}
+}
+
+// Reconnect advises the client to reconnect after retryAfter.
+// Data is the reason; the JSON "data" is a ReconnectPayload.
+func Reconnect(reason string, retryAfter time.Duration) Event {
+	return Event{Type: TypeReconnect, Data: reason, Payload: ReconnectPayload{Reason: reason, RetryAfterMs: retryAfter.Milliseconds()}}
+}
+
+// Enrichment reports facts fetched from an outside source to go with the answer, such as the
+// weather at a flight's destination. Data is the summary; the JSON "data" is an
+// EnrichmentPayload.
+func Enrichment(kind, summary string, data any) Event {
+	return Event{Type: TypeEnrichment, Data: summary, Payload: EnrichmentPayload{Kind: kind, Summary: summary, Data: data}}
+}
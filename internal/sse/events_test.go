@@ -0,0 +1,95 @@
+package sse
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestConstructorWireFormat locks down what clients receive for every constructor, in both
+// formats. Changing one of these lines breaks clients.
+func TestConstructorWireFormat(t *testing.T) {
+	type flight struct {
+		Number string `json:"flight_number"`
+	}
+	type route struct {
+		Destination string `json:"destination"`
+	}
+	ts := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		event      Event
+		text, json string
+	}{
+		{Started("s1"),
+			"event: Started\ndata: s1",
+			`{"v":1,"type":"Started","data":{"stream_id":"s1"},"ts":"2026-03-01T12:00:00Z","seq":5}`},
+		{Status("Searching flights"),
+			"event: Status\ndata: Searching flights",
+			`{"v":1,"type":"Status","data":"Searching flights","ts":"2026-03-01T12:00:00Z","seq":5}`},
+		{QueryUnderstanding(QueryUnderstandingPayload{Intent: "flight", Origin: "Madrid", Destination: "Paris", MaxPrice: 200, Currency: "EUR", Passengers: 2, Language: "en", Confidence: ConfidenceHigh}),
+			"event: QueryUnderstanding\ndata: flight: Madrid → Paris, under 200 EUR, 2 passengers",
+			`{"v":1,"type":"QueryUnderstanding","data":{"intent":"flight","origin":"Madrid","destination":"Paris","max_price":200,"currency":"EUR","passengers":2,"language":"en","confidence":"high"},"ts":"2026-03-01T12:00:00Z","seq":5}`},
+		{QueryUnderstanding(QueryUnderstandingPayload{Intent: "routes", Origin: "Madrid", Currency: "EUR", Language: "en", Confidence: ConfidenceLow}),
+			"event: QueryUnderstanding\ndata: routes: from Madrid",
+			`{"v":1,"type":"QueryUnderstanding","data":{"intent":"routes","origin":"Madrid","currency":"EUR","language":"en","confidence":"low"},"ts":"2026-03-01T12:00:00Z","seq":5}`},
+		{QueryUnderstanding(QueryUnderstandingPayload{Intent: "flight", Destination: "Paris", Currency: "USD", Language: "es", Confidence: ConfidenceHigh}),
+			"event: QueryUnderstanding\ndata: flight: to Paris",
+			`{"v":1,"type":"QueryUnderstanding","data":{"intent":"flight","destination":"Paris","currency":"USD","language":"es","confidence":"high"},"ts":"2026-03-01T12:00:00Z","seq":5}`},
+		{MessageChunk("Hello\nworld", false),
+			"event: Message\ndata: Hello\ndata: world",
+			`{"v":1,"type":"Message","data":{"text":"Hello\nworld","final":false},"ts":"2026-03-01T12:00:00Z","seq":5}`},
+		{FlightResults([]flight{{"IB101"}, {"AF202"}}),
+			"event: FlightResults\ndata: Found 2 flights",
+			`{"v":1,"type":"FlightResults","data":[{"flight_number":"IB101"},{"flight_number":"AF202"}],"ts":"2026-03-01T12:00:00Z","seq":5}`},
+		{Routes([]route{{"Paris"}}),
+			"event: Routes\ndata: Found 1 routes",
+			`{"v":1,"type":"Routes","data":[{"destination":"Paris"}],"ts":"2026-03-01T12:00:00Z","seq":5}`},
+		{Enrichment("weather", "Paris: 18°C, sunny", map[string]int{"temp_c": 18}),
+			"event: Enrichment\ndata: Paris: 18°C, sunny",
+			`{"v":1,"type":"Enrichment","data":{"kind":"weather","summary":"Paris: 18°C, sunny","data":{"temp_c":18}},"ts":"2026-03-01T12:00:00Z","seq":5}`},
+		{Error("search_unavailable", "Try again later"),
+			"event: Error\ndata: Try again later",
+			`{"v":1,"type":"Error","data":{"code":"search_unavailable","message":"Try again later"},"ts":"2026-03-01T12:00:00Z","seq":5}`},
+		{Done(DonePayload{Outcome: OutcomeOK, DurationMs: 1200}),
+			"event: Done\ndata: ok",
+			`{"v":1,"type":"Done","data":{"outcome":"ok","duration_ms":1200},"ts":"2026-03-01T12:00:00Z","seq":5}`},
+		{Reconnect("server shutting down", 3*time.Second),
+			"event: Reconnect\ndata: server shutting down",
+			`{"v":1,"type":"Reconnect","data":{"reason":"server shutting down","retry_after_ms":3000},"ts":"2026-03-01T12:00:00Z","seq":5}`},
+	} {
+		event := tt.event
+		event.Seq, event.Timestamp = 5, ts
+		for _, format := range []struct {
+			format Format
+			data   string
+		}{{FormatText, tt.text}, {FormatJSON, "event: " + event.Type + "\ndata: " + tt.json}} {
+			var b strings.Builder
+			if _, err := writeEvent(&b, "s1", event, format.format); err != nil {
+				t.Fatal(err)
+			}
+			if want := "id: s1-5\n" + format.data + "\n\n"; b.String() != want {
+				t.Errorf("%s (format %d):\n got %q\nwant %q", event.Type, format.format, b.String(), want)
+			}
+		}
+		var b strings.Builder
+		NDJSONEncoder{}.Encode(&b, "s1", event)
+		if b.String() != tt.json+"\n" {
+			t.Errorf("%s as NDJSON:\n got %q\nwant %q", event.Type, b.String(), tt.json+"\n")
+		}
+	}
+}
+
+func TestPublishStampsEvents(t *testing.T) {
+	stream := newStream("s1")
+	before := time.Now()
+	stream.Publish(Status("a"))
+	fixed := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	stream.Publish(Event{Type: TypeStatus, Data: "b", Seq: 99, Timestamp: fixed})
+	events := stream.Events()
+	if events[0].Seq != 1 || events[0].Timestamp.Before(before) {
+		t.Errorf("first event = %+v, want seq 1 stamped now", events[0])
+	}
+	if events[1].Seq != 2 || !events[1].Timestamp.Equal(fixed) {
+		t.Errorf("second event = %+v, want seq 2 with its own timestamp", events[1])
+	}
+}
@@ -1,50 +1,374 @@
 package sse
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// Format selects how event data is written on the wire.
+type Format int
+
+const (
+	// FormatText writes Data as-is (the legacy format).
+	FormatText Format = iota
+	// FormatJSON wraps every event in a versioned JSON envelope.
+	FormatJSON
 )
 
-// Event represents a generic Server-Sent Event (SSE).
-// It has a Type (e.g., "Status", "Message") and Data (the actual content).
-type Event struct {
-	Type string
-	Data string
+// tracerName identifies this package's spans; it matches the tracing package's instrumentation name.
+const tracerName = "github.com/Cris245/go-llm-chat"
+
+// envelopeVersion is the "v" of the JSON envelope; bump it on incompatible changes.
+const envelopeVersion = 1
+
+// envelope is the JSON form of an event: {"v":1,"type":"Status","data":...,"ts":"...","seq":5}.
+type envelope struct {
+	V    int       `json:"v"`
+	Type string    `json:"type"`
+	Data any       `json:"data"`
+	TS   time.Time `json:"ts"`
+	Seq  int64     `json:"seq"`
+}
+
+// NegotiateFormat picks the wire format for a request. Clients opt into JSON envelopes with
+// ?format=json or by listing application/json in Accept (e.g. "text/event-stream, application/json");
+// everyone else keeps the plain-text format.
+func NegotiateFormat(r *http.Request) Format {
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "json":
+		return FormatJSON
+	case "text":
+		return FormatText
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mediaType == "application/json" {
+				return FormatJSON
+			}
+		}
+	}
+	return FormatText
 }
 
+// Defaults for Handler's slow-client protection and reconnect hint.
+const (
+	DefaultBufferSize    = 256
+	DefaultWriteTimeout  = 30 * time.Second
+	DefaultRetryInterval = 3 * time.Second
+)
+
 // Struct to manage SSE connections.
-type Handler struct{}
+// The fields protect the server from slow or stalled clients; set them before serving.
+type Handler struct {
+	// BufferSize is how many events a connection may fall behind the live stream.
+	// Beyond it, pending Status events are dropped (only the latest is kept), and if the
+	// client is still too far behind its connection is closed. Message, Error and Done
+	// events are never dropped. Zero disables the limit.
+	BufferSize int
+	// WriteTimeout bounds each write to the client; a client that stops reading is
+	// disconnected once it expires. Zero disables the deadline.
+	WriteTimeout time.Duration
+	// RetryInterval is sent as the SSE "retry:" field at the start of every stream, telling
+	// EventSource clients how long to wait before reconnecting. Zero omits the field.
+	RetryInterval time.Duration
+	// CoalesceWindow and CoalesceBytes batch Message chunks: they are flushed once the oldest
+	// has waited CoalesceWindow or CoalesceBytes have accumulated, whichever comes first.
+	// Other event types always flush immediately. A zero window flushes every event.
+	CoalesceWindow time.Duration
+	CoalesceBytes  int
+	// OnClose, if set, is called with how each connection went once ServeStream is done with
+	// it, e.g. to record metrics.
+	OnClose func(stats StreamStats)
+
+	mu        sync.Mutex
+	closing   chan struct{}    // Closed by Shutdown
+	reconnect ReconnectPayload // Advisory sent to connections closed by Shutdown
+}
 
-// NewHandler creates and returns a new instance of SSEHandler.
+// NewHandler creates and returns a new instance of SSEHandler with the default limits.
 func NewHandler() *Handler {
-	return &Handler{}
+	return &Handler{
+		BufferSize:     DefaultBufferSize,
+		WriteTimeout:   DefaultWriteTimeout,
+		RetryInterval:  DefaultRetryInterval,
+		CoalesceWindow: DefaultCoalesceWindow,
+		CoalesceBytes:  DefaultCoalesceBytes,
+	}
 }
 
-// This function is called by the Go HTTP server when a request comes to the SSE path.
-func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, eventChan <-chan Event) {
-	w.Header().Set("Content-Type", "text/event-stream")
+// closingChan returns the channel closed by Shutdown, creating it on first use
+// so a zero Handler works too.
+func (h *Handler) closingChan() chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closing == nil {
+		h.closing = make(chan struct{})
+	}
+	return h.closing
+}
+
+// Shutdown makes every open and future connection send a "Reconnect" advisory with a
+// retry hint of retryAfter and then close. The streams themselves keep running, so a client
+// that reconnects with Last-Event-ID (to this server or a replica) resumes where it left off.
+func (h *Handler) Shutdown(reason string, retryAfter time.Duration) {
+	closing := h.closingChan()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-closing:
+		return // Already shutting down.
+	default:
+	}
+	h.reconnect = ReconnectPayload{Reason: reason, RetryAfterMs: retryAfter.Milliseconds()}
+	close(closing)
+}
+
+// sendReconnect writes the shutdown advisory. The event has no id, so the client's
+// Last-Event-ID still points at the last real event.
+func (h *Handler) sendReconnect(w io.Writer, rc *http.ResponseController, streamID string, enc Encoder) {
+	h.mu.Lock()
+	advisory := h.reconnect
+	h.mu.Unlock()
+	retry := time.Duration(advisory.RetryAfterMs) * time.Millisecond
+	event := Reconnect(advisory.Reason, retry)
+	event.Timestamp = time.Now()
+	enc.Reconnect(w, streamID, event, retry)
+	rc.Flush()
+}
+
+// ServeStream writes the events of stream to the client, starting after sequence number after
+// (0 for a new connection, or the sequence from Last-Event-ID when a client reconnects).
+// It replays buffered events first, then follows live events until the stream finishes or the client leaves.
+// The transport (SSE or NDJSON) and data format are chosen per request by NegotiateEncoder.
+func (h *Handler) ServeStream(w http.ResponseWriter, r *http.Request, stream *Stream, after int64) {
+	enc := NegotiateEncoder(r)
+
+	w.Header().Set("Content-Type", enc.ContentType())
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("X-Stream-ID", stream.ID())
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming not supported by this HTTP server", http.StatusInternalServerError)
+	if _, ok := w.(http.Flusher); !ok {
+		httpapi.Write(w, r, &httpapi.Error{Status: http.StatusInternalServerError, Code: httpapi.CodeStreamingUnsupported, Message: "Streaming not supported by this HTTP server"})
 		return
 	}
+	rc := http.NewResponseController(w)
 
+	// The write loop is traced as one span recording how much was sent and why it ended;
+	// the same figures go to the access log through the request's StreamStats.
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "sse.write_loop",
+		trace.WithAttributes(attribute.String("sse.stream_id", stream.ID()), attribute.Int64("sse.after", after)))
+	// The stats also go to a log line of their own, written when the connection closes, and
+	// to OnClose.
+	stats := statsFrom(r.Context())
+	stats.Streamed = true
+	start := time.Now()
+	sent, sentBytes, endReason := 0, 0, EndDone
+	defer func() {
+		span.SetAttributes(attribute.Int("sse.events", sent), attribute.Int("sse.bytes", sentBytes), attribute.String("sse.end_reason", endReason))
+		span.End()
+		stats.Events, stats.Bytes, stats.EndReason, stats.Duration = sent, sentBytes, endReason, time.Since(start)
+		slog.InfoContext(r.Context(), "SSE stream closed", "stream", stream.ID(), "end_reason", endReason,
+			"events", sent, "bytes", sentBytes, "duration", stats.Duration, "outcome", stats.Outcome)
+		if h.OnClose != nil {
+			h.OnClose(*stats)
+		}
+	}()
+
+	closing := h.closingChan()
+	select {
+	case <-closing:
+		endReason = EndShutdown
+		h.sendReconnect(w, rc, stream.ID(), enc)
+		return
+	default:
+	}
+	enc.Start(w, h.RetryInterval)
+
+	coalesce := &coalescer{window: h.CoalesceWindow, maxBytes: h.CoalesceBytes}
+	if !enc.Coalesce() {
+		coalesce.window = 0
+	}
+	flush := func() bool {
+		if err := rc.Flush(); err != nil {
+			if endReason = writeEndReason(r.Context(), err, EndFlushFailed); endReason != EndClientDisconnected {
+				slog.WarnContext(r.Context(), "SSE flush failed", "stream", stream.ID(), "error", err)
+			}
+			return false
+		}
+		coalesce.flushed()
+		return true
+	}
+
+	// The first pass replays a reconnecting client's backlog in full; the buffer limit
+	// applies to how far behind the live events the client falls afterwards.
+	catchingUp := true
 	for {
+		events, done, changed := stream.since(after)
+		if len(events) > 0 {
+			last := events[len(events)-1].Seq
+			if !catchingUp && h.BufferSize > 0 && len(events) > h.BufferSize {
+				events = dropStatus(events)
+				if len(events) > h.BufferSize {
+					slog.WarnContext(r.Context(), "SSE client too far behind; closing connection", "stream", stream.ID(), "behind", len(events), "limit", h.BufferSize)
+					endReason = EndTooFarBehind
+					return
+				}
+			}
+			if h.WriteTimeout > 0 {
+				// Not every ResponseWriter supports deadlines (e.g. in tests); streaming still works without one.
+				rc.SetWriteDeadline(time.Now().Add(h.WriteTimeout))
+			}
+			flushNow := false
+			for _, event := range events {
+				n, err := enc.Encode(w, stream.ID(), event)
+				if err != nil {
+					if endReason = writeEndReason(r.Context(), err, EndWriteFailed); endReason != EndClientDisconnected {
+						slog.WarnContext(r.Context(), "SSE write failed", "stream", stream.ID(), "error", err)
+					}
+					return
+				}
+				if sent == 0 {
+					stats.FirstEventAt = time.Now()
+				}
+				if done, ok := event.Payload.(DonePayload); ok {
+					stats.Outcome = done.Outcome
+				}
+				sent, sentBytes = sent+1, sentBytes+n
+				if coalesce.wrote(event.Type, n) {
+					flushNow = true
+				}
+			}
+			after = last
+			if flushNow || done {
+				if !flush() {
+					return
+				}
+			}
+		}
+		catchingUp = false
+		if done {
+			// Nothing is published after Close, so pending writes were flushed above.
+			return
+		}
+
+		timeout, stopTimer := coalesce.deadline()
 		select {
-		case event, ok := <-eventChan:
-			if !ok {
+		case <-changed:
+		case <-timeout:
+			if !flush() {
 				return
 			}
-			fmt.Fprintf(w, "event: %s\n", event.Type)
-			fmt.Fprintf(w, "data: %s\n\n", event.Data)
-			flusher.Flush()
+		case <-closing:
+			endReason = EndShutdown
+			h.sendReconnect(w, rc, stream.ID(), enc)
+			return
 		case <-r.Context().Done():
-			fmt.Println("Client disconnected.")
+			endReason = EndClientDisconnected
 			return
 		}
+		stopTimer()
+	}
+}
+
+// writeEndReason tells why a write or flush to the client failed with err: the client went
+// away (its request context is cancelled, or the connection was reset or closed under the
+// write), or failed, e.g. because the write deadline passed on a client that stopped reading.
+func writeEndReason(ctx context.Context, err error, failed string) string {
+	if ctx.Err() != nil || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, net.ErrClosed) {
+		return EndClientDisconnected
+	}
+	return failed
+}
+
+// dropStatus removes the Status events from a backlog, keeping the last event if it is one
+// so the client still learns the current phase. Status events are progress hints whose
+// information is superseded by whatever follows them.
+func dropStatus(events []Event) []Event {
+	kept := make([]Event, 0, len(events))
+	for i, event := range events {
+		if event.Type != TypeStatus || i == len(events)-1 {
+			kept = append(kept, event)
+		}
+	}
+	return kept
+}
+
+// lineBreaks normalizes every SSE line terminator (CRLF, bare CR, LF) to LF.
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// writeEvent writes one event in SSE wire format.
+// The spec treats CR, LF and CRLF all as line terminators, so multi-line data is split
+// into one "data:" line per line; clients join them back with "\n". A bare CR would
+// otherwise end the field early and silently drop the rest of the line.
+func writeEvent(w io.Writer, streamID string, event Event, format Format) (int, error) {
+	var b strings.Builder
+	if event.Seq > 0 {
+		b.WriteString("id: " + EventID(streamID, event.Seq) + "\n")
+	}
+	if event.Type != "" {
+		// The event name must stay on a single line.
+		b.WriteString("event: " + strings.ReplaceAll(lineBreaks.Replace(event.Type), "\n", " ") + "\n")
+	}
+	for _, line := range strings.Split(lineBreaks.Replace(eventData(event, format)), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return io.WriteString(w, b.String())
+}
+
+// WriteEvent writes one event outside a Stream, in the encoding the request negotiated. It is for
+// last-ditch messages on a connection whose stream can no longer be used, such as after a panic;
+// the event has no ID, so a reconnecting client's Last-Event-ID is unaffected.
+func WriteEvent(w io.Writer, r *http.Request, event Event) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	_, err := NegotiateEncoder(r).Encode(w, "", event)
+	return err
+}
+
+// MarshalEvent returns the JSON envelope of an event, as JSON-mode clients receive it.
+func MarshalEvent(event Event) json.RawMessage {
+	return json.RawMessage(eventData(event, FormatJSON))
+}
+
+// eventData renders the data field of an event in the given format.
+// In the plain format a payload-only event falls back to the payload's JSON.
+func eventData(event Event, format Format) string {
+	if format == FormatJSON {
+		env := envelope{V: envelopeVersion, Type: event.Type, Data: event.Data, TS: event.Timestamp.UTC(), Seq: event.Seq}
+		if event.Payload != nil {
+			env.Data = event.Payload
+		}
+		b, err := json.Marshal(env)
+		if err != nil {
+			// Payloads are plain data types, so this only happens on a programming error; keep the text.
+			env.Data = event.Data
+			b, _ = json.Marshal(env)
+		}
+		return string(b)
+	}
+	if event.Data == "" && event.Payload != nil {
+		if b, err := json.Marshal(event.Payload); err == nil {
+			return string(b)
+		}
 	}
+	return event.Data
 }
@@ -0,0 +1,597 @@
+package sse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// closedStream returns a finished stream holding events.
+func closedStream(events ...Event) *Stream {
+	stream := newStream("s1")
+	for _, event := range events {
+		stream.Publish(event)
+	}
+	stream.Close()
+	return stream
+}
+
+// serve runs h.ServeStream for stream through a recorder and returns what the client read.
+func serve(t *testing.T, h *Handler, target string, stream *Stream, after int64) []Frame {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeStream(w, httptest.NewRequest("GET", target, nil), stream, after)
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if acao := w.Header().Get("Access-Control-Allow-Origin"); acao != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q; CORS is the middleware's job", acao)
+	}
+	return readFrames(t, w.Body)
+}
+
+// readFrames parses every event in r.
+func readFrames(t *testing.T, r io.Reader) []Frame {
+	t.Helper()
+	var frames []Frame
+	reader := NewReader(r)
+	for {
+		frame, err := reader.Next()
+		if err == io.EOF {
+			return frames
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func TestMultiLineDataSurvivesFraming(t *testing.T) {
+	for _, tt := range []struct {
+		name, data, want string
+	}{
+		{"single line", "Hello", "Hello"},
+		{"paragraphs", "First paragraph.\n\nSecond paragraph.\n- a\n- b", "First paragraph.\n\nSecond paragraph.\n- a\n- b"},
+		{"CRLF", "one\r\ntwo\r\n\r\nthree", "one\ntwo\n\nthree"},
+		{"bare CR", "one\rtwo", "one\ntwo"},
+		{"mixed", "a\r\nb\rc\nd", "a\nb\nc\nd"},
+		{"trailing newline", "done\n", "done\n"},
+		{"looks like a field", "x\nevent: Done\nid: 99\n: comment", "x\nevent: Done\nid: 99\n: comment"},
+	} {
+		frames := serve(t, NewHandler(), "/", closedStream(MessageChunk(tt.data, true), Done(DonePayload{Outcome: OutcomeOK})), 0)
+		if len(frames) != 2 {
+			t.Errorf("%s: got %d events, want 2: %+v", tt.name, len(frames), frames)
+			continue
+		}
+		if frames[0].Event != TypeMessage || frames[0].Data != tt.want {
+			t.Errorf("%s: read %q %q, want %q", tt.name, frames[0].Event, frames[0].Data, tt.want)
+		}
+		if frames[1].Event != TypeDone {
+			t.Errorf("%s: second event = %+v, want Done", tt.name, frames[1])
+		}
+	}
+}
+
+func TestWriteEventFraming(t *testing.T) {
+	var b strings.Builder
+	if _, err := writeEvent(&b, "s1", Event{Type: TypeMessage, Data: "a\r\nb\rc", Seq: 3}, FormatText); err != nil {
+		t.Fatal(err)
+	}
+	want := "id: s1-3\nevent: Message\ndata: a\ndata: b\ndata: c\n\n"
+	if b.String() != want {
+		t.Errorf("wrote %q, want %q", b.String(), want)
+	}
+
+	// A line break in the event name can't start a new field.
+	b.Reset()
+	writeEvent(&b, "", Event{Type: "Bad\r\nid: 7", Data: "x"}, FormatText)
+	if want := "event: Bad id: 7\ndata: x\n\n"; b.String() != want {
+		t.Errorf("wrote %q, want %q", b.String(), want)
+	}
+}
+
+func TestMultiLineDataInJSONEnvelope(t *testing.T) {
+	frames := serve(t, NewHandler(), "/?format=json", closedStream(MessageChunk("one\ntwo\r\nthree", true)), 0)
+	if len(frames) != 1 {
+		t.Fatalf("got %d events, want 1", len(frames))
+	}
+	env, err := ParseEnvelope(frames[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"text":"one\ntwo\r\nthree","final":true}`; string(env.Data) != want {
+		t.Errorf("data = %s, want %s", env.Data, want)
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	for _, tt := range []struct {
+		target, accept string
+		want           Format
+	}{
+		{"/", "", FormatText},
+		{"/", "text/event-stream", FormatText},
+		{"/?format=json", "", FormatJSON},
+		{"/?format=JSON", "", FormatJSON},
+		{"/", "text/event-stream, application/json", FormatJSON},
+		{"/", "text/event-stream, application/json;q=0.9", FormatJSON},
+		{"/?format=text", "application/json", FormatText},
+		{"/?format=xml", "", FormatText},
+	} {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := NegotiateFormat(r); got != tt.want {
+			t.Errorf("%s with Accept %q: format %v, want %v", tt.target, tt.accept, got, tt.want)
+		}
+	}
+}
+
+type testFlight struct {
+	Number string  `json:"flight_number"`
+	Price  float64 `json:"price"`
+}
+
+func TestJSONEnvelopeRoundTrip(t *testing.T) {
+	flights := []testFlight{{"IB101", 120}, {"AF202", 95.5}}
+	events := []Event{
+		Started("s1"),
+		Status("Searching flights"),
+		FlightResults(flights),
+		MessageChunk("FL101\nis cheapest", true),
+		Error("search_unavailable", "Try again later"),
+		Done(DonePayload{Outcome: OutcomeError, Error: "boom", DurationMs: 42}),
+	}
+	frames := serve(t, NewHandler(), "/?format=json", closedStream(events...), 0)
+	if len(frames) != len(events) {
+		t.Fatalf("got %d events, want %d", len(frames), len(events))
+	}
+
+	envs := make([]Envelope, len(frames))
+	for i, frame := range frames {
+		env, err := ParseEnvelope(frame.Data)
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if env.V != 1 || env.Type != events[i].Type || env.Type != frame.Event || env.Seq != int64(i+1) || env.TS.IsZero() || env.TS.Location() != time.UTC {
+			t.Errorf("event %d envelope = %+v", i, env)
+		}
+		envs[i] = env
+	}
+	decode := func(i int, v any) {
+		t.Helper()
+		if err := json.Unmarshal(envs[i].Data, v); err != nil {
+			t.Fatalf("event %d data %s: %v", i, envs[i].Data, err)
+		}
+	}
+
+	var started StartedPayload
+	decode(0, &started)
+	var status string
+	decode(1, &status)
+	var gotFlights []testFlight
+	decode(2, &gotFlights)
+	var message MessagePayload
+	decode(3, &message)
+	var errPayload ErrorPayload
+	decode(4, &errPayload)
+	var done DonePayload
+	decode(5, &done)
+	if started.StreamID != "s1" || status != "Searching flights" || !reflect.DeepEqual(gotFlights, flights) ||
+		message != (MessagePayload{Text: "FL101\nis cheapest", Final: true}) ||
+		errPayload != (ErrorPayload{Code: "search_unavailable", Message: "Try again later"}) ||
+		done.Outcome != OutcomeError || done.Error != "boom" || done.DurationMs != 42 {
+		t.Errorf("decoded %+v, %q, %+v, %+v, %+v, %+v", started, status, gotFlights, message, errPayload, done)
+	}
+}
+
+func TestTextFormatKeepsLegacyData(t *testing.T) {
+	frames := serve(t, NewHandler(), "/", closedStream(
+		FlightResults([]testFlight{{"IB101", 120}}),
+		Error("search_unavailable", "Try again later"),
+		Event{Type: TypeStatus, Payload: map[string]int{"n": 1}}, // No text: the payload's JSON
+	), 0)
+	want := []string{"Found 1 flights", "Try again later", `{"n":1}`}
+	if len(frames) != len(want) {
+		t.Fatalf("got %d events, want %d", len(frames), len(want))
+	}
+	for i, frame := range frames {
+		if frame.Data != want[i] {
+			t.Errorf("event %d data = %q, want %q", i, frame.Data, want[i])
+		}
+	}
+}
+
+func TestParseEnvelopeRejects(t *testing.T) {
+	for _, data := range []string{"plain text", `{"v":2,"type":"Status","data":"x"}`, `{"type":"Status"}`} {
+		if _, err := ParseEnvelope(data); err == nil {
+			t.Errorf("ParseEnvelope(%q) succeeded", data)
+		}
+	}
+}
+
+func TestDropStatus(t *testing.T) {
+	events := []Event{Status("a"), MessageChunk("x", false), Status("b"), Error("e", "oops"), Status("c")}
+	var got []string
+	for _, event := range dropStatus(events) {
+		got = append(got, event.Data)
+	}
+	if want := "x oops c"; strings.Join(got, " ") != want {
+		t.Errorf("kept %v, want %s", got, want)
+	}
+}
+
+// gateWriter is a ResponseWriter for a client that stops reading: once closed, its writes
+// block until the gate opens again or the write deadline passes.
+type gateWriter struct {
+	header  http.Header
+	blocked chan struct{} // Receives when a write starts waiting at the gate
+
+	mu       sync.Mutex
+	open     chan struct{} // Closed while writes go through
+	deadline time.Time
+	body     bytes.Buffer
+}
+
+func newGateWriter() *gateWriter {
+	return &gateWriter{header: http.Header{}, blocked: make(chan struct{}, 1), open: make(chan struct{})}
+}
+
+func (w *gateWriter) Header() http.Header { return w.header }
+func (w *gateWriter) WriteHeader(int)     {}
+func (w *gateWriter) Flush()              {}
+
+func (w *gateWriter) SetWriteDeadline(deadline time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.deadline = deadline
+	return nil
+}
+
+func (w *gateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	open, deadline := w.open, w.deadline
+	w.mu.Unlock()
+	select {
+	case <-open:
+	default:
+		select {
+		case w.blocked <- struct{}{}:
+		default:
+		}
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			expired = time.After(time.Until(deadline))
+		}
+		select {
+		case <-open:
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.body.Write(p)
+}
+
+// release opens the gate.
+func (w *gateWriter) release() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	close(w.open)
+}
+
+// text returns what went through the gate so far.
+func (w *gateWriter) text() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.body.String()
+}
+
+// frames parses what went through the gate.
+func (w *gateWriter) frames(t *testing.T) []Frame {
+	t.Helper()
+	return readFrames(t, strings.NewReader(w.text()))
+}
+
+// serveAsync runs h.ServeStream on w in the background and returns the connection's stats once
+// it is done.
+func serveAsync(h *Handler, w http.ResponseWriter, stream *Stream) <-chan StreamStats {
+	done := make(chan StreamStats, 1)
+	h.OnClose = func(stats StreamStats) { done <- stats }
+	go h.ServeStream(w, httptest.NewRequest("GET", "/", nil), stream, 0)
+	return done
+}
+
+// waitStats waits for a connection served by serveAsync to end.
+func waitStats(t *testing.T, done <-chan StreamStats) StreamStats {
+	t.Helper()
+	select {
+	case stats := <-done:
+		return stats
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler didn't return")
+		return StreamStats{}
+	}
+}
+
+// lagHandler returns a handler allowing a lag of two events, which writes and flushes every
+// event as it comes.
+func lagHandler() *Handler {
+	return &Handler{BufferSize: 2}
+}
+
+func TestSlowClientStatusDropped(t *testing.T) {
+	w, stream := newGateWriter(), newStream("s1")
+	done := serveAsync(lagHandler(), w, stream)
+	stream.Publish(MessageChunk("first ", false))
+	<-w.blocked
+	// While the client is stuck, progress piles up past the limit; only the latest matters.
+	for i := range 4 {
+		stream.Publish(Status(fmt.Sprint("step ", i)))
+	}
+	stream.Publish(MessageChunk("second", true))
+	stream.Publish(Status("latest"))
+	stream.Close()
+	w.release()
+
+	if stats := waitStats(t, done); stats.EndReason != EndDone {
+		t.Errorf("end reason %q, want %q", stats.EndReason, EndDone)
+	}
+	var got []string
+	for _, frame := range w.frames(t) {
+		got = append(got, frame.Data)
+	}
+	if want := "first |second|latest"; strings.Join(got, "|") != want {
+		t.Errorf("client got %q, want %q", got, want)
+	}
+}
+
+func TestSlowClientTooFarBehind(t *testing.T) {
+	w, stream := newGateWriter(), newStream("s1")
+	done := serveAsync(lagHandler(), w, stream)
+	stream.Publish(MessageChunk("first ", false))
+	<-w.blocked
+	for i := range 3 {
+		stream.Publish(MessageChunk(fmt.Sprint(i), false))
+	}
+	stream.Publish(Done(DonePayload{Outcome: OutcomeOK}))
+	stream.Close()
+	w.release()
+
+	if stats := waitStats(t, done); stats.EndReason != EndTooFarBehind || stats.Events != 1 {
+		t.Errorf("stats %+v, want the connection closed as too far behind after one event", stats)
+	}
+}
+
+func TestReplayNotLimited(t *testing.T) {
+	// A reconnecting client's backlog is replayed in full, however long.
+	events := make([]Event, 10)
+	for i := range events {
+		events[i] = MessageChunk(fmt.Sprint(i), i == len(events)-1)
+	}
+	frames := serve(t, lagHandler(), "/", closedStream(events...), 0)
+	if len(frames) != len(events) {
+		t.Errorf("replayed %d events, want %d", len(frames), len(events))
+	}
+}
+
+func TestStalledClientDoesNotBlockProducer(t *testing.T) {
+	w, stream := newGateWriter(), newStream("s1")
+	h := lagHandler()
+	h.BufferSize = DefaultBufferSize
+	h.WriteTimeout = 20 * time.Millisecond
+	done := serveAsync(h, w, stream)
+
+	// The producer sends on an unbuffered channel, like the orchestrator, and must finish
+	// although the client reads nothing.
+	events := make(chan Event)
+	piped := make(chan struct{})
+	go func() {
+		stream.Pipe(events)
+		close(piped)
+	}()
+	for i := range 1000 {
+		events <- MessageChunk(fmt.Sprint(i), false)
+	}
+	events <- Done(DonePayload{Outcome: OutcomeOK})
+	close(events)
+	select {
+	case <-piped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the producer blocked on a stalled client")
+	}
+
+	if stats := waitStats(t, done); stats.EndReason != EndWriteFailed {
+		t.Errorf("end reason %q, want %q once the write deadline passed", stats.EndReason, EndWriteFailed)
+	}
+	if n, _ := stream.Progress(); n != 1001 {
+		t.Errorf("%d events buffered, want all 1001 for a reconnect", n)
+	}
+}
+
+func TestRetryHintAtStart(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewHandler().ServeStream(w, httptest.NewRequest("GET", "/", nil), closedStream(Status("x")), 0)
+	if !strings.HasPrefix(w.Body.String(), "retry: 3000\n\n") {
+		t.Errorf("stream starts %q, want the default retry hint", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	(&Handler{}).ServeStream(w, httptest.NewRequest("GET", "/", nil), closedStream(Status("x")), 0)
+	if strings.Contains(w.Body.String(), "retry:") {
+		t.Errorf("stream %q has a retry hint with none configured", w.Body.String())
+	}
+}
+
+func TestShutdownSendsReconnectAdvisory(t *testing.T) {
+	w, stream := newGateWriter(), newStream("s1")
+	h := &Handler{RetryInterval: time.Second}
+	done := serveAsync(h, w, stream)
+	stream.Publish(MessageChunk("partial", false))
+	<-w.blocked
+	w.release()
+	// The answer is still being written when the server starts shutting down.
+	for deadline := time.Now().Add(5 * time.Second); !strings.Contains(w.text(), "partial"); {
+		if time.Now().After(deadline) {
+			t.Fatal("the first event wasn't written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	h.Shutdown("server shutting down", 5*time.Second)
+
+	if stats := waitStats(t, done); stats.EndReason != EndShutdown {
+		t.Errorf("end reason %q, want %q", stats.EndReason, EndShutdown)
+	}
+	reader := NewReader(strings.NewReader(w.text()))
+	var frames []Frame
+	for {
+		frame, err := reader.Next()
+		if err != nil {
+			break
+		}
+		frames = append(frames, frame)
+	}
+	if len(frames) != 2 || frames[1].Event != TypeReconnect || frames[1].Data != "server shutting down" {
+		t.Fatalf("client got %+v, want the event and then the advisory", frames)
+	}
+	// The advisory raises the retry hint and leaves Last-Event-ID at the last real event.
+	if reader.Retry() != 5*time.Second || frames[1].ID != EventID("s1", 1) {
+		t.Errorf("after the advisory retry = %v, Last-Event-ID = %q", reader.Retry(), frames[1].ID)
+	}
+
+	// New connections get the advisory at once, and the stream itself is still there to resume.
+	stream.Publish(MessageChunk(" answer", true))
+	stream.Close()
+	frames = serve(t, h, "/?format=json", stream, 1)
+	if len(frames) != 1 || frames[0].Event != TypeReconnect {
+		t.Fatalf("connection after shutdown got %+v, want only the advisory", frames)
+	}
+	env, err := ParseEnvelope(frames[0].Data)
+	var advisory ReconnectPayload
+	if err == nil {
+		err = json.Unmarshal(env.Data, &advisory)
+	}
+	if err != nil || advisory != (ReconnectPayload{Reason: "server shutting down", RetryAfterMs: 5000}) {
+		t.Errorf("advisory = %+v, %v", advisory, err)
+	}
+	if events := stream.Events(); len(events) != 2 {
+		t.Errorf("stream has %d events, want both for a resume", len(events))
+	}
+}
+
+// failWriter is a ResponseWriter for a connection that breaks: its writes fail with writeErr
+// and its flushes with flushErr, when set.
+type failWriter struct {
+	httptest.ResponseRecorder
+	writeErr, flushErr error
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.writeErr != nil {
+		return 0, w.writeErr
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func (w *failWriter) WriteString(s string) (int, error) { return w.Write([]byte(s)) }
+
+func (w *failWriter) FlushError() error { return w.flushErr }
+
+func TestEndReasons(t *testing.T) {
+	reset := &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.ECONNRESET)}
+	for _, tt := range []struct {
+		name               string
+		target             string
+		writeErr, flushErr error
+		want               string
+	}{
+		{"done", "/", nil, nil, EndDone},
+		{"done over NDJSON", "/?format=ndjson", nil, nil, EndDone},
+		{"connection reset", "/", reset, nil, EndClientDisconnected},
+		{"broken pipe", "/?format=ndjson", syscall.EPIPE, nil, EndClientDisconnected},
+		{"connection closed", "/", net.ErrClosed, nil, EndClientDisconnected},
+		{"write deadline", "/", os.ErrDeadlineExceeded, nil, EndWriteFailed},
+		{"write error", "/?format=ndjson", errors.New("proxy buffer full"), nil, EndWriteFailed},
+		{"flush reset", "/", nil, reset, EndClientDisconnected},
+		{"flush error", "/", nil, errors.New("proxy buffer full"), EndFlushFailed},
+	} {
+		var stats StreamStats
+		h := &Handler{OnClose: func(s StreamStats) { stats = s }}
+		w := &failWriter{ResponseRecorder: *httptest.NewRecorder(), writeErr: tt.writeErr, flushErr: tt.flushErr}
+		h.ServeStream(w, httptest.NewRequest("GET", tt.target, nil), closedStream(Status("x"), Done(DonePayload{Outcome: OutcomeOK})), 0)
+		if stats.EndReason != tt.want {
+			t.Errorf("%s: end reason %q, want %q", tt.name, stats.EndReason, tt.want)
+		}
+		// Events count as sent once written, even if the flush then fails.
+		if wantEvents := map[bool]int{true: 0, false: 2}[tt.writeErr != nil]; stats.Events != wantEvents {
+			t.Errorf("%s: %d events, want %d", tt.name, stats.Events, wantEvents)
+		}
+	}
+}
+
+func TestClientDisconnectWhileWaiting(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	stream := newStream("s1")
+	stream.Publish(Status("Searching flights"))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan StreamStats, 1)
+	h := &Handler{OnClose: func(stats StreamStats) { done <- stats }}
+	w := httptest.NewRecorder()
+	go h.ServeStream(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx), stream, 0)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	// The client going away between events isn't a failure, and the close is logged once.
+	stats := waitStats(t, done)
+	if stats.EndReason != EndClientDisconnected || stats.Events != 1 || stats.Duration < 20*time.Millisecond {
+		t.Errorf("stats %+v", stats)
+	}
+	if n := strings.Count(logs.String(), `msg="SSE stream closed"`); n != 1 || !strings.Contains(logs.String(), "end_reason=client_disconnected events=1") {
+		t.Errorf("logs:\n%s", logs.String())
+	}
+	if strings.Contains(logs.String(), "level=WARN") {
+		t.Errorf("a disconnect logged a warning:\n%s", logs.String())
+	}
+}
+
+func TestWriteEndReason(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, tt := range []struct {
+		ctx  context.Context
+		err  error
+		want string
+	}{
+		{context.Background(), syscall.EPIPE, EndClientDisconnected},
+		{context.Background(), fmt.Errorf("write: %w", syscall.ECONNRESET), EndClientDisconnected},
+		{context.Background(), net.ErrClosed, EndClientDisconnected},
+		{cancelled, os.ErrDeadlineExceeded, EndClientDisconnected}, // The request ended first
+		{context.Background(), os.ErrDeadlineExceeded, EndWriteFailed},
+		{context.Background(), io.ErrShortWrite, EndWriteFailed},
+	} {
+		if got := writeEndReason(tt.ctx, tt.err, EndWriteFailed); got != tt.want {
+			t.Errorf("writeEndReason(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package sse
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frame is one event as a client reads it off the wire, before any interpretation of its data.
+type Frame struct {
+	ID    string // The last event ID seen, as EventSource reports it (may come from an earlier frame)
+	Event string // The event name; empty means the SSE default, "message"
+	Data  string // The data lines joined with "\n"
+}
+
+// Reader parses an SSE stream (the client side of Handler) following the WHATWG
+// EventSource rules: fields are split at the first colon, one space after it is dropped,
+// comment lines start with ":", CR, LF and CRLF all end lines, and events with no data
+// are not dispatched.
+type Reader struct {
+	r           *bufio.Reader
+	lastEventID string
+	retry       time.Duration
+	afterCR     bool // The previous line ended with CR, so a leading LF is part of its terminator
+}
+
+// NewReader returns a Reader that parses events from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// LastEventID returns the ID to send as Last-Event-ID when reconnecting.
+func (r *Reader) LastEventID() string {
+	return r.lastEventID
+}
+
+// Retry returns the reconnect delay the server asked for with "retry:", or 0 if it sent none.
+func (r *Reader) Retry() time.Duration {
+	return r.retry
+}
+
+// Next returns the next dispatched event. At the end of the stream it returns io.EOF;
+// an event cut off by the end of the stream is discarded, as EventSource does.
+func (r *Reader) Next() (Frame, error) {
+	var frame Frame
+	var data strings.Builder
+	hasData := false
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return Frame{}, err
+		}
+		if line == "" {
+			if !hasData {
+				frame.Event = "" // Nothing to dispatch; the event name doesn't carry over.
+				continue
+			}
+			frame.ID = r.lastEventID
+			frame.Data = data.String()
+			return frame, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue // Comment, e.g. a keep-alive.
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			frame.Event = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				r.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				r.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// readLine returns the next line without its terminator (CRLF, LF or a lone CR).
+func (r *Reader) readLine() (string, error) {
+	var b strings.Builder
+	for {
+		c, err := r.r.ReadByte()
+		if err != nil {
+			return "", err // An unterminated final line is dropped along with its event.
+		}
+		afterCR := r.afterCR
+		r.afterCR = false
+		switch c {
+		case '\n':
+			if afterCR && b.Len() == 0 {
+				continue // The LF of a CRLF whose CR already ended the previous line.
+			}
+			return b.String(), nil
+		case '\r':
+			// Don't wait for a possible LF: on a live stream it may not have arrived yet.
+			r.afterCR = true
+			return b.String(), nil
+		}
+		b.WriteByte(c)
+	}
+}
+
+// Envelope is a decoded JSON-format event (see FormatJSON). Data is kept raw so callers can
+// decode it into the payload type matching Type, e.g. MessagePayload for "Message".
+type Envelope struct {
+	V    int             `json:"v"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	TS   time.Time       `json:"ts"`
+	Seq  int64           `json:"seq"`
+}
+
+// ParseEnvelope decodes the data of a JSON-format frame.
+func ParseEnvelope(data string) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal([]byte(data), &env); err != nil {
+		return Envelope{}, fmt.Errorf("invalid event envelope: %w", err)
+	}
+	if env.V != envelopeVersion {
+		return Envelope{}, fmt.Errorf("unsupported event envelope version %d", env.V)
+	}
+	return env, nil
+}
@@ -0,0 +1,147 @@
+package sse
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorded reads a stream recorded from the server with curl -N.
+func recorded(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestReaderRecordedTextStream(t *testing.T) {
+	const stream = "20145a1663a3a3d59dbe2528cfcb8450"
+	raw := recorded(t, "flight_text.sse")
+	wantEvents := []string{TypeStarted, TypeQueryUnderstanding, TypeFlightResults,
+		TypeStatus, TypeStatus, TypeStatus, TypeStatus, TypeStatus, TypeStatus, TypeMessage, TypeDone}
+
+	// Servers may end lines with LF, CRLF or a lone CR; all read the same.
+	for _, eol := range []string{"\n", "\r\n", "\r"} {
+		reader := NewReader(strings.NewReader(strings.ReplaceAll(raw, "\n", eol)))
+		var events []string
+		var frames []Frame
+		for {
+			frame, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			frames = append(frames, frame)
+			events = append(events, frame.Event)
+		}
+		if !reflect.DeepEqual(events, wantEvents) {
+			t.Fatalf("EOL %q: events %v, want %v", eol, events, wantEvents)
+		}
+		if frames[0].Data != stream || frames[2].Data != "Found 4 flights" || frames[10].Data != OutcomeOK {
+			t.Errorf("EOL %q: data %q, %q, %q", eol, frames[0].Data, frames[2].Data, frames[10].Data)
+		}
+		if !strings.HasPrefix(frames[9].Data, "This is a mock answer") {
+			t.Errorf("EOL %q: message %q", eol, frames[9].Data)
+		}
+		for i, frame := range frames {
+			if want := EventID(stream, int64(i+1)); frame.ID != want {
+				t.Errorf("EOL %q: event %d has ID %q, want %q", eol, i, frame.ID, want)
+			}
+		}
+		if reader.Retry() != 3*time.Second || reader.LastEventID() != EventID(stream, 11) {
+			t.Errorf("EOL %q: retry %v, last ID %q", eol, reader.Retry(), reader.LastEventID())
+		}
+	}
+}
+
+func TestReaderRecordedJSONStream(t *testing.T) {
+	frames := readFrames(t, strings.NewReader(recorded(t, "general_json.sse")))
+	if len(frames) != 9 {
+		t.Fatalf("%d frames, want 9", len(frames))
+	}
+	for i, frame := range frames {
+		env, err := ParseEnvelope(frame.Data)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if env.Type != frame.Event || env.Seq != int64(i+1) || env.TS.IsZero() {
+			t.Errorf("frame %d: %s envelope %+v", i, frame.Event, env)
+		}
+	}
+	env, _ := ParseEnvelope(frames[len(frames)-1].Data)
+	var done DonePayload
+	if err := json.Unmarshal(env.Data, &done); err != nil || done.Outcome != OutcomeOK || done.Telemetry == nil {
+		t.Errorf("Done = %+v, %v", done, err)
+	}
+}
+
+func TestReaderFields(t *testing.T) {
+	for _, tt := range []struct {
+		name, raw string
+		want      []Frame
+	}{
+		{"multi-line data", "data: a\ndata: b\ndata:\n\n", []Frame{{Data: "a\nb\n"}}},
+		{"one space dropped", "data:  two\ndata:none\n\n", []Frame{{Data: " two\nnone"}}},
+		{"comments skipped", ": keep-alive\n\n:\ndata: x\n\n", []Frame{{Data: "x"}}},
+		{"field without colon", "data\n\n", []Frame{{Data: ""}}},
+		{"unknown fields ignored", "foo: bar\ndata: x\n\n", []Frame{{Data: "x"}}},
+		{"no data, no event", "event: Status\n\nevent: Done\ndata: ok\n\n", []Frame{{Event: "Done", Data: "ok"}}},
+		{"event name doesn't carry over", "event: Status\ndata: a\n\ndata: b\n\n", []Frame{{Event: "Status", Data: "a"}, {Data: "b"}}},
+		{"ID carries over", "id: s-1\ndata: a\n\ndata: b\n\nid\ndata: c\n\n", []Frame{{ID: "s-1", Data: "a"}, {ID: "s-1", Data: "b"}, {Data: "c"}}},
+		{"ID with NUL ignored", "id: s-1\ndata: a\n\nid: s\x00-2\ndata: b\n\n", []Frame{{ID: "s-1", Data: "a"}, {ID: "s-1", Data: "b"}}},
+		{"cut-off event discarded", "data: a\n\ndata: b\n", []Frame{{Data: "a"}}},
+		{"unterminated line discarded", "data: a\n\ndata: b", []Frame{{Data: "a"}}},
+	} {
+		if got := readFrames(t, strings.NewReader(tt.raw)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReaderRetry(t *testing.T) {
+	for _, tt := range []struct {
+		raw  string
+		want time.Duration
+	}{
+		{"retry: 1500\ndata: x\n\n", 1500 * time.Millisecond},
+		{"retry: 1500\nretry: soon\ndata: x\n\n", 1500 * time.Millisecond},
+		{"retry: -1\ndata: x\n\n", 0},
+	} {
+		reader := NewReader(strings.NewReader(tt.raw))
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if reader.Retry() != tt.want {
+			t.Errorf("%q: retry %v, want %v", tt.raw, reader.Retry(), tt.want)
+		}
+	}
+}
+
+// splitReader returns its data one byte per Read, as a slow network would.
+type splitReader struct{ data string }
+
+func (r *splitReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.EOF
+	}
+	p[0] = r.data[0]
+	r.data = r.data[1:]
+	return 1, nil
+}
+
+func TestReaderSplitReads(t *testing.T) {
+	raw := "id: s-1\r\nevent: Message\r\ndata: a\r\ndata: b\r\n\r\nid: s-2\rdata: c\r\r"
+	got := readFrames(t, &splitReader{raw})
+	want := []Frame{{ID: "s-1", Event: "Message", Data: "a\nb"}, {ID: "s-2", Data: "c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,46 @@
+package sse
+
+import (
+	"context"
+	"time"
+)
+
+// StreamStats describes how an SSE response went, for access logs: Handler.ServeStream fills it
+// in when the request context carries one (see WithStats).
+type StreamStats struct {
+	Streamed     bool      // ServeStream handled the request
+	FirstEventAt time.Time // When the first event was written; zero if none was
+	Events       int       // Events written
+	Bytes        int       // Bytes written, including SSE framing
+	Outcome      string    // The Done event's outcome, if one was written
+	// EndReason is why the write loop stopped, one of the End reasons.
+	EndReason string
+	Duration  time.Duration // How long the write loop ran
+}
+
+// Reasons the write loop of a connection stops, as StreamStats.EndReason.
+const (
+	EndDone               = "done"                // The stream finished and every event was sent
+	EndClientDisconnected = "client_disconnected" // The client went away, mid-wait or mid-write
+	EndShutdown           = "shutdown"            // The server is shutting down and sent Reconnect
+	EndTooFarBehind       = "too_far_behind"      // The client fell further behind than the buffer allows
+	EndWriteFailed        = "write_failed"        // A write failed otherwise, e.g. its deadline passed
+	EndFlushFailed        = "flush_failed"
+)
+
+type statsKey struct{}
+
+// WithStats returns a context that asks ServeStream to record its StreamStats into the
+// returned value. Read it once the handler has returned.
+func WithStats(ctx context.Context) (context.Context, *StreamStats) {
+	stats := &StreamStats{}
+	return context.WithValue(ctx, statsKey{}, stats), stats
+}
+
+// statsFrom returns the StreamStats requested by WithStats, or a throwaway one.
+func statsFrom(ctx context.Context) *StreamStats {
+	if stats, ok := ctx.Value(statsKey{}).(*StreamStats); ok {
+		return stats
+	}
+	return &StreamStats{}
+}
@@ -0,0 +1,283 @@
+package sse
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stream buffers the events of one request so a client that reconnects with
+// Last-Event-ID can be replayed what it missed before following the live events.
+// Events get increasing sequence numbers starting at 1.
+// A Stream has one producer and any number of subscribers: each Handler.ServeStream call
+// reads the shared buffer independently, so every subscriber sees the same events in the same order.
+type Stream struct {
+	id        string
+	onPublish func(Event) // Optional; inherited from the Registry
+
+	mu     sync.Mutex
+	events []Event       // Every event published so far, in order
+	done   bool          // Set once the producer has finished
+	doneAt time.Time     // When the stream finished, for expiry
+	notify chan struct{} // Closed (and replaced) whenever events or done change
+}
+
+func newStream(id string) *Stream {
+	return &Stream{id: id, notify: make(chan struct{})}
+}
+
+// ID returns the stream's identifier, which prefixes every event ID it emits.
+func (s *Stream) ID() string {
+	return s.id
+}
+
+// Publish appends an event, assigning it the next sequence number (and a timestamp if it has none),
+// and wakes any waiting writers. Events published after Close are dropped.
+func (s *Stream) Publish(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	event.Seq = int64(len(s.events)) + 1
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	s.events = append(s.events, event)
+	s.wake()
+	if s.onPublish != nil {
+		s.onPublish(event)
+	}
+}
+
+// Close marks the stream as finished; writers return once they have sent every event.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.done = true
+	s.doneAt = time.Now()
+	s.wake()
+}
+
+// Pipe publishes everything received on eventChan and closes the stream when the channel closes.
+// It lets producers written against a plain channel (like the orchestrator) feed a Stream.
+func (s *Stream) Pipe(eventChan <-chan Event) {
+	for event := range eventChan {
+		s.Publish(event)
+	}
+	s.Close()
+}
+
+// Events returns a copy of the events published so far.
+func (s *Stream) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// Follow calls fn with every event of the stream in order, the buffered ones first and then the
+// live ones as they are published. It returns nil once the stream has finished and fn has seen
+// every event, or ctx's error if ctx ends first. It lets consumers other than an HTTP client
+// (such as chat integrations) subscribe to a stream.
+func (s *Stream) Follow(ctx context.Context, fn func(Event)) error {
+	var after int64
+	for {
+		events, done, changed := s.since(after)
+		for _, event := range events {
+			fn(event)
+			after = event.Seq
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// FollowBatches is Follow for consumers that handle events in batches, such as writers to a
+// database: fn gets every event published since its last call at once, and done is set on its
+// last call, once the stream has finished (possibly with no events).
+func (s *Stream) FollowBatches(ctx context.Context, fn func(events []Event, done bool)) error {
+	var after int64
+	for {
+		events, done, changed := s.since(after)
+		if len(events) > 0 || done {
+			fn(events, done)
+		}
+		if len(events) > 0 {
+			after = events[len(events)-1].Seq
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Progress returns how many events have been published and the text of the latest Status
+// event, which names the pipeline phase the producer is in.
+func (s *Stream) Progress() (events int, phase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if s.events[i].Type == TypeStatus {
+			phase = s.events[i].Data
+			break
+		}
+	}
+	return len(s.events), phase
+}
+
+// wake notifies waiters; the caller must hold s.mu.
+func (s *Stream) wake() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// since returns the events after sequence number after, whether the stream has finished,
+// and a channel that is closed when anything changes.
+func (s *Stream) since(after int64) ([]Event, bool, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []Event
+	if after < int64(len(s.events)) {
+		if after < 0 {
+			after = 0
+		}
+		events = append(events, s.events[after:]...)
+	}
+	return events, s.done, s.notify
+}
+
+// expired reports whether the stream finished more than ttl ago.
+func (s *Stream) expired(ttl time.Duration, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done && now.Sub(s.doneAt) > ttl
+}
+
+// EventID formats the SSE id for an event: "<stream ID>-<sequence>".
+func EventID(streamID string, seq int64) string {
+	return streamID + "-" + strconv.FormatInt(seq, 10)
+}
+
+// ParseEventID splits an id produced by EventID (e.g. from a Last-Event-ID header).
+func ParseEventID(id string) (streamID string, seq int64, ok bool) {
+	i := strings.LastIndexByte(id, '-')
+	if i <= 0 {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseInt(id[i+1:], 10, 64)
+	if err != nil || seq < 0 {
+		return "", 0, false
+	}
+	return id[:i], seq, true
+}
+
+// Registry keeps recent streams addressable by ID so reconnecting clients can resume them.
+// Finished streams are dropped ttl after they end; expired entries are swept whenever a stream is created.
+type Registry struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	streams   map[string]*Stream
+	onPublish func(Event)
+}
+
+// NewRegistry creates a registry that keeps finished streams for ttl.
+func NewRegistry(ttl time.Duration) *Registry {
+	return &Registry{ttl: ttl, streams: make(map[string]*Stream)}
+}
+
+// OnPublish registers fn to be called with every event published to streams created afterwards
+// (e.g. to count events for metrics). fn runs while the stream is locked, so it must be quick
+// and must not touch the stream.
+func (r *Registry) OnPublish(fn func(Event)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onPublish = fn
+}
+
+// Create registers a new stream with a random, unguessable ID.
+func (r *Registry) Create() *Stream {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	stream := newStream(hex.EncodeToString(buf))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep()
+	stream.onPublish = r.onPublish
+	r.streams[stream.id] = stream
+	return stream
+}
+
+// Restore returns the registered stream with the given ID, or registers a finished stream
+// holding events if there is none, e.g. to replay a request another process (or an earlier
+// run of this one) served. The events keep their sequence numbers, so clients can resume the
+// restored stream with Last-Event-ID like the original. It is kept for the registry's ttl.
+func (r *Registry) Restore(id string, events []Event) *Stream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep()
+	if stream, ok := r.streams[id]; ok {
+		return stream
+	}
+	stream := newStream(id)
+	stream.events = append([]Event(nil), events...)
+	stream.done, stream.doneAt = true, time.Now()
+	r.streams[id] = stream
+	return stream
+}
+
+// Remote returns the registered stream with the given ID, or registers an open one for a
+// request another process is serving, e.g. a replica that shares its streams through a
+// database. The caller feeds a new one (isNew) with Publish in sequence order, so its events
+// keep their sequence numbers, and closes it once the original has finished. Its events don't
+// go to the registry's OnPublish, as they were counted where they were first published.
+func (r *Registry) Remote(id string) (stream *Stream, isNew bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep()
+	if stream, ok := r.streams[id]; ok {
+		return stream, false
+	}
+	stream = newStream(id)
+	r.streams[id] = stream
+	return stream, true
+}
+
+// Get returns a registered, unexpired stream.
+func (r *Registry) Get(id string) (*Stream, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stream, ok := r.streams[id]
+	if !ok || stream.expired(r.ttl, time.Now()) {
+		return nil, false
+	}
+	return stream, true
+}
+
+// sweep drops expired streams; the caller must hold r.mu.
+func (r *Registry) sweep() {
+	now := time.Now()
+	for id, stream := range r.streams {
+		if stream.expired(r.ttl, now) {
+			delete(r.streams, id)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package sse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resumeServer serves the streams of reg the way the chat endpoint does: a request with
+// Last-Event-ID resumes its stream after that event, and one for an unknown or expired stream
+// gets 204 so EventSource stops reconnecting. Other requests follow stream from the start.
+func resumeServer(t *testing.T, reg *Registry, stream *Stream) *httptest.Server {
+	t.Helper()
+	h := NewHandler()
+	h.CoalesceWindow = 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+			streamID, seq, ok := ParseEventID(lastID)
+			var resumed *Stream
+			if ok {
+				resumed, ok = reg.Get(streamID)
+			}
+			if !ok {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			h.ServeStream(w, r, resumed, seq)
+			return
+		}
+		h.ServeStream(w, r, stream, 0)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// connect opens an event stream on srv, resuming after lastID if it is set.
+func connect(t *testing.T, srv *httptest.Server, lastID string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestReconnectResumesWithoutLossOrDuplicates(t *testing.T) {
+	reg := NewRegistry(time.Minute)
+	stream := reg.Create()
+	srv := resumeServer(t, reg, stream)
+	stream.Publish(Status("Thinking"))
+	stream.Publish(MessageChunk("one ", false))
+	stream.Publish(MessageChunk("two ", false))
+
+	var got []Frame
+	resp := connect(t, srv, "")
+	reader := NewReader(resp.Body)
+	for range 3 {
+		frame, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, frame)
+	}
+	// The client drops the connection; the answer goes on without it.
+	resp.Body.Close()
+	stream.Publish(MessageChunk("three ", false))
+	stream.Publish(MessageChunk("four", true))
+
+	resp = connect(t, srv, reader.LastEventID())
+	defer resp.Body.Close()
+	reader = NewReader(resp.Body)
+	frame, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, frame)
+	// Events published while the client is connected again follow the replayed ones.
+	stream.Publish(Done(DonePayload{Outcome: OutcomeOK}))
+	stream.Close()
+	for {
+		frame, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, frame)
+	}
+
+	want := []string{"Thinking", "one ", "two ", "three ", "four", OutcomeOK}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events %+v, want %d", len(got), got, len(want))
+	}
+	for i, frame := range got {
+		if wantID := EventID(stream.ID(), int64(i+1)); frame.ID != wantID || frame.Data != want[i] {
+			t.Errorf("event %d = %q %q, want %q %q", i, frame.ID, frame.Data, wantID, want[i])
+		}
+	}
+}
+
+func TestReconnectToFinishedStream(t *testing.T) {
+	reg := NewRegistry(time.Minute)
+	stream := reg.Create()
+	srv := resumeServer(t, reg, stream)
+	for i := range 4 {
+		stream.Publish(MessageChunk(fmt.Sprint(i), i == 3))
+	}
+	stream.Close()
+
+	// The client missed the last two events before the stream finished.
+	resp := connect(t, srv, EventID(stream.ID(), 2))
+	defer resp.Body.Close()
+	frames := readFrames(t, resp.Body)
+	if len(frames) != 2 || frames[0].Data != "2" || frames[1].Data != "3" || frames[1].ID != EventID(stream.ID(), 4) {
+		t.Errorf("replayed %+v, want events 3 and 4", frames)
+	}
+
+	// One that saw everything gets nothing more.
+	resp = connect(t, srv, EventID(stream.ID(), 4))
+	defer resp.Body.Close()
+	if frames := readFrames(t, resp.Body); len(frames) != 0 {
+		t.Errorf("replayed %+v after the last event", frames)
+	}
+}
+
+func TestReconnectToUnknownOrExpiredStream(t *testing.T) {
+	reg := NewRegistry(time.Minute)
+	stream := reg.Create()
+	srv := resumeServer(t, reg, stream)
+	stream.Publish(Status("Thinking"))
+	stream.Close()
+	stream.doneAt = time.Now().Add(-2 * time.Minute)
+
+	for _, lastID := range []string{EventID(stream.ID(), 1), EventID("unknown", 1), "not-an-id"} {
+		resp := connect(t, srv, lastID)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("Last-Event-ID %q: status %d, want 204", lastID, resp.StatusCode)
+		}
+	}
+}
+
+func TestRegistryExpiry(t *testing.T) {
+	reg := NewRegistry(time.Minute)
+	open, finished, expired := reg.Create(), reg.Create(), reg.Create()
+	finished.Close()
+	expired.Close()
+	expired.doneAt = time.Now().Add(-2 * time.Minute)
+	open.doneAt = time.Now().Add(-2 * time.Minute) // Ignored while the stream is open
+
+	for _, tt := range []struct {
+		stream *Stream
+		want   bool
+	}{{open, true}, {finished, true}, {expired, false}} {
+		if _, ok := reg.Get(tt.stream.ID()); ok != tt.want {
+			t.Errorf("Get(%s) found = %v, want %v", tt.stream.ID(), ok, tt.want)
+		}
+	}
+	// Creating a stream sweeps the expired ones.
+	reg.Create()
+	if _, ok := reg.streams[expired.ID()]; ok {
+		t.Error("expired stream wasn't swept")
+	}
+	if len(reg.streams) != 3 {
+		t.Errorf("%d streams registered, want 3", len(reg.streams))
+	}
+}
+
+func TestPublishAssignsIncreasingSeqs(t *testing.T) {
+	stream := newStream("s1")
+	for range 3 {
+		stream.Publish(Status("x"))
+	}
+	stream.Close()
+	stream.Publish(Status("after close"))
+	events := stream.Events()
+	if len(events) != 3 {
+		t.Fatalf("%d events, want 3 (nothing after Close)", len(events))
+	}
+	for i, event := range events {
+		if event.Seq != int64(i+1) || event.Timestamp.IsZero() {
+			t.Errorf("event %d = %+v", i, event)
+		}
+	}
+}
+
+func TestParseEventID(t *testing.T) {
+	for _, tt := range []struct {
+		id     string
+		stream string
+		seq    int64
+		ok     bool
+	}{
+		{EventID("abc123", 7), "abc123", 7, true},
+		{"a-b-c-12", "a-b-c", 12, true},
+		{"abc-0", "abc", 0, true},
+		{"abc", "", 0, false},
+		{"-5", "", 0, false},
+		{"abc-", "", 0, false},
+		{"abc-x", "", 0, false},
+	} {
+		stream, seq, ok := ParseEventID(tt.id)
+		if stream != tt.stream || seq != tt.seq || ok != tt.ok {
+			t.Errorf("ParseEventID(%q) = %q, %d, %v; want %q, %d, %v", tt.id, stream, seq, ok, tt.stream, tt.seq, tt.ok)
+		}
+	}
+}
+
+func TestConcurrentSubscribersSeeTheSameEvents(t *testing.T) {
+	reg := NewRegistry(time.Minute)
+	stream := reg.Create()
+	srv := resumeServer(t, reg, stream)
+	stream.Publish(Status("Thinking"))
+
+	// Both attach mid-stream, one before and one after more events, while the producer goes on.
+	results := make(chan []Frame, 2)
+	subscribe := func() {
+		resp, err := srv.Client().Get(srv.URL)
+		if err != nil {
+			t.Error(err)
+			results <- nil
+			return
+		}
+		defer resp.Body.Close()
+		var frames []Frame
+		reader := NewReader(resp.Body)
+		for {
+			frame, err := reader.Next()
+			if err != nil {
+				break
+			}
+			frames = append(frames, frame)
+		}
+		results <- frames
+	}
+	go subscribe()
+	for i := range 50 {
+		stream.Publish(MessageChunk(fmt.Sprint(i, " "), false))
+		if i == 25 {
+			go subscribe()
+		}
+	}
+	stream.Publish(Done(DonePayload{Outcome: OutcomeOK}))
+	stream.Close()
+
+	var got [2][]Frame
+	for i := range got {
+		select {
+		case got[i] = <-results:
+		case <-time.After(5 * time.Second):
+			t.Fatal("a subscriber didn't finish")
+		}
+	}
+	if len(got[0]) != 52 || !reflect.DeepEqual(got[0], got[1]) {
+		t.Fatalf("subscribers got %d and %d events, want the same 52", len(got[0]), len(got[1]))
+	}
+	for i, frame := range got[0] {
+		if frame.ID != EventID(stream.ID(), int64(i+1)) {
+			t.Errorf("event %d has id %q", i, frame.ID)
+		}
+	}
+}
+
+func TestFollowAfterClose(t *testing.T) {
+	stream := newStream("s1")
+	stream.Publish(Status("a"))
+	stream.Publish(Status("b"))
+	stream.Close()
+	var got []string
+	if err := stream.Follow(context.Background(), func(event Event) { got = append(got, event.Data) }); err != nil || strings.Join(got, "") != "ab" {
+		t.Errorf("Follow = %v, saw %v", err, got)
+	}
+
+	open := newStream("s2")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := open.Follow(ctx, func(Event) {}); err != context.Canceled {
+		t.Errorf("Follow on a cancelled context = %v", err)
+	}
+}
+
+func TestFollowBatches(t *testing.T) {
+	stream := newStream("s1")
+	stream.Publish(Status("a"))
+	stream.Publish(Status("b"))
+	batches := make(chan []Event, 10)
+	finished := make(chan error, 1)
+	go func() {
+		finished <- stream.FollowBatches(context.Background(), func(events []Event, done bool) {
+			if done {
+				events = append(events, Event{Type: "done"})
+			}
+			batches <- events
+		})
+	}()
+
+	// What was published before gets one batch; later events the next ones.
+	if first := <-batches; len(first) != 2 || first[0].Seq != 1 || first[1].Seq != 2 {
+		t.Errorf("first batch %+v", first)
+	}
+	stream.Publish(Status("c"))
+	if second := <-batches; len(second) != 1 || second[0].Data != "c" || second[0].Seq != 3 {
+		t.Errorf("second batch %+v", second)
+	}
+	// Closing calls fn a last time, done, with nothing left to send.
+	stream.Close()
+	if last := <-batches; len(last) != 1 || last[0].Type != "done" {
+		t.Errorf("last batch %+v", last)
+	}
+	if err := <-finished; err != nil {
+		t.Errorf("FollowBatches = %v", err)
+	}
+}
+
+func TestRegistryRemote(t *testing.T) {
+	var published int
+	reg := NewRegistry(time.Minute)
+	reg.OnPublish(func(Event) { published++ })
+
+	// A stream of another process is registered once, and fed with its sequence numbers.
+	remote, isNew := reg.Remote("other-1")
+	if !isNew || remote.ID() != "other-1" {
+		t.Fatalf("Remote = %v, %v", remote.ID(), isNew)
+	}
+	remote.Publish(Status("a"))
+	remote.Publish(Status("b"))
+	if again, isNew := reg.Remote("other-1"); isNew || again != remote {
+		t.Errorf("Remote again = %p, %v; want %p", again, isNew, remote)
+	}
+	if got, ok := reg.Get("other-1"); !ok || got != remote {
+		t.Errorf("Get = %v, %v", got, ok)
+	}
+	if events := remote.Events(); len(events) != 2 || events[1].Seq != 2 {
+		t.Errorf("events %+v", events)
+	}
+	if published != 0 {
+		t.Errorf("OnPublish saw %d events of a remote stream", published)
+	}
+
+	// A local stream with the ID is returned as it is.
+	local := reg.Create()
+	if got, isNew := reg.Remote(local.ID()); isNew || got != local {
+		t.Errorf("Remote of a local stream = %p, %v", got, isNew)
+	}
+}
@@ -0,0 +1,148 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultAPIURL is the base URL of the Telegram Bot API.
+const DefaultAPIURL = "https://api.telegram.org"
+
+// APIError is a failed Bot API call: {"ok":false,"error_code":...,"description":...}.
+type APIError struct {
+	Method      string
+	Code        int    // HTTP-like status, e.g. 400, 409 or 429
+	Description string // e.g. "Bad Request: message is not modified"
+	RetryAfter  time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("telegram %s: %d %s", e.Method, e.Code, e.Description)
+}
+
+// Update is an incoming update from getUpdates. Only messages are requested.
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message"`
+}
+
+// Message is the part of a Telegram message the bot uses.
+type Message struct {
+	MessageID int64  `json:"message_id"`
+	Chat      Chat   `json:"chat"`
+	From      *User  `json:"from"`
+	Text      string `json:"text"`
+}
+
+// Chat is the conversation a message belongs to.
+type Chat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"` // "private", "group", "supergroup" or "channel"
+}
+
+// User is the sender of a message.
+type User struct {
+	ID           int64  `json:"id"`
+	IsBot        bool   `json:"is_bot"`
+	LanguageCode string `json:"language_code"` // IETF tag of the user's app language, if known
+}
+
+// Client calls the Telegram Bot API with a bot token.
+type Client struct {
+	token      string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient returns a client for the API at baseURL (DefaultAPIURL if empty) using token.
+// The HTTP timeout leaves room for long polls of up to a minute.
+func NewClient(token, baseURL string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultAPIURL
+	}
+	return &Client{token: token, baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: &http.Client{Timeout: 90 * time.Second}}
+}
+
+// GetUpdates long-polls for messages with IDs from offset on, waiting up to timeout for one to arrive.
+func (c *Client) GetUpdates(ctx context.Context, offset int64, timeout time.Duration) ([]Update, error) {
+	var updates []Update
+	params := map[string]any{"offset": offset, "timeout": int(timeout.Seconds()), "allowed_updates": []string{"message"}}
+	err := c.call(ctx, "getUpdates", params, &updates)
+	return updates, err
+}
+
+// SendMessage sends text to chatID, as a reply to replyTo if it is not zero, and returns the new message's ID.
+func (c *Client) SendMessage(ctx context.Context, chatID, replyTo int64, text string) (int64, error) {
+	params := map[string]any{"chat_id": chatID, "text": text}
+	if replyTo != 0 {
+		params["reply_parameters"] = map[string]any{"message_id": replyTo, "allow_sending_without_reply": true}
+	}
+	var msg Message
+	err := c.call(ctx, "sendMessage", params, &msg)
+	return msg.MessageID, err
+}
+
+// EditMessageText replaces the text of messageID in chatID. Editing a message to the text
+// it already has is not an error.
+func (c *Client) EditMessageText(ctx context.Context, chatID, messageID int64, text string) error {
+	err := c.call(ctx, "editMessageText", map[string]any{"chat_id": chatID, "message_id": messageID, "text": text}, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && strings.Contains(apiErr.Description, "message is not modified") {
+		return nil
+	}
+	return err
+}
+
+// call POSTs params as JSON to the API method and decodes the response's result into out (if not nil).
+func (c *Client) call(ctx context.Context, method string, params map[string]any, out any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("encode %s request: %w", method, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/bot"+c.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// The URL contains the bot token; keep it out of the error (and so out of the logs).
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		ErrorCode   int             `json:"error_code"`
+		Description string          `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decode %s response (status %d): %w", method, resp.StatusCode, err)
+	}
+	if !result.OK {
+		return &APIError{Method: method, Code: result.ErrorCode, Description: result.Description,
+			RetryAfter: time.Duration(result.Parameters.RetryAfter) * time.Second}
+	}
+	if out != nil {
+		if err := json.Unmarshal(result.Result, out); err != nil {
+			return fmt.Errorf("decode %s result: %w", method, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Package telegram answers questions sent to a Telegram bot. Bot long-polls the Bot API for
+// messages, so it needs no public URL (and no HTTP server); each message is started through the
+// chat pipeline and answered with a reply that is edited as the answer streams in, followed by
+// the flights found as a list.
+//
+// Each Telegram chat is one conversation: the session ID is derived from the chat ID, so
+// follow-up questions see the earlier answers.
+package telegram
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/chatbot"
+	"github.com/Cris245/go-llm-chat/internal/i18n"
+)
+
+// Telegram allows 4096 characters per message; the rest of the margin is for the typing marker.
+const maxMessageLength = 4000
+
+// Backoff between failed polls.
+const (
+	minPollBackoff = time.Second
+	maxPollBackoff = time.Minute
+)
+
+// Bot polls for messages and answers them. Create it with NewBot and start it with Run.
+type Bot struct {
+	API         *Client
+	Chat        chatbot.ChatFunc
+	PollTimeout time.Duration   // How long each getUpdates call waits for messages
+	Reply       chatbot.Options // How answers are rendered
+
+	wg sync.WaitGroup // Replies being written
+}
+
+// NewBot returns a bot that reads messages with api and starts them through chat.
+func NewBot(api *Client, chat chatbot.ChatFunc) *Bot {
+	return &Bot{
+		API:         api,
+		Chat:        chat,
+		PollTimeout: 30 * time.Second,
+		Reply:       chatbot.Options{MaxLength: maxMessageLength, EditInterval: time.Second, ShowFlights: true},
+	}
+}
+
+// Run polls for messages until ctx is done, answering each in the background. Failed polls
+// are retried with exponential backoff (or after the delay Telegram asks for).
+// Replies still being written when Run returns finish on their own; see Wait.
+func (b *Bot) Run(ctx context.Context) {
+	slog.InfoContext(ctx, "Telegram bot polling for messages", "poll_timeout", b.PollTimeout)
+	var offset int64
+	backoff := minPollBackoff
+	for ctx.Err() == nil {
+		updates, err := b.API.GetUpdates(ctx, offset, b.PollTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			wait := backoff
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+				wait = apiErr.RetryAfter
+			}
+			slog.WarnContext(ctx, "Telegram poll failed", "error", err, "retry_in", wait)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+			}
+			backoff = min(2*backoff, maxPollBackoff)
+			continue
+		}
+		backoff = minPollBackoff
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1 // Confirms the update, so it isn't delivered again.
+			if msg := update.Message; msg != nil && answerable(msg) {
+				b.wg.Add(1)
+				go func() {
+					defer b.wg.Done()
+					// The answer outlives the poll loop; shutdown stops polling, not the replies.
+					b.answer(context.WithoutCancel(ctx), msg)
+				}()
+			}
+		}
+	}
+	slog.InfoContext(ctx, "Telegram bot stopped polling")
+}
+
+// answerable reports whether msg is a text message from a person.
+func answerable(msg *Message) bool {
+	return msg.From != nil && !msg.From.IsBot && strings.TrimSpace(msg.Text) != ""
+}
+
+// Wait blocks until the replies being written have finished or ctx is done, and reports
+// whether they all finished. Shutdown calls it after the orchestrations have drained.
+func (b *Bot) Wait(ctx context.Context) bool {
+	finished := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// answer runs msg through the chat pipeline and replies to it in its chat.
+func (b *Bot) answer(ctx context.Context, msg *Message) {
+	chatID := strconv.FormatInt(msg.Chat.ID, 10)
+	sessionID := "telegram:" + chatID
+	m := &chatMessenger{api: b.API, chatID: msg.Chat.ID, replyTo: msg.MessageID}
+
+	text := strings.TrimSpace(msg.Text)
+	if command, _, _ := strings.Cut(text, " "); command == "/start" || strings.HasPrefix(command, "/start@") {
+		// Telegram clients send /start when a user opens a chat with the bot. There is no
+		// question to detect a language from yet, so the welcome uses the user's app language.
+		if _, err := m.Post(ctx, i18n.T(i18n.Negotiate(msg.From.LanguageCode), "bot.welcome")); err != nil {
+			slog.ErrorContext(ctx, "Sending Telegram welcome failed", "chat_id", chatID, "error", err)
+		}
+		return
+	}
+
+	slog.InfoContext(ctx, "Telegram question", "chat_id", chatID, "chat_type", msg.Chat.Type, "session_id", sessionID)
+	stream, err := b.Chat(ctx, "telegram:"+strconv.FormatInt(msg.From.ID, 10), sessionID, text)
+	if err != nil {
+		if _, postErr := m.Post(ctx, "⚠️ "+err.Error()); postErr != nil {
+			slog.ErrorContext(ctx, "Sending Telegram error reply failed", "chat_id", chatID, "error", postErr)
+		}
+		return
+	}
+	opts := b.Reply
+	opts.Language = i18n.Detect(text) // The pipeline answers in the question's language.
+	if err := chatbot.Reply(ctx, stream, m, opts); err != nil {
+		slog.ErrorContext(ctx, "Sending Telegram answer failed", "stream", stream.ID(), "chat_id", chatID, "error", err)
+	}
+}
+
+// chatMessenger sends and edits the messages of one answer in a Telegram chat. The first
+// message replies to the question, so answers stay attached to their questions in busy chats.
+type chatMessenger struct {
+	api     *Client
+	chatID  int64
+	replyTo int64
+}
+
+func (m *chatMessenger) Post(ctx context.Context, text string) (string, error) {
+	id, err := m.api.SendMessage(ctx, m.chatID, m.replyTo, text)
+	m.replyTo = 0
+	return strconv.FormatInt(id, 10), err
+}
+
+func (m *chatMessenger) Edit(ctx context.Context, id, text string) error {
+	messageID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return err
+	}
+	err = m.api.EditMessageText(ctx, m.chatID, messageID, text)
+	// Waiting out a short rate limit once keeps the final text from being lost.
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 && apiErr.RetryAfter <= 5*time.Second {
+		select {
+		case <-time.After(apiErr.RetryAfter):
+		case <-ctx.Done():
+			return err
+		}
+		err = m.api.EditMessageText(ctx, m.chatID, messageID, text)
+	}
+	return err
+}
@@ -0,0 +1,243 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/chatbot"
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+const testToken = "123:secret-token"
+
+// sentMessage is a sendMessage or editMessageText call the fake API received.
+type sentMessage struct {
+	Method    string
+	ChatID    int64
+	MessageID int64 // The edited message, or the ID given to a sent one
+	ReplyTo   int64
+	Text      string
+}
+
+// fakeAPI is a Bot API that delivers queued updates and records what the bot sends.
+type fakeAPI struct {
+	mu      sync.Mutex
+	updates []Update
+	offsets []int64 // The offset of every getUpdates call
+	sent    []sentMessage
+}
+
+func newFakeAPI(t *testing.T, updates ...Update) (*fakeAPI, *Client) {
+	api := &fakeAPI{updates: updates}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	return api, NewClient(testToken, srv.URL)
+}
+
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+testToken+"/")
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": 401, "description": "Unauthorized"})
+		return
+	}
+	var params struct {
+		Offset          int64 `json:"offset"`
+		ChatID          int64 `json:"chat_id"`
+		MessageID       int64 `json:"message_id"`
+		Text            string
+		ReplyParameters struct {
+			MessageID int64 `json:"message_id"`
+		} `json:"reply_parameters"`
+	}
+	json.NewDecoder(r.Body).Decode(&params)
+
+	a.mu.Lock()
+	var result any = true
+	switch method {
+	case "getUpdates":
+		a.offsets = append(a.offsets, params.Offset)
+		pending := []Update{}
+		for _, u := range a.updates {
+			if u.UpdateID >= params.Offset {
+				pending = append(pending, u)
+			}
+		}
+		if len(pending) == 0 {
+			a.mu.Unlock()
+			time.Sleep(20 * time.Millisecond) // A short long poll
+			a.mu.Lock()
+		}
+		result = pending
+	case "sendMessage":
+		id := int64(100 + len(a.sent))
+		a.sent = append(a.sent, sentMessage{Method: method, ChatID: params.ChatID, MessageID: id, ReplyTo: params.ReplyParameters.MessageID, Text: params.Text})
+		result = Message{MessageID: id, Chat: Chat{ID: params.ChatID}}
+	case "editMessageText":
+		a.sent = append(a.sent, sentMessage{Method: method, ChatID: params.ChatID, MessageID: params.MessageID, Text: params.Text})
+	}
+	a.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+// messages returns what the bot has sent so far.
+func (a *fakeAPI) messages() []sentMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]sentMessage(nil), a.sent...)
+}
+
+// waitFor polls the fake API until done reports true for what the bot has sent.
+func (a *fakeAPI) waitFor(t *testing.T, done func([]sentMessage) bool) []sentMessage {
+	t.Helper()
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if sent := a.messages(); done(sent) {
+			return sent
+		}
+	}
+	t.Fatalf("the bot sent %+v", a.messages())
+	return nil
+}
+
+// question is an update with a text message from user 7 in chat 42.
+func question(updateID, messageID int64, text string) Update {
+	return Update{UpdateID: updateID, Message: &Message{MessageID: messageID, Chat: Chat{ID: 42, Type: "private"}, From: &User{ID: 7}, Text: text}}
+}
+
+// chatCall is one question the bot started through the chat pipeline.
+type chatCall struct{ user, sessionID, text string }
+
+// testFlights are the flights streamingChat finds.
+var testFlights = []db.Flight{{FlightNumber: "FL101", Origin: "Madrid", Destination: "Paris",
+	DepartureTime: "2026-03-01T08:00:00Z", ArrivalTime: "2026-03-01T10:00:00Z", Price: 120, AvailableSeats: 5}}
+
+// streamingChat answers every question with a flight and an answer in two chunks, a little apart.
+func streamingChat(calls chan<- chatCall) chatbot.ChatFunc {
+	registry := sse.NewRegistry(time.Minute)
+	return func(ctx context.Context, user, sessionID, text string) (*sse.Stream, error) {
+		calls <- chatCall{user, sessionID, text}
+		stream := registry.Create()
+		go func() {
+			defer stream.Close()
+			stream.Publish(sse.Status("Searching flights"))
+			stream.Publish(sse.FlightResults(testFlights))
+			stream.Publish(sse.MessageChunk("FL101 ", false))
+			time.Sleep(50 * time.Millisecond)
+			stream.Publish(sse.MessageChunk("leaves at 08:00.", true))
+			stream.Publish(sse.Done(sse.DonePayload{Outcome: sse.OutcomeOK}))
+		}()
+		return stream, nil
+	}
+}
+
+// runBot runs b until the test ends and checks that it stops polling and finishes its replies.
+func runBot(t *testing.T, b *Bot) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		b.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(2 * time.Second):
+			t.Error("Run didn't return after its context was cancelled")
+		}
+		waitCtx, cancelWait := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancelWait()
+		if !b.Wait(waitCtx) {
+			t.Error("replies still running")
+		}
+	})
+}
+
+func TestBotAnswersWithStreamingEdits(t *testing.T) {
+	api, client := newFakeAPI(t, question(5, 11, "Flights from Madrid to Paris"))
+	calls := make(chan chatCall, 1)
+	bot := NewBot(client, streamingChat(calls))
+	bot.PollTimeout = 0
+	bot.Reply.EditInterval = 10 * time.Millisecond
+	runBot(t, bot)
+
+	sent := api.waitFor(t, func(sent []sentMessage) bool {
+		return len(sent) > 0 && strings.Contains(sent[len(sent)-1].Text, "leaves at 08:00.") && !strings.HasSuffix(sent[len(sent)-1].Text, "…")
+	})
+	if call := <-calls; call != (chatCall{"telegram:7", "telegram:42", "Flights from Madrid to Paris"}) {
+		t.Errorf("chat started with %+v", call)
+	}
+
+	// One reply to the question, then edits of it as the answer streams in.
+	placeholder := sent[0]
+	if placeholder.Method != "sendMessage" || placeholder.ChatID != 42 || placeholder.ReplyTo != 11 || !strings.HasPrefix(placeholder.Text, "⏳") {
+		t.Errorf("first message %+v, want a placeholder replying to the question", placeholder)
+	}
+	typing := false
+	for _, m := range sent[1:] {
+		if m.Method != "editMessageText" || m.MessageID != placeholder.MessageID {
+			t.Errorf("%+v, want edits of the placeholder", m)
+		}
+		typing = typing || (strings.HasPrefix(m.Text, "FL101") && strings.HasSuffix(m.Text, " …"))
+	}
+	if !typing {
+		t.Errorf("no partial answer among %+v", sent)
+	}
+
+	if final, want := sent[len(sent)-1].Text, "FL101 leaves at 08:00.\n\n"+chatbot.FormatFlights("en", testFlights); final != want {
+		t.Errorf("final text %q, want %q", final, want)
+	}
+
+	// The update was confirmed, so it isn't delivered again.
+	api.mu.Lock()
+	offsets := append([]int64(nil), api.offsets...)
+	api.mu.Unlock()
+	if len(offsets) < 2 || offsets[0] != 0 || offsets[len(offsets)-1] != 6 {
+		t.Errorf("getUpdates offsets %v, want 0 then 6", offsets)
+	}
+}
+
+func TestBotIgnoresBotsAndWelcomes(t *testing.T) {
+	fromBot := question(1, 21, "I am a bot")
+	fromBot.Message.From.IsBot = true
+	start := question(2, 22, "/start")
+	start.Message.From.LanguageCode = "es"
+	api, client := newFakeAPI(t, fromBot, start)
+	calls := make(chan chatCall, 2)
+	bot := NewBot(client, streamingChat(calls))
+	bot.PollTimeout = 0
+	runBot(t, bot)
+
+	sent := api.waitFor(t, func(sent []sentMessage) bool { return len(sent) > 0 })
+	time.Sleep(50 * time.Millisecond) // Nothing else should follow.
+	if sent = api.messages(); len(sent) != 1 || sent[0].ReplyTo != 22 || !strings.HasPrefix(sent[0].Text, "¡Hola!") {
+		t.Errorf("sent %+v, want only a Spanish welcome", sent)
+	}
+	if len(calls) != 0 {
+		t.Errorf("%d questions started", len(calls))
+	}
+}
+
+func TestClientErrorsHideToken(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close() // Nothing listens there any more.
+	_, err := NewClient(testToken, srv.URL).SendMessage(context.Background(), 42, 0, "hi")
+	if err == nil || strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("error %v, want one without the token", err)
+	}
+
+	_, client := newFakeAPI(t)
+	client.token = "wrong"
+	var apiErr *APIError
+	if _, err := client.SendMessage(context.Background(), 42, 0, "hi"); !errors.As(err, &apiErr) || apiErr.Code != 401 {
+		t.Errorf("error %v, want the API's 401", err)
+	}
+}
@@ -0,0 +1,129 @@
+// Package timezone knows the time zones of the cities and airports flights use, so that the
+// times stored in UTC can be shown as travellers read them: "09:00 CEST / 07:00 UTC".
+//
+// Zones are tz database names ("Europe/Madrid"), looked up by airport code first and then by
+// city. The tz database is embedded in the binary, so conversions, daylight saving time
+// included, don't depend on the zone files of the host.
+package timezone
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // The zones must load in minimal images without /usr/share/zoneinfo
+)
+
+// Default are the built-in zones: of the cities the sample flights use and other common ones,
+// and of their airports.
+var Default = map[string]string{
+	"Amsterdam":   "Europe/Amsterdam",
+	"Barcelona":   "Europe/Madrid",
+	"Berlin":      "Europe/Berlin",
+	"Dubai":       "Asia/Dubai",
+	"Lisbon":      "Europe/Lisbon",
+	"London":      "Europe/London",
+	"Los Angeles": "America/Los_Angeles",
+	"Madrid":      "Europe/Madrid",
+	"Mexico City": "America/Mexico_City",
+	"Munich":      "Europe/Berlin",
+	"New York":    "America/New_York",
+	"Paris":       "Europe/Paris",
+	"Rome":        "Europe/Rome",
+	"Seville":     "Europe/Madrid",
+	"Sydney":      "Australia/Sydney",
+	"Tokyo":       "Asia/Tokyo",
+	"Valencia":    "Europe/Madrid",
+
+	"CDG": "Europe/Paris",
+	"EWR": "America/New_York",
+	"HND": "Asia/Tokyo",
+	"JFK": "America/New_York",
+	"LAX": "America/Los_Angeles",
+	"LGW": "Europe/London",
+	"LHR": "Europe/London",
+	"NRT": "Asia/Tokyo",
+	"ORY": "Europe/Paris",
+}
+
+// Table maps cities and airports to their time zones. A nil Table knows none.
+type Table struct {
+	airports map[string]*time.Location // By IATA code
+	cities   map[string]*time.Location // By lowercased city
+}
+
+// New returns a table of the Default zones with zones over them. Keys are cities, as the
+// database writes them, or IATA airport codes (three capital letters). It fails if a zone
+// isn't a tz database name.
+func New(zones map[string]string) (*Table, error) {
+	t := &Table{airports: make(map[string]*time.Location), cities: make(map[string]*time.Location)}
+	for _, set := range []map[string]string{Default, zones} {
+		for name, zone := range set {
+			loc, err := time.LoadLocation(zone)
+			if err != nil || zone == "" || strings.EqualFold(zone, "local") {
+				return nil, fmt.Errorf("time zone %q of %s is not a tz database name", zone, name)
+			}
+			if isAirportCode(name) {
+				t.airports[name] = loc
+			} else {
+				t.cities[strings.ToLower(strings.TrimSpace(name))] = loc
+			}
+		}
+	}
+	return t, nil
+}
+
+// isAirportCode reports whether name is an IATA airport code: three capital letters.
+func isAirportCode(name string) bool {
+	if len(name) != 3 {
+		return false
+	}
+	for _, r := range name {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Location returns the time zone of airport, if it is known, or else of city. ok is false
+// when neither is known.
+func (t *Table) Location(city, airport string) (loc *time.Location, ok bool) {
+	if t == nil {
+		return nil, false
+	}
+	if loc, ok := t.airports[airport]; ok {
+		return loc, true
+	}
+	loc, ok = t.cities[strings.ToLower(strings.TrimSpace(city))]
+	return loc, ok
+}
+
+// Format writes at in loc, with the zone's abbreviation, followed by the time in UTC:
+// "2025-08-20 11:00 JST / 02:00 UTC". The UTC time gets its date too when it falls on another
+// day. A nil loc writes the time in UTC only: "2025-08-20 02:00 UTC".
+func Format(at time.Time, loc *time.Location) string {
+	utc := at.UTC()
+	if loc == nil || loc == time.UTC {
+		return utc.Format("2006-01-02 15:04 UTC")
+	}
+	local := at.In(loc)
+	if local.Format(time.DateOnly) == utc.Format(time.DateOnly) {
+		return local.Format("2006-01-02 15:04 MST") + " / " + utc.Format("15:04 UTC")
+	}
+	return local.Format("2006-01-02 15:04 MST") + " / " + utc.Format("2006-01-02 15:04 UTC")
+}
+
+// Show is Format for an RFC 3339 time and the tz database name of its zone, as flights carry
+// them. An empty or unknown zone writes the time in UTC only; a time that doesn't parse is
+// returned as it is.
+func Show(at, zone string) string {
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return at
+	}
+	var loc *time.Location
+	if zone != "" {
+		loc, _ = time.LoadLocation(zone)
+	}
+	return Format(t, loc)
+}
@@ -0,0 +1,91 @@
+package timezone
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocation(t *testing.T) {
+	zones, err := New(map[string]string{"Oporto": "Europe/Lisbon", "OPO": "Europe/Lisbon", "LGW": "Europe/Paris"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		city, airport, want string
+	}{
+		{"Tokyo", "", "Asia/Tokyo"},
+		{" los angeles ", "", "America/Los_Angeles"},
+		{"Oporto", "", "Europe/Lisbon"},
+		{"", "OPO", "Europe/Lisbon"},
+		// The airport goes first, so a zone given for it overrides its city's.
+		{"London", "LGW", "Europe/Paris"},
+		{"London", "LHR", "Europe/London"},
+		{"New York", "XXX", "America/New_York"},
+	} {
+		if loc, ok := zones.Location(tt.city, tt.airport); !ok || loc.String() != tt.want {
+			t.Errorf("Location(%q, %q) = %v, %v, want %s", tt.city, tt.airport, loc, ok, tt.want)
+		}
+	}
+	if loc, ok := zones.Location("Atlantis", ""); ok {
+		t.Errorf("Location of an unknown city = %v", loc)
+	}
+	var none *Table
+	if loc, ok := none.Location("Tokyo", "HND"); ok {
+		t.Errorf("Location of a nil table = %v", loc)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, zone := range []string{"Mars/Olympus", "", "Local", "local"} {
+		if _, err := New(map[string]string{"Oporto": zone}); err == nil || !strings.Contains(err.Error(), "Oporto") {
+			t.Errorf("New with zone %q: %v", zone, err)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	la, _ := time.LoadLocation("America/Los_Angeles")
+	madrid, _ := time.LoadLocation("Europe/Madrid")
+	for _, tt := range []struct {
+		at   string
+		loc  *time.Location
+		want string
+	}{
+		// FL118 leaves Tokyo and lands in Los Angeles on the same day, UTC and local.
+		{"2025-08-20T02:00:00Z", tokyo, "2025-08-20 11:00 JST / 02:00 UTC"},
+		{"2025-08-20T12:00:00Z", la, "2025-08-20 05:00 PDT / 12:00 UTC"},
+		// FL119 leaves Los Angeles the evening before, local time.
+		{"2025-08-21T03:00:00Z", la, "2025-08-20 20:00 PDT / 2025-08-21 03:00 UTC"},
+		{"2025-08-21T03:00:00Z", nil, "2025-08-21 03:00 UTC"},
+		{"2025-08-21T03:00:00Z", time.UTC, "2025-08-21 03:00 UTC"},
+		// Madrid moves to summer time at 01:00 UTC on 30 March 2025.
+		{"2025-03-30T00:30:00Z", madrid, "2025-03-30 01:30 CET / 00:30 UTC"},
+		{"2025-03-30T01:30:00Z", madrid, "2025-03-30 03:30 CEST / 01:30 UTC"},
+		// Los Angeles goes back to standard time at 09:00 UTC on 2 November 2025.
+		{"2025-11-02T08:30:00Z", la, "2025-11-02 01:30 PDT / 08:30 UTC"},
+		{"2025-11-02T09:30:00Z", la, "2025-11-02 01:30 PST / 09:30 UTC"},
+	} {
+		at, _ := time.Parse(time.RFC3339, tt.at)
+		if got := Format(at, tt.loc); got != tt.want {
+			t.Errorf("Format(%s, %v) = %q, want %q", tt.at, tt.loc, got, tt.want)
+		}
+	}
+}
+
+func TestShow(t *testing.T) {
+	for _, tt := range []struct {
+		at, zone, want string
+	}{
+		{"2025-08-20T02:00:00Z", "Asia/Tokyo", "2025-08-20 11:00 JST / 02:00 UTC"},
+		{"2025-08-20T11:00:00+09:00", "Asia/Tokyo", "2025-08-20 11:00 JST / 02:00 UTC"},
+		{"2025-08-20T02:00:00Z", "", "2025-08-20 02:00 UTC"},
+		{"2025-08-20T02:00:00Z", "Mars/Olympus", "2025-08-20 02:00 UTC"},
+		{"tomorrow", "Asia/Tokyo", "tomorrow"},
+	} {
+		if got := Show(tt.at, tt.zone); got != tt.want {
+			t.Errorf("Show(%q, %q) = %q, want %q", tt.at, tt.zone, got, tt.want)
+		}
+	}
+}
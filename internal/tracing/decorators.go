@@ -0,0 +1,356 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+)
+
+// tracedLLM runs every call to the wrapped client in an "llm.<method>" span. The client adds
+// token counts to the span itself (see llmclient), since only it sees the response.
+type tracedLLM struct {
+	next        llmclient.LLMClient
+	slot, model string
+}
+
+// TraceLLM wraps client so its calls are traced under the given pipeline slot and model.
+func TraceLLM(client llmclient.LLMClient, slot, model string) llmclient.LLMClient {
+	return &tracedLLM{next: client, slot: slot, model: model}
+}
+
+func (c *tracedLLM) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return Start(ctx, "llm."+method,
+		attribute.String("llm.slot", c.slot),
+		attribute.String("gen_ai.request.model", c.model),
+	)
+}
+
+func (c *tracedLLM) ChatCompletion(ctx context.Context, prompt string) (string, error) {
+	ctx, span := c.start(ctx, "chat")
+	resp, err := c.next.ChatCompletion(ctx, prompt)
+	End(span, err)
+	return resp, err
+}
+
+// StreamChatCompletion's span covers the time until the stream is available, not until it is drained.
+func (c *tracedLLM) StreamChatCompletion(ctx context.Context, prompt string) (<-chan string, error) {
+	ctx, span := c.start(ctx, "stream")
+	stream, err := c.next.StreamChatCompletion(ctx, prompt)
+	End(span, err)
+	return stream, err
+}
+
+// tracedDB runs the wrapped client's data operations in "db.<operation>" spans.
+// Connection management and seeding pass straight through via the embedded Client.
+type tracedDB struct {
+	db.Client
+}
+
+// TraceDB wraps client so its operations are traced. Like metrics.InstrumentDB, wrap the raw
+// backend below any cache so spans stand for real database round trips. The wrapper does not
+// implement optional interfaces such as db.Watcher; check for those on client itself.
+func TraceDB(client db.Client) db.Client {
+	return &tracedDB{Client: client}
+}
+
+// startDB starts the span for one operation; finish it with `defer endDB(span, &err)`.
+func startDB(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Start(ctx, "db."+operation, append(attrs, attribute.String("db.operation.name", operation))...)
+}
+
+func endDB(span trace.Span, err *error) {
+	End(span, *err)
+}
+
+func (c *tracedDB) InsertFlights(ctx context.Context, flights []db.Flight) (err error) {
+	ctx, span := startDB(ctx, "insert_flights", attribute.Int("db.flights", len(flights)))
+	defer endDB(span, &err)
+	return c.Client.InsertFlights(ctx, flights)
+}
+
+func (c *tracedDB) UpsertFlights(ctx context.Context, flights []db.Flight) (_ db.UpsertResult, err error) {
+	ctx, span := startDB(ctx, "upsert_flights", attribute.Int("db.flights", len(flights)))
+	defer endDB(span, &err)
+	return c.Client.UpsertFlights(ctx, flights)
+}
+
+func (c *tracedDB) CreateFlight(ctx context.Context, flight db.Flight) (err error) {
+	ctx, span := startDB(ctx, "create_flight", attribute.String("flight.number", flight.FlightNumber))
+	defer endDB(span, &err)
+	return c.Client.CreateFlight(ctx, flight)
+}
+
+func (c *tracedDB) UpdateFlight(ctx context.Context, flight db.Flight) (err error) {
+	ctx, span := startDB(ctx, "update_flight", attribute.String("flight.number", flight.FlightNumber))
+	defer endDB(span, &err)
+	return c.Client.UpdateFlight(ctx, flight)
+}
+
+func (c *tracedDB) DeleteFlight(ctx context.Context, flightNumber string) (err error) {
+	ctx, span := startDB(ctx, "delete_flight", attribute.String("flight.number", flightNumber))
+	defer endDB(span, &err)
+	return c.Client.DeleteFlight(ctx, flightNumber)
+}
+
+func (c *tracedDB) GetFlight(ctx context.Context, flightNumber string) (_ db.Flight, err error) {
+	ctx, span := startDB(ctx, "get_flight", attribute.String("flight.number", flightNumber))
+	defer endDB(span, &err)
+	return c.Client.GetFlight(ctx, flightNumber)
+}
+
+// SearchFlights is routed through QueryFlights so both are traced as "query_flights".
+func (c *tracedDB) SearchFlights(ctx context.Context, origin, destination string, maxPrice float64) ([]db.Flight, error) {
+	return c.QueryFlights(ctx, db.FlightQuery{Origin: origin, Destination: destination, MaxPrice: maxPrice})
+}
+
+func (c *tracedDB) QueryFlights(ctx context.Context, q db.FlightQuery) (flights []db.Flight, err error) {
+	ctx, span := startDB(ctx, "query_flights",
+		attribute.String("flight.origin", q.Origin),
+		attribute.String("flight.destination", q.Destination),
+	)
+	defer endDB(span, &err)
+	flights, err = c.Client.QueryFlights(ctx, q)
+	span.SetAttributes(attribute.Int("db.result_count", len(flights)))
+	return flights, err
+}
+
+func (c *tracedDB) ListRoutes(ctx context.Context) (routes []db.Route, err error) {
+	ctx, span := startDB(ctx, "list_routes")
+	defer endDB(span, &err)
+	routes, err = c.Client.ListRoutes(ctx)
+	span.SetAttributes(attribute.Int("db.result_count", len(routes)))
+	return routes, err
+}
+
+func (c *tracedDB) ListAirports(ctx context.Context) (airports []db.Airport, err error) {
+	ctx, span := startDB(ctx, "list_airports")
+	defer endDB(span, &err)
+	airports, err = c.Client.ListAirports(ctx)
+	span.SetAttributes(attribute.Int("db.result_count", len(airports)))
+	return airports, err
+}
+
+func (c *tracedDB) UpsertSchedule(ctx context.Context, schedule db.FlightSchedule) (err error) {
+	ctx, span := startDB(ctx, "upsert_schedule", attribute.String("flight.number", schedule.FlightNumber))
+	defer endDB(span, &err)
+	return c.Client.UpsertSchedule(ctx, schedule)
+}
+
+func (c *tracedDB) GetSchedule(ctx context.Context, flightNumber string) (_ db.FlightSchedule, err error) {
+	ctx, span := startDB(ctx, "get_schedule", attribute.String("flight.number", flightNumber))
+	defer endDB(span, &err)
+	return c.Client.GetSchedule(ctx, flightNumber)
+}
+
+func (c *tracedDB) ListSchedules(ctx context.Context) (_ []db.FlightSchedule, err error) {
+	ctx, span := startDB(ctx, "list_schedules")
+	defer endDB(span, &err)
+	return c.Client.ListSchedules(ctx)
+}
+
+func (c *tracedDB) DeleteSchedule(ctx context.Context, flightNumber string) (err error) {
+	ctx, span := startDB(ctx, "delete_schedule", attribute.String("flight.number", flightNumber))
+	defer endDB(span, &err)
+	return c.Client.DeleteSchedule(ctx, flightNumber)
+}
+
+func (c *tracedDB) InsertQueryLog(ctx context.Context, entry db.QueryLog) (err error) {
+	ctx, span := startDB(ctx, "insert_query_log")
+	defer endDB(span, &err)
+	return c.Client.InsertQueryLog(ctx, entry)
+}
+
+func (c *tracedDB) GetQueryLog(ctx context.Context, requestID string) (_ db.QueryLog, err error) {
+	ctx, span := startDB(ctx, "get_query_log")
+	defer endDB(span, &err)
+	return c.Client.GetQueryLog(ctx, requestID)
+}
+
+func (c *tracedDB) GetQueryStats(ctx context.Context, since time.Time) (_ db.QueryStats, err error) {
+	ctx, span := startDB(ctx, "get_query_stats")
+	defer endDB(span, &err)
+	return c.Client.GetQueryStats(ctx, since)
+}
+
+func (c *tracedDB) AppendTurns(ctx context.Context, sessionID, client string, turns ...db.Turn) (err error) {
+	ctx, span := startDB(ctx, "append_turns", attribute.Int("db.turns", len(turns)))
+	defer endDB(span, &err)
+	return c.Client.AppendTurns(ctx, sessionID, client, turns...)
+}
+
+func (c *tracedDB) GetConversation(ctx context.Context, sessionID string) (_ db.Conversation, err error) {
+	ctx, span := startDB(ctx, "get_conversation")
+	defer endDB(span, &err)
+	return c.Client.GetConversation(ctx, sessionID)
+}
+
+func (c *tracedDB) SetConversationTitle(ctx context.Context, sessionID, client, title string, overwrite bool) (err error) {
+	ctx, span := startDB(ctx, "set_conversation_title")
+	defer endDB(span, &err)
+	return c.Client.SetConversationTitle(ctx, sessionID, client, title, overwrite)
+}
+
+func (c *tracedDB) ListConversations(ctx context.Context, client string, limit int) (_ []db.ConversationSummary, err error) {
+	ctx, span := startDB(ctx, "list_conversations")
+	defer endDB(span, &err)
+	return c.Client.ListConversations(ctx, client, limit)
+}
+
+func (c *tracedDB) ShareConversation(ctx context.Context, sessionID, client string, share db.ConversationShare) (err error) {
+	ctx, span := startDB(ctx, "share_conversation")
+	defer endDB(span, &err)
+	return c.Client.ShareConversation(ctx, sessionID, client, share)
+}
+
+func (c *tracedDB) UnshareConversation(ctx context.Context, sessionID, client string) (err error) {
+	ctx, span := startDB(ctx, "unshare_conversation")
+	defer endDB(span, &err)
+	return c.Client.UnshareConversation(ctx, sessionID, client)
+}
+
+func (c *tracedDB) GetSharedConversation(ctx context.Context, tokenHash string, now time.Time) (_ db.Conversation, err error) {
+	ctx, span := startDB(ctx, "get_shared_conversation")
+	defer endDB(span, &err)
+	return c.Client.GetSharedConversation(ctx, tokenHash, now)
+}
+
+func (c *tracedDB) IncrementUsage(ctx context.Context, delta db.UsageDelta) (err error) {
+	ctx, span := startDB(ctx, "increment_usage")
+	defer endDB(span, &err)
+	return c.Client.IncrementUsage(ctx, delta)
+}
+
+func (c *tracedDB) ListUsage(ctx context.Context, q db.UsageQuery) (_ []db.Usage, err error) {
+	ctx, span := startDB(ctx, "list_usage")
+	defer endDB(span, &err)
+	return c.Client.ListUsage(ctx, q)
+}
+
+func (c *tracedDB) SaveJob(ctx context.Context, job db.Job) (err error) {
+	ctx, span := startDB(ctx, "save_job")
+	defer endDB(span, &err)
+	return c.Client.SaveJob(ctx, job)
+}
+
+func (c *tracedDB) GetJob(ctx context.Context, id string) (_ db.Job, err error) {
+	ctx, span := startDB(ctx, "get_job")
+	defer endDB(span, &err)
+	return c.Client.GetJob(ctx, id)
+}
+
+func (c *tracedDB) CreateIdempotencyKey(ctx context.Context, record db.IdempotencyRecord) (err error) {
+	ctx, span := startDB(ctx, "create_idempotency_key")
+	defer endDB(span, &err)
+	return c.Client.CreateIdempotencyKey(ctx, record)
+}
+
+func (c *tracedDB) GetIdempotencyKey(ctx context.Context, id string) (_ db.IdempotencyRecord, err error) {
+	ctx, span := startDB(ctx, "get_idempotency_key")
+	defer endDB(span, &err)
+	return c.Client.GetIdempotencyKey(ctx, id)
+}
+
+func (c *tracedDB) SaveIdempotencyKey(ctx context.Context, record db.IdempotencyRecord) (err error) {
+	ctx, span := startDB(ctx, "save_idempotency_key")
+	defer endDB(span, &err)
+	return c.Client.SaveIdempotencyKey(ctx, record)
+}
+
+func (c *tracedDB) DeleteIdempotencyKey(ctx context.Context, id string) (err error) {
+	ctx, span := startDB(ctx, "delete_idempotency_key")
+	defer endDB(span, &err)
+	return c.Client.DeleteIdempotencyKey(ctx, id)
+}
+
+func (c *tracedDB) AppendStreamEvents(ctx context.Context, id, sessionID string, events []db.StoredEvent, done bool, expiresAt time.Time) (err error) {
+	ctx, span := startDB(ctx, "append_stream_events")
+	defer endDB(span, &err)
+	return c.Client.AppendStreamEvents(ctx, id, sessionID, events, done, expiresAt)
+}
+
+func (c *tracedDB) GetStream(ctx context.Context, id string) (_ db.SharedStream, err error) {
+	ctx, span := startDB(ctx, "get_stream")
+	defer endDB(span, &err)
+	return c.Client.GetStream(ctx, id)
+}
+
+func (c *tracedDB) ListFlags(ctx context.Context) (flags []db.Flag, err error) {
+	ctx, span := startDB(ctx, "list_flags")
+	defer endDB(span, &err)
+	flags, err = c.Client.ListFlags(ctx)
+	span.SetAttributes(attribute.Int("db.result_count", len(flags)))
+	return flags, err
+}
+
+func (c *tracedDB) SaveFlag(ctx context.Context, flag db.Flag) (err error) {
+	ctx, span := startDB(ctx, "save_flag", attribute.String("flag.name", flag.Name))
+	defer endDB(span, &err)
+	return c.Client.SaveFlag(ctx, flag)
+}
+
+func (c *tracedDB) DeleteFlag(ctx context.Context, name string) (err error) {
+	ctx, span := startDB(ctx, "delete_flag", attribute.String("flag.name", name))
+	defer endDB(span, &err)
+	return c.Client.DeleteFlag(ctx, name)
+}
+
+func (c *tracedDB) GetPreferences(ctx context.Context, sessionID string) (_ db.Preferences, err error) {
+	ctx, span := startDB(ctx, "get_preferences")
+	defer endDB(span, &err)
+	return c.Client.GetPreferences(ctx, sessionID)
+}
+
+func (c *tracedDB) SavePreferences(ctx context.Context, prefs db.Preferences) (err error) {
+	ctx, span := startDB(ctx, "save_preferences")
+	defer endDB(span, &err)
+	return c.Client.SavePreferences(ctx, prefs)
+}
+
+func (c *tracedDB) DeleteSessionData(ctx context.Context, sessionID string, dryRun bool) (_ db.RecordCounts, err error) {
+	ctx, span := startDB(ctx, "delete_session_data")
+	defer endDB(span, &err)
+	return c.Client.DeleteSessionData(ctx, sessionID, dryRun)
+}
+
+func (c *tracedDB) PurgeExpired(ctx context.Context, r db.Retention, now time.Time) (_ db.RecordCounts, err error) {
+	ctx, span := startDB(ctx, "purge_expired")
+	defer endDB(span, &err)
+	return c.Client.PurgeExpired(ctx, r, now)
+}
+
+func (c *tracedDB) RecordRejection(ctx context.Context, rejection db.Rejection, since time.Time) (_ int, err error) {
+	ctx, span := startDB(ctx, "record_rejection", attribute.String("rejection.kind", rejection.Kind))
+	defer endDB(span, &err)
+	return c.Client.RecordRejection(ctx, rejection, since)
+}
+
+func (c *tracedDB) SaveBan(ctx context.Context, ban db.Ban) (err error) {
+	ctx, span := startDB(ctx, "save_ban")
+	defer endDB(span, &err)
+	return c.Client.SaveBan(ctx, ban)
+}
+
+func (c *tracedDB) GetBan(ctx context.Context, client string, now time.Time) (_ db.Ban, err error) {
+	ctx, span := startDB(ctx, "get_ban")
+	defer endDB(span, &err)
+	return c.Client.GetBan(ctx, client, now)
+}
+
+func (c *tracedDB) ListBans(ctx context.Context, activeAt time.Time) (bans []db.Ban, err error) {
+	ctx, span := startDB(ctx, "list_bans")
+	defer endDB(span, &err)
+	bans, err = c.Client.ListBans(ctx, activeAt)
+	span.SetAttributes(attribute.Int("db.result_count", len(bans)))
+	return bans, err
+}
+
+func (c *tracedDB) LiftBan(ctx context.Context, client, by string, at time.Time) (_ db.Ban, err error) {
+	ctx, span := startDB(ctx, "lift_ban")
+	defer endDB(span, &err)
+	return c.Client.LiftBan(ctx, client, by, at)
+}
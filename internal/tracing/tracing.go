@@ -0,0 +1,180 @@
+// Package tracing wires OpenTelemetry tracing through the request pipeline: a server span per
+// HTTP request, with child spans for intent detection, every LLM call, every database
+// operation and the SSE write loop.
+//
+// Tracing is off by default. Setup turns it on when the standard OTEL_* environment variables
+// ask for it and exports spans over OTLP/HTTP; while it is off every span is a cheap no-op.
+// Incoming W3C traceparent headers are honoured either way, so log lines still carry the
+// caller's trace ID.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Cris245/go-llm-chat/internal/logging"
+)
+
+// instrumentationName identifies this module's spans to the tracer provider.
+const instrumentationName = "github.com/Cris245/go-llm-chat"
+
+// defaultServiceName is reported unless OTEL_SERVICE_NAME or OTEL_RESOURCE_ATTRIBUTES set one.
+const defaultServiceName = "go-llm-chat"
+
+// Enabled reports whether the environment asks for traces to be exported:
+// OTEL_TRACES_EXPORTER=otlp, or an OTLP endpoint without an explicit exporter.
+// OTEL_SDK_DISABLED=true always wins. Other exporters are rejected rather than ignored.
+func Enabled(getenv func(string) string) (bool, error) {
+	if strings.EqualFold(getenv("OTEL_SDK_DISABLED"), "true") {
+		return false, nil
+	}
+	switch exporter := strings.ToLower(getenv("OTEL_TRACES_EXPORTER")); exporter {
+	case "otlp":
+		return true, nil
+	case "none":
+		return false, nil
+	case "":
+		return getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "", nil
+	default:
+		return false, fmt.Errorf("OTEL_TRACES_EXPORTER=%q is not supported (want \"otlp\" or \"none\")", exporter)
+	}
+}
+
+// Setup installs the W3C trace-context propagator and, if Enabled, a tracer provider that
+// batches spans to an OTLP/HTTP exporter. The exporter, sampler and batching read their own
+// settings from the environment (OTEL_EXPORTER_OTLP_*, OTEL_TRACES_SAMPLER, OTEL_BSP_*).
+// The returned function flushes pending spans; call it on shutdown. It is a no-op when
+// tracing is disabled.
+func Setup(ctx context.Context, getenv func(string) string) (shutdown func(context.Context) error, enabled bool, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	noop := func(context.Context) error { return nil }
+
+	enabled, err = Enabled(getenv)
+	if err != nil || !enabled {
+		return noop, false, err
+	}
+	protocol := getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	if protocol != "" && protocol != "http/protobuf" {
+		return noop, false, fmt.Errorf("OTLP protocol %q is not supported (want \"http/protobuf\")", protocol)
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return noop, false, fmt.Errorf("creating OTLP trace exporter: %w", err)
+	}
+	// Later sources win: the environment overrides the default service name.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", defaultServiceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		return noop, false, fmt.Errorf("building trace resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, true, nil
+}
+
+// Start starts a span named name as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it failed if err is non-nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// statusRecorder captures the status code of a response while keeping Flush working for SSE.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// OutboundHeaders adds ctx's request ID (logging.RequestIDHeader) and W3C trace context to the
+// headers of a request made to another service on its behalf, so that service's records can be
+// tied to the request's. The trace context is the current span's; while tracing is off it is
+// the caller's traceparent passed through, and there is none if the caller sent none. It is an
+// llmclient.RequestHeaders.
+func OutboundHeaders(ctx context.Context, header http.Header) {
+	if id := logging.RequestID(ctx); id != "" {
+		header.Set(logging.RequestIDHeader, id)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Middleware runs next inside a server span named after the method and route, continuing the
+// caller's trace when the request carries a traceparent header. Use the route pattern, not the
+// raw path, for the name. Put it inside the request ID middleware so the span records the ID.
+func Middleware(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", logging.RequestPath(r)),
+				attribute.String("request.id", logging.RequestID(r.Context())),
+			))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/logging"
+)
+
+// recordSpans installs a tracer provider that keeps every span in memory, for the rest of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return recorder
+}
+
+// attrs returns a span's attributes by key.
+func attrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestEnabled(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		env     map[string]string
+		want    bool
+		wantErr bool
+	}{
+		{"nothing set", nil, false, false},
+		{"endpoint", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, true, false},
+		{"traces endpoint", map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://collector:4318/v1/traces"}, true, false},
+		{"otlp exporter", map[string]string{"OTEL_TRACES_EXPORTER": "OTLP"}, true, false},
+		{"none exporter", map[string]string{"OTEL_TRACES_EXPORTER": "none", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, false, false},
+		{"SDK disabled", map[string]string{"OTEL_SDK_DISABLED": "true", "OTEL_TRACES_EXPORTER": "otlp"}, false, false},
+		{"other exporter", map[string]string{"OTEL_TRACES_EXPORTER": "zipkin"}, false, true},
+	} {
+		got, err := Enabled(func(name string) string { return tt.env[name] })
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: Enabled = %v, %v", tt.name, got, err)
+		}
+	}
+}
+
+func TestMiddlewareContinuesTrace(t *testing.T) {
+	recorder := recordSpans(t)
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	handler := Middleware("/api/flights/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, child := Start(r.Context(), "child")
+		child.End()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	r := httptest.NewRequest(http.MethodGet, "/api/flights/IB101", nil)
+	r.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	handler(httptest.NewRecorder(), r.WithContext(logging.WithRequestID(r.Context(), "req-1")))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans, want the child and the server span", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if server.Name() != "GET /api/flights/{id}" || server.SpanContext().TraceID().String() != traceID || server.Parent().SpanID().String() != parentID {
+		t.Errorf("server span %s in trace %s under %s", server.Name(), server.SpanContext().TraceID(), server.Parent().SpanID())
+	}
+	if child.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("the handler's span isn't a child of the server span")
+	}
+	a := attrs(server)
+	if a["http.response.status_code"].AsInt64() != 503 || a["request.id"].AsString() != "req-1" || a["url.path"].AsString() != "/api/flights/IB101" {
+		t.Errorf("server span attributes %v", a)
+	}
+	if server.Status().Code != codes.Error {
+		t.Errorf("server span status %v, want an error for a 503", server.Status())
+	}
+}
+
+// failingLLM fails every call.
+type failingLLM struct{}
+
+func (failingLLM) ChatCompletion(context.Context, string) (string, error) {
+	return "", errors.New("provider down")
+}
+
+func (failingLLM) StreamChatCompletion(context.Context, string) (<-chan string, error) {
+	return nil, errors.New("provider down")
+}
+
+func TestDecoratorSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	ctx := context.Background()
+	TraceLLM(&llmclient.MockClient{Response: "hi"}, "llm1", "test-model").ChatCompletion(ctx, "hello")
+	TraceLLM(failingLLM{}, "llm2", "test-model").StreamChatCompletion(ctx, "hello")
+	store := TraceDB(db.NewMemoryClient())
+	store.SearchFlights(ctx, "Madrid", "Paris", 0)
+	store.GetFlight(ctx, "XX999")
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("%d spans, want 4", len(spans))
+	}
+	for i, want := range []struct {
+		name string
+		attr attribute.KeyValue
+		err  bool
+	}{
+		{"llm.chat", attribute.String("llm.slot", "llm1"), false},
+		{"llm.stream", attribute.String("gen_ai.request.model", "test-model"), true},
+		{"db.query_flights", attribute.String("flight.origin", "Madrid"), false},
+		{"db.get_flight", attribute.String("db.operation.name", "get_flight"), true},
+	} {
+		span := spans[i]
+		if span.Name() != want.name || attrs(span)[want.attr.Key] != want.attr.Value || (span.Status().Code == codes.Error) != want.err {
+			t.Errorf("span %d = %s %v %v; want %s with %v, failed %v", i, span.Name(), span.Attributes(), span.Status(), want.name, want.attr, want.err)
+		}
+	}
+}
+
+func TestOutboundHeaders(t *testing.T) {
+	recordSpans(t)
+	ctx, span := Start(logging.WithRequestID(context.Background(), "req-1"), "call")
+	defer span.End()
+	header := http.Header{}
+	OutboundHeaders(ctx, header)
+	if header.Get(logging.RequestIDHeader) != "req-1" {
+		t.Errorf("request ID header %q", header.Get(logging.RequestIDHeader))
+	}
+	want := "00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"
+	if header.Get("traceparent") != want {
+		t.Errorf("traceparent %q, want %q", header.Get("traceparent"), want)
+	}
+}
+
+func TestOutboundHeadersTracingOff(t *testing.T) {
+	// Setup installs the propagator even when tracing is off; the tracer provider is a no-op.
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	for _, incoming := range []string{traceparent, ""} {
+		var header http.Header
+		h := Middleware("/api", func(w http.ResponseWriter, r *http.Request) {
+			header = http.Header{}
+			OutboundHeaders(r.Context(), header)
+		})
+		r := httptest.NewRequest(http.MethodPost, "/api", nil)
+		if incoming != "" {
+			r.Header.Set("traceparent", incoming)
+		}
+		h(httptest.NewRecorder(), r.WithContext(logging.WithRequestID(r.Context(), "req-1")))
+		// The caller's trace context is passed on as it came, if it came.
+		if header.Get("traceparent") != incoming || header.Get(logging.RequestIDHeader) != "req-1" {
+			t.Errorf("incoming traceparent %q: outbound headers %v", incoming, header)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Package version reports which build of the server is running.
+//
+// Version, Commit and BuildDate are set at link time:
+//
+//	go build -ldflags "-X github.com/Cris245/go-llm-chat/internal/version.Version=v1.2.0 \
+//	  -X github.com/Cris245/go-llm-chat/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/Cris245/go-llm-chat/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/server
+//
+// Without ldflags, Get falls back to the VCS details the Go toolchain embeds, and then to "dev"/"unknown".
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags "-X"; see the package documentation.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// Info describes the running build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information, filling commit and date from the embedded VCS stamp
+// (present in builds from a git checkout) when ldflags didn't set them.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range build.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "unknown":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildDate == "unknown":
+				info.BuildDate = s.Value
+			}
+		}
+	}
+	return info
+}
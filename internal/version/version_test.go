@@ -0,0 +1,26 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGetDefaults(t *testing.T) {
+	info := Get()
+	// Test binaries carry no VCS stamp, so the defaults show through.
+	if info.Version != "dev" || info.Commit == "" || info.BuildDate == "" || info.GoVersion != runtime.Version() {
+		t.Errorf("Get() = %+v", info)
+	}
+}
+
+func TestGetLinkerValues(t *testing.T) {
+	prevVersion, prevCommit, prevDate := Version, Commit, BuildDate
+	t.Cleanup(func() { Version, Commit, BuildDate = prevVersion, prevCommit, prevDate })
+	// What -ldflags "-X ..." would set.
+	Version, Commit, BuildDate = "v1.2.0", "abc1234", "2026-05-01T08:00:00Z"
+
+	want := Info{Version: "v1.2.0", Commit: "abc1234", BuildDate: "2026-05-01T08:00:00Z", GoVersion: runtime.Version()}
+	if info := Get(); info != want {
+		t.Errorf("Get() = %+v, want %+v", info, want)
+	}
+}
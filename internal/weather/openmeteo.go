@@ -0,0 +1,158 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Open-Meteo's public API endpoints.
+const (
+	DefaultGeocodingURL = "https://geocoding-api.open-meteo.com/v1/search"
+	DefaultForecastURL  = "https://api.open-meteo.com/v1/forecast"
+)
+
+// OpenMeteo looks up forecasts with Open-Meteo: the city is located with its geocoding API,
+// then the day's forecast is read from its forecast API. Forecasts reach about 16 days ahead;
+// other dates fail with the API's error. Cities are few, so their locations are cached.
+type OpenMeteo struct {
+	geocodingURL string
+	forecastURL  string
+	httpClient   *http.Client
+
+	mu        sync.Mutex
+	locations map[string]location // By lowercased city name
+}
+
+// location is a geocoded city.
+type location struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// NewOpenMeteo returns a provider using the given endpoints, or Open-Meteo's public ones
+// where they are empty.
+func NewOpenMeteo(geocodingURL, forecastURL string) *OpenMeteo {
+	if geocodingURL == "" {
+		geocodingURL = DefaultGeocodingURL
+	}
+	if forecastURL == "" {
+		forecastURL = DefaultForecastURL
+	}
+	return &OpenMeteo{
+		geocodingURL: geocodingURL,
+		forecastURL:  forecastURL,
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		locations:    make(map[string]location),
+	}
+}
+
+// Forecast returns the forecast for city on date's calendar day.
+func (m *OpenMeteo) Forecast(ctx context.Context, city string, date time.Time) (Forecast, error) {
+	loc, err := m.locate(ctx, city)
+	if err != nil {
+		return Forecast{}, err
+	}
+	day := date.Format(time.DateOnly)
+	params := url.Values{
+		"latitude":   {fmt.Sprint(loc.Latitude)},
+		"longitude":  {fmt.Sprint(loc.Longitude)},
+		"daily":      {"weather_code,temperature_2m_max,temperature_2m_min,precipitation_probability_max"},
+		"timezone":   {"auto"},
+		"start_date": {day},
+		"end_date":   {day},
+	}
+	var resp struct {
+		Daily struct {
+			Time                []string   `json:"time"`
+			WeatherCode         []*int     `json:"weather_code"`
+			TempMax             []*float64 `json:"temperature_2m_max"`
+			TempMin             []*float64 `json:"temperature_2m_min"`
+			PrecipitationChance []*int     `json:"precipitation_probability_max"`
+		} `json:"daily"`
+	}
+	if err := m.get(ctx, m.forecastURL, params, &resp); err != nil {
+		return Forecast{}, fmt.Errorf("forecast for %s on %s: %w", city, day, err)
+	}
+	d := resp.Daily
+	if len(d.Time) == 0 || len(d.WeatherCode) == 0 || len(d.TempMax) == 0 || len(d.TempMin) == 0 ||
+		d.WeatherCode[0] == nil || d.TempMax[0] == nil || d.TempMin[0] == nil {
+		return Forecast{}, fmt.Errorf("forecast for %s on %s: no data", city, day)
+	}
+	forecast := Forecast{
+		City:       loc.Name,
+		Date:       d.Time[0],
+		Conditions: conditions(*d.WeatherCode[0]),
+		TempMinC:   *d.TempMin[0],
+		TempMaxC:   *d.TempMax[0],
+	}
+	if len(d.PrecipitationChance) > 0 && d.PrecipitationChance[0] != nil {
+		forecast.PrecipitationChance = *d.PrecipitationChance[0]
+	}
+	return forecast, nil
+}
+
+// locate geocodes city, from the cache when it was looked up before.
+func (m *OpenMeteo) locate(ctx context.Context, city string) (location, error) {
+	key := strings.ToLower(city)
+	m.mu.Lock()
+	loc, ok := m.locations[key]
+	m.mu.Unlock()
+	if ok {
+		return loc, nil
+	}
+
+	var resp struct {
+		Results []location `json:"results"`
+	}
+	params := url.Values{"name": {city}, "count": {"1"}, "language": {"en"}, "format": {"json"}}
+	if err := m.get(ctx, m.geocodingURL, params, &resp); err != nil {
+		return location{}, fmt.Errorf("locate %s: %w", city, err)
+	}
+	if len(resp.Results) == 0 {
+		return location{}, fmt.Errorf("locate %s: %w", city, ErrUnknownCity)
+	}
+	loc = resp.Results[0]
+	m.mu.Lock()
+	m.locations[key] = loc
+	m.mu.Unlock()
+	return loc, nil
+}
+
+// get fetches endpoint with params and decodes the JSON response into out. Open-Meteo answers
+// errors with {"error":true,"reason":"..."}.
+func (m *OpenMeteo) get(ctx context.Context, endpoint string, params url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Reason string `json:"reason"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Reason != "" {
+			return fmt.Errorf("open-meteo: %s (status %d)", apiErr.Reason, resp.StatusCode)
+		}
+		return fmt.Errorf("open-meteo: status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
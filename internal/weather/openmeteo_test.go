@@ -0,0 +1,82 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeOpenMeteo serves the geocoding and forecast APIs, knowing only Paris.
+func fakeOpenMeteo(t *testing.T, geocodings *atomic.Int32) *OpenMeteo {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		geocodings.Add(1)
+		if r.URL.Query().Get("name") != "Paris" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Write([]byte(`{"results":[{"name":"Paris","latitude":48.85,"longitude":2.35}]}`))
+	})
+	mux.HandleFunc("/forecast", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("start_date") != "2025-08-10" || q.Get("latitude") != "48.85" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":true,"reason":"Parameter 'start_date' is out of allowed range"}`))
+			return
+		}
+		w.Write([]byte(`{"daily":{"time":["2025-08-10"],"weather_code":[61],"temperature_2m_max":[21.4],"temperature_2m_min":[13.8],"precipitation_probability_max":[60]}}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return NewOpenMeteo(srv.URL+"/search", srv.URL+"/forecast")
+}
+
+func TestOpenMeteoForecast(t *testing.T) {
+	var geocodings atomic.Int32
+	m := fakeOpenMeteo(t, &geocodings)
+	ctx := context.Background()
+	arrival := time.Date(2025, 8, 10, 11, 0, 0, 0, time.UTC)
+
+	for range 2 {
+		forecast, err := m.Forecast(ctx, "Paris", arrival)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Forecast{City: "Paris", Date: "2025-08-10", Conditions: "light rain", TempMinC: 13.8, TempMaxC: 21.4, PrecipitationChance: 60}
+		if forecast != want {
+			t.Errorf("forecast %+v, want %+v", forecast, want)
+		}
+	}
+	if got := geocodings.Load(); got != 1 {
+		t.Errorf("%d geocoding calls, want the location cached after one", got)
+	}
+
+	if _, err := m.Forecast(ctx, "Atlantis", arrival); !errors.Is(err, ErrUnknownCity) {
+		t.Errorf("unknown city: %v", err)
+	}
+	_, err := m.Forecast(ctx, "Paris", arrival.AddDate(1, 0, 0))
+	if err == nil || !strings.Contains(err.Error(), "out of allowed range") {
+		t.Errorf("date out of range: %v, want the API's reason", err)
+	}
+}
+
+func TestForecastSummary(t *testing.T) {
+	f := Forecast{City: "Paris", Date: "2025-08-10", Conditions: "light rain", TempMinC: 13.8, TempMaxC: 21.4, PrecipitationChance: 60}
+	if got, want := f.Summary(), "Paris on 2025-08-10: light rain, 14–21 °C, 60% chance of precipitation"; got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if p, err := New(Config{Provider: ProviderOpenMeteo}); err != nil || p == nil {
+		t.Errorf("New(open-meteo) = %v, %v", p, err)
+	}
+	if _, err := New(Config{Provider: "sky"}); err == nil {
+		t.Error("New accepted an unknown provider")
+	}
+}
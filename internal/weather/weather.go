@@ -0,0 +1,93 @@
+// Package weather looks up the weather forecast for a city on a day, to enrich flight answers
+// with the conditions travellers can expect when they land.
+//
+// Provider is the interface the orchestrator uses; OpenMeteo implements it with the free
+// Open-Meteo APIs, which need no API key.
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Providers accepted by New.
+const (
+	ProviderOpenMeteo = "open-meteo"
+)
+
+// Providers lists the providers New can construct, for validation and error messages.
+var Providers = []string{ProviderOpenMeteo}
+
+// ErrUnknownCity is returned for a city the provider can't locate.
+var ErrUnknownCity = errors.New("unknown city")
+
+// Forecast is the weather expected in a city on one day.
+type Forecast struct {
+	City                string  `json:"city"`
+	Date                string  `json:"date"`       // YYYY-MM-DD, in the city's time zone
+	Conditions          string  `json:"conditions"` // e.g. "light rain"
+	TempMinC            float64 `json:"temp_min_c"`
+	TempMaxC            float64 `json:"temp_max_c"`
+	PrecipitationChance int     `json:"precipitation_chance"` // Percent
+}
+
+// Summary renders the forecast as one line, e.g. "Paris on 2025-08-10: light rain, 14–21 °C,
+// 60% chance of precipitation".
+func (f Forecast) Summary() string {
+	return fmt.Sprintf("%s on %s: %s, %.0f–%.0f °C, %d%% chance of precipitation",
+		f.City, f.Date, f.Conditions, f.TempMinC, f.TempMaxC, f.PrecipitationChance)
+}
+
+// Provider looks up forecasts. Implementations must honor ctx, since lookups run while a
+// request is waiting.
+type Provider interface {
+	Forecast(ctx context.Context, city string, date time.Time) (Forecast, error)
+}
+
+// Config describes the provider to construct.
+type Config struct {
+	Provider     string
+	GeocodingURL string // Optional; the provider's public API if empty
+	ForecastURL  string // Optional; the provider's public API if empty
+}
+
+// New constructs the provider named by cfg.Provider.
+func New(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case ProviderOpenMeteo:
+		return NewOpenMeteo(cfg.GeocodingURL, cfg.ForecastURL), nil
+	default:
+		return nil, fmt.Errorf("unknown weather provider %q (supported: %v)", cfg.Provider, Providers)
+	}
+}
+
+// conditions describes WMO weather interpretation codes, as used by Open-Meteo.
+func conditions(code int) string {
+	switch {
+	case code == 0:
+		return "clear sky"
+	case code <= 2:
+		return "partly cloudy"
+	case code == 3:
+		return "overcast"
+	case code == 45 || code == 48:
+		return "fog"
+	case code >= 51 && code <= 57:
+		return "drizzle"
+	case code == 61 || code == 80:
+		return "light rain"
+	case code == 63 || code == 81:
+		return "rain"
+	case code == 65 || code == 82:
+		return "heavy rain"
+	case code == 66 || code == 67:
+		return "freezing rain"
+	case code >= 71 && code <= 77, code == 85, code == 86:
+		return "snow"
+	case code >= 95:
+		return "thunderstorms"
+	}
+	return "mixed conditions"
+}
@@ -0,0 +1,191 @@
+// Package webhook delivers signed JSON callbacks to URLs chosen by API clients, retrying
+// failed deliveries with backoff. Receivers verify them with pkg/webhookverify.
+//
+// Every callback carries four headers: X-Webhook-Delivery, the delivery's ID, which increases
+// with each callback and is kept when a failed delivery is retried; X-Webhook-Timestamp, the
+// Unix time the attempt was signed; X-Webhook-Nonce, random for each attempt; and
+// X-Webhook-Signature, "v2=" followed by the hex HMAC-SHA256, keyed with the shared secret, of
+// "v2:<timestamp>:<delivery>:<nonce>:<body>". Receivers reject stale timestamps and nonces
+// they have seen, so a captured callback can't be replayed, and drop deliveries they have
+// already handled.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Header names of a callback.
+const (
+	DeliveryHeader  = "X-Webhook-Delivery"
+	TimestampHeader = "X-Webhook-Timestamp"
+	NonceHeader     = "X-Webhook-Nonce"
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event" // The payload's type, so receivers can route before parsing
+)
+
+const (
+	defaultTimeout   = 10 * time.Second
+	defaultBaseDelay = time.Second
+	maxRetryAfter    = time.Minute // A receiver's Retry-After is honored up to this long
+)
+
+// Sign returns the X-Webhook-Signature value for body, sent as delivery with nonce, signed at
+// timestamp.
+func Sign(secret string, timestamp time.Time, delivery, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v2:" + strconv.FormatInt(timestamp.Unix(), 10) + ":" + delivery + ":" + nonce + ":"))
+	mac.Write(body)
+	return "v2=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// StatusError is a delivery the receiver answered with a non-2xx status.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // From the receiver's Retry-After header, if any
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("callback receiver answered %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// retryable reports whether a failed delivery may succeed later: network failures, timeouts,
+// 408, 429 and 5xx are retried; other statuses mean the receiver rejected the callback.
+func retryable(err error) bool {
+	var status *StatusError
+	if !errors.As(err, &status) {
+		return true
+	}
+	return status.StatusCode == http.StatusRequestTimeout ||
+		status.StatusCode == http.StatusTooManyRequests ||
+		status.StatusCode >= 500
+}
+
+// Sender signs and POSTs callbacks. Create it with NewSender.
+type Sender struct {
+	Secret     string
+	HTTPClient *http.Client
+	BaseDelay  time.Duration // First retry's backoff; each further retry doubles it
+	UserAgent  string
+
+	now          func() time.Time                     // Replaced in tests
+	after        func(time.Duration) <-chan time.Time // Replaced in tests
+	lastDelivery atomic.Int64                         // The ID of the last delivery; see nextDelivery
+}
+
+// NewSender returns a sender signing with secret, whose deliveries time out after timeout
+// (10 seconds if 0).
+func NewSender(secret string, timeout time.Duration) *Sender {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Sender{
+		Secret:     secret,
+		HTTPClient: &http.Client{Timeout: timeout},
+		BaseDelay:  defaultBaseDelay,
+		UserAgent:  "go-llm-chat-webhook",
+		now:        time.Now,
+		after:      time.After,
+	}
+}
+
+// nextDelivery returns the ID of a new delivery: the current time in microseconds, or one more
+// than the last ID if that is later, so IDs keep increasing across restarts and are unique
+// within a process.
+func (s *Sender) nextDelivery() string {
+	for {
+		last := s.lastDelivery.Load()
+		id := max(last+1, s.now().UnixMicro())
+		if s.lastDelivery.CompareAndSwap(last, id) {
+			return strconv.FormatInt(id, 10)
+		}
+	}
+}
+
+// Send POSTs payload as JSON to url, trying at most attempts times (at least once). Failures
+// that may be temporary are retried with exponential backoff and jitter; a Retry-After from
+// the receiver lengthens the wait. Every attempt has the same delivery ID, so a receiver that
+// handled an attempt whose answer was lost can drop the next. It returns how many attempts were
+// made and the last error, or nil once the receiver answered 2xx. ctx bounds the whole
+// delivery, waits included.
+func (s *Sender) Send(ctx context.Context, url, event string, payload any, attempts int) (int, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("encode %s callback: %w", event, err)
+	}
+	attempts = max(attempts, 1)
+	delivery := s.nextDelivery()
+	for attempt := 1; ; attempt++ {
+		err = s.post(ctx, url, event, delivery, body)
+		if err == nil || attempt == attempts || !retryable(err) {
+			return attempt, err
+		}
+		delay := s.BaseDelay << (attempt - 1)
+		delay += mathrand.N(delay/2 + 1) // Jitter keeps a receiver's many callbacks from retrying in lockstep.
+		var status *StatusError
+		if errors.As(err, &status) && status.RetryAfter > delay {
+			delay = min(status.RetryAfter, maxRetryAfter)
+		}
+		slog.WarnContext(ctx, "Retrying callback delivery", "event", event, "attempt", attempt+1, "delay", delay, "error", err)
+		select {
+		case <-s.after(delay):
+		case <-ctx.Done():
+			return attempt, fmt.Errorf("%w (last attempt: %v)", ctx.Err(), err)
+		}
+	}
+}
+
+// newNonce returns 16 random bytes in hex.
+func newNonce() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generate callback nonce: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// post makes one attempt of delivery, signed at the time it is sent with a new nonce.
+func (s *Sender) post(ctx context.Context, url, event, delivery string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create callback request: %w", err)
+	}
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("User-Agent", s.UserAgent)
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(DeliveryHeader, delivery)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, Sign(s.Secret, now, delivery, nonce, body))
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("deliver callback: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // Lets the connection be reused.
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	return &StatusError{StatusCode: resp.StatusCode, RetryAfter: time.Duration(seconds) * time.Second}
+}
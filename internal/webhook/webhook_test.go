@@ -0,0 +1,188 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// received is one attempt as the test receiver saw it.
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a callback receiver answering each attempt with the next of statuses, the last
+// one over and over, and recording what it received.
+type receiver struct {
+	mu         sync.Mutex
+	statuses   []int
+	retryAfter string
+	got        []received
+}
+
+func (rv *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	rv.got = append(rv.got, received{r.Header.Clone(), body})
+	status := rv.statuses[min(len(rv.got), len(rv.statuses))-1]
+	if rv.retryAfter != "" {
+		w.Header().Set("Retry-After", rv.retryAfter)
+	}
+	w.WriteHeader(status)
+}
+
+// newTestSender returns a sender to a receiver answering statuses, on a clock stopped at now
+// whose waits end at once and are recorded in delays.
+func newTestSender(t *testing.T, rv *receiver, now time.Time) (s *Sender, url string, delays *[]time.Duration) {
+	t.Helper()
+	srv := httptest.NewServer(rv)
+	t.Cleanup(srv.Close)
+	s = NewSender("shh", 0)
+	s.now = func() time.Time { return now }
+	delays = new([]time.Duration)
+	s.after = func(d time.Duration) <-chan time.Time {
+		*delays = append(*delays, d)
+		ch := make(chan time.Time, 1)
+		ch <- now.Add(d)
+		return ch
+	}
+	return s, srv.URL, delays
+}
+
+var testNow = time.Unix(1_700_000_000, 0)
+
+func TestSendSigned(t *testing.T) {
+	rv := &receiver{statuses: []int{http.StatusNoContent}}
+	s, url, _ := newTestSender(t, rv, testNow)
+	attempts, err := s.Send(context.Background(), url, "chat.completed", map[string]string{"answer": "Paris"}, 3)
+	if err != nil || attempts != 1 {
+		t.Fatalf("Send = %d, %v; want 1 attempt, nil", attempts, err)
+	}
+
+	got := rv.got[0]
+	if string(got.body) != `{"answer":"Paris"}` {
+		t.Errorf("body = %s", got.body)
+	}
+	h := got.header
+	if h.Get(EventHeader) != "chat.completed" || h.Get(TimestampHeader) != "1700000000" || len(h.Get(NonceHeader)) != 32 {
+		t.Errorf("headers = %v", h)
+	}
+	if want := Sign("shh", testNow, h.Get(DeliveryHeader), h.Get(NonceHeader), got.body); h.Get(SignatureHeader) != want {
+		t.Errorf("signature = %s, want %s", h.Get(SignatureHeader), want)
+	}
+	if Sign("other", testNow, h.Get(DeliveryHeader), h.Get(NonceHeader), got.body) == h.Get(SignatureHeader) {
+		t.Error("another secret gives the same signature")
+	}
+}
+
+func TestSendRetriesWithBackoff(t *testing.T) {
+	rv := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}}
+	s, url, delays := newTestSender(t, rv, testNow)
+	attempts, err := s.Send(context.Background(), url, "chat.completed", "payload", 5)
+	if err != nil || attempts != 4 {
+		t.Fatalf("Send = %d, %v; want 4 attempts, nil", attempts, err)
+	}
+
+	// Each wait doubles the last, plus up to half again of jitter.
+	if len(*delays) != 3 {
+		t.Fatalf("waits = %v, want 3", *delays)
+	}
+	for i, d := range *delays {
+		base := time.Second << i
+		if d < base || d > base+base/2 {
+			t.Errorf("wait %d = %v, want %v to %v", i+1, d, base, base+base/2)
+		}
+	}
+	// Every attempt is the same delivery, signed afresh with its own nonce.
+	nonces := make(map[string]bool)
+	for i, got := range rv.got {
+		if got.header.Get(DeliveryHeader) != rv.got[0].header.Get(DeliveryHeader) {
+			t.Errorf("attempt %d is delivery %s, want %s", i+1, got.header.Get(DeliveryHeader), rv.got[0].header.Get(DeliveryHeader))
+		}
+		nonce := got.header.Get(NonceHeader)
+		if nonces[nonce] {
+			t.Errorf("attempt %d reuses nonce %s", i+1, nonce)
+		}
+		nonces[nonce] = true
+		if want := Sign("shh", testNow, got.header.Get(DeliveryHeader), nonce, got.body); got.header.Get(SignatureHeader) != want {
+			t.Errorf("attempt %d signature = %s, want %s", i+1, got.header.Get(SignatureHeader), want)
+		}
+	}
+}
+
+func TestSendRetryAfter(t *testing.T) {
+	for _, tt := range []struct {
+		retryAfter string
+		want       time.Duration
+	}{
+		{"30", 30 * time.Second},
+		{"3600", maxRetryAfter}, // Capped
+	} {
+		rv := &receiver{statuses: []int{http.StatusTooManyRequests, http.StatusOK}, retryAfter: tt.retryAfter}
+		s, url, delays := newTestSender(t, rv, testNow)
+		if _, err := s.Send(context.Background(), url, "chat.completed", "payload", 2); err != nil {
+			t.Fatal(err)
+		}
+		if len(*delays) != 1 || (*delays)[0] != tt.want {
+			t.Errorf("Retry-After %s: waits = %v, want [%v]", tt.retryAfter, *delays, tt.want)
+		}
+	}
+}
+
+func TestSendGivesUp(t *testing.T) {
+	rv := &receiver{statuses: []int{http.StatusInternalServerError}}
+	s, url, delays := newTestSender(t, rv, testNow)
+	attempts, err := s.Send(context.Background(), url, "chat.completed", "payload", 3)
+	var status *StatusError
+	if attempts != 3 || !errors.As(err, &status) || status.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Send = %d, %v; want 3 attempts and the 500", attempts, err)
+	}
+	if len(*delays) != 2 {
+		t.Errorf("waits = %v, want one between each attempt", *delays)
+	}
+}
+
+func TestSendRejectedIsNotRetried(t *testing.T) {
+	rv := &receiver{statuses: []int{http.StatusUnauthorized}}
+	s, url, delays := newTestSender(t, rv, testNow)
+	attempts, err := s.Send(context.Background(), url, "chat.completed", "payload", 5)
+	var status *StatusError
+	if attempts != 1 || !errors.As(err, &status) || status.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Send = %d, %v; want 1 attempt and the 401", attempts, err)
+	}
+	if len(*delays) != 0 {
+		t.Errorf("waits = %v, want none", *delays)
+	}
+}
+
+func TestSendCancelledWhileWaiting(t *testing.T) {
+	rv := &receiver{statuses: []int{http.StatusServiceUnavailable}}
+	s, url, _ := newTestSender(t, rv, testNow)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.after = func(time.Duration) <-chan time.Time {
+		cancel()
+		return nil // Never fires
+	}
+	attempts, err := s.Send(ctx, url, "chat.completed", "payload", 5)
+	if attempts != 1 || !errors.Is(err, context.Canceled) {
+		t.Errorf("Send = %d, %v; want 1 attempt and context.Canceled", attempts, err)
+	}
+}
+
+func TestNextDeliveryIncreases(t *testing.T) {
+	s := NewSender("shh", 0)
+	s.now = func() time.Time { return testNow }
+	first, _ := strconv.ParseInt(s.nextDelivery(), 10, 64)
+	second, _ := strconv.ParseInt(s.nextDelivery(), 10, 64)
+	if first != testNow.UnixMicro() || second != first+1 {
+		t.Errorf("deliveries %d, %d; want %d and the next", first, second, testNow.UnixMicro())
+	}
+}
@@ -0,0 +1,226 @@
+package chatclient
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// defaultRetry is how long Chat waits before reconnecting when the server hasn't said.
+const defaultRetry = 3 * time.Second
+
+// ErrCancelled is returned by CollectAnswer for an answer stopped on purpose, e.g. by Cancel.
+var ErrCancelled = errors.New("chatclient: the answer was cancelled")
+
+// Request is a question for Chat and CollectAnswer.
+type Request struct {
+	Message   string `json:"message"`
+	SessionID string `json:"session_id,omitempty"` // Continues a conversation; see the README's sessions
+	Language  string `json:"language,omitempty"`   // "en" or "es"; empty detects it from Message
+	Aggregate *bool  `json:"aggregate,omitempty"`  // False returns the worker answers as they are; nil keeps the server's default
+	Force     bool   `json:"force,omitempty"`      // Answers anew a message the session just had answered, instead of repeating that answer
+
+	IdempotencyKey   string            `json:"idempotency_key,omitempty"`   // Makes retrying the request safe
+	PersonaOverrides map[string]string `json:"persona_overrides,omitempty"` // Per-stage system prompts; needs a developer key
+}
+
+// Chat asks the question in req and returns its events, in order, on a channel that is closed
+// after the last one. The answer streams in as MessageChunks; Done is the last event.
+//
+// If the connection drops before Done, Chat reconnects with Last-Event-ID, announcing it with
+// a Reconnecting event, so the events continue where they stopped. Once it has reconnected as
+// often as WithMaxReconnects allows, the channel ends with an Error of code
+// CodeConnectionLost instead of Done. Cancelling ctx stops reading: the channel is closed and
+// the rest of the events are dropped; the server carries on with the answer.
+//
+// A request the server turns down, e.g. for its rate limit, is an *APIError.
+func (c *Client) Chat(ctx context.Context, req Request) (<-chan Event, error) {
+	body := struct {
+		Request
+		Stream bool `json:"stream"`
+	}{req, true}
+	httpReq, err := c.newRequest(ctx, http.MethodPost, "/api", body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.openStream(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan Event)
+	go c.follow(ctx, resp, events)
+	return events, nil
+}
+
+// CollectAnswer asks the question in req and waits for the whole answer. It returns the
+// answer's text and the telemetry of the Done event (zero if the server doesn't send it). If
+// the server reports a failure, the error is its Error event (an Error), or, for a cancelled
+// answer, ErrCancelled; the text received so far is returned with it.
+func (c *Client) CollectAnswer(ctx context.Context, req Request) (string, Telemetry, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := c.Chat(ctx, req)
+	if err != nil {
+		return "", Telemetry{}, err
+	}
+	var answer strings.Builder
+	var failure error
+	for event := range events {
+		switch e := event.(type) {
+		case MessageChunk:
+			answer.WriteString(e.Text)
+		case Error:
+			if failure == nil {
+				failure = e
+			}
+		case Done:
+			var telemetry Telemetry
+			if e.Telemetry != nil {
+				telemetry = *e.Telemetry
+			}
+			switch {
+			case failure != nil:
+			case e.Outcome == OutcomeCancelled:
+				failure = ErrCancelled
+			case e.Outcome != OutcomeOK:
+				failure = fmt.Errorf("chatclient: the answer failed: %s", e.Error)
+			}
+			return answer.String(), telemetry, failure
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return answer.String(), Telemetry{}, err
+	}
+	return answer.String(), Telemetry{}, failure // The stream broke off with CodeConnectionLost.
+}
+
+// openStream sends a request for an answer's events, in JSON envelopes.
+func (c *Client) openStream(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Accept", "text/event-stream, application/json")
+	return c.do(req, http.StatusOK)
+}
+
+// resume reconnects to the answer whose last event received was lastID. It returns a nil
+// response if the server no longer has the answer.
+func (c *Client) resume(ctx context.Context, lastID string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Last-Event-ID", lastID)
+	resp, err := c.openStream(req)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	return resp, err
+}
+
+// follow sends the events of resp, and of the reconnections that continue it, to events, and
+// closes it when the answer is done, the stream is lost or ctx is cancelled.
+func (c *Client) follow(ctx context.Context, resp *http.Response, events chan<- Event) {
+	defer close(events)
+	send := func(e Event) bool {
+		select {
+		case events <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	var lastID string
+	var lastErr error
+	retry := defaultRetry
+	for attempt := 0; ; attempt++ {
+		if resp != nil {
+			done, id, wait, err := readStream(resp.Body, send)
+			resp.Body.Close()
+			if done {
+				return
+			}
+			if id != "" {
+				lastID = id
+			}
+			if wait > 0 {
+				retry = wait
+			}
+			lastErr = err
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if lastID == "" || attempt == c.maxReconnects {
+			message := "the stream ended before the answer was complete"
+			if lastErr != nil {
+				message = lastErr.Error()
+			}
+			send(Error{Code: CodeConnectionLost, Message: message})
+			return
+		}
+
+		if !send(Reconnecting{Attempt: attempt + 1, Wait: retry}) {
+			return
+		}
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+		var err error
+		if resp, err = c.resume(ctx, lastID); err != nil {
+			lastErr, resp = err, nil
+		} else if resp == nil {
+			send(Error{Code: CodeConnectionLost, Message: "the answer is no longer available"})
+			return
+		}
+	}
+}
+
+// readStream sends the events of one connection until Done or the end of the connection. It
+// returns whether Done arrived (or sending stopped), the last event ID, how long the server
+// asked to wait before reconnecting (0 if it didn't), and why the connection ended, if not
+// cleanly.
+func readStream(body io.Reader, send func(Event) bool) (done bool, lastID string, retry time.Duration, err error) {
+	reader := sse.NewReader(body)
+	var advised time.Duration // By a Reconnect event, which overrides the stream's retry field
+	// The ID of the last event received whole: reader.LastEventID may be that of one the
+	// connection cut off, which resuming from would skip.
+	var received string
+	defer func() {
+		lastID, retry = received, cmp.Or(advised, reader.Retry())
+	}()
+	for {
+		frame, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return false, "", 0, err
+		}
+		received = frame.ID
+		env, err := sse.ParseEnvelope(frame.Data)
+		if err != nil {
+			continue
+		}
+		event, ok, err := decodeEvent(env.Type, env.Data)
+		if !ok || err != nil {
+			continue
+		}
+		if reconnect, ok := event.(Reconnect); ok {
+			advised = reconnect.RetryAfter
+		}
+		if !send(event) {
+			return true, "", 0, nil
+		}
+		if _, ok := event.(Done); ok {
+			return true, "", 0, nil
+		}
+	}
+}
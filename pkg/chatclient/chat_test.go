@@ -0,0 +1,280 @@
+package chatclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/db"
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+	"github.com/Cris245/go-llm-chat/internal/llmclient"
+	"github.com/Cris245/go-llm-chat/internal/orchestrator"
+	"github.com/Cris245/go-llm-chat/internal/sse"
+)
+
+// chatServer serves /api as the server does: a POST starts an orchestration, with mock LLMs
+// over the seeded memory backend, and streams its events; a GET with Last-Event-ID resumes
+// one.
+type chatServer struct {
+	*httptest.Server
+	registry *sse.Registry
+	handler  *sse.Handler
+
+	cut     atomic.Bool  // Whether to drop the next POST's connection at its first Message event
+	forget  atomic.Bool  // Whether GETs find no stream to resume, as after a restart
+	resumed atomic.Value // The last Last-Event-ID a GET resumed from
+}
+
+func newChatServer(t *testing.T, answer string) *chatServer {
+	t.Helper()
+	store := db.NewMemoryClient()
+	if err := store.SeedFlights(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	llm := func(response string) llmclient.LLMClient { return &llmclient.MockClient{Response: response} }
+	orch := orchestrator.NewOrchestrator(llm("FL101 and FL102."), llm("Two hours each."), llm(answer), store)
+	s := &chatServer{registry: sse.NewRegistry(time.Minute), handler: sse.NewHandler()}
+	s.handler.RetryInterval = 10 * time.Millisecond
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			streamID, seq, ok := sse.ParseEventID(r.Header.Get("Last-Event-ID"))
+			stream, found := s.registry.Get(streamID)
+			if !ok || !found || s.forget.Load() {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			s.resumed.Store(r.Header.Get("Last-Event-ID"))
+			s.handler.ServeStream(w, r, stream, seq)
+			return
+		}
+		var req struct {
+			Message  string `json:"message"`
+			Language string `json:"language"`
+			Stream   bool   `json:"stream"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Stream {
+			httpapi.Write(w, r, httpapi.BadRequest(httpapi.CodeMalformedJSON, "want a streamed JSON request"))
+			return
+		}
+		if req.Message == "busy" {
+			httpapi.Write(w, r, &httpapi.Error{Status: http.StatusTooManyRequests, Code: httpapi.CodeRateLimited, Message: "Slow down", RetryAfter: 2 * time.Second})
+			return
+		}
+		stream := s.registry.Create()
+		stream.Publish(sse.Started(stream.ID()))
+		events := make(chan sse.Event)
+		go stream.Pipe(events)
+		go func() {
+			opts := orchestrator.Options{Language: map[string]string{"es": orchestrator.LanguageSpanish, "en": orchestrator.LanguageEnglish}[req.Language]}
+			orch.ProcessMessageStream(context.Background(), req.Message, opts, events)
+			close(events)
+		}()
+		if s.cut.CompareAndSwap(true, false) {
+			w = &cutWriter{ResponseWriter: w}
+		}
+		s.handler.ServeStream(w, r, stream, 0)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// cutWriter fails the write of the first Message event and every write after it, as a
+// dropped connection would.
+type cutWriter struct {
+	http.ResponseWriter
+	cut bool
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	if w.cut = w.cut || strings.Contains(string(p), `"type":"Message"`); w.cut {
+		return 0, errors.New("connection cut")
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *cutWriter) Flush() { w.ResponseWriter.(http.Flusher).Flush() }
+
+func (w *cutWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// chat asks client message and returns the events of the answer.
+func chat(t *testing.T, client *Client, message string) []Event {
+	t.Helper()
+	ch, err := client.Chat(context.Background(), Request{Message: message})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	for e := range ch {
+		events = append(events, e)
+	}
+	return events
+}
+
+// answerText joins the MessageChunks of events.
+func answerText(events []Event) string {
+	var b strings.Builder
+	for _, e := range events {
+		if chunk, ok := e.(MessageChunk); ok {
+			b.WriteString(chunk.Text)
+		}
+	}
+	return b.String()
+}
+
+func TestChat(t *testing.T) {
+	s := newChatServer(t, "FL101 leaves at 09:00 and costs $120.")
+	events := chat(t, New(s.URL), "Show me flights from Madrid to Paris")
+
+	// The events come typed, Started first and Done last.
+	if started, ok := events[0].(Started); !ok || started.StreamID == "" {
+		t.Errorf("first event %#v", events[0])
+	}
+	done, ok := events[len(events)-1].(Done)
+	if !ok || done.Outcome != OutcomeOK || done.Telemetry == nil || done.Telemetry.Intent != "flight" || done.Telemetry.Origin != "Madrid" {
+		t.Fatalf("last event %#v", events[len(events)-1])
+	}
+	var statuses int
+	var understood *QueryUnderstanding
+	var flights []string
+	for _, e := range events {
+		switch e := e.(type) {
+		case Status:
+			statuses++
+		case QueryUnderstanding:
+			understood = &e
+		case FlightResults:
+			for _, f := range e.Flights {
+				flights = append(flights, f.FlightNumber)
+			}
+		}
+	}
+	slices.Sort(flights)
+	if statuses == 0 || understood == nil || understood.Destination != "Paris" || !slices.Equal(flights, []string{"FL101", "FL102", "FL103", "FL104"}) {
+		t.Errorf("%d statuses, understood %+v, flights %v", statuses, understood, flights)
+	}
+	if got := answerText(events); got != "FL101 leaves at 09:00 and costs $120." {
+		t.Errorf("answer %q", got)
+	}
+}
+
+func TestCollectAnswer(t *testing.T) {
+	s := newChatServer(t, "The capital of France is Paris.")
+	client := New(s.URL)
+	answer, telemetry, err := client.CollectAnswer(context.Background(), Request{Message: "What is the capital of France?"})
+	if err != nil || answer != "The capital of France is Paris." || telemetry.Intent != "general" || telemetry.Language != "English" {
+		t.Errorf("CollectAnswer = %q, %+v, %v", answer, telemetry, err)
+	}
+
+	// A request the server turns down is an *APIError with its code.
+	_, _, err = client.CollectAnswer(context.Background(), Request{Message: "busy"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.Code != httpapi.CodeRateLimited ||
+		apiErr.RetryAfter != 2*time.Second || apiErr.Message != "Slow down" {
+		t.Errorf("turned down: %#v", err)
+	}
+}
+
+func TestChatReconnects(t *testing.T) {
+	s := newChatServer(t, "FL101 leaves at 09:00 and costs $120.")
+	s.cut.Store(true)
+	events := chat(t, New(s.URL), "Show me flights from Madrid to Paris")
+
+	// The answer continues after the drop, announced, without an event lost or repeated.
+	var reconnecting []Reconnecting
+	var started int
+	for _, e := range events {
+		switch e := e.(type) {
+		case Reconnecting:
+			reconnecting = append(reconnecting, e)
+		case Started:
+			started++
+		}
+	}
+	if len(reconnecting) != 1 || reconnecting[0].Attempt != 1 || reconnecting[0].Wait != 10*time.Millisecond {
+		t.Errorf("reconnections %+v", reconnecting)
+	}
+	if got := answerText(events); got != "FL101 leaves at 09:00 and costs $120." || started != 1 {
+		t.Errorf("answer %q after %d Started", got, started)
+	}
+	if done, ok := events[len(events)-1].(Done); !ok || done.Outcome != OutcomeOK {
+		t.Errorf("last event %#v", events[len(events)-1])
+	}
+	if id, _ := s.resumed.Load().(string); id == "" {
+		t.Error("resumed without Last-Event-ID")
+	}
+}
+
+func TestChatConnectionLost(t *testing.T) {
+	s := newChatServer(t, "Paris.")
+	s.cut.Store(true)
+
+	// Without reconnections, or with the answer gone from the server, the events end in an
+	// Error instead of Done.
+	events := chat(t, New(s.URL, WithMaxReconnects(0)), "What is the capital of France?")
+	if e, ok := events[len(events)-1].(Error); !ok || e.Code != CodeConnectionLost {
+		t.Errorf("without reconnections, last event %#v", events[len(events)-1])
+	}
+
+	s.cut.Store(true)
+	s.forget.Store(true)
+	client := New(s.URL)
+	answer, _, err := client.CollectAnswer(context.Background(), Request{Message: "What is the capital of France?"})
+	var lost Error
+	if !errors.As(err, &lost) || lost.Code != CodeConnectionLost || answer != "" {
+		t.Errorf("with the answer gone: %q, %v", answer, err)
+	}
+}
+
+func TestChatCancelled(t *testing.T) {
+	s := newChatServer(t, "Paris.")
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := New(s.URL).Chat(ctx, Request{Message: "What is the capital of France?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	cancel()
+	// The channel closes without waiting for the rest of the answer.
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("the channel wasn't closed")
+		}
+	}
+}
+
+func TestDecodeEvent(t *testing.T) {
+	for _, tt := range []struct {
+		typ, data string
+		want      Event
+	}{
+		{"Status", `"Searching flights..."`, Status{Message: "Searching flights..."}},
+		{"QueryUnderstanding", `{"intent":"flight","destination":"Paris","max_price":200,"currency":"EUR","language":"es","confidence":"low"}`,
+			QueryUnderstanding{Intent: "flight", Destination: "Paris", MaxPrice: 200, Currency: "EUR", Language: "es", Confidence: "low"}},
+		{"Message", `{"text":"Hi","final":true}`, MessageChunk{Text: "Hi", Final: true}},
+		{"Error", `{"code":"llm_failed","message":"No answer"}`, Error{Code: "llm_failed", Message: "No answer"}},
+		{"Reconnect", `{"reason":"shutdown","retry_after_ms":1500}`, Reconnect{Reason: "shutdown", RetryAfter: 1500 * time.Millisecond}},
+		{"Done", `{"outcome":"cancelled","duration_ms":12}`, Done{Outcome: OutcomeCancelled, DurationMs: 12}},
+	} {
+		got, ok, err := decodeEvent(tt.typ, json.RawMessage(tt.data))
+		if !ok || err != nil || got != tt.want {
+			t.Errorf("decodeEvent(%s, %s) = %#v, %v, %v", tt.typ, tt.data, got, ok, err)
+		}
+	}
+	// Newer event types are skipped.
+	if _, ok, err := decodeEvent("Forecast", json.RawMessage(`{}`)); ok || err != nil {
+		t.Errorf("unknown type: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,154 @@
+// Package chatclient is a Go client for the chat server's HTTP API, for services that call it
+// without parsing the event stream themselves.
+//
+// Chat sends a question and returns its events, typed (Status, MessageChunk, FlightResults,
+// Error, Done, ...); it reconnects with Last-Event-ID when the connection drops, so no event
+// is lost or repeated. CollectAnswer waits for the whole answer instead:
+//
+//	client := chatclient.New("http://localhost:8080", chatclient.WithAPIKey(key))
+//	answer, telemetry, err := client.CollectAnswer(ctx, chatclient.Request{Message: "Flights from Madrid to Paris"})
+//
+// The session and flight endpoints have methods of their own. Failed calls return an
+// *APIError with the server's error code.
+package chatclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxReconnects is how often Chat reconnects after the connection drops mid-answer,
+// unless WithMaxReconnects says otherwise.
+const DefaultMaxReconnects = 3
+
+// Client calls one server. It is safe for concurrent use.
+type Client struct {
+	baseURL       string
+	apiKey        string
+	http          *http.Client
+	maxReconnects int
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAPIKey sends key as a bearer token with every request.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithHTTPClient makes the requests with hc. Its timeout, if any, bounds whole answers, which
+// stream for as long as the server's pipeline runs; the default client has none.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithMaxReconnects sets how often Chat reconnects after the connection drops mid-answer; 0
+// never does.
+func WithMaxReconnects(n int) Option {
+	return func(c *Client) { c.maxReconnects = n }
+}
+
+// New returns a client of the server at baseURL, e.g. "http://localhost:8080".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{baseURL: strings.TrimRight(baseURL, "/"), http: &http.Client{}, maxReconnects: DefaultMaxReconnects}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// APIError is a request the server turned down, with the error of its JSON body.
+type APIError struct {
+	StatusCode int           // The HTTP status, e.g. 429
+	Code       string        // The server's error code, e.g. "rate_limited"; empty if the body had none
+	Message    string        // What went wrong, for people
+	RequestID  string        // The X-Request-ID to quote when reporting it
+	RetryAfter time.Duration // The Retry-After header, if any
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("chatclient: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	}
+	return fmt.Sprintf("chatclient: %d %s: %s (%s, request %s)", e.StatusCode, http.StatusText(e.StatusCode), e.Message, e.Code, e.RequestID)
+}
+
+// newRequest builds a request for path, with the API key and, if body isn't nil, body as JSON.
+func (c *Client) newRequest(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return req, nil
+}
+
+// do sends req and returns the response if its status is want; any other status is returned
+// as an *APIError, with the body closed.
+func (c *Client) do(req *http.Request, want int) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != want {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// doJSON sends req and decodes its JSON response into out.
+func (c *Client) doJSON(req *http.Request, out any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("chatclient: invalid response from %s: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+// responseError reads the server's error body ({"error": {"code": ..., "message": ...}}) into
+// an *APIError; a body of another shape becomes the message.
+func responseError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	var parsed struct {
+		Error struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &parsed) == nil && parsed.Error.Message != "" {
+		apiErr.Code, apiErr.Message, apiErr.RequestID = parsed.Error.Code, parsed.Error.Message, parsed.Error.RequestID
+	}
+	return apiErr
+}
@@ -0,0 +1,251 @@
+package chatclient
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is one event of an answer: one of Started, Status, QueryUnderstanding, MessageChunk,
+// FlightResults, Routes, Enrichment, Error, Reconnect, Reconnecting or Done. The last event of an answer is
+// Done, unless the stream broke off (see Chat).
+type Event interface {
+	event()
+}
+
+// Started opens an answer. StreamID is the ID to cancel it with or to watch it from elsewhere.
+type Started struct {
+	StreamID string
+}
+
+// Status is progress of the server's pipeline, for display ("Searching flights..."). The
+// server drops some for slow clients.
+type Status struct {
+	Message string
+}
+
+// QueryUnderstanding is what the server read from a flight question, before it searches:
+// the route, the price limit in Currency, the answer's language code and Confidence, "low"
+// when the route's direction was guessed. Fields the question didn't set are empty.
+type QueryUnderstanding struct {
+	Intent             string  `json:"intent"`
+	Origin             string  `json:"origin,omitempty"`
+	Destination        string  `json:"destination,omitempty"`
+	OriginAirport      string  `json:"origin_airport,omitempty"`
+	DestinationAirport string  `json:"destination_airport,omitempty"`
+	MaxPrice           float64 `json:"max_price,omitempty"`
+	Currency           string  `json:"currency"`
+	Passengers         int     `json:"passengers,omitempty"` // Party size, if the question gave one
+	Language           string  `json:"language"`
+	Confidence         string  `json:"confidence"`
+}
+
+// MessageChunk is a part of the answer's text; Final is set on the last one.
+type MessageChunk struct {
+	Text  string
+	Final bool
+}
+
+// FlightResults are the flights the answer is drawn from.
+type FlightResults struct {
+	Flights []Flight
+}
+
+// Routes are the routes that answer a question about where flights go.
+type Routes struct {
+	Routes []Route
+}
+
+// Enrichment is facts from an outside source that go with the answer. Kind names the source
+// (e.g. "weather") and decides the shape of Data; Summary is the facts in one line.
+type Enrichment struct {
+	Kind    string
+	Summary string
+	Data    json.RawMessage
+}
+
+// Error is the server saying the answer failed, with its error code (e.g. "llm_failed"). A
+// stream that breaks off for good ends with an Error of code CodeConnectionLost.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e Error) Error() string {
+	return "chatclient: " + e.Message + " (" + e.Code + ")"
+}
+
+// CodeConnectionLost is the code of the Error that ends a stream Chat couldn't reconnect to.
+const CodeConnectionLost = "connection_lost"
+
+// Reconnect is the server closing the connection on purpose, e.g. because it is shutting
+// down. Chat reconnects after RetryAfter.
+type Reconnect struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+// Reconnecting is Chat about to reconnect after the connection dropped, once Wait is over.
+// It isn't sent by the server.
+type Reconnecting struct {
+	Attempt int // 1 for the first reconnection of the answer
+	Wait    time.Duration
+}
+
+// Done ends every answer.
+type Done struct {
+	Outcome    string // OutcomeOK, OutcomeError or OutcomeCancelled
+	Error      string // Why it failed, for OutcomeError
+	DurationMs int64
+	Telemetry  *Telemetry // Nil if the server doesn't send it
+}
+
+// Outcomes of Done.
+const (
+	OutcomeOK        = "ok"
+	OutcomeError     = "error"
+	OutcomeCancelled = "cancelled"
+)
+
+func (Started) event()            {}
+func (Status) event()             {}
+func (QueryUnderstanding) event() {}
+func (MessageChunk) event()       {}
+func (FlightResults) event()      {}
+func (Routes) event()             {}
+func (Enrichment) event()         {}
+func (Error) event()              {}
+func (Reconnect) event()          {}
+func (Reconnecting) event()       {}
+func (Done) event()               {}
+
+// Flight is a flight, as FlightResults and ListFlights return it. Times are RFC 3339.
+type Flight struct {
+	FlightNumber   string  `json:"flight_number"`
+	Origin         string  `json:"origin"`
+	Destination    string  `json:"destination"`
+	DepartureTime  string  `json:"departure_time"`
+	ArrivalTime    string  `json:"arrival_time"`
+	Price          float64 `json:"price"`
+	AvailableSeats int     `json:"available_seats"`
+
+	OriginAirport      string `json:"origin_airport,omitempty"` // IATA codes, for cities with more than one airport
+	DestinationAirport string `json:"destination_airport,omitempty"`
+
+	// The times in the local time of the airports, RFC 3339 with their offsets, and the time
+	// zones' tz database names; FlightResults carries them where the server knows the zone.
+	DepartureLocal    string `json:"departure_local,omitempty"`
+	DepartureTimeZone string `json:"departure_time_zone,omitempty"`
+	ArrivalLocal      string `json:"arrival_local,omitempty"`
+	ArrivalTimeZone   string `json:"arrival_time_zone,omitempty"`
+	DurationMinutes   int    `json:"duration_minutes,omitempty"`
+
+	// The party size the question searched for and the flight's price for all of it;
+	// FlightResults carries them for parties of more than one. Price is per person.
+	Passengers int     `json:"passengers,omitempty"`
+	TotalPrice float64 `json:"total_price,omitempty"`
+}
+
+// Route is an origin and destination pair the flights serve.
+type Route struct {
+	Origin      string `json:"origin"`
+	Destination string `json:"destination"`
+}
+
+// Telemetry summarizes how the server answered; see the README's Done event.
+type Telemetry struct {
+	RequestID   string  `json:"request_id,omitempty"` // The X-Request-ID, to quote when reporting a problem
+	Intent      string  `json:"intent"`               // "flight", "routes", "compare", "local_time", "preferences", "repeat" or "general"
+	Language    string  `json:"language"`
+	Origin      string  `json:"origin,omitempty"`
+	Destination string  `json:"destination,omitempty"`
+	MaxPrice    float64 `json:"max_price,omitempty"`
+	Currency    string  `json:"currency,omitempty"`
+	Passengers  int     `json:"passengers,omitempty"` // Party size the flights were searched for
+	ResultCount int     `json:"result_count"`
+	DurationMs  int64   `json:"duration_ms"`
+	Version     string  `json:"version"`           // The server's build
+	Replica     string  `json:"replica,omitempty"` // The replica that answered
+
+	OriginAirport      string `json:"origin_airport,omitempty"` // The airports the question named by code
+	DestinationAirport string `json:"destination_airport,omitempty"`
+
+	StagesMs         map[string]int64  `json:"stages_ms,omitempty"` // How long each pipeline stage took
+	Models           map[string]string `json:"models,omitempty"`    // The model of each LLM stage
+	TokensUsed       int               `json:"tokens_used,omitempty"`
+	Truncated        bool              `json:"truncated,omitempty"`
+	LanguageRetry    string            `json:"language_retry,omitempty"`
+	Condensed        map[string]string `json:"condensed,omitempty"`
+	Flags            []string          `json:"flags,omitempty"`
+	PersonaOverrides []string          `json:"persona_overrides,omitempty"`
+	Preferences      []string          `json:"preferences,omitempty"`
+}
+
+// decodeEvent converts the type and JSON data of an event envelope into its Event. Types this
+// client doesn't know are skipped (ok is false), so newer servers can add some.
+func decodeEvent(eventType string, data json.RawMessage) (e Event, ok bool, err error) {
+	switch eventType {
+	case "Started":
+		var p struct {
+			StreamID string `json:"stream_id"`
+		}
+		err = json.Unmarshal(data, &p)
+		e = Started{StreamID: p.StreamID}
+	case "Status":
+		var msg string
+		err = json.Unmarshal(data, &msg)
+		e = Status{Message: msg}
+	case "QueryUnderstanding":
+		var p QueryUnderstanding
+		err = json.Unmarshal(data, &p)
+		e = p
+	case "Message":
+		var p struct {
+			Text  string `json:"text"`
+			Final bool   `json:"final"`
+		}
+		err = json.Unmarshal(data, &p)
+		e = MessageChunk{Text: p.Text, Final: p.Final}
+	case "FlightResults":
+		var flights []Flight
+		err = json.Unmarshal(data, &flights)
+		e = FlightResults{Flights: flights}
+	case "Routes":
+		var routes []Route
+		err = json.Unmarshal(data, &routes)
+		e = Routes{Routes: routes}
+	case "Enrichment":
+		var p struct {
+			Kind    string          `json:"kind"`
+			Summary string          `json:"summary"`
+			Data    json.RawMessage `json:"data"`
+		}
+		err = json.Unmarshal(data, &p)
+		e = Enrichment{Kind: p.Kind, Summary: p.Summary, Data: p.Data}
+	case "Error":
+		var p struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		err = json.Unmarshal(data, &p)
+		e = Error{Code: p.Code, Message: p.Message}
+	case "Reconnect":
+		var p struct {
+			Reason       string `json:"reason"`
+			RetryAfterMs int64  `json:"retry_after_ms"`
+		}
+		err = json.Unmarshal(data, &p)
+		e = Reconnect{Reason: p.Reason, RetryAfter: time.Duration(p.RetryAfterMs) * time.Millisecond}
+	case "Done":
+		var p struct {
+			Outcome    string     `json:"outcome"`
+			Error      string     `json:"error"`
+			DurationMs int64      `json:"duration_ms"`
+			Telemetry  *Telemetry `json:"telemetry"`
+		}
+		err = json.Unmarshal(data, &p)
+		e = Done{Outcome: p.Outcome, Error: p.Error, DurationMs: p.DurationMs, Telemetry: p.Telemetry}
+	default:
+		return nil, false, nil
+	}
+	return e, true, err
+}
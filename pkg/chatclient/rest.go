@@ -0,0 +1,232 @@
+package chatclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Session is one of the caller's conversations, as ListSessions returns it.
+type Session struct {
+	SessionID string    `json:"session_id"`
+	Title     string    `json:"title"`
+	TurnCount int       `json:"turn_count"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ListSessions returns the conversations started with the client's API key, most recently
+// updated first; limit caps how many (0 is the server's default).
+func (c *Client) ListSessions(ctx context.Context, limit int) ([]Session, error) {
+	path := "/api/sessions"
+	if limit > 0 {
+		path += "?limit=" + strconv.Itoa(limit)
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Sessions []Session `json:"sessions"`
+	}
+	if err := c.doJSON(req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Sessions, nil
+}
+
+// RenameSession sets the title of a conversation.
+func (c *Client) RenameSession(ctx context.Context, sessionID, title string) error {
+	req, err := c.newRequest(ctx, http.MethodPatch, "/api/sessions/"+url.PathEscape(sessionID), map[string]string{"title": title})
+	if err != nil {
+		return err
+	}
+	var resp struct{}
+	return c.doJSON(req, &resp)
+}
+
+// ExportSession downloads a conversation in format, "json" or "md" (Markdown).
+func (c *Client) ExportSession(ctx context.Context, sessionID, format string) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/sessions/"+url.PathEscape(sessionID)+"/export?format="+url.QueryEscape(format), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// ShareLink is a read-only link to a conversation, which anyone who has it can open without
+// an API key until ExpiresAt.
+type ShareLink struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url"` // The link's path on the server, "/share/<token>"
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ShareSession creates a read-only link to a conversation, replacing the one it had.
+func (c *Client) ShareSession(ctx context.Context, sessionID string) (ShareLink, error) {
+	var link ShareLink
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/sessions/"+url.PathEscape(sessionID)+"/share", nil)
+	if err != nil {
+		return link, err
+	}
+	resp, err := c.do(req, http.StatusCreated)
+	if err != nil {
+		return link, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&link); err != nil {
+		return link, fmt.Errorf("chatclient: invalid response from %s: %w", req.URL.Path, err)
+	}
+	return link, nil
+}
+
+// UnshareSession removes a conversation's link, which stops working.
+func (c *Client) UnshareSession(ctx context.Context, sessionID string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, "/api/sessions/"+url.PathEscape(sessionID)+"/share", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Preferences are the defaults a session remembers for the questions that leave them out.
+type Preferences struct {
+	SessionID string    `json:"session_id"`
+	HomeCity  string    `json:"home_city,omitempty"`
+	Currency  string    `json:"currency,omitempty"`
+	Language  string    `json:"language,omitempty"`
+	MaxBudget float64   `json:"max_budget,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PreferencesUpdate changes some of a session's preferences: nil fields keep their value, and
+// an empty string or 0 clears one.
+type PreferencesUpdate struct {
+	HomeCity  *string  `json:"home_city,omitempty"`
+	Currency  *string  `json:"currency,omitempty"`
+	Language  *string  `json:"language,omitempty"`
+	MaxBudget *float64 `json:"max_budget,omitempty"`
+}
+
+// GetPreferences returns a session's preferences.
+func (c *Client) GetPreferences(ctx context.Context, sessionID string) (Preferences, error) {
+	var prefs Preferences
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/sessions/"+url.PathEscape(sessionID)+"/preferences", nil)
+	if err != nil {
+		return prefs, err
+	}
+	err = c.doJSON(req, &prefs)
+	return prefs, err
+}
+
+// UpdatePreferences changes a session's preferences and returns them as saved.
+func (c *Client) UpdatePreferences(ctx context.Context, sessionID string, update PreferencesUpdate) (Preferences, error) {
+	var prefs Preferences
+	req, err := c.newRequest(ctx, http.MethodPatch, "/api/sessions/"+url.PathEscape(sessionID)+"/preferences", update)
+	if err != nil {
+		return prefs, err
+	}
+	err = c.doJSON(req, &prefs)
+	return prefs, err
+}
+
+// Cancel stops the answer streaming as streamID (see Started). Its events end with a Done of
+// outcome OutcomeCancelled.
+func (c *Client) Cancel(ctx context.Context, streamID string) error {
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/cancel/"+url.PathEscape(streamID), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// FlightQuery filters ListFlights. Zero fields don't filter.
+type FlightQuery struct {
+	Origin       string
+	Destination  string
+	MinPrice     float64
+	MaxPrice     float64
+	DepartAfter  time.Time
+	DepartBefore time.Time
+	Sort         string // "price", "departure_time" (the default) or "flight_number"; a leading "-" reverses it
+	Limit        int    // 0 is the server's default
+	Offset       int
+
+	OriginAirport      string // IATA code, narrowing Origin to one of its airports
+	DestinationAirport string
+}
+
+// FlightPage is a page of ListFlights: Total counts every flight the query matched.
+type FlightPage struct {
+	Flights []Flight `json:"flights"`
+	Total   int      `json:"total"`
+}
+
+// ListFlights returns the stored flights q matches, straight from the database.
+func (c *Client) ListFlights(ctx context.Context, q FlightQuery) (FlightPage, error) {
+	params := url.Values{}
+	set := func(name, value string) {
+		if value != "" {
+			params.Set(name, value)
+		}
+	}
+	number := func(f float64) string {
+		if f == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	moment := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	count := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+	set("origin", q.Origin)
+	set("destination", q.Destination)
+	set("origin_airport", q.OriginAirport)
+	set("destination_airport", q.DestinationAirport)
+	set("min_price", number(q.MinPrice))
+	set("max_price", number(q.MaxPrice))
+	set("depart_after", moment(q.DepartAfter))
+	set("depart_before", moment(q.DepartBefore))
+	set("sort", q.Sort)
+	set("limit", count(q.Limit))
+	set("offset", count(q.Offset))
+
+	var page FlightPage
+	path := "/api/flights"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return page, err
+	}
+	err = c.doJSON(req, &page)
+	return page, err
+}
@@ -0,0 +1,136 @@
+package chatclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/httpapi"
+)
+
+// recorded is a request a restServer got.
+type recorded struct {
+	method, uri, auth, body string
+}
+
+// restServer answers every request with status and body, recording it.
+func restServer(t *testing.T, status int, body string) (*httptest.Server, *recorded) {
+	t.Helper()
+	var got recorded
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = recorded{method: r.Method, uri: r.URL.RequestURI(), auth: r.Header.Get("Authorization"), body: string(b)}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(s.Close)
+	return s, &got
+}
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	s, got := restServer(t, http.StatusOK, `{"sessions":[{"session_id":"trip-1","title":"Paris in May","turn_count":4}]}`)
+	client := New(s.URL+"/", WithAPIKey("k1"))
+	sessions, err := client.ListSessions(ctx, 10)
+	if err != nil || len(sessions) != 1 || sessions[0].SessionID != "trip-1" || sessions[0].TurnCount != 4 {
+		t.Errorf("ListSessions = %+v, %v", sessions, err)
+	}
+	if *got != (recorded{method: http.MethodGet, uri: "/api/sessions?limit=10", auth: "Bearer k1"}) {
+		t.Errorf("ListSessions sent %+v", *got)
+	}
+
+	s, got = restServer(t, http.StatusOK, `{}`)
+	client = New(s.URL, WithAPIKey("k1"))
+	if err := client.RenameSession(ctx, "trip 1/a", "Lisbon"); err != nil {
+		t.Error(err)
+	}
+	if *got != (recorded{method: http.MethodPatch, uri: "/api/sessions/trip%201%2Fa", auth: "Bearer k1", body: `{"title":"Lisbon"}`}) {
+		t.Errorf("RenameSession sent %+v", *got)
+	}
+
+	s, got = restServer(t, http.StatusOK, "# Paris in May\n")
+	if md, err := New(s.URL).ExportSession(ctx, "trip-1", "md"); err != nil || string(md) != "# Paris in May\n" || got.uri != "/api/sessions/trip-1/export?format=md" || got.auth != "" {
+		t.Errorf("ExportSession = %q, %v, sent %+v", md, err, *got)
+	}
+
+	s, got = restServer(t, http.StatusCreated, `{"token":"tok","url":"/share/tok","expires_at":"2026-05-01T00:00:00Z"}`)
+	link, err := New(s.URL).ShareSession(ctx, "trip-1")
+	if err != nil || link.URL != "/share/tok" || !link.ExpiresAt.Equal(time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)) || got.method != http.MethodPost || got.uri != "/api/sessions/trip-1/share" {
+		t.Errorf("ShareSession = %+v, %v, sent %+v", link, err, *got)
+	}
+	s, got = restServer(t, http.StatusNoContent, "")
+	if err := New(s.URL).UnshareSession(ctx, "trip-1"); err != nil || got.method != http.MethodDelete {
+		t.Errorf("UnshareSession = %v, sent %+v", err, *got)
+	}
+	s, got = restServer(t, http.StatusAccepted, `{}`)
+	if err := New(s.URL).Cancel(ctx, "stream-1"); err != nil || got.method != http.MethodPost || got.uri != "/api/cancel/stream-1" {
+		t.Errorf("Cancel = %v, sent %+v", err, *got)
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	s, got := restServer(t, http.StatusOK, `{"session_id":"trip-1","home_city":"Madrid","currency":"EUR"}`)
+	client := New(s.URL)
+	prefs, err := client.GetPreferences(context.Background(), "trip-1")
+	if err != nil || prefs.HomeCity != "Madrid" || prefs.Currency != "EUR" || got.uri != "/api/sessions/trip-1/preferences" {
+		t.Errorf("GetPreferences = %+v, %v, sent %+v", prefs, err, *got)
+	}
+
+	// Only the fields set are sent; an empty one clears the preference.
+	home, budget := "", 300.0
+	if _, err := client.UpdatePreferences(context.Background(), "trip-1", PreferencesUpdate{HomeCity: &home, MaxBudget: &budget}); err != nil {
+		t.Error(err)
+	}
+	if got.method != http.MethodPatch || got.body != `{"home_city":"","max_budget":300}` {
+		t.Errorf("UpdatePreferences sent %+v", *got)
+	}
+}
+
+func TestListFlights(t *testing.T) {
+	s, got := restServer(t, http.StatusOK, `{"flights":[{"flight_number":"FL103","price":110}],"total":4}`)
+	client := New(s.URL)
+	page, err := client.ListFlights(context.Background(), FlightQuery{
+		Origin: "New York", OriginAirport: "JFK", MaxPrice: 150.5, Sort: "-price", Limit: 1,
+		DepartAfter: time.Date(2025, 8, 10, 10, 0, 0, 0, time.FixedZone("CEST", 2*3600)),
+	})
+	if err != nil || page.Total != 4 || len(page.Flights) != 1 || page.Flights[0].Price != 110 {
+		t.Errorf("ListFlights = %+v, %v", page, err)
+	}
+	// Zero fields are left out; times are sent in UTC.
+	if want := "/api/flights?depart_after=2025-08-10T08%3A00%3A00Z&limit=1&max_price=150.5&origin=New+York&origin_airport=JFK&sort=-price"; got.uri != want {
+		t.Errorf("ListFlights sent %s, want %s", got.uri, want)
+	}
+	if _, err := client.ListFlights(context.Background(), FlightQuery{}); err != nil || got.uri != "/api/flights" {
+		t.Errorf("ListFlights without filters sent %s, %v", got.uri, err)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	s, _ := restServer(t, http.StatusNotFound, `{"error":{"code":"session_not_found","message":"No such session","request_id":"req-9"}}`)
+	_, err := New(s.URL).GetPreferences(context.Background(), "trip-9")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || *apiErr != (APIError{StatusCode: http.StatusNotFound, Code: httpapi.CodeSessionNotFound, Message: "No such session", RequestID: "req-9"}) {
+		t.Errorf("GetPreferences = %#v", err)
+	}
+	if want := "chatclient: 404 Not Found: No such session (session_not_found, request req-9)"; err.Error() != want {
+		t.Errorf("error %q, want %q", err, want)
+	}
+
+	// A body that isn't the server's error JSON is the message.
+	s, _ = restServer(t, http.StatusBadGateway, "upstream down\n")
+	_, err = New(s.URL).ListSessions(context.Background(), 0)
+	if !errors.As(err, &apiErr) || apiErr.Code != "" || apiErr.Message != "upstream down" || err.Error() != "chatclient: 502 Bad Gateway: upstream down" {
+		t.Errorf("ListSessions = %#v", err)
+	}
+
+	// So is a response that isn't JSON at all where JSON was expected.
+	s, _ = restServer(t, http.StatusOK, "<html>")
+	if _, err := New(s.URL).ListSessions(context.Background(), 0); err == nil || errors.As(err, &apiErr) {
+		t.Errorf("invalid JSON: %v", err)
+	}
+}
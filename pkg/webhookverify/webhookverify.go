@@ -0,0 +1,165 @@
+// Package webhookverify checks the callbacks the chat server POSTs to a request's
+// callback_url, for Go services that receive them.
+//
+// A Verifier checks each callback's signature, rejects it if its timestamp is outside the
+// window or its nonce was seen before, so a captured callback can't be replayed, and reports a
+// retried delivery that was already handled:
+//
+//	verifier := webhookverify.New(secret, 0)
+//	delivery, err := verifier.Verify(r.Header, body)
+//	switch {
+//	case errors.Is(err, webhookverify.ErrDuplicate):
+//		w.WriteHeader(http.StatusOK) // Handled already; the server retried
+//	case err != nil:
+//		w.WriteHeader(http.StatusUnauthorized)
+//	default:
+//		// Handle delivery.Event; if that fails, call verifier.Forget(delivery.ID) so the
+//		// server's retry is accepted.
+//	}
+package webhookverify
+
+import (
+	"crypto/hmac"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/webhook"
+)
+
+// DefaultWindow is how far a callback's timestamp may be from the receiver's clock unless New
+// is given another window.
+const DefaultWindow = 5 * time.Minute
+
+// pruneInterval is how often a Verifier forgets the nonces and deliveries it no longer needs.
+const pruneInterval = time.Minute
+
+// Verification failures.
+var (
+	ErrMissingSignature = errors.New("missing webhook signature headers")
+	ErrStaleRequest     = errors.New("webhook timestamp is outside the window")
+	ErrBadSignature     = errors.New("webhook signature does not match")
+	ErrReplayed         = errors.New("webhook nonce was already used")
+	ErrDuplicate        = errors.New("webhook delivery was already handled")
+)
+
+// Delivery identifies a verified callback.
+type Delivery struct {
+	ID        string // The same for every attempt of a delivery
+	Event     string // "job.progress" or "job.completed"
+	Nonce     string // Different for every attempt
+	Timestamp time.Time
+}
+
+// VerifySignature checks that body was signed with secret and that its timestamp is within
+// window of now, without remembering it. Verifier.Verify also rejects replays and duplicates.
+func VerifySignature(secret string, header http.Header, body []byte, now time.Time, window time.Duration) (Delivery, error) {
+	delivery := Delivery{
+		ID:    header.Get(webhook.DeliveryHeader),
+		Event: header.Get(webhook.EventHeader),
+		Nonce: header.Get(webhook.NonceHeader),
+	}
+	timestamp := header.Get(webhook.TimestampHeader)
+	signature := header.Get(webhook.SignatureHeader)
+	if delivery.ID == "" || delivery.Nonce == "" || timestamp == "" || signature == "" {
+		return Delivery{}, ErrMissingSignature
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Delivery{}, ErrMissingSignature
+	}
+	delivery.Timestamp = time.Unix(seconds, 0)
+	if age := now.Sub(delivery.Timestamp); age > window || age < -window {
+		return Delivery{}, ErrStaleRequest
+	}
+	expected := webhook.Sign(secret, delivery.Timestamp, delivery.ID, delivery.Nonce, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return Delivery{}, ErrBadSignature
+	}
+	return delivery, nil
+}
+
+// Verifier verifies callbacks and remembers their nonces and delivery IDs, in memory, for as
+// long as a replay or a retry could arrive. Create it with New; it is safe for concurrent use.
+// Receivers running several instances see only their own callbacks, so a retry that reaches
+// another instance isn't recognized as a duplicate.
+type Verifier struct {
+	secret string
+	window time.Duration
+	now    func() time.Time // Replaced in tests
+
+	mu         sync.Mutex
+	nonces     map[string]time.Time // Until when each nonce is remembered
+	deliveries map[string]time.Time
+	pruneAt    time.Time
+}
+
+// New returns a Verifier of callbacks signed with secret whose timestamps are within window of
+// the receiver's clock (DefaultWindow if 0).
+func New(secret string, window time.Duration) *Verifier {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Verifier{
+		secret:     secret,
+		window:     window,
+		now:        time.Now,
+		nonces:     make(map[string]time.Time),
+		deliveries: make(map[string]time.Time),
+	}
+}
+
+// Verify checks a callback's signature and timestamp, then rejects it with ErrReplayed if its
+// nonce was seen before, or with ErrDuplicate, and the delivery, if another attempt of the
+// same delivery was verified in the last window.
+func (v *Verifier) Verify(header http.Header, body []byte) (Delivery, error) {
+	now := v.now()
+	delivery, err := VerifySignature(v.secret, header, body, now, v.window)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.prune(now)
+	if _, seen := v.nonces[delivery.Nonce]; seen {
+		return Delivery{}, ErrReplayed
+	}
+	// A timestamp older than window is rejected as stale, so the nonce needn't be kept longer.
+	v.nonces[delivery.Nonce] = delivery.Timestamp.Add(v.window)
+	_, seen := v.deliveries[delivery.ID]
+	v.deliveries[delivery.ID] = now.Add(v.window)
+	if seen {
+		return delivery, ErrDuplicate
+	}
+	return delivery, nil
+}
+
+// Forget forgets that the delivery id was verified, so a retry of it is accepted again. A
+// receiver that failed to handle a callback calls it before answering with an error.
+func (v *Verifier) Forget(id string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.deliveries, id)
+}
+
+// prune forgets the nonces and deliveries whose time has passed, at most every pruneInterval.
+// v.mu must be held.
+func (v *Verifier) prune(now time.Time) {
+	if now.Before(v.pruneAt) {
+		return
+	}
+	v.pruneAt = now.Add(pruneInterval)
+	for nonce, until := range v.nonces {
+		if now.After(until) {
+			delete(v.nonces, nonce)
+		}
+	}
+	for id, until := range v.deliveries {
+		if now.After(until) {
+			delete(v.deliveries, id)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package webhookverify
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Cris245/go-llm-chat/internal/webhook"
+)
+
+const secret = "shh"
+
+var testNow = time.Unix(1_700_000_000, 0)
+
+// signed returns the headers of a callback of body signed with secret at timestamp.
+func signed(secret string, timestamp time.Time, delivery, nonce string, body []byte) http.Header {
+	h := make(http.Header)
+	h.Set(webhook.EventHeader, "job.completed")
+	h.Set(webhook.DeliveryHeader, delivery)
+	h.Set(webhook.NonceHeader, nonce)
+	h.Set(webhook.TimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+	h.Set(webhook.SignatureHeader, webhook.Sign(secret, timestamp, delivery, nonce, body))
+	return h
+}
+
+// newTestVerifier returns a verifier on a clock that reads *now.
+func newTestVerifier(now *time.Time) *Verifier {
+	v := New(secret, 0)
+	v.now = func() time.Time { return *now }
+	return v
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"status":"done"}`)
+	valid := signed(secret, testNow, "1", "n1", body)
+	missing := valid.Clone()
+	missing.Del(webhook.NonceHeader)
+	for _, tt := range []struct {
+		name   string
+		secret string
+		header http.Header
+		body   string
+		now    time.Time
+		want   error
+	}{
+		{"valid", secret, valid, string(body), testNow, nil},
+		{"valid, a little old", secret, valid, string(body), testNow.Add(DefaultWindow), nil},
+		{"tampered body", secret, valid, `{"status":"failed"}`, testNow, ErrBadSignature},
+		{"other secret", "other", valid, string(body), testNow, ErrBadSignature},
+		{"stale", secret, valid, string(body), testNow.Add(DefaultWindow + time.Second), ErrStaleRequest},
+		{"from the future", secret, valid, string(body), testNow.Add(-DefaultWindow - time.Second), ErrStaleRequest},
+		{"missing nonce", secret, missing, string(body), testNow, ErrMissingSignature},
+		{"unsigned", secret, http.Header{}, string(body), testNow, ErrMissingSignature},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			delivery, err := VerifySignature(tt.secret, tt.header, []byte(tt.body), tt.now, DefaultWindow)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if err == nil && (delivery.ID != "1" || delivery.Event != "job.completed" || delivery.Nonce != "n1" || !delivery.Timestamp.Equal(testNow)) {
+				t.Errorf("delivery = %+v", delivery)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsReplay(t *testing.T) {
+	now := testNow
+	v := newTestVerifier(&now)
+	body := []byte(`{}`)
+	header := signed(secret, testNow, "1", "n1", body)
+	if _, err := v.Verify(header, body); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := v.Verify(header, body); !errors.Is(err, ErrReplayed) {
+		t.Errorf("replay err = %v, want ErrReplayed", err)
+	}
+	// Once the nonce is forgotten, the replay is stale anyway.
+	now = testNow.Add(DefaultWindow + pruneInterval + time.Second)
+	if _, err := v.Verify(header, body); !errors.Is(err, ErrStaleRequest) {
+		t.Errorf("late replay err = %v, want ErrStaleRequest", err)
+	}
+	if _, err := v.Verify(signed(secret, now, "2", "n2", body), body); err != nil {
+		t.Fatal(err)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, kept := v.nonces["n1"]; kept || len(v.deliveries) != 1 {
+		t.Errorf("nonces %v, deliveries %v; want the first callback's pruned", v.nonces, v.deliveries)
+	}
+}
+
+func TestVerifyDuplicateDelivery(t *testing.T) {
+	now := testNow
+	v := newTestVerifier(&now)
+	body := []byte(`{}`)
+	if _, err := v.Verify(signed(secret, now, "1", "n1", body), body); err != nil {
+		t.Fatal(err)
+	}
+	// A retry of the same delivery, signed afresh.
+	now = now.Add(10 * time.Second)
+	delivery, err := v.Verify(signed(secret, now, "1", "n2", body), body)
+	if !errors.Is(err, ErrDuplicate) || delivery.ID != "1" {
+		t.Errorf("retry = %+v, %v; want the delivery and ErrDuplicate", delivery, err)
+	}
+	// A receiver that failed to handle it forgets it, and the next retry is accepted.
+	v.Forget("1")
+	now = now.Add(10 * time.Second)
+	if _, err := v.Verify(signed(secret, now, "1", "n3", body), body); err != nil {
+		t.Errorf("retry after Forget err = %v, want nil", err)
+	}
+	if _, err := v.Verify(signed(secret, now, "2", "n4", body), body); err != nil {
+		t.Errorf("another delivery err = %v, want nil", err)
+	}
+}
+
+func TestVerifySenderCallbacks(t *testing.T) {
+	v := New(secret, 0)
+	verified := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, err := v.Verify(r.Header, body)
+		verified <- err
+	}))
+	defer srv.Close()
+
+	if _, err := webhook.NewSender(secret, 0).Send(context.Background(), srv.URL, "job.completed", map[string]string{"status": "done"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-verified; err != nil {
+		t.Errorf("the sender's callback didn't verify: %v", err)
+	}
+}